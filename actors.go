@@ -0,0 +1,269 @@
+package handel
+
+import (
+	"sync"
+	"time"
+)
+
+// VerifiedSignature is a new verified signature as seen by an Actor. The
+// multi-signature is a copy: the actor can keep it and use it from any
+// routine.
+type VerifiedSignature struct {
+	// Origin is the id of the node that sent the signature
+	Origin int32
+	// Level is the level at which the signature was received
+	Level byte
+	// MultiSig is the verified multi-signature
+	MultiSig *MultiSignature
+	// Individual is true if the signature is the individual signature of the
+	// origin
+	Individual bool
+}
+
+func newVerifiedSignature(s *IncomingSig) *VerifiedSignature {
+	return &VerifiedSignature{
+		Origin: s.origin,
+		Level:  s.level,
+		MultiSig: &MultiSignature{
+			BitSet:    s.ms.BitSet.Clone(),
+			Signature: s.ms.Signature,
+		},
+		Individual: s.isInd,
+	}
+}
+
+// Actor acts on each new verified signature stored by Handel.
+type Actor interface {
+	OnVerifiedSignature(s *VerifiedSignature)
+}
+
+// ActorFunc morphs a function into an Actor
+type ActorFunc func(s *VerifiedSignature)
+
+// OnVerifiedSignature implements the Actor interface
+func (a ActorFunc) OnVerifiedSignature(s *VerifiedSignature) {
+	a(s)
+}
+
+// namedActor is an actor whose execution time is reported under its name
+type namedActor struct {
+	name string
+	actor
+	// async actors only queue their calls, their execution time is recorded
+	// by the queue
+	async bool
+}
+
+// RegisterActor registers an actor that is called on each new verified
+// signature while Handel's global lock is held, as the built-in actors are:
+// incoming packets and periodic updates wait for it to return. A dispatch
+// taking longer than Config.ActorBudget is logged as a warning. Actors doing
+// non-trivial work should be registered with RegisterAsyncActor instead.
+func (h *Handel) RegisterActor(name string, a Actor) {
+	h.Lock()
+	defer h.Unlock()
+	h.actors = append(h.actors, namedActor{name: name, actor: actorFunc(func(s *IncomingSig) {
+		a.OnVerifiedSignature(newVerifiedSignature(s))
+	})})
+}
+
+// RegisterAsyncActor registers an actor whose calls are queued and executed
+// in order, outside of Handel's global lock. The actor only sees eventually
+// consistent state: by the time it runs, Handel may have stored better
+// signatures, completed levels or even be stopped. The calls still queued when
+// Handel stops are discarded.
+func (h *Handel) RegisterAsyncActor(name string, a Actor) {
+	h.Lock()
+	defer h.Unlock()
+	h.actors = append(h.actors, namedActor{name: name, async: true, actor: actorFunc(func(s *IncomingSig) {
+		h.queue.push(name, a, newVerifiedSignature(s))
+	})})
+}
+
+// dispatch calls all the actors on the verified signature, recording how long
+// each takes and how long the whole dispatch holds the lock, which must be
+// held by the caller.
+func (h *Handel) dispatch(s *IncomingSig) {
+	start := time.Now()
+	for _, a := range h.actors {
+		if a.async {
+			a.OnVerifiedSignature(s)
+			continue
+		}
+		actorStart := time.Now()
+		a.OnVerifiedSignature(s)
+		h.actorStats.called(a.name, time.Since(actorStart))
+	}
+	hold := time.Since(start)
+	if h.actorStats.dispatched(hold, h.c.ActorBudget) {
+		h.log.Warn("actor_budget", h.c.ActorBudget, "dispatch", hold, "level", s.level)
+	}
+}
+
+// asyncCall is a queued call to an async actor
+type asyncCall struct {
+	name  string
+	actor Actor
+	sig   *VerifiedSignature
+}
+
+// actorQueue is an unbounded queue of calls to the async actors, executed by
+// a single routine so an actor sees the signatures in order.
+type actorQueue struct {
+	sync.Mutex
+	pending []asyncCall
+	notify  chan bool
+	stats   *actorStats
+}
+
+func newActorQueue(stats *actorStats) *actorQueue {
+	return &actorQueue{
+		notify: make(chan bool, 1),
+		stats:  stats,
+	}
+}
+
+func (q *actorQueue) push(name string, a Actor, s *VerifiedSignature) {
+	q.Lock()
+	q.pending = append(q.pending, asyncCall{name, a, s})
+	q.Unlock()
+	select {
+	case q.notify <- true:
+	default:
+	}
+}
+
+func (q *actorQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.pending)
+}
+
+// run executes the queued calls until stop is closed
+func (q *actorQueue) run(stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-q.notify:
+		}
+		q.Lock()
+		calls := q.pending
+		q.pending = nil
+		q.Unlock()
+		for _, c := range calls {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			start := time.Now()
+			c.actor.OnVerifiedSignature(c.sig)
+			q.stats.called(c.name, time.Since(start))
+		}
+	}
+}
+
+// actorStats measures the execution time of each actor and how long the
+// global lock is held while dispatching a verified signature.
+type actorStats struct {
+	sync.Mutex
+	calls      map[string]int
+	total      map[string]time.Duration
+	max        map[string]time.Duration
+	dispatches int
+	holdTotal  time.Duration
+	holdMax    time.Duration
+	overBudget int
+	queue      *actorQueue
+	// true once disabled by the observability budget
+	disabled bool
+}
+
+func newActorStats() *actorStats {
+	return &actorStats{
+		calls: make(map[string]int),
+		total: make(map[string]time.Duration),
+		max:   make(map[string]time.Duration),
+	}
+}
+
+func (a *actorStats) called(name string, d time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	if a.disabled {
+		return
+	}
+	a.calls[name]++
+	a.total[name] += d
+	if d > a.max[name] {
+		a.max[name] = d
+	}
+}
+
+// dispatched records the lock hold time of a dispatch and returns true if it
+// exceeds the budget.
+func (a *actorStats) dispatched(hold, budget time.Duration) bool {
+	a.Lock()
+	defer a.Unlock()
+	if budget > 0 && hold > budget {
+		a.overBudget++
+	}
+	if a.disabled {
+		return budget > 0 && hold > budget
+	}
+	a.dispatches++
+	a.holdTotal += hold
+	if hold > a.holdMax {
+		a.holdMax = hold
+	}
+	return budget > 0 && hold > budget
+}
+
+// approxSize implements the collector interface
+func (a *actorStats) approxSize() int {
+	a.Lock()
+	defer a.Unlock()
+	size := 64
+	for name := range a.calls {
+		size += len(name) + 3*24
+	}
+	return size
+}
+
+// disable implements the collector interface. The execution times are not
+// measured anymore, the dispatches over the budget are still counted.
+func (a *actorStats) disable() {
+	a.Lock()
+	defer a.Unlock()
+	a.disabled = true
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Values returns, in milliseconds, the average and maximum execution time of
+// each actor and the average and maximum lock hold time of the dispatches.
+func (a *actorStats) Values() map[string]float64 {
+	a.Lock()
+	defer a.Unlock()
+	values := map[string]float64{
+		"dispatches":  float64(a.dispatches),
+		"lockHold":    0,
+		"lockHoldMax": millis(a.holdMax),
+		"overBudget":  float64(a.overBudget),
+	}
+	if a.dispatches > 0 {
+		values["lockHold"] = millis(a.holdTotal) / float64(a.dispatches)
+	}
+	for name, calls := range a.calls {
+		values[name+"_calls"] = float64(calls)
+		values[name+"_time"] = millis(a.total[name]) / float64(calls)
+		values[name+"_maxTime"] = millis(a.max[name])
+	}
+	if a.queue != nil {
+		values["queued"] = float64(a.queue.len())
+	}
+	return values
+}
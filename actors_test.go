@@ -0,0 +1,141 @@
+package handel
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// warnLogger records the warnings
+type warnLogger struct {
+	sync.Mutex
+	warns []string
+}
+
+func (w *warnLogger) Info(kv ...interface{})  {}
+func (w *warnLogger) Debug(kv ...interface{}) {}
+func (w *warnLogger) Error(kv ...interface{}) {}
+func (w *warnLogger) Warn(kv ...interface{}) {
+	w.Lock()
+	defer w.Unlock()
+	w.warns = append(w.warns, fmt.Sprint(kv...))
+}
+func (w *warnLogger) With(kv ...interface{}) Logger { return w }
+
+func (w *warnLogger) warnings() []string {
+	w.Lock()
+	defer w.Unlock()
+	return append([]string{}, w.warns...)
+}
+
+func fakeHandels(t *testing.T, n int, config *Config) []*Handel {
+	nets := NewTestNetworks(n)
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", &fakePublic{true})
+	}
+	reg := NewArrayRegistry(ids)
+	handels := make([]*Handel, n)
+	for i := range handels {
+		sig, err := new(fakeSecret).Sign(msg, rand.Reader)
+		require.NoError(t, err)
+		handels[i] = NewHandel(nets[i], reg, ids[i], new(fakeCons), msg, sig, config)
+	}
+	return handels
+}
+
+func TestHandelAsyncActor(t *testing.T) {
+	n := 8
+	config := DefaultConfig(n)
+	config.Contributions = n
+	// a generous budget: the actor blocked until the end would exceed any
+	// budget if it held the lock
+	config.ActorBudget = time.Second
+	logger := &warnLogger{}
+	config.Logger = logger
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+
+	// a slow actor that sees every verified signature, blocked until the end
+	called := make(chan *VerifiedSignature, 100)
+	release := make(chan bool)
+	defer close(release)
+	handels[0].RegisterAsyncActor("slow", ActorFunc(func(s *VerifiedSignature) {
+		called <- s
+		<-release
+	}))
+	for _, h := range handels {
+		h.Start()
+	}
+	select {
+	case s := <-called:
+		require.NotNil(t, s.MultiSig.BitSet)
+	case <-time.After(time.Second):
+		t.Fatal("async actor not called")
+	}
+
+	// the actor is blocked but packets are still processed
+	processed := make(chan bool)
+	go func() {
+		handels[0].NewPacket(&Packet{Origin: 1, Level: 1})
+		close(processed)
+	}()
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet blocked by the async actor")
+	}
+	select {
+	case ms := <-handels[0].FinalSignatures():
+		require.Equal(t, n, ms.Cardinality())
+	case <-time.After(time.Second):
+		t.Fatal("aggregation slowed down by the async actor")
+	}
+
+	// the calls to the actor were dispatched but wait behind the blocked
+	// one, without holding the lock
+	values := handels[0].actorStats.Values()
+	require.True(t, values["checkFinalSignature_calls"] > 0)
+	require.True(t, values["slow_calls"] > 1)
+	require.Len(t, called, 0)
+	require.Equal(t, 0.0, values["overBudget"])
+	for _, w := range logger.warnings() {
+		require.NotContains(t, w, "actor_budget")
+	}
+}
+
+func TestHandelActorBudget(t *testing.T) {
+	n := 4
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.ActorBudget = 5 * time.Millisecond
+	logger := &warnLogger{}
+	config.Logger = logger
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+
+	handels[0].RegisterActor("slow", ActorFunc(func(s *VerifiedSignature) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	for _, h := range handels {
+		h.Start()
+	}
+	select {
+	case ms := <-handels[0].FinalSignatures():
+		require.Equal(t, n, ms.Cardinality())
+	case <-time.After(5 * time.Second):
+		t.Fatal("aggregation not finished")
+	}
+
+	values := handels[0].actorStats.Values()
+	require.True(t, values["overBudget"] > 0)
+	require.True(t, values["slow_calls"] > 0)
+	require.True(t, values["slow_maxTime"] >= 10)
+	warnings := logger.warnings()
+	require.NotEmpty(t, warnings)
+	require.Contains(t, warnings[0], "actor_budget")
+}
@@ -0,0 +1,163 @@
+package handel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultAdvisorPercentile is the percentile of the first contribution times
+// of a level a ScheduleAdvisor activates it before by default
+const DefaultAdvisorPercentile = 50
+
+// DefaultAdvisorLead is how long before the percentile of the first
+// contribution times of a level a ScheduleAdvisor activates it by default
+const DefaultAdvisorLead = 10 * time.Millisecond
+
+// ScheduleAdvisor learns the activation schedule of the levels from the
+// results of the previous aggregations: each level is activated a lead before
+// the percentile of the times its first contribution was verified, see
+// LevelResult.FirstContribution. The levels without any record keep their
+// activation time in the schedule of the config. It is thread-safe.
+type ScheduleAdvisor struct {
+	sync.Mutex
+	percentile float64
+	lead       time.Duration
+	// number of results recorded
+	results int
+	// the first contribution times recorded, by level
+	firsts map[int][]time.Duration
+}
+
+// NewScheduleAdvisor returns an advisor activating each level the given lead
+// before the given percentile, in ]0, 100], of the first contribution times
+// of the level.
+func NewScheduleAdvisor(percentile float64, lead time.Duration) *ScheduleAdvisor {
+	if percentile <= 0 || percentile > 100 {
+		panic(fmt.Sprintf("handel: invalid percentile %f", percentile))
+	}
+	return &ScheduleAdvisor{
+		percentile: percentile,
+		lead:       lead,
+		firsts:     make(map[int][]time.Duration),
+	}
+}
+
+// Add records the first contribution times of the levels of the result
+func (a *ScheduleAdvisor) Add(r AggregationResult) {
+	a.Lock()
+	defer a.Unlock()
+	a.results++
+	for _, lvl := range r.Levels {
+		if lvl.FirstContribution > 0 {
+			a.firsts[lvl.Level] = append(a.firsts[lvl.Level], lvl.FirstContribution)
+		}
+	}
+}
+
+// Results returns the number of results recorded, zero while cold
+func (a *ScheduleAdvisor) Results() int {
+	a.Lock()
+	defer a.Unlock()
+	return a.results
+}
+
+// Levels returns the levels with records, in increasing order
+func (a *ScheduleAdvisor) Levels() []int {
+	a.Lock()
+	defer a.Unlock()
+	levels := make([]int, 0, len(a.firsts))
+	for level := range a.firsts {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	return levels
+}
+
+// Activation returns the activation time learned for the level, false if the
+// level has no record.
+func (a *ScheduleAdvisor) Activation(level int) (time.Duration, bool) {
+	a.Lock()
+	defer a.Unlock()
+	return a.activation(level)
+}
+
+func (a *ScheduleAdvisor) activation(level int) (time.Duration, bool) {
+	firsts := a.firsts[level]
+	if len(firsts) == 0 {
+		return 0, false
+	}
+	at := percentile(firsts, a.percentile) - a.lead
+	if at < 0 {
+		at = 0
+	}
+	return at, true
+}
+
+// Schedule returns the schedule of the activation times learned so far, the
+// records added afterwards are not taken into account. The levels without
+// record are activated at their time in the fallback, the schedule of the
+// config, e.g. LinearSchedule.
+func (a *ScheduleAdvisor) Schedule(fallback LevelActivationSchedule) LevelActivationSchedule {
+	a.Lock()
+	defer a.Unlock()
+	learned := make(map[int]time.Duration, len(a.firsts))
+	for level := range a.firsts {
+		learned[level], _ = a.activation(level)
+	}
+	return func(index, level int) time.Duration {
+		if at, ok := learned[level]; ok {
+			return at
+		}
+		return fallback(index, level)
+	}
+}
+
+// advisorRecords is the JSON encoding of the records of a ScheduleAdvisor.
+// The times are in nanoseconds.
+type advisorRecords struct {
+	Results            int                     `json:"results"`
+	FirstContributions map[int][]time.Duration `json:"firstContributions"`
+}
+
+// Save writes the records of the advisor in JSON, for an advisor of a later
+// run to Load.
+func (a *ScheduleAdvisor) Save(w io.Writer) error {
+	a.Lock()
+	defer a.Unlock()
+	return json.NewEncoder(w).Encode(&advisorRecords{
+		Results:            a.results,
+		FirstContributions: a.firsts,
+	})
+}
+
+// Load adds the records written by Save to the ones of the advisor
+func (a *ScheduleAdvisor) Load(r io.Reader) error {
+	var records advisorRecords
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.results += records.Results
+	for level, firsts := range records.FirstContributions {
+		a.firsts[level] = append(a.firsts[level], firsts...)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank percentile p, in ]0, 100], of the
+// samples: the smallest sample greater than or equal to p percents of them.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p * float64(len(sorted)) / 100))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
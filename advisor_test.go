@@ -0,0 +1,250 @@
+package handel
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// timingsResult returns a result whose levels got their first contribution
+// at the given times, in milliseconds, none if zero
+func timingsResult(firsts ...int) AggregationResult {
+	var r AggregationResult
+	for i, ms := range firsts {
+		r.Levels = append(r.Levels, LevelResult{
+			Level:             i + 1,
+			FirstContribution: time.Duration(ms) * time.Millisecond,
+		})
+	}
+	return r
+}
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		ds := make([]time.Duration, len(values))
+		for i, v := range values {
+			ds[i] = time.Duration(v) * time.Millisecond
+		}
+		return ds
+	}
+	samples := ms(70, 10, 100, 40, 20, 90, 30, 60, 80, 50)
+	var tests = []struct {
+		p   float64
+		exp int
+	}{
+		{1, 10},
+		{10, 10},
+		{11, 20},
+		{50, 50},
+		{70, 70},
+		{90, 90},
+		{100, 100},
+	}
+	for _, test := range tests {
+		require.Equal(t, ms(test.exp)[0], percentile(samples, test.p), "percentile %f", test.p)
+	}
+	require.Equal(t, ms(5)[0], percentile(ms(5), 1))
+	// the samples are left as is
+	require.Equal(t, ms(70)[0], samples[0])
+}
+
+func TestScheduleAdvisor(t *testing.T) {
+	require.Panics(t, func() { NewScheduleAdvisor(0, 0) })
+	require.Panics(t, func() { NewScheduleAdvisor(101, 0) })
+
+	period := 100 * time.Millisecond
+	linear := LinearSchedule(period)
+	a := NewScheduleAdvisor(50, 5*time.Millisecond)
+	// cold, the schedule of the config
+	cold := a.Schedule(linear)
+	for i, level := range []int{1, 2, 3, 4} {
+		require.Equal(t, time.Duration(i)*period, cold(i, level))
+	}
+
+	// the level 4 never got any contribution
+	a.Add(timingsResult(2, 30, 60, 0))
+	a.Add(timingsResult(4, 10, 50, 0))
+	a.Add(timingsResult(3, 20, 70, 0))
+	require.Equal(t, 3, a.Results())
+	require.Equal(t, []int{1, 2, 3}, a.Levels())
+	_, ok := a.Activation(4)
+	require.False(t, ok)
+	at, ok := a.Activation(2)
+	require.True(t, ok)
+	require.Equal(t, 15*time.Millisecond, at)
+	// never before the start
+	at, _ = a.Activation(1)
+	require.Equal(t, time.Duration(0), at)
+
+	learned := a.Schedule(linear)
+	require.Equal(t, time.Duration(0), learned(0, 1))
+	require.Equal(t, 15*time.Millisecond, learned(1, 2))
+	require.Equal(t, 55*time.Millisecond, learned(2, 3))
+	require.Equal(t, 3*period, learned(3, 4))
+	// the schedule does not change with the records added afterwards
+	a.Add(timingsResult(1, 1, 1, 1))
+	require.Equal(t, 55*time.Millisecond, learned(2, 3))
+
+	// the records are saved for a later run
+	var buff bytes.Buffer
+	require.NoError(t, a.Save(&buff))
+	loaded := NewScheduleAdvisor(50, 5*time.Millisecond)
+	require.NoError(t, loaded.Load(&buff))
+	require.Equal(t, a.Results(), loaded.Results())
+	for level := 1; level <= 4; level++ {
+		exp, expOk := a.Activation(level)
+		at, ok := loaded.Activation(level)
+		require.Equal(t, expOk, ok)
+		require.Equal(t, exp, at)
+	}
+	require.Error(t, loaded.Load(bytes.NewBufferString("{")))
+}
+
+func TestScheduledTimeout(t *testing.T) {
+	_, handels := FakeSetup(8)
+	schedule := func(_, level int) time.Duration {
+		return time.Duration(4-level) * 20 * time.Millisecond
+	}
+	s := NewScheduledTimeout(handels[0], []int{1, 2, 3}, schedule).(*scheduledTimeout)
+	started := make(chan int, 3)
+	s.newLevel = func(level int) { started <- level }
+	begin := time.Now()
+	go s.Start()
+	for _, exp := range []int{3, 2, 1} {
+		select {
+		case level := <-started:
+			require.Equal(t, exp, level)
+			require.True(t, time.Since(begin) >= schedule(0, level))
+		case <-time.After(time.Second):
+			t.Fatalf("level %d not started", exp)
+		}
+	}
+
+	// stopped before the activation of the levels
+	s = NewScheduledTimeout(handels[0], []int{1, 2}, LinearSchedule(time.Hour)).(*scheduledTimeout)
+	s.newLevel = func(level int) { started <- level }
+	done := make(chan bool)
+	go func() {
+		s.Start()
+		close(done)
+	}()
+	require.Equal(t, 1, <-started)
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the strategy did not stop")
+	}
+}
+
+// activationRecorder records the time since the start of each level
+// activated by the first node, and signals each level recorded on activated
+type activationRecorder struct {
+	sync.Mutex
+	at        map[int]time.Duration
+	activated chan int
+}
+
+func newActivationRecorder() *activationRecorder {
+	return &activationRecorder{
+		at:        make(map[int]time.Duration),
+		activated: make(chan int, 64),
+	}
+}
+
+// newStrategy returns the constructor of the strategies following the
+// schedule, recording the activations of the first node
+func (r *activationRecorder) newStrategy(schedule LevelActivationSchedule) func(*Handel, []int) TimeoutStrategy {
+	return func(h *Handel, levels []int) TimeoutStrategy {
+		s := NewScheduledTimeout(h, levels, schedule).(*scheduledTimeout)
+		if h.id.ID() != 0 {
+			return s
+		}
+		begin := time.Now()
+		s.newLevel = func(level int) {
+			r.Lock()
+			if _, ok := r.at[level]; !ok {
+				r.at[level] = time.Since(begin)
+				r.activated <- level
+			}
+			r.Unlock()
+			h.StartLevel(level)
+		}
+		return s
+	}
+}
+
+// activations returns the activations recorded once the given number of
+// levels are activated
+func (r *activationRecorder) activations(t *testing.T, levels int) map[int]time.Duration {
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < levels; i++ {
+		select {
+		case <-r.activated:
+		case <-timeout:
+			t.Fatal("the levels were not all activated")
+		}
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.at
+}
+
+func TestScheduleAdvisorRepetitions(t *testing.T) {
+	n := 16
+	period := 200 * time.Millisecond
+	advisor := NewScheduleAdvisor(DefaultAdvisorPercentile, DefaultAdvisorLead)
+	// runs an aggregation whose nodes follow the schedule, and returns the
+	// activations of the levels by the first node
+	repetition := func(schedule LevelActivationSchedule) map[int]time.Duration {
+		config := DefaultConfig(n)
+		config.Contributions = n
+		config.Logger = &warnLogger{}
+		rec := newActivationRecorder()
+		config.NewTimeoutStrategy = rec.newStrategy(schedule)
+		handels := fakeHandels(t, n, config)
+		for _, h := range handels {
+			h.Start()
+		}
+		for i, h := range handels {
+			for done := false; !done; {
+				select {
+				case ms := <-h.FinalSignatures():
+					done = ms.Cardinality() == n
+				case <-time.After(5 * time.Second):
+					t.Fatalf("node %d did not get all the contributions", i)
+				}
+			}
+		}
+		at := rec.activations(t, len(handels[0].ids))
+		CloseHandels(handels)
+		for _, h := range handels {
+			advisor.Add(h.Result())
+		}
+		return at
+	}
+
+	// the first repetition runs cold, with the linear schedule of the config
+	linear := LinearSchedule(period)
+	require.Equal(t, 0, advisor.Results())
+	cold := repetition(advisor.Schedule(linear))
+	require.Equal(t, n, advisor.Results())
+	for level := 2; level <= 4; level++ {
+		_, ok := advisor.Activation(level)
+		require.True(t, ok, "level %d", level)
+	}
+
+	// the contributions of the higher levels arrived before their linear
+	// activation with the fast path: the learned schedule activates them
+	// sooner
+	learned := repetition(advisor.Schedule(linear))
+	for level := 2; level <= 4; level++ {
+		require.True(t, cold[level] >= linear(level-1, level), "level %d", level)
+		require.True(t, learned[level] < cold[level], "level %d: %s, cold %s", level, learned[level], cold[level])
+		at, _ := advisor.Activation(level)
+		require.True(t, at < linear(level-1, level), "level %d: %s", level, at)
+	}
+}
@@ -0,0 +1,79 @@
+package handel
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// packetAuthTag separates the messages signed to authenticate the packets
+// from the messages aggregated, since the nodes sign both with the same key
+var packetAuthTag = []byte("handel-packet")
+
+// errNoSecret is the error of a config authenticating the packets without the
+// secret key to sign them
+var errNoSecret = errors.New("handel: authenticating the packets requires the secret key, see NewHandelWithSecret")
+
+// errUnsignedPacket is the error of the packets received without a signature
+// while Config.AuthenticatePackets is set
+var errUnsignedPacket = errors.New("packet not signed")
+
+// packetAuthMessage returns the message signed by the origin of the packet:
+// all its fields but the Signature, after packetAuthTag. The byte slices are
+// length-prefixed so no bytes can move from one field to the next.
+func packetAuthMessage(p *Packet) []byte {
+	fields := [][]byte{p.MultiSig, p.IndividualSig, p.PreviousKeys}
+	size := len(packetAuthTag) + 12
+	for _, f := range fields {
+		size += 4 + len(f)
+	}
+	msg := make([]byte, size)
+	n := copy(msg, packetAuthTag)
+	binary.BigEndian.PutUint32(msg[n:], uint32(p.Origin))
+	msg[n+4] = p.Level
+	msg[n+5] = p.Flags
+	binary.BigEndian.PutUint16(msg[n+6:], p.Progress)
+	binary.BigEndian.PutUint32(msg[n+8:], p.Aggregation)
+	n += 12
+	for _, f := range fields {
+		binary.BigEndian.PutUint32(msg[n:], uint32(len(f)))
+		n += 4
+		n += copy(msg[n:], f)
+	}
+	return msg
+}
+
+// signPacket sets the signature of the packet with Config.AuthenticatePackets,
+// and leaves it unsigned otherwise. No field of the packet must change
+// afterwards.
+func (h *Handel) signPacket(p *Packet) error {
+	if !h.c.AuthenticatePackets {
+		return nil
+	}
+	sig, err := h.secret.Sign(packetAuthMessage(p), h.c.Rand)
+	if err != nil {
+		return err
+	}
+	p.Signature, err = sig.MarshalBinary()
+	return err
+}
+
+// authenticatePacket verifies the signature of the packet against the public
+// key of its origin with Config.AuthenticatePackets, and accepts any packet
+// otherwise. It does not need the lock.
+func (h *Handel) authenticatePacket(p *Packet) error {
+	if !h.c.AuthenticatePackets {
+		return nil
+	}
+	if len(p.Signature) == 0 {
+		return errUnsignedPacket
+	}
+	id, ok := h.reg.Identity(int(p.Origin))
+	if !ok {
+		return errOriginRange
+	}
+	sig := h.cons.Signature()
+	if err := sig.UnmarshalBinary(p.Signature); err != nil {
+		return err
+	}
+	return id.PublicKey().VerifySignature(packetAuthMessage(p), sig)
+}
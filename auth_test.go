@@ -0,0 +1,227 @@
+package handel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signedPacket returns the packet signed with the given fake signature
+func signedPacket(t *testing.T, p *Packet, valid bool) *Packet {
+	buff, err := (&fakeSig{valid}).MarshalBinary()
+	require.NoError(t, err)
+	p.Signature = buff
+	return p
+}
+
+func TestHandelAuthenticatePackets(t *testing.T) {
+	n := 16
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.AuthenticatePackets = true
+	config.BroadcastCompletion = true
+	config.EnableNegotiation = true
+	config.Logger = &warnLogger{}
+	nets := NewTestNetworks(n)
+	reg := FakeRegistry(n)
+	handels := make([]*Handel, n)
+	for i := range handels {
+		id, _ := reg.Identity(i)
+		handels[i] = NewHandelWithSecret(nets[i], reg, id, new(fakeCons), msg, &fakeSig{true}, new(fakeSecret), config)
+	}
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	// the packets signed by the nodes are accepted
+	for i, h := range handels {
+		for done := false; !done; {
+			select {
+			case ms := <-h.FinalSignatures():
+				done = ms.Cardinality() == n
+			case <-time.After(5 * time.Second):
+				t.Fatalf("node %d did not get all the contributions", i)
+			}
+		}
+		require.Equal(t, 0.0, h.observabilityValues()["unauthenticated"])
+	}
+
+	// the signature must be the one of the origin: the key of the node 2
+	// verifies no signature
+	ids := make([]Identity, 4)
+	for i := range ids {
+		ids[i] = &fakeIdentity{int32(i), &fakePublic{i != 2}}
+	}
+	h := NewHandelWithSecret(new(TestNetwork), NewArrayRegistry(ids), ids[0], new(fakeCons), msg, &fakeSig{true}, new(fakeSecret), &Config{AuthenticatePackets: true})
+	spoofed := []*Packet{
+		aggregatePacket(t, 1, 1, 1, 0),
+		signedPacket(t, aggregatePacket(t, 1, 1, 1, 0), false),
+		signedPacket(t, aggregatePacket(t, 2, 2, 2, 0), true),
+		// garbage spoofed from the node 3 is not parsed
+		{Origin: 3, Level: 2, MultiSig: []byte{0xff}},
+	}
+	for _, p := range spoofed {
+		h.NewPacket(p)
+	}
+	require.Equal(t, float64(len(spoofed)), h.observabilityValues()["unauthenticated"])
+	h.NewPacket(signedPacket(t, aggregatePacket(t, 3, 2, 2, 1), true))
+	require.Equal(t, float64(len(spoofed)), h.observabilityValues()["unauthenticated"])
+
+	// without the option, the unsigned packets are accepted
+	h = NewHandel(new(TestNetwork), NewArrayRegistry(ids), ids[0], new(fakeCons), msg, &fakeSig{true})
+	for _, p := range spoofed {
+		h.NewPacket(p)
+	}
+	require.Equal(t, 0.0, h.observabilityValues()["unauthenticated"])
+
+	// the option requires the secret key
+	require.Panics(t, func() {
+		NewHandel(new(TestNetwork), NewArrayRegistry(ids), ids[0], new(fakeCons), msg, &fakeSig{true}, &Config{AuthenticatePackets: true})
+	})
+	s := NewSession(new(TestNetwork), NewArrayRegistry(ids), ids[0], new(fakeCons))
+	defer s.Close()
+	_, err := s.NewAggregation(msg, &fakeSig{true}, &Config{AuthenticatePackets: true})
+	require.Error(t, err)
+}
+
+// digestSig is a fake signature binding the message signed: it holds the
+// digest of the message, after the encoding of a fakeSig, which it reads too
+type digestSig struct {
+	fakeSig
+	digest []byte
+}
+
+func (d *digestSig) MarshalBinary() ([]byte, error) {
+	buff, err := d.fakeSig.MarshalBinary()
+	return append(buff, d.digest...), err
+}
+
+func (d *digestSig) UnmarshalBinary(buff []byte) error {
+	if err := d.fakeSig.UnmarshalBinary(buff); err != nil {
+		return err
+	}
+	d.digest = buff[1:]
+	return nil
+}
+
+func (d *digestSig) Combine(Signature) Signature { return d }
+
+// digestPublic verifies the digest of the digestSigs holding one
+type digestPublic struct{}
+
+func (d *digestPublic) String() string { return "digest-public" }
+func (d *digestPublic) VerifySignature(msg []byte, s Signature) error {
+	sig := s.(*digestSig)
+	digest := sha256.Sum256(msg)
+	if len(sig.digest) != 0 && !bytes.Equal(sig.digest, digest[:]) {
+		return errors.New("wrong digest")
+	}
+	if !sig.verify {
+		return errors.New("wrong")
+	}
+	return nil
+}
+func (d *digestPublic) Combine(PublicKey) PublicKey { return d }
+
+type digestCons struct{}
+
+func (d *digestCons) Signature() Signature { return new(digestSig) }
+func (d *digestCons) PublicKey() PublicKey { return new(digestPublic) }
+
+func TestHandelAuthenticateAllFields(t *testing.T) {
+	ids := make([]Identity, 4)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", new(digestPublic))
+	}
+	h := NewHandelWithSecret(new(TestNetwork), NewArrayRegistry(ids), ids[0], new(digestCons), msg, &digestSig{fakeSig: fakeSig{true}}, new(fakeSecret), &Config{AuthenticatePackets: true})
+	sign := func(p *Packet) *Packet {
+		digest := sha256.Sum256(packetAuthMessage(p))
+		buff, err := (&digestSig{fakeSig{true}, digest[:]}).MarshalBinary()
+		require.NoError(t, err)
+		p.Signature = buff
+		return p
+	}
+	signed := func() *Packet {
+		p := aggregatePacket(t, 2, 2, 2, 0)
+		p.IndividualSig = []byte{1}
+		p.Progress = 3
+		p.PreviousKeys = []byte{0}
+		return sign(p)
+	}
+
+	h.NewPacket(signed())
+	require.Equal(t, 0.0, h.observabilityValues()["unauthenticated"])
+
+	tampers := map[string]func(p *Packet){
+		"origin":        func(p *Packet) { p.Origin = 3 },
+		"level":         func(p *Packet) { p.Level = 1 },
+		"multisig":      func(p *Packet) { p.MultiSig = append([]byte{}, p.MultiSig[:len(p.MultiSig)-1]...) },
+		"individualSig": func(p *Packet) { p.IndividualSig = []byte{0} },
+		"flags":         func(p *Packet) { p.Flags = FlagDigest },
+		"progress":      func(p *Packet) { p.Progress = 4 },
+		"previousKeys":  func(p *Packet) { p.PreviousKeys = []byte{0xff} },
+		"aggregation":   func(p *Packet) { p.Aggregation++ },
+		// the bytes moved from a field to the next
+		"boundary": func(p *Packet) {
+			p.IndividualSig = append([]byte{p.MultiSig[len(p.MultiSig)-1]}, p.IndividualSig...)
+			p.MultiSig = p.MultiSig[:len(p.MultiSig)-1]
+		},
+	}
+	for name, tamper := range tampers {
+		before := h.observabilityValues()["unauthenticated"]
+		p := signed()
+		tamper(p)
+		h.NewPacket(p)
+		require.Equal(t, before+1, h.observabilityValues()["unauthenticated"], name)
+	}
+}
+
+// countingPublic counts the signatures it verifies
+type countingPublic struct {
+	fakePublic
+	verified *int
+}
+
+func (c *countingPublic) VerifySignature(b []byte, s Signature) error {
+	*c.verified++
+	return c.fakePublic.VerifySignature(b, s)
+}
+
+func (c *countingPublic) Combine(PublicKey) PublicKey { return c }
+
+func TestHandelAuthenticateLast(t *testing.T) {
+	n := 16
+	verified := 0
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", &countingPublic{fakePublic{true}, &verified})
+	}
+	h := NewHandelWithSecret(new(TestNetwork), NewArrayRegistry(ids), ids[1], new(fakeCons), msg, &fakeSig{true}, new(fakeSecret), &Config{AuthenticatePackets: true})
+	defer h.Close()
+
+	// the packets failing the cheap checks cost no verification
+	rejected := []*Packet{
+		// out of range origin
+		signedPacket(t, aggregatePacket(t, int32(n), 1, 1), true),
+		// no such level
+		signedPacket(t, aggregatePacket(t, 0, 9, 1), true),
+		// unknown flags
+		signedPacket(t, &Packet{Origin: 0, Level: 1, Flags: 0x80}, true),
+	}
+	for _, p := range rejected {
+		h.NewPacket(p)
+	}
+	require.Zero(t, verified)
+	require.Zero(t, h.observabilityValues()["unauthenticated"])
+	h.NewPacket(signedPacket(t, aggregatePacket(t, 0, 1, 1), true))
+	require.Equal(t, 1, verified)
+
+	// nor the packets received once stopped
+	h.Stop()
+	h.NewPacket(signedPacket(t, aggregatePacket(t, 0, 1, 1), true))
+	require.Equal(t, 1, verified)
+}
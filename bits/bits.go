@@ -0,0 +1,43 @@
+// Package bits holds the integer math used by Handel to size its levels. All
+// functions are computed on integers, without any floating point rounding, and
+// are defined for sizes lower than 1 as for a size of 1.
+package bits
+
+import "math/bits"
+
+// Log2Ceil returns the smallest k such that 2^k >= n, i.e. the number of bits
+// needed to index n identities. It returns 0 for n <= 1 and k for n = 2^k.
+func Log2Ceil(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// Log2Floor returns the largest k such that 2^k <= n. It returns 0 for n <= 1
+// and k for n = 2^k.
+func Log2Floor(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n)) - 1
+}
+
+// IsPow2 returns true if n is a power of two. 1 is a power of two, 0 and the
+// negative numbers are not.
+func IsPow2(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Pow2 returns 2^k, for k >= 0
+func Pow2(k int) int {
+	return 1 << uint(k)
+}
+
+// LevelsForSize returns the number of levels of the binomial partitioning of
+// n identities: the levels go from 1 to LevelsForSize(n) included, and the
+// last level holds the other half of the smallest power of two greater or
+// equal to n. A single identity has no level.
+func LevelsForSize(n int) int {
+	return Log2Ceil(n)
+}
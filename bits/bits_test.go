@@ -0,0 +1,48 @@
+package bits
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog2(t *testing.T) {
+	var tests = []struct {
+		n      int
+		ceil   int
+		floor  int
+		isPow2 bool
+	}{
+		{-1, 0, 0, false},
+		{0, 0, 0, false},
+		{1, 0, 0, true},
+		{2, 1, 1, true},
+		{3, 2, 1, false},
+		{4, 2, 2, true},
+		{5, 3, 2, false},
+		{1023, 10, 9, false},
+		{1024, 10, 10, true},
+		{1025, 11, 10, false},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		require.Equal(t, test.ceil, Log2Ceil(test.n))
+		require.Equal(t, test.floor, Log2Floor(test.n))
+		require.Equal(t, test.isPow2, IsPow2(test.n))
+	}
+}
+
+func TestLog2Exhaustive(t *testing.T) {
+	for n := 1; n <= 5000; n++ {
+		ceil, floor := Log2Ceil(n), Log2Floor(n)
+		// the bounds hold around n
+		require.True(t, Pow2(ceil) >= n, "n=%d", n)
+		require.True(t, ceil == 0 || Pow2(ceil-1) < n, "n=%d", n)
+		require.True(t, Pow2(floor) <= n && Pow2(floor+1) > n, "n=%d", n)
+		// both are equal exactly on the powers of two
+		require.Equal(t, IsPow2(n), ceil == floor, "n=%d", n)
+		require.Equal(t, int(math.Ceil(math.Log2(float64(n)))), ceil, "n=%d", n)
+		require.Equal(t, ceil, LevelsForSize(n))
+	}
+}
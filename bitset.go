@@ -3,12 +3,14 @@ package handel
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
+	"sync"
 
 	"github.com/willf/bitset"
 )
 
-// BitSet interface. Available implementation is a wrapper around wilff's bitset
-// library.
+// BitSet interface. Available implementations are a wrapper around wilff's
+// bitset library, and RawBitSet.
 type BitSet interface {
 	// BitLength returns the fixed size of this BitSet
 	BitLength() int
@@ -49,6 +51,17 @@ type BitSet interface {
 	NextSet(i int) (int, bool)
 	// IntersectionCardinality computes the cardinality of the differnce
 	IntersectionCardinality(b2 BitSet) int
+	// OrCardinality returns the cardinality of the union of this bitset and
+	// the other one, without allocating it.
+	OrCardinality(b2 BitSet) int
+	// AndCardinality returns the cardinality of the intersection of this
+	// bitset and the other one, without allocating it.
+	AndCardinality(b2 BitSet) int
+	// DifferenceCardinality returns the number of bits set in this bitset and
+	// not in the other one, without allocating the difference.
+	DifferenceCardinality(b2 BitSet) int
+	// AnyIntersect returns true if at least one bit is set in both bitsets
+	AnyIntersect(b2 BitSet) bool
 	// Clone this BitSet
 	Clone() BitSet
 }
@@ -164,16 +177,38 @@ func (w *WilffBitSet) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary implements the go Marshaler interface. It decodes the length
 // first and then the bitset.
 func (w *WilffBitSet) UnmarshalBinary(buff []byte) error {
-	var b = bytes.NewBuffer(buff)
-	var length uint16
-	err := binary.Read(b, binary.BigEndian, &length)
-	if err != nil {
-		return err
+	switch len(buff) {
+	case 0:
+		return io.EOF
+	case 1:
+		return io.ErrUnexpectedEOF
+	}
+	w.l = int(binary.BigEndian.Uint16(buff))
+	if w.unmarshalInPlace(buff[2:]) {
+		return nil
 	}
-
 	w.b = new(bitset.BitSet)
-	w.l = int(length)
-	return w.b.UnmarshalBinary(b.Bytes())
+	return w.b.UnmarshalBinary(buff[2:])
+}
+
+// unmarshalInPlace decodes the words of the bitset marshalled by the wilff's
+// library into the words of w, without allocating, if w already has the
+// length of the marshalled bitset, as the bitsets of a BitSetPool do. It
+// returns false if it can't.
+func (w *WilffBitSet) unmarshalInPlace(buff []byte) bool {
+	if w.b == nil || len(buff) < 8 {
+		return false
+	}
+	length := binary.BigEndian.Uint64(buff)
+	words := w.b.Bytes()
+	if length != uint64(w.b.Len()) || uint64(len(words)) != (length+63)/64 ||
+		len(buff)-8 < 8*len(words) {
+		return false
+	}
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(buff[8+8*i:])
+	}
+	return true
 }
 
 func (w *WilffBitSet) String() string {
@@ -205,3 +240,76 @@ func (w *WilffBitSet) NextSet(i int) (int, bool) {
 func (w *WilffBitSet) IntersectionCardinality(b2 BitSet) int {
 	return int(w.b.IntersectionCardinality(b2.(*WilffBitSet).b))
 }
+
+// OrCardinality implements the BitSet interface
+func (w *WilffBitSet) OrCardinality(b2 BitSet) int {
+	return int(w.b.UnionCardinality(b2.(*WilffBitSet).b))
+}
+
+// AndCardinality implements the BitSet interface
+func (w *WilffBitSet) AndCardinality(b2 BitSet) int {
+	return int(w.b.IntersectionCardinality(b2.(*WilffBitSet).b))
+}
+
+// DifferenceCardinality implements the BitSet interface
+func (w *WilffBitSet) DifferenceCardinality(b2 BitSet) int {
+	return int(w.b.DifferenceCardinality(b2.(*WilffBitSet).b))
+}
+
+// AnyIntersect implements the BitSet interface. It stops at the first common
+// word instead of counting the whole intersection.
+func (w *WilffBitSet) AnyIntersect(b2 BitSet) bool {
+	words, words2 := w.b.Bytes(), b2.(*WilffBitSet).b.Bytes()
+	if len(words2) < len(words) {
+		words = words[:len(words2)]
+	}
+	for i, word := range words {
+		if word&words2[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BitSetPool is an allocator of Wilff bitsets that recycles the bitsets
+// released with Put, one pool per bit length. Its New method can be used as
+// Config.NewBitSet. It is safe for concurrent use.
+type BitSetPool struct {
+	sync.Mutex
+	pools map[int]*sync.Pool
+}
+
+// NewBitSetPool returns an empty pool of bitsets
+func NewBitSetPool() *BitSetPool {
+	return &BitSetPool{pools: make(map[int]*sync.Pool)}
+}
+
+func (p *BitSetPool) pool(length int) *sync.Pool {
+	p.Lock()
+	defer p.Unlock()
+	pool, exists := p.pools[length]
+	if !exists {
+		pool = new(sync.Pool)
+		p.pools[length] = pool
+	}
+	return pool
+}
+
+// New returns an empty bitset of the given length, recycled if possible.
+func (p *BitSetPool) New(length int) BitSet {
+	if w, ok := p.pool(length).Get().(*WilffBitSet); ok {
+		w.b.ClearAll()
+		return w
+	}
+	return NewWilffBitset(length)
+}
+
+// Put releases the bitset to the pool: the caller must not use it anymore.
+// Put is a no-op on a nil pool and on bitsets that are not WilffBitSet.
+func (p *BitSetPool) Put(bs BitSet) {
+	w, ok := bs.(*WilffBitSet)
+	if p == nil || !ok || w.b.Len() != uint(w.l) {
+		return
+	}
+	p.pool(w.l).Put(w)
+}
@@ -1,13 +1,24 @@
 package handel
 
 import (
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 var nb = NewWilffBitset
 
+// bitsetImpls are the BitSet implementations run against the same tests
+var bitsetImpls = []struct {
+	name string
+	nb   func(int) BitSet
+}{
+	{"wilff", NewWilffBitset},
+	{"raw", NewRawBitset},
+}
+
 type bitsetTest struct {
 	fb          func() BitSet
 	bitlength   int
@@ -16,7 +27,15 @@ type bitsetTest struct {
 }
 
 func TestBitSetWilff(t *testing.T) {
-	var tests = []bitsetTest{
+	testBitSets(t, newBitsetTests(NewWilffBitset))
+}
+
+func TestBitSetRaw(t *testing.T) {
+	testBitSets(t, newBitsetTests(NewRawBitset))
+}
+
+func newBitsetTests(nb func(int) BitSet) []bitsetTest {
+	return []bitsetTest{
 		{func() BitSet { return nb(10) }, 10, 0, []int{}},
 		{
 			func() BitSet {
@@ -34,7 +53,6 @@ func TestBitSetWilff(t *testing.T) {
 			}, 10, 1, []int{3},
 		},
 	}
-	testBitSets(t, tests)
 }
 
 func testBitSets(t *testing.T, tests []bitsetTest) {
@@ -62,3 +80,183 @@ func TestBitSetWilffMarshalling(t *testing.T) {
 
 	require.Equal(t, b.l, b2.l)
 }
+
+func TestBitSetPool(t *testing.T) {
+	pool := NewBitSetPool()
+	b := pool.New(10)
+	b.Set(3, true)
+	pool.Put(b)
+	for i := 0; i < 10; i++ {
+		// recycled or not, a bitset is always empty and of the right length
+		b2 := pool.New(10)
+		require.Equal(t, 10, b2.BitLength())
+		require.Equal(t, 0, b2.Cardinality())
+		b3 := pool.New(20)
+		require.Equal(t, 20, b3.BitLength())
+		pool.Put(b2)
+		pool.Put(b3)
+	}
+	// a nil pool ignores the released bitsets
+	var nilPool *BitSetPool
+	nilPool.Put(b)
+}
+
+func TestBitSetCardinalities(t *testing.T) {
+	for _, impl := range bitsetImpls {
+		t.Run(impl.name, func(t *testing.T) {
+			testBitSetCardinalities(t, impl.nb)
+		})
+	}
+}
+
+func testBitSetCardinalities(t *testing.T, nb func(int) BitSet) {
+	r := rand.New(rand.NewSource(42))
+	for i, n := range []int{1, 7, 64, 65, 130, 1000} {
+		t.Logf(" -- test %d -- ", i)
+		for j := 0; j < 20; j++ {
+			b1, b2 := nb(n), nb(n)
+			for k := 0; k < n; k++ {
+				b1.Set(k, r.Intn(3) == 0)
+				b2.Set(k, r.Intn(3) == 0)
+			}
+			require.Equal(t, b1.Or(b2).Cardinality(), b1.OrCardinality(b2))
+			require.Equal(t, b1.And(b2).Cardinality(), b1.AndCardinality(b2))
+			require.Equal(t, b1.Xor(b2).And(b1).Cardinality(), b1.DifferenceCardinality(b2))
+			require.Equal(t, b1.And(b2).Any(), b1.AnyIntersect(b2))
+		}
+	}
+}
+
+// randomBitSets returns a Wilff and a raw bitset with the same random bits set
+func randomBitSets(r *rand.Rand, n int) (BitSet, BitSet) {
+	w, raw := NewWilffBitset(n), NewRawBitset(n)
+	for i := 0; i < n; i++ {
+		if r.Intn(3) == 0 {
+			w.Set(i, true)
+			raw.Set(i, true)
+		}
+	}
+	return w, raw
+}
+
+func TestBitSetRawOperations(t *testing.T) {
+	// the raw bitsets behave as the Wilff ones, with raw or Wilff operands
+	r := rand.New(rand.NewSource(42))
+	for _, n := range []int{1, 7, 8, 9, 64, 65, 130, 1000} {
+		for j := 0; j < 20; j++ {
+			w1, r1 := randomBitSets(r, n)
+			w2, r2 := randomBitSets(r, n)
+			for _, other := range []BitSet{r2, w2} {
+				require.Equal(t, w1.Or(w2).String(), r1.Or(other).String())
+				require.Equal(t, w1.And(w2).String(), r1.And(other).String())
+				require.Equal(t, w1.Xor(w2).String(), r1.Xor(other).String())
+				require.Equal(t, w1.IsSuperSet(w2), r1.IsSuperSet(other))
+				require.Equal(t, w1.IntersectionCardinality(w2), r1.IntersectionCardinality(other))
+				require.Equal(t, w1.DifferenceCardinality(w2), r1.DifferenceCardinality(other))
+				require.Equal(t, w1.AnyIntersect(w2), r1.AnyIntersect(other))
+			}
+			require.True(t, r1.Or(r2).IsSuperSet(r1))
+			require.Equal(t, w1.String(), r1.String())
+			require.Equal(t, w1.Cardinality(), r1.Cardinality())
+			require.Equal(t, w1.All(), r1.All())
+			require.Equal(t, w1.None(), r1.None())
+			require.Equal(t, w1.Any(), r1.Any())
+			for i := 0; i <= n; i++ {
+				wi, wok := w1.NextSet(i)
+				ri, rok := r1.NextSet(i)
+				require.Equal(t, wok, rok, "next set from %d of %s", i, r1)
+				if wok {
+					require.Equal(t, wi, ri)
+				}
+			}
+			clone := r1.Clone()
+			clone.Set(0, !clone.Get(0))
+			require.NotEqual(t, r1.Get(0), clone.Get(0))
+		}
+	}
+
+	// the operations between bitsets of different lengths
+	short, long := NewRawBitset(10), NewRawBitset(20)
+	short.Set(9, true)
+	long.Set(9, true)
+	long.Set(19, true)
+	require.Equal(t, 20, short.Or(long).BitLength())
+	require.Equal(t, 10, short.And(long).BitLength())
+	require.Equal(t, "{19}", short.Xor(long).String())
+	require.True(t, long.IsSuperSet(short))
+	require.False(t, short.IsSuperSet(long))
+	require.Equal(t, 0, short.DifferenceCardinality(long))
+	require.Equal(t, 1, long.DifferenceCardinality(short))
+
+	full := NewRawBitset(10)
+	for i := 0; i < 10; i++ {
+		full.Set(i, true)
+	}
+	require.True(t, full.All())
+	require.Panics(t, func() { full.Set(10, true) })
+	require.Panics(t, func() { full.Get(-1) })
+}
+
+func TestBitSetRawMarshalling(t *testing.T) {
+	for _, n := range []int{1, 8, 10, 1000} {
+		b := NewRawBitset(n)
+		b.Set(0, true)
+		b.Set(n-1, true)
+		buff, err := b.MarshalBinary()
+		require.NoError(t, err)
+		require.Len(t, buff, (n+7)/8)
+
+		// a bitset of the same length keeps it
+		b2 := NewRawBitset(n)
+		require.NoError(t, b2.UnmarshalBinary(buff))
+		require.Equal(t, n, b2.BitLength())
+		require.Equal(t, b.String(), b2.String())
+
+		// another one takes the length of the bytes
+		b3 := new(RawBitSet)
+		require.NoError(t, b3.UnmarshalBinary(buff))
+		require.Equal(t, 8*len(buff), b3.BitLength())
+		require.Equal(t, b.String(), b3.String())
+	}
+
+	// the bits beyond the length are rejected
+	require.Error(t, NewRawBitset(10).UnmarshalBinary([]byte{0, 4}))
+
+	// the multi-signatures are decoded with the bitsets of the level
+	ms := &MultiSignature{BitSet: NewRawBitset(5), Signature: &fakeSig{true}}
+	ms.BitSet.Set(3, true)
+	buff, err := ms.MarshalBinary()
+	require.NoError(t, err)
+	ms2 := new(MultiSignature)
+	require.NoError(t, ms2.Unmarshal(buff, new(fakeSig), func(int) BitSet { return NewRawBitset(5) }))
+	require.Equal(t, 5, ms2.BitLength())
+	require.Equal(t, "{3}", ms2.BitSet.String())
+}
+
+func TestHandelRawBitSet(t *testing.T) {
+	// the levels and the registry are not multiples of 8 bits
+	n := 13
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.NewBitSet = NewRawBitset
+	config.Logger = &warnLogger{}
+	require.Equal(t, "raw", config.Describe()["NewBitSet"])
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		for done := false; !done; {
+			select {
+			case ms := <-h.FinalSignatures():
+				_, raw := ms.BitSet.(*RawBitSet)
+				require.True(t, raw)
+				require.Equal(t, n, ms.BitLength())
+				done = ms.Cardinality() == n
+			case <-time.After(5 * time.Second):
+				t.Fatalf("node %d did not get all the contributions", i)
+			}
+		}
+	}
+}
@@ -108,6 +108,20 @@ func (p *PublicKey) Combine(pp handel.PublicKey) handel.PublicKey {
 	return &PublicKey{p3}
 }
 
+// Sub implements the handel.SubPublicKey interface: it returns the aggregate
+// public key minus the given public key, computed as the addition of the
+// negated point.
+func (p *PublicKey) Sub(pp handel.PublicKey) handel.PublicKey {
+	p2 := pp.(*PublicKey)
+	neg := new(bn256.G2).Neg(p2.p)
+	if p.p == nil {
+		return &PublicKey{neg}
+	}
+	p3 := new(bn256.G2)
+	p3.Add(p.p, neg)
+	return &PublicKey{p3}
+}
+
 // MarshalBinary implements the simul/lib/PublicKey interface
 func (p *PublicKey) MarshalBinary() ([]byte, error) {
 	return p.p.Marshal(), nil
@@ -154,6 +168,20 @@ func (s *SecretKey) Sign(msg []byte, reader io.Reader) (handel.Signature, error)
 	return &SigBLS{p}, nil
 }
 
+// DiffieHellman implements the handel.DHSecretKey interface: the shared
+// secret is the hash of the point x*Y of G2, for the secret key x and the
+// public key Y of the peer.
+func (s *SecretKey) DiffieHellman(peer handel.PublicKey) ([]byte, error) {
+	p, ok := peer.(*PublicKey)
+	if !ok || p.p == nil {
+		return nil, errors.New("bn256: invalid peer public key")
+	}
+	shared := new(bn256.G2).ScalarMult(p.p, s.s)
+	h := Hash()
+	h.Write(shared.Marshal())
+	return h.Sum(nil), nil
+}
+
 // MarshalBinary implements the simul/lib/SecretKey interface
 func (s *SecretKey) MarshalBinary() ([]byte, error) {
 	return s.s.Bytes(), nil
@@ -97,3 +97,39 @@ func TestMarshalling(t *testing.T) {
 	err = pk2.(*PublicKey).UnmarshalBinary(buffPK)
 	require.NoError(t, err)
 }
+
+func TestSub(t *testing.T) {
+	_, pk1, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+	_, pk2, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+
+	pk3 := pk1.Combine(pk2).(*PublicKey).Sub(pk2)
+	require.Equal(t, pk1.String(), pk3.String())
+
+	// subtracting from the empty key gives the opposite key
+	neg := new(PublicKey).Sub(pk1)
+	require.Equal(t, pk2.String(), neg.Combine(pk1).Combine(pk2).String())
+}
+
+func TestDiffieHellman(t *testing.T) {
+	sk1, pk1, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+	sk2, pk2, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+	_, pk3, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+
+	var _ h.DHSecretKey = sk1
+	s12, err := sk1.DiffieHellman(pk2)
+	require.NoError(t, err)
+	s21, err := sk2.DiffieHellman(pk1)
+	require.NoError(t, err)
+	require.Equal(t, s12, s21)
+	s13, err := sk1.DiffieHellman(pk3)
+	require.NoError(t, err)
+	require.NotEqual(t, s12, s13)
+
+	_, err = sk1.DiffieHellman(new(PublicKey))
+	require.Error(t, err)
+}
@@ -153,6 +153,20 @@ func (s *SecretKey) Sign(msg []byte, reader io.Reader) (handel.Signature, error)
 	return &SigBLS{p}, nil
 }
 
+// DiffieHellman implements the handel.DHSecretKey interface: the shared
+// secret is the hash of the point x*Y of G2, for the secret key x and the
+// public key Y of the peer.
+func (s *SecretKey) DiffieHellman(peer handel.PublicKey) ([]byte, error) {
+	p, ok := peer.(*PublicKey)
+	if !ok || p.p == nil {
+		return nil, errors.New("bn256: invalid peer public key")
+	}
+	shared := new(bn256.G2).ScalarMult(p.p, s.s)
+	h := Hash()
+	h.Write(shared.Marshal())
+	return h.Sum(nil), nil
+}
+
 // MarshalBinary implements the simul/lib/SecretKey interface
 func (s *SecretKey) MarshalBinary() ([]byte, error) {
 	return s.s.Bytes(), nil
@@ -101,3 +101,25 @@ func TestMarshalling(t *testing.T) {
 	err = pk2.(*PublicKey).UnmarshalBinary(buffPK)
 	require.NoError(t, err)
 }
+
+func TestDiffieHellman(t *testing.T) {
+	sk1, pk1, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+	sk2, pk2, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+	_, pk3, err := NewKeyPair(rand.Reader)
+	require.NoError(t, err)
+
+	var _ h.DHSecretKey = sk1
+	s12, err := sk1.DiffieHellman(pk2)
+	require.NoError(t, err)
+	s21, err := sk2.DiffieHellman(pk1)
+	require.NoError(t, err)
+	require.Equal(t, s12, s21)
+	s13, err := sk1.DiffieHellman(pk3)
+	require.NoError(t, err)
+	require.NotEqual(t, s12, s13)
+
+	_, err = sk1.DiffieHellman(new(PublicKey))
+	require.Error(t, err)
+}
@@ -0,0 +1,56 @@
+// Package main is a service verifying the multi-signatures of the nodes of a
+// registry over HTTP, for the consumers which don't link Handel:
+//
+//	POST /verify    verifies a multi-signature, see Submission and Result
+//	GET  /registry  returns the size, the commitment and the digest of the registry
+//	GET  /metrics   returns the counters in the text format of Prometheus
+//
+// The registry is a CSV file as written by the simulation, whose records may
+// have no private key.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/ConsenSys/handel/simul/lib"
+)
+
+var registryFile = flag.String("registry", "", "CSV registry file of the nodes")
+var registryDigest = flag.String("registry-digest", "", "SHA-256 digest of the registry file - refused if it differs, empty disables the check")
+var curve = flag.String("curve", "bn256", "curve system of the registry")
+var listen = flag.String("listen", ":8080", "address to listen on")
+var maxBody = flag.Int64("max-body", 1<<20, "maximum size of a request body in bytes")
+var workers = flag.Int("workers", runtime.NumCPU(), "number of verifications running concurrently")
+var rangeSize = flag.Int("range", 64, "number of identities of each range of the breakdown")
+
+func main() {
+	flag.Parse()
+	if *registryFile == "" {
+		fmt.Fprintln(os.Stderr, "handel-verifierd: -registry is required")
+		os.Exit(2)
+	}
+	cons := lib.NewCurveConstructor(*curve)
+	nodes, _, err := lib.ReadAllWith(*registryFile, lib.NewCSVParser(), cons, lib.LoadOptions{PublicOnly: true, Digest: *registryDigest})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "handel-verifierd:", err)
+		os.Exit(1)
+	}
+	v, err := NewVerifier(nodes.Registry(), cons, *curve, *maxBody, *workers, *rangeSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "handel-verifierd:", err)
+		os.Exit(1)
+	}
+	if v.info.Digest, err = lib.RegistryDigest(*registryFile); err != nil {
+		fmt.Fprintln(os.Stderr, "handel-verifierd:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("handel-verifierd: %d identities, root %s, listening on %s\n", v.info.Size, v.info.Root, *listen)
+	if err := http.ListenAndServe(*listen, v.Handler()); err != nil {
+		fmt.Fprintln(os.Stderr, "handel-verifierd:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the outcomes of the POST /verify requests
+const (
+	outcomeValid     = "valid"
+	outcomeInvalid   = "invalid"
+	outcomeMalformed = "malformed"
+	outcomeOversized = "oversized"
+	outcomeCanceled  = "canceled"
+)
+
+// metrics holds the counters of the verifier, written in the text format of
+// Prometheus
+type metrics struct {
+	sync.Mutex
+	requests map[string]int
+	// number of verifications and total time spent verifying
+	verifications int
+	seconds       float64
+	// number of verifications running
+	running int
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[string]int)}
+}
+
+func (m *metrics) count(outcome string) {
+	m.Lock()
+	defer m.Unlock()
+	m.requests[outcome]++
+}
+
+func (m *metrics) observe(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.verifications++
+	m.seconds += d.Seconds()
+}
+
+func (m *metrics) busy(delta int) {
+	m.Lock()
+	defer m.Unlock()
+	m.running += delta
+}
+
+// write writes the metrics in the text format of Prometheus
+func (m *metrics) write(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	outcomes := make([]string, 0, len(m.requests))
+	for o := range m.requests {
+		outcomes = append(outcomes, o)
+	}
+	sort.Strings(outcomes)
+	fmt.Fprintln(w, "# HELP verifierd_requests_total Requests to verify a multi-signature by outcome.")
+	fmt.Fprintln(w, "# TYPE verifierd_requests_total counter")
+	for _, o := range outcomes {
+		fmt.Fprintf(w, "verifierd_requests_total{outcome=%q} %d\n", o, m.requests[o])
+	}
+	fmt.Fprintln(w, "# HELP verifierd_verification_seconds Time spent verifying the multi-signatures.")
+	fmt.Fprintln(w, "# TYPE verifierd_verification_seconds summary")
+	fmt.Fprintf(w, "verifierd_verification_seconds_sum %g\n", m.seconds)
+	fmt.Fprintf(w, "verifierd_verification_seconds_count %d\n", m.verifications)
+	fmt.Fprintln(w, "# HELP verifierd_workers_busy Verifications running.")
+	fmt.Fprintln(w, "# TYPE verifierd_workers_busy gauge")
+	fmt.Fprintf(w, "verifierd_workers_busy %d\n", m.running)
+}
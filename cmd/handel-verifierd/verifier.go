@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/proof"
+	"github.com/ConsenSys/handel/simul/lib"
+)
+
+// Submission is the JSON body of a POST /verify: the message, or the hex
+// encoded hash the producers signed instead of the message, and the
+// marshalled multi-signature
+type Submission struct {
+	Message  []byte `json:"message,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	MultiSig []byte `json:"multisig"`
+}
+
+// Range is the number of signers of a range of identities, from included to
+// excluded
+type Range struct {
+	From        int `json:"from"`
+	To          int `json:"to"`
+	Cardinality int `json:"cardinality"`
+}
+
+// Result is the answer to a POST /verify
+type Result struct {
+	Valid       bool    `json:"valid"`
+	Error       string  `json:"error,omitempty"`
+	Cardinality int     `json:"cardinality"`
+	Size        int     `json:"size"`
+	Ranges      []Range `json:"ranges"`
+}
+
+// RegistryInfo is the answer to a GET /registry: the root of the Merkle tree
+// committing to the public keys of the registry, see the proof package, and
+// the digest of the registry file the simulation pinned its nodes to, see
+// lib.RegistryDigest
+type RegistryInfo struct {
+	Curve  string `json:"curve"`
+	Size   int    `json:"size"`
+	Root   string `json:"root"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// Verifier verifies the multi-signatures submitted over HTTP against a
+// registry
+type Verifier struct {
+	reg       handel.Registry
+	cons      lib.Constructor
+	info      RegistryInfo
+	maxBody   int64
+	rangeSize int
+	// one token per verification running
+	workers chan struct{}
+	metrics *metrics
+}
+
+// NewVerifier returns a Verifier of the multi-signatures of the given
+// registry. It reads bodies up to maxBody bytes, runs up to the given number
+// of verifications concurrently and breaks the signers down by ranges of
+// rangeSize identities.
+func NewVerifier(reg handel.Registry, cons lib.Constructor, curve string, maxBody int64, workers, rangeSize int) (*Verifier, error) {
+	if workers < 1 || rangeSize < 1 || maxBody < 1 {
+		return nil, errors.New("verifier: workers, range size and body limit must be positive")
+	}
+	tree, err := proof.BuildKeyTree(reg)
+	if err != nil {
+		return nil, err
+	}
+	root := tree.Root()
+	return &Verifier{
+		reg:       reg,
+		cons:      cons,
+		info:      RegistryInfo{Curve: curve, Size: reg.Size(), Root: hex.EncodeToString(root[:])},
+		maxBody:   maxBody,
+		rangeSize: rangeSize,
+		workers:   make(chan struct{}, workers),
+		metrics:   newMetrics(),
+	}, nil
+}
+
+// Handler returns the handler serving POST /verify, GET /registry and GET
+// /metrics
+func (v *Verifier) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/verify", v.serveVerify)
+	mux.HandleFunc("/registry", v.serveRegistry)
+	mux.HandleFunc("/metrics", v.serveMetrics)
+	return mux
+}
+
+func (v *Verifier) serveRegistry(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, v.info)
+}
+
+func (v *Verifier) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	v.metrics.write(w)
+}
+
+func (v *Verifier) serveVerify(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	// one more byte to tell a body at the limit from a larger one
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, v.maxBody+1))
+	if err != nil {
+		v.metrics.count(outcomeMalformed)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > v.maxBody {
+		v.metrics.count(outcomeOversized)
+		http.Error(w, fmt.Sprintf("body larger than %d bytes", v.maxBody), http.StatusRequestEntityTooLarge)
+		return
+	}
+	msg, ms, err := v.decode(req.Header.Get("Content-Type"), body)
+	if err != nil {
+		v.metrics.count(outcomeMalformed)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case v.workers <- struct{}{}:
+	case <-req.Context().Done():
+		v.metrics.count(outcomeCanceled)
+		http.Error(w, "canceled while waiting for a worker", http.StatusServiceUnavailable)
+		return
+	}
+	v.metrics.busy(1)
+	start := time.Now()
+	res := v.verify(msg, ms)
+	v.metrics.observe(time.Since(start))
+	v.metrics.busy(-1)
+	<-v.workers
+
+	if res.Valid {
+		v.metrics.count(outcomeValid)
+	} else {
+		v.metrics.count(outcomeInvalid)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// decode returns the message and the multi-signature of the body: a
+// Submission in JSON, or with an "application/octet-stream" content type the
+// length of the message on 4 bytes big endian, the message and the
+// multi-signature.
+func (v *Verifier) decode(contentType string, body []byte) ([]byte, *handel.MultiSignature, error) {
+	var msg, msBuff []byte
+	if strings.HasPrefix(contentType, "application/octet-stream") {
+		buff := bytes.NewBuffer(body)
+		var length uint32
+		if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
+			return nil, nil, errors.New("missing message length")
+		}
+		if uint64(length) > uint64(buff.Len()) {
+			return nil, nil, errors.New("message longer than the body")
+		}
+		msg = buff.Next(int(length))
+		msBuff = buff.Bytes()
+	} else {
+		var s Submission
+		if err := json.Unmarshal(body, &s); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case s.Message != nil && s.Hash != "":
+			return nil, nil, errors.New("both a message and a hash")
+		case s.Hash != "":
+			hash, err := hex.DecodeString(s.Hash)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid hash: %s", err)
+			}
+			msg = hash
+		default:
+			msg = s.Message
+		}
+		msBuff = s.MultiSig
+	}
+	if len(msg) == 0 {
+		return nil, nil, errors.New("empty message")
+	}
+	ms := new(handel.MultiSignature)
+	if err := ms.Unmarshal(msBuff, v.cons.Signature(), handel.DefaultBitSet); err != nil {
+		return nil, nil, fmt.Errorf("invalid multi-signature: %s", err)
+	}
+	if ms.BitLength() != v.reg.Size() {
+		return nil, nil, fmt.Errorf("bitset of %d identities for a registry of %d", ms.BitLength(), v.reg.Size())
+	}
+	return msg, ms, nil
+}
+
+// verify returns the cardinality of the multi-signature, by range, and
+// whether it is valid
+func (v *Verifier) verify(msg []byte, ms *handel.MultiSignature) *Result {
+	res := &Result{Cardinality: ms.Cardinality(), Size: v.reg.Size()}
+	for from := 0; from < res.Size; from += v.rangeSize {
+		r := Range{From: from, To: from + v.rangeSize}
+		if r.To > res.Size {
+			r.To = res.Size
+		}
+		for i := r.From; i < r.To; i++ {
+			if ms.Get(i) {
+				r.Cardinality++
+			}
+		}
+		res.Ranges = append(res.Ranges, r)
+	}
+	if err := handel.VerifyMultiSignature(msg, ms, v.reg, v.cons.Handel()); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Valid = true
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+var msg = []byte("Sun is Shining...")
+
+// testRegistry writes the public records of n bn256 nodes to a registry file
+// and returns the verifier loading it with the nodes
+func testRegistry(t *testing.T, n int, maxBody int64) (*Verifier, []*lib.Node) {
+	cons := lib.NewCurveConstructor("bn256")
+	var addresses []string
+	for i := 0; i < n; i++ {
+		addresses = append(addresses, "127.0.0.1:3000")
+	}
+	nodes := lib.GenerateNodes(cons, addresses)
+	var records []*lib.NodeRecord
+	for _, node := range nodes {
+		rec, err := node.ToRecord()
+		require.NoError(t, err)
+		rec.Private = ""
+		records = append(records, rec)
+	}
+	dir, err := ioutil.TempDir("", "verifierd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "registry.csv")
+	require.NoError(t, lib.NewCSVParser().Write(file, records))
+
+	list, _, err := lib.ReadAllWith(file, lib.NewCSVParser(), cons, lib.LoadOptions{PublicOnly: true})
+	require.NoError(t, err)
+	v, err := NewVerifier(list.Registry(), cons, "bn256", maxBody, 2, 4)
+	require.NoError(t, err)
+	return v, nodes
+}
+
+// multiSig returns the multi-signature of the given nodes over the message
+func multiSig(t *testing.T, nodes []*lib.Node, message []byte, signers ...int) *handel.MultiSignature {
+	bs := handel.NewWilffBitset(len(nodes))
+	var sig handel.Signature
+	for _, i := range signers {
+		s, err := nodes[i].Sign(message, nil)
+		require.NoError(t, err)
+		if sig == nil {
+			sig = s
+		} else {
+			sig = sig.Combine(s)
+		}
+		bs.Set(i, true)
+	}
+	return &handel.MultiSignature{BitSet: bs, Signature: sig}
+}
+
+func postJSON(t *testing.T, url string, s *Submission) (int, *Result) {
+	buff, err := json.Marshal(s)
+	require.NoError(t, err)
+	return post(t, url, "application/json", buff)
+}
+
+func post(t *testing.T, url, contentType string, body []byte) (int, *Result) {
+	resp, err := http.Post(url+"/verify", contentType, bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	res := new(Result)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(res))
+	return resp.StatusCode, res
+}
+
+func TestVerifierSubmissions(t *testing.T) {
+	v, nodes := testRegistry(t, 10, 1<<16)
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+
+	valid := multiSig(t, nodes, msg, 0, 2, 3, 9)
+	buff, err := valid.MarshalBinary()
+	require.NoError(t, err)
+	code, res := postJSON(t, server.URL, &Submission{Message: msg, MultiSig: buff})
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, res.Valid, res.Error)
+	require.Equal(t, 4, res.Cardinality)
+	require.Equal(t, 10, res.Size)
+	require.Equal(t, []Range{{0, 4, 3}, {4, 8, 0}, {8, 10, 1}}, res.Ranges)
+
+	// the binary body
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, uint32(len(msg)))
+	body.Write(msg)
+	body.Write(buff)
+	code, res = post(t, server.URL, "application/octet-stream", body.Bytes())
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, res.Valid, res.Error)
+
+	// a signer added to the bitset
+	valid.BitSet.Set(5, true)
+	tampered, err := valid.MarshalBinary()
+	require.NoError(t, err)
+	code, res = postJSON(t, server.URL, &Submission{Message: msg, MultiSig: tampered})
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, res.Valid)
+	require.Equal(t, 5, res.Cardinality)
+	require.NotEmpty(t, res.Error)
+
+	// the signature of another message
+	other := multiSig(t, nodes, []byte("another message"), 0, 2, 3, 9)
+	tampered, err = other.MarshalBinary()
+	require.NoError(t, err)
+	code, res = postJSON(t, server.URL, &Submission{Message: msg, MultiSig: tampered})
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, res.Valid)
+
+	// garbled signature bytes, a bitset of another size and no message
+	code, _ = postJSON(t, server.URL, &Submission{Message: msg, MultiSig: append(buff[:len(buff)-4], 1, 2, 3, 4)})
+	require.Equal(t, http.StatusBadRequest, code)
+	small, err := multiSig(t, nodes[:8], msg, 0).MarshalBinary()
+	require.NoError(t, err)
+	code, _ = postJSON(t, server.URL, &Submission{Message: msg, MultiSig: small})
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = postJSON(t, server.URL, &Submission{MultiSig: buff})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	metrics, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(metrics), `verifierd_requests_total{outcome="valid"} 2`)
+	require.Contains(t, string(metrics), `verifierd_requests_total{outcome="invalid"} 2`)
+	require.Contains(t, string(metrics), `verifierd_requests_total{outcome="malformed"} 3`)
+	require.Contains(t, string(metrics), "verifierd_verification_seconds_count 4")
+}
+
+func TestVerifierOversized(t *testing.T) {
+	v, nodes := testRegistry(t, 4, 256)
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+
+	buff, err := multiSig(t, nodes, msg, 0, 1).MarshalBinary()
+	require.NoError(t, err)
+	code, res := postJSON(t, server.URL, &Submission{Message: msg, MultiSig: buff})
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, res.Valid, res.Error)
+
+	code, _ = postJSON(t, server.URL, &Submission{Message: bytes.Repeat(msg, 20), MultiSig: buff})
+	require.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	metrics, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.True(t, strings.Contains(string(metrics), `verifierd_requests_total{outcome="oversized"} 1`))
+}
+
+func TestVerifierRegistry(t *testing.T) {
+	v, _ := testRegistry(t, 5, 1<<16)
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/registry")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	info := new(RegistryInfo)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(info))
+	require.Equal(t, 5, info.Size)
+	require.Equal(t, "bn256", info.Curve)
+	require.Len(t, info.Root, 64)
+	require.Equal(t, v.info, *info)
+
+	resp, err = http.Post(server.URL+"/registry", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Params are the parameters of a simulated aggregation
+type Params struct {
+	// N is the number of nodes
+	N int
+	// Loss is the probability of a packet to be lost
+	Loss float64
+	// Latency samples the one-way latency of the packets
+	Latency Latency
+	// Verify is the time to verify a packet
+	Verify time.Duration
+	// Period is the update period, see handel.Config.UpdatePeriod
+	Period time.Duration
+	// LevelTimeout is the period of the linear activation of the levels,
+	// see handel.NewLinearTimeout
+	LevelTimeout time.Duration
+	// UpdateCount and FastPath are the numbers of peers contacted by the
+	// periodic updates and when a level completes, see handel.Config
+	UpdateCount int
+	FastPath    int
+	// Shuffle makes the nodes shuffle the peers of their levels, as Handel
+	// does by default
+	Shuffle bool
+	// Seed of the losses, latencies and shuffles
+	Seed int64
+	// MaxTime stops the aggregation if it is not over by then
+	MaxTime time.Duration
+}
+
+// LevelStats are the completion times of a level over the nodes: a node
+// completes a level once it verified contributions of all its peers of the
+// level.
+type LevelStats struct {
+	Level int
+	// Size is the largest number of peers of the level over the nodes
+	Size int
+	// Completed is the number of nodes which completed the level
+	Completed int
+	// Median and Max of the completion times of the nodes which completed
+	// the level
+	Median time.Duration
+	Max    time.Duration
+}
+
+// Result summarizes a simulated aggregation
+type Result struct {
+	Levels []LevelStats
+	// Packets sent, including the Lost ones
+	Packets int
+	Lost    int
+	// Verified is the number of packets verified
+	Verified int
+	// Useful is, for each node, the number of contributions the verified
+	// packets added, plus its own
+	Useful []int
+	// Final is, for each node, the cardinality of its full signature
+	Final []int
+	// End is the time of the last event, when all the nodes completed or at
+	// MaxTime
+	End time.Duration
+}
+
+// Latency samples the one-way latency of a packet
+type Latency interface {
+	Sample(r *rand.Rand) time.Duration
+	String() string
+}
+
+type fixedLatency time.Duration
+
+func (f fixedLatency) Sample(*rand.Rand) time.Duration { return time.Duration(f) }
+func (f fixedLatency) String() string                  { return time.Duration(f).String() }
+
+type uniformLatency struct{ min, max time.Duration }
+
+func (u uniformLatency) Sample(r *rand.Rand) time.Duration {
+	return u.min + time.Duration(r.Int63n(int64(u.max-u.min)+1))
+}
+func (u uniformLatency) String() string { return fmt.Sprintf("uniform:%s:%s", u.min, u.max) }
+
+type normalLatency struct{ mean, stddev time.Duration }
+
+func (n normalLatency) Sample(r *rand.Rand) time.Duration {
+	d := n.mean + time.Duration(r.NormFloat64()*float64(n.stddev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+func (n normalLatency) String() string { return fmt.Sprintf("normal:%s:%s", n.mean, n.stddev) }
+
+// ParseLatency parses a fixed latency, e.g. "50ms", a uniform one between two
+// durations, e.g. "uniform:20ms:80ms", or a normal one given its mean and
+// standard deviation, e.g. "normal:50ms:10ms", never negative.
+func ParseLatency(s string) (Latency, error) {
+	parts := strings.Split(s, ":")
+	durations := make([]time.Duration, 0, 2)
+	for _, p := range parts[min(1, len(parts)-1):] {
+		d, err := time.ParseDuration(p)
+		if err != nil {
+			return nil, fmt.Errorf("latency %q: %s", s, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("latency %q: negative duration", s)
+		}
+		durations = append(durations, d)
+	}
+	switch {
+	case len(parts) == 1:
+		return fixedLatency(durations[0]), nil
+	case len(parts) == 3 && parts[0] == "uniform" && durations[0] <= durations[1]:
+		return uniformLatency{durations[0], durations[1]}, nil
+	case len(parts) == 3 && parts[0] == "normal":
+		return normalLatency{durations[0], durations[1]}, nil
+	}
+	return nil, fmt.Errorf("latency %q: expected a duration, uniform:min:max or normal:mean:stddev", s)
+}
+
+// packet is an update of a level: the contributions of the levels below of
+// the sender, and its own if it did not complete the level
+type packet struct {
+	from    int
+	level   int
+	contrib handel.BitSet
+	indiv   bool
+}
+
+// node is the state of a simulated node
+type node struct {
+	id    int
+	part  handel.Partitioner
+	sched *handel.LevelSchedule
+	// best aggregate and individual contributions verified per level, over
+	// the global ids
+	aggs   map[int]handel.BitSet
+	indivs map[int]handel.BitSet
+	// completion time of the levels completed
+	completed map[int]time.Duration
+	pending   []*packet
+	verifying bool
+	useful    int
+}
+
+// best returns the contributions verified for the level
+func (n *node) best(level int) handel.BitSet {
+	return n.aggs[level].Or(n.indivs[level])
+}
+
+// combined returns our contribution and the ones verified for the levels up
+// to the given one, as Handel sends them at the level above
+func (n *node) combined(level int) handel.BitSet {
+	bs := n.aggs[0].Clone()
+	for _, id := range n.sched.Levels() {
+		if id <= level {
+			bs = bs.Or(n.best(id))
+		}
+	}
+	return bs
+}
+
+// merge returns the aggregate of the level once the packet is verified, as
+// the store of Handel merges disjoint aggregates and keeps the best one
+// otherwise, and the number of contributions it adds
+func (n *node) merge(p *packet) (handel.BitSet, handel.BitSet, int) {
+	agg, indivs := n.aggs[p.level], n.indivs[p.level]
+	before := agg.Or(indivs).Cardinality()
+	if !agg.AnyIntersect(p.contrib) {
+		agg = agg.Or(p.contrib)
+	} else if p.contrib.Cardinality() > agg.Cardinality() {
+		agg = p.contrib
+	}
+	if p.indiv {
+		indivs = indivs.Clone()
+		indivs.Set(p.from, true)
+	}
+	return agg, indivs, agg.Or(indivs).Cardinality() - before
+}
+
+type event struct {
+	at   time.Duration
+	seq  int
+	node int
+	// one of the kinds below
+	kind   int
+	packet *packet
+	level  int
+}
+
+const (
+	tick = iota
+	arrival
+	verified
+	timeout
+)
+
+type events []*event
+
+func (e events) Len() int { return len(e) }
+func (e events) Less(i, j int) bool {
+	if e[i].at != e[j].at {
+		return e[i].at < e[j].at
+	}
+	return e[i].seq < e[j].seq
+}
+func (e events) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *events) Push(x interface{}) { *e = append(*e, x.(*event)) }
+func (e *events) Pop() interface{} {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+type simulator struct {
+	Params
+	rnd    *rand.Rand
+	nodes  []*node
+	queue  events
+	seq    int
+	now    time.Duration
+	result Result
+	// number of nodes with all the contributions
+	full int
+}
+
+// Simulate runs the dissemination of Handel over the nodes with the
+// parameters. It models the candidate sets of the partitioner of Handel, the
+// activation of its levels and the rotation over their peers, with the code
+// of Handel. The network loses and delays the packets, and the verification
+// of each packet takes a fixed time. The signatures are not modeled: the
+// packets only carry the ids of their contributions.
+func Simulate(p Params) (*Result, error) {
+	if p.N < 1 {
+		return nil, errors.New("whatif: no node")
+	}
+	if p.Period <= 0 || p.LevelTimeout <= 0 || p.MaxTime <= 0 {
+		return nil, errors.New("whatif: the period, level timeout and max time must be positive")
+	}
+	if p.Loss < 0 || p.Loss >= 1 {
+		return nil, fmt.Errorf("whatif: loss %f not in [0, 1)", p.Loss)
+	}
+	s := &simulator{Params: p, rnd: rand.New(rand.NewSource(p.Seed))}
+	ids := make([]handel.Identity, p.N)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), strconv.Itoa(i), nil)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	for i := 0; i < p.N; i++ {
+		conf := &handel.Config{
+			DisableShuffling: !p.Shuffle,
+			Rand:             rand.New(rand.NewSource(p.Seed + int64(i) + 1)),
+		}
+		part := handel.NewBinPartitioner(int32(i), reg, nil)
+		n := &node{
+			id:        i,
+			part:      part,
+			sched:     handel.NewLevelSchedule(conf, part),
+			aggs:      map[int]handel.BitSet{0: handel.NewWilffBitset(p.N)},
+			indivs:    make(map[int]handel.BitSet),
+			completed: make(map[int]time.Duration),
+			useful:    1,
+		}
+		n.aggs[0].Set(i, true)
+		for _, lvl := range n.sched.Levels() {
+			n.aggs[lvl] = handel.NewWilffBitset(p.N)
+			n.indivs[lvl] = handel.NewWilffBitset(p.N)
+		}
+		s.nodes = append(s.nodes, n)
+		// the levels start one after the other, the first one at once
+		for j, lvl := range n.sched.Levels() {
+			s.schedule(time.Duration(j)*p.LevelTimeout, i, timeout, nil, lvl)
+		}
+		s.schedule(p.Period, i, tick, nil, 0)
+		if len(n.sched.Levels()) == 0 {
+			s.full++
+		}
+	}
+	s.run()
+	return s.summary(), nil
+}
+
+func (s *simulator) schedule(at time.Duration, node, kind int, p *packet, level int) {
+	s.seq++
+	heap.Push(&s.queue, &event{at: at, seq: s.seq, node: node, kind: kind, packet: p, level: level})
+}
+
+func (s *simulator) run() {
+	for s.queue.Len() > 0 && s.full < s.N {
+		e := heap.Pop(&s.queue).(*event)
+		if e.at > s.MaxTime {
+			s.now = s.MaxTime
+			return
+		}
+		s.now = e.at
+		n := s.nodes[e.node]
+		switch e.kind {
+		case tick:
+			for _, lvl := range n.sched.Levels() {
+				if n.sched.Active(lvl) {
+					s.send(n, lvl, s.UpdateCount)
+				}
+			}
+			s.schedule(s.now+s.Period, n.id, tick, nil, 0)
+		case timeout:
+			if n.sched.Start(e.level) {
+				s.send(n, e.level, s.UpdateCount)
+			}
+		case arrival:
+			if _, done := n.completed[e.packet.level]; !done {
+				n.pending = append(n.pending, e.packet)
+				s.verifyNext(n)
+			}
+		case verified:
+			n.verifying = false
+			s.apply(n, e.packet)
+			s.verifyNext(n)
+		}
+	}
+}
+
+// send sends the update of the level to its next count peers
+func (s *simulator) send(n *node, level, count int) {
+	contrib := n.combined(level - 1)
+	_, indiv := n.completed[level]
+	p := &packet{from: n.id, level: level, contrib: contrib, indiv: !indiv}
+	for _, id := range n.sched.Next(level, count) {
+		s.result.Packets++
+		if s.rnd.Float64() < s.Loss {
+			s.result.Lost++
+			continue
+		}
+		peer := s.nodes[id.ID()]
+		// the level of the packet is the same for the receiver
+		s.schedule(s.now+s.Latency.Sample(s.rnd), peer.id, arrival, p, level)
+	}
+}
+
+// verifyNext starts the verification of the pending packet adding the most
+// contributions, dropping the ones adding none, as the evaluator of the
+// store of Handel does
+func (s *simulator) verifyNext(n *node) {
+	if n.verifying {
+		return
+	}
+	bestIdx, bestScore := -1, 0
+	kept := n.pending[:0]
+	for _, p := range n.pending {
+		_, _, score := n.merge(p)
+		if score == 0 {
+			continue
+		}
+		if score > bestScore {
+			bestIdx, bestScore = len(kept), score
+		}
+		kept = append(kept, p)
+	}
+	n.pending = kept
+	if bestIdx < 0 {
+		return
+	}
+	p := n.pending[bestIdx]
+	n.pending = append(n.pending[:bestIdx], n.pending[bestIdx+1:]...)
+	n.verifying = true
+	s.schedule(s.now+s.Verify, n.id, verified, p, p.level)
+}
+
+// apply stores the verified packet, then completes the level and updates
+// the levels above as the actors of Handel do
+func (s *simulator) apply(n *node, p *packet) {
+	s.result.Verified++
+	agg, indivs, score := n.merge(p)
+	if score == 0 {
+		return
+	}
+	n.useful += score
+	n.aggs[p.level], n.indivs[p.level] = agg, indivs
+	if _, done := n.completed[p.level]; !done && n.best(p.level).Cardinality() == n.part.Size(p.level) {
+		n.completed[p.level] = s.now
+	}
+	for _, lvl := range n.sched.Levels() {
+		if lvl <= p.level {
+			continue
+		}
+		if n.sched.Improve(lvl, n.combined(lvl-1).Cardinality()) {
+			s.send(n, lvl, s.FastPath)
+		}
+	}
+	if n.useful == s.N {
+		s.full++
+	}
+}
+
+func (s *simulator) summary() *Result {
+	r := &s.result
+	r.End = s.now
+	levels := make(map[int][]time.Duration)
+	sizes := make(map[int]int)
+	for _, n := range s.nodes {
+		r.Useful = append(r.Useful, n.useful)
+		r.Final = append(r.Final, n.combined(n.part.MaxLevel()).Cardinality())
+		for _, lvl := range n.sched.Levels() {
+			sizes[lvl] = max(sizes[lvl], n.part.Size(lvl))
+			if at, ok := n.completed[lvl]; ok {
+				levels[lvl] = append(levels[lvl], at)
+			}
+		}
+	}
+	var ids []int
+	for lvl := range sizes {
+		ids = append(ids, lvl)
+	}
+	sort.Ints(ids)
+	for _, lvl := range ids {
+		times := levels[lvl]
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		st := LevelStats{Level: lvl, Size: sizes[lvl], Completed: len(times)}
+		if len(times) > 0 {
+			st.Median = times[(len(times)-1)/2]
+			st.Max = times[len(times)-1]
+		}
+		r.Levels = append(r.Levels, st)
+	}
+	return r
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
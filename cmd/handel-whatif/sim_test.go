@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+// params returns parameters without loss where the levels only start as the
+// levels below complete
+func params(n int) Params {
+	return Params{
+		N:            n,
+		Latency:      fixedLatency(10 * time.Millisecond),
+		Verify:       time.Millisecond,
+		Period:       5 * time.Millisecond,
+		LevelTimeout: time.Hour,
+		UpdateCount:  1,
+		FastPath:     10,
+		Seed:         1,
+		MaxTime:      10 * time.Second,
+	}
+}
+
+func TestSimulateTiny(t *testing.T) {
+	// each node sends its contribution to its peer of level 1 at the first
+	// update, after 5ms, which verifies it 10ms + 1ms later. The level 2
+	// then starts with the 2 contributions, sent to both peers at once.
+	r, err := Simulate(params(4))
+	require.NoError(t, err)
+	require.Equal(t, []LevelStats{
+		{Level: 1, Size: 1, Completed: 4, Median: 16 * time.Millisecond, Max: 16 * time.Millisecond},
+		{Level: 2, Size: 2, Completed: 4, Median: 27 * time.Millisecond, Max: 27 * time.Millisecond},
+	}, r.Levels)
+	require.Equal(t, 4*(1+2), r.Packets)
+	require.Zero(t, r.Lost)
+	// the second packet of level 2 adds nothing
+	require.Equal(t, 4*2, r.Verified)
+	require.Equal(t, 27*time.Millisecond, r.End)
+	require.Equal(t, []int{4, 4, 4, 4}, r.Final)
+
+	// the level 2 starts before the level 1 completes, with our contribution
+	p := params(4)
+	p.LevelTimeout = 3 * time.Millisecond
+	r, err = Simulate(p)
+	require.NoError(t, err)
+	// the level 2 starts at 3ms with our contribution alone, sent to the first
+	// peer of the level, and the update at 5ms sends it to the second one:
+	// each node completes the level 2 by 18ms, with the contributions alone.
+	require.Equal(t, LevelStats{Level: 2, Size: 2, Completed: 4, Median: 15 * time.Millisecond, Max: 18 * time.Millisecond}, r.Levels[1])
+	// one update of the level 1, two of the level 2 and the fast path
+	require.Equal(t, 4*(1+2+2), r.Packets)
+	require.Equal(t, 18*time.Millisecond, r.End)
+}
+
+func TestSimulateLossFree(t *testing.T) {
+	// every level completes one latency and one verification after the
+	// level below, once the fast path reaches all the peers of the level
+	n := 64
+	p := params(n)
+	p.FastPath = n / 2
+	p.Shuffle = true
+	r, err := Simulate(p)
+	require.NoError(t, err)
+	require.Len(t, r.Levels, 6)
+	round := 11 * time.Millisecond
+	for i, l := range r.Levels {
+		exp := p.Period + time.Duration(i+1)*round
+		require.Equal(t, n, l.Completed)
+		require.Equal(t, exp, l.Median, "level %d", l.Level)
+		require.Equal(t, exp, l.Max, "level %d", l.Level)
+	}
+	require.Equal(t, p.Period+6*round, r.End)
+
+	// with losses, it takes longer
+	p.Loss = 0.2
+	lossy, err := Simulate(p)
+	require.NoError(t, err)
+	require.True(t, lossy.End > r.End)
+	require.NotZero(t, lossy.Lost)
+}
+
+// withLevel returns the number of nodes out of n with peers at the level
+func withLevel(n, level int) int {
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", nil)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	var count int
+	for i := 0; i < n; i++ {
+		if handel.NewBinPartitioner(int32(i), reg, nil).Size(level) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSimulateConservation(t *testing.T) {
+	for _, n := range []int{1, 7, 100, 256} {
+		for _, loss := range []float64{0, 0.2} {
+			p := params(n)
+			p.Loss = loss
+			p.Latency = uniformLatency{5 * time.Millisecond, 50 * time.Millisecond}
+			p.LevelTimeout = 50 * time.Millisecond
+			p.Shuffle = true
+			r, err := Simulate(p)
+			require.NoError(t, err)
+			require.Len(t, r.Useful, n)
+			for i := range r.Useful {
+				// the contributions are counted once, whatever the packets
+				require.Equal(t, r.Final[i], r.Useful[i], "n=%d node %d", n, i)
+				require.True(t, r.Final[i] <= n)
+			}
+			require.True(t, r.Lost <= r.Packets)
+			if loss > 0 {
+				continue
+			}
+			// without loss, every node gets every contribution
+			for i := range r.Final {
+				require.Equal(t, n, r.Final[i], "n=%d node %d", n, i)
+			}
+			// the nodes complete all their levels
+			for _, l := range r.Levels {
+				require.Equal(t, withLevel(n, l.Level), l.Completed, "n=%d level %d", n, l.Level)
+			}
+			require.True(t, r.End < p.MaxTime)
+		}
+	}
+}
+
+func TestSimulateMaxTime(t *testing.T) {
+	p := params(64)
+	p.Loss = 0.5
+	p.MaxTime = 30 * time.Millisecond
+	r, err := Simulate(p)
+	require.NoError(t, err)
+	require.Equal(t, p.MaxTime, r.End)
+	for i := range r.Useful {
+		require.Equal(t, r.Final[i], r.Useful[i])
+		require.True(t, r.Final[i] < 64)
+	}
+}
+
+func TestSimulateDeterminism(t *testing.T) {
+	p := params(128)
+	p.Loss = 0.1
+	p.Latency = normalLatency{20 * time.Millisecond, 5 * time.Millisecond}
+	p.Shuffle = true
+	r1, err := Simulate(p)
+	require.NoError(t, err)
+	r2, err := Simulate(p)
+	require.NoError(t, err)
+	require.Equal(t, r1, r2)
+	p.Seed = 2
+	r3, err := Simulate(p)
+	require.NoError(t, err)
+	require.NotEqual(t, r1.Packets, r3.Packets)
+
+	_, err = Simulate(Params{})
+	require.Error(t, err)
+	p.Loss = 1
+	_, err = Simulate(p)
+	require.Error(t, err)
+}
+
+func TestParseLatency(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	l, err := ParseLatency("50ms")
+	require.NoError(t, err)
+	require.Equal(t, 50*time.Millisecond, l.Sample(rnd))
+	l, err = ParseLatency("uniform:20ms:80ms")
+	require.NoError(t, err)
+	require.Equal(t, "uniform:20ms:80ms", l.String())
+	for i := 0; i < 100; i++ {
+		d := l.Sample(rnd)
+		require.True(t, d >= 20*time.Millisecond && d <= 80*time.Millisecond)
+	}
+	l, err = ParseLatency("normal:5ms:10ms")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.True(t, l.Sample(rnd) >= 0)
+	}
+	for _, s := range []string{"", "x", "-5ms", "uniform:20ms", "uniform:80ms:20ms", "pareto:1ms:2ms", "normal:a:b"} {
+		_, err := ParseLatency(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestRunGrid(t *testing.T) {
+	*nodes = "4,8"
+	*losses = "0,0.1"
+	*seeds = "1"
+	defer func() { *nodes, *losses = "1024", "0" }()
+	grid, err := parseGrid()
+	require.NoError(t, err)
+	require.Len(t, grid, 4)
+	var b bytes.Buffer
+	require.NoError(t, run(grid, &b))
+	rows, err := csv.NewReader(&b).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, csvHeader, rows[0])
+	// 2 levels with 4 nodes, 3 with 8
+	require.Len(t, rows, 1+2*2+2*3)
+	require.Equal(t, []string{"4", "0", "50ms", "1ms", "10ms", "50ms", "1", "1", "1", "4"}, rows[1][:10])
+
+	*losses = "x"
+	_, err = parseGrid()
+	require.Error(t, err)
+}
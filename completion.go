@@ -0,0 +1,290 @@
+package handel
+
+import (
+	"encoding/binary"
+	"errors"
+	mathRand "math/rand"
+	"time"
+)
+
+// FlagCompletion marks a packet telling the aggregation is over, see
+// Config.BroadcastCompletion. Its MultiSig field holds the BitSetDigest of
+// the full signature meeting the threshold, and its IndividualSig field the
+// full multi-signature itself unless Config.CompletionDigestOnly.
+const FlagCompletion byte = 2
+
+// completion is the state of the completion packets, see
+// Config.BroadcastCompletion. It is guarded by the lock of Handel.
+type completion struct {
+	// true once our full signature met the threshold or a completion packet
+	// was accepted
+	done bool
+	// true if the completion came from a peer
+	external bool
+	// true while the signature of a completion packet is verified, which
+	// bounds the verifications to one at a time
+	verifying bool
+	// time of the last completion sent to each peer
+	answered map[int32]time.Time
+	// peers which sent us a completion, which need none
+	completed map[int32]bool
+
+	sent       int
+	received   int
+	accepted   int
+	rejected   int
+	verified   int
+	unverified int
+}
+
+func newCompletion() *completion {
+	return &completion{
+		answered:  make(map[int32]time.Time),
+		completed: make(map[int32]bool),
+	}
+}
+
+// completed returns true once the aggregation is over for this node: it
+// stops sending updates, see Config.BroadcastCompletion. The lock must be
+// held.
+func (h *Handel) completed() bool {
+	return h.completion.done
+}
+
+// complete marks the aggregation as completed by our full signature and
+// broadcasts it. The lock must be held.
+func (h *Handel) complete(ms *MultiSignature) {
+	c := h.completion
+	if !h.c.BroadcastCompletion || c.done {
+		return
+	}
+	c.done = true
+	p, err := h.completionPacket(ms)
+	if err != nil {
+		h.log.Error("completion", err)
+		return
+	}
+	targets := h.completionTargets()
+	h.log.Debug("sent_completion", ms.Cardinality(), "peers", len(targets))
+	for _, id := range targets {
+		h.sendCompletion(id, p)
+	}
+}
+
+// completionTargets returns the next update count peers of each active level,
+// which would have been contacted next, and CompletionSample other random
+// nodes. The peers of the other levels got our best signature already, and
+// the ones still sending us updates get the completion in answer.
+func (h *Handel) completionTargets() []Identity {
+	seen := map[int32]bool{h.id.ID(): true}
+	var ids []Identity
+	add := func(id Identity) {
+		if !seen[id.ID()] {
+			seen[id.ID()] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		if !lvl.active() {
+			continue
+		}
+		for i := 0; i < min(h.updateCount(lvl), len(lvl.nodes)); i++ {
+			add(lvl.nodes[(lvl.sendPos+i)%len(lvl.nodes)])
+		}
+	}
+	var seed int64
+	if err := binary.Read(h.c.Rand, binary.BigEndian, &seed); err != nil {
+		h.log.Error("completion_sample", err)
+		return ids
+	}
+	size := h.reg.Size()
+	for _, i := range mathRand.New(mathRand.NewSource(seed)).Perm(size)[:min(h.c.CompletionSample+1, size)] {
+		if id, ok := h.reg.Identity(i); ok {
+			add(id)
+		}
+	}
+	return ids
+}
+
+// completionPacket returns the completion packet of the full signature
+func (h *Handel) completionPacket(ms *MultiSignature) (*Packet, error) {
+	d, err := NewBitSetDigest(FullLevel, ms.BitSet)
+	if err != nil {
+		return nil, err
+	}
+	p := &Packet{Origin: h.id.ID(), Level: FullLevel, Flags: FlagCompletion, Aggregation: h.aggregation}
+	if p.MultiSig, err = d.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	if !h.c.CompletionDigestOnly {
+		if p.IndividualSig, err = ms.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.signPacket(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// sendCompletion sends the completion packet to the peer, on the address of
+// its level if Config.PortPerLevel
+func (h *Handel) sendCompletion(peer Identity, p *Packet) {
+	ids := []Identity{peer}
+	if h.c.PortPerLevel {
+		lvl, _, err := h.Partitioner.LevelOf(int(peer.ID()))
+		if err == nil {
+			ids, err = levelIdentities(ids, lvl)
+		}
+		if err != nil {
+			h.log.Error("level_address", err)
+			return
+		}
+	}
+	h.completion.sent++
+	h.completion.answered[peer.ID()] = h.now()
+	h.stats.msgSentCt++
+	h.stats.bytesSent += len(p.MultiSig) + len(p.IndividualSig)
+	h.net.Send(ids, p)
+}
+
+// answerLate sends the completion to a peer which still sends us packets, at
+// most once per ResendIdenticalAfter, unless the peer told us it completed.
+// The lock must be held.
+func (h *Handel) answerLate(origin int32) {
+	c := h.completion
+	now := h.now()
+	if last, ok := c.answered[origin]; c.completed[origin] || ok && now.Sub(last) < h.c.ResendIdenticalAfter {
+		return
+	}
+	peer, ok := h.reg.Identity(int(origin))
+	if !ok || h.best == nil {
+		return
+	}
+	p, err := h.completionPacket(h.best)
+	if err != nil {
+		h.log.Error("completion", err)
+		return
+	}
+	h.sendCompletion(peer, p)
+}
+
+// newCompletion handles a completion packet. The claim is accepted without
+// verification if our own full signature matches its digest, otherwise its
+// signature is verified in the background. The lock must be held.
+func (h *Handel) newCompletion(p *Packet) {
+	c := h.completion
+	c.received++
+	if !h.c.BroadcastCompletion || c.verifying {
+		return
+	}
+	d, ms, err := h.parseCompletion(p)
+	if err != nil {
+		c.rejected++
+		h.log.Warn("invalid_completion", err, "from", p.Origin)
+		return
+	}
+	// not verified, but the peer is the only one misled if it lies
+	c.completed[p.Origin] = true
+	if c.done {
+		return
+	}
+	own := h.store.FullSignature()
+	if own.Cardinality() == d.Cardinality && d.Missing(own.BitSet) == 0 && h.groupQuorum(own.BitSet) {
+		h.acceptCompletion(p.Origin, own)
+		return
+	}
+	h.bitsets.Put(own.BitSet)
+	if ms == nil {
+		c.unverified++
+		return
+	}
+	c.verifying = true
+	h.spawn(func() { h.verifyCompletion(p.Origin, ms) })
+}
+
+// parseCompletion returns the digest of the completion packet and its
+// multi-signature, nil if it carries none. It checks they claim the
+// threshold.
+func (h *Handel) parseCompletion(p *Packet) (*BitSetDigest, *MultiSignature, error) {
+	d, err := unmarshalDigest(p.MultiSig, h.c.NewBitSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.Level != FullLevel || d.BitLength != h.reg.Size() {
+		return nil, nil, errors.New("digest of another signature than the full one")
+	}
+	if d.Cardinality < h.threshold {
+		return nil, nil, errors.New("completion below the threshold")
+	}
+	if p.IndividualSig == nil {
+		return d, nil, nil
+	}
+	ms := new(MultiSignature)
+	// the bitset has the length of the digest, whatever its encoding
+	nbs := func(int) BitSet { return h.c.NewBitSet(d.BitLength) }
+	if err := ms.Unmarshal(p.IndividualSig, h.cons.Signature(), nbs); err != nil {
+		return nil, nil, err
+	}
+	if ms.BitLength() != d.BitLength || ms.Cardinality() != d.Cardinality || d.Missing(ms.BitSet) != 0 {
+		return nil, nil, errors.New("signature not matching the digest")
+	}
+	if !h.groupQuorum(ms.BitSet) {
+		return nil, nil, errors.New("completion without the group quorum")
+	}
+	return d, ms, nil
+}
+
+// verifyCompletion verifies the signature of a completion packet of the
+// origin and accepts it if valid
+func (h *Handel) verifyCompletion(origin int32, ms *MultiSignature) {
+	err := VerifyMultiSignature(h.msg, ms, h.reg, h.cons)
+	h.Lock()
+	defer h.Unlock()
+	c := h.completion
+	c.verifying = false
+	c.verified++
+	if err != nil {
+		c.rejected++
+		h.log.Warn("invalid_completion", err, "from", origin)
+		return
+	}
+	if h.done || c.done {
+		return
+	}
+	h.acceptCompletion(origin, ms)
+}
+
+// acceptCompletion marks the aggregation as completed by the verified full
+// signature of a peer and outputs it. The lock must be held.
+func (h *Handel) acceptCompletion(origin int32, ms *MultiSignature) {
+	c := h.completion
+	c.done = true
+	c.external = true
+	c.accepted++
+	h.log.Info("completed_by", origin, "card", ms.Cardinality())
+	h.emitFinal(ms)
+}
+
+// completionValues returns the counts of the completion packets sent,
+// received, accepted, rejected as invalid, verified and not verifiable
+// without their signature, and whether the completion came from a peer
+func (h *Handel) completionValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	c := h.completion
+	values := map[string]float64{
+		"sent":       float64(c.sent),
+		"received":   float64(c.received),
+		"accepted":   float64(c.accepted),
+		"rejected":   float64(c.rejected),
+		"verified":   float64(c.verified),
+		"unverified": float64(c.unverified),
+		"external":   0,
+	}
+	if c.external {
+		values["external"] = 1
+	}
+	return values
+}
@@ -0,0 +1,140 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastCompletion(t *testing.T) {
+	baseline, _ := completionTraffic(t, false)
+	after, handels := completionTraffic(t, true)
+	t.Logf("packets after the first completion: %d without, %d with the completion", baseline, after)
+	require.True(t, after*2 < baseline, "%d packets with the completion, %d without", after, baseline)
+
+	var external int
+	for _, h := range handels {
+		r := h.Result()
+		require.Equal(t, ThresholdMet, r.Outcome)
+		if r.External {
+			external++
+		}
+	}
+	require.NotZero(t, external)
+	values := NewReportHandel(handels[0]).Values()
+	require.NotZero(t, values["completion_sent"]+values["completion_received"])
+}
+
+// completionTraffic runs an aggregation over FakeSetup until every node
+// outputs a signature, then for 50 more updates. It returns the number of
+// packets sent since the first output, and the stopped nodes.
+func completionTraffic(t *testing.T, broadcast bool) (int, []*Handel) {
+	n := 64
+	_, handels := FakeSetupWith(n, func(c *Config) { c.BroadcastCompletion = broadcast })
+	defer CloseHandels(handels)
+	nets := make([]*recordNetwork, n)
+	for i, h := range handels {
+		nets[i] = &recordNetwork{Network: h.net}
+		h.net = nets[i]
+	}
+	sent := func() int {
+		total := 0
+		for _, net := range nets {
+			total += len(net.to())
+		}
+		return total
+	}
+	outputs := make(chan bool, n)
+	for _, h := range handels {
+		go func(h *Handel) {
+			if _, ok := <-h.FinalSignatures(); ok {
+				outputs <- true
+			}
+		}(h)
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	var first int
+	for i := 0; i < n; i++ {
+		select {
+		case <-outputs:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d nodes out of %d output a signature", i, n)
+		}
+		if i == 0 {
+			first = sent()
+		}
+	}
+	// the updates which would follow, run in place of the tickers
+	for _, h := range handels {
+		h.ticker.Stop()
+	}
+	for i := 0; i < 50; i++ {
+		for _, h := range handels {
+			h.periodicUpdate()
+		}
+	}
+	CloseHandels(handels)
+	return sent() - first, handels
+}
+
+func TestCompletionForged(t *testing.T) {
+	_, handels := FakeSetupWith(16, func(c *Config) { c.BroadcastCompletion = true })
+	defer CloseHandels(handels)
+	h := handels[0]
+	completion := func(sig *fakeSig, card int, digestOnly bool) *Packet {
+		bs := NewWilffBitset(16)
+		for i := 0; i < card; i++ {
+			bs.Set(i, true)
+		}
+		h.c.CompletionDigestOnly = digestOnly
+		p, err := h.completionPacket(&MultiSignature{BitSet: bs, Signature: sig})
+		require.NoError(t, err)
+		p.Origin = 3
+		return p
+	}
+	values := func() map[string]float64 {
+		// the verifications are tracked by Handel
+		h.wg.Wait()
+		return h.completionValues()
+	}
+
+	// an invalid signature
+	h.NewPacket(completion(&fakeSig{false}, 16, false))
+	require.Equal(t, 1.0, values()["rejected"])
+	require.Equal(t, 1.0, values()["verified"])
+	// below the threshold
+	h.NewPacket(completion(&fakeSig{true}, h.threshold-1, false))
+	require.Equal(t, 2.0, values()["rejected"])
+	require.Equal(t, 1.0, values()["verified"])
+	// a digest not matching our own signature
+	h.NewPacket(completion(&fakeSig{true}, 16, true))
+	require.Equal(t, 1.0, values()["unverified"])
+	// a signature not matching the digest
+	p := completion(&fakeSig{true}, 16, false)
+	p.MultiSig = completion(&fakeSig{true}, 15, false).MultiSig
+	h.NewPacket(p)
+	require.Equal(t, 3.0, values()["rejected"])
+
+	h.Lock()
+	require.False(t, h.completed())
+	h.Unlock()
+	select {
+	case ms := <-h.FinalSignatures():
+		t.Fatalf("forged completion output %s", ms.String())
+	default:
+	}
+
+	// a valid one
+	h.NewPacket(completion(&fakeSig{true}, 16, false))
+	select {
+	case ms := <-h.FinalSignatures():
+		require.Equal(t, 16, ms.Cardinality())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no output")
+	}
+	require.Equal(t, 1.0, values()["accepted"])
+	require.Equal(t, 1.0, values()["external"])
+}
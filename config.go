@@ -3,6 +3,8 @@ package handel
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"math"
 	"time"
@@ -18,6 +20,19 @@ type Config struct {
 	// default.
 	Contributions int
 
+	// Groups maps each index of the registry to the failure domain (for
+	// example the organization) this node belongs to. It is only used when
+	// MaxGroupFraction is set. Indexes not covered by the slice do not belong
+	// to any group.
+	Groups []int
+
+	// MaxGroupFraction is the maximum fraction of the contributions of a
+	// final multi-signature that a single group can account for. When set,
+	// Handel only outputs multi-signatures that contain the threshold of
+	// contributions AND where no group contributes more than this fraction of
+	// the set bits. Zero disables the rule.
+	MaxGroupFraction float64
+
 	// UpdatePeriod indicates at which frequency a Handel nodes sends updates
 	// about its state to other Handel nodes.
 	UpdatePeriod time.Duration
@@ -26,12 +41,38 @@ type Config struct {
 	// a given level.
 	UpdateCount int
 
+	// LevelUpdateCount returns the number of nodes contacted during each
+	// update at the given level, of the given number of peers, e.g.
+	// ProportionalUpdateCount, so the higher levels are covered faster. A
+	// count below 1 means UpdateCount, as does a nil LevelUpdateCount.
+	LevelUpdateCount func(level, size int) int
+
+	// UpdateOnImprovement makes Handel send the signature of a started level
+	// to the next peers of the level, see LevelUpdateCount, as soon as it
+	// improves, without waiting for the next periodic update. The levels
+	// getting complete are sent to the FastPath peers either way.
+	UpdateOnImprovement bool
+
+	// UpdatePayload selects the signatures sent to the peers of a level:
+	// UpdateCombinedPrefix, UpdateBestLevel or UpdateFull. All the nodes must
+	// use the same payload. Empty means UpdateCombinedPrefix.
+	UpdatePayload string
+
 	// FastPath indicates how many peers should we contact when a level gets
 	// completed.
 	FastPath int
 
+	// FanoutSpread is the window over which the sends to the FastPath peers
+	// of a level are spread when it gets complete, starting after a random
+	// jitter, so the nodes completing a level at the same time don't send
+	// their bursts at once. If zero, DefaultFanoutSpreadRatio of the
+	// UpdatePeriod is used. A negative value sends them all at once.
+	FanoutSpread time.Duration
+
 	// NewBitSet returns an empty bitset. This function is used to parse
-	// incoming packets containing bitsets.
+	// incoming packets containing bitsets. All the nodes must use the same
+	// implementation: NewRawBitset encodes the bitsets more compactly than
+	// the default WilffBitSet.
 	NewBitSet func(bitlength int) BitSet
 
 	// NewPartitioner returns the Partitioner to use for this Handel round. If
@@ -39,6 +80,38 @@ type Config struct {
 	// responsible for and reg is the global registry of participants.
 	NewPartitioner func(id int32, reg Registry, Logger Logger) Partitioner
 
+	// PartitionerMode selects how the nodes to contact at each level are
+	// ordered: PartitionerDeterministic, PartitionerSharedSeed or
+	// PartitionerSalted. When set, it overrides NewPartitioner. If empty,
+	// NewPartitioner is used and the nodes are shuffled using Rand.
+	PartitionerMode string
+
+	// PartitionerSeed is the seed used by the shared-seed and salted
+	// partitioner modes.
+	PartitionerSeed []byte
+
+	// MaxAggregationLevel restricts the aggregation to the subtree of the
+	// node up to this level: the ids sharing its bits above the
+	// MaxAggregationLevel lowest ones, see SubtreeRange. The registry stays
+	// global for the addresses and the keys, but the levels above are never
+	// created and the packets of the nodes outside of the subtree are
+	// rejected. The final signatures span the subtree, see
+	// VerifySubtreeMultiSignature, and Contributions counts contributions of
+	// the subtree: a smaller subtree, at the end of the registry, requires
+	// all of its own. It assumes a binomial partitioner, and can't be used
+	// with BroadcastCompletion nor KeyRotationGrace. Zero aggregates the whole
+	// registry.
+	MaxAggregationLevel int
+
+	// NewStore returns the store of the verified signatures. The bitset
+	// function is Config.NewBitSet. If nil, DefaultStore is used.
+	NewStore func(part Partitioner, nbs func(int) BitSet, c Constructor) SignatureStore
+
+	// NewProcessing returns the processing verifying the incoming signatures
+	// over the message, in the order given by the evaluator. If nil, Handel
+	// uses its evaluator processing, which honors UnsafeSleepTimeOnSigVerify.
+	NewProcessing func(part Partitioner, c Constructor, msg []byte, e SigEvaluator, log Logger) SignatureProcessing
+
 	// NewEvaluatorStrategy returns the signature evaluator to use during the
 	// Handel round.
 	NewEvaluatorStrategy func(s SignatureStore, h *Handel) SigEvaluator
@@ -47,6 +120,46 @@ type Config struct {
 	// round. By default, it uses the linear timeout strategy.
 	NewTimeoutStrategy func(h *Handel, levels []int) TimeoutStrategy
 
+	// Encoding is the wire format of the packets when Handel runs over a
+	// Transport. If nil, DefaultEncoding is used. It is not used by a Handel
+	// running over a Network, which does its own encoding.
+	Encoding Encoding
+
+	// ActorBudget is the time the actors can hold the global lock when
+	// dispatching a verified signature before a warning is logged. If zero,
+	// DefaultActorBudget is used.
+	ActorBudget time.Duration
+
+	// StarvationCheck is the number of update periods between two checks for
+	// starved levels, i.e. levels that did not receive any contribution
+	// after their whole candidate set has been contacted twice. If zero,
+	// DefaultStarvationCheck is used. A negative value disables the checks.
+	StarvationCheck int
+
+	// OnLevelStarved is called, if not nil, each time a level is detected as
+	// starved. As OnThreshold, it is called in its own routine, outside of
+	// Handel's global lock, so it can call Handel's methods but Close.
+	OnLevelStarved func(LevelStarved)
+
+	// OnThresholdUnreachable is called, if not nil, once the starved levels
+	// cap the cardinality of the final signature below the threshold, with
+	// this upper bound. It is called again if late contributions make the
+	// threshold reachable, then starved levels unreachable again. As
+	// OnThreshold, it is called in its own routine, so it can call Stop.
+	OnThresholdUnreachable func(maxAchievable int)
+
+	// OnThreshold is called, if not nil, once the first time the final
+	// signature reaches the Contributions, with a copy of it. It is called in
+	// its own routine, outside of Handel's global lock, so it can call
+	// Handel's methods, such as Stop, but not Close, which waits for it to
+	// return. The signatures are still sent over FinalSignatures.
+	OnThreshold func(ms MultiSignature)
+
+	// Tracer, if not nil, receives the spans of the timeline of the
+	// aggregation: the aggregation itself, the active period of each level
+	// and each verification, see Tracer.
+	Tracer Tracer
+
 	// Logger to use for logging handel actions
 	Logger Logger
 	// Rand provides the source of entropy for shuffling the list of nodes that
@@ -58,6 +171,173 @@ type Config struct {
 	// is much easier to detect pattern in bugs in this manner
 	DisableShuffling bool
 
+	// ResendIdenticalAfter is the time before Handel sends again to a peer the
+	// same signature it already sent to this peer at a level, to cover the
+	// loss of the first packet. The sends of a signature which did not improve
+	// since the last send to the peer are skipped during this time. Each
+	// resend time is randomized up to twice this value, so the peers
+	// receiving the resends vary. Zero means DefaultResendIdenticalAfter and a
+	// negative value always resends.
+	ResendIdenticalAfter time.Duration
+
+	// HealthStall is the time without activity after which Health reports a
+	// routine as stuck, or the network as silent. If zero,
+	// DefaultHealthStall is used.
+	HealthStall time.Duration
+
+	// PortPerLevel makes Handel send the packets of level L to the port of the
+	// registry address plus L, see LevelAddress, so the traffic of each level
+	// can be told apart at the transport layer. The network of the receiving
+	// nodes must listen on these ports, and all the nodes must agree on the
+	// option.
+	PortPerLevel bool
+
+	// Deadline is the time after which Handel stops itself if it is still
+	// running, counted from Start. The Result then reports whether the
+	// threshold was met before. Zero means no deadline.
+	Deadline time.Duration
+
+	// StatusLogPeriod is the period at which Handel logs its StatusLine at
+	// the info level, as progress breadcrumbs. Zero disables it. It can be
+	// changed at runtime with Handel.SetStatusLogPeriod.
+	StatusLogPeriod time.Duration
+
+	// LogLevel is the lowest level of the statements Handel passes to the
+	// Logger, LogDebug by default which leaves the filtering to the Logger.
+	// It can be changed at runtime with Handel.SetLogLevel.
+	LogLevel LogLevel
+
+	// StrictIndividualOrigin makes the processing reject an individual
+	// signature whose signer is not the node which sent it. By default such
+	// signatures are accepted, since a node may legitimately relay the
+	// individual signature of another one.
+	StrictIndividualOrigin bool
+
+	// TolerantLevels makes Handel accept the signatures labeled with a level
+	// adjacent to their own, as sent by the peers numbering the levels off by
+	// one: a signature whose bitset does not have the size of the level of
+	// its packet is remapped to the level of its origin, if this level is
+	// adjacent and of the exact size of the bitset. Otherwise, and by
+	// default, such signatures are rejected. See Handel.LevelRemaps.
+	TolerantLevels bool
+
+	// StrictInvariants makes Handel panic with a dump of its store when an
+	// internal invariant is violated, such as the cardinality of the full
+	// signature decreasing. Otherwise the violation is only logged as an
+	// error and counted. Meant for the tests and the simulations.
+	StrictInvariants bool
+
+	// QueueCapacity is the number of signatures the processing queue is
+	// expected to hold. Above the pressures of the Shedding policy relative
+	// to this capacity, Handel drops incoming packets before they reach the
+	// processing. Zero disables the shedding. A custom processing takes part
+	// by implementing PressureReporter. It can be changed at runtime with
+	// Handel.SetQueueCapacity.
+	QueueCapacity int
+
+	// Shedding is the policy applied under the pressure of the processing
+	// queue, DefaultShedPolicy if zero.
+	Shedding ShedPolicy
+
+	// DeprioritizeStale makes the evaluator give the lowest positive score
+	// to the aggregates of the stale origins, so they are verified last
+	// instead of being dropped. An origin is stale once the EWMA of the ratio
+	// of the cardinality of its aggregates to our best signature of their
+	// level is below StaleThreshold after StaleMinPackets aggregates. The
+	// individual signatures are never down-weighted.
+	DeprioritizeStale bool
+	// StaleThreshold is DefaultStaleThreshold if zero
+	StaleThreshold float64
+	// StaleMinPackets is DefaultStaleMinPackets if zero
+	StaleMinPackets int
+
+	// EnableNegotiation makes Handel send, every NegotiationPeriod, a digest
+	// of its best signature of its NegotiationLevels most incomplete levels
+	// which did not progress since the previous period to a peer of the
+	// level. The peer answers with its signature for the level if it holds
+	// contributions missing from the digest. It also makes Handel answer the
+	// digests it receives. Ignored with the UpdateFull payload, whose updates
+	// already carry all the levels.
+	EnableNegotiation bool
+	// NegotiationPeriod is DefaultNegotiationPeriod if zero. A peer answers
+	// at most one digest of a node per period.
+	NegotiationPeriod time.Duration
+	// NegotiationLevels is DefaultNegotiationLevels if zero
+	NegotiationLevels int
+
+	// ObservabilityBudgetBytes bounds the approximate size of the structures
+	// tracking observations about the aggregation. When their sum exceeds it,
+	// Handel disables them with a notice in the logs, in this order: the
+	// staleness of the origins, which stops DeprioritizeStale, the execution
+	// times of the actors and the efficiency of the verifications. Zero means
+	// no budget. The per-origin structures are bounded by the registry anyway.
+	ObservabilityBudgetBytes int
+
+	// AvoidUnresponsive makes Handel suspect the peers it never heard of
+	// after UnresponsiveAttempts contacts since their last packet, and
+	// contact them at the end of each rotation over the peers of their level
+	// instead of in their turn. A packet clears the suspicion. It only
+	// changes the order of the contacts, so it composes with any contact
+	// order: a contiguous range of offline nodes doesn't delay the contact of
+	// the live ones anymore, and every peer is still contacted once per
+	// rotation.
+	AvoidUnresponsive bool
+	// UnresponsiveAttempts is DefaultUnresponsiveAttempts if zero
+	UnresponsiveAttempts int
+
+	// RetainProofMaterial makes the default store keep, for each level, the
+	// verified signatures its best multi-signature was combined from, so
+	// Handel.ProofFor can prove the best was honestly formed. The parts of a
+	// level are disjoint, so there are at most as many as the size of the
+	// level; their memory is reported as store_proofBytes.
+	RetainProofMaterial bool
+
+	// GossipProgress makes Handel give the cardinality of its full signature
+	// in the packets it sends, and estimate the progress of the network from
+	// the ones it receives, see Handel.NetworkProgress. Without it, the hints
+	// received are ignored.
+	GossipProgress bool
+
+	// BroadcastCompletion makes Handel tell its peers the aggregation is over
+	// once its full signature meets the threshold: it sends a completion
+	// packet to the peers of each level the next update would contact, see
+	// LevelUpdateCount, and to CompletionSample random nodes, and stops
+	// sending updates. A peer
+	// receiving it verifies the signature, or matches the digest against its
+	// own full signature, before it outputs the signature and stops sending
+	// updates too. The completed nodes still answer the digests, see
+	// EnableNegotiation, and answer the peers still sending them updates with
+	// the completion, at most once per ResendIdenticalAfter. A peer verifies
+	// one completion at a time, so forged ones cost at most a verification
+	// each.
+	BroadcastCompletion bool
+	// CompletionSample is DefaultCompletionSample if zero
+	CompletionSample int
+	// CompletionDigestOnly makes the completion packets carry only the digest
+	// of the full signature: only the peers holding a matching full signature
+	// accept them.
+	CompletionDigestOnly bool
+
+	// AuthenticatePackets makes Handel sign the packets it sends with the
+	// secret key of its identity, and drop the packets received without a
+	// valid signature of their origin before parsing them, so a node can't
+	// spoof the origin of the packets it sends. The secret key is given to
+	// NewHandelWithSecret; a Session does not support it. All the nodes must
+	// enable it: without it, the packets are neither signed nor checked.
+	AuthenticatePackets bool
+
+	// PreStart keeps the packets received before Handel starts, which it
+	// takes once started - see PacketBuffer. Handel doesn't register itself
+	// to the network then: the buffer must be registered instead, once for
+	// all the Handels using it. A Session keeps the buffer of its config.
+	PreStart *PacketBuffer
+
+	// KeyRotationGrace is the time after Start during which the signatures
+	// of the RotatingIdentity are accepted under their previous key as well,
+	// following the hints of the packets - see Handel.PreviousKeys. After,
+	// only the current keys are accepted. Zero disables the previous keys.
+	KeyRotationGrace time.Duration
+
 	// UnsafeSleepTimeOnSigVerify is a test feature a sleep time (in ms) rather than actually verifying the signatures
 	// Can be used to save on CPU during tests or/and to test with shorter/longer verifying time
 	// Set to zero by default: no sleep time. When activated the sleep replaces the verification.
@@ -71,17 +351,43 @@ func DefaultConfig(numberOfNodes int) *Config {
 	return &Config{
 		Contributions:        contributions,
 		FastPath:             DefaultCandidateCount,
+		FanoutSpread:         time.Duration(DefaultFanoutSpreadRatio * float64(DefaultUpdatePeriod)),
 		UpdatePeriod:         DefaultUpdatePeriod,
 		UpdateCount:          DefaultUpdateCount,
 		NewBitSet:            DefaultBitSet,
 		NewPartitioner:       DefaultPartitioner,
+		NewStore:             DefaultStore,
 		NewEvaluatorStrategy: DefaultEvaluatorStrategy,
 		NewTimeoutStrategy:   DefaultTimeoutStrategy,
+		ActorBudget:          DefaultActorBudget,
+		StarvationCheck:      DefaultStarvationCheck,
+		ResendIdenticalAfter: DefaultResendIdenticalAfter,
+		HealthStall:          DefaultHealthStall,
 		Logger:               DefaultLogger,
 		Rand:                 rand.Reader,
 	}
 }
 
+// Validate returns an error if the config can't be used by Handel over a
+// registry of the given size: the registry must contain at least one identity
+// and the number of contributions can't exceed its size, or the size of the
+// subtrees with MaxAggregationLevel.
+func (c *Config) Validate(size int) error {
+	if size < 1 {
+		return errors.New("handel: empty registry")
+	}
+	if c.MaxAggregationLevel < 0 {
+		return fmt.Errorf("handel: negative maximum aggregation level %d", c.MaxAggregationLevel)
+	}
+	if c.MaxAggregationLevel > 0 && (c.BroadcastCompletion || c.KeyRotationGrace > 0) {
+		return errors.New("handel: the maximum aggregation level excludes the completions and the key rotations")
+	}
+	if n := maxSubtreeSize(c.MaxAggregationLevel, size); c.Contributions < 0 || c.Contributions > n {
+		return fmt.Errorf("handel: %d contributions required out of %d identities", c.Contributions, n)
+	}
+	return nil
+}
+
 // DefaultContributionsPerc is the default percentage used as the required
 // number of contributions in a multi-signature.
 const DefaultContributionsPerc = 51
@@ -92,10 +398,72 @@ const DefaultCandidateCount = 10
 // DefaultUpdatePeriod is the default update period used by Handel.
 const DefaultUpdatePeriod = 10 * time.Millisecond
 
+// DefaultFanoutSpreadRatio is the default ratio of the update period over
+// which the sends of the fast path are spread, see Config.FanoutSpread.
+const DefaultFanoutSpreadRatio = 0.25
+
+// DefaultActorBudget is the default time the actors can hold the global lock
+// when dispatching a verified signature.
+const DefaultActorBudget = 10 * time.Millisecond
+
+// DefaultStarvationCheck is the default number of update periods between two
+// checks for starved levels.
+const DefaultStarvationCheck = 5
+
+// DefaultResendIdenticalAfter is the default time before sending again the
+// same signature to a peer.
+const DefaultResendIdenticalAfter = 100 * time.Millisecond
+
+// DefaultHealthStall is the default time without activity after which a
+// routine is reported as stuck by Handel.Health.
+const DefaultHealthStall = time.Second
+
+// DefaultStaleThreshold is the default EWMA of the staleness ratio below
+// which an origin is stale, see Config.DeprioritizeStale.
+const DefaultStaleThreshold = 0.5
+
+// DefaultStaleMinPackets is the default number of aggregates of an origin
+// before it can be stale.
+const DefaultStaleMinPackets = 10
+
+// DefaultNegotiationPeriod is the default period between two digests sent,
+// see Config.EnableNegotiation.
+const DefaultNegotiationPeriod = 200 * time.Millisecond
+
+// DefaultNegotiationLevels is the default number of levels negotiated per
+// period.
+const DefaultNegotiationLevels = 1
+
+// DefaultUnresponsiveAttempts is the default number of contacts after which a
+// peer never heard of is suspected, see Config.AvoidUnresponsive.
+const DefaultUnresponsiveAttempts = 2
+
+// DefaultCompletionSample is the default number of random nodes a completion
+// is sent to, see Config.BroadcastCompletion.
+const DefaultCompletionSample = 4
+
 // DefaultUpdateCount is the default number of candidate contacted during an
 // update
 const DefaultUpdateCount = 1
 
+// ProportionalUpdateCount returns a Config.LevelUpdateCount contacting the
+// given fraction of the peers of a level during each update, rounded up, and
+// at least min of them.
+//
+// It is not inlined, so the functions it returns share their code and are
+// all named "proportional" by Config.Describe.
+//
+//go:noinline
+func ProportionalUpdateCount(fraction float64, min int) func(level, size int) int {
+	return func(level, size int) int {
+		count := int(math.Ceil(fraction * float64(size)))
+		if count < min {
+			return min
+		}
+		return count
+	}
+}
+
 // DefaultBitSet returns the default implementation used by Handel, i.e. the
 // WilffBitSet
 var DefaultBitSet = func(bitlength int) BitSet { return NewWilffBitset(bitlength) }
@@ -106,12 +474,29 @@ var DefaultPartitioner = func(id int32, reg Registry, logger Logger) Partitioner
 	return NewBinPartitioner(id, reg, logger)
 }
 
+// DefaultStore returns the default implementation of the SignatureStore used
+// by Handel, which merges the multi-signatures with the individual signatures
+// verified so far.
+var DefaultStore = func(part Partitioner, nbs func(int) BitSet, c Constructor) SignatureStore {
+	return newStore(part, nbs, c)
+}
+
 // DefaultEvaluatorStrategy returns an evaluator based on the store's own
 // evaluation strategy.
 var DefaultEvaluatorStrategy = func(store SignatureStore, h *Handel) SigEvaluator {
 	return newEvaluatorStore(store)
 }
 
+// CostEvaluatorStrategy returns an evaluator based on the store's own
+// evaluation strategy, weighted by the estimated verification cost of each
+// signature. The cost model is calibrated once by timing a few verifications
+// through the constructor of the given Handel. See CostEvaluator.
+var CostEvaluatorStrategy = func(store SignatureStore, h *Handel) SigEvaluator {
+	cost := CalibrateCost(h.cons, h.reg, h.msg, h.sig, h.id.PublicKey(), DefaultCalibrationRounds)
+	h.log.Debug("cost_base", cost.Base, "cost_per_key", cost.PerKey)
+	return NewCostEvaluator(newEvaluatorStore(store), cost)
+}
+
 // DefaultTimeoutStrategy returns the default timeout strategy used by handel -
 // the linear strategy with the default timeout. See DefaultLevelTimeout.
 func DefaultTimeoutStrategy(h *Handel, levels []int) TimeoutStrategy {
@@ -128,7 +513,7 @@ func PercentageToContributions(perc, n int) int {
 func mergeWithDefault(c *Config, size int) *Config {
 	c2 := *c
 	if c.Contributions == 0 {
-		n := PercentageToContributions(DefaultContributionsPerc, size)
+		n := PercentageToContributions(DefaultContributionsPerc, maxSubtreeSize(c.MaxAggregationLevel, size))
 		c2.Contributions = n
 	}
 	if c.FastPath == 0 {
@@ -137,6 +522,9 @@ func mergeWithDefault(c *Config, size int) *Config {
 	if c.UpdatePeriod == 0*time.Second {
 		c2.UpdatePeriod = DefaultUpdatePeriod
 	}
+	if c.FanoutSpread == 0 {
+		c2.FanoutSpread = time.Duration(DefaultFanoutSpreadRatio * float64(c2.UpdatePeriod))
+	}
 	if c.UpdateCount == 0 {
 		c2.UpdateCount = DefaultUpdateCount
 	}
@@ -146,12 +534,71 @@ func mergeWithDefault(c *Config, size int) *Config {
 	if c.NewPartitioner == nil {
 		c2.NewPartitioner = DefaultPartitioner
 	}
+	switch c.PartitionerMode {
+	case "":
+	case PartitionerDeterministic:
+		c2.NewPartitioner = DefaultPartitioner
+		c2.DisableShuffling = true
+	case PartitionerSharedSeed:
+		c2.NewPartitioner = func(id int32, reg Registry, logger Logger) Partitioner {
+			return NewRandomBinPartitioner(id, reg, logger, c.PartitionerSeed)
+		}
+	case PartitionerSalted:
+		c2.NewPartitioner = func(id int32, reg Registry, logger Logger) Partitioner {
+			return NewRandomBinPartitionerSalted(id, reg, logger, c.PartitionerSeed, saltFromID(id))
+		}
+	default:
+		panic("handel: unknown partitioner mode " + c.PartitionerMode)
+	}
+	switch c.UpdatePayload {
+	case "":
+		c2.UpdatePayload = UpdateCombinedPrefix
+	case UpdateCombinedPrefix, UpdateBestLevel, UpdateFull:
+	default:
+		panic("handel: unknown update payload " + c.UpdatePayload)
+	}
+	if c.NewStore == nil {
+		c2.NewStore = DefaultStore
+	}
 	if c.NewEvaluatorStrategy == nil {
 		c2.NewEvaluatorStrategy = DefaultEvaluatorStrategy
 	}
 	if c.NewTimeoutStrategy == nil {
 		c2.NewTimeoutStrategy = DefaultTimeoutStrategy
 	}
+	if c.ActorBudget == 0 {
+		c2.ActorBudget = DefaultActorBudget
+	}
+	if c.StarvationCheck == 0 {
+		c2.StarvationCheck = DefaultStarvationCheck
+	}
+	if c.ResendIdenticalAfter == 0 {
+		c2.ResendIdenticalAfter = DefaultResendIdenticalAfter
+	}
+	if c.HealthStall == 0 {
+		c2.HealthStall = DefaultHealthStall
+	}
+	if c.Shedding == (ShedPolicy{}) {
+		c2.Shedding = DefaultShedPolicy
+	}
+	if c.StaleThreshold == 0 {
+		c2.StaleThreshold = DefaultStaleThreshold
+	}
+	if c.StaleMinPackets == 0 {
+		c2.StaleMinPackets = DefaultStaleMinPackets
+	}
+	if c.NegotiationPeriod == 0 {
+		c2.NegotiationPeriod = DefaultNegotiationPeriod
+	}
+	if c.NegotiationLevels == 0 {
+		c2.NegotiationLevels = DefaultNegotiationLevels
+	}
+	if c.UnresponsiveAttempts == 0 {
+		c2.UnresponsiveAttempts = DefaultUnresponsiveAttempts
+	}
+	if c.CompletionSample == 0 {
+		c2.CompletionSample = DefaultCompletionSample
+	}
 	if c.Logger == nil {
 		c2.Logger = DefaultLogger
 	}
@@ -0,0 +1,340 @@
+package handel
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The contract tests check the documented behavior of the SignatureStore and
+// SignatureProcessing interfaces against each of their implementations: a new
+// implementation only needs to be registered in storeImpls or processingImpls
+// to be covered.
+
+// storeImpl is a SignatureStore implementation under the contract tests
+type storeImpl struct {
+	name string
+	new  func(part Partitioner, nbs func(int) BitSet, c Constructor) SignatureStore
+}
+
+var storeImpls = []storeImpl{
+	{"store", DefaultStore},
+	{"report", func(part Partitioner, nbs func(int) BitSet, c Constructor) SignatureStore {
+		return newReportStore(newStore(part, nbs, c))
+	}},
+}
+
+// processingImpl is a SignatureProcessing implementation under the contract
+// tests, evaluating the signatures with the given store. The processing must
+// implement drainer so the tests can await it.
+type processingImpl struct {
+	name string
+	new  func(store SignatureStore, part Partitioner, c Constructor, msg []byte) SignatureProcessing
+}
+
+var processingImpls = []processingImpl{
+	{"evaluator", func(store SignatureStore, part Partitioner, c Constructor, msg []byte) SignatureProcessing {
+		return newEvaluatorProcessing(part, c, msg, 0, false, 0, newEvaluatorStore(store), nopLogger{})
+	}},
+	{"fifo", func(store SignatureStore, part Partitioner, c Constructor, msg []byte) SignatureProcessing {
+		return newFifoProcessing(store, part, c, msg)
+	}},
+}
+
+// drainer is a processing telling when the signatures added so far were all
+// output on Verified or discarded
+type drainer interface {
+	Drained() <-chan struct{}
+}
+
+var storeContracts = []struct {
+	name string
+	test func(t *testing.T, impl storeImpl)
+}{
+	{"monotonic", testStoreMonotonic},
+	{"evaluate", testStoreEvaluate},
+	{"combined", testStoreCombinedContract},
+	{"concurrent", testStoreConcurrent},
+}
+
+var processingContracts = []struct {
+	name string
+	test func(t *testing.T, impl processingImpl)
+}{
+	{"verified", testProcessingVerified},
+	{"stop", testProcessingStop},
+	{"concurrent", testProcessingConcurrent},
+}
+
+func TestStoreContract(t *testing.T) {
+	for _, impl := range storeImpls {
+		for _, c := range storeContracts {
+			impl, c := impl, c
+			t.Run(impl.name+"/"+c.name, func(t *testing.T) {
+				c.test(t, impl)
+			})
+		}
+	}
+}
+
+func TestProcessingContract(t *testing.T) {
+	for _, impl := range processingImpls {
+		for _, c := range processingContracts {
+			impl, c := impl, c
+			t.Run(impl.name+"/"+c.name, func(t *testing.T) {
+				c.test(t, impl)
+			})
+		}
+	}
+}
+
+// contractLevels is the number of levels of the registry of the contract tests
+const contractLevels = 6
+
+// newContractStore returns a store of the implementation for a random node of
+// a registry of 2^contractLevels nodes, and the partitioner of the node
+func newContractStore(r *rand.Rand, impl storeImpl) (SignatureStore, Partitioner) {
+	n := 1 << contractLevels
+	part := NewBinPartitioner(int32(r.Intn(n)), FakeRegistry(n), DefaultLogger)
+	return impl.new(part, NewWilffBitset, new(fakeCons)), part
+}
+
+// bestCardinalities returns the cardinality of the best signature of each
+// level of the store, zero if it has none
+func bestCardinalities(store SignatureStore) []int {
+	cards := make([]int, contractLevels+1)
+	for level := range cards {
+		if ms, ok := store.Best(byte(level)); ok && ms != nil {
+			cards[level] = ms.Cardinality()
+		}
+	}
+	return cards
+}
+
+// the best signature of a level never loses contributions
+func testStoreMonotonic(t *testing.T, impl storeImpl) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 20; i++ {
+		store, part := newContractStore(r, impl)
+		for level := 0; level <= contractLevels; level++ {
+			_, ok := store.Best(byte(level))
+			require.False(t, ok, "level %d", level)
+		}
+		before := bestCardinalities(store)
+		for _, sp := range randomIncomingSigs(r, part, contractLevels, 100) {
+			store.Store(sp)
+			after := bestCardinalities(store)
+			for level := range after {
+				require.True(t, after[level] >= before[level], "level %d: %d then %d", level, before[level], after[level])
+			}
+			require.True(t, after[sp.level] > 0)
+			before = after
+		}
+	}
+}
+
+// the evaluation is never negative, and zero for the signatures which can't
+// add any contribution
+func testStoreEvaluate(t *testing.T, impl storeImpl) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 20; i++ {
+		store, part := newContractStore(r, impl)
+		for _, sp := range randomIncomingSigs(r, part, contractLevels, 100) {
+			require.True(t, store.Evaluate(sp) >= 0)
+			store.Store(sp)
+			if sp.Individual() {
+				// already verified
+				require.Equal(t, 0, store.Evaluate(sp))
+			}
+			best, ok := store.Best(sp.level)
+			require.True(t, ok)
+			// the best signature itself, and any signature once the level
+			// is complete
+			require.Equal(t, 0, store.Evaluate(&IncomingSig{level: sp.level, ms: best}))
+			if best.Cardinality() == part.Size(int(sp.level)) {
+				require.Equal(t, 0, store.Evaluate(sp))
+			}
+		}
+	}
+}
+
+// Combined and FullSignature are the combinations of the best signatures by
+// the partitioner
+func testStoreCombinedContract(t *testing.T, impl storeImpl) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 20; i++ {
+		store, part := newContractStore(r, impl)
+		require.Nil(t, store.Combined(0))
+		store.Store(&IncomingSig{level: 0, ms: fullSig(0)})
+		for _, sp := range randomIncomingSigs(r, part, contractLevels, 50) {
+			store.Store(sp)
+		}
+
+		var bests []*IncomingSig
+		total := 0
+		for level := 0; level <= contractLevels; level++ {
+			if ms, ok := store.Best(byte(level)); ok {
+				bests = append(bests, &IncomingSig{level: byte(level), ms: ms})
+				total += ms.Cardinality()
+			}
+			if level == part.MaxLevel() {
+				// the combination of all the levels is the full signature
+				break
+			}
+			exp := part.Combine(bests, level+1, NewWilffBitset)
+			combined := store.Combined(byte(level))
+			require.NotNil(t, combined)
+			require.Equal(t, part.Size(level+1), combined.BitLength())
+			require.Equal(t, exp.BitSet.String(), combined.BitSet.String(), "level %d", level)
+		}
+
+		full := store.FullSignature()
+		require.Equal(t, 1<<contractLevels, full.BitLength())
+		require.Equal(t, total, full.Cardinality())
+		require.Equal(t, part.CombineFull(bests, NewWilffBitset).BitSet.String(), full.BitSet.String())
+	}
+}
+
+// the signatures are stored by one routine while the others read the store
+func testStoreConcurrent(t *testing.T, impl storeImpl) {
+	r := rand.New(rand.NewSource(42))
+	store, part := newContractStore(r, impl)
+	sps := randomIncomingSigs(r, part, contractLevels, 500)
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := i; ; j++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				level := byte(j % contractLevels)
+				store.Evaluate(sps[j%len(sps)])
+				store.Best(level)
+				store.Combined(level)
+				store.FullSignature()
+				asReporter(store).Values()
+			}
+		}(i)
+	}
+	for _, sp := range sps {
+		store.Store(sp)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// runContractProcessing starts a processing of the implementation, and reads
+// its verified signatures until it is stopped. Each verified signature is
+// stored, as Handel does. The returned function stops the processing and
+// returns the signatures verified.
+func runContractProcessing(t *testing.T, impl processingImpl, r *rand.Rand) (SignatureProcessing, Partitioner, func() []*IncomingSig) {
+	n := 1 << contractLevels
+	part := NewBinPartitioner(int32(r.Intn(n)), FakeRegistry(n), DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	proc := impl.new(store, part, new(fakeCons), msg)
+	started := make(chan bool)
+	go func() {
+		proc.Start()
+		close(started)
+	}()
+	var verified []*IncomingSig
+	read := make(chan bool)
+	go func() {
+		for v := range proc.Verified() {
+			verified = append(verified, v)
+			store.Store(v)
+		}
+		close(read)
+	}()
+	return proc, part, func() []*IncomingSig {
+		select {
+		case <-proc.(drainer).Drained():
+		case <-time.After(5 * time.Second):
+			t.Fatal("processing not drained")
+		}
+		proc.Stop()
+		for _, ch := range []chan bool{read, started} {
+			select {
+			case <-ch:
+			case <-time.After(5 * time.Second):
+				t.Fatal("processing not stopped")
+			}
+		}
+		return verified
+	}
+}
+
+// invalidSigs makes one out of three signatures invalid, and returns whether
+// each is valid
+func invalidSigs(sps []*IncomingSig) map[*IncomingSig]bool {
+	valid := make(map[*IncomingSig]bool, len(sps))
+	for i, sp := range sps {
+		valid[sp] = i%3 != 0
+		if !valid[sp] {
+			sp.ms.Signature = &fakeSig{false}
+		}
+	}
+	return valid
+}
+
+// requireVerifiedFrom checks that each signature verified is a valid one
+// added, and is verified once
+func requireVerifiedFrom(t *testing.T, verified []*IncomingSig, valid map[*IncomingSig]bool) {
+	require.NotEmpty(t, verified)
+	seen := make(map[*IncomingSig]bool)
+	for _, v := range verified {
+		ok, added := valid[v]
+		require.True(t, added, "signature verified but not added")
+		require.True(t, ok, "invalid signature verified")
+		require.False(t, seen[v], "signature verified twice")
+		seen[v] = true
+	}
+}
+
+// only the valid signatures added are output on Verified
+func testProcessingVerified(t *testing.T, impl processingImpl) {
+	r := rand.New(rand.NewSource(42))
+	proc, part, stop := runContractProcessing(t, impl, r)
+	sps := randomIncomingSigs(r, part, contractLevels, 100)
+	valid := invalidSigs(sps)
+	for _, sp := range sps {
+		proc.Add(sp)
+	}
+	requireVerifiedFrom(t, stop(), valid)
+}
+
+// once stopped, Start returns and Verified is closed, even without any
+// signature
+func testProcessingStop(t *testing.T, impl processingImpl) {
+	r := rand.New(rand.NewSource(42))
+	_, _, stop := runContractProcessing(t, impl, r)
+	require.Empty(t, stop())
+}
+
+// the signatures are added concurrently, while the verified ones are stored
+func testProcessingConcurrent(t *testing.T, impl processingImpl) {
+	r := rand.New(rand.NewSource(42))
+	proc, part, stop := runContractProcessing(t, impl, r)
+	sps := randomIncomingSigs(r, part, contractLevels, 400)
+	valid := invalidSigs(sps)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(sps []*IncomingSig) {
+			defer wg.Done()
+			for _, sp := range sps {
+				proc.Add(sp)
+			}
+		}(sps[i*100 : (i+1)*100])
+	}
+	wg.Wait()
+	requireVerifiedFrom(t, stop(), valid)
+}
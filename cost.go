@@ -0,0 +1,109 @@
+package handel
+
+import (
+	"time"
+)
+
+// VerifyCost is a linear model of the time it takes to verify a
+// multi-signature: a constant cost for the verification itself (i.e. the
+// pairing for BLS) plus the cost of aggregating one public key per
+// contribution present in the bitset.
+type VerifyCost struct {
+	// Base is the time taken to verify a signature against a single public key
+	Base time.Duration
+	// PerKey is the time taken to aggregate one public key
+	PerKey time.Duration
+}
+
+// Estimate returns the estimated time to verify a multi-signature containing
+// the given number of contributions.
+func (v *VerifyCost) Estimate(cardinality int) time.Duration {
+	return v.Base + time.Duration(cardinality)*v.PerKey
+}
+
+// DefaultCalibrationRounds is the number of verifications and aggregations
+// timed by CalibrateCost.
+const DefaultCalibrationRounds = 5
+
+// CalibrateCost times a few verifications of the given signature under the
+// given public key, and a few public key aggregations using the keys of the
+// registry, to estimate the verification cost model of the Constructor.
+func CalibrateCost(cons Constructor, reg Registry, msg []byte, sig Signature, pub PublicKey, rounds int) *VerifyCost {
+	if rounds <= 0 {
+		rounds = DefaultCalibrationRounds
+	}
+	start := time.Now()
+	for i := 0; i < rounds; i++ {
+		// only the time matters, the signature can be invalid
+		pub.VerifySignature(msg, sig)
+	}
+	base := time.Since(start) / time.Duration(rounds)
+
+	keys := 0
+	aggregate := cons.PublicKey()
+	start = time.Now()
+	for i := 0; i < rounds; i++ {
+		id, ok := reg.Identity(i % reg.Size())
+		if !ok {
+			break
+		}
+		aggregate = aggregate.Combine(id.PublicKey())
+		keys++
+	}
+	var perKey time.Duration
+	if keys > 0 {
+		perKey = time.Since(start) / time.Duration(keys)
+	}
+	return &VerifyCost{Base: base, PerKey: perKey}
+}
+
+// CostEvaluator is a wrapper around an evaluator that ranks signatures by the
+// ratio between their value, as given by the wrapped evaluator, and their
+// estimated verification cost. When two signatures are equally valuable, for
+// example both complete a level, the cheapest one to verify is preferred.
+type CostEvaluator struct {
+	SigEvaluator
+	cost *VerifyCost
+}
+
+// NewCostEvaluator returns a CostEvaluator around the given evaluator using
+// the given cost model.
+func NewCostEvaluator(e SigEvaluator, cost *VerifyCost) *CostEvaluator {
+	return &CostEvaluator{SigEvaluator: e, cost: cost}
+}
+
+// Evaluate implements the SigEvaluator interface. The value of the wrapped
+// evaluator is scaled down by the cost relative to the cost of verifying an
+// individual signature, so individual signatures keep their original value.
+func (c *CostEvaluator) Evaluate(sp *IncomingSig) int {
+	score, _ := c.evaluateReason(sp)
+	return score
+}
+
+// evaluateReason implements the explainingEvaluator interface, a signature
+// being only rejected by the wrapped evaluator
+func (c *CostEvaluator) evaluateReason(sp *IncomingSig) (int, rejection) {
+	value, reason := evaluateReason(c.SigEvaluator, sp)
+	if value <= 0 {
+		return 0, reason
+	}
+	cost := c.cost.Estimate(sp.ms.Cardinality())
+	if cost <= 0 {
+		return value, ""
+	}
+	scaled := int(float64(value) * float64(c.cost.Estimate(1)) / float64(cost))
+	if scaled < 1 {
+		// still worth verifying
+		return 1, ""
+	}
+	return scaled, ""
+}
+
+// Values implements the Reporter interface. It returns the calibration numbers
+// in nanoseconds.
+func (c *CostEvaluator) Values() map[string]float64 {
+	return map[string]float64{
+		"costBase":   float64(c.cost.Base.Nanoseconds()),
+		"costPerKey": float64(c.cost.PerKey.Nanoseconds()),
+	}
+}
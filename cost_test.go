@@ -0,0 +1,162 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// delayPublic is a fake public key whose verification takes a time linear in
+// the number of signers it aggregates, as the verification of a BLS
+// multi-signature does: a base time plus one aggregation per signer.
+type delayPublic struct {
+	*fakePublic
+	signers int
+	base    time.Duration
+	perKey  time.Duration
+}
+
+func (d *delayPublic) delay() time.Duration {
+	return d.base + time.Duration(d.signers)*d.perKey
+}
+
+func (d *delayPublic) VerifySignature(msg []byte, s Signature) error {
+	time.Sleep(d.delay())
+	return d.fakePublic.VerifySignature(msg, s)
+}
+
+func (d *delayPublic) Combine(p PublicKey) PublicKey {
+	time.Sleep(d.perKey)
+	signers := 1
+	if other, ok := p.(*delayPublic); ok {
+		signers = other.signers
+	}
+	return &delayPublic{d.fakePublic, d.signers + signers, d.base, d.perKey}
+}
+
+type delayCons struct {
+	fakeCons
+	pub *delayPublic
+}
+
+func (d *delayCons) PublicKey() PublicKey {
+	return d.pub
+}
+
+// constEvaluator gives the same value to all signatures.
+type constEvaluator int
+
+func (c constEvaluator) Evaluate(sp *IncomingSig) int {
+	return int(c)
+}
+
+func TestCostCalibrate(t *testing.T) {
+	pub := &delayPublic{&fakePublic{true}, 1, 4 * time.Millisecond, 2 * time.Millisecond}
+	cons := &delayCons{pub: pub}
+	reg := FakeRegistry(8)
+	cost := CalibrateCost(cons, reg, msg, &fakeSig{true}, pub, 3)
+	require.True(t, cost.Base >= pub.delay())
+	require.True(t, cost.PerKey >= pub.perKey)
+	require.True(t, cost.Base > cost.PerKey)
+	require.Equal(t, cost.Base+10*cost.PerKey, cost.Estimate(10))
+
+	ev := NewCostEvaluator(&Evaluator1{}, cost)
+	values := ev.Values()
+	require.Equal(t, float64(cost.Base.Nanoseconds()), values["costBase"])
+	require.Equal(t, float64(cost.PerKey.Nanoseconds()), values["costPerKey"])
+
+	// equally valuable candidates aggregating more signers take longer to
+	// verify with the backend, and are ranked lower by the evaluator
+	lvl := 4
+	ev = NewCostEvaluator(constEvaluator(1000), cost)
+	var prevTime time.Duration
+	var prevScore int
+	for i, signers := range []int{1, 2, 4, 8} {
+		bs := NewWilffBitset(8)
+		aggregate := PublicKey(pub)
+		for j := 0; j < signers; j++ {
+			bs.Set(j, true)
+			if j > 0 {
+				aggregate = aggregate.Combine(pub)
+			}
+		}
+		start := time.Now()
+		require.NoError(t, aggregate.VerifySignature(msg, &fakeSig{true}))
+		took := time.Since(start)
+		score := ev.Evaluate(&IncomingSig{level: byte(lvl), ms: newSig(bs)})
+		if i > 0 {
+			require.True(t, took > prevTime, "%d signers: %s <= %s", signers, took, prevTime)
+			require.True(t, score < prevScore, "%d signers: %d >= %d", signers, score, prevScore)
+		}
+		prevTime, prevScore = took, score
+	}
+}
+
+func TestCostEvaluatorWorkload(t *testing.T) {
+	n := 16
+	lvl := 4
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	size := part.Size(lvl)
+	cost := &VerifyCost{Base: 10 * time.Millisecond, PerKey: 1 * time.Millisecond}
+
+	individual := func(idx int) *IncomingSig {
+		bs := NewWilffBitset(size)
+		bs.Set(idx, true)
+		return &IncomingSig{
+			origin:      int32(size + idx),
+			level:       byte(lvl),
+			ms:          newSig(bs),
+			isInd:       true,
+			mappedIndex: idx,
+		}
+	}
+
+	// run processes a scripted workload: all individual signatures but the
+	// last one are already verified, and both the aggregate signature and the
+	// last individual signature complete the level. It returns the first
+	// signature verified and the total simulated verification time.
+	run := func(newEvaluator func(SignatureStore) SigEvaluator) (*IncomingSig, time.Duration) {
+		store := newStore(part, NewWilffBitset, new(fakeCons))
+		for i := 0; i < size-1; i++ {
+			store.Store(individual(i))
+		}
+		proc := newEvaluatorProcessing(part, new(fakeCons), msg, 0, false, 0, newEvaluator(store), DefaultLogger).(*evaluatorProcessing)
+		aggregate := fullIncomingSig(lvl)
+		last := individual(size - 1)
+		proc.Add(aggregate)
+		proc.Add(last)
+
+		var first *IncomingSig
+		var total time.Duration
+		for proc.hasTodos() {
+			_, best := proc.readTodos()
+			if best == nil {
+				break
+			}
+			if first == nil {
+				first = best
+			}
+			total += cost.Estimate(best.ms.Cardinality())
+			store.Store(best)
+		}
+		best, _ := store.Best(byte(lvl))
+		require.Equal(t, size, best.Cardinality())
+		return first, total
+	}
+
+	valueFirst, valueTotal := run(func(s SignatureStore) SigEvaluator {
+		return newEvaluatorStore(s)
+	})
+	costFirst, costTotal := run(func(s SignatureStore) SigEvaluator {
+		return NewCostEvaluator(newEvaluatorStore(s), cost)
+	})
+
+	// pure value picks the aggregate, value/cost picks the individual sig
+	require.False(t, valueFirst.Individual())
+	require.True(t, costFirst.Individual())
+	require.Equal(t, cost.Estimate(size), valueTotal)
+	require.Equal(t, cost.Estimate(1), costTotal)
+	require.True(t, costTotal < valueTotal)
+}
@@ -31,6 +31,17 @@ type SecretKey interface {
 	Sign(msg []byte, r io.Reader) (Signature, error)
 }
 
+// DHSecretKey is a SecretKey able to derive a secret shared with the owner of
+// a public key, as in a Diffie-Hellman exchange: the secret a node derives
+// from the public key of a peer is the one the peer derives from the public
+// key of the node. It is optional, see network.NewEncryptedNetwork.
+type DHSecretKey interface {
+	SecretKey
+	// DiffieHellman returns the secret shared with the owner of the public
+	// key
+	DiffieHellman(peer PublicKey) ([]byte, error)
+}
+
 // Constructor creates empty signatures of the required type suitable for
 // unmarshalling and empty public keys of the required type suitable for
 // aggregation. See package bn256 for an example.
@@ -84,23 +95,25 @@ func (m *MultiSignature) MarshalBinary() ([]byte, error) {
 // Unmarshal reads a multisignature from the given slice, using the *empty*
 // signature and bitset interface given.
 func (m *MultiSignature) Unmarshal(b []byte, s Signature, nbs func(b int) BitSet) error {
-	var buff = bytes.NewBuffer(b)
-	var length uint16
-	if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
-		return err
+	// parsed in place, the hot path of the packets must not allocate
+	switch len(b) {
+	case 0:
+		return io.EOF
+	case 1:
+		return io.ErrUnexpectedEOF
 	}
-
-	bitset := buff.Next(int(length))
-	if len(bitset) < int(length) {
+	length := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < length {
 		return errors.New("bitset received smaller than expected")
 	}
 
-	bs := nbs(int(length))
-	if err := bs.UnmarshalBinary(bitset); err != nil {
+	bs := nbs(length)
+	if err := bs.UnmarshalBinary(b[:length]); err != nil {
 		return err
 	}
 
-	if err := s.UnmarshalBinary(buff.Bytes()); err != nil {
+	if err := s.UnmarshalBinary(b[length:]); err != nil {
 		return err
 	}
 
@@ -118,20 +131,45 @@ func (m *MultiSignature) String() string {
 // aggregating all public keys from the registry. It returns nil if the
 // verification was sucessful, an error otherwise.
 func VerifyMultiSignature(msg []byte, ms *MultiSignature, reg Registry, cons Constructor) error {
-	n := ms.BitSet.BitLength()
-	if n != reg.Size() {
-		return errors.New("verify multisignature: inconsistent sizes")
+	_, err := VerifyAndExtract(msg, ms, reg, cons)
+	return err
+}
+
+// ContributingIdentities returns the identities of the registry whose bits
+// are set in the bitset of the multisignature, in the order of the registry.
+// The bitset must index the whole registry. The signature itself is not
+// verified, see VerifyAndExtract.
+func ContributingIdentities(ms *MultiSignature, reg Registry) ([]Identity, error) {
+	if n := ms.BitSet.BitLength(); n != reg.Size() {
+		return nil, fmt.Errorf("verify multisignature: inconsistent sizes, bitset of %d for a registry of %d", n, reg.Size())
 	}
-	aggregate := cons.PublicKey()
-	for i := 0; i < n; i++ {
-		if ms.BitSet.Get(i) {
-			id, ok := reg.Identity(i)
-			if !ok {
-				return fmt.Errorf("registry returned empty identity at %d", i)
-			}
-			aggregate = aggregate.Combine(id.PublicKey())
+	ids := make([]Identity, 0, ms.BitSet.Cardinality())
+	for i, ok := ms.BitSet.NextSet(0); ok; i, ok = ms.BitSet.NextSet(i + 1) {
+		id, ok := reg.Identity(i)
+		if !ok {
+			return nil, fmt.Errorf("registry returned empty identity at %d", i)
 		}
+		ids = append(ids, id)
 	}
+	return ids, nil
+}
 
-	return aggregate.VerifySignature(msg, ms.Signature)
+// VerifyAndExtract verifies the multisignature as VerifyMultiSignature does
+// and returns the identities which contributed to it, as
+// ContributingIdentities does. The identities are only read once from the
+// registry, and their public keys only aggregated once. No identity is
+// returned if the signature is invalid.
+func VerifyAndExtract(msg []byte, ms *MultiSignature, reg Registry, cons Constructor) ([]Identity, error) {
+	ids, err := ContributingIdentities(ms, reg)
+	if err != nil {
+		return nil, err
+	}
+	aggregate := cons.PublicKey()
+	for _, id := range ids {
+		aggregate = aggregate.Combine(id.PublicKey())
+	}
+	if err := aggregate.VerifySignature(msg, ms.Signature); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
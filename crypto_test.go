@@ -22,3 +22,64 @@ func TestMultiSignatureMarshalling(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestContributingIdentities(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	var tests = []struct {
+		bits []int
+		size int
+		err  bool
+	}{
+		{[]int{0, 3, 7}, n, false},
+		{[]int{5}, n, false},
+		{nil, n, false},
+		{[]int{0, 1, 2, 3, 4, 5, 6, 7}, n, false},
+		{[]int{0, 3}, n - 1, true},
+		{[]int{0, 3}, n + 1, true},
+	}
+	for i, test := range tests {
+		ms := newSig(bitsOf(test.size, test.bits...))
+		ids, err := ContributingIdentities(ms, reg)
+		if test.err {
+			require.Error(t, err, "test %d", i)
+			continue
+		}
+		require.NoError(t, err, "test %d", i)
+		require.Len(t, ids, len(test.bits))
+		for j, id := range ids {
+			require.Equal(t, int32(test.bits[j]), id.ID())
+		}
+	}
+}
+
+func TestVerifyAndExtract(t *testing.T) {
+	n := 8
+	// the key of the identity 5 verifies no signature
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = &fakeIdentity{int32(i), &fakePublic{i != 5}}
+	}
+	reg := NewArrayRegistry(ids)
+	cons := new(fakeCons)
+
+	ms := newSig(bitsOf(n, 1, 2, 6))
+	contributors, err := VerifyAndExtract(msg, ms, reg, cons)
+	require.NoError(t, err)
+	require.Equal(t, []Identity{ids[1], ids[2], ids[6]}, contributors)
+	require.NoError(t, VerifyMultiSignature(msg, ms, reg, cons))
+
+	// an invalid signature returns no identity
+	ms = newSig(bitsOf(n, 1, 5))
+	contributors, err = VerifyAndExtract(msg, ms, reg, cons)
+	require.Error(t, err)
+	require.Nil(t, contributors)
+	require.Error(t, VerifyMultiSignature(msg, ms, reg, cons))
+	invalid := &MultiSignature{BitSet: bitsOf(n, 1), Signature: &fakeSig{false}}
+	_, err = VerifyAndExtract(msg, invalid, reg, cons)
+	require.Error(t, err)
+
+	// the bitset must index the whole registry
+	_, err = VerifyAndExtract(msg, newSig(bitsOf(n/2, 1)), reg, cons)
+	require.Error(t, err)
+}
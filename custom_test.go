@@ -0,0 +1,94 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the signatures stored
+type countingStore struct {
+	SignatureStore
+	sync.Mutex
+	stored int
+}
+
+func (c *countingStore) Store(sp *IncomingSig) *MultiSignature {
+	c.Lock()
+	c.stored++
+	c.Unlock()
+	return c.SignatureStore.Store(sp)
+}
+
+func (c *countingStore) Values() map[string]float64 {
+	c.Lock()
+	defer c.Unlock()
+	return map[string]float64{"stored": float64(c.stored)}
+}
+
+// countingProcessing counts the signatures added, without reporting any value
+type countingProcessing struct {
+	SignatureProcessing
+	sync.Mutex
+	added int
+}
+
+func (c *countingProcessing) Add(sp *IncomingSig) {
+	c.Lock()
+	c.added++
+	c.Unlock()
+	c.SignatureProcessing.Add(sp)
+}
+
+func TestHandelCustomStoreProcessing(t *testing.T) {
+	n := 8
+	var lock sync.Mutex
+	var stores []*countingStore
+	var procs []*countingProcessing
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = &warnLogger{}
+	config.NewStore = func(part Partitioner, nbs func(int) BitSet, c Constructor) SignatureStore {
+		lock.Lock()
+		defer lock.Unlock()
+		s := &countingStore{SignatureStore: DefaultStore(part, nbs, c)}
+		stores = append(stores, s)
+		return s
+	}
+	config.NewProcessing = func(part Partitioner, c Constructor, msg []byte, e SigEvaluator, log Logger) SignatureProcessing {
+		lock.Lock()
+		defer lock.Unlock()
+		p := &countingProcessing{SignatureProcessing: newFifoProcessing(e.(*EvaluatorStore).store, part, c, msg)}
+		procs = append(procs, p)
+		return p
+	}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	require.Len(t, stores, n)
+	require.Len(t, procs, n)
+	report := NewReportHandel(handels[0])
+
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("node %d: no final signature", i)
+		}
+	}
+
+	procs[0].Lock()
+	require.True(t, procs[0].added > 0)
+	procs[0].Unlock()
+	// the custom store values are reported, the processing has none
+	values := report.Store().Values()
+	require.True(t, values["stored"] > 1)
+	require.True(t, values["successReplace"] > 0)
+	require.Empty(t, report.Processing().Values())
+	require.NotContains(t, report.Values(), "net_sent")
+}
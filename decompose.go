@@ -0,0 +1,132 @@
+package handel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The full multi-signatures, as returned by CombineFull, and the signatures
+// of the levels of a node convert into each other with DecomposeFull and
+// RecomposeFull. The bits of the levels are placed in the full bitset as
+// CombineFull places them.
+//
+// A multi-signature can't be split: the signatures of the levels returned by
+// DecomposeFull all carry the signature of the full one, which is only valid
+// for the union of their bitsets. They are flagged as such, see
+// IncomingSig.Decomposed, and must be verified as a whole, once recomposed
+// with RecomposeFull, never one by one.
+
+// errDecomposedVerified is the error of the verification of a decomposed
+// signature alone
+var errDecomposedVerified = errors.New("handel: decomposed signature verified alone, verify the full signature")
+
+// fullLayout returns the length of the bitsets of the full signatures of the
+// partitioner and the offset of the global indexes in them: the bit of a
+// global index is at the global index minus the offset.
+func fullLayout(part Partitioner, nbs func(int) BitSet) (length, offset int, err error) {
+	self, err := part.GlobalOf(0, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	own := nbs(1)
+	own.Set(0, true)
+	probe := part.CombineFull([]*IncomingSig{{ms: &MultiSignature{BitSet: own}}}, nbs)
+	if probe == nil {
+		return 0, 0, errors.New("handel: no full signature from the partitioner")
+	}
+	pos, ok := probe.BitSet.NextSet(0)
+	if !ok {
+		return 0, 0, errors.New("handel: own contribution missing from the full signature")
+	}
+	return probe.BitLength(), self - pos, nil
+}
+
+// DecomposeFull splits the bitset of the full multi-signature into the
+// bitsets of the levels of the partitioner, the level 0 included. The levels
+// without any contribution are left out. The signatures returned all carry
+// the signature of the full one and are decomposed: they must be recomposed
+// with RecomposeFull to be verified. It returns an error if the bitset is
+// not of the length of the full signatures of the partitioner, or has
+// contributions outside of its levels.
+func DecomposeFull(ms *MultiSignature, part Partitioner, nbs func(int) BitSet) ([]*IncomingSig, error) {
+	length, offset, err := fullLayout(part, nbs)
+	if err != nil {
+		return nil, err
+	}
+	if ms.BitLength() != length {
+		return nil, fmt.Errorf("handel: full bitset of length %d instead of %d", ms.BitLength(), length)
+	}
+	var sigs []*IncomingSig
+	placed := 0
+	for _, level := range append([]int{0}, part.Levels()...) {
+		bs := nbs(part.Size(level))
+		for pos := 0; pos < bs.BitLength(); pos++ {
+			global, err := part.GlobalOf(level, pos)
+			if err != nil {
+				return nil, err
+			}
+			if ms.Get(global - offset) {
+				bs.Set(pos, true)
+				placed++
+			}
+		}
+		if bs.None() {
+			continue
+		}
+		sigs = append(sigs, &IncomingSig{
+			level:      byte(level),
+			ms:         &MultiSignature{BitSet: bs, Signature: ms.Signature},
+			decomposed: true,
+		})
+	}
+	if placed != ms.Cardinality() {
+		return nil, fmt.Errorf("handel: %d contributions outside of the levels", ms.Cardinality()-placed)
+	}
+	return sigs, nil
+}
+
+// RecomposeFull is the inverse of DecomposeFull: it places the bitsets of the
+// signatures of the levels in the full bitset as CombineFull does. The
+// signatures of a decomposition share the signature of the full one, which
+// is kept as is, while the signatures of the levels which are not decomposed
+// are combined. It returns an error if there is no signature, if two of them
+// are of the same level or don't have the size of their level, or if the
+// decomposed signatures are mixed with the others.
+func RecomposeFull(sigs []*IncomingSig, part Partitioner, nbs func(int) BitSet) (*MultiSignature, error) {
+	if len(sigs) == 0 {
+		return nil, errors.New("handel: no signature to recompose")
+	}
+	length, offset, err := fullLayout(part, nbs)
+	if err != nil {
+		return nil, err
+	}
+	full := &MultiSignature{BitSet: nbs(length)}
+	levels := make(map[byte]bool, len(sigs))
+	for _, s := range sigs {
+		if s.decomposed != sigs[0].decomposed {
+			return nil, errors.New("handel: decomposed signatures mixed with others")
+		}
+		if levels[s.level] {
+			return nil, fmt.Errorf("handel: several signatures of level %d", s.level)
+		}
+		levels[s.level] = true
+		bs := s.ms.BitSet
+		if size := part.Size(int(s.level)); bs.BitLength() != size {
+			return nil, fmt.Errorf("handel: bitset of length %d at level %d of size %d", bs.BitLength(), s.level, size)
+		}
+		for pos, ok := bs.NextSet(0); ok; pos, ok = bs.NextSet(pos + 1) {
+			global, err := part.GlobalOf(int(s.level), pos)
+			if err != nil {
+				return nil, err
+			}
+			full.Set(global-offset, true)
+		}
+		switch {
+		case full.Signature == nil:
+			full.Signature = s.ms.Signature
+		case !s.decomposed:
+			full.Signature = full.Signature.Combine(s.ms.Signature)
+		}
+	}
+	return full, nil
+}
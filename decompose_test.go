@@ -0,0 +1,141 @@
+package handel
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countSig is a signature counting the signatures combined into it
+type countSig struct {
+	fakeSig
+	count int
+}
+
+func (c *countSig) Combine(s Signature) Signature {
+	return &countSig{count: c.count + s.(*countSig).count}
+}
+
+// decomposeSizes are the sizes of the registries the decompositions are
+// tested with, powers of two or not
+var decomposeSizes = []int{1, 2, 3, 4, 5, 7, 8, 9, 13, 16, 17, 32, 33}
+
+// randomBitset returns a bitset of the given length with random bits set, at
+// least one
+func randomBitset(r *rand.Rand, length int) BitSet {
+	bs := NewWilffBitset(length)
+	for i := 0; i < length; i++ {
+		bs.Set(i, r.Intn(2) == 0)
+	}
+	bs.Set(r.Intn(length), true)
+	return bs
+}
+
+// levelSigs returns random signatures of the levels of the partitioner, at
+// least one
+func levelSigs(r *rand.Rand, part Partitioner) []*IncomingSig {
+	var sigs []*IncomingSig
+	for _, level := range append([]int{0}, part.Levels()...) {
+		if r.Intn(3) == 0 && len(sigs) > 0 {
+			continue
+		}
+		sigs = append(sigs, &IncomingSig{
+			level: byte(level),
+			ms:    &MultiSignature{BitSet: randomBitset(r, part.Size(level)), Signature: &countSig{count: 1}},
+		})
+	}
+	return sigs
+}
+
+// requireSameLevels checks the signatures have the same levels and bitsets
+func requireSameLevels(t *testing.T, exp, sigs []*IncomingSig) {
+	require.Len(t, sigs, len(exp))
+	for i := range exp {
+		require.Equal(t, exp[i].level, sigs[i].level)
+		require.Equal(t, exp[i].ms.BitSet.String(), sigs[i].ms.BitSet.String(), "level %d", exp[i].level)
+	}
+}
+
+func TestDecomposeFull(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for _, n := range decomposeSizes {
+		reg := FakeRegistry(n)
+		for id := 0; id < n; id++ {
+			parts := []Partitioner{NewBinPartitioner(int32(id), reg, nil)}
+			if max := NewBinPartitioner(int32(id), reg, nil).MaxLevel(); max > 1 {
+				capped := capPartitioner(&Config{MaxAggregationLevel: max - 1}, parts[0], int32(id), n)
+				parts = append(parts, capped)
+			}
+			for _, part := range parts {
+				length, _, err := fullLayout(part, NewWilffBitset)
+				require.NoError(t, err)
+
+				// the full bitsets are the same once decomposed and
+				// recomposed
+				full := &MultiSignature{BitSet: randomBitset(r, length), Signature: &countSig{count: 1}}
+				sigs, err := DecomposeFull(full, part, NewWilffBitset)
+				require.NoError(t, err, "n %d id %d", n, id)
+				for _, s := range sigs {
+					require.True(t, s.Decomposed())
+					require.Equal(t, full.Signature, s.ms.Signature)
+				}
+				ms, err := RecomposeFull(sigs, part, NewWilffBitset)
+				require.NoError(t, err)
+				require.Equal(t, full.BitSet.String(), ms.BitSet.String(), "n %d id %d", n, id)
+				// the signature of the full one is not combined with itself
+				require.Equal(t, full.Signature, ms.Signature)
+
+				// the signatures of the levels are the same once recomposed
+				// and decomposed, and they are placed as CombineFull does
+				levels := levelSigs(r, part)
+				ms, err = RecomposeFull(levels, part, NewWilffBitset)
+				require.NoError(t, err)
+				require.Equal(t, len(levels), ms.Signature.(*countSig).count)
+				exp := part.CombineFull(levels, NewWilffBitset)
+				require.Equal(t, exp.BitSet.String(), ms.BitSet.String(), "n %d id %d", n, id)
+				sigs, err = DecomposeFull(ms, part, NewWilffBitset)
+				require.NoError(t, err)
+				requireSameLevels(t, levels, sigs)
+			}
+		}
+	}
+}
+
+func TestDecomposeFullErrors(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(3, reg, nil)
+	full := &MultiSignature{BitSet: finalBitset(n), Signature: &fakeSig{true}}
+	sigs, err := DecomposeFull(full, part, NewWilffBitset)
+	require.NoError(t, err)
+	require.Len(t, sigs, len(part.Levels())+1)
+
+	// the bitset is not a full one
+	_, err = DecomposeFull(&MultiSignature{BitSet: finalBitset(n - 1)}, part, NewWilffBitset)
+	require.Error(t, err)
+	// the capped partitioner has no level for the contributions outside of
+	// the subtree
+	capped := capPartitioner(&Config{MaxAggregationLevel: 2}, part, 3, n)
+	_, err = DecomposeFull(full, capped, NewWilffBitset)
+	require.Error(t, err)
+
+	_, err = RecomposeFull(nil, part, NewWilffBitset)
+	require.Error(t, err)
+	// several signatures of a level
+	_, err = RecomposeFull([]*IncomingSig{sigs[1], sigs[1]}, part, NewWilffBitset)
+	require.Error(t, err)
+	// a bitset of the size of another level
+	wrong := &IncomingSig{level: 2, ms: sigs[1].ms}
+	_, err = RecomposeFull([]*IncomingSig{wrong}, part, NewWilffBitset)
+	require.Error(t, err)
+	// the decomposed signatures are not combined with the others
+	other := &IncomingSig{level: 1, ms: sigs[1].ms}
+	_, err = RecomposeFull([]*IncomingSig{sigs[0], other}, part, NewWilffBitset)
+	require.Error(t, err)
+
+	// the signatures decomposed are only verified recomposed
+	err = VerifyIncomingSig(sigs[1], msg, part, new(fakeCons))
+	require.Equal(t, errDecomposedVerified, err)
+	require.NoError(t, VerifyIncomingSig(other, msg, part, new(fakeCons)))
+}
@@ -0,0 +1,11 @@
+// Package handel implements the Handel protocol, aggregating the signatures of
+// a large number of nodes over a message.
+//
+// Handel exchanges decoded Packets over a Network. An application which only
+// has a byte oriented networking stack uses a Transport instead, see
+// NewHandelWithTransport: the Transport is adapted to a Network, and not the
+// other way around, as the Networks deliver decoded Packets in their own
+// encoding, so running them under a Transport would encode and decode each
+// packet once more for nothing. The adapter is the only place dealing with
+// bytes.
+package handel
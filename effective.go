@@ -0,0 +1,127 @@
+package handel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// configFuncs names the known functions of a Config by code pointer, see
+// RegisterConfigFunc
+var configFuncs = struct {
+	sync.RWMutex
+	names map[uintptr]string
+}{names: make(map[uintptr]string)}
+
+func init() {
+	RegisterConfigFunc("wilff", DefaultBitSet)
+	// the bitsets recycled by the sessions, see Session.NewAggregation
+	RegisterConfigFunc("wilff", NewBitSetPool().New)
+	RegisterConfigFunc("raw", NewRawBitset)
+	RegisterConfigFunc("bin", DefaultPartitioner)
+	RegisterConfigFunc("bin", NewBinPartitioner)
+	RegisterConfigFunc("default", DefaultStore)
+	RegisterConfigFunc("store", DefaultEvaluatorStrategy)
+	RegisterConfigFunc("cost", CostEvaluatorStrategy)
+	RegisterConfigFunc("linear", DefaultTimeoutStrategy)
+	RegisterConfigFunc("linear", NewDefaultLinearTimeout)
+	// all the constructors returned share the code of their closure
+	RegisterConfigFunc("linear", LinearTimeoutConstructor(0))
+	RegisterConfigFunc("scheduled", ScheduledTimeoutConstructor(nil))
+	RegisterConfigFunc("proportional", ProportionalUpdateCount(0, 0))
+}
+
+// RegisterConfigFunc names a function assigned to the fields of a Config,
+// such as a custom NewPartitioner, in Config.Describe. The closures returned
+// by a same function share its name, whatever they capture.
+func RegisterConfigFunc(name string, fn interface{}) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic(fmt.Sprintf("handel: %T is not a function", fn))
+	}
+	configFuncs.Lock()
+	defer configFuncs.Unlock()
+	configFuncs.names[v.Pointer()] = name
+}
+
+// funcName returns the name of the function, "none" if nil and "custom" if
+// not registered
+func funcName(v reflect.Value) string {
+	if v.IsNil() {
+		return "none"
+	}
+	configFuncs.RLock()
+	defer configFuncs.RUnlock()
+	if name, ok := configFuncs.names[v.Pointer()]; ok {
+		return name
+	}
+	return "custom"
+}
+
+// EffectiveConfig returns a copy of the configuration Handel runs with, i.e.
+// the one given to NewHandel with the defaults filled in, and the current
+// values of the settings changed at runtime.
+func (h *Handel) EffectiveConfig() Config {
+	c := *h.c
+	c.Groups = append([]int(nil), h.c.Groups...)
+	c.PartitionerSeed = append([]byte(nil), h.c.PartitionerSeed...)
+	c.LogLevel = h.LogLevel()
+	c.StatusLogPeriod = h.StatusLogPeriod()
+	c.QueueCapacity = h.QueueCapacity()
+	return c
+}
+
+// Describe returns the value of each field of the config by name, for the
+// logs: the functions are named as registered with RegisterConfigFunc, or
+// "custom", and the other fields without a printable value, such as the
+// Logger, by their type.
+func (c *Config) Describe() map[string]string {
+	desc := make(map[string]string)
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		switch f.Kind() {
+		case reflect.Func:
+			desc[name] = funcName(f)
+		case reflect.Interface, reflect.Ptr:
+			if f.IsNil() {
+				desc[name] = "none"
+			} else {
+				desc[name] = fmt.Sprintf("%T", f.Interface())
+			}
+		default:
+			desc[name] = fmt.Sprintf("%v", f.Interface())
+		}
+	}
+	// the partitioner modes build their own partitioner
+	switch c.PartitionerMode {
+	case PartitionerSharedSeed:
+		desc["NewPartitioner"] = "random-bin"
+	case PartitionerSalted:
+		desc["NewPartitioner"] = "random-bin-salted"
+	}
+	return desc
+}
+
+// Fingerprint returns a hash of the fields of the config which are neither
+// functions nor objects, such as the Logger, so the runs of a same config
+// can be told apart from the others. It is stable across the processes and
+// the versions of Handel which keep these fields.
+func (c *Config) Fingerprint() string {
+	v := reflect.ValueOf(c).Elem()
+	var fields []string
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Func, reflect.Interface, reflect.Ptr:
+		default:
+			fields = append(fields, fmt.Sprintf("%s=%v", v.Type().Field(i).Name, f.Interface()))
+		}
+	}
+	sort.Strings(fields)
+	h := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(h[:8])
+}
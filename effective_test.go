@@ -0,0 +1,92 @@
+package handel
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	_, handels := FakeSetupWith(8, func(c *Config) {
+		c.NewPartitioner = nil
+		c.UpdateCount = 3
+		c.NewTimeoutStrategy = LinearTimeoutConstructor(time.Second)
+		c.NewEvaluatorStrategy = func(SignatureStore, *Handel) SigEvaluator { return new(Evaluator1) }
+	})
+	defer CloseHandels(handels)
+	h := handels[0]
+	c := h.EffectiveConfig()
+	// the defaults are visible
+	require.Equal(t, PercentageToContributions(DefaultContributionsPerc, 8), c.Contributions)
+	require.Equal(t, DefaultCandidateCount, c.FastPath)
+	require.Equal(t, DefaultUpdatePeriod, c.UpdatePeriod)
+	require.Equal(t, UpdateCombinedPrefix, c.UpdatePayload)
+	require.Equal(t, DefaultShedPolicy, c.Shedding)
+	// the overrides survive
+	require.Equal(t, 3, c.UpdateCount)
+
+	desc := c.Describe()
+	require.Equal(t, "wilff", desc["NewBitSet"])
+	require.Equal(t, "bin", desc["NewPartitioner"])
+	require.Equal(t, "default", desc["NewStore"])
+	require.Equal(t, "linear", desc["NewTimeoutStrategy"])
+	require.Equal(t, "custom", desc["NewEvaluatorStrategy"])
+	require.Equal(t, "none", desc["NewProcessing"])
+	require.Equal(t, "3", desc["UpdateCount"])
+	require.Equal(t, "10ms", desc["UpdatePeriod"])
+
+	// the settings changed at runtime are visible
+	h.SetLogLevel(LogWarn)
+	require.Equal(t, LogWarn, h.EffectiveConfig().LogLevel)
+
+	RegisterConfigFunc("evaluator1", c.NewEvaluatorStrategy)
+	require.Equal(t, "evaluator1", c.Describe()["NewEvaluatorStrategy"])
+	c.NewEvaluatorStrategy = CostEvaluatorStrategy
+	require.Equal(t, "cost", c.Describe()["NewEvaluatorStrategy"])
+	c.PartitionerMode = PartitionerSalted
+	require.Equal(t, "random-bin-salted", c.Describe()["NewPartitioner"])
+	require.Panics(t, func() { RegisterConfigFunc("answer", 42) })
+
+	// the aggregations of a session recycle the wilff bitsets
+	c.NewBitSet = NewBitSetPool().New
+	require.Equal(t, "wilff", c.Describe()["NewBitSet"])
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	c := mergeWithDefault(DefaultConfig(10), 10)
+	fp := c.Fingerprint()
+	require.Len(t, fp, 16)
+	c2 := *c
+	require.Equal(t, fp, c2.Fingerprint())
+	// the functions and the objects are not part of it
+	c2.NewEvaluatorStrategy = CostEvaluatorStrategy
+	c2.Logger = nil
+	require.Equal(t, fp, c2.Fingerprint())
+
+	// any numeric field changes it
+	v := reflect.ValueOf(c).Elem()
+	numeric := 0
+	for i := 0; i < v.NumField(); i++ {
+		c2 := *c
+		f := reflect.ValueOf(&c2).Elem().Field(i)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			f.SetInt(f.Int() + 1)
+		case reflect.Float64:
+			f.SetFloat(f.Float() + 0.125)
+		default:
+			continue
+		}
+		numeric++
+		require.NotEqual(t, fp, c2.Fingerprint(), v.Type().Field(i).Name)
+	}
+	require.True(t, numeric > 20)
+	c2 = *c
+	c2.Shedding.NotBetter = 0.5
+	require.NotEqual(t, fp, c2.Fingerprint())
+	c2 = *c
+	c2.BroadcastCompletion = true
+	require.NotEqual(t, fp, c2.Fingerprint())
+}
@@ -0,0 +1,130 @@
+package handel
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Efficiency reports how useful the verified signatures were: a verification
+// is useful when storing the signature increased the cardinality of the full
+// signature, and redundant otherwise. It is computed at store time, so it
+// takes into account the merges with the best signatures and the individual
+// signatures already verified.
+type Efficiency struct {
+	// Useful is the number of verified signatures that increased the
+	// cardinality of the full signature
+	Useful int
+	// Redundant is the number of verified signatures that did not
+	Redundant int
+	// Added is the histogram of the number of contributions added to the full
+	// signature per verification. The buckets are powers of two: the key k
+	// counts the verifications adding between k and 2k-1 contributions, the
+	// key 0 counts the redundant ones.
+	Added map[int]int
+}
+
+// UsefulRatio returns the fraction of the verified signatures that were
+// useful, 0 if no signature was verified.
+func (e Efficiency) UsefulRatio() float64 {
+	total := e.Useful + e.Redundant
+	if total == 0 {
+		return 0
+	}
+	return float64(e.Useful) / float64(total)
+}
+
+// efficiency accounts the verified signatures as they are stored
+type efficiency struct {
+	sync.Mutex
+	Efficiency
+	// cardinality of the best signature of each level after the last store
+	cards map[byte]int
+	// true once disabled by the observability budget
+	disabled bool
+}
+
+func newEfficiency() *efficiency {
+	return &efficiency{
+		Efficiency: Efficiency{Added: make(map[int]int)},
+		cards:      make(map[byte]int),
+	}
+}
+
+// stored accounts the given verified signature, which has just been given to
+// the store. Since the levels are disjoint, the contributions it added to the
+// full signature are the ones added to the best signature of its level.
+func (e *efficiency) stored(sp *IncomingSig, s SignatureStore) {
+	card := 0
+	if ms, ok := s.Best(sp.level); ok && ms != nil {
+		card = ms.Cardinality()
+	}
+	e.Lock()
+	defer e.Unlock()
+	if e.disabled {
+		return
+	}
+	added := card - e.cards[sp.level]
+	e.cards[sp.level] = card
+	if added <= 0 {
+		e.Redundant++
+		e.Added[0]++
+		return
+	}
+	e.Useful++
+	e.Added[bucket(added)]++
+}
+
+// bucket returns the largest power of two lower or equal to n
+func bucket(n int) int {
+	b := 1
+	for b*2 <= n {
+		b *= 2
+	}
+	return b
+}
+
+// approxSize implements the collector interface
+func (e *efficiency) approxSize() int {
+	e.Lock()
+	defer e.Unlock()
+	return 64 + 16*(len(e.Added)+len(e.cards))
+}
+
+// disable implements the collector interface. The accounting stops where it
+// is.
+func (e *efficiency) disable() {
+	e.Lock()
+	defer e.Unlock()
+	e.disabled = true
+}
+
+func (e *efficiency) snapshot() Efficiency {
+	e.Lock()
+	defer e.Unlock()
+	added := make(map[int]int, len(e.Added))
+	for k, v := range e.Added {
+		added[k] = v
+	}
+	return Efficiency{Useful: e.Useful, Redundant: e.Redundant, Added: added}
+}
+
+// Values returns the useful and redundant verifications, their ratio and the
+// histogram of the contributions added, one value per bucket.
+func (e *efficiency) Values() map[string]float64 {
+	eff := e.snapshot()
+	values := map[string]float64{
+		"useful":    float64(eff.Useful),
+		"redundant": float64(eff.Redundant),
+		"ratio":     eff.UsefulRatio(),
+	}
+	for k, v := range eff.Added {
+		values["added_"+strconv.Itoa(k)] = float64(v)
+	}
+	return values
+}
+
+// Efficiency returns the accounting of the useful and redundant signatures
+// verified so far.
+func (h *Handel) Efficiency() Efficiency {
+	return h.efficiency.snapshot()
+}
@@ -0,0 +1,72 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEfficiencyScripted(t *testing.T) {
+	n := 16
+	part := NewBinPartitioner(0, FakeRegistry(n), DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	// returns a signature of the level with the given bits set
+	sig := func(level int, bits ...int) *IncomingSig {
+		bs := NewWilffBitset(part.Size(level))
+		for _, b := range bits {
+			bs.Set(b, true)
+		}
+		return &IncomingSig{level: byte(level), ms: newSig(bs)}
+	}
+	e := newEfficiency()
+	// half of the verified signatures are redundant
+	for _, sp := range sigs(
+		sig(3, 0, 1, 2, 3), // useful, 4 added
+		sig(3, 0, 1, 2, 3), // redundant, duplicate
+		sig(4, 0, 1, 2),    // useful, 3 added
+		sig(4, 0, 1),       // redundant, subset
+		sig(1, 0),          // useful, 1 added
+		sig(1, 0),          // redundant, completed level
+	) {
+		store.Store(sp)
+		e.stored(sp, store)
+	}
+	eff := e.snapshot()
+	require.Equal(t, 3, eff.Useful)
+	require.Equal(t, 3, eff.Redundant)
+	require.Equal(t, 0.5, eff.UsefulRatio())
+	require.Equal(t, map[int]int{0: 3, 1: 1, 2: 1, 4: 1}, eff.Added)
+
+	values := e.Values()
+	require.Equal(t, 0.5, values["ratio"])
+	require.Equal(t, 3.0, values["added_0"])
+	require.Equal(t, 1.0, values["added_4"])
+}
+
+func TestEfficiencyHandel(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		go h.Start()
+	}
+	for _, h := range handels {
+		for done := false; !done; {
+			select {
+			case ms := <-h.FinalSignatures():
+				done = ms.Cardinality() == n
+			case <-time.After(5 * time.Second):
+				t.Fatal("no complete signature")
+			}
+		}
+	}
+	var total Efficiency
+	for _, h := range handels {
+		eff := h.Efficiency()
+		total.Useful += eff.Useful
+		total.Redundant += eff.Redundant
+	}
+	ratio := total.UsefulRatio()
+	require.True(t, ratio > 0 && ratio < 1, "ratio %f", ratio)
+}
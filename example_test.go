@@ -0,0 +1,152 @@
+package handel_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/ConsenSys/handel/network"
+	"github.com/ConsenSys/handel/network/udp"
+	"github.com/go-kit/kit/log"
+)
+
+// quiet is a logger that discards everything so the examples output stays
+// deterministic.
+var quiet = handel.NewKitLoggerFrom(log.NewNopLogger())
+
+// generateKeys returns n BLS key pairs and the associated registry. The
+// addresses are given in order to the identities, and can be empty when using
+// an in-memory network.
+func generateKeys(n int, addresses []string) ([]handel.SecretKey, handel.Registry) {
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := 0; i < n; i++ {
+		sk, pk, err := bn256.NewKeyPair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		var addr string
+		if i < len(addresses) {
+			addr = addresses[i]
+		}
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), addr, pk)
+	}
+	return secrets, handel.NewArrayRegistry(ids)
+}
+
+// newHandels creates one Handel instance per network, each signing msg with
+// its own secret key.
+func newHandels(nets []handel.Network, secrets []handel.SecretKey, reg handel.Registry, msg []byte, config *handel.Config) []*handel.Handel {
+	cons := bn256.NewConstructor()
+	handels := make([]*handel.Handel, len(nets))
+	for i := range nets {
+		id, _ := reg.Identity(i)
+		sig, err := secrets[i].Sign(msg, rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		handels[i] = handel.NewHandel(nets[i], reg, id, cons, msg, sig, config)
+	}
+	return handels
+}
+
+// This example runs a two nodes aggregation over an in-memory network using
+// BLS signatures on the BN256 curve.
+func Example() {
+	n := 2
+	msg := []byte("Sun is Shining...")
+	secrets, reg := generateKeys(n, nil)
+
+	config := handel.DefaultConfig(n)
+	// wait for the contributions of every node
+	config.Contributions = n
+	config.Logger = quiet
+	handels := newHandels(handeltest.NewBus(n).Networks(), secrets, reg, msg, config)
+	for _, h := range handels {
+		h.Start()
+	}
+
+	ms := <-handels[0].FinalSignatures()
+	err := handel.VerifyMultiSignature(msg, &ms, reg, bn256.NewConstructor())
+	fmt.Printf("final signature: %d/%d contributions, valid: %v\n", ms.Cardinality(), reg.Size(), err == nil)
+
+	for _, h := range handels {
+		h.Stop()
+	}
+	// Output: final signature: 2/2 contributions, valid: true
+}
+
+// This example shows how to customize the parameters of Handel: the threshold
+// of contributions, the update period and the bitset implementation.
+func ExampleConfig() {
+	n := 8
+	msg := []byte("Sun is Shining...")
+	secrets, reg := generateKeys(n, nil)
+
+	config := &handel.Config{
+		// 75% of the nodes must contribute to the final signature
+		Contributions: handel.PercentageToContributions(75, n),
+		// send an update every 20ms to one node per active level
+		UpdatePeriod: 20 * time.Millisecond,
+		UpdateCount:  1,
+		NewBitSet:    handel.DefaultBitSet,
+		Logger:       quiet,
+	}
+	// all the fields left empty are filled with the default values
+	handels := newHandels(handeltest.NewBus(n).Networks(), secrets, reg, msg, config)
+	for _, h := range handels {
+		h.Start()
+	}
+
+	ms := <-handels[0].FinalSignatures()
+	fmt.Printf("threshold: %d, reached: %v\n", config.Contributions, ms.Cardinality() >= config.Contributions)
+
+	for _, h := range handels {
+		h.Stop()
+	}
+	// Output: threshold: 6, reached: true
+}
+
+// This example runs a two nodes aggregation over UDP on localhost, with each
+// node listening on its own port.
+func Example_udp() {
+	addresses := []string{"127.0.0.1:34501", "127.0.0.1:34502"}
+	n := len(addresses)
+	msg := []byte("Sun is Shining...")
+	secrets, reg := generateKeys(n, addresses)
+
+	nets := make([]handel.Network, n)
+	for i, addr := range addresses {
+		net, err := udp.NewNetwork(addr, network.NewGOBEncoding())
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = net
+	}
+
+	config := handel.DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = quiet
+	handels := newHandels(nets, secrets, reg, msg, config)
+	for _, h := range handels {
+		h.Start()
+	}
+
+	select {
+	case ms := <-handels[1].FinalSignatures():
+		fmt.Printf("final signature: %d/%d contributions\n", ms.Cardinality(), reg.Size())
+	case <-time.After(10 * time.Second):
+		fmt.Println("timeout")
+	}
+
+	// stop Handel first so it does not send anything on a closed network
+	for i, h := range handels {
+		h.Stop()
+		nets[i].(*udp.Network).Stop()
+	}
+	// Output: final signature: 2/2 contributions
+}
@@ -0,0 +1,26 @@
+package handel
+
+// This file exposes internals to the external tests of package handel_test,
+// which can use the real curve implementations without import cycle.
+
+// KeyCache exposes the aggregate public key cache.
+type KeyCache = keyCache
+
+// NewKeyCache exposes newKeyCache.
+func NewKeyCache(c Constructor) *KeyCache { return newKeyCache(c) }
+
+// Aggregate exposes keyCache.aggregate.
+func (k *keyCache) Aggregate(level byte, bs BitSet, ids []Identity) PublicKey {
+	return k.aggregate(level, bs, ids)
+}
+
+// AggregatePublicKey exposes aggregatePublicKey.
+var AggregatePublicKey = aggregatePublicKey
+
+// NewIncomingSig exposes the creation of an IncomingSig.
+func NewIncomingSig(origin int32, level byte, ms *MultiSignature, isInd bool) *IncomingSig {
+	return &IncomingSig{origin: origin, level: level, ms: ms, isInd: isInd}
+}
+
+// VerifySignature exposes verifySignature.
+var VerifySignature = verifySignature
@@ -0,0 +1,182 @@
+package handel
+
+import (
+	"encoding/binary"
+	"io"
+	mathRand "math/rand"
+	"sort"
+	"time"
+)
+
+// fanout spreads the sends of the fast path, when a level gets complete, over
+// Config.FanoutSpread, so the nodes completing a level at the same time don't
+// all send their burst at once. The peers are selected when the level
+// completes, and each one gets the signature of the level as it is when its
+// turn comes: an improvement during the spread replaces the signature of the
+// remaining sends. It is only used with Handel's lock held.
+type fanout struct {
+	spread time.Duration
+	rnd    *mathRand.Rand
+	// sends scheduled, by due time
+	pending []fanoutSend
+	// signals the scheduling routine of new sends
+	wake chan bool
+	// number of sends scheduled, done, and canceled by the end of the
+	// aggregation
+	scheduled, sent, canceled int
+}
+
+// fanoutSend is a send of the fast path scheduled at the given time
+type fanoutSend struct {
+	level int
+	peer  Identity
+	at    time.Time
+}
+
+func newFanout(spread time.Duration, r io.Reader) *fanout {
+	var seed int64
+	if err := binary.Read(r, binary.BigEndian, &seed); err != nil {
+		panic(err)
+	}
+	return &fanout{
+		spread: spread,
+		rnd:    mathRand.New(mathRand.NewSource(seed)),
+		wake:   make(chan bool, 1),
+	}
+}
+
+// enabled returns true if the sends of the fast path are spread
+func (f *fanout) enabled() bool {
+	return f.spread > 0
+}
+
+// hasPending returns true if sends of the level are still scheduled
+func (f *fanout) hasPending(level int) bool {
+	for _, s := range f.pending {
+		if s.level == level {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule spreads the sends to the peers of the level evenly over the
+// spread, from now plus a random jitter of at most one interval between two
+// sends.
+func (f *fanout) schedule(level int, peers []Identity, now time.Time) {
+	step := f.spread / time.Duration(len(peers))
+	start := now.Add(time.Duration(f.rnd.Int63n(int64(step) + 1)))
+	for i, p := range peers {
+		f.pending = append(f.pending, fanoutSend{level: level, peer: p, at: start.Add(time.Duration(i) * step)})
+	}
+	sort.SliceStable(f.pending, func(i, j int) bool {
+		return f.pending[i].at.Before(f.pending[j].at)
+	})
+	f.scheduled += len(peers)
+	select {
+	case f.wake <- true:
+	default:
+	}
+}
+
+// due removes and returns the peers of the sends due at the given time, by
+// level
+func (f *fanout) due(now time.Time) map[int][]Identity {
+	var i int
+	peers := make(map[int][]Identity)
+	for ; i < len(f.pending) && !f.pending[i].at.After(now); i++ {
+		s := f.pending[i]
+		peers[s.level] = append(peers[s.level], s.peer)
+	}
+	f.pending = f.pending[i:]
+	return peers
+}
+
+// next returns the time until the next send, and false if none is scheduled
+func (f *fanout) next(now time.Time) (time.Duration, bool) {
+	if len(f.pending) == 0 {
+		return 0, false
+	}
+	return f.pending[0].at.Sub(now), true
+}
+
+// cancel drops the sends scheduled
+func (f *fanout) cancel() {
+	f.canceled += len(f.pending)
+	f.pending = nil
+}
+
+// sendFastPath sends the signature of the level, which just got complete, to
+// the next Config.FastPath peers of the level, or schedules the sends over
+// Config.FanoutSpread. While sends of the level are scheduled, they carry
+// the latest signature and no other peer is added. The lock must be held.
+func (h *Handel) sendFastPath(l *level) {
+	if !h.fanout.enabled() {
+		h.sendUpdate(l, h.c.FastPath)
+		return
+	}
+	if h.fanout.hasPending(l.id) {
+		return
+	}
+	ms, peers := h.nextUpdate(l, h.c.FastPath)
+	if ms == nil {
+		return
+	}
+	h.bitsets.Put(ms.BitSet)
+	h.fanout.schedule(l.id, peers, h.now())
+}
+
+// flushFanout sends the updates of the fast path due at the given time, with
+// the current signature of their level, and returns the time until the next
+// one, false if none is scheduled.
+func (h *Handel) flushFanout(now time.Time) (time.Duration, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.completed() {
+		h.fanout.cancel()
+		return 0, false
+	}
+	due := h.fanout.due(now)
+	for _, id := range h.ids {
+		peers, ok := due[id]
+		if !ok {
+			continue
+		}
+		ms := h.updateSig(id)
+		if ms == nil {
+			continue
+		}
+		h.sendLevel(h.levels[id], peers, ms)
+		h.fanout.sent += len(peers)
+	}
+	return h.fanout.next(now)
+}
+
+// fanoutLoop runs the sends of the fast path as they are due, until Handel
+// stops.
+func (h *Handel) fanoutLoop() {
+	for {
+		var due <-chan time.Time
+		if wait, ok := h.flushFanout(h.now()); ok {
+			due = time.After(wait)
+		}
+		select {
+		case <-due:
+		case <-h.fanout.wake:
+		case <-h.stopCh:
+			return
+		}
+	}
+}
+
+// fanoutValues returns the number of sends of the fast path scheduled, done,
+// and canceled by the end of the aggregation
+func (h *Handel) fanoutValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	return map[string]float64{
+		"scheduled": float64(h.fanout.scheduled),
+		"sent":      float64(h.fanout.sent),
+		"canceled":  float64(h.fanout.canceled),
+	}
+}
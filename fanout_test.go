@@ -0,0 +1,130 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fullCardinality returns the cardinality of the full signature of an update
+// of UpdateFull
+func fullCardinality(t *testing.T, p *Packet, n int) int {
+	buff := bytes.NewBuffer(p.MultiSig)
+	var length uint16
+	require.NoError(t, binary.Read(buff, binary.BigEndian, &length))
+	bs := NewWilffBitset(n)
+	require.NoError(t, bs.UnmarshalBinary(buff.Next(int(length))))
+	return bs.Cardinality()
+}
+
+func newFanoutHandel(n int, spread time.Duration) (*Handel, *recordNetwork, *fakeClock) {
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	clock := newFakeClock()
+	net := &recordNetwork{clock: clock}
+	conf := &Config{
+		FastPath:         4,
+		FanoutSpread:     spread,
+		UpdatePayload:    UpdateFull,
+		DisableShuffling: true,
+	}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	clock.use(h)
+	return h, net, clock
+}
+
+// completeLevels stores the signatures completing the levels 2 and 3 of the
+// node 1 of 8 nodes
+func completeLevels(h *Handel) {
+	for _, sp := range []*IncomingSig{fullIncomingSig(1), fullIncomingSig(2)} {
+		h.store.Store(sp)
+		h.checkCompletedLevel(sp)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	n := 8
+	spread := 8 * time.Millisecond
+	h, net, clock := newFanoutHandel(n, spread)
+	start := clock.now()
+	completeLevels(h)
+	require.Empty(t, net.to(2, 3, 4, 5, 6, 7))
+
+	// the sends of the level 3 to the nodes 4 to 7 are spread, and a better
+	// signature arriving in the middle is sent to the remaining peers
+	improved := false
+	for i := 0; i <= 10; i++ {
+		h.flushFanout(clock.now())
+		if !improved && len(net.to(4, 5, 6, 7)) > 0 {
+			sp := &IncomingSig{origin: 4, level: 3, ms: newSig(bitsOf(4, 0)), isInd: true}
+			h.store.Store(sp)
+			improved = true
+		}
+		clock.advance(time.Millisecond)
+	}
+	_, scheduled := h.fanout.next(clock.now())
+	require.False(t, scheduled)
+
+	sent := net.to(4, 5, 6, 7)
+	require.Len(t, sent, 4)
+	first, last := sent[0].at.Sub(start), sent[3].at.Sub(start)
+	require.True(t, first >= 0 && first <= spread/4, "first send after %s", first)
+	require.True(t, last-first >= spread*3/4, "sends spread over %s", last-first)
+	require.True(t, last <= spread, "last send after %s", last)
+	require.Equal(t, 4, fullCardinality(t, sent[0].p, n))
+	for _, s := range sent[1:] {
+		require.Equal(t, 5, fullCardinality(t, s.p, n))
+	}
+
+	// the same peers as the sends at once
+	burst, burstNet, _ := newFanoutHandel(n, -1)
+	completeLevels(burst)
+	peers := func(sent []sentPacket) []int32 {
+		var ids []int32
+		for _, s := range sent {
+			ids = append(ids, s.to)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+	require.Equal(t, peers(burstNet.to(2, 3, 4, 5, 6, 7)), peers(net.to(2, 3, 4, 5, 6, 7)))
+	require.Equal(t, []int32{4, 5, 6, 7}, peers(sent))
+
+	values := h.fanoutValues()
+	require.Equal(t, 6.0, values["scheduled"])
+	require.Equal(t, 6.0, values["sent"])
+}
+
+func TestFanoutJitter(t *testing.T) {
+	// the nodes completing at the same time start their sends at different
+	// times
+	n := 8
+	spread := 8 * time.Millisecond
+	starts := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		h, _, clock := newFanoutHandel(n, spread)
+		completeLevels(h)
+		wait, ok := h.fanout.next(clock.now())
+		require.True(t, ok)
+		require.True(t, wait >= 0 && wait <= spread/2)
+		starts[wait] = true
+	}
+	require.True(t, len(starts) > 1)
+}
+
+func TestFanoutStop(t *testing.T) {
+	n := 8
+	h, net, clock := newFanoutHandel(n, 8*time.Millisecond)
+	completeLevels(h)
+	h.Stop()
+
+	clock.advance(time.Second)
+	_, scheduled := h.flushFanout(clock.now())
+	require.False(t, scheduled)
+	require.Empty(t, net.to(2, 3, 4, 5, 6, 7))
+	require.Equal(t, 6.0, h.fanoutValues()["canceled"])
+}
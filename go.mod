@@ -8,6 +8,7 @@ require (
 	github.com/cloudflare/bn256 v0.0.0-20190523220833-828ba4f91854
 	github.com/go-kit/kit v0.9.0
 	github.com/go-logfmt/logfmt v0.4.0 // indirect
+	github.com/gorilla/websocket v1.4.0
 	github.com/ipfs/go-log v0.0.1
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/libp2p/go-libp2p v0.2.1
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +19,9 @@ type Handel struct {
 	c *Config
 	// Network enabling external communication with other Handel nodes
 	net Network
+	// Transport adapter, only set when Handel runs over a Transport. See
+	// NewHandelWithTransport.
+	raw *transportNetwork
 	// Registry holding access to all Handel node's identities
 	reg Registry
 	// Partitioning strategy used by the Handel round
@@ -28,25 +32,47 @@ type Handel struct {
 	id Identity
 	// Message that is being signed during the Handel protocol
 	msg []byte
+	// identifier of the aggregation of msg, stamped on the packets sent
+	aggregation uint32
 	// signature over the message
 	sig Signature
 	// signature store with different merging/caching strategy
 	store SignatureStore
 	// processing of signature - verification strategy
-	proc signatureProcessing
+	proc SignatureProcessing
 	// all actors registered that acts on a new signature
-	actors []actor
+	actors []namedActor
+	// queue of the calls to the async actors
+	queue *actorQueue
+	// execution time of the actors
+	actorStats *actorStats
+	// accounting of the useful and redundant verifications
+	efficiency *efficiency
 	// best final signature,i.e. at the last level, seen so far
 	best *MultiSignature
+	// number of final signatures sent over out, the sequence number of best
+	bestSeq uint64
+	// whether Config.OnThreshold has been called
+	thresholdNotified bool
 	// channel to exposes multi-signatures to the user
 	out chan MultiSignature
 	// indicating whether handel is finished or not
 	done bool
+	// indicating whether handel has been started
+	started bool
+	// closed when handel stops, to stop the periodic loop
+	stopCh chan bool
+	// tracks all the routines started by handel
+	wg sync.WaitGroup
 	// constant threshold of contributions required in a ms to be considered
 	// valid
 	threshold int
-	// ticker for the periodic update
+	// ticker for the periodic update, created at Start
 	ticker *time.Ticker
+	// stops Handel once Config.Deadline elapsed, created at Start
+	deadline *time.Timer
+	// summary of the aggregation set when Handel stops, see Result
+	result atomic.Value
 	// all the levels
 	levels map[int]*level
 	// ids of the level in order as returned by the partitioner
@@ -59,6 +85,58 @@ type Handel struct {
 	log Logger
 	// minimal stats about Handel
 	stats HStats
+	// number of periodic updates, to schedule the starvation checks
+	ticks int
+	// levels detected as starved
+	starved map[int]bool
+	// skips the sends of the signatures already sent to a peer
+	resend *resendFilter
+	// sends of the fast path spread over Config.FanoutSpread
+	fanout *fanout
+	// last activity of the routines, see Health
+	beats heartbeats
+	// clock of the heartbeats, the resends and the negotiations, time.Now
+	// by default
+	now func() time.Time
+	// true once the starved levels make the threshold unreachable
+	unreachable bool
+	// checks the full signature never regresses
+	guard fullGuard
+	// packets and aggregates dropped under the pressure of the processing
+	// queue, see Config.QueueCapacity
+	shedding shedStats
+	// knobs which can change at runtime
+	tuning *tuning
+	// staleness of the aggregates of each origin
+	staleness *staleness
+	// digests exchanged with the peers, see Config.EnableNegotiation
+	negotiation *negotiation
+	// budget of the collectors, see Config.ObservabilityBudgetBytes
+	observability *observability
+	// peers never heard of, only set with Config.AvoidUnresponsive
+	unresponsive *unresponsive
+	// progress hints of the origins, see Config.GossipProgress
+	progress *progress
+	// completion packets sent and received, see Config.BroadcastCompletion
+	completion *completion
+	// spans of the aggregation, only set with Config.Tracer
+	tracing *tracing
+	// identities signing with their previous key, only set with
+	// Config.KeyRotationGrace
+	rotation *keyRotation
+	// recycled bitsets and marshalling buffers, only set when Handel runs in
+	// a Session
+	bitsets *BitSetPool
+	buffers *bufferPool
+	// recycled signatures parsed from the packets, see sigPool
+	pool *sigPool
+	// signatures remapped to the level of their bitset per origin, see
+	// Config.TolerantLevels
+	remaps map[int32]int
+	// outcomes of the updates of the levels, see RecentUpdates
+	updates *updateLog
+	// signs the packets sent, only set with Config.AuthenticatePackets
+	secret SecretKey
 }
 
 // NewHandel returns a Handle interface that uses the given network and
@@ -66,9 +144,21 @@ type Handel struct {
 // constructor defines over which curves / signature scheme Handel runs. The
 // message is the message to "multi-sign" by Handel.  The first config in the
 // slice is taken if not nil. Otherwise, the default config generated by
-// DefaultConfig() is used.
+// DefaultConfig() is used. It panics if the config is invalid for the
+// registry, see Config.Validate, or if it authenticates the packets, see
+// NewHandelWithSecret.
 func NewHandel(n Network, r Registry, id Identity, c Constructor,
 	msg []byte, s Signature, conf ...*Config) *Handel {
+	return NewHandelWithSecret(n, r, id, c, msg, s, nil, conf...)
+}
+
+// NewHandelWithSecret returns a Handel as NewHandel, which signs the packets it
+// sends with the given secret key of its identity when the config
+// authenticates the packets, see Config.AuthenticatePackets. The key may be
+// nil otherwise. It panics if the config authenticates the packets without
+// a key.
+func NewHandelWithSecret(n Network, r Registry, id Identity, c Constructor,
+	msg []byte, s Signature, sk SecretKey, conf ...*Config) *Handel {
 
 	var config *Config
 	if len(conf) > 0 && conf[0] != nil {
@@ -76,121 +166,409 @@ func NewHandel(n Network, r Registry, id Identity, c Constructor,
 	} else {
 		config = DefaultConfig(r.Size())
 	}
+	if err := config.Validate(r.Size()); err != nil {
+		panic(err)
+	}
+	if config.AuthenticatePackets && sk == nil {
+		panic(errNoSecret)
+	}
 	log := config.Logger.With("id", id.ID())
-	part := config.NewPartitioner(id.ID(), r, log)
-	firstBs := config.NewBitSet(1)
-	firstBs.Set(0, true)
-	mySig := &MultiSignature{BitSet: firstBs, Signature: s}
+	part := capPartitioner(config, config.NewPartitioner(id.ID(), r, log), id.ID(), r.Size())
+	h := newHandel(n, r, id, c, msg, s, config, part, log)
+	h.secret = sk
+	if config.PreStart == nil {
+		h.net.RegisterListener(h)
+	}
+	return h
+}
+
+// newHandel returns a Handel using the given partitioner, without registering
+// it to the network.
+func newHandel(n Network, r Registry, id Identity, c Constructor,
+	msg []byte, s Signature, config *Config, part Partitioner, log Logger) *Handel {
 
+	tuning := newTuning(config, log)
 	h := &Handel{
+		tuning:      tuning,
 		c:           config,
-		net:         n,
+		net:         wrapNetwork(n),
 		reg:         r,
 		Partitioner: part,
 		id:          id,
 		cons:        c,
-		msg:         msg,
-		sig:         s,
-		out:         make(chan MultiSignature, 10000),
-		ticker:      time.NewTicker(config.UpdatePeriod),
-		log:         log,
+		log:         tuning.logger,
 		levels:      createLevels(config, part),
 		ids:         part.Levels(),
+		now:         time.Now,
 	}
-	h.actors = []actor{
-		actorFunc(h.checkCompletedLevel),
-		actorFunc(h.checkFinalSignature),
+	h.actors = []namedActor{
+		{name: "checkCompletedLevel", actor: actorFunc(h.checkCompletedLevel)},
+		{name: "checkFinalSignature", actor: actorFunc(h.checkFinalSignature)},
 	}
+	if tn, ok := n.(*transportNetwork); ok {
+		tn.log = h.log
+		h.raw = tn
+	}
+	h.pool = newSigPool(config.NewBitSet)
+	// the nodes of a subtree smaller than the others require all of theirs
+	h.threshold = min(h.c.Contributions, h.subtreeSize())
+	h.reset(msg, s)
+	return h
+}
 
-	h.threshold = h.c.Contributions
-	h.store = newStore(part, h.c.NewBitSet, c)
+// reset sets up the aggregation of the signature over the message: all the
+// state of Handel but the one kept from an aggregation to the next, i.e. the
+// config, the network, the partitioner, the peers of the levels in their
+// contact order and the pools. The lock must be held once Handel is built.
+func (h *Handel) reset(msg []byte, s Signature) {
+	config := h.c
+	h.msg = msg
+	h.aggregation = AggregationID(msg)
+	h.sig = s
+	h.out = make(chan MultiSignature, 10000)
+	h.stopCh = make(chan bool)
+	h.done = false
+	h.started = false
+	h.best = nil
+	h.bestSeq = 0
+	h.thresholdNotified = false
+	h.ticker = nil
+	h.deadline = nil
+	h.startTime = time.Time{}
+	h.stats = HStats{}
+	h.ticks = 0
+	h.unreachable = false
+	h.guard = fullGuard{}
+	h.shedding = shedStats{}
+	h.remaps = nil
+	h.beats.reset()
+	h.staleness = newStaleness(h.reg.Size())
+	h.negotiation = newNegotiation()
+	h.progress = newProgress()
+	h.completion = newCompletion()
+	h.starved = make(map[int]bool)
+	h.efficiency = newEfficiency()
+	h.updates = newUpdateLog()
+	h.resend = newResendFilter(config.ResendIdenticalAfter, config.Rand)
+	h.fanout = newFanout(config.FanoutSpread, config.Rand)
+	h.actorStats = newActorStats()
+	h.queue = newActorQueue(h.actorStats)
+	h.actorStats.queue = h.queue
+	h.observability = &observability{collectors: h.collectors()}
+	for i, id := range h.ids {
+		h.levels[id].reset()
+		if i == 0 {
+			h.levels[id].setStarted()
+		}
+	}
+	h.unresponsive = nil
+	if config.AvoidUnresponsive {
+		h.unresponsive = newUnresponsive(config.UnresponsiveAttempts)
+		for _, lvl := range h.levels {
+			lvl.suspect = h.suspicion(lvl)
+		}
+	}
+
+	// the reporting store and the state of the processing of the previous
+	// aggregation are kept
+	_, reporting := h.store.(*ReportStore)
+	prevProc, _ := h.proc.(*evaluatorProcessing)
+
+	h.store = h.c.NewStore(h.Partitioner, h.c.NewBitSet, h.cons)
+	if st, ok := h.store.(*store); ok {
+		st.setLogger(h.log)
+		if config.RetainProofMaterial {
+			st.retainProofs()
+		}
+	}
 
 	// We need to add our own sig at level 0
-	ind := &incomingSig{
-		origin:      id.ID(),
+	firstBs := config.NewBitSet(1)
+	firstBs.Set(0, true)
+	ind := &IncomingSig{
+		origin:      h.id.ID(),
 		level:       0,
-		ms:          mySig,
+		ms:          &MultiSignature{BitSet: firstBs, Signature: s},
 		isInd:       true,
 		mappedIndex: 0,
 	}
 	h.store.Store(ind) // Our own sig is at level 0.
 	evaluator := h.c.NewEvaluatorStrategy(h.store, h)
-	h.proc = newEvaluatorProcessing(part, c, msg, config.UnsafeSleepTimeOnSigVerify, evaluator, h.log)
-	h.net.RegisterListener(h)
+	if h.c.DeprioritizeStale {
+		evaluator = &staleEvaluator{SigEvaluator: evaluator, h: h}
+	}
+	if h.c.NewProcessing != nil {
+		h.proc = h.c.NewProcessing(h.Partitioner, h.cons, msg, evaluator, h.log)
+	} else {
+		h.proc = newEvaluatorProcessing(h.Partitioner, h.cons, msg, config.UnsafeSleepTimeOnSigVerify, config.StrictIndividualOrigin, config.QueueCapacity, evaluator, h.log)
+	}
+	if p, ok := h.proc.(*evaluatorProcessing); ok && prevProc != nil {
+		p.keys = prevProc.keys
+		p.now = prevProc.now
+	}
+	if reporting {
+		h.store = newReportStore(h.store)
+	}
+	if p, ok := h.proc.(pooledProcessing); ok {
+		p.setPool(h.pool)
+	}
+	h.tracing = newTracing(config.Tracer)
+	if p, ok := h.proc.(tracedProcessing); ok {
+		p.setTracing(h.tracing)
+	}
+	h.rotation = nil
+	if config.KeyRotationGrace > 0 {
+		h.rotation = newKeyRotation(config.KeyRotationGrace, h.reg.Size(), config.NewBitSet)
+		h.checkOwnKey()
+		if p, ok := h.proc.(rotatingProcessing); ok {
+			p.setRotation(h.rotation)
+		}
+	}
 	h.timeout = h.c.NewTimeoutStrategy(h, h.ids)
-	return h
+}
+
+// Reset prepares Handel to aggregate the signature over a new message,
+// without building a new one: the network, the partitioner and the contact
+// order of the peers are kept, and all the state of the previous aggregation
+// is cleared. Handel must be stopped first, or not started yet: Reset returns
+// an error if it is running. It waits for the routines of the previous
+// aggregation to return, as Close does. The channels returned by
+// FinalSignatures and Done are replaced, as the Result, and Handel is started
+// again with Start.
+func (h *Handel) Reset(msg []byte, sig Signature) error {
+	h.Lock()
+	if h.started && !h.done {
+		h.Unlock()
+		return errors.New("handel: reset while running")
+	}
+	// a Handel never started still has its processing to stop
+	h.stop(Stopped)
+	h.Unlock()
+	h.wg.Wait()
+
+	h.Lock()
+	defer h.Unlock()
+	h.reset(msg, sig)
+	h.result.Store((*AggregationResult)(nil))
+	return nil
 }
 
 // NewPacket implements the Listener interface for the network.  It parses the
 // packet and forwards the multisignature (if correct) and the individual
 // signature (if correct) to the processing loop.
 func (h *Handel) NewPacket(p *Packet) {
+	h.beats.received()
+	if !h.acceptPacket(p) {
+		return
+	}
+	// authenticated last and outside of the lock, as it costs a signature
+	// verification
+	authErr := h.authenticatePacket(p)
 	h.Lock()
 	defer h.Unlock()
 
 	if h.done {
 		return
 	}
-	if err := h.validatePacket(p); err != nil {
-		h.log.Warn("invalid_packet", err)
+	if authErr != nil {
+		h.stats.unauthenticated++
+		h.log.Warn("unauthenticated_packet", authErr)
+		return
+	}
+	if h.unresponsive != nil {
+		h.unresponsive.heard(p.Origin)
+	}
+	h.observeProgress(p)
+	if p.Flags&FlagCompletion != 0 {
+		h.newCompletion(p)
+		return
+	}
+	if h.completed() {
+		// the signatures are not needed anymore, but the sender does not know
+		h.answerLate(p.Origin)
+		if p.Flags&FlagDigest == 0 {
+			return
+		}
+	}
+	if p.Flags&FlagDigest != 0 {
+		h.answerDigest(p)
+		return
+	}
+	tier := h.shedTier()
+	if p.Level == FullLevel {
+		h.newFullPacket(p, tier)
+		return
+	}
+	if lvl, ok := h.levels[int(p.Level)]; ok && tier >= shedCompleted && lvl.rcvCompleted {
+		h.shedding.completed++
 		return
 	}
 	ms, ind, err := h.parseSignatures(p)
 	if err != nil {
 		h.log.Warn("invalid_packet - multisig", err)
 		return
-	} else if !h.getLevel(p.Level).rcvCompleted {
+	} else if !h.getLevel(ms.level).rcvCompleted {
 		// sends it to processing
 		h.log.Debug("rcvd_from", p.Origin, "rcvd_level", p.Level)
-		h.proc.Add(ms)
+		h.observeStaleness(ms)
+		if !h.shed(tier, ms) {
+			h.proc.Add(ms)
+		} else {
+			h.pool.put(ms)
+		}
 		if ind != nil {
 			// can happen since we don't always send individual signature if this
 			// is a complete level
 			h.proc.Add(ind)
 		}
+	} else {
+		h.pool.put(ms)
+		h.pool.put(ind)
 	}
 }
 
 // Start the Handel protocol by sending signatures to peers in the first level,
-// and by starting relevant sub-routines.
+// and by starting relevant sub-routines. All the routines are owned by Handel
+// and return when it is stopped. Calling Start more than once, or after Stop,
+// has no effect.
 func (h *Handel) Start() {
 	h.Lock()
-	defer h.Unlock()
+	if h.started || h.done {
+		h.Unlock()
+		return
+	}
+	h.started = true
 	h.startTime = time.Now()
-	go h.proc.Start()
-	go h.rangeOnVerified()
-	go h.timeout.Start()
-	go h.periodicLoop()
+	beat(&h.beats.started, h.now())
+	h.tracing.start(h.id.ID(), h.threshold, h.subtreeSize())
+	if h.rotation != nil {
+		h.rotation.start(h.startTime)
+	}
+	h.ticker = time.NewTicker(h.c.UpdatePeriod)
+	if h.c.Deadline > 0 {
+		deadline := time.NewTimer(h.c.Deadline)
+		h.deadline = deadline
+		h.spawn(func() { h.deadlineLoop(deadline) })
+	}
+	h.spawn(h.proc.Start)
+	h.spawn(h.rangeOnVerified)
+	h.spawn(h.timeout.Start)
+	h.spawn(h.periodicLoop)
+	h.spawn(func() { h.queue.run(h.stopCh) })
+	h.spawn(h.statusLoop)
+	if h.fanout.enabled() {
+		h.spawn(h.fanoutLoop)
+	}
+	// our own signature may be enough, e.g. with a single identity
+	h.checkFinalSignature(nil)
+	h.Unlock()
+	if h.c.PreStart != nil {
+		h.c.PreStart.attach(h)
+	}
+}
+
+// spawn runs the function in a routine tracked by Handel.
+func (h *Handel) spawn(fn func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		fn()
+	}()
+}
+
+// deadlineLoop stops Handel once the deadline timer expires, unless it is
+// stopped before.
+func (h *Handel) deadlineLoop(deadline *time.Timer) {
+	select {
+	case <-deadline.C:
+		h.Lock()
+		defer h.Unlock()
+		h.stop(DeadlineExpired)
+	case <-h.stopCh:
+	}
 }
 
 // periodicLoop simply calls the periodic update each period of time.
 func (h *Handel) periodicLoop() {
-	for range h.ticker.C {
-		h.periodicUpdate()
+	for {
+		select {
+		case <-h.ticker.C:
+			h.periodicUpdate()
+		case <-h.stopCh:
+			return
+		}
 	}
 }
 
-// Stop the Handel protocol and all sub routines
+// Stop the Handel protocol and all sub routines. It does not wait for the
+// routines to return, see Close. Calling Stop more than once has no effect.
 func (h *Handel) Stop() {
 	h.Lock()
 	defer h.Unlock()
-	h.ticker.Stop()
+	h.stop(Stopped)
+}
+
+// stop is the "unlocked" version of Stop, recording the given reason in the
+// result if the threshold was not met.
+func (h *Handel) stop(end Outcome) {
+	if h.done {
+		return
+	}
+	h.done = true
+	atomic.StoreInt64(&h.beats.stopped, 1)
+	h.setResult(end)
+	h.stopTracing(h.result.Load().(*AggregationResult))
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+	if h.deadline != nil {
+		h.deadline.Stop()
+	}
+	close(h.stopCh)
+	h.fanout.cancel()
 	h.timeout.Stop()
 	h.proc.Stop()
-	h.done = true
 	close(h.out)
+	if h.c.PreStart != nil {
+		h.c.PreStart.detach(h)
+	}
+}
+
+// Close stops Handel and waits for all its routines to return. It is the
+// canonical way to dispose of a Handel instance: once it returns, Handel does
+// not hold any routine nor timer anymore.
+func (h *Handel) Close() error {
+	h.Stop()
+	h.wg.Wait()
+	return nil
 }
 
 // periodicUpdate sends the best multi-signature (potentially ind. sig.) for
 // each started level.
 func (h *Handel) periodicUpdate() {
+	now := h.now()
+	beat(&h.beats.tick, now)
+	h.beats.lastPacket(now)
 	h.Lock()
 	defer h.Unlock()
+	h.ticks++
+	if h.c.StarvationCheck > 0 && h.ticks%h.c.StarvationCheck == 0 {
+		h.checkStarvation()
+	}
 	for _, lvl := range h.levels {
-		if lvl.active() {
-			h.sendUpdate(lvl, h.c.UpdateCount)
+		switch {
+		case !lvl.started():
+			h.recordUpdate(lvl, UpdateNotStarted, 0)
+		case !lvl.active():
+			h.recordUpdate(lvl, UpdateNoPending, 0)
+		default:
+			h.sendUpdate(lvl, h.updateCount(lvl))
 		}
 	}
+	if h.negotiating() && !h.completed() {
+		h.negotiate(now)
+	}
+	h.checkObservability()
 }
 
 // StartLevel starts the given level if not started already. This in effects
@@ -208,41 +586,187 @@ func (h *Handel) unsafeStartLevel(lvl *level) {
 		return
 	}
 	lvl.setStarted()
-	h.sendUpdate(lvl, h.c.UpdateCount)
+	h.sendUpdate(lvl, h.updateCount(lvl))
+}
+
+// updateCount returns the number of peers of the level contacted during an
+// update, see Config.LevelUpdateCount.
+func (h *Handel) updateCount(lvl *level) int {
+	if h.c.LevelUpdateCount != nil {
+		if count := h.c.LevelUpdateCount(lvl.id, len(lvl.nodes)); count > 0 {
+			return count
+		}
+	}
+	return h.c.UpdateCount
 }
 
 // Send our best signature set for this level, to 'count' nodes. The level MUST
-// be active before calling this method.
+// be active before calling this method. Nothing is sent once the aggregation
+// is completed, see Config.BroadcastCompletion. The outcome is recorded, see
+// RecentUpdates.
 func (h *Handel) sendUpdate(l *level, count int) {
-	ms := h.store.Combined(byte(l.id) - 1)
-	newNodes, _ := l.selectNextPeers(count)
+	ms, peers := h.nextUpdate(l, count)
+	if ms == nil {
+		return
+	}
+	h.sendLevel(l, peers, ms)
+}
+
+// nextUpdate returns the signature of the level and the next peers of the
+// level to send it to, and records the outcome of the update. It returns a
+// nil signature if there is nothing to send.
+func (h *Handel) nextUpdate(l *level, count int) (*MultiSignature, []Identity) {
+	if h.completed() {
+		h.recordUpdate(l, UpdateFinished, 0)
+		return nil, nil
+	}
+	h.tracing.startLevel(l)
+	ms := h.updateSig(l.id)
+	if ms == nil {
+		h.recordUpdate(l, UpdateEmptyStore, 0)
+		return nil, nil
+	}
+	newNodes := h.selectNextPeers(l, count, h.resend.skipper(l, h.now()))
+	if len(newNodes) == 0 {
+		h.bitsets.Put(ms.BitSet)
+		h.recordUpdate(l, UpdateNoPeer, 0)
+		return nil, nil
+	}
+	h.recordUpdate(l, UpdateSent, len(newNodes))
+	return ms, newNodes
+}
+
+// sendLevel sends the signature of the level to the peers
+func (h *Handel) sendLevel(l *level, peers []Identity, ms *MultiSignature) {
 	var sig Signature
 	if !l.rcvCompleted {
 		// send our individual signature only we still did not finish the level
 		sig = h.sig
 	}
-	h.sendTo(l.id, newNodes, ms, sig)
+	h.sendTo(l.id, peers, ms, sig)
+	// the combined signature is only marshalled
+	h.bitsets.Put(ms.BitSet)
+}
+
+// selectNextPeers selects the next peers of the level but the skipped ones,
+// and records their contact if the unresponsive peers are avoided.
+func (h *Handel) selectNextPeers(l *level, count int, skip func(pos int) bool) []Identity {
+	ids := l.selectNextPeersBut(count, skip)
+	if h.unresponsive != nil {
+		for _, id := range ids {
+			h.unresponsive.contacted(id.ID())
+		}
+	}
+	return ids
 }
 
 // FinalSignatures returns the channel over which final multi-signatures
 // are sent over. These multi-signatures contain at least a threshold of
 // contributions, as defined in the config.
+//
+// Each multi-signature sent has strictly more contributions than the previous
+// one, and is a copy: its bitset can be modified without changing the state of
+// Handel, but its signature is shared and must not be modified. They are sent
+// in order, as Handel updates its best signature, so the n-th value received
+// has the sequence number n - see LatestFinalSignature.
 func (h *Handel) FinalSignatures() chan MultiSignature {
 	return h.out
 }
 
+// FinalSignature is a final multi-signature with its sequence number
+type FinalSignature struct {
+	MultiSignature
+	// Seq is 1 for the first final signature sent over FinalSignatures, and
+	// increases by one with each of the next ones
+	Seq uint64
+}
+
+// LatestFinalSignature returns a copy of the last final signature sent over
+// FinalSignatures, and false if none was sent yet. It lets an application
+// keeping only the latest signature, instead of reading them all from the
+// channel, tell from the sequence numbers how many it skipped.
+func (h *Handel) LatestFinalSignature() (FinalSignature, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.best == nil {
+		return FinalSignature{}, false
+	}
+	return FinalSignature{MultiSignature: h.finalCopy(h.best), Seq: h.bestSeq}, true
+}
+
+// emitFinal makes the signature the best final signature and sends a copy of
+// it over FinalSignatures if it has more contributions than the current best
+// one, and returns true if so. The lock must be held.
+func (h *Handel) emitFinal(ms *MultiSignature) bool {
+	if h.done || (h.best != nil && ms.Cardinality() <= h.best.Cardinality()) {
+		return false
+	}
+	h.best = ms
+	h.bestSeq++
+	h.out <- h.finalCopy(ms)
+	h.tracing.final(ms, h.bestSeq)
+	return true
+}
+
+// BestSignature returns a copy of the best final signature so far or, below
+// the threshold, of the current full signature, to observe the progress of
+// the aggregation without reading FinalSignatures. It returns nil if there is
+// no signature yet. As the final signatures, its bitset can be modified but
+// its signature is shared.
+func (h *Handel) BestSignature() *MultiSignature {
+	h.Lock()
+	defer h.Unlock()
+	if h.best != nil {
+		best := h.finalCopy(h.best)
+		return &best
+	}
+	full := h.store.FullSignature()
+	if full == nil {
+		return nil
+	}
+	best := h.finalCopy(full)
+	h.bitsets.Put(full.BitSet)
+	return &best
+}
+
+// Progress returns the cardinality of the signature returned by BestSignature,
+// zero without one, and the threshold of contributions.
+func (h *Handel) Progress() (cardinality, threshold int) {
+	h.Lock()
+	defer h.Unlock()
+	if h.best != nil {
+		return h.best.Cardinality(), h.threshold
+	}
+	if full := h.store.FullSignature(); full != nil {
+		cardinality = full.Cardinality()
+		h.bitsets.Put(full.BitSet)
+	}
+	return cardinality, h.threshold
+}
+
+func (h *Handel) finalCopy(ms *MultiSignature) MultiSignature {
+	return MultiSignature{BitSet: ms.BitSet.Clone(), Signature: ms.Signature}
+}
+
 // rangeOnVerified processed each verified signature from the processing
 // routine. For each, it:
 //  1) adds it to the store of verified signature
 //  2) pass it down to all registered actors. Each handler is called in
 //     a thread safe manner, global lock is held during the call to actors.
+//     The calls to the async actors are only queued.
 func (h *Handel) rangeOnVerified() {
 	for v := range h.proc.Verified() {
-		h.store.Store(&v)
+		beat(&h.beats.verified, h.now())
+		h.store.Store(v)
+		h.efficiency.stored(v, h.store)
 		h.Lock()
-		for _, actor := range h.actors {
-			actor.OnVerifiedSignature(&v)
+		if h.done {
+			// drain the remaining signatures until processing returns
+			h.Unlock()
+			continue
 		}
+		h.learnPreviousKeys(v)
+		h.dispatch(v)
 		h.Unlock()
 	}
 }
@@ -253,57 +777,114 @@ func (h *Handel) rangeOnVerified() {
 // higher levels so it should send it out to other peers, etc. The store is
 // guaranteed to have a multisignature present at the level indicated in the
 // verifiedSig. Each handler is called in a thread safe manner, global lock is
-// held during the call to actors.
+// held during the call to actors. See RegisterActor and RegisterAsyncActor for
+// the actors registered by the application.
 type actor interface {
-	OnVerifiedSignature(s *incomingSig)
+	OnVerifiedSignature(s *IncomingSig)
 }
 
 // actorFunc is a simpler wrapper to morph a function into an actor.
-type actorFunc func(s *incomingSig)
+type actorFunc func(s *IncomingSig)
 
-func (a actorFunc) OnVerifiedSignature(s *incomingSig) {
+func (a actorFunc) OnVerifiedSignature(s *IncomingSig) {
 	a(s)
 }
 
 // checkFinalSignature checks if a new better final signature (ig. a signature
 // at the last level) has been generated. If so, it sends it to the output
 // channel.
-func (h *Handel) checkFinalSignature(s *incomingSig) {
+func (h *Handel) checkFinalSignature(s *IncomingSig) {
 	sig := h.store.FullSignature()
+	h.checkFullSignature(sig)
 
-	if sig.BitSet.Cardinality() < h.threshold {
+	if sig.BitSet.Cardinality() < h.threshold || !h.groupQuorum(sig.BitSet) {
+		h.bitsets.Put(sig.BitSet)
+		h.checkReachable()
 		return
 	}
-	newBest := func(ms *MultiSignature) {
-		if h.done {
-			return
-		}
-		h.best = ms
-		h.log.Info("new_sig", fmt.Sprintf("%d/%d/%d", ms.Cardinality(), h.threshold, h.reg.Size()))
-		h.out <- *h.best
+	// a merge may change the signature without adding contributions: only a
+	// better one is sent
+	if !h.emitFinal(sig) {
+		h.bitsets.Put(sig.BitSet)
+		return
 	}
+	h.log.Info("new_sig", fmt.Sprintf("%d/%d/%d", sig.Cardinality(), h.threshold, h.subtreeSize()))
+	h.notifyThreshold(sig)
+	h.complete(sig)
+}
 
-	if h.best == nil {
-		newBest(sig)
+// notifyThreshold calls Config.OnThreshold with the signature the first time
+// the threshold is reached, in a routine joined by Close. The lock must be
+// held.
+func (h *Handel) notifyThreshold(sig *MultiSignature) {
+	if h.thresholdNotified || h.c.OnThreshold == nil {
 		return
 	}
+	h.thresholdNotified = true
+	ms := h.finalCopy(sig)
+	h.spawn(func() { h.c.OnThreshold(ms) })
+}
 
-	newCard := sig.Cardinality()
-	local := h.best.Cardinality()
-	if newCard > local {
-		newBest(sig)
+// groupQuorum returns true if no single group contributes more than the
+// MaxGroupFraction of the bits set in the given full bitset. It always returns
+// true if the rule is disabled.
+func (h *Handel) groupQuorum(bs BitSet) bool {
+	if h.c.MaxGroupFraction <= 0 {
+		return true
+	}
+	total := bs.Cardinality()
+	if total == 0 {
+		return false
+	}
+	for g, ct := range h.groupContributions(bs) {
+		if float64(ct)/float64(total) > h.c.MaxGroupFraction {
+			h.log.Debug("group_quorum", g, "contributions", ct, "total", total)
+			return false
+		}
 	}
+	return true
+}
+
+// groupContributions returns the number of contributions of each group, as
+// defined by Config.Groups, contained in the given full bitset.
+func (h *Handel) groupContributions(bs BitSet) map[int]int {
+	counts := make(map[int]int)
+	// the full bitsets index the subtree
+	offset, _ := h.subtree()
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		if offset+i >= len(h.c.Groups) {
+			continue
+		}
+		counts[h.c.Groups[offset+i]]++
+	}
+	return counts
+}
+
+// GroupContributions returns the number of contributions of each group, as
+// defined by Config.Groups, contained in the best full multi-signature
+// currently stored.
+func (h *Handel) GroupContributions() map[int]int {
+	h.Lock()
+	defer h.Unlock()
+	return h.groupContributions(h.store.FullSignature().BitSet)
 }
 
 // checkCompletedLevels checks if higher levels may be completed by the given
 // signature. For each of those, it sends the update to the corresponding peers
 // in a fast path fashion.
-func (h *Handel) checkCompletedLevel(s *incomingSig) {
+func (h *Handel) checkCompletedLevel(s *IncomingSig) {
 	// The receiving phase: have we completed this level?
 	lvl := h.getLevel(s.level)
 	if lvl.rcvCompleted {
 		return
 	}
+	var elapsed time.Duration
+	if !h.startTime.IsZero() {
+		elapsed = h.now().Sub(h.startTime)
+	}
+	if lvl.firstVerified == 0 {
+		lvl.firstVerified = elapsed
+	}
 
 	sp, _ := h.store.Best(s.level)
 	if sp == nil {
@@ -312,6 +893,8 @@ func (h *Handel) checkCompletedLevel(s *incomingSig) {
 	if sp.Cardinality() == len(lvl.nodes) {
 		h.log.Debug("level_complete", s.level)
 		lvl.rcvCompleted = true
+		lvl.completedIn = elapsed
+		h.tracing.endLevel(lvl, sp.Cardinality())
 	}
 
 	// The sending phase: for all upper levels we may have completed the level.
@@ -321,8 +904,17 @@ func (h *Handel) checkCompletedLevel(s *incomingSig) {
 			continue
 		}
 		ms := h.store.Combined(byte(id) - 1)
-		if ms != nil && lvl.updateSigToSend(ms) {
-			h.sendUpdate(lvl, h.c.FastPath)
+		if ms == nil {
+			continue
+		}
+		improved := ms.Cardinality() > lvl.sendSigSize
+		update := lvl.updateSigToSend(ms)
+		h.bitsets.Put(ms.BitSet)
+		switch {
+		case update:
+			h.sendFastPath(lvl)
+		case improved && h.c.UpdateOnImprovement && lvl.started():
+			h.sendUpdate(lvl, h.updateCount(lvl))
 		}
 	}
 }
@@ -343,16 +935,24 @@ func (h *Handel) getLevel(levelID byte) *level {
 func (h *Handel) sendTo(lvl int, ids []Identity, ms *MultiSignature, ind Signature) {
 	h.stats.msgSentCt += len(ids)
 
-	buff, err := ms.MarshalBinary()
+	level, buff, err := h.marshalUpdate(lvl, ms)
 	if err != nil {
 		h.log.Error("multi-signature", err)
 		return
 	}
 
 	p := &Packet{
-		Origin:   h.id.ID(),
-		Level:    byte(lvl),
-		MultiSig: buff,
+		Origin:      h.id.ID(),
+		Level:       level,
+		MultiSig:    buff,
+		Aggregation: h.aggregation,
+	}
+	if h.c.GossipProgress {
+		p.Progress = h.progressHint()
+	}
+	if p.PreviousKeys, err = h.previousKeysHint(level, ms); err != nil {
+		h.log.Error("previous_keys", err)
+		return
 	}
 	if ind != nil {
 		indBuff, err := ind.MarshalBinary()
@@ -362,11 +962,47 @@ func (h *Handel) sendTo(lvl int, ids []Identity, ms *MultiSignature, ind Signatu
 		}
 		p.IndividualSig = indBuff
 	}
+	if err := h.signPacket(p); err != nil {
+		h.log.Error("packet_signature", err)
+		return
+	}
 
+	h.stats.bytesSent += (len(p.MultiSig) + len(p.IndividualSig) + len(p.PreviousKeys)) * len(ids)
 	h.log.Debug("sent_level", p.Level, "sent_nodes", fmt.Sprintf("%s", ids))
+	if h.c.PortPerLevel {
+		ids, err = levelIdentities(ids, lvl)
+		if err != nil {
+			h.log.Error("level_address", err)
+			return
+		}
+	}
 	h.net.Send(ids, p)
 }
 
+// acceptPacket runs the cheap checks of the packet, before its
+// authentication: Handel must be running, and the origin and the level of the
+// packet valid.
+func (h *Handel) acceptPacket(p *Packet) bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return false
+	}
+	if err := h.validatePacket(p); err == errOriginRange {
+		h.stats.rejected++
+		h.log.Warn(originRangeLog...)
+		return false
+	} else if err == errOutsideSubtree {
+		h.stats.outsideSubtree++
+		h.log.Warn(outsideSubtreeLog...)
+		return false
+	} else if err != nil {
+		h.log.Warn("invalid_packet", err)
+		return false
+	}
+	return true
+}
+
 // validatePacket verifies the validity of the origin and level fields of the
 // packet and returns an error if any. This method does NOT verify the validity
 // of the signature(s) inside the packet.
@@ -374,12 +1010,25 @@ func (h *Handel) validatePacket(p *Packet) error {
 	h.stats.msgRcvCt++
 
 	if p.Origin < 0 || p.Origin >= int32(h.reg.Size()) {
-		return errors.New("packet's origin out of range")
+		return errOriginRange
+	}
+	if min, max := h.subtree(); int(p.Origin) < min || int(p.Origin) >= max {
+		return errOutsideSubtree
+	}
+	if p.Flags&^(FlagDigest|FlagCompletion) != 0 {
+		return fmt.Errorf("unknown packet's flags %d", p.Flags)
+	}
+	if p.Flags&FlagCompletion != 0 {
+		// the level is the one of the full signature
+		return nil
 	}
 
+	if p.Level == FullLevel && h.c.UpdatePayload == UpdateFull {
+		return nil
+	}
 	_, exists := h.levels[int(p.Level)]
 
-	if !exists {
+	if !exists && !h.tolerableLevel(p) {
 		return fmt.Errorf("invalid packet's level %d", p.Level)
 	}
 	return nil
@@ -387,52 +1036,113 @@ func (h *Handel) validatePacket(p *Packet) error {
 
 // parseMultisignature returns the multisignature (and the individual signature
 // if present) unmarshalled if correct, or an error otherwise.
-func (h *Handel) parseSignatures(p *Packet) (ms *incomingSig, ind *incomingSig, err error) {
-	m := new(MultiSignature)
-	err = m.Unmarshal(p.MultiSig, h.cons.Signature(), h.c.NewBitSet)
+func (h *Handel) parseSignatures(p *Packet) (ms *IncomingSig, ind *IncomingSig, err error) {
+	level := int(p.Level)
+	size := 0
+	if lvl, ok := h.levels[level]; ok {
+		size = len(lvl.nodes)
+	} else if !h.tolerableLevel(p) {
+		return nil, nil, errors.New("invalid packet's level")
+	}
+	ms = h.rentSig(size)
+	defer func() {
+		if err != nil {
+			h.pool.put(ms)
+			ms = nil
+		}
+	}()
+	m := ms.ms
+	// the bitset rented is of the size of the level, the one unmarshalled
+	// when it is valid
+	err = m.Unmarshal(p.MultiSig, h.cons.Signature(), func(int) BitSet { return m.BitSet })
 	if err != nil {
 		return
 	}
-
-	// level is already check before
-	lvl, _ := h.levels[int(p.Level)]
-	if m.BitLength() != len(lvl.nodes) {
-		err = errors.New("invalid bitset's size for given level")
-		return
+	if m.BitLength() != size {
+		if level, err = h.remapLevel(p, m.BitLength()); err != nil {
+			return
+		}
 	}
 	if m.None() {
 		err = errors.New("no signature in the bitset")
 		return
 	}
-	ms = &incomingSig{
-		origin: p.Origin,
-		level:  p.Level,
-		ms:     m,
+	previous, err := h.parsePreviousKeys(p)
+	if err != nil {
+		return
 	}
+	ms.origin = p.Origin
+	ms.level = byte(level)
+	ms.previous = previous
 
 	if p.IndividualSig == nil {
 		return
 	}
+	ind, err = h.parseIndividual(p, level)
+	if ind != nil {
+		ind.previous = previous
+	}
+	return
+}
+
+// parseIndividual returns the individual signature of the packet, at the
+// given level
+func (h *Handel) parseIndividual(p *Packet, level int) (*IncomingSig, error) {
 	individual := h.cons.Signature()
-	if err = individual.UnmarshalBinary(p.IndividualSig); err != nil {
+	if err := individual.UnmarshalBinary(p.IndividualSig); err != nil {
+		return nil, err
+	}
+	levelIndex, err := h.Partitioner.IndexAtLevel(p.Origin, level)
+	if err != nil {
+		return nil, err
+	}
+	ind := h.rentSig(h.Partitioner.Size(level))
+	ind.ms.BitSet.Set(levelIndex, true)
+	ind.ms.Signature = individual
+	ind.origin = p.Origin
+	ind.level = byte(level)
+	ind.isInd = true
+	ind.mappedIndex = levelIndex
+	return ind, nil
+}
+
+// newFullPacket decomposes a packet of the UpdateFull payload and sends the
+// signatures of the levels not completed yet to processing. It must be
+// called with the lock held.
+func (h *Handel) newFullPacket(p *Packet, tier int) {
+	sigs, err := h.parseFull(p)
+	if err != nil {
+		h.log.Warn("invalid_packet - full", err)
 		return
 	}
-	bs := h.c.NewBitSet(len(lvl.nodes))
-	var levelIndex int
-	levelIndex, err = h.Partitioner.IndexAtLevel(p.Origin, int(p.Level))
+	previous, err := h.parsePreviousKeys(p)
 	if err != nil {
+		h.log.Warn("invalid_packet - previous keys", err)
 		return
 	}
-	bs.Set(levelIndex, true)
-	msind := &MultiSignature{BitSet: bs, Signature: individual}
-	ind = &incomingSig{
-		origin:      p.Origin,
-		level:       p.Level,
-		ms:          msind,
-		isInd:       true,
-		mappedIndex: levelIndex,
+	if p.IndividualSig != nil {
+		lvl, _, _ := h.Partitioner.LevelOf(int(p.Origin))
+		ind, err := h.parseIndividual(p, lvl)
+		if err != nil {
+			h.log.Warn("invalid_packet - individual", err)
+			return
+		}
+		sigs = append(sigs, ind)
+	}
+	for _, s := range sigs {
+		s.previous = previous
+		if h.getLevel(s.level).rcvCompleted {
+			h.pool.put(s)
+			continue
+		}
+		h.observeStaleness(s)
+		if h.shed(tier, s) {
+			h.pool.put(s)
+			continue
+		}
+		h.log.Debug("rcvd_from", p.Origin, "rcvd_level", s.level, "full", true)
+		h.proc.Add(s)
 	}
-	return
 }
 
 // level keeps all the required state for a given level such as the list of
@@ -453,15 +1163,26 @@ type level struct {
 	// True is this level is completed for the reception, i.e. we have all the sigs
 	rcvCompleted bool
 
+	// Time since the start of Handel of the first signature verified for this
+	// level, and of its completion, zero if none yet. See LevelResult.
+	firstVerified time.Duration
+	completedIn   time.Duration
+
 	// This field reference our current position in our list of peers. Each time
 	// Handel sends an update, it takes the peer at this position and increases
 	// it.
 	sendPos int
 
-	// Count of peers contacted for the current sig
-	// If we sent our current signature to all our peers we stop until we have
-	//  a better signature for this level
-	sendPeersCt int
+	// Count of peers contacted since the beginning, used to detect starvation
+	contacted int
+
+	// The last signature sent to each peer, by position in nodes. The
+	// rotation only stops at the pending peers, counted by pending: the ones
+	// that did not get a signature of our current size yet, or all of them
+	// once rearmed. Once they all got it, we stop until we have a better
+	// signature for this level. See also resendFilter.
+	sent    []sentSig
+	pending int
 
 	// The size of the signature we send at this level. It's not symmetric if
 	//  we don't have a power of two for the numbers of nodes: we may have a number of
@@ -472,6 +1193,30 @@ type level struct {
 	// Size of the current sig we're sending. This allows to check if we have a
 	//  better signature.
 	sendSigSize int
+
+	// Tells if the peer at the given position must be deferred to the end of
+	// the rotation, nil if none is. See Config.AvoidUnresponsive.
+	suspect func(pos int) bool
+	// Positions of the peers deferred to the end of the current rotation
+	deferred []int
+}
+
+// reset clears the state of the level for a new aggregation, keeping its
+// peers in their contact order
+func (l *level) reset() {
+	l.sendStarted = false
+	l.rcvCompleted = false
+	l.firstVerified = 0
+	l.completedIn = 0
+	l.sendPos = 0
+	l.contacted = 0
+	l.sendSigSize = 0
+	l.suspect = nil
+	l.deferred = nil
+	for pos := range l.sent {
+		l.sent[pos] = sentSig{card: -1}
+	}
+	l.rearm()
 }
 
 // newLevel returns a fresh new level at the given id (number) for these given
@@ -486,23 +1231,28 @@ func newLevel(id int, nodes []Identity, sendExpectedFullSize int) *level {
 		sendStarted:          false,
 		rcvCompleted:         false,
 		sendPos:              0,
-		sendPeersCt:          0,
+		sent:                 make([]sentSig, len(nodes)),
 		sendExpectedFullSize: sendExpectedFullSize,
 		sendSigSize:          0,
 	}
+	l.reset()
 	return l
 }
 
 // createLevels generate a map of all the levels for this registry. It currently
-// shuffles the peers to contact for each level.
+// shuffles the peers to contact for each level, unless the partitioner decides
+// the contact order itself.
 func createLevels(c *Config, partitioner Partitioner) map[int]*level {
 	lvls := make(map[int]*level)
 	var firstActive bool
 	sendExpectedFullSize := 1
+	orderer, ordered := partitioner.(ContactOrderer)
 	for _, level := range partitioner.Levels() {
 		nodes2, _ := partitioner.IdentitiesAt(level)
 		nodes := nodes2
-		if !c.DisableShuffling {
+		if ordered {
+			nodes, _ = orderer.ContactOrder(level)
+		} else if !c.DisableShuffling {
 			nodes = make([]Identity, len(nodes2))
 			copy(nodes, nodes2)
 			shuffle(nodes, c.Rand)
@@ -524,7 +1274,22 @@ func createLevels(c *Config, partitioner Partitioner) map[int]*level {
 // 2. the corresponding aggregate signature is complete, i.e. the number of
 // individual contributions equals the number of peers at this level.
 func (l *level) active() bool {
-	return l.started() && l.sendPeersCt < len(l.nodes)
+	return l.started() && l.pending > 0
+}
+
+// rearm makes all the peers of the level pending again, so they are all
+// contacted again. The signatures they got are still known, see resendFilter.
+func (l *level) rearm() {
+	for pos := range l.sent {
+		l.sent[pos].pending = true
+	}
+	l.pending = len(l.nodes)
+}
+
+// isPending returns true if the peer at the given position did not get a
+// signature of our current size yet, or was rearmed since
+func (l *level) isPending(pos int) bool {
+	return l.sent[pos].pending
 }
 
 // started returns true after the waiting time of a level has elapsed. See
@@ -542,33 +1307,96 @@ func (l *level) setStarted() {
 // Select the peers Handel should contact next at this level. Peers are selected
 // on a rolling basis.
 func (l *level) selectNextPeers(count int) ([]Identity, bool) {
+	return l.selectNextPeersBut(count, nil), true
+}
+
+// selectNextPeersBut selects the next peers as selectNextPeers, but leaves out
+// the peers for which skip, if not nil, returns true given their position, as
+// they already got the signature. These peers are not counted as contacted,
+// and are not pending anymore. While some peers are pending, only them are
+// selected, each one at most once per call; once none is, the rotation goes
+// on over all the peers.
+func (l *level) selectNextPeersBut(count int, skip func(pos int) bool) []Identity {
 	size := min(count, len(l.nodes))
-	res := make([]Identity, size)
+	res := make([]Identity, 0, size)
 
+	onlyPending := l.pending > 0
+	visited := make(map[int]bool, size)
 	for i := 0; i < size; i++ {
-		res[i] = l.nodes[l.sendPos]
+		pos, ok := l.nextPos(onlyPending)
+		if !ok || visited[pos] {
+			break
+		}
+		visited[pos] = true
+		skipped := skip != nil && skip(pos)
+		if l.isPending(pos) {
+			l.sent[pos].pending = false
+			l.pending--
+		}
+		if skipped {
+			continue
+		}
+		res = append(res, l.nodes[pos])
+		l.sent[pos].card = l.sendSigSize
+	}
+
+	l.contacted += len(res)
+	return res
+}
+
+// nextPos returns the position of the next peer of the rotation and moves the
+// rotation forward. If onlyPending is true, the peers that are not pending are
+// passed, and it returns false if none is. The peers for which suspect returns
+// true are deferred to the end of the current rotation, in their order.
+func (l *level) nextPos(onlyPending bool) (int, bool) {
+	// each peer is looked at at most once, and the deferred ones once more
+	for scanned := 0; scanned <= 2*len(l.nodes); scanned++ {
+		if onlyPending && l.pending == 0 {
+			break
+		}
+		if l.sendPos == 0 && len(l.deferred) > 0 {
+			pos := l.deferred[0]
+			l.deferred = l.deferred[1:]
+			if !onlyPending || l.isPending(pos) {
+				return pos, true
+			}
+			continue
+		}
+		pos := l.sendPos
 		l.sendPos++
 		if l.sendPos >= len(l.nodes) {
 			l.sendPos = 0
 		}
+		if onlyPending && !l.isPending(pos) {
+			continue
+		}
+		if l.suspect == nil || !l.suspect(pos) {
+			return pos, true
+		}
+		l.deferred = append(l.deferred, pos)
 	}
-
-	l.sendPeersCt += size
-	return res, true
+	return 0, false
 }
 
 // Updates the size of the signature stored at this level if the given sig has a
-// larger cardinality. If it is the case, it resets the counter of the numbers
-// of peers Handel has contacted, in order to eventually propagate the better
-// signature to the whole level.
+// larger cardinality. If it is the case, all the peers are pending again, in
+// order to eventually propagate the better signature to the whole level: the
+// rotation goes on from where it is, and each peer gets the better signature
+// once within a full rotation.
 // If the level is now complete, it returns true; if not it returns false.
 func (l *level) updateSigToSend(sig *MultiSignature) bool {
-	if l.sendSigSize >= sig.Cardinality() {
+	return l.updateSigSize(sig.Cardinality())
+}
+
+// updateSigSize is updateSigToSend given the cardinality of the signature
+func (l *level) updateSigSize(card int) bool {
+	if l.sendSigSize >= card {
 		return false
 	}
 
-	l.sendSigSize = sig.Cardinality()
-	l.sendPeersCt = 0
+	l.sendSigSize = card
+	// all the peers got a smaller signature, if any
+	l.rearm()
 
 	if l.sendSigSize == l.sendExpectedFullSize {
 		// If we have all the signatures to send
@@ -595,4 +1423,14 @@ func (l *level) String() string {
 type HStats struct {
 	msgSentCt int
 	msgRcvCt  int
+	// bytes of the packets sent, per recipient
+	bytesSent int
+	// packets whose origin is not in the registry
+	rejected int
+	// packets whose origin is outside of the subtree, see
+	// Config.MaxAggregationLevel
+	outsideSubtree int
+	// packets without a valid signature of their origin, see
+	// Config.AuthenticatePackets
+	unauthenticated int
 }
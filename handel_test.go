@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"crypto/rand"
 	"fmt"
+	mathRand "math/rand"
+	"runtime"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ConsenSys/handel/bits"
 	"github.com/stretchr/testify/require"
 )
 
@@ -131,7 +135,6 @@ func TestHandelWholeThing(t *testing.T) {
 	n := 32
 	reg, handels := FakeSetup(n)
 	defer CloseHandels(handels)
-	//PrintLog = false
 	t.Logf("%d", reg.Size())
 	for _, h := range handels {
 		go h.Start()
@@ -210,7 +213,10 @@ func TestHandelWholeThing(t *testing.T) {
 
 func TestHandelCheckCompletedLevel(t *testing.T) {
 	n := 8
-	_, handels := FakeSetup(n)
+	// the fast path sends at once, see TestFanout for the spread sends
+	_, handels := FakeSetupWith(n, func(c *Config) {
+		c.FanoutSpread = -1
+	})
 	defer CloseHandels(handels)
 
 	// simulate not-complete signature of level 1 on node 1
@@ -218,7 +224,7 @@ func TestHandelCheckCompletedLevel(t *testing.T) {
 	sender := handels[1]
 	receiver2 := handels[2]
 	inc2 := make(chan *Packet)
-	receiver2.net.(*TestNetwork).lis = []Listener{ChanListener(inc2)}
+	unwrapNetwork(receiver2.net).(*TestNetwork).lis = []Listener{ChanListener(inc2)}
 
 	sig0 := fullIncomingSig(1)
 	// not-complete signature
@@ -257,9 +263,9 @@ func TestHandelCheckFinalSignature(t *testing.T) {
 	type checkFinalTest struct {
 		// one slice represents sigs to store before calling the checkVerified
 		// you can put multiple slices to call checkverified multiple times
-		sigs [][]*incomingSig
+		sigs [][]*IncomingSig
 		// input to the handler
-		input *incomingSig
+		input *IncomingSig
 		// expected output on the output channel
 		out []*MultiSignature
 	}
@@ -279,7 +285,7 @@ func TestHandelCheckFinalSignature(t *testing.T) {
 	// 4-1 -> because that's how you compute the size of a level
 	// -1 -> to just spread out holes to other levels and leave this one still
 	// having one contribution
-	for i := 0; i < pow2(4-1)-1; i++ {
+	for i := 0; i < bits.Pow2(4-1)-1; i++ {
 		pairs3[4].ms.BitSet.Set(i, false)
 	}
 	pairs3[3].ms.BitSet.Set(1, false)
@@ -288,8 +294,8 @@ func TestHandelCheckFinalSignature(t *testing.T) {
 	//fmt.Println("pairs3[4] bitset = ", pairs3[4].ms.BitSet.String())
 	//fmt.Println("pairs3[3] bitset = ", pairs3[3].ms.BitSet.String())
 
-	toMatrix := func(pairs ...[]*incomingSig) [][]*incomingSig {
-		return append(make([][]*incomingSig, 0), pairs...)
+	toMatrix := func(pairs ...[]*IncomingSig) [][]*IncomingSig {
+		return append(make([][]*IncomingSig, 0), pairs...)
 	}
 	var tests = []checkFinalTest{
 		// too lower level signatures
@@ -332,6 +338,144 @@ func TestHandelCheckFinalSignature(t *testing.T) {
 	}
 }
 
+// scriptedStore returns the given full signatures in turn, then the last one
+type scriptedStore struct {
+	SignatureStore
+	full []*MultiSignature
+}
+
+func (s *scriptedStore) FullSignature() *MultiSignature {
+	ms := s.full[0]
+	if len(s.full) > 1 {
+		s.full = s.full[1:]
+	}
+	return newSig(ms.BitSet.Clone())
+}
+
+func TestHandelFinalSignatureSequence(t *testing.T) {
+	n := 16
+	_, handels := FakeSetupWith(n, func(c *Config) { c.Contributions = 8 })
+	defer CloseHandels(handels)
+	h := handels[0]
+	first := func(card int) []int {
+		var bits []int
+		for i := 0; i < card; i++ {
+			bits = append(bits, i)
+		}
+		return bits
+	}
+	last := func(card int) []int {
+		var bits []int
+		for i := n - card; i < n; i++ {
+			bits = append(bits, i)
+		}
+		return bits
+	}
+	// the merges change the signature without adding contributions
+	script := [][]int{first(10), last(10), first(12), last(12), first(12), first(16)}
+	store := &scriptedStore{SignatureStore: h.store}
+	for _, bits := range script {
+		store.full = append(store.full, newSig(bitsOf(n, bits...)))
+	}
+	h.store = store
+	var received []MultiSignature
+	for range script {
+		h.checkFinalSignature(nil)
+		select {
+		case ms := <-h.FinalSignatures():
+			received = append(received, ms)
+			// the copies received are independent of Handel
+			ms.BitSet.Set(0, false)
+		default:
+		}
+		fs, ok := h.LatestFinalSignature()
+		require.True(t, ok)
+		require.Equal(t, uint64(len(received)), fs.Seq)
+		require.Equal(t, h.best.Cardinality(), fs.Cardinality())
+	}
+	require.Len(t, received, 3)
+	for i, card := range []int{10, 12, 16} {
+		require.Equal(t, card-1, received[i].Cardinality())
+	}
+	require.Equal(t, 16, h.best.Cardinality())
+
+	// the latest one is a copy too
+	fs, _ := h.LatestFinalSignature()
+	require.Equal(t, uint64(3), fs.Seq)
+	fs.BitSet.Set(3, false)
+	require.Equal(t, 16, h.best.Cardinality())
+
+	_, handels = FakeSetup(n)
+	defer CloseHandels(handels)
+	_, ok := handels[0].LatestFinalSignature()
+	require.False(t, ok)
+}
+
+func TestHandelCheckFinalSignatureGroups(t *testing.T) {
+	n := 32
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[1]
+	// first half of the registry is group 0, second half group 1
+	groups := make([]int, n)
+	for i := n / 2; i < n; i++ {
+		groups[i] = 1
+	}
+	h.c.Groups = groups
+	h.c.MaxGroupFraction = 0.6
+	h.threshold = 20
+
+	// partial signature for level 5 - node 1's view - i.e. ids 16 to 31
+	partial := func(set int) *IncomingSig {
+		bs := NewWilffBitset(n / 2)
+		for i := 0; i < set; i++ {
+			bs.Set(i, true)
+		}
+		return &IncomingSig{level: 5, ms: newSig(bs)}
+	}
+	waitOut := func() *MultiSignature {
+		select {
+		case ms := <-h.FinalSignatures():
+			return &ms
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		}
+	}
+
+	// group 0 signs instantly: 16 contributions
+	for _, s := range incomingSigs(0, 1, 2, 3, 4) {
+		h.store.Store(s)
+	}
+	h.checkFinalSignature(nil)
+	require.Nil(t, waitOut())
+
+	var tests = []struct {
+		// number of contributions from group 1
+		set  int
+		emit bool
+	}{
+		// 16/20 = 0.8 from group 0
+		{4, false},
+		// 16/26 = 0.615 from group 0
+		{10, false},
+		// 16/27 = 0.592 from group 0
+		{11, true},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d --", i)
+		h.store.Store(partial(test.set))
+		h.checkFinalSignature(nil)
+		ms := waitOut()
+		if !test.emit {
+			require.Nil(t, ms)
+			continue
+		}
+		require.NotNil(t, ms)
+		require.Equal(t, 16+test.set, ms.Cardinality())
+	}
+	require.Equal(t, map[int]int{0: 16, 1: 11}, h.GroupContributions())
+}
+
 func TestHandelParsePacket(t *testing.T) {
 	n := 16
 	registry := FakeRegistry(n)
@@ -439,6 +583,101 @@ func TestHandelCreateLevel(t *testing.T) {
 	require.NotEqual(t, mapping5, mapping1)
 }
 
+// rotationRecorder sends the signatures selected at a level and records the
+// sizes each peer got
+type rotationRecorder struct {
+	l     *level
+	got   map[int32][]int
+	sends int
+}
+
+func (r *rotationRecorder) next(count int) []int32 {
+	sel, _ := r.l.selectNextPeers(count)
+	for _, id := range sel {
+		r.got[id.ID()] = append(r.got[id.ID()], r.l.sendSigSize)
+	}
+	r.sends += len(sel)
+	return ids(sel)
+}
+
+func TestLevelRotationImprovements(t *testing.T) {
+	n := 8
+	l := newLevel(4, FakeRegistry(n).(*arrayRegistry).ids, n)
+	l.setStarted()
+	r := &rotationRecorder{l: l, got: make(map[int32][]int)}
+
+	require.Equal(t, []int32{0, 1, 2}, r.next(3))
+	// the rotation goes on after an improvement, up to the peers which got
+	// the previous signature
+	require.False(t, l.updateSigSize(2))
+	require.Equal(t, []int32{3, 4, 5, 6, 7}, r.next(5))
+	require.True(t, l.active())
+	require.Equal(t, []int32{0, 1, 2}, r.next(5))
+	require.False(t, l.active())
+	// once every peer got it, the rotation goes on over all of them
+	require.Equal(t, []int32{3, 4}, r.next(2))
+	require.Equal(t, map[int32][]int{
+		0: {0, 2}, 1: {0, 2}, 2: {0, 2}, 3: {2, 2}, 4: {2, 2}, 5: {2}, 6: {2}, 7: {2},
+	}, r.got)
+
+	// an improvement in the middle of the rotation
+	r.got = make(map[int32][]int)
+	require.False(t, l.updateSigSize(3))
+	require.Equal(t, []int32{5, 6}, r.next(2))
+	require.False(t, l.updateSigSize(5))
+	require.Equal(t, []int32{7, 0, 1, 2, 3, 4, 5, 6}, r.next(n))
+	require.False(t, l.active())
+	require.Equal(t, map[int32][]int{
+		0: {5}, 1: {5}, 2: {5}, 3: {5}, 4: {5}, 5: {3, 5}, 6: {3, 5}, 7: {5},
+	}, r.got)
+
+	// a skipped peer already got the signature: it is not pending anymore
+	require.False(t, l.updateSigSize(6))
+	require.Equal(t, []int32{7, 1}, ids(l.selectNextPeersBut(3, func(pos int) bool { return pos == 0 })))
+	require.Equal(t, []int32{2, 3, 4, 5, 6}, ids(l.selectNextPeersBut(n, nil)))
+	require.False(t, l.active())
+}
+
+// TestLevelRotationScript interleaves random improvements and selections,
+// checking each peer gets each improvement once within a full rotation, and
+// that the number of signatures sent stays bounded.
+func TestLevelRotationScript(t *testing.T) {
+	n := 16
+	for seed := int64(0); seed < 20; seed++ {
+		rnd := mathRand.New(mathRand.NewSource(seed))
+		l := newLevel(5, FakeRegistry(n).(*arrayRegistry).ids, 1000)
+		l.setStarted()
+		r := &rotationRecorder{l: l, got: make(map[int32][]int)}
+
+		improvements := 0
+		card := 0
+		for step := 0; step < 200; step++ {
+			if rnd.Intn(4) == 0 {
+				card += 1 + rnd.Intn(3)
+				l.updateSigSize(card)
+				improvements++
+			}
+			if l.active() {
+				r.next(1 + rnd.Intn(4))
+			}
+		}
+		// a full rotation after the last improvement
+		for l.active() {
+			r.next(1 + rnd.Intn(4))
+		}
+
+		require.True(t, r.sends <= (improvements+1)*n, "seed %d: %d sends", seed, r.sends)
+		for pos, id := range l.nodes {
+			cards := r.got[id.ID()]
+			require.NotEmpty(t, cards, "seed %d: peer %d starved", seed, pos)
+			require.Equal(t, card, cards[len(cards)-1], "seed %d", seed)
+			for i := 1; i < len(cards); i++ {
+				require.True(t, cards[i-1] < cards[i], "seed %d: peer %d got %v", seed, pos, cards)
+			}
+		}
+	}
+}
+
 type infiniteTimeout struct {
 }
 
@@ -452,3 +691,280 @@ func (l *infiniteTimeout) Start() {}
 
 // Stop implements the interface
 func (l *infiniteTimeout) Stop() {}
+
+// nopNetwork is a Network dropping all packets
+type nopNetwork struct{}
+
+func (n *nopNetwork) RegisterListener(Listener) {}
+func (n *nopNetwork) Send([]Identity, *Packet)  {}
+
+func TestHandelCloseNoLeak(t *testing.T) {
+	n := 8
+	reg := FakeRegistry(n)
+	conf := &Config{UpdatePeriod: time.Millisecond, Deadline: time.Hour}
+	newHandel := func() *Handel {
+		id, _ := reg.Identity(1)
+		return NewHandel(new(nopNetwork), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	// waits for the number of goroutines of the process to go back to at
+	// most max: the routines of the runtime and of the previous tests may
+	// take a while to return
+	settled := func(max int) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > max {
+			if time.Now().After(deadline) {
+				return false
+			}
+			runtime.Gosched()
+			time.Sleep(time.Millisecond)
+		}
+		return true
+	}
+
+	// nothing is running nor scheduled before Start
+	before := runtime.NumGoroutine()
+	h := newHandel()
+	require.Nil(t, h.ticker)
+	require.Equal(t, before, runtime.NumGoroutine())
+	require.NoError(t, h.Close())
+	require.NoError(t, h.Close())
+
+	instances := 1000
+	for i := 0; i < instances; i++ {
+		h := newHandel()
+		h.Start()
+		require.True(t, runtime.NumGoroutine() > before)
+		if i%2 == 0 {
+			// let the routines do some work
+			runtime.Gosched()
+		}
+		require.NoError(t, h.Close())
+		h.Start()
+	}
+	require.True(t, settled(before), "%d goroutines left, %d before", runtime.NumGoroutine(), before)
+}
+
+func TestHandelPortPerLevel(t *testing.T) {
+	n := 8
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "127.0.0.1:"+strconv.Itoa(3000+i*10), &fakePublic{true})
+	}
+	reg := NewArrayRegistry(ids)
+	ms := newSig(fullBitset(2))
+
+	for _, perLevel := range []bool{false, true} {
+		t.Logf(" -- port per level %v -- ", perLevel)
+		net := new(recordNetwork)
+		conf := &Config{PortPerLevel: perLevel}
+		h := NewHandel(net, reg, ids[1], new(fakeCons), msg, &fakeSig{true}, conf)
+		h.sendTo(2, ids[2:4], ms, nil)
+
+		sent := net.recipients()
+		require.Len(t, sent, 2)
+		for i, id := range sent {
+			exp := ids[2+i].Address()
+			if perLevel {
+				exp = "127.0.0.1:" + strconv.Itoa(3000+(2+i)*10+2)
+			}
+			require.Equal(t, exp, id.Address())
+			require.Equal(t, ids[2+i].ID(), id.ID())
+		}
+	}
+}
+
+func TestHandelOnThreshold(t *testing.T) {
+	n := 16
+	config := DefaultConfig(n)
+	config.Contributions = n / 2
+	config.Logger = &warnLogger{}
+	notified := make(chan MultiSignature, 2*n)
+	var handels []*Handel
+	config.OnThreshold = func(ms MultiSignature) {
+		// called outside of the lock: calling back into Handel is safe
+		handels[0].LatestFinalSignature()
+		notified <- ms
+	}
+	handels = fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+
+	// the final signatures keep improving up to all the contributions
+	for i, h := range handels {
+		for done := false; !done; {
+			select {
+			case ms := <-h.FinalSignatures():
+				done = ms.Cardinality() == n
+			case <-time.After(5 * time.Second):
+				t.Fatalf("node %d did not get all the contributions", i)
+			}
+		}
+	}
+	// each node notified the threshold once: Close joins the callbacks
+	CloseHandels(handels)
+	require.Len(t, notified, n)
+	for i := 0; i < n; i++ {
+		ms := <-notified
+		require.True(t, ms.Cardinality() >= config.Contributions)
+	}
+}
+
+func TestHandelBestSignature(t *testing.T) {
+	n := 16
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = &warnLogger{}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+
+	// only our own contribution before the start
+	h := handels[0]
+	card, threshold := h.Progress()
+	require.Equal(t, 1, card)
+	require.Equal(t, n, threshold)
+	best := h.BestSignature()
+	require.Equal(t, 1, best.Cardinality())
+
+	// the observers modify the copies they get while the packets arrive
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				ms := h.BestSignature()
+				card, _ := h.Progress()
+				require.True(t, ms.Cardinality() <= card)
+				for j := 0; j < ms.BitLength(); j++ {
+					ms.Set(j, true)
+				}
+			}
+		}()
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	var final MultiSignature
+	select {
+	case final = <-h.FinalSignatures():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no final signature")
+	}
+	close(stop)
+	wg.Wait()
+
+	best = h.BestSignature()
+	require.Equal(t, final.BitSet.String(), best.BitSet.String())
+	card, _ = h.Progress()
+	require.Equal(t, n, card)
+	// the copy does not share the bitset of the best signature
+	best.Set(0, false)
+	require.Equal(t, n, h.BestSignature().Cardinality())
+}
+
+func TestHandelReset(t *testing.T) {
+	n := 16
+	_, handels := FakeSetupWith(n, func(c *Config) {
+		c.Contributions = n
+	})
+	defer CloseHandels(handels)
+
+	msgs := [][]byte{msg, []byte("second message"), []byte("third message")}
+	for i, m := range msgs {
+		if i > 0 {
+			for _, h := range handels {
+				require.NoError(t, h.Reset(m, &fakeSig{true}))
+				require.Equal(t, Running, h.Result().Outcome)
+				require.Equal(t, m, h.msg)
+			}
+		}
+		for _, h := range handels {
+			h.Start()
+		}
+		require.Error(t, handels[0].Reset(m, &fakeSig{true}))
+		for id, h := range handels {
+			select {
+			case ms := <-h.FinalSignatures():
+				require.Equal(t, n, ms.Cardinality())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("message %d: node %d did not get all the contributions", i, id)
+			}
+		}
+		for _, h := range handels {
+			h.Stop()
+			require.Equal(t, ThresholdMet, h.Result().Outcome)
+		}
+	}
+	// the levels restart as new
+	lvl := handels[0].levels[handels[0].ids[1]]
+	require.NoError(t, handels[0].Reset(msg, &fakeSig{true}))
+	require.False(t, lvl.started())
+	require.False(t, lvl.rcvCompleted)
+	require.Zero(t, lvl.sendSigSize)
+	require.True(t, handels[0].levels[handels[0].ids[0]].started())
+}
+
+func TestHandelIndividualSigsFillGaps(t *testing.T) {
+	n := 8
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = &warnLogger{}
+	level := 3
+	// the packets of the level of two nodes whose aggregates overlap, with
+	// their individual signatures or not
+	packets := func(h *Handel, individual bool) []*Packet {
+		size := h.Partitioner.Size(level)
+		ids, err := h.Partitioner.IdentitiesAt(level)
+		require.NoError(t, err)
+		first, second := ids[0].ID(), ids[2].ID()
+		p1 := aggregatePacket(t, second, level, size, 1, 2, 3)
+		p2 := aggregatePacket(t, first, level, size, 0, 1)
+		if individual {
+			return []*Packet{withIndividual(p1), withIndividual(p2)}
+		}
+		return []*Packet{p1, p2}
+	}
+	// the contributions at the level once the packets are processed: the
+	// processing is drained, and closing joins the routine storing the
+	// signatures it verified
+	bestAt := func(h *Handel) int {
+		select {
+		case <-h.proc.(drainer).Drained():
+		case <-time.After(5 * time.Second):
+			t.Fatal("processing not drained")
+		}
+		h.Close()
+		ms, ok := h.store.Best(byte(level))
+		require.True(t, ok)
+		return ms.Cardinality()
+	}
+
+	// the individual signature of the first node completes the aggregate of
+	// the second one, which the first aggregate overlaps
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	h := handels[0]
+	h.Start()
+	for _, p := range packets(h, true) {
+		h.NewPacket(p)
+	}
+	require.Equal(t, h.Partitioner.Size(level), bestAt(h))
+
+	// without them, the gap stays
+	others := fakeHandels(t, n, config)
+	defer CloseHandels(others)
+	h = others[0]
+	h.Start()
+	for _, p := range packets(h, false) {
+		h.NewPacket(p)
+	}
+	require.Equal(t, 3, bestAt(h))
+}
@@ -0,0 +1,164 @@
+package handeltest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/go-kit/kit/log"
+)
+
+// DefaultMessage is the message signed by the nodes of NewCluster
+var DefaultMessage = []byte("handeltest message")
+
+// Quiet is a logger discarding everything
+var Quiet = handel.NewKitLoggerFrom(log.NewNopLogger())
+
+// Cluster holds n Handel instances wired over a Bus
+type Cluster struct {
+	Registry handel.Registry
+	Secrets  []handel.SecretKey
+	Cons     handel.Constructor
+	Msg      []byte
+	Bus      *Bus
+	Handels  []*handel.Handel
+	started  []bool
+}
+
+// NewCluster returns a Cluster of n nodes with the fake keys derived from
+// DefaultSeed, signing DefaultMessage. See NewClusterWith for the override.
+func NewCluster(n int, override func(id int32, c *handel.Config)) *Cluster {
+	reg, secrets := NewRegistry(n, DefaultSeed)
+	return NewClusterWith(reg, secrets, NewConstructor(), DefaultMessage, override)
+}
+
+// NewClusterWith returns a Cluster of the nodes of the registry, signing the
+// message with their secret keys. The config of each node starts as the
+// DefaultConfig of the registry, with a Quiet logger and the StrictInvariants,
+// and is given to the override, if not nil, before creating the node.
+func NewClusterWith(reg handel.Registry, secrets []handel.SecretKey, cons handel.Constructor, msg []byte, override func(id int32, c *handel.Config)) *Cluster {
+	n := reg.Size()
+	c := &Cluster{
+		Registry: reg,
+		Secrets:  secrets,
+		Cons:     cons,
+		Msg:      msg,
+		Bus:      NewBus(n),
+		Handels:  make([]*handel.Handel, n),
+		started:  make([]bool, n),
+	}
+	for i := 0; i < n; i++ {
+		id, _ := reg.Identity(i)
+		sig, err := secrets[i].Sign(msg, nil)
+		if err != nil {
+			panic(err)
+		}
+		config := handel.DefaultConfig(n)
+		config.Logger = Quiet
+		config.StrictInvariants = true
+		if override != nil {
+			override(int32(i), config)
+		}
+		c.Handels[i] = handel.NewHandel(c.Bus.Network(int32(i)), reg, id, cons, msg, sig, config)
+	}
+	return c
+}
+
+// Start starts all the nodes but the offline ones
+func (c *Cluster) Start(offline ...int32) {
+	off := make(map[int32]bool)
+	for _, id := range offline {
+		off[id] = true
+	}
+	for i, h := range c.Handels {
+		if off[int32(i)] {
+			continue
+		}
+		c.started[i] = true
+		h.Start()
+	}
+}
+
+// Stop stops all the nodes and the delivery of the packets
+func (c *Cluster) Stop() {
+	for _, h := range c.Handels {
+		h.Close()
+	}
+	c.Bus.Close()
+}
+
+// Started returns the number of nodes started
+func (c *Cluster) Started() int {
+	var n int
+	for _, s := range c.started {
+		if s {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitThreshold waits until each started node outputs a valid final
+// signature with at least threshold contributions, and returns them by node.
+// It fails the test if they are not all there before the timeout.
+func (c *Cluster) WaitThreshold(t testing.TB, threshold int, timeout time.Duration) []*handel.MultiSignature {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	out := make([]*handel.MultiSignature, len(c.Handels))
+	for i, h := range c.Handels {
+		if !c.started[i] {
+			continue
+		}
+		ms, err := waitCardinality(h, threshold, time.Until(deadline))
+		if err != nil {
+			t.Fatalf("node %d: %s", i, err)
+		}
+		if err := handel.VerifyMultiSignature(c.Msg, ms, c.Registry, c.Cons); err != nil {
+			t.Fatalf("node %d: invalid final signature: %s", i, err)
+		}
+		out[i] = ms
+	}
+	return out
+}
+
+// WaitComplete waits until each started node outputs a valid final signature
+// with the contributions of all the started nodes, see WaitThreshold.
+func (c *Cluster) WaitComplete(t testing.TB, timeout time.Duration) []*handel.MultiSignature {
+	t.Helper()
+	return c.WaitThreshold(t, c.Started(), timeout)
+}
+
+// WaitThreshold waits until the Handel outputs a final signature with at
+// least threshold contributions and returns it. It fails the test if there
+// is none before the timeout.
+func WaitThreshold(t testing.TB, h *handel.Handel, threshold int, timeout time.Duration) *handel.MultiSignature {
+	t.Helper()
+	ms, err := waitCardinality(h, threshold, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+func waitCardinality(h *handel.Handel, threshold int, timeout time.Duration) (*handel.MultiSignature, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var best int
+	for {
+		select {
+		case ms, ok := <-h.FinalSignatures():
+			if !ok {
+				return nil, fmt.Errorf("handel stopped with %d/%d contributions", best, threshold)
+			}
+			if ms.Cardinality() >= threshold {
+				return &ms, nil
+			}
+			if ms.Cardinality() > best {
+				best = ms.Cardinality()
+			}
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout with %d/%d contributions", best, threshold)
+		}
+	}
+}
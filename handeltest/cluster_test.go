@@ -0,0 +1,88 @@
+package handeltest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClusterThreshold is written as a downstream project would
+func TestClusterThreshold(t *testing.T) {
+	n := 16
+	c := handeltest.NewCluster(n, func(id int32, conf *handel.Config) {
+		conf.Contributions = 12
+	})
+	defer c.Stop()
+	c.Start()
+	sigs := c.WaitThreshold(t, 12, 5*time.Second)
+	require.Len(t, sigs, n)
+	for _, ms := range sigs {
+		require.True(t, ms.Cardinality() >= 12)
+	}
+}
+
+func TestClusterComplete(t *testing.T) {
+	var tests = []struct {
+		n       int
+		offline []int32
+		hook    handeltest.Hook
+	}{
+		{4, nil, nil},
+		{16, []int32{3, 9}, nil},
+		{8, nil, handeltest.Hooks(handeltest.Duplicate(1), handeltest.FixedDelay(time.Millisecond))},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		c := handeltest.NewCluster(test.n, func(id int32, conf *handel.Config) {
+			conf.Contributions = test.n - len(test.offline)
+		})
+		c.Bus.SetHook(test.hook)
+		c.Start(test.offline...)
+		sigs := c.WaitComplete(t, 10*time.Second)
+		for _, id := range test.offline {
+			require.Nil(t, sigs[id])
+		}
+		for _, ms := range sigs {
+			if ms == nil {
+				continue
+			}
+			for _, id := range test.offline {
+				require.False(t, ms.Get(int(id)))
+			}
+		}
+		c.Stop()
+	}
+}
+
+func TestClusterDrops(t *testing.T) {
+	n := 16
+	c := handeltest.NewCluster(n, func(id int32, conf *handel.Config) {
+		conf.Contributions = 12
+	})
+	defer c.Stop()
+	c.Bus.SetHook(handeltest.DropRate(0.3, 42))
+	c.Start()
+	c.WaitThreshold(t, 12, 10*time.Second)
+	require.True(t, c.Bus.Dropped() > 0)
+}
+
+func TestClusterBN256(t *testing.T) {
+	n := 8
+	reg, secrets := handeltest.NewBN256Registry(n)
+	c := handeltest.NewClusterWith(reg, secrets, bn256.NewConstructor(), []byte("Sun is Shining..."), nil)
+	defer c.Stop()
+	c.Start()
+	c.WaitComplete(t, 10*time.Second)
+}
+
+func TestWaitThreshold(t *testing.T) {
+	c := handeltest.NewCluster(4, nil)
+	defer c.Stop()
+	c.Start()
+	ms := handeltest.WaitThreshold(t, c.Handels[0], 4, 5*time.Second)
+	require.Equal(t, 4, ms.Cardinality())
+}
@@ -0,0 +1,156 @@
+// Package handeltest provides deterministic fakes and helpers to test Handel
+// and the code built on it: a fake signature scheme, an in-memory network
+// with fault injection, registry builders and a Cluster wiring n Handel
+// instances together.
+//
+// The fake signatures are cheap and aggregate like BLS signatures, but they
+// are NOT secure. DO NOT USE THEM IN PRODUCTION.
+//
+// The internal tests of package handel can't import this package without
+// import cycle, its external tests use it.
+package handeltest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ConsenSys/handel"
+)
+
+// The fake signature scheme works over the integers modulo 2^64: a public
+// key is equal to its secret key, and the signature of a message is the
+// secret key times the hash of the message. Signatures and public keys
+// aggregate by addition, so an aggregate signature verifies under the
+// aggregate public key of its signers.
+
+// DefaultSeed is the seed of the keys of NewCluster
+var DefaultSeed = []byte("handeltest")
+
+// Constructor is the handel.Constructor of the fake signature scheme
+type Constructor struct{}
+
+// NewConstructor returns the constructor of the fake signature scheme
+func NewConstructor() *Constructor {
+	return new(Constructor)
+}
+
+// Signature implements the handel.Constructor interface
+func (c *Constructor) Signature() handel.Signature {
+	return new(Signature)
+}
+
+// PublicKey implements the handel.Constructor interface. The empty public key
+// is the neutral element of the aggregation.
+func (c *Constructor) PublicKey() handel.PublicKey {
+	return new(PublicKey)
+}
+
+// SecretKey is a fake secret key
+type SecretKey struct {
+	v uint64
+}
+
+// NewSecretKey returns the i-th secret key derived from the seed. The same
+// seed and index always give the same key.
+func NewSecretKey(seed []byte, i int) *SecretKey {
+	h := sha256.New()
+	h.Write(seed)
+	binary.Write(h, binary.BigEndian, uint32(i))
+	return &SecretKey{binary.BigEndian.Uint64(h.Sum(nil))}
+}
+
+// PublicKey returns the public key of the secret key
+func (s *SecretKey) PublicKey() *PublicKey {
+	return &PublicKey{s.v}
+}
+
+// Sign implements the handel.SecretKey interface. The signature is
+// deterministic and the randomness source is not used.
+func (s *SecretKey) Sign(msg []byte, r io.Reader) (handel.Signature, error) {
+	return &Signature{s.v * hashMessage(msg)}, nil
+}
+
+// PublicKey is a fake public key
+type PublicKey struct {
+	v uint64
+}
+
+// VerifySignature implements the handel.PublicKey interface
+func (p *PublicKey) VerifySignature(msg []byte, sig handel.Signature) error {
+	s, ok := sig.(*Signature)
+	if !ok {
+		return fmt.Errorf("handeltest: invalid signature type %T", sig)
+	}
+	if s.v != p.v*hashMessage(msg) {
+		return errors.New("handeltest: invalid signature")
+	}
+	return nil
+}
+
+// Combine implements the handel.PublicKey interface
+func (p *PublicKey) Combine(pp handel.PublicKey) handel.PublicKey {
+	return &PublicKey{p.v + pp.(*PublicKey).v}
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (p *PublicKey) MarshalBinary() ([]byte, error) {
+	return marshalUint64(p.v), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (p *PublicKey) UnmarshalBinary(buff []byte) (err error) {
+	p.v, err = unmarshalUint64(buff)
+	return err
+}
+
+func (p *PublicKey) String() string {
+	return fmt.Sprintf("fake-pk-%016x", p.v)
+}
+
+// Signature is a fake signature
+type Signature struct {
+	v uint64
+}
+
+// MarshalBinary implements the handel.Signature interface
+func (s *Signature) MarshalBinary() ([]byte, error) {
+	return marshalUint64(s.v), nil
+}
+
+// UnmarshalBinary implements the handel.Signature interface
+func (s *Signature) UnmarshalBinary(buff []byte) (err error) {
+	s.v, err = unmarshalUint64(buff)
+	return err
+}
+
+// Combine implements the handel.Signature interface
+func (s *Signature) Combine(ss handel.Signature) handel.Signature {
+	return &Signature{s.v + ss.(*Signature).v}
+}
+
+func (s *Signature) String() string {
+	return fmt.Sprintf("fake-sig-%016x", s.v)
+}
+
+// hashMessage returns the hash of the message as an odd integer, so that
+// distinct keys give distinct signatures.
+func hashMessage(msg []byte) uint64 {
+	h := sha256.Sum256(msg)
+	return binary.BigEndian.Uint64(h[:]) | 1
+}
+
+func marshalUint64(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+func unmarshalUint64(buff []byte) (uint64, error) {
+	if len(buff) != 8 {
+		return 0, fmt.Errorf("handeltest: invalid length %d", len(buff))
+	}
+	return binary.BigEndian.Uint64(buff), nil
+}
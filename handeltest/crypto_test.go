@@ -0,0 +1,50 @@
+package handeltest
+
+import (
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeSignatures(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	n := 8
+	reg, secrets := NewRegistry(n, DefaultSeed)
+	reg2, _ := NewRegistry(n, DefaultSeed)
+	reg3, _ := NewRegistry(n, []byte("another seed"))
+	cons := NewConstructor()
+
+	bs := handel.NewWilffBitset(n)
+	agg := cons.Signature()
+	for i := 0; i < n; i++ {
+		id, _ := reg.Identity(i)
+		id2, _ := reg2.Identity(i)
+		id3, _ := reg3.Identity(i)
+		require.Equal(t, id.PublicKey(), id2.PublicKey())
+		require.NotEqual(t, id.PublicKey(), id3.PublicKey())
+
+		sig, err := secrets[i].Sign(msg, nil)
+		require.NoError(t, err)
+		require.NoError(t, id.PublicKey().VerifySignature(msg, sig))
+		require.Error(t, id.PublicKey().VerifySignature([]byte("another message"), sig))
+		require.Error(t, id3.PublicKey().VerifySignature(msg, sig))
+
+		buff, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		sig2 := cons.Signature()
+		require.NoError(t, sig2.UnmarshalBinary(buff))
+		require.Equal(t, sig, sig2)
+		require.Error(t, sig2.UnmarshalBinary(buff[1:]))
+
+		if i%3 != 0 {
+			bs.Set(i, true)
+			agg = agg.Combine(sig)
+		}
+	}
+	ms := &handel.MultiSignature{BitSet: bs, Signature: agg}
+	require.NoError(t, handel.VerifyMultiSignature(msg, ms, reg, cons))
+	// a contribution missing from the bitset
+	bs.Set(1, false)
+	require.Error(t, handel.VerifyMultiSignature(msg, ms, reg, cons))
+}
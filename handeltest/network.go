@@ -0,0 +1,228 @@
+package handeltest
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Delivery tells the Bus what to do with a packet
+type Delivery struct {
+	// Drop discards the packet
+	Drop bool
+	// Delay postpones the delivery
+	Delay time.Duration
+	// Duplicates is the number of extra copies of the packet delivered
+	Duplicates int
+}
+
+// Hook decides the delivery of each packet sent by a node to another. It is
+// called concurrently by the senders.
+type Hook func(from, to int32, p *handel.Packet) Delivery
+
+// Hooks returns a Hook combining the given ones: a packet is dropped if one of
+// them drops it, and the delays and duplicates add up.
+func Hooks(hooks ...Hook) Hook {
+	return func(from, to int32, p *handel.Packet) Delivery {
+		var d Delivery
+		for _, h := range hooks {
+			d2 := h(from, to, p)
+			d.Drop = d.Drop || d2.Drop
+			d.Delay += d2.Delay
+			d.Duplicates += d2.Duplicates
+		}
+		return d
+	}
+}
+
+// DropRate returns a Hook dropping the given fraction of the packets, picked
+// by a random source of the given seed.
+func DropRate(rate float64, seed int64) Hook {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func(from, to int32, p *handel.Packet) Delivery {
+		mu.Lock()
+		defer mu.Unlock()
+		return Delivery{Drop: r.Float64() < rate}
+	}
+}
+
+// FixedDelay returns a Hook delaying all the packets
+func FixedDelay(d time.Duration) Hook {
+	return func(from, to int32, p *handel.Packet) Delivery {
+		return Delivery{Delay: d}
+	}
+}
+
+// Duplicate returns a Hook delivering each packet 1+copies times
+func Duplicate(copies int) Hook {
+	return func(from, to int32, p *handel.Packet) Delivery {
+		return Delivery{Duplicates: copies}
+	}
+}
+
+// Bus connects in-memory networks to each other: a packet sent to the
+// identity with ID i is dispatched to the listeners of the i-th network, in
+// its own goroutine as with a real network. A Hook can drop, delay or
+// duplicate the packets.
+type Bus struct {
+	nets      []*Network
+	hook      atomic.Value
+	closed    int32
+	delivered int64
+	dropped   int64
+
+	// signaled once enough packets are delivered, see WaitDelivered
+	mu      sync.Mutex
+	waiters []deliveryWaiter
+}
+
+// deliveryWaiter is closed once n packets are delivered
+type deliveryWaiter struct {
+	n  int
+	ch chan struct{}
+}
+
+// NewBus returns a Bus of n networks
+func NewBus(n int) *Bus {
+	b := &Bus{nets: make([]*Network, n)}
+	for i := range b.nets {
+		b.nets[i] = &Network{id: int32(i), bus: b}
+	}
+	return b
+}
+
+// Network returns the network of the given node
+func (b *Bus) Network(id int32) *Network {
+	return b.nets[id]
+}
+
+// Networks returns the networks of all the nodes, by ID
+func (b *Bus) Networks() []handel.Network {
+	nets := make([]handel.Network, len(b.nets))
+	for i, n := range b.nets {
+		nets[i] = n
+	}
+	return nets
+}
+
+// SetHook sets the hook deciding the delivery of the packets sent from now
+// on, nil to deliver them all.
+func (b *Bus) SetHook(h Hook) {
+	b.hook.Store(&h)
+}
+
+// Delivered returns the number of packets delivered to the listeners so far,
+// duplicates included
+func (b *Bus) Delivered() int {
+	return int(atomic.LoadInt64(&b.delivered))
+}
+
+// WaitDelivered returns a channel closed once n packets are delivered to the
+// listeners, duplicates included
+func (b *Bus) WaitDelivered(n int) <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := deliveryWaiter{n, make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.notifyDelivered()
+	return w.ch
+}
+
+// notifyDelivered closes the channels of the waiters served. The lock must
+// be held.
+func (b *Bus) notifyDelivered() {
+	delivered := b.Delivered()
+	waiting := b.waiters[:0]
+	for _, w := range b.waiters {
+		if delivered >= w.n {
+			close(w.ch)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	b.waiters = waiting
+}
+
+// Dropped returns the number of packets dropped by the hook so far
+func (b *Bus) Dropped() int {
+	return int(atomic.LoadInt64(&b.dropped))
+}
+
+// Close stops the delivery of the packets, including the delayed ones
+func (b *Bus) Close() {
+	atomic.StoreInt32(&b.closed, 1)
+}
+
+func (b *Bus) send(from int32, ids []handel.Identity, p *handel.Packet) {
+	var hook Hook
+	if h, ok := b.hook.Load().(*Hook); ok {
+		hook = *h
+	}
+	for _, id := range ids {
+		to := id.ID()
+		if to < 0 || int(to) >= len(b.nets) {
+			continue
+		}
+		var d Delivery
+		if hook != nil {
+			d = hook(from, to, p)
+		}
+		if d.Drop {
+			atomic.AddInt64(&b.dropped, 1)
+			continue
+		}
+		// each recipient gets its own copy of the packet header
+		cp := *p
+		for i := 0; i <= d.Duplicates; i++ {
+			if d.Delay > 0 {
+				time.AfterFunc(d.Delay, func() { b.deliver(to, &cp) })
+			} else {
+				go b.deliver(to, &cp)
+			}
+		}
+	}
+}
+
+func (b *Bus) deliver(to int32, p *handel.Packet) {
+	if atomic.LoadInt32(&b.closed) != 0 {
+		return
+	}
+	b.nets[to].dispatch(p)
+	atomic.AddInt64(&b.delivered, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.notifyDelivered()
+}
+
+// Network is the in-memory network of a node of a Bus
+type Network struct {
+	id  int32
+	bus *Bus
+	sync.Mutex
+	lis []handel.Listener
+}
+
+// Send implements the handel.Network interface
+func (n *Network) Send(ids []handel.Identity, p *handel.Packet) {
+	n.bus.send(n.id, ids, p)
+}
+
+// RegisterListener implements the handel.Network interface
+func (n *Network) RegisterListener(l handel.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.lis = append(n.lis, l)
+}
+
+func (n *Network) dispatch(p *handel.Packet) {
+	n.Lock()
+	lis := n.lis
+	n.Unlock()
+	for _, l := range lis {
+		l.NewPacket(p)
+	}
+}
@@ -0,0 +1,81 @@
+package handeltest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+type listenFunc func(*handel.Packet)
+
+func (l listenFunc) NewPacket(p *handel.Packet) { l(p) }
+
+func TestBusHooks(t *testing.T) {
+	var tests = []struct {
+		hook Hook
+		// number of packets sent, half to the node 1 and half to the node 2
+		sent     int
+		received int
+		dropped  int
+		// minimum delay of the deliveries
+		delay time.Duration
+	}{
+		{nil, 100, 100, 0, 0},
+		{Duplicate(2), 10, 30, 0, 0},
+		{FixedDelay(50 * time.Millisecond), 10, 10, 0, 50 * time.Millisecond},
+		{DropRate(1, 1), 10, 0, 10, 0},
+		{Hooks(Duplicate(1), FixedDelay(20*time.Millisecond)), 10, 20, 0, 20 * time.Millisecond},
+		// only the packets to node 2 are dropped
+		{func(from, to int32, p *handel.Packet) Delivery { return Delivery{Drop: to == 2} }, 10, 5, 5, 0},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		bus := NewBus(3)
+		bus.SetHook(test.hook)
+		reg, _ := NewRegistry(3, DefaultSeed)
+		ids, _ := reg.Identities(1, 3)
+		var mu sync.Mutex
+		var received int
+		for _, id := range ids {
+			bus.Network(id.ID()).RegisterListener(listenFunc(func(p *handel.Packet) {
+				mu.Lock()
+				defer mu.Unlock()
+				received++
+			}))
+		}
+		start := time.Now()
+		for j := 0; j < test.sent/2; j++ {
+			bus.Network(0).Send(ids, &handel.Packet{Origin: 0, Level: 1})
+		}
+		select {
+		case <-bus.WaitDelivered(test.received):
+		case <-time.After(time.Second):
+		}
+		require.Equal(t, test.received, bus.Delivered())
+		require.True(t, time.Since(start) >= test.delay)
+		require.Equal(t, test.dropped, bus.Dropped())
+		mu.Lock()
+		require.Equal(t, test.received, received)
+		mu.Unlock()
+		bus.Close()
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	bus := NewBus(2)
+	bus.SetHook(FixedDelay(20 * time.Millisecond))
+	received := make(chan bool, 1)
+	bus.Network(1).RegisterListener(listenFunc(func(p *handel.Packet) { received <- true }))
+	reg, _ := NewRegistry(2, DefaultSeed)
+	id, _ := reg.Identity(1)
+	bus.Network(0).Send([]handel.Identity{id}, &handel.Packet{})
+	bus.Close()
+	select {
+	case <-received:
+		t.Fatal("packet delivered after close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,38 @@
+package handeltest
+
+import (
+	"crypto/rand"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+)
+
+// NewRegistry returns a registry of n identities with the fake keys derived
+// from the seed, and their secret keys. The identities have no address.
+func NewRegistry(n int, seed []byte) (handel.Registry, []handel.SecretKey) {
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := 0; i < n; i++ {
+		sk := NewSecretKey(seed, i)
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), "", sk.PublicKey())
+	}
+	return handel.NewArrayRegistry(ids), secrets
+}
+
+// NewBN256Registry returns a registry of n identities with random BLS keys on
+// the BN256 curve, and their secret keys, to use with bn256.NewConstructor.
+// The identities have no address.
+func NewBN256Registry(n int) (handel.Registry, []handel.SecretKey) {
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := 0; i < n; i++ {
+		sk, pk, err := bn256.NewKeyPair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), "", pk)
+	}
+	return handel.NewArrayRegistry(ids), secrets
+}
@@ -0,0 +1,196 @@
+package handel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus is the overall classification of a HealthReport
+type HealthStatus int
+
+const (
+	// HealthOK means all the routines of Handel are running
+	HealthOK HealthStatus = iota
+	// HealthDegraded means Handel runs but can't make progress as expected,
+	// e.g. it does not receive any packet, or is not running yet or anymore
+	HealthDegraded
+	// HealthFailed means a routine of Handel is stuck
+	HealthFailed
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	default:
+		return "failed"
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface
+func (s HealthStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// HealthReport is the health of a Handel instance, see Handel.Health.
+type HealthReport struct {
+	Status HealthStatus `json:"status"`
+	// Reasons explain why the status is not ok
+	Reasons []string `json:"reasons,omitempty"`
+	// Last activity of the periodic loop, of the network and of the
+	// processing. Zero if none yet.
+	LastTick     time.Time `json:"lastTick"`
+	LastPacket   time.Time `json:"lastPacket"`
+	LastVerified time.Time `json:"lastVerified"`
+	// ProcessingQueue is the number of signatures waiting for verification,
+	// -1 if the processing does not report it.
+	ProcessingQueue int `json:"processingQueue"`
+	// ActorQueue is the number of calls waiting for the async actors
+	ActorQueue int `json:"actorQueue"`
+}
+
+// degrade lowers the status of the report to the given one, with the reason
+func (r *HealthReport) degrade(s HealthStatus, format string, args ...interface{}) {
+	if s > r.Status {
+		r.Status = s
+	}
+	r.Reasons = append(r.Reasons, fmt.Sprintf(format, args...))
+}
+
+// heartbeats holds the last activity of Handel's routines, mostly as unix
+// nanoseconds. They are updated atomically on the hot paths and read without
+// Handel's lock, so the health is available even if a routine holds it.
+type heartbeats struct {
+	started  int64
+	stopped  int64
+	tick     int64
+	verified int64
+	// number of packets received: the packet path only increments it, the
+	// time of the last packet is observed when the count changes, by the
+	// periodic loop and by Health
+	packets int64
+	sync.Mutex
+	seen   int64
+	packet time.Time
+}
+
+// reset forgets the activity of a previous aggregation, see Handel.Reset
+func (b *heartbeats) reset() {
+	for _, beat := range []*int64{&b.started, &b.stopped, &b.tick, &b.verified, &b.packets} {
+		atomic.StoreInt64(beat, 0)
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.seen = 0
+	b.packet = time.Time{}
+}
+
+// received records a packet
+func (b *heartbeats) received() {
+	atomic.AddInt64(&b.packets, 1)
+}
+
+// lastPacket returns the time of the last packet received, at the precision
+// of the observations, i.e. of the update period.
+func (b *heartbeats) lastPacket(now time.Time) time.Time {
+	b.Lock()
+	defer b.Unlock()
+	if n := atomic.LoadInt64(&b.packets); n != b.seen {
+		b.seen = n
+		b.packet = now
+	}
+	return b.packet
+}
+
+func beat(v *int64, now time.Time) {
+	atomic.StoreInt64(v, now.UnixNano())
+}
+
+// lastBeat returns the time of the last beat, zero if none.
+func lastBeat(v *int64) time.Time {
+	n := atomic.LoadInt64(v)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// processingHeartbeat is implemented by the processings reporting their
+// activity: the time they last picked or verified a signature, or were given
+// one while idle, and the number of signatures waiting.
+type processingHeartbeat interface {
+	heartbeat() (time.Time, int)
+}
+
+// Health returns the health of this Handel instance. It is failed if the
+// periodic loop did not tick or if the processing did not progress while
+// signatures are waiting for more than Config.HealthStall, and degraded if
+// no packet was received during this time or if Handel is not running. It
+// does not take Handel's lock and can be called at any time.
+func (h *Handel) Health() HealthReport {
+	now := h.now()
+	stall := h.c.HealthStall
+	r := HealthReport{
+		LastTick:        lastBeat(&h.beats.tick),
+		LastPacket:      h.beats.lastPacket(now),
+		LastVerified:    lastBeat(&h.beats.verified),
+		ProcessingQueue: -1,
+		ActorQueue:      h.queue.len(),
+	}
+	started := lastBeat(&h.beats.started)
+	if started.IsZero() {
+		r.degrade(HealthDegraded, "not started")
+		return r
+	}
+	if atomic.LoadInt64(&h.beats.stopped) != 0 {
+		r.degrade(HealthDegraded, "stopped")
+		return r
+	}
+	// the time elapsed since the last beat, or since the start if none
+	since := func(t time.Time) time.Duration {
+		if t.IsZero() {
+			t = started
+		}
+		return now.Sub(t)
+	}
+	if d := since(r.LastTick); d > stall {
+		r.degrade(HealthFailed, "periodic loop stalled for %s", d)
+	}
+	if p, ok := h.proc.(processingHeartbeat); ok {
+		last, pending := p.heartbeat()
+		r.ProcessingQueue = pending
+		if d := since(last); pending > 0 && d > stall {
+			r.degrade(HealthFailed, "processing stuck for %s with %d signatures waiting", d, pending)
+		}
+	}
+	if d := since(r.LastPacket); d > stall {
+		r.degrade(HealthDegraded, "no packet received for %s", d)
+	}
+	return r
+}
+
+// HealthHandler returns an http.Handler serving the health of the given
+// Handel as JSON, with the status code 200 if ok, 503 if degraded and 500 if
+// failed: a readiness probe can require a 200 and a liveness probe anything
+// but a 500.
+func HealthHandler(h *Handel) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := h.Health()
+		code := http.StatusOK
+		switch r.Status {
+		case HealthDegraded:
+			code = http.StatusServiceUnavailable
+		case HealthFailed:
+			code = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(r)
+	})
+}
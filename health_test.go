@@ -0,0 +1,181 @@
+package handel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// healthHandel returns a Handel of id 1 among 8 identities, over a network
+// dropping all packets. Its periodic loop does not tick by itself: the tests
+// call periodicUpdate.
+func healthHandel(conf *Config) *Handel {
+	return healthHandelWith(conf, FakeRegistry(8), new(fakeCons))
+}
+
+func healthHandelWith(conf *Config, reg Registry, cons Constructor) *Handel {
+	id, _ := reg.Identity(1)
+	conf.HealthStall = 50 * time.Millisecond
+	conf.UpdatePeriod = time.Hour
+	conf.NewTimeoutStrategy = newInfiniteTimeout
+	return NewHandel(new(nopNetwork), reg, id, cons, msg, &fakeSig{true}, conf)
+}
+
+// blockingPublic is a fake public key whose verifications signal they started
+// and wait for the test to release them
+type blockingPublic struct {
+	*fakePublic
+	started chan bool
+	release chan bool
+}
+
+func (b *blockingPublic) VerifySignature(msg []byte, s Signature) error {
+	b.started <- true
+	<-b.release
+	return b.fakePublic.VerifySignature(msg, s)
+}
+
+func (b *blockingPublic) Combine(p PublicKey) PublicKey {
+	return b
+}
+
+type blockingCons struct {
+	fakeCons
+	pub *blockingPublic
+}
+
+func (b *blockingCons) PublicKey() PublicKey {
+	return b.pub
+}
+
+// requireHealth checks the status of the report and that each reason is
+// explained
+func requireHealth(t *testing.T, r HealthReport, status HealthStatus, reasons ...string) {
+	require.Equal(t, status, r.Status, "%v", r.Reasons)
+	require.Len(t, r.Reasons, len(reasons), "%v", r.Reasons)
+	for i, reason := range reasons {
+		require.True(t, strings.HasPrefix(r.Reasons[i], reason), r.Reasons[i])
+	}
+}
+
+func TestHealthSilentNetwork(t *testing.T) {
+	h := healthHandel(&Config{})
+	defer h.Close()
+	clock := newFakeClock()
+	clock.use(h)
+	requireHealth(t, h.Health(), HealthDegraded, "not started")
+
+	h.Start()
+	requireHealth(t, h.Health(), HealthOK)
+	clock.advance(100 * time.Millisecond)
+	h.periodicUpdate()
+	r := h.Health()
+	requireHealth(t, r, HealthDegraded, "no packet received")
+	require.False(t, r.LastTick.IsZero())
+	require.True(t, r.LastPacket.IsZero())
+
+	h.NewPacket(withIndividual(aggregatePacket(t, 0, 1, 1)))
+	r = h.Health()
+	requireHealth(t, r, HealthOK)
+	require.False(t, r.LastPacket.IsZero())
+	require.Equal(t, 0, r.ActorQueue)
+
+	h.Stop()
+	requireHealth(t, h.Health(), HealthDegraded, "stopped")
+}
+
+func TestHealthStoppedTicker(t *testing.T) {
+	h := healthHandel(&Config{})
+	defer h.Close()
+	clock := newFakeClock()
+	clock.use(h)
+	h.Start()
+	// no tick during the stall
+	clock.advance(100 * time.Millisecond)
+	h.NewPacket(withIndividual(aggregatePacket(t, 0, 1, 1)))
+	requireHealth(t, h.Health(), HealthFailed, "periodic loop stalled")
+}
+
+func TestHealthBlockedProcessing(t *testing.T) {
+	pub := &blockingPublic{&fakePublic{true}, make(chan bool, 100), make(chan bool)}
+	ids := make([]Identity, 8)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(int32(i), "", pub)
+	}
+	h := healthHandelWith(&Config{}, NewArrayRegistry(ids), &blockingCons{pub: pub})
+	defer h.Close()
+	defer close(pub.release)
+	clock := newFakeClock()
+	clock.use(h)
+	h.Start()
+	h.NewPacket(withIndividual(aggregatePacket(t, 0, 1, 1)))
+	// the processing is stuck on the first verification
+	<-pub.started
+	h.NewPacket(withIndividual(aggregatePacket(t, 2, 2, 2)))
+	h.NewPacket(withIndividual(aggregatePacket(t, 4, 3, 4)))
+	clock.advance(100 * time.Millisecond)
+	h.periodicUpdate()
+	h.NewPacket(withIndividual(aggregatePacket(t, 0, 1, 1)))
+	r := h.Health()
+	requireHealth(t, r, HealthFailed, "processing stuck")
+	require.True(t, r.ProcessingQueue > 0)
+	require.True(t, r.LastVerified.IsZero())
+}
+
+func TestHealthHandler(t *testing.T) {
+	h := healthHandel(&Config{})
+	defer h.Close()
+	clock := newFakeClock()
+	clock.use(h)
+	get := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		HealthHandler(h).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := get()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "degraded", body["status"])
+	require.Equal(t, []interface{}{"not started"}, body["reasons"])
+
+	h.Start()
+	code, body = get()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body["status"])
+	require.Nil(t, body["reasons"])
+
+	// no tick during the stall
+	clock.advance(100 * time.Millisecond)
+	code, body = get()
+	require.Equal(t, http.StatusInternalServerError, code)
+	require.Equal(t, "failed", body["status"])
+}
+
+// BenchmarkHealthPacketPath compares the heartbeat of the packet path to the
+// handling of a packet.
+func BenchmarkHealthPacketPath(b *testing.B) {
+	h := healthHandel(&Config{})
+	ms, _ := newSig(fullBitset(2)).MarshalBinary()
+	p := &Packet{Origin: 2, Level: 2, MultiSig: ms}
+	// the level is complete, the packet is only parsed
+	h.getLevel(2).rcvCompleted = true
+	b.Run("heartbeat", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.beats.received()
+		}
+	})
+	b.Run("packet", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.NewPacket(p)
+		}
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	mathRand "math/rand"
+	"net"
+	"strconv"
 )
 
 // Identity holds the public information of a Handel node
@@ -18,6 +20,45 @@ type Identity interface {
 	ID() int32
 }
 
+// MultiAddressIdentity is implemented by the identities reachable on several
+// addresses, which one works depending on the network of the sender. Their
+// Address is the first, primary, one. The networks supporting them try the
+// addresses in order.
+type MultiAddressIdentity interface {
+	Identity
+	// Addresses returns the addresses of the node in their order of
+	// preference
+	Addresses() []string
+}
+
+// Addresses returns the addresses of the identity in their order of
+// preference: its Addresses if it is a MultiAddressIdentity, its Address
+// otherwise.
+func Addresses(id Identity) []string {
+	if m, ok := id.(MultiAddressIdentity); ok {
+		return m.Addresses()
+	}
+	return []string{id.Address()}
+}
+
+// RotatingIdentity is implemented by the identities whose key is being
+// rotated: during Config.KeyRotationGrace, Handel accepts their signatures
+// under their previous key as well as under their current one.
+type RotatingIdentity interface {
+	Identity
+	// PreviousPublicKey returns the key PublicKey replaced, nil if none
+	PreviousPublicKey() PublicKey
+}
+
+// PreviousPublicKey returns the previous key of the identity if it is a
+// RotatingIdentity, nil otherwise.
+func PreviousPublicKey(id Identity) PublicKey {
+	if r, ok := id.(RotatingIdentity); ok {
+		return r.PreviousPublicKey()
+	}
+	return nil
+}
+
 // Registry abstracts the bookeeping of the list of Handel nodes
 type Registry interface {
 	// Size returns the total number of Handel nodes
@@ -35,6 +76,10 @@ type fixedIdentity struct {
 	id   int32
 	addr string
 	p    PublicKey
+	// all the addresses, addr first, only set if there are several
+	addrs []string
+	// key replaced by p, if any
+	prev PublicKey
 }
 
 // NewStaticIdentity returns an Identity fixed by these parameters
@@ -46,10 +91,40 @@ func NewStaticIdentity(id int32, addr string, p PublicKey) Identity {
 	}
 }
 
+// NewStaticIdentityAddresses returns an Identity reachable on the given
+// addresses, in their order of preference. It panics if there is none.
+func NewStaticIdentityAddresses(id int32, addrs []string, p PublicKey) Identity {
+	if len(addrs) == 0 {
+		panic("handel: identity without address")
+	}
+	s := &fixedIdentity{id: id, addr: addrs[0], p: p}
+	if len(addrs) > 1 {
+		s.addrs = append([]string{}, addrs...)
+	}
+	return s
+}
+
+// NewRotatingIdentity returns an Identity as NewStaticIdentityAddresses, whose
+// key p replaced the previous one, see RotatingIdentity. It panics if there is
+// no address.
+func NewRotatingIdentity(id int32, addrs []string, p, previous PublicKey) Identity {
+	s := NewStaticIdentityAddresses(id, addrs, p).(*fixedIdentity)
+	s.prev = previous
+	return s
+}
+
 func (s *fixedIdentity) Address() string {
 	return s.addr
 }
 
+// Addresses implements the MultiAddressIdentity interface
+func (s *fixedIdentity) Addresses() []string {
+	if s.addrs == nil {
+		return []string{s.addr}
+	}
+	return s.addrs
+}
+
 func (s *fixedIdentity) ID() int32 {
 	return s.id
 }
@@ -58,6 +133,11 @@ func (s *fixedIdentity) PublicKey() PublicKey {
 	return s.p
 }
 
+// PreviousPublicKey implements the RotatingIdentity interface
+func (s *fixedIdentity) PreviousPublicKey() PublicKey {
+	return s.prev
+}
+
 func (s *fixedIdentity) String() string {
 	if s.addr == "" {
 		return fmt.Sprintf("{id:%d}", s.id)
@@ -65,6 +145,56 @@ func (s *fixedIdentity) String() string {
 	return fmt.Sprintf("{id: %d - %s}", s.id, s.addr)
 }
 
+// LevelAddress returns the address of the given level for the given
+// "host:port" address, i.e. the same host with the port offset by the level.
+// This is the convention used by Config.PortPerLevel: the level 0 address is
+// the address itself.
+func LevelAddress(addr string, level int) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+level)), nil
+}
+
+// levelIdentity is an identity whose addresses are the addresses of a level,
+// see LevelAddress
+type levelIdentity struct {
+	Identity
+	addrs []string
+}
+
+func (l *levelIdentity) Address() string {
+	return l.addrs[0]
+}
+
+// Addresses implements the MultiAddressIdentity interface
+func (l *levelIdentity) Addresses() []string {
+	return l.addrs
+}
+
+// levelIdentities returns the identities with their addresses for the given
+// level.
+func levelIdentities(ids []Identity, level int) ([]Identity, error) {
+	out := make([]Identity, len(ids))
+	for i, id := range ids {
+		addrs := Addresses(id)
+		levelAddrs := make([]string, len(addrs))
+		for j, addr := range addrs {
+			var err error
+			if levelAddrs[j], err = LevelAddress(addr, level); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = &levelIdentity{Identity: id, addrs: levelAddrs}
+	}
+	return out, nil
+}
+
 // arrayRegistry is a Registry that uses a fixed size array as backend
 type arrayRegistry struct {
 	ids []Identity
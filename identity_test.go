@@ -54,3 +54,46 @@ func testRegistryTests(t *testing.T, tests []registryTest) {
 		}
 	}
 }
+
+func TestLevelAddress(t *testing.T) {
+	var tests = []struct {
+		addr  string
+		level int
+		exp   string
+		err   bool
+	}{
+		{"127.0.0.1:3000", 0, "127.0.0.1:3000", false},
+		{"127.0.0.1:3000", 3, "127.0.0.1:3003", false},
+		{"[::1]:3000", 1, "[::1]:3001", false},
+		{"127.0.0.1", 1, "", true},
+		{"127.0.0.1:http", 1, "", true},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		addr, err := LevelAddress(test.addr, test.level)
+		if test.err {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, test.exp, addr)
+	}
+}
+
+func TestMultiAddressIdentity(t *testing.T) {
+	single := NewStaticIdentity(1, "127.0.0.1:3000", nil)
+	require.Equal(t, []string{"127.0.0.1:3000"}, Addresses(single))
+
+	addrs := []string{"54.1.2.3:3000", "10.0.0.2:3000"}
+	multi := NewStaticIdentityAddresses(2, addrs, nil)
+	require.Equal(t, "54.1.2.3:3000", multi.Address())
+	require.Equal(t, addrs, Addresses(multi))
+	require.Panics(t, func() { NewStaticIdentityAddresses(3, nil, nil) })
+
+	lvls, err := levelIdentities([]Identity{single, multi}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1:3002"}, Addresses(lvls[0]))
+	require.Equal(t, "54.1.2.3:3002", lvls[1].Address())
+	require.Equal(t, []string{"54.1.2.3:3002", "10.0.0.2:3002"}, Addresses(lvls[1]))
+	require.Equal(t, int32(2), lvls[1].ID())
+}
@@ -0,0 +1,60 @@
+package handel
+
+import "fmt"
+
+// strictInvariants turns Config.StrictInvariants on for every Handel, the
+// tests of the package set it
+var strictInvariants = false
+
+// fullGuard checks the cardinality of the full signature of the store never
+// decreases during an aggregation: the store only replaces a signature by a
+// better one. Each Handel runs a single aggregation, so a new aggregation
+// starts with a new guard.
+type fullGuard struct {
+	// cardinality and bits of the last full signature observed
+	card int
+	bits string
+	// number of decreases observed
+	regressions int
+}
+
+// checkFullSignature checks the given full signature of the store against the
+// last one observed. A decrease is logged as an error, and panics with a dump
+// of the store under Config.StrictInvariants.
+func (h *Handel) checkFullSignature(full *MultiSignature) {
+	g := &h.guard
+	card := full.Cardinality()
+	if card == g.card && g.bits != "" {
+		return
+	}
+	bits := full.BitSet.String()
+	if card < g.card {
+		g.regressions++
+		h.log.Error("invariant", "full_signature_regression",
+			"before", fmt.Sprintf("%d/%d", g.card, full.BitLength()),
+			"after", fmt.Sprintf("%d/%d", card, full.BitLength()),
+			"before_bits", g.bits, "after_bits", bits)
+		if h.c.StrictInvariants || strictInvariants {
+			panic(fmt.Sprintf("handel %d: full signature regressed from %d to %d contributions\nbefore: %s\nafter:  %s\n%s",
+				h.id.ID(), g.card, card, g.bits, bits, storeDump(h.store)))
+		}
+	}
+	g.card, g.bits = card, bits
+}
+
+// storeDump returns the debug output of the store
+func storeDump(s SignatureStore) string {
+	if r, ok := s.(*ReportStore); ok {
+		s = r.SignatureStore
+	}
+	return fmt.Sprint(s)
+}
+
+// invariantValues returns the number of invariant violations observed
+func (h *Handel) invariantValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	return map[string]float64{
+		"full_regressions": float64(h.guard.regressions),
+	}
+}
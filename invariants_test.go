@@ -0,0 +1,82 @@
+package handel
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// the whole suite runs with the invariants checked
+	strictInvariants = true
+}
+
+// regressiveStore returns a full signature losing a contribution once
+// regress is set
+type regressiveStore struct {
+	SignatureStore
+	regress bool
+}
+
+func (r *regressiveStore) FullSignature() *MultiSignature {
+	ms := r.SignatureStore.FullSignature()
+	if !r.regress {
+		return ms
+	}
+	bs := ms.BitSet.Clone()
+	i, _ := bs.NextSet(0)
+	bs.Set(i, false)
+	return &MultiSignature{BitSet: bs, Signature: ms.Signature}
+}
+
+func TestInvariantsFullRegression(t *testing.T) {
+	n := 8
+	for i, strict := range []bool{false, true} {
+		t.Logf(" -- test %d -- ", i)
+		_, handels := FakeSetup(n)
+		h := handels[0]
+		store := &regressiveStore{SignatureStore: h.store}
+		h.store = store
+		h.log = new(warnLogger)
+		h.c.StrictInvariants = strict
+		strictInvariants = false
+
+		h.store.Store(fullIncomingSig(1))
+		h.store.Store(fullIncomingSig(2))
+		h.checkFinalSignature(nil)
+		require.Equal(t, 0, h.guard.regressions)
+		// the same cardinality is no regression
+		h.checkFinalSignature(nil)
+		require.Equal(t, 0, h.guard.regressions)
+
+		store.regress = true
+		if strict {
+			require.Panics(t, func() { h.checkFinalSignature(nil) })
+		} else {
+			h.checkFinalSignature(nil)
+			require.Equal(t, 1, h.guard.regressions)
+			require.Equal(t, float64(1), h.invariantValues()["full_regressions"])
+			// the guard follows the regressed signature
+			h.checkFinalSignature(nil)
+			require.Equal(t, 1, h.guard.regressions)
+		}
+		strictInvariants = true
+		CloseHandels(handels)
+	}
+}
+
+// TestInvariantsNewAggregation checks a new aggregation of a session, which
+// starts again from its own contribution, is not a regression
+func TestInvariantsNewAggregation(t *testing.T) {
+	conf := &Config{Contributions: 8, Logger: new(warnLogger)}
+	sessions := fakeSessions(8, conf)
+	defer func() {
+		for _, s := range sessions {
+			s.Close()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		aggregate(t, sessions, []byte(fmt.Sprintf("message %d", i)), conf)
+	}
+}
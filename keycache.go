@@ -0,0 +1,138 @@
+package handel
+
+// SubPublicKey is an optional capability of a PublicKey: a public key
+// implementing it can remove a key from an aggregate. It lets the verification
+// path update an aggregate key incrementally instead of recomputing it from
+// scratch. Constructors whose keys don't implement it always recompute the
+// full aggregate.
+type SubPublicKey interface {
+	PublicKey
+	// Sub returns the aggregate public key minus the given public key, i.e.
+	// the opposite of Combine.
+	Sub(PublicKey) PublicKey
+}
+
+// cachedKey is the last aggregate key computed at a level with its bitset.
+type cachedKey struct {
+	bs  BitSet
+	key PublicKey
+}
+
+// keyCache caches the last aggregate public key computed at each level. A new
+// aggregate key is derived from the cached one by combining the keys of the
+// newly set bits and subtracting the keys of the removed bits, whenever that
+// needs fewer operations than aggregating all the keys of the bitset. It is
+// not thread safe: it is only used by the processing routine.
+type keyCache struct {
+	cons   Constructor
+	levels map[byte]*cachedKey
+
+	// number of aggregate keys derived from the cached one
+	hits int
+	// number of aggregate keys computed from scratch
+	misses int
+	// number of key additions / subtractions avoided thanks to the cache
+	saved int
+	// number of key additions / subtractions performed
+	operations int
+	// number of individual signatures verified against the key of their
+	// signer, without aggregation
+	individuals int
+
+	// previous keys of the identities, see Config.KeyRotationGrace, and the
+	// number of them used
+	rotation     *keyRotation
+	previousKeys int
+}
+
+func newKeyCache(cons Constructor) *keyCache {
+	return &keyCache{
+		cons:   cons,
+		levels: make(map[byte]*cachedKey),
+	}
+}
+
+// aggregate returns the aggregate public key of the identities whose index is
+// set in the bitset, ids being the identities of the given level.
+func (k *keyCache) aggregate(level byte, bs BitSet, ids []Identity) PublicKey {
+	var key PublicKey
+	full := bs.Cardinality()
+	cached, exists := k.levels[level]
+	if exists && cached.bs.BitLength() == bs.BitLength() {
+		diff := cached.bs.Xor(bs)
+		_, canSub := cached.key.(SubPublicKey)
+		removed := diff.IntersectionCardinality(cached.bs)
+		if diff.Cardinality() < full && (removed == 0 || canSub) {
+			key = cached.key
+			for i, ok := diff.NextSet(0); ok; i, ok = diff.NextSet(i + 1) {
+				if bs.Get(i) {
+					key = key.Combine(ids[i].PublicKey())
+				} else {
+					key = key.(SubPublicKey).Sub(ids[i].PublicKey())
+				}
+			}
+			k.hits++
+			k.saved += full - diff.Cardinality()
+			k.operations += diff.Cardinality()
+		}
+	}
+	if key == nil {
+		key = aggregatePublicKey(k.cons, bs, ids)
+		k.misses++
+		k.operations += full
+	}
+	k.levels[level] = &cachedKey{bs: bs.Clone(), key: key}
+	return key
+}
+
+// keyOf returns the key of the identity to verify a signature of the given
+// hint with, see keyRotation.previousKey
+func (k *keyCache) keyOf(id Identity, hint BitSet) PublicKey {
+	if prev := k.rotation.previousKey(id, hint); prev != nil {
+		k.previousKeys++
+		return prev
+	}
+	return id.PublicKey()
+}
+
+// aggregateRotated computes from scratch the aggregate public key of the
+// identities set in the bitset, with the previous key of the ones the hint
+// gives, or returns nil if none of them uses its previous key: the cache only
+// holds aggregates of current keys.
+func (k *keyCache) aggregateRotated(bs BitSet, ids []Identity, hint BitSet) PublicKey {
+	rotated := false
+	for i, ok := bs.NextSet(0); ok && !rotated; i, ok = bs.NextSet(i + 1) {
+		rotated = k.rotation.previousKey(ids[i], hint) != nil
+	}
+	if !rotated {
+		return nil
+	}
+	key := k.cons.PublicKey()
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		key = key.Combine(k.keyOf(ids[i], hint))
+	}
+	k.misses++
+	k.operations += bs.Cardinality()
+	return key
+}
+
+// Values implements the Reporter interface
+func (k *keyCache) Values() map[string]float64 {
+	return map[string]float64{
+		"keyCacheHits":   float64(k.hits),
+		"keyCacheMisses": float64(k.misses),
+		"keyCacheSaved":  float64(k.saved),
+		"individualKeys": float64(k.individuals),
+		"previousKeys":   float64(k.previousKeys),
+	}
+}
+
+// aggregatePublicKey computes from scratch the aggregate public key of the
+// identities whose index is set in the bitset.
+func aggregatePublicKey(cons Constructor, bs BitSet, ids []Identity) PublicKey {
+	key := cons.PublicKey()
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		key = key.Combine(ids[i].PublicKey())
+	}
+	return key
+}
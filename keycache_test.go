@@ -0,0 +1,183 @@
+package handel_test
+
+import (
+	"crypto/rand"
+	"io"
+	mathRand "math/rand"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	cf "github.com/ConsenSys/handel/bn256/cf"
+	golang "github.com/ConsenSys/handel/bn256/go"
+	"github.com/stretchr/testify/require"
+)
+
+type keyPairer interface {
+	handel.Constructor
+	KeyPair(r io.Reader) (handel.SecretKey, handel.PublicKey)
+}
+
+func keyIdentities(cons keyPairer, n int) []handel.Identity {
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		_, pub := cons.KeyPair(rand.Reader)
+		ids[i] = handel.NewStaticIdentity(int32(i), "", pub)
+	}
+	return ids
+}
+
+// signatureStream returns a sequence of bitsets as seen by the verification
+// path at a level: aggregates mostly grow, but some peers send aggregates
+// missing a few contributions of the previous ones.
+func signatureStream(r *mathRand.Rand, size, length int) []handel.BitSet {
+	stream := make([]handel.BitSet, length)
+	bs := handel.NewWilffBitset(size)
+	for i := range stream {
+		for j := r.Intn(4) + 1; j > 0; j-- {
+			bs.Set(r.Intn(size), true)
+		}
+		if r.Intn(5) == 0 {
+			for j := r.Intn(2) + 1; j > 0; j-- {
+				bs.Set(r.Intn(size), false)
+			}
+		}
+		stream[i] = bs.Clone()
+	}
+	return stream
+}
+
+func marshal(t *testing.T, p handel.PublicKey) []byte {
+	buff, err := p.(interface{ MarshalBinary() ([]byte, error) }).MarshalBinary()
+	require.NoError(t, err)
+	return buff
+}
+
+func TestKeyCacheIncremental(t *testing.T) {
+	var tests = []struct {
+		name string
+		cons keyPairer
+		// whether removals can be done incrementally
+		sub bool
+	}{
+		{"bn256/cf", cf.NewConstructor(), true},
+		{"bn256/go", golang.NewConstructor(), false},
+	}
+	for _, test := range tests {
+		t.Logf(" -- test %s -- ", test.name)
+		size := 32
+		ids := keyIdentities(test.cons, size)
+		r := mathRand.New(mathRand.NewSource(42))
+		// random jumps in addition to the realistic stream
+		stream := signatureStream(r, size, 50)
+		for i := 0; i < 10; i++ {
+			bs := handel.NewWilffBitset(size)
+			for j := 0; j < size; j++ {
+				bs.Set(j, r.Intn(2) == 0)
+			}
+			stream = append(stream, bs)
+		}
+
+		cache := handel.NewKeyCache(test.cons)
+		removals := 0
+		var previous handel.BitSet
+		for i, bs := range stream {
+			incremental := cache.Aggregate(1, bs, ids)
+			full := handel.AggregatePublicKey(test.cons, bs, ids)
+			if bs.None() {
+				continue
+			}
+			require.Equal(t, marshal(t, full), marshal(t, incremental), "bitset %d: %s", i, bs)
+			if previous != nil && !bs.IsSuperSet(previous) {
+				removals++
+			}
+			previous = bs
+		}
+		values := cache.Values()
+		require.Equal(t, float64(len(stream)), values["keyCacheHits"]+values["keyCacheMisses"])
+		require.True(t, values["keyCacheHits"] > 0)
+		require.True(t, values["keyCacheSaved"] > 0)
+		if !test.sub {
+			// every removal forces a full recomputation
+			require.True(t, values["keyCacheMisses"] >= float64(removals))
+		}
+	}
+}
+
+func BenchmarkKeyCacheStream(b *testing.B) {
+	cons := cf.NewConstructor()
+	size := 128
+	ids := keyIdentities(cons, size)
+	stream := signatureStream(mathRand.New(mathRand.NewSource(42)), size, 200)
+	var additions int
+	for _, bs := range stream {
+		additions += bs.Cardinality()
+	}
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, bs := range stream {
+				handel.AggregatePublicKey(cons, bs, ids)
+			}
+		}
+		b.ReportMetric(float64(additions), "additions/op")
+	})
+	b.Run("cached", func(b *testing.B) {
+		var saved float64
+		for i := 0; i < b.N; i++ {
+			cache := handel.NewKeyCache(cons)
+			for _, bs := range stream {
+				cache.Aggregate(1, bs, ids)
+			}
+			saved = cache.Values()["keyCacheSaved"]
+		}
+		b.ReportMetric(float64(additions)-saved, "additions/op")
+	})
+}
+
+func BenchmarkVerifyIndividual(b *testing.B) {
+	cons := cf.NewConstructor()
+	msg := []byte("Get Funky Tonight")
+	size := 16
+	ids := make([]handel.Identity, size)
+	var sig handel.Signature
+	for i := range ids {
+		sec, pub := cons.KeyPair(rand.Reader)
+		ids[i] = handel.NewStaticIdentity(int32(i), "", pub)
+		if i == size-1 {
+			s, err := sec.Sign(msg, nil)
+			require.NoError(b, err)
+			sig = s
+		}
+	}
+	part := handel.NewBinPartitioner(0, handel.NewArrayRegistry(ids), handel.DefaultLogger)
+	// the last node is the last one of the last level of node 0
+	level := 4
+	levelIds, err := part.IdentitiesAt(level)
+	require.NoError(b, err)
+	bs := handel.NewWilffBitset(len(levelIds))
+	bs.Set(len(levelIds)-1, true)
+	ms := &handel.MultiSignature{BitSet: bs, Signature: sig}
+	incoming := handel.NewIncomingSig(int32(size-1), byte(level), ms, true)
+
+	// the individual signatures used to take the aggregate path, combining
+	// the key of the signer with the empty key
+	b.Run("aggregate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			key := handel.AggregatePublicKey(cons, bs, levelIds)
+			if err := key.VerifySignature(msg, sig); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("direct", func(b *testing.B) {
+		b.ReportAllocs()
+		keys := handel.NewKeyCache(cons)
+		for i := 0; i < b.N; i++ {
+			if err := handel.VerifySignature(incoming, msg, part, keys, true); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(keys.Values()["individualKeys"]/float64(b.N), "direct/op")
+	})
+}
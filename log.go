@@ -2,6 +2,7 @@ package handel
 
 import (
 	"os"
+	"sync/atomic"
 
 	"github.com/go-kit/kit/log"
 	// conflicts with handel.level type
@@ -76,3 +77,41 @@ func (k *kitLogger) With(kv ...interface{}) Logger {
 	newLogger := log.With(k.Logger, kv...)
 	return NewKitLoggerFrom(newLogger)
 }
+
+// nopLogger discards all the statements. It is the logger of the components
+// given none.
+type nopLogger struct{}
+
+func (nopLogger) Info(kv ...interface{})  {}
+func (nopLogger) Debug(kv ...interface{}) {}
+func (nopLogger) Warn(kv ...interface{})  {}
+func (nopLogger) Error(kv ...interface{}) {}
+
+func (n nopLogger) With(kv ...interface{}) Logger {
+	return n
+}
+
+// componentLogger returns the logger of a component, whose statements hold
+// the name of the component. A nil logger discards them.
+func componentLogger(logger Logger, component string) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger.With("component", component)
+}
+
+// errorCounter counts the errors of a component which are only logged, so
+// they are visible in its Values even when the logs are discarded. It is safe
+// for concurrent use.
+type errorCounter struct {
+	errors int64
+}
+
+func (e *errorCounter) inc() {
+	atomic.AddInt64(&e.errors, 1)
+}
+
+// Values implements the Reporter interface
+func (e *errorCounter) Values() map[string]float64 {
+	return map[string]float64{"errors": float64(atomic.LoadInt64(&e.errors))}
+}
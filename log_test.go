@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"sync"
 	"testing"
 
 	"github.com/go-kit/kit/log"
@@ -86,3 +87,38 @@ func requireContains(t *testing.T, r io.Reader, outs []string, present bool) {
 		require.Contains(t, string(out), o)
 	}
 }
+
+// captureLogger records the warnings with the fields of the logger
+type captureLogger struct {
+	*captured
+	fields []interface{}
+}
+
+type captured struct {
+	sync.Mutex
+	warns [][]interface{}
+}
+
+func newCaptureLogger() *captureLogger {
+	return &captureLogger{captured: new(captured)}
+}
+
+func (c *captureLogger) Info(kv ...interface{})  {}
+func (c *captureLogger) Debug(kv ...interface{}) {}
+func (c *captureLogger) Error(kv ...interface{}) {}
+
+func (c *captureLogger) Warn(kv ...interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	c.warns = append(c.warns, append(append([]interface{}{}, c.fields...), kv...))
+}
+
+func (c *captureLogger) With(kv ...interface{}) Logger {
+	return &captureLogger{captured: c.captured, fields: append(append([]interface{}{}, c.fields...), kv...)}
+}
+
+func (c *captureLogger) warnings() [][]interface{} {
+	c.Lock()
+	defer c.Unlock()
+	return append([][]interface{}{}, c.warns...)
+}
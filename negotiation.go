@@ -0,0 +1,334 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// digestVersion is the first byte of a marshalled BitSetDigest
+const digestVersion byte = 1
+
+// digestRawMax is the largest bit length of a bitset carried raw by a digest
+const digestRawMax = 512
+
+// digestHeaderSize is the size of a marshalled digest without its raw bitset
+const digestHeaderSize = 1 + 1 + 4*4
+
+// BitSetDigest summarizes the best signature of a node for a level, so a peer
+// can tell if it holds contributions the node misses without sending its own
+// signature blindly. The bitset itself is only carried when small, see
+// digestRawMax: otherwise its cardinality and hash tell some of the cases.
+type BitSetDigest struct {
+	// Level of the signature
+	Level byte
+	// BitLength of the bitset
+	BitLength int
+	// Cardinality of the bitset
+	Cardinality int
+	// Hash of the marshalled bitset
+	Hash uint32
+	// Raw is the bitset, nil if larger than digestRawMax
+	Raw BitSet
+}
+
+// NewBitSetDigest returns the digest of the bitset of a signature of the
+// level
+func NewBitSetDigest(level byte, bs BitSet) (*BitSetDigest, error) {
+	buff, err := bs.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	d := &BitSetDigest{
+		Level:       level,
+		BitLength:   bs.BitLength(),
+		Cardinality: bs.Cardinality(),
+		Hash:        hashBytes(buff),
+	}
+	if bs.BitLength() <= digestRawMax {
+		d.Raw = bs
+	}
+	return d, nil
+}
+
+// MarshalBinary returns the version, level, bit length, cardinality and hash
+// of the digest followed by the length of the raw bitset, zero if there is
+// none, and the raw bitset.
+func (d *BitSetDigest) MarshalBinary() ([]byte, error) {
+	var raw []byte
+	if d.Raw != nil {
+		var err error
+		if raw, err = d.Raw.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	var b bytes.Buffer
+	b.WriteByte(digestVersion)
+	b.WriteByte(d.Level)
+	for _, v := range []uint32{uint32(d.BitLength), uint32(d.Cardinality), d.Hash, uint32(len(raw))} {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	b.Write(raw)
+	return b.Bytes(), nil
+}
+
+// unmarshalDigest decodes a digest marshalled by MarshalBinary, checking its
+// raw bitset against the other fields
+func unmarshalDigest(buff []byte, nbs func(int) BitSet) (*BitSetDigest, error) {
+	if len(buff) < digestHeaderSize {
+		return nil, errors.New("digest too short")
+	}
+	if buff[0] != digestVersion {
+		return nil, fmt.Errorf("unknown digest version %d", buff[0])
+	}
+	values := make([]uint32, 4)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint32(buff[2+4*i:])
+	}
+	d := &BitSetDigest{
+		Level:       buff[1],
+		BitLength:   int(values[0]),
+		Cardinality: int(values[1]),
+		Hash:        values[2],
+	}
+	raw := buff[digestHeaderSize:]
+	if int(values[3]) != len(raw) {
+		return nil, errors.New("invalid raw bitset length")
+	}
+	if d.Cardinality > d.BitLength {
+		return nil, errors.New("cardinality above the bit length")
+	}
+	if len(raw) == 0 {
+		return d, nil
+	}
+	if d.BitLength > digestRawMax {
+		return nil, errors.New("raw bitset too large")
+	}
+	if hashBytes(raw) != d.Hash {
+		return nil, errors.New("raw bitset not matching the hash")
+	}
+	bs := nbs(d.BitLength)
+	if err := bs.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	if bs.BitLength() != d.BitLength || bs.Cardinality() != d.Cardinality {
+		return nil, errors.New("raw bitset not matching the digest")
+	}
+	d.Raw = bs
+	return d, nil
+}
+
+// Missing returns a lower bound of the number of contributions of the bitset
+// missing from the digest. Without the raw bitset, a larger bitset or a
+// different one of the same cardinality misses at least the difference of
+// cardinality, or one contribution.
+func (d *BitSetDigest) Missing(bs BitSet) int {
+	if bs.BitLength() != d.BitLength {
+		return 0
+	}
+	if d.Raw != nil {
+		return bs.DifferenceCardinality(d.Raw)
+	}
+	card := bs.Cardinality()
+	if card > d.Cardinality {
+		return card - d.Cardinality
+	}
+	if card < d.Cardinality {
+		return 0
+	}
+	buff, err := bs.MarshalBinary()
+	if err != nil || hashBytes(buff) == d.Hash {
+		return 0
+	}
+	return 1
+}
+
+func hashBytes(buff []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(buff)
+	return h.Sum32()
+}
+
+// negotiation is the state of the digests exchanged with the peers, see
+// Config.EnableNegotiation. It is guarded by the lock of Handel.
+type negotiation struct {
+	// time of the last digests sent
+	last time.Time
+	// cardinality of our best signature of each level at the last digests
+	progress map[int]int
+	// position of the next peer to send a digest to, per level
+	next map[int]int
+	// time of the last answer to each peer
+	answered map[int32]time.Time
+
+	sent     int
+	received int
+	answers  int
+	rejected int
+	limited  int
+}
+
+func newNegotiation() *negotiation {
+	return &negotiation{
+		progress: make(map[int]int),
+		next:     make(map[int]int),
+		answered: make(map[int32]time.Time),
+	}
+}
+
+// negotiating returns true if the digests are enabled for the payload
+func (h *Handel) negotiating() bool {
+	return h.c.EnableNegotiation && h.c.UpdatePayload != UpdateFull
+}
+
+// negotiate sends, once per NegotiationPeriod, the digests of the most
+// incomplete started levels which did not progress since the previous
+// digests to their next peer. The lock must be held.
+func (h *Handel) negotiate(now time.Time) {
+	n := h.negotiation
+	if now.Sub(n.last) < h.c.NegotiationPeriod {
+		return
+	}
+	n.last = now
+	type stalled struct {
+		lvl   *level
+		ratio float64
+		bs    BitSet
+	}
+	var levels []stalled
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		if !lvl.started() || lvl.rcvCompleted {
+			continue
+		}
+		bs := h.c.NewBitSet(len(lvl.nodes))
+		if best, ok := h.store.Best(byte(id)); ok {
+			bs = best.BitSet
+		}
+		previous, seen := n.progress[id]
+		n.progress[id] = bs.Cardinality()
+		if !seen || bs.Cardinality() > previous {
+			continue
+		}
+		levels = append(levels, stalled{lvl, float64(bs.Cardinality()) / float64(len(lvl.nodes)), bs})
+	}
+	sort.SliceStable(levels, func(i, j int) bool { return levels[i].ratio < levels[j].ratio })
+	if len(levels) > h.c.NegotiationLevels {
+		levels = levels[:h.c.NegotiationLevels]
+	}
+	for _, s := range levels {
+		peer := s.lvl.nodes[n.next[s.lvl.id]%len(s.lvl.nodes)]
+		n.next[s.lvl.id]++
+		h.sendDigest(s.lvl.id, peer, s.bs)
+	}
+}
+
+// sendDigest sends the digest of the bitset of our best signature of the
+// level to the peer
+func (h *Handel) sendDigest(lvl int, peer Identity, bs BitSet) {
+	d, err := NewBitSetDigest(byte(lvl), bs)
+	if err != nil {
+		h.log.Error("digest", err)
+		return
+	}
+	buff, err := d.MarshalBinary()
+	if err != nil {
+		h.log.Error("digest", err)
+		return
+	}
+	ids := []Identity{peer}
+	if h.c.PortPerLevel {
+		if ids, err = levelIdentities(ids, lvl); err != nil {
+			h.log.Error("level_address", err)
+			return
+		}
+	}
+	p := &Packet{Origin: h.id.ID(), Level: byte(lvl), MultiSig: buff, Flags: FlagDigest, Aggregation: h.aggregation}
+	if err := h.signPacket(p); err != nil {
+		h.log.Error("packet_signature", err)
+		return
+	}
+	h.negotiation.sent++
+	h.stats.msgSentCt++
+	h.stats.bytesSent += len(buff)
+	h.log.Debug("sent_digest", lvl, "to", peer.ID(), "card", d.Cardinality)
+	h.net.Send(ids, p)
+}
+
+// answerDigest sends our signature for the level of the digest to its sender
+// if it holds contributions missing from the digest, at most once per
+// NegotiationPeriod per peer. The lock must be held.
+func (h *Handel) answerDigest(p *Packet) {
+	if !h.negotiating() {
+		return
+	}
+	n := h.negotiation
+	n.received++
+	d, err := unmarshalDigest(p.MultiSig, h.c.NewBitSet)
+	if err == nil && d.Level != p.Level {
+		err = errors.New("digest of another level")
+	}
+	lvl := h.getLevel(p.Level)
+	var peer Identity
+	for _, id := range lvl.nodes {
+		if id.ID() == p.Origin {
+			peer = id
+		}
+	}
+	if err == nil && peer == nil {
+		err = errors.New("digest from outside the level")
+	}
+	if err != nil {
+		n.rejected++
+		h.log.Warn("invalid_digest", err, "from", p.Origin)
+		return
+	}
+	now := h.now()
+	if last, ok := n.answered[p.Origin]; ok && now.Sub(last) < h.c.NegotiationPeriod {
+		n.limited++
+		return
+	}
+	ms := h.digestReply(lvl, d)
+	if ms == nil {
+		return
+	}
+	n.answered[p.Origin] = now
+	n.answers++
+	var sig Signature
+	if !lvl.rcvCompleted {
+		sig = h.sig
+	}
+	h.log.Debug("digest_answer", lvl.id, "to", p.Origin, "missing", d.Missing(ms.BitSet))
+	h.sendTo(lvl.id, []Identity{peer}, ms, sig)
+	h.bitsets.Put(ms.BitSet)
+}
+
+// digestReply returns the signature we send for the level if it holds
+// contributions missing from the digest, nil otherwise
+func (h *Handel) digestReply(lvl *level, d *BitSetDigest) *MultiSignature {
+	ms := h.updateSig(lvl.id)
+	if d.Missing(ms.BitSet) == 0 {
+		h.bitsets.Put(ms.BitSet)
+		return nil
+	}
+	return ms
+}
+
+// negotiationValues returns the counts of the digests sent, received,
+// answered, rejected as invalid and not answered because of the rate limit
+func (h *Handel) negotiationValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	n := h.negotiation
+	return map[string]float64{
+		"sent":     float64(n.sent),
+		"received": float64(n.received),
+		"answers":  float64(n.answers),
+		"rejected": float64(n.rejected),
+		"limited":  float64(n.limited),
+	}
+}
@@ -0,0 +1,226 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitSetDigest(t *testing.T) {
+	small := bitsOf(8, 1, 2)
+	large := bitsOf(1024, 1, 2)
+	for i, bs := range []BitSet{small, large} {
+		t.Logf(" -- test %d -- ", i)
+		d, err := NewBitSetDigest(3, bs)
+		require.NoError(t, err)
+		buff, err := d.MarshalBinary()
+		require.NoError(t, err)
+		read, err := unmarshalDigest(buff, NewWilffBitset)
+		require.NoError(t, err)
+		require.Equal(t, byte(3), read.Level)
+		require.Equal(t, bs.BitLength(), read.BitLength)
+		require.Equal(t, 2, read.Cardinality)
+		require.Equal(t, d.Hash, read.Hash)
+		require.Equal(t, bs.BitLength() <= digestRawMax, read.Raw != nil)
+	}
+
+	// the raw bitset gives the exact difference
+	d, _ := NewBitSetDigest(3, small)
+	require.Equal(t, 2, d.Missing(bitsOf(8, 0, 1, 2, 3)))
+	require.Equal(t, 1, d.Missing(bitsOf(8, 5)))
+	require.Equal(t, 0, d.Missing(bitsOf(8, 1)))
+	require.Equal(t, 0, d.Missing(bitsOf(16, 0, 1, 2, 3)))
+
+	// otherwise a lower bound
+	d, _ = NewBitSetDigest(3, large)
+	for i, c := range []struct {
+		bs  BitSet
+		exp int
+	}{
+		{bitsOf(1024, 0, 1, 2), 1},
+		{bitsOf(1024, 4, 5, 6, 7), 2},
+		{bitsOf(1024, 1, 2), 0},
+		{bitsOf(1024, 1, 3), 1},
+		{bitsOf(1024, 5), 0},
+	} {
+		t.Logf(" -- test %d -- ", i)
+		require.Equal(t, c.exp, d.Missing(c.bs))
+	}
+}
+
+func TestDigestMalformed(t *testing.T) {
+	valid, err := NewBitSetDigest(2, bitsOf(2, 1))
+	require.NoError(t, err)
+	buff, err := valid.MarshalBinary()
+	require.NoError(t, err)
+	raw := buff[digestHeaderSize:]
+	// header of a digest, with a raw bitset of the given length
+	header := func(version byte, bitLength, card int, hash uint32, rawLen int) []byte {
+		var b bytes.Buffer
+		b.Write([]byte{version, 2})
+		for _, v := range []uint32{uint32(bitLength), uint32(card), hash, uint32(rawLen)} {
+			binary.Write(&b, binary.BigEndian, v)
+		}
+		return b.Bytes()
+	}
+	otherRaw, _ := bitsOf(2, 0).MarshalBinary()
+	garbage := []byte{0xff, 0xff, 0x01}
+	largeRaw, _ := bitsOf(1024, 1).MarshalBinary()
+	malformed := [][]byte{
+		nil,
+		buff[:digestHeaderSize-1],
+		append(header(2, 2, 1, valid.Hash, len(raw)), raw...),
+		append(header(digestVersion, 2, 1, valid.Hash, len(raw)+1), raw...),
+		append(header(digestVersion, 2, 3, valid.Hash, len(raw)), raw...),
+		append(header(digestVersion, 2, 1, valid.Hash+1, len(raw)), raw...),
+		append(header(digestVersion, 2, 2, hashBytes(otherRaw), len(otherRaw)), otherRaw...),
+		append(header(digestVersion, 2, 1, hashBytes(garbage), len(garbage)), garbage...),
+		append(header(digestVersion, 1024, 1, hashBytes(largeRaw), len(largeRaw)), largeRaw...),
+		header(digestVersion, 2, 3, valid.Hash, 0),
+	}
+	for i, b := range malformed {
+		t.Logf(" -- test %d -- ", i)
+		_, err := unmarshalDigest(b, NewWilffBitset)
+		require.Error(t, err)
+	}
+
+	// rejected by Handel as well, as the digests of another level or from
+	// outside the level
+	_, handels := FakeSetupWith(4, func(c *Config) { c.EnableNegotiation = true })
+	defer CloseHandels(handels)
+	h := handels[0]
+	net := new(recordNetwork)
+	h.net = net
+	for _, b := range malformed {
+		h.NewPacket(&Packet{Origin: 1, Level: 1, MultiSig: b, Flags: FlagDigest})
+	}
+	h.NewPacket(&Packet{Origin: 1, Level: 1, MultiSig: buff, Flags: FlagDigest})
+	h.NewPacket(&Packet{Origin: 1, Level: 2, MultiSig: buff, Flags: FlagDigest})
+	// unknown flags
+	h.NewPacket(&Packet{Origin: 2, Level: 2, MultiSig: buff, Flags: 0x80})
+	values := h.negotiationValues()
+	require.Equal(t, float64(len(malformed)+2), values["rejected"])
+	require.Equal(t, float64(len(malformed)+2), values["received"])
+	require.Empty(t, net.packets())
+}
+
+func TestDigestReply(t *testing.T) {
+	// the nodes 4 to 7 are at the level 3 of the node 0, which sends them a
+	// bitset of 4 bits: itself, the node 1 and the nodes 2 and 3
+	var tests = []struct {
+		payload string
+		stored  []*IncomingSig
+		digest  BitSet
+		exp     BitSet
+	}{
+		// only our own signature
+		{UpdateCombinedPrefix, nil, bitsOf(4, 0), nil},
+		{UpdateCombinedPrefix, nil, bitsOf(4, 1, 2, 3), bitsOf(4, 0)},
+		// we miss what the requester lacks
+		{UpdateCombinedPrefix, []*IncomingSig{fullIncomingSig(1)}, bitsOf(4, 2, 3), bitsOf(4, 0, 1)},
+		{UpdateCombinedPrefix, []*IncomingSig{fullIncomingSig(1)}, bitsOf(4, 0, 1, 2), nil},
+		{UpdateCombinedPrefix, []*IncomingSig{fullIncomingSig(1), fullIncomingSig(2)}, bitsOf(4, 0, 1), bitsOf(4, 0, 1, 2, 3)},
+		// the best signature of the previous level only
+		{UpdateBestLevel, []*IncomingSig{fullIncomingSig(1), fullIncomingSig(2)}, bitsOf(4, 2, 3), nil},
+		{UpdateBestLevel, []*IncomingSig{fullIncomingSig(1), fullIncomingSig(2)}, bitsOf(4, 0, 1), bitsOf(4, 2, 3)},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		payload := test.payload
+		_, handels := FakeSetupWith(16, func(c *Config) {
+			c.EnableNegotiation = true
+			c.UpdatePayload = payload
+		})
+		h := handels[0]
+		net := new(recordNetwork)
+		h.net = net
+		for _, s := range test.stored {
+			h.store.Store(s)
+		}
+		d, err := NewBitSetDigest(3, test.digest)
+		require.NoError(t, err)
+		buff, err := d.MarshalBinary()
+		require.NoError(t, err)
+		h.NewPacket(&Packet{Origin: 5, Level: 3, MultiSig: buff, Flags: FlagDigest})
+		// answered once per period
+		h.NewPacket(&Packet{Origin: 5, Level: 3, MultiSig: buff, Flags: FlagDigest})
+
+		sent := net.packets()
+		values := h.negotiationValues()
+		if test.exp == nil {
+			require.Empty(t, sent)
+			require.Zero(t, values["answers"])
+			CloseHandels(handels)
+			continue
+		}
+		require.Len(t, sent, 1)
+		require.Equal(t, byte(0), sent[0].Flags)
+		require.Equal(t, byte(3), sent[0].Level)
+		ms := new(MultiSignature)
+		require.NoError(t, ms.Unmarshal(sent[0].MultiSig, new(fakeSig), NewWilffBitset))
+		require.Equal(t, test.exp.String(), ms.BitSet.String())
+		require.NotNil(t, sent[0].IndividualSig)
+		require.Equal(t, 1.0, values["answers"])
+		require.Equal(t, 1.0, values["limited"])
+		CloseHandels(handels)
+	}
+}
+
+// TestNegotiationGap runs an aggregation where a node never starts: it never
+// sends its signature and its peers only get it by negotiating.
+func TestNegotiationGap(t *testing.T) {
+	n := 8
+	silent := 1
+	for _, negotiate := range []bool{false, true} {
+		t.Logf(" -- negotiation %v -- ", negotiate)
+		_, handels := FakeSetupWith(n, func(c *Config) {
+			c.EnableNegotiation = negotiate
+			c.NegotiationPeriod = 20 * time.Millisecond
+		})
+		var running []*Handel
+		for i, h := range handels {
+			if i != silent {
+				running = append(running, h)
+			}
+		}
+		done := make(chan bool, len(running))
+		for _, h := range running {
+			go func(h *Handel) {
+				for ms := range h.FinalSignatures() {
+					if ms.BitSet.Cardinality() == n {
+						done <- true
+						return
+					}
+				}
+			}(h)
+			h.Start()
+		}
+		timeout := time.After(5 * time.Second)
+		if !negotiate {
+			timeout = time.After(500 * time.Millisecond)
+		}
+		completed := 0
+	wait:
+		for completed < len(running) {
+			select {
+			case <-done:
+				completed++
+			case <-timeout:
+				break wait
+			}
+		}
+		if negotiate {
+			require.Equal(t, len(running), completed)
+			values := handels[silent].negotiationValues()
+			require.True(t, values["answers"] > 0)
+			require.True(t, handels[0].negotiationValues()["sent"] > 0)
+		} else {
+			// the peers of the level 1 of the silent node never complete
+			require.True(t, completed < len(running), "%d completed", completed)
+		}
+		CloseHandels(handels)
+	}
+}
@@ -9,6 +9,11 @@ type Network interface {
 	RegisterListener(Listener)
 	// Send sends the given packet to the given Identity. There can be no
 	// guarantees about the reception of the packet provided by the Network.
+	// The caller gives up the packet: it must not modify it, nor the bytes
+	// it references, once Send is called. Handel builds a new packet for
+	// each call. The Network must not modify the packet either, but it may
+	// read it after Send returns, for example to encode it asynchronously
+	// for each destination.
 	Send([]Identity, *Packet)
 }
 
@@ -28,9 +33,9 @@ func (l ListenFunc) NewPacket(p *Packet) {
 }
 
 // Packet is the general packet that Handel sends out and expects to receive
-// from the Network. Handel do not provide any authentication nor
-// confidentiality on Packets, it is up to the application layer to add these
-// features if relevant.
+// from the Network. Handel do not provide any confidentiality on Packets, and
+// only authenticates them with Config.AuthenticatePackets: it is up to the
+// application layer to add these features if relevant.
 type Packet struct {
 	// Origin is the ID of the sender of this packet.
 	Origin int32
@@ -41,4 +46,26 @@ type Packet struct {
 	MultiSig []byte
 	// IndividualSig holds the individual signature of the Origin node
 	IndividualSig []byte
+	// Flags tells how to read the packet, see FlagDigest. Zero for the
+	// packets carrying signatures.
+	Flags byte
+	// Progress is the cardinality of the full signature of the Origin when
+	// sending, zero if not given, see Config.GossipProgress. It is only a
+	// hint: it is not part of any aggregated signature.
+	Progress uint16
+	// PreviousKeys is the bitset, by index in the registry, of the
+	// contributors of the signatures of the packet signing with their
+	// previous key, nil if none, see Config.KeyRotationGrace.
+	PreviousKeys []byte
+	// Aggregation identifies the aggregation the packet belongs to, see
+	// AggregationID.
+	Aggregation uint32
+	// Signature is the signature of the Origin over all the other fields of
+	// the packet, nil if not signed, see Config.AuthenticatePackets.
+	Signature []byte
 }
+
+// FlagDigest marks a packet whose MultiSig field holds a BitSetDigest of the
+// sender for the level instead of a multi-signature, see
+// Config.EnableNegotiation.
+const FlagDigest byte = 1
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// DefaultReprobePeriod is the default period after which an AddressSelector
+// tries again the preferred addresses of an identity
+const DefaultReprobePeriod = 30 * time.Second
+
+// AddressSelector picks the address to send to, for the identities reachable
+// on several addresses, see handel.MultiAddressIdentity. It tries their
+// addresses in order until a send succeeds, and sticks to the address which
+// worked for the next sends to the identity. Once the reprobe period elapsed,
+// it tries again the addresses in order, in case a preferred one works again.
+// The identities are told apart by their ID. It is safe for concurrent use.
+type AddressSelector struct {
+	sync.Mutex
+	reprobe time.Duration
+	now     func() time.Time
+	// the identities sending to another address than their primary one
+	sticky map[int32]stickyAddress
+	// sends to an address which failed
+	failed int
+}
+
+// stickyAddress is the address which worked for an identity
+type stickyAddress struct {
+	index int
+	since time.Time
+}
+
+// NewAddressSelector returns a selector trying again the preferred addresses
+// of an identity after the given period, DefaultReprobePeriod if zero.
+func NewAddressSelector(reprobe time.Duration) *AddressSelector {
+	if reprobe == 0 {
+		reprobe = DefaultReprobePeriod
+	}
+	return &AddressSelector{
+		reprobe: reprobe,
+		now:     time.Now,
+		sticky:  make(map[int32]stickyAddress),
+	}
+}
+
+// Send calls send with the addresses of the identity, starting with the one
+// which worked last, then in their order of preference, until it succeeds.
+// It returns the error of the last address tried if none did.
+func (s *AddressSelector) Send(id handel.Identity, send func(addr string) error) error {
+	addrs := handel.Addresses(id)
+	if len(addrs) == 1 {
+		return send(addrs[0])
+	}
+	first := s.first(id.ID(), len(addrs))
+	var err error
+	for i := range addrs {
+		// the sticky address first, then the others in order
+		index := i
+		if i == 0 {
+			index = first
+		} else if i <= first {
+			index = i - 1
+		}
+		if err = send(addrs[index]); err == nil {
+			s.worked(id.ID(), index)
+			return nil
+		}
+		s.Lock()
+		s.failed++
+		s.Unlock()
+	}
+	return err
+}
+
+// first returns the index of the address to try first for the identity
+func (s *AddressSelector) first(id int32, n int) int {
+	s.Lock()
+	defer s.Unlock()
+	sticky, ok := s.sticky[id]
+	if !ok || sticky.index >= n {
+		return 0
+	}
+	if s.now().Sub(sticky.since) >= s.reprobe {
+		// the preferred addresses again
+		delete(s.sticky, id)
+		return 0
+	}
+	return sticky.index
+}
+
+// worked records the address which worked for the identity
+func (s *AddressSelector) worked(id int32, index int) {
+	s.Lock()
+	defer s.Unlock()
+	if index == 0 {
+		delete(s.sticky, id)
+		return
+	}
+	if sticky, ok := s.sticky[id]; ok && sticky.index == index {
+		return
+	}
+	s.sticky[id] = stickyAddress{index: index, since: s.now()}
+}
+
+// Values returns the number of sends to an address which failed, and of
+// identities sending to another address than their primary one
+func (s *AddressSelector) Values() map[string]float64 {
+	s.Lock()
+	defer s.Unlock()
+	return map[string]float64{
+		"addrFailed":    float64(s.failed),
+		"addrSecondary": float64(len(s.sticky)),
+	}
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressSelector(t *testing.T) {
+	s := NewAddressSelector(time.Minute)
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	id := handel.NewStaticIdentityAddresses(1, []string{"a:1", "b:1", "c:1"}, nil)
+
+	// only c works
+	working := map[string]bool{"c:1": true}
+	var tried []string
+	send := func(addr string) error {
+		tried = append(tried, addr)
+		if !working[addr] {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"a:1", "b:1", "c:1"}, tried)
+	// it sticks to c
+	tried = nil
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"c:1"}, tried)
+	require.Equal(t, map[string]float64{"addrFailed": 2, "addrSecondary": 1}, s.Values())
+
+	// c fails now, b works: c first, then in order
+	working = map[string]bool{"b:1": true}
+	tried = nil
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"c:1", "a:1", "b:1"}, tried)
+	tried = nil
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"b:1"}, tried)
+
+	// the preferred address works again, which is noticed once the reprobe
+	// period elapsed
+	working["a:1"] = true
+	now = now.Add(30 * time.Second)
+	tried = nil
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"b:1"}, tried)
+	now = now.Add(31 * time.Second)
+	tried = nil
+	require.NoError(t, s.Send(id, send))
+	require.Equal(t, []string{"a:1"}, tried)
+	require.Equal(t, 0.0, s.Values()["addrSecondary"])
+
+	// nothing works
+	working = nil
+	require.Error(t, s.Send(id, send))
+
+	// a single address is just used
+	tried = nil
+	single := handel.NewStaticIdentity(2, "d:1", nil)
+	require.Error(t, s.Send(single, send))
+	require.Equal(t, []string{"d:1"}, tried)
+}
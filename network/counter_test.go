@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"encoding/gob"
 	"testing"
 
 	"github.com/ConsenSys/handel"
@@ -32,3 +33,34 @@ func TestCounterEncoding(t *testing.T) {
 	require.Equal(t, toSend, read)
 	require.True(t, counter.Values()["rcvdBytes"] > 0.0)
 }
+
+// oldPacket is a Packet without the progress hint
+type oldPacket struct {
+	Origin        int32
+	Level         byte
+	MultiSig      []byte
+	IndividualSig []byte
+	Flags         byte
+}
+
+func TestGOBEncodingProgress(t *testing.T) {
+	enc := NewGOBEncoding()
+	var medium bytes.Buffer
+	toSend := &handel.Packet{Origin: 3, Level: 2, MultiSig: []byte{0x01}, Progress: 300}
+	require.NoError(t, enc.Encode(toSend, &medium))
+	read, err := enc.Decode(&medium)
+	require.NoError(t, err)
+	require.Equal(t, toSend, read)
+
+	// the nodes without the hint ignore it
+	require.NoError(t, enc.Encode(toSend, &medium))
+	var old oldPacket
+	require.NoError(t, gob.NewDecoder(&medium).Decode(&old))
+	require.Equal(t, oldPacket{Origin: 3, Level: 2, MultiSig: []byte{0x01}}, old)
+
+	// and their packets have none
+	require.NoError(t, gob.NewEncoder(&medium).Encode(&oldPacket{Origin: 3, Level: 2, MultiSig: []byte{0x01}}))
+	read, err = enc.Decode(&medium)
+	require.NoError(t, err)
+	require.Equal(t, &handel.Packet{Origin: 3, Level: 2, MultiSig: []byte{0x01}}, read)
+}
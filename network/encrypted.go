@@ -0,0 +1,210 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ConsenSys/handel"
+)
+
+// encryptionDomain separates the keys of the encrypted network from any other
+// use of the shared secrets
+var encryptionDomain = []byte("handel-packet-encryption")
+
+// EncryptedNetwork is a handel.Network encrypting the packets sent to each
+// peer with a key shared with it, so the signatures and bitsets exchanged are
+// only readable by their destination. The key shared with a peer is derived
+// from the secret key of the node and the public key of the peer in the
+// registry, see handel.DHSecretKey, and cached.
+//
+// Each packet is encoded, and encrypted and authenticated with AES-GCM, with
+// the origin and the level as associated data. Only these stay in clear in the
+// packet sent over the wrapped network, so its level ports still apply. The
+// packets received that don't decrypt, i.e. forged, tampered or encrypted for
+// another node, are dropped and counted.
+type EncryptedNetwork struct {
+	handel.Network
+	id     int32
+	secret handel.DHSecretKey
+	reg    handel.Registry
+	enc    Encoding
+
+	sync.Mutex
+	keys      map[int32]cipher.AEAD
+	listeners []handel.Listener
+	// statistics
+	sent     int
+	rcvd     int
+	rejected int
+}
+
+// NewEncryptedNetwork returns the network inner encrypting the packets
+// exchanged between the node of the given ID and secret key and the other
+// nodes of the registry. The secret key must implement handel.DHSecretKey.
+func NewEncryptedNetwork(inner handel.Network, ownID int32, ownSecret handel.SecretKey, reg handel.Registry) (*EncryptedNetwork, error) {
+	secret, ok := ownSecret.(handel.DHSecretKey)
+	if !ok {
+		return nil, fmt.Errorf("network: secret key %T does not support key exchange", ownSecret)
+	}
+	e := &EncryptedNetwork{
+		Network: inner,
+		id:      ownID,
+		secret:  secret,
+		reg:     reg,
+		enc:     NewGOBEncoding(),
+		keys:    make(map[int32]cipher.AEAD),
+	}
+	inner.RegisterListener(handel.ListenFunc(e.receive))
+	return e, nil
+}
+
+// RegisterListener implements the handel.Network interface: the listeners
+// get the decrypted packets
+func (e *EncryptedNetwork) RegisterListener(l handel.Listener) {
+	e.Lock()
+	defer e.Unlock()
+	e.listeners = append(e.listeners, l)
+}
+
+// Send implements the handel.Network interface: the packet is encrypted for
+// each destination and sent to it alone.
+func (e *EncryptedNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	var b bytes.Buffer
+	if err := e.enc.Encode(p, &b); err != nil {
+		return
+	}
+	for _, id := range ids {
+		sealed, err := e.seal(id.ID(), p, b.Bytes())
+		if err != nil {
+			continue
+		}
+		e.Lock()
+		e.sent++
+		e.Unlock()
+		e.Network.Send([]handel.Identity{id}, sealed)
+	}
+}
+
+// seal returns the packet carrying the encoded packet encrypted for the peer
+func (e *EncryptedNetwork) seal(peer int32, p *handel.Packet, plain []byte) (*handel.Packet, error) {
+	aead, err := e.key(peer)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &handel.Packet{
+		Origin:   e.id,
+		Level:    p.Level,
+		MultiSig: aead.Seal(nonce, nonce, plain, associatedData(e.id, p.Level)),
+	}, nil
+}
+
+// receive decrypts the packet and dispatches it to the listeners
+func (e *EncryptedNetwork) receive(sealed *handel.Packet) {
+	p, err := e.open(sealed)
+	e.Lock()
+	if err != nil {
+		e.rejected++
+		e.Unlock()
+		return
+	}
+	e.rcvd++
+	listeners := e.listeners
+	e.Unlock()
+	for _, l := range listeners {
+		l.NewPacket(p)
+	}
+}
+
+// open returns the packet encrypted in the packet sent by its origin
+func (e *EncryptedNetwork) open(sealed *handel.Packet) (*handel.Packet, error) {
+	aead, err := e.key(sealed.Origin)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.MultiSig) < aead.NonceSize() {
+		return nil, errors.New("network: encrypted packet too short")
+	}
+	nonce, cipherText := sealed.MultiSig[:aead.NonceSize()], sealed.MultiSig[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, cipherText, associatedData(sealed.Origin, sealed.Level))
+	if err != nil {
+		return nil, err
+	}
+	p, err := e.enc.Decode(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	if p.Origin != sealed.Origin || p.Level != sealed.Level {
+		return nil, errors.New("network: encrypted packet from another origin or level")
+	}
+	return p, nil
+}
+
+// key returns the cipher of the key shared with the peer, derived at the
+// first use
+func (e *EncryptedNetwork) key(peer int32) (cipher.AEAD, error) {
+	e.Lock()
+	aead, ok := e.keys[peer]
+	e.Unlock()
+	if ok {
+		return aead, nil
+	}
+	id, ok := e.reg.Identity(int(peer))
+	if !ok {
+		return nil, fmt.Errorf("network: no identity %d in the registry", peer)
+	}
+	shared, err := e.secret.DiffieHellman(id.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(encryptionDomain)
+	h.Write(shared)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.Lock()
+	e.keys[peer] = aead
+	e.Unlock()
+	return aead, nil
+}
+
+// associatedData returns the data in clear authenticated with a packet
+func associatedData(origin int32, level byte) []byte {
+	ad := make([]byte, 5)
+	binary.BigEndian.PutUint32(ad, uint32(origin))
+	ad[4] = level
+	return ad
+}
+
+// Values implements the monitor.Counter interface. The values of the wrapped
+// network are included if it implements handel.Reporter.
+func (e *EncryptedNetwork) Values() map[string]float64 {
+	values := make(map[string]float64)
+	if r, ok := e.Network.(handel.Reporter); ok {
+		for k, v := range r.Values() {
+			values[k] = v
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	values["encrypted_sent"] = float64(e.sent)
+	values["encrypted_rcvd"] = float64(e.rcvd)
+	values["encrypted_rejected"] = float64(e.rejected)
+	return values
+}
@@ -0,0 +1,210 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/go"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeNetwork delivers the packets sent synchronously to the pipeNetworks of
+// their destinations, and keeps the last packet sent
+type pipeNetwork struct {
+	peers     map[int32]*pipeNetwork
+	listeners []handel.Listener
+	last      *handel.Packet
+}
+
+func newPipeNetworks(n int) []*pipeNetwork {
+	peers := make(map[int32]*pipeNetwork)
+	nets := make([]*pipeNetwork, n)
+	for i := range nets {
+		nets[i] = &pipeNetwork{peers: peers}
+		peers[int32(i)] = nets[i]
+	}
+	return nets
+}
+
+func (p *pipeNetwork) RegisterListener(l handel.Listener) {
+	p.listeners = append(p.listeners, l)
+}
+
+func (p *pipeNetwork) Send(ids []handel.Identity, packet *handel.Packet) {
+	p.last = packet
+	for _, id := range ids {
+		p.peers[id.ID()].deliver(packet)
+	}
+}
+
+func (p *pipeNetwork) deliver(packet *handel.Packet) {
+	for _, l := range p.listeners {
+		l.NewPacket(packet)
+	}
+}
+
+// encryptedNetworks returns the encrypted networks of n nodes over pipe
+// networks, and the registry of the nodes
+func encryptedNetworks(t testing.TB, n int) ([]*EncryptedNetwork, []*pipeNetwork, handel.Registry) {
+	secrets := make([]*bn256.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		sk, pk, err := bn256.NewKeyPair(nil)
+		require.NoError(t, err)
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), fmt.Sprintf("127.0.0.1:%d", 3000+i), pk)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	pipes := newPipeNetworks(n)
+	nets := make([]*EncryptedNetwork, n)
+	for i := range nets {
+		net, err := NewEncryptedNetwork(pipes[i], int32(i), secrets[i], reg)
+		require.NoError(t, err)
+		nets[i] = net
+	}
+	return nets, pipes, reg
+}
+
+// received returns the listener appending the packets it gets to the slice
+func received(packets *[]*handel.Packet) handel.Listener {
+	return handel.ListenFunc(func(p *handel.Packet) {
+		*packets = append(*packets, p)
+	})
+}
+
+func TestEncryptedNetwork(t *testing.T) {
+	nets, pipes, reg := encryptedNetworks(t, 3)
+	var rcvd1, rcvd2 []*handel.Packet
+	nets[1].RegisterListener(received(&rcvd1))
+	nets[2].RegisterListener(received(&rcvd2))
+	id1, _ := reg.Identity(1)
+	id2, _ := reg.Identity(2)
+
+	toSend := &handel.Packet{
+		Origin:        0,
+		Level:         3,
+		MultiSig:      []byte("the bitset of the online validators"),
+		IndividualSig: []byte{0x01, 0x02},
+		Progress:      12,
+	}
+	nets[0].Send([]handel.Identity{id1, id2}, toSend)
+	require.Equal(t, []*handel.Packet{toSend}, rcvd1)
+	require.Equal(t, []*handel.Packet{toSend}, rcvd2)
+	// only the origin and the level are in clear
+	sealed := pipes[0].last
+	require.Equal(t, int32(0), sealed.Origin)
+	require.Equal(t, byte(3), sealed.Level)
+	require.False(t, bytes.Contains(sealed.MultiSig, toSend.MultiSig))
+	require.Nil(t, sealed.IndividualSig)
+	require.Equal(t, uint16(0), sealed.Progress)
+	require.Len(t, nets[0].keys, 2)
+
+	// back to the sender
+	var rcvd0 []*handel.Packet
+	nets[0].RegisterListener(received(&rcvd0))
+	id0, _ := reg.Identity(0)
+	reply := &handel.Packet{Origin: 1, Level: 3, MultiSig: []byte{0x03}}
+	nets[1].Send([]handel.Identity{id0}, reply)
+	require.Equal(t, []*handel.Packet{reply}, rcvd0)
+
+	values := nets[0].Values()
+	require.Equal(t, 2.0, values["encrypted_sent"])
+	require.Equal(t, 1.0, values["encrypted_rcvd"])
+	require.Equal(t, 0.0, values["encrypted_rejected"])
+}
+
+func TestEncryptedNetworkRejection(t *testing.T) {
+	nets, pipes, reg := encryptedNetworks(t, 3)
+	var rcvd []*handel.Packet
+	nets[1].RegisterListener(received(&rcvd))
+	id1, _ := reg.Identity(1)
+	id2, _ := reg.Identity(2)
+	nets[0].Send([]handel.Identity{id1}, &handel.Packet{Origin: 0, Level: 2, MultiSig: []byte{0x01}})
+	require.Len(t, rcvd, 1)
+	sealed := *pipes[0].last
+
+	var tests = []func(p *handel.Packet){
+		// tampered cipher text
+		func(p *handel.Packet) {
+			p.MultiSig = append([]byte(nil), p.MultiSig...)
+			p.MultiSig[len(p.MultiSig)-1] ^= 0x01
+		},
+		// tampered associated data
+		func(p *handel.Packet) { p.Level = 3 },
+		func(p *handel.Packet) { p.Origin = 2 },
+		// truncated
+		func(p *handel.Packet) { p.MultiSig = p.MultiSig[:4] },
+		// unknown origin
+		func(p *handel.Packet) { p.Origin = 5 },
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		p := sealed
+		test(&p)
+		pipes[1].deliver(&p)
+		require.Len(t, rcvd, 1)
+		require.Equal(t, float64(i+1), nets[1].Values()["encrypted_rejected"])
+	}
+
+	// a packet encrypted for the node 2 does not decrypt with the key of
+	// the node 1
+	nets[0].Send([]handel.Identity{id2}, &handel.Packet{Origin: 0, Level: 2, MultiSig: []byte{0x01}})
+	pipes[1].deliver(pipes[0].last)
+	require.Len(t, rcvd, 1)
+	require.Equal(t, float64(len(tests)+1), nets[1].Values()["encrypted_rejected"])
+
+	// the origin of the encrypted packet must be the sender
+	var b bytes.Buffer
+	require.NoError(t, nets[2].enc.Encode(&handel.Packet{Origin: 0, Level: 2}, &b))
+	p, err := nets[2].seal(1, &handel.Packet{Level: 2}, b.Bytes())
+	require.NoError(t, err)
+	nets[1].receive(p)
+	require.Len(t, rcvd, 1)
+	require.Equal(t, float64(len(tests)+2), nets[1].Values()["encrypted_rejected"])
+}
+
+func TestEncryptedNetworkUnsupportedKey(t *testing.T) {
+	_, err := NewEncryptedNetwork(newPipeNetworks(1)[0], 0, &noExchangeKey{}, handel.NewArrayRegistry(nil))
+	require.Error(t, err)
+}
+
+type noExchangeKey struct {
+	handel.SecretKey
+}
+
+// BenchmarkEncryptedNetwork measures the time to send and receive a packet
+// over the pipe networks, and the bytes the encryption adds to the packets.
+func BenchmarkEncryptedNetwork(b *testing.B) {
+	packet := &handel.Packet{Origin: 0, Level: 5, MultiSig: bytes.Repeat([]byte{0xab}, 100), IndividualSig: bytes.Repeat([]byte{0xcd}, 64)}
+	size := func(p *handel.Packet) int {
+		var buff bytes.Buffer
+		require.NoError(b, NewGOBEncoding().Encode(p, &buff))
+		return buff.Len()
+	}
+
+	b.Run("plain", func(b *testing.B) {
+		pipes := newPipeNetworks(2)
+		pipes[1].RegisterListener(handel.ListenFunc(func(*handel.Packet) {}))
+		id := handel.NewStaticIdentity(1, "", nil)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pipes[0].Send([]handel.Identity{id}, packet)
+		}
+		b.ReportMetric(float64(size(pipes[0].last)), "bytes/packet")
+	})
+	b.Run("encrypted", func(b *testing.B) {
+		nets, pipes, reg := encryptedNetworks(b, 2)
+		nets[1].RegisterListener(handel.ListenFunc(func(*handel.Packet) {}))
+		id, _ := reg.Identity(1)
+		// the keys are derived once
+		nets[0].Send([]handel.Identity{id}, packet)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			nets[0].Send([]handel.Identity{id}, packet)
+		}
+		b.ReportMetric(float64(size(pipes[0].last)), "bytes/packet")
+		b.ReportMetric(float64(size(pipes[0].last)-size(packet)), "overhead-bytes/packet")
+	})
+}
@@ -6,8 +6,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"io/ioutil"
 	"math/big"
+	"path/filepath"
 	"time"
+
+	h "github.com/ConsenSys/handel"
 )
 
 // Config is a quic specyfic configuration
@@ -18,9 +23,12 @@ type Config struct {
 	dialer dialer
 	//handshakeTimeout quic handshakeTimeout
 	handshakeTimeout time.Duration
+	// auth binds the connections to the identities, nil without mutual TLS
+	auth *authenticator
 }
 
-const defaultHandshakeTimeout = 2000 * time.Millisecond
+// DefaultHandshakeTimeout is the handshake timeout of NewInsecureTestConfig
+const DefaultHandshakeTimeout = 2000 * time.Millisecond
 
 // NewInsecureTestConfig creates config for testing prupose,
 // node with this quic configuration won't verify server's
@@ -28,8 +36,8 @@ const defaultHandshakeTimeout = 2000 * time.Millisecond
 func NewInsecureTestConfig() Config {
 	return Config{
 		tlsCfg:           generateTestTLSConfig(),
-		dialer:           newInsecureQuicDialer(defaultHandshakeTimeout),
-		handshakeTimeout: defaultHandshakeTimeout,
+		dialer:           newInsecureQuicDialer(DefaultHandshakeTimeout),
+		handshakeTimeout: DefaultHandshakeTimeout,
 	}
 }
 
@@ -42,6 +50,64 @@ func NewConfig(pathToTLSCert string, pathToTLSKey string, handshakeTimeout time.
 	}
 }
 
+// NewTLSConfig creates quic configuration from the given tls config: the
+// network listens with it, and dials the peers with a copy of it.
+func NewTLSConfig(tlsCfg *tls.Config, handshakeTimeout time.Duration) Config {
+	return Config{
+		tlsCfg: tlsCfg,
+		dialer: newTLSDialer(handshakeTimeout, func(h.Identity) *tls.Config {
+			return tlsCfg.Clone()
+		}),
+		handshakeTimeout: handshakeTimeout,
+	}
+}
+
+// NewMutualTLSConfig creates quic configuration where the nodes authenticate
+// each other with the certificates of their identities issued by the CAs of
+// the pool, see IdentityURI: a node only accepts the certificate of the
+// identity it dials, and only the packets whose origin is the identity of the
+// certificate of the connection. The certificate is the one of the node.
+func NewMutualTLSConfig(cert tls.Certificate, roots *x509.CertPool, handshakeTimeout time.Duration) Config {
+	auth := &authenticator{roots: roots}
+	return Config{
+		tlsCfg: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			// verified by the authenticator, against the roots
+			ClientAuth:            tls.RequireAnyClientCert,
+			VerifyPeerCertificate: auth.verifier(-1, x509.ExtKeyUsageClientAuth),
+		},
+		dialer: newTLSDialer(handshakeTimeout, func(id h.Identity) *tls.Config {
+			return &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				// verified by the authenticator, against the roots and the
+				// identity dialed rather than a host name
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: auth.verifier(id.ID(), x509.ExtKeyUsageServerAuth),
+			}
+		}),
+		handshakeTimeout: handshakeTimeout,
+		auth:             auth,
+	}
+}
+
+// LoadMutualTLSConfig returns the NewMutualTLSConfig of the identity of the
+// given ID from the certificates of the directory, see WriteCertificates.
+func LoadMutualTLSConfig(dir string, id int32, handshakeTimeout time.Duration) (Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile(id)), filepath.Join(dir, keyFile(id)))
+	if err != nil {
+		return Config{}, err
+	}
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, caFile))
+	if err != nil {
+		return Config{}, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return Config{}, errors.New("quic: no CA certificate in " + caFile)
+	}
+	return NewMutualTLSConfig(cert, roots, handshakeTimeout), nil
+}
+
 func generateTestTLSConfig() *tls.Config {
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
 	quic "github.com/lucas-clemente/quic-go"
 )
 
@@ -16,28 +17,40 @@ type dialer interface {
 }
 
 type quicDialer struct {
-	handshakeTimeout   time.Duration
-	insecureSkipVerify bool
-	serverName         string
+	handshakeTimeout time.Duration
+	// returns the tls config of a session to the identity
+	tlsConfig func(h.Identity) *tls.Config
+	// the addresses of the peers reachable on several addresses
+	addrs *network.AddressSelector
 }
 
 func newQuicDialer(handshakeTimeout time.Duration, serverName string) dialer {
-	return &quicDialer{handshakeTimeout, false, serverName}
+	return newTLSDialer(handshakeTimeout, func(h.Identity) *tls.Config {
+		return &tls.Config{ServerName: serverName}
+	})
 }
 
 func newInsecureQuicDialer(handshakeTimeout time.Duration) dialer {
-	return &quicDialer{handshakeTimeout, true, ""}
+	return newTLSDialer(handshakeTimeout, func(h.Identity) *tls.Config {
+		return &tls.Config{InsecureSkipVerify: true}
+	})
+}
 
+func newTLSDialer(handshakeTimeout time.Duration, tlsConfig func(h.Identity) *tls.Config) dialer {
+	return &quicDialer{handshakeTimeout, tlsConfig, network.NewAddressSelector(0)}
 }
 
 func (q quicDialer) startDial(identity h.Identity, out chan *result) {
-	tlsCfg := &tls.Config{
-		InsecureSkipVerify: q.insecureSkipVerify,
-		ServerName:         q.serverName,
-	}
+	tlsCfg := q.tlsConfig(identity)
 	quicCfg := &quic.Config{HandshakeTimeout: q.handshakeTimeout}
-	//Returns session or error of the handshake timeout
-	sess, err := quic.DialAddr(identity.Address(), tlsCfg, quicCfg)
+	//Returns session or error of the handshake timeout, of the last address
+	//tried if the identity has several
+	var sess quic.Session
+	err := q.addrs.Send(identity, func(addr string) error {
+		var err error
+		sess, err = quic.DialAddr(addr, tlsCfg, quicCfg)
+		return err
+	})
 
 	if err != nil {
 		out <- &result{identity.ID(), nil, false, err}
@@ -26,6 +26,8 @@ type Network struct {
 	enc            network.Encoding
 	quicListener   quic.Listener
 	sessionManager sessionManager
+	// binds the connections to the identities, nil without mutual TLS
+	auth *authenticator
 }
 
 // NewNetwork creates Nework baked by QUIC protocol
@@ -45,6 +47,7 @@ func NewNetwork(addr string, enc network.Encoding, cfg Config) (*Network, error)
 		enc:            enc,
 		quicListener:   listener,
 		sessionManager: sessManager,
+		auth:           cfg.auth,
 	}
 
 	go net.handler()
@@ -107,18 +110,34 @@ func (quicNet *Network) handler() {
 			sess.Close()
 			return
 		}
-		go handleSession(sess, listeners, enc)
+		go handleSession(sess, listeners, enc, quicNet.auth)
 	}
 }
 
-func handleSession(sess quic.Session, listeners []h.Listener, enc network.Encoding) {
+func handleSession(sess quic.Session, listeners []h.Listener, enc network.Encoding, auth *authenticator) {
+	// with mutual TLS, the packets of the session must come from the identity
+	// of the certificate of the peer, verified during the handshake
+	origin := int32(-1)
+	if auth != nil {
+		certs := sess.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			sess.Close()
+			return
+		}
+		id, err := CertificateIdentity(certs[0])
+		if err != nil {
+			sess.Close()
+			return
+		}
+		origin = id
+	}
 	stream, err := sess.AcceptStream()
 
 	if err != nil {
 		return
 	}
 	reader := bufio.NewReader(stream)
-	dispatch(listeners, reader, enc)
+	dispatch(listeners, reader, enc, origin, auth)
 	// This implementation creates new session for every packet
 	// after packet is delivered the session has to be drined and closed
 	// see: lucas-clemente/quic-go#1618 (comment)
@@ -127,13 +146,26 @@ func handleSession(sess quic.Session, listeners []h.Listener, enc network.Encodi
 	sess.Close()
 }
 
-func dispatch(listeners []h.Listener, byteReader io.Reader, enc network.Encoding) {
+func dispatch(listeners []h.Listener, byteReader io.Reader, enc network.Encoding, origin int32, auth *authenticator) {
 	packet, err := enc.Decode(byteReader)
 
 	if err != nil {
 		log.Println(err)
 	}
+	if auth != nil && (packet == nil || packet.Origin != origin) {
+		auth.mismatch()
+		return
+	}
 	for _, listener := range listeners {
 		listener.NewPacket(packet)
 	}
 }
+
+// Values implements the monitor.CounterMeasure interface with the connections
+// and the packets refused by the mutual TLS, zero without it
+func (quicNet *Network) Values() map[string]float64 {
+	if quicNet.auth == nil {
+		return map[string]float64{"auth_rejected": 0, "auth_mismatch": 0}
+	}
+	return quicNet.auth.Values()
+}
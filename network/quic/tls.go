@@ -0,0 +1,255 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The certificates of the mutual TLS bind the connections to the identities of
+// the registry: the certificate of each node, issued by a CA all the nodes
+// trust, holds the URI of its identity, see IdentityURI. A node dialing a peer
+// only accepts the certificate of the identity dialed, and a node accepting a
+// connection only accepts the packets whose origin is the identity of the
+// certificate of the peer.
+
+// certValidity is the validity of the certificates generated
+const certValidity = 365 * 24 * time.Hour
+
+// IdentityURI returns the URI of the identity of the given ID held by its
+// certificate
+func IdentityURI(id int32) *url.URL {
+	return &url.URL{Scheme: "handel", Opaque: "node:" + strconv.Itoa(int(id))}
+}
+
+// CertificateIdentity returns the ID of the identity of the certificate, an
+// error if it holds none or several
+func CertificateIdentity(cert *x509.Certificate) (int32, error) {
+	id := int32(-1)
+	for _, u := range cert.URIs {
+		if u.Scheme != "handel" || !strings.HasPrefix(u.Opaque, "node:") {
+			continue
+		}
+		parsed, err := strconv.ParseInt(strings.TrimPrefix(u.Opaque, "node:"), 10, 32)
+		if err != nil || parsed < 0 {
+			return 0, fmt.Errorf("quic: invalid identity URI %s", u)
+		}
+		if id >= 0 {
+			return 0, errors.New("quic: certificate of several identities")
+		}
+		id = int32(parsed)
+	}
+	if id < 0 {
+		return 0, errors.New("quic: certificate without identity")
+	}
+	return id, nil
+}
+
+// authenticator verifies the certificates of the peers against the CA and
+// counts the connections and the packets refused
+type authenticator struct {
+	sync.Mutex
+	roots *x509.CertPool
+	// connections refused because of the certificate of the peer
+	rejected int
+	// packets refused because their origin is not the identity of the
+	// certificate of the connection
+	mismatched int
+}
+
+// verifier returns the function verifying the certificate chain of a peer,
+// as tls.Config.VerifyPeerCertificate, for the given usage. The peer must be
+// the identity of the given ID, any identity if negative.
+func (a *authenticator) verifier(expected int32, usage x509.ExtKeyUsage) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		err := a.verify(raw, expected, usage)
+		if err != nil {
+			a.Lock()
+			a.rejected++
+			a.Unlock()
+		}
+		return err
+	}
+}
+
+func (a *authenticator) verify(raw [][]byte, expected int32, usage x509.ExtKeyUsage) error {
+	if len(raw) == 0 {
+		return errors.New("quic: no certificate from the peer")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, r := range raw {
+		cert, err := x509.ParseCertificate(r)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+	id, err := CertificateIdentity(certs[0])
+	if err != nil {
+		return err
+	}
+	if expected >= 0 && id != expected {
+		return fmt.Errorf("quic: certificate of the identity %d instead of %d", id, expected)
+	}
+	return nil
+}
+
+// mismatch counts a packet whose origin is not the identity of its
+// connection
+func (a *authenticator) mismatch() {
+	a.Lock()
+	defer a.Unlock()
+	a.mismatched++
+}
+
+// Values returns the connections and the packets refused
+func (a *authenticator) Values() map[string]float64 {
+	a.Lock()
+	defer a.Unlock()
+	return map[string]float64{
+		"auth_rejected": float64(a.rejected),
+		"auth_mismatch": float64(a.mismatched),
+	}
+}
+
+// CA is a certificate authority issuing the certificates of the identities,
+// for tests and simulations.
+type CA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// NewCA returns a new self-signed certificate authority
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "handel CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key}, nil
+}
+
+// Pool returns the pool of the certificate of the CA, to verify the
+// certificates it issued
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns the certificate and the key of the identity of the given ID,
+// in PEM
+func (ca *CA) issue(id int32) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(id) + 2),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("handel node %d", id)},
+		URIs:         []*url.URL{IdentityURI(id)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// Issue returns the certificate of the identity of the given ID
+func (ca *CA) Issue(id int32) (tls.Certificate, error) {
+	certPEM, keyPEM, err := ca.issue(id)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// the files of the certificates in the directory of WriteCertificates
+const caFile = "ca.pem"
+
+func certFile(id int32) string { return fmt.Sprintf("node-%d.pem", id) }
+func keyFile(id int32) string  { return fmt.Sprintf("node-%d-key.pem", id) }
+
+// WriteCertificates writes, in the given directory, the certificate of a new
+// CA and the certificates and keys of the identities of the given IDs issued
+// by the CA, for LoadMutualTLSConfig. The key of the CA is not kept.
+func WriteCertificates(dir string, ids []int32) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ca, err := NewCA()
+	if err != nil {
+		return err
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, caFile), caPEM, 0600); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		certPEM, keyPEM, err := ca.issue(id)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, certFile(id)), certPEM, 0600); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, keyFile(id)), keyPEM, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package quic
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/stretchr/testify/require"
+)
+
+// newMutualConfig returns the mutual TLS config of the identity issued by the
+// CA
+func newMutualConfig(t *testing.T, ca *CA, id int32) Config {
+	cert, err := ca.Issue(id)
+	require.NoError(t, err)
+	return NewMutualTLSConfig(cert, ca.Pool(), time.Second)
+}
+
+// newTestNetwork returns a network listening on the address with the config
+// and a channel of the packets it receives
+func newTestNetwork(t *testing.T, addr string, cfg Config) (*Network, chan *h.Packet) {
+	n, err := NewNetwork(addr, network.NewGOBEncoding(), cfg)
+	require.NoError(t, err)
+	rcvd := make(chan *h.Packet, 10)
+	n.RegisterListener(h.ListenFunc(func(p *h.Packet) { rcvd <- p }))
+	return n, rcvd
+}
+
+func requireReceived(t *testing.T, rcvd chan *h.Packet, origin int32) {
+	select {
+	case p := <-rcvd:
+		require.Equal(t, origin, p.Origin)
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet not received")
+	}
+}
+
+func requireNotReceived(t *testing.T, rcvd chan *h.Packet) {
+	select {
+	case p := <-rcvd:
+		t.Fatalf("packet of %d received", p.Origin)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	ca, err := NewCA()
+	require.NoError(t, err)
+	cert, err := ca.Issue(12)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	id, err := CertificateIdentity(parsed)
+	require.NoError(t, err)
+	require.Equal(t, int32(12), id)
+
+	auth := &authenticator{roots: ca.Pool()}
+	require.NoError(t, auth.verifier(12, x509.ExtKeyUsageServerAuth)(cert.Certificate, nil))
+	require.NoError(t, auth.verifier(-1, x509.ExtKeyUsageClientAuth)(cert.Certificate, nil))
+	require.Error(t, auth.verifier(13, x509.ExtKeyUsageServerAuth)(cert.Certificate, nil))
+	// the certificates of another CA are refused
+	other, err := NewCA()
+	require.NoError(t, err)
+	foreign, err := other.Issue(12)
+	require.NoError(t, err)
+	require.Error(t, auth.verifier(12, x509.ExtKeyUsageServerAuth)(foreign.Certificate, nil))
+	require.Equal(t, 2.0, auth.Values()["auth_rejected"])
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, err := NewCA()
+	require.NoError(t, err)
+	addr0, addr1 := "127.0.0.1:3100", "127.0.0.1:3101"
+	n0, rcvd0 := newTestNetwork(t, addr0, newMutualConfig(t, ca, 0))
+	n1, rcvd1 := newTestNetwork(t, addr1, newMutualConfig(t, ca, 1))
+	defer n0.Stop()
+	defer n1.Stop()
+
+	// the nodes of the CA exchange their packets
+	n1.Send([]h.Identity{h.NewStaticIdentity(0, addr0, nil)}, &h.Packet{Origin: 1, Level: 1})
+	requireReceived(t, rcvd0, 1)
+	n0.Send([]h.Identity{h.NewStaticIdentity(1, addr1, nil)}, &h.Packet{Origin: 0, Level: 1})
+	requireReceived(t, rcvd1, 0)
+
+	// a packet whose origin is not the identity of the certificate is dropped
+	n1.Send([]h.Identity{h.NewStaticIdentity(0, addr0, nil)}, &h.Packet{Origin: 2, Level: 1})
+	requireNotReceived(t, rcvd0)
+	require.Equal(t, 1.0, n0.Values()["auth_mismatch"])
+
+	// the node at the address is not the identity dialed
+	n1.Send([]h.Identity{h.NewStaticIdentity(2, addr0, nil)}, &h.Packet{Origin: 1, Level: 1})
+	requireNotReceived(t, rcvd0)
+	require.Equal(t, 1.0, n1.Values()["auth_rejected"])
+
+	// the certificates of another CA are refused
+	other, err := NewCA()
+	require.NoError(t, err)
+	n2, _ := newTestNetwork(t, "127.0.0.1:3102", newMutualConfig(t, other, 2))
+	defer n2.Stop()
+	n2.Send([]h.Identity{h.NewStaticIdentity(0, addr0, nil)}, &h.Packet{Origin: 2, Level: 1})
+	requireNotReceived(t, rcvd0)
+	require.True(t, n0.Values()["auth_rejected"] >= 1)
+}
+
+func TestLoadMutualTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handel-quic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, WriteCertificates(dir, []int32{0, 1}))
+	cfg0, err := LoadMutualTLSConfig(dir, 0, time.Second)
+	require.NoError(t, err)
+	cfg1, err := LoadMutualTLSConfig(dir, 1, time.Second)
+	require.NoError(t, err)
+	_, err = LoadMutualTLSConfig(dir, 2, time.Second)
+	require.Error(t, err)
+
+	addr0 := "127.0.0.1:3103"
+	n0, rcvd0 := newTestNetwork(t, addr0, cfg0)
+	n1, _ := newTestNetwork(t, "127.0.0.1:3104", cfg1)
+	defer n0.Stop()
+	defer n1.Stop()
+	n1.Send([]h.Identity{h.NewStaticIdentity(0, addr0, nil)}, &h.Packet{Origin: 1, Level: 1})
+	requireReceived(t, rcvd0, 1)
+}
+
+func TestInsecureTestConfig(t *testing.T) {
+	// the insecure config of the simulations on localhost still works
+	addr0 := "127.0.0.1:3105"
+	n0, rcvd0 := newTestNetwork(t, addr0, NewInsecureTestConfig())
+	n1, _ := newTestNetwork(t, "127.0.0.1:3106", NewInsecureTestConfig())
+	defer n0.Stop()
+	defer n1.Stop()
+	n1.Send([]h.Identity{h.NewStaticIdentity(0, addr0, nil)}, &h.Packet{Origin: 5, Level: 1})
+	requireReceived(t, rcvd0, 5)
+	require.Equal(t, 0.0, n0.Values()["auth_mismatch"])
+}
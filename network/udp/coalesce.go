@@ -0,0 +1,136 @@
+package udp
+
+import (
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+)
+
+// Options holds the optional behaviors of the udp network. The zero value
+// disables them all.
+type Options struct {
+	// CoalesceDelay is how long a packet waits in the queue of its destination
+	// before being sent. During this delay, a newer packet for the same
+	// destination and level replaces the queued one instead of being sent as
+	// well. Zero disables the coalescing: packets are sent right away.
+	CoalesceDelay time.Duration
+	// CoalesceMax is the number of packets queued for a destination that
+	// flushes its queue before the delay. Zero means DefaultCoalesceMax.
+	CoalesceMax int
+	// LevelPorts is the number of levels having their own socket: the network
+	// also binds the ports following its own, one per level, and sends the
+	// packets of level L from the port of its address plus L. The packets
+	// received on the port of a level must be of this level, the others are
+	// dropped and counted as mismatches. It is the counterpart of
+	// handel.Config.PortPerLevel. Zero sends and receives all the packets on
+	// the port of the address.
+	LevelPorts int
+	// ReprobePeriod is the period after which the network tries again the
+	// preferred addresses of a peer reachable on several addresses, once it
+	// stuck to another one, see network.AddressSelector. Zero means
+	// network.DefaultReprobePeriod.
+	ReprobePeriod time.Duration
+}
+
+// DefaultCoalesceMax is the default size of the queue of a destination
+const DefaultCoalesceMax = 16
+
+// coalescer queues the packets per destination for a short delay, keeping
+// only the latest packet of each level. The packets of a destination are sent
+// in the order in which their level was first queued.
+type coalescer struct {
+	sync.Mutex
+	delay time.Duration
+	max   int
+	// the queues by destination address
+	queues map[string]*sendQueue
+	// sends the packets of a flushed queue
+	send func(h.Identity, *h.Packet)
+	// number of packets replaced by a newer one
+	coalesced int
+	stopped   bool
+}
+
+type sendQueue struct {
+	id      h.Identity
+	packets []*h.Packet
+	timer   *time.Timer
+}
+
+func newCoalescer(opts Options, send func(h.Identity, *h.Packet)) *coalescer {
+	max := opts.CoalesceMax
+	if max <= 0 {
+		max = DefaultCoalesceMax
+	}
+	return &coalescer{
+		delay:  opts.CoalesceDelay,
+		max:    max,
+		queues: make(map[string]*sendQueue),
+		send:   send,
+	}
+}
+
+// push queues the packet for the identity, replacing the queued packet of the
+// same level if any.
+func (c *coalescer) push(id h.Identity, p *h.Packet) {
+	addr := id.Address()
+	c.Lock()
+	if c.stopped {
+		c.Unlock()
+		return
+	}
+	q, exists := c.queues[addr]
+	if !exists {
+		q = &sendQueue{id: id}
+		c.queues[addr] = q
+		q.timer = time.AfterFunc(c.delay, func() { c.flush(addr, q) })
+	}
+	for i, queued := range q.packets {
+		if queued.Level == p.Level {
+			q.packets[i] = p
+			c.coalesced++
+			c.Unlock()
+			return
+		}
+	}
+	q.packets = append(q.packets, p)
+	full := len(q.packets) >= c.max
+	c.Unlock()
+	if full {
+		c.flush(addr, q)
+	}
+}
+
+// flush sends the packets of the queue if it is still the queue of the
+// destination, i.e. if it has not been flushed already.
+func (c *coalescer) flush(addr string, q *sendQueue) {
+	c.Lock()
+	if c.queues[addr] != q {
+		c.Unlock()
+		return
+	}
+	delete(c.queues, addr)
+	q.timer.Stop()
+	c.Unlock()
+	for _, p := range q.packets {
+		c.send(q.id, p)
+	}
+}
+
+// stop drops the queued packets
+func (c *coalescer) stop() {
+	c.Lock()
+	defer c.Unlock()
+	c.stopped = true
+	for addr, q := range c.queues {
+		q.timer.Stop()
+		delete(c.queues, addr)
+	}
+}
+
+func (c *coalescer) values() map[string]float64 {
+	c.Lock()
+	defer c.Unlock()
+	return map[string]float64{"coalesced": float64(c.coalesced)}
+}
@@ -2,11 +2,13 @@ package udp
 
 import (
 	"bufio"
+	"bytes"
 	"container/list"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/ConsenSys/handel"
@@ -19,7 +21,7 @@ import (
 type Network struct {
 	sync.RWMutex
 	udpSock   *net.UDPConn
-	listeners []h.Listener
+	listeners []*filteredListener
 	quit      bool
 	enc       network.Encoding
 	newPacket chan *handel.Packet
@@ -29,10 +31,28 @@ type Network struct {
 	buff      []*handel.Packet
 	sent      int
 	rcvd      int
+	// nil unless the coalescing is enabled
+	coalescer *coalescer
+	// the sockets by level, the first one being udpSock. Empty unless the
+	// level ports are enabled, see Options.LevelPorts.
+	levelSocks []*net.UDPConn
+	// packets sent and received by level port
+	levelSent []int
+	levelRcvd []int
+	// packets received on the port of another level than theirs
+	mismatch int
+	// the addresses of the peers reachable on several addresses
+	addrs *network.AddressSelector
 }
 
 // NewNetwork creates Network baked by udp protocol
 func NewNetwork(addr string, enc network.Encoding) (*Network, error) {
+	return NewNetworkWithOptions(addr, enc, Options{})
+}
+
+// NewNetworkWithOptions creates Network baked by udp protocol with the given
+// options, see Options.
+func NewNetworkWithOptions(addr string, enc network.Encoding, opts Options) (*Network, error) {
 	_, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -57,8 +77,22 @@ func NewNetwork(addr string, enc network.Encoding) (*Network, error) {
 		process:   make(chan *handel.Packet, 100),
 		ready:     make(chan bool, 1),
 		done:      make(chan bool, 1),
+		addrs:     network.NewAddressSelector(opts.ReprobePeriod),
+	}
+	if opts.LevelPorts > 0 {
+		if err := udpNet.bindLevels(port, opts.LevelPorts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CoalesceDelay > 0 {
+		udpNet.coalescer = newCoalescer(opts, udpNet.send)
+	}
+	if len(udpNet.levelSocks) == 0 {
+		go udpNet.handler(udpSock, 0)
+	}
+	for lvl, sock := range udpNet.levelSocks {
+		go udpNet.handler(sock, lvl)
 	}
-	go udpNet.handler()
 	go udpNet.loop()
 	go udpNet.dispatchLoop()
 	return udpNet, nil
@@ -71,16 +105,86 @@ func (udpNet *Network) Stop() {
 	if udpNet.quit {
 		return
 	}
+	if udpNet.coalescer != nil {
+		udpNet.coalescer.stop()
+	}
 	udpNet.udpSock.Close()
+	for lvl, sock := range udpNet.levelSocks {
+		if lvl > 0 {
+			sock.Close()
+		}
+	}
 	udpNet.quit = true
 	close(udpNet.done)
 }
 
+// bindLevels binds the sockets of the levels 1 to levels included, on the
+// ports following the given one.
+func (udpNet *Network) bindLevels(port string, levels int) error {
+	base, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	socks := []*net.UDPConn{udpNet.udpSock}
+	for lvl := 1; lvl <= levels; lvl++ {
+		addr := net.JoinHostPort("0.0.0.0", strconv.Itoa(base+lvl))
+		udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+		if err == nil {
+			var sock *net.UDPConn
+			if sock, err = net.ListenUDP("udp", udpAddr); err == nil {
+				socks = append(socks, sock)
+				continue
+			}
+		}
+		for _, sock := range socks {
+			sock.Close()
+		}
+		return err
+	}
+	udpNet.levelSocks = socks
+	udpNet.levelSent = make([]int, len(socks))
+	udpNet.levelRcvd = make([]int, len(socks))
+	return nil
+}
+
+// filteredListener is a listener receiving only the packets accepted by its
+// filter, all packets if the filter is nil
+type filteredListener struct {
+	filter func(*h.Packet) bool
+	h.Listener
+}
+
 //RegisterListener registers listener for processing incoming packets
 func (udpNet *Network) RegisterListener(listener h.Listener) {
+	udpNet.RegisterListenerFunc(nil, listener)
+}
+
+// RegisterListenerFunc registers a listener receiving only the packets for
+// which the filter returns true, so several protocols can share the same
+// socket. A nil filter accepts all packets. The filter must not modify the
+// packet it is given.
+//
+// Each listener receives its own copy of the packet: a listener can modify or
+// keep the packet without affecting the other listeners.
+func (udpNet *Network) RegisterListenerFunc(filter func(*h.Packet) bool, listener h.Listener) {
 	udpNet.Lock()
 	defer udpNet.Unlock()
-	udpNet.listeners = append(udpNet.listeners, listener)
+	udpNet.listeners = append(udpNet.listeners, &filteredListener{filter, listener})
+}
+
+// Filtered returns a view of this network registering its listeners with the
+// given filter, see RegisterListenerFunc. Stopping the view stops the network.
+func (udpNet *Network) Filtered(filter func(*h.Packet) bool) h.Network {
+	return &filteredNetwork{Network: udpNet, filter: filter}
+}
+
+type filteredNetwork struct {
+	*Network
+	filter func(*h.Packet) bool
+}
+
+func (f *filteredNetwork) RegisterListener(listener h.Listener) {
+	f.RegisterListenerFunc(f.filter, listener)
 }
 
 //Send sends a packet to supplied identities
@@ -89,21 +193,39 @@ func (udpNet *Network) Send(identities []h.Identity, packet *h.Packet) {
 	udpNet.sent += len(identities)
 	udpNet.Unlock()
 	for _, id := range identities {
+		if udpNet.coalescer != nil {
+			udpNet.coalescer.push(id, packet)
+			continue
+		}
 		udpNet.send(id, packet)
 	}
 }
 
+// send sends the packet to the addresses of the identity in order, until the
+// send to one of them succeeds, see network.AddressSelector.
 func (udpNet *Network) send(identity h.Identity, packet *h.Packet) {
-	addr := identity.Address()
+	err := udpNet.addrs.Send(identity, func(addr string) error {
+		return udpNet.sendTo(addr, packet)
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// sendTo sends the packet to the address. The packets which can't be encoded
+// are dropped without error, since no other address would do better.
+func (udpNet *Network) sendTo(addr string, packet *h.Packet) error {
 	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
 	if err != nil {
-		//TODO consider changing it to error logging
-		panic(err)
+		return err
+	}
+	if lvl := int(packet.Level); lvl < len(udpNet.levelSocks) {
+		return udpNet.sendFrom(lvl, udpAddr, packet)
 	}
 
 	udpSock, err := net.DialUDP("udp", nil, udpAddr)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer udpSock.Close()
 
@@ -115,13 +237,29 @@ func (udpNet *Network) send(identity h.Identity, packet *h.Packet) {
 	err = udpNet.enc.Encode(packet, byteWriter)
 	if err != nil {
 		//TODO consider changing it to error logging
-		return
+		return nil
 	}
-	byteWriter.Flush()
-	//fmt.Printf("%s -> sending packet to %s\n", udpSock.LocalAddr().String(), addr)
+	return byteWriter.Flush()
 }
 
-func (udpNet *Network) handler() {
+// sendFrom sends the packet from the socket of the given level
+func (udpNet *Network) sendFrom(lvl int, udpAddr *net.UDPAddr, packet *h.Packet) error {
+	var buff bytes.Buffer
+	if err := udpNet.enc.Encode(packet, &buff); err != nil {
+		return nil
+	}
+	if _, err := udpNet.levelSocks[lvl].WriteToUDP(buff.Bytes(), udpAddr); err != nil {
+		return err
+	}
+	udpNet.Lock()
+	udpNet.levelSent[lvl]++
+	udpNet.Unlock()
+	return nil
+}
+
+// handler decodes the packets received on the socket of the given level. When
+// the level ports are enabled, the packets of another level are dropped.
+func (udpNet *Network) handler(socket *net.UDPConn, lvl int) {
 	enc := udpNet.enc
 	for {
 		//udpNet.quit and udpNet.listeners have to be guarded by a read lock
@@ -132,7 +270,6 @@ func (udpNet *Network) handler() {
 		if quit {
 			return
 		}
-		socket := udpNet.udpSock
 		reader := bufio.NewReader(socket)
 		var byteReader io.Reader = bufio.NewReader(reader)
 		packet, err := enc.Decode(byteReader)
@@ -140,11 +277,27 @@ func (udpNet *Network) handler() {
 			log.Println(err)
 			continue
 		}
+		if len(udpNet.levelSocks) > 0 && !udpNet.received(lvl, packet) {
+			continue
+		}
 		//udpNet.dispatch(packet)
 		udpNet.newPacket <- packet
 	}
 }
 
+// received accounts a packet received on the port of the given level and
+// returns false if the packet is of another level.
+func (udpNet *Network) received(lvl int, packet *h.Packet) bool {
+	udpNet.Lock()
+	defer udpNet.Unlock()
+	if int(packet.Level) != lvl {
+		udpNet.mismatch++
+		return false
+	}
+	udpNet.levelRcvd[lvl]++
+	return true
+}
+
 func (udpNet *Network) loop() {
 	pendings := list.New()
 	var ready = false
@@ -179,7 +332,7 @@ func (udpNet *Network) loop() {
 	}
 }
 
-func (udpNet *Network) getListeners() []handel.Listener {
+func (udpNet *Network) getListeners() []*filteredListener {
 	udpNet.RLock()
 	defer udpNet.RUnlock()
 	udpNet.rcvd++
@@ -188,9 +341,20 @@ func (udpNet *Network) getListeners() []handel.Listener {
 
 func (udpNet *Network) dispatchLoop() {
 	dispatch := func(p *handel.Packet) {
-		listeners := udpNet.getListeners()
-		for _, listener := range listeners {
-			listener.NewPacket(p)
+		var accepted []handel.Listener
+		for _, l := range udpNet.getListeners() {
+			if l.filter == nil || l.filter(p) {
+				accepted = append(accepted, l.Listener)
+			}
+		}
+		// the decoded packet is handed to the last listener only, once all
+		// the copies are made
+		for i, listener := range accepted {
+			if i == len(accepted)-1 {
+				listener.NewPacket(p)
+			} else {
+				listener.NewPacket(copyPacket(p))
+			}
 		}
 	}
 
@@ -208,6 +372,24 @@ func (udpNet *Network) dispatchLoop() {
 	}
 }
 
+// copyPacket returns a deep copy of the packet
+func copyPacket(p *handel.Packet) *handel.Packet {
+	c := *p
+	if p.MultiSig != nil {
+		c.MultiSig = append([]byte{}, p.MultiSig...)
+	}
+	if p.IndividualSig != nil {
+		c.IndividualSig = append([]byte{}, p.IndividualSig...)
+	}
+	if p.PreviousKeys != nil {
+		c.PreviousKeys = append([]byte{}, p.PreviousKeys...)
+	}
+	if p.Signature != nil {
+		c.Signature = append([]byte{}, p.Signature...)
+	}
+	return &c
+}
+
 // Values implements the monitor.CounterMeasure interface
 func (udpNet *Network) Values() map[string]float64 {
 	udpNet.RLock()
@@ -216,6 +398,22 @@ func (udpNet *Network) Values() map[string]float64 {
 		"sent": float64(udpNet.sent),
 		"rcvd": float64(udpNet.rcvd),
 	}
+	if len(udpNet.levelSocks) > 0 {
+		toSend["level_mismatch"] = float64(udpNet.mismatch)
+		for lvl := range udpNet.levelSocks {
+			prefix := "port" + strconv.Itoa(lvl)
+			toSend[prefix+"_sent"] = float64(udpNet.levelSent[lvl])
+			toSend[prefix+"_rcvd"] = float64(udpNet.levelRcvd[lvl])
+		}
+	}
+	for k, v := range udpNet.addrs.Values() {
+		toSend[k] = v
+	}
+	if udpNet.coalescer != nil {
+		for k, v := range udpNet.coalescer.values() {
+			toSend[k] = v
+		}
+	}
 	counter, ok := udpNet.enc.(*network.CounterEncoding)
 	if ok {
 		for k, v := range counter.Values() {
@@ -29,3 +29,275 @@ func TestUDPNetwork(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestUDPNetworkListenersIsolation(t *testing.T) {
+	n1, err := NewNetwork("127.0.0.1:3002", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3003", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	// the first listener mutates and keeps its packet
+	first := make(chan *handel.Packet, 1)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		p.Origin = 42
+		p.MultiSig[0] = 0xff
+		first <- p
+	}))
+	second := make(chan *handel.Packet, 1)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		second <- p
+	}))
+
+	id2 := handel.NewStaticIdentity(2, "127.0.0.1:3003", nil)
+	n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: 1, Level: 1, MultiSig: []byte{0x01, 0x02}})
+
+	var p1, p2 *handel.Packet
+	for _, ch := range []chan *handel.Packet{first, second} {
+		select {
+		case p := <-ch:
+			if p1 == nil {
+				p1 = p
+			} else {
+				p2 = p
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("packet not received")
+		}
+	}
+	require.Equal(t, int32(42), p1.Origin)
+	require.Equal(t, []byte{0xff, 0x02}, p1.MultiSig)
+	require.Equal(t, int32(1), p2.Origin)
+	require.Equal(t, []byte{0x01, 0x02}, p2.MultiSig)
+}
+
+func TestUDPNetworkListenerFilters(t *testing.T) {
+	n1, err := NewNetwork("127.0.0.1:3004", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3005", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	levels := func(ch chan byte) handel.Listener {
+		return handel.ListenFunc(func(p *handel.Packet) { ch <- p.Level })
+	}
+	zero := make(chan byte, 10)
+	n2.RegisterListenerFunc(func(p *handel.Packet) bool { return p.Level == 0 }, levels(zero))
+	others := make(chan byte, 10)
+	n2.Filtered(func(p *handel.Packet) bool { return p.Level != 0 }).RegisterListener(levels(others))
+	all := make(chan byte, 10)
+	n2.RegisterListener(levels(all))
+
+	id2 := handel.NewStaticIdentity(2, "127.0.0.1:3005", nil)
+	for _, level := range []byte{0, 1, 2, 0} {
+		n1.Send([]handel.Identity{id2}, &handel.Packet{Level: level, MultiSig: []byte{0x01}})
+	}
+
+	receive := func(ch chan byte, n int) []byte {
+		var got []byte
+		for len(got) < n {
+			select {
+			case l := <-ch:
+				got = append(got, l)
+			case <-time.After(500 * time.Millisecond):
+				t.Fatalf("received %d packets instead of %d", len(got), n)
+			}
+		}
+		select {
+		case l := <-ch:
+			t.Fatalf("unexpected packet of level %d", l)
+		case <-time.After(100 * time.Millisecond):
+		}
+		return got
+	}
+	require.Equal(t, []byte{0, 0}, receive(zero, 2))
+	require.ElementsMatch(t, []byte{1, 2}, receive(others, 2))
+	require.Len(t, receive(all, 4), 4)
+}
+
+func TestUDPNetworkCoalescing(t *testing.T) {
+	opts := Options{CoalesceDelay: 50 * time.Millisecond}
+	n1, err := NewNetworkWithOptions("127.0.0.1:3006", network.NewGOBEncoding(), opts)
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3007", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	received := make(chan *handel.Packet, 20)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		received <- p
+	}))
+	id2 := []handel.Identity{handel.NewStaticIdentity(2, "127.0.0.1:3007", nil)}
+	send := func(level byte, version int32) {
+		n1.Send(id2, &handel.Packet{Origin: version, Level: level, MultiSig: []byte{0x01}})
+	}
+	// wait for one flush window and returns the (level, version) received
+	flushed := func() [][2]int {
+		var got [][2]int
+		for {
+			select {
+			case p := <-received:
+				got = append(got, [2]int{int(p.Level), int(p.Origin)})
+			case <-time.After(200 * time.Millisecond):
+				return got
+			}
+		}
+	}
+
+	// the level 2 is queued after the level 1 and stays after it
+	send(1, 1)
+	send(2, 1)
+	send(1, 2)
+	send(2, 2)
+	send(1, 3)
+	require.Equal(t, [][2]int{{1, 3}, {2, 2}}, flushed())
+	require.Equal(t, 3.0, n1.Values()["coalesced"])
+
+	// a new window starts after the flush
+	send(3, 1)
+	send(3, 2)
+	require.Equal(t, [][2]int{{3, 2}}, flushed())
+	require.Equal(t, 4.0, n1.Values()["coalesced"])
+	require.Equal(t, 7.0, n1.Values()["sent"])
+}
+
+func TestUDPNetworkCoalescingMax(t *testing.T) {
+	opts := Options{CoalesceDelay: time.Minute, CoalesceMax: 3}
+	n1, err := NewNetworkWithOptions("127.0.0.1:3008", network.NewGOBEncoding(), opts)
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3009", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	received := make(chan byte, 20)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		received <- p.Level
+	}))
+	id2 := []handel.Identity{handel.NewStaticIdentity(2, "127.0.0.1:3009", nil)}
+	for _, level := range []byte{1, 2, 1, 3} {
+		n1.Send(id2, &handel.Packet{Level: level, MultiSig: []byte{0x01}})
+	}
+	// the third level fills the queue, flushed without waiting for the delay
+	for _, exp := range []byte{1, 2, 3} {
+		select {
+		case level := <-received:
+			require.Equal(t, exp, level)
+		case <-time.After(time.Second):
+			t.Fatal("queue not flushed")
+		}
+	}
+	require.Equal(t, 1.0, n1.Values()["coalesced"])
+}
+
+func TestUDPNetworkLevelPorts(t *testing.T) {
+	opts := Options{LevelPorts: 2}
+	n1, err := NewNetworkWithOptions("127.0.0.1:3020", network.NewGOBEncoding(), opts)
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetworkWithOptions("127.0.0.1:3030", network.NewGOBEncoding(), opts)
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	received := make(chan *handel.Packet, 20)
+	listener := handel.ListenFunc(func(p *handel.Packet) {
+		received <- p
+	})
+	n1.RegisterListener(listener)
+	n2.RegisterListener(listener)
+	// send the packet to the port of its level, as Handel does
+	send := func(from *Network, to string, level byte) {
+		addr, err := handel.LevelAddress(to, int(level))
+		require.NoError(t, err)
+		id := []handel.Identity{handel.NewStaticIdentity(0, addr, nil)}
+		from.Send(id, &handel.Packet{Level: level, MultiSig: []byte{0x01}})
+	}
+	send(n1, "127.0.0.1:3030", 0)
+	send(n1, "127.0.0.1:3030", 1)
+	send(n1, "127.0.0.1:3030", 1)
+	send(n1, "127.0.0.1:3030", 2)
+	send(n2, "127.0.0.1:3020", 2)
+	for i := 0; i < 5; i++ {
+		select {
+		case <-received:
+		case <-time.After(1 * time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+
+	v1, v2 := n1.Values(), n2.Values()
+	require.Equal(t, []float64{1, 2, 1}, []float64{v1["port0_sent"], v1["port1_sent"], v1["port2_sent"]})
+	require.Equal(t, []float64{1, 2, 1}, []float64{v2["port0_rcvd"], v2["port1_rcvd"], v2["port2_rcvd"]})
+	require.Equal(t, []float64{0, 0, 1}, []float64{v2["port0_sent"], v2["port1_sent"], v2["port2_sent"]})
+	require.Equal(t, []float64{0, 0, 1}, []float64{v1["port0_rcvd"], v1["port1_rcvd"], v1["port2_rcvd"]})
+	require.Equal(t, 0.0, v1["level_mismatch"])
+	require.Equal(t, 0.0, v2["level_mismatch"])
+}
+
+func TestUDPNetworkLevelPortsInterop(t *testing.T) {
+	n1, err := NewNetworkWithOptions("127.0.0.1:3040", network.NewGOBEncoding(), Options{LevelPorts: 2})
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3050", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	received := make(chan *handel.Packet, 20)
+	listener := handel.ListenFunc(func(p *handel.Packet) {
+		received <- p
+	})
+	n1.RegisterListener(listener)
+	n2.RegisterListener(listener)
+
+	// n2 sends to the address of n1, where only the level 0 is accepted
+	id1 := []handel.Identity{handel.NewStaticIdentity(1, "127.0.0.1:3040", nil)}
+	n2.Send(id1, &handel.Packet{Level: 1, MultiSig: []byte{0x01}})
+	// n1 sends to the level port of n2, where nobody listens
+	id2 := []handel.Identity{handel.NewStaticIdentity(2, "127.0.0.1:3051", nil)}
+	n1.Send(id2, &handel.Packet{Level: 1, MultiSig: []byte{0x01}})
+
+	select {
+	case p := <-received:
+		t.Fatalf("packet of level %d delivered", p.Level)
+	case <-time.After(300 * time.Millisecond):
+	}
+	require.Equal(t, 1.0, n1.Values()["level_mismatch"])
+	require.Equal(t, 0.0, n1.Values()["port0_rcvd"])
+	require.Equal(t, 1.0, n1.Values()["port1_sent"])
+	require.Equal(t, 0.0, n2.Values()["rcvd"])
+}
+
+// TestUDPNetworkSecondaryAddress sends to a peer only reachable on its
+// secondary address: the primary one is an IPv6 address the network can't
+// send to.
+func TestUDPNetworkSecondaryAddress(t *testing.T) {
+	n1, err := NewNetwork("127.0.0.1:3060", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:3061", network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	received := make(chan bool, 10)
+	n2.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		received <- true
+	}))
+
+	id2 := handel.NewStaticIdentityAddresses(2, []string{"[::1]:3061", "127.0.0.1:3061"}, nil)
+	require.Equal(t, "[::1]:3061", id2.Address())
+	for i := 0; i < 5; i++ {
+		n1.Send([]handel.Identity{id2}, &handel.Packet{Origin: 1, MultiSig: []byte{0x01}})
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("packet not received")
+		}
+		// only the first send tried the primary address
+		v := n1.Values()
+		require.Equal(t, 1.0, v["addrFailed"])
+		require.Equal(t, 1.0, v["addrSecondary"])
+	}
+}
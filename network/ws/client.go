@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/gorilla/websocket"
+)
+
+// Client is a handel.Network connected to a gateway. All the packets are sent
+// to the gateway that routes them to their recipients. The client reconnects
+// with an exponential backoff whenever the connection drops; packets sent
+// while disconnected are dropped.
+type Client struct {
+	sync.RWMutex
+	url       string
+	id        int32
+	enc       network.Encoding
+	conn      *websocket.Conn
+	listeners []h.Listener
+	done      chan bool
+	stopped   bool
+	wg        sync.WaitGroup
+	// notified on each connection, disconnection and counter change
+	changes  notifier
+	connects int
+	sent     int
+	rcvd     int
+	dropped  int
+}
+
+// NewClient returns a Client that connects to the gateway listening on the
+// given address, authenticating itself with the id of the given identity.
+func NewClient(gateway string, id h.Identity, enc network.Encoding) *Client {
+	c := &Client{
+		url:  "ws://" + gateway + "/",
+		id:   id.ID(),
+		enc:  enc,
+		done: make(chan bool),
+	}
+	c.wg.Add(1)
+	go c.connectLoop()
+	return c
+}
+
+func (c *Client) connectLoop() {
+	defer c.wg.Done()
+	backoff := minBackoff
+	for {
+		conn, err := c.dial()
+		if err == nil && c.setConn(conn) {
+			backoff = minBackoff
+			c.readLoop(conn)
+			c.setConn(nil)
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) dial() (*websocket.Conn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: writeWait}
+	conn, _, err := dialer.Dial(c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.BinaryMessage, encodeHandshake(c.id)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setConn sets the active connection, and returns false if the client is
+// stopped in which case the connection is closed.
+func (c *Client) setConn(conn *websocket.Conn) bool {
+	c.Lock()
+	defer c.Unlock()
+	if conn != nil && c.stopped {
+		conn.Close()
+		return false
+	}
+	if conn != nil {
+		c.connects++
+	}
+	c.conn = conn
+	c.changes.notify()
+	return true
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		packet, err := decodePacket(msg, c.enc)
+		if err != nil {
+			continue
+		}
+		dispatch(c.getListeners(), packet)
+	}
+}
+
+func (c *Client) getListeners() []h.Listener {
+	c.Lock()
+	defer c.Unlock()
+	c.rcvd++
+	c.changes.notify()
+	return c.listeners
+}
+
+// Send implements the handel.Network interface. The packet is sent once to
+// the gateway along with the ids of the recipients.
+func (c *Client) Send(ids []h.Identity, p *h.Packet) {
+	recipients := make([]int32, len(ids))
+	for i, id := range ids {
+		recipients[i] = id.ID()
+	}
+	frame, err := encodeFrame(recipients, p, c.enc)
+	if err != nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	defer c.changes.notify()
+	if c.conn == nil {
+		c.dropped += len(ids)
+		return
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		// the read loop notices the broken connection and reconnects
+		c.conn.Close()
+		c.dropped += len(ids)
+		return
+	}
+	c.sent += len(ids)
+}
+
+// RegisterListener implements the handel.Network interface
+func (c *Client) RegisterListener(l h.Listener) {
+	c.Lock()
+	defer c.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// Connected returns true if the client has an active connection to the
+// gateway.
+func (c *Client) Connected() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.conn != nil
+}
+
+// changed returns a channel closed at the next change of the client's state
+func (c *Client) changed() <-chan struct{} {
+	return c.changes.wait()
+}
+
+// Stop closes the connection to the gateway and waits for the client's
+// routines to return.
+func (c *Client) Stop() {
+	c.Lock()
+	if c.stopped {
+		c.Unlock()
+		return
+	}
+	c.stopped = true
+	close(c.done)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.Unlock()
+	c.wg.Wait()
+}
+
+// Values implements the monitor.CounterMeasure interface
+func (c *Client) Values() map[string]float64 {
+	c.RLock()
+	defer c.RUnlock()
+	reconnects := 0
+	if c.connects > 1 {
+		reconnects = c.connects - 1
+	}
+	toSend := map[string]float64{
+		"sent":       float64(c.sent),
+		"rcvd":       float64(c.rcvd),
+		"dropped":    float64(c.dropped),
+		"reconnects": float64(reconnects),
+	}
+	if counter, ok := c.enc.(*network.CounterEncoding); ok {
+		for k, v := range counter.Values() {
+			toSend[k] = v
+		}
+	}
+	return toSend
+}
@@ -0,0 +1,93 @@
+// Package ws implements the handel.Network interface over WebSocket
+// connections, for participants that can only use WebSocket transports such
+// as browsers. A Gateway accepts the connections of the clients and routes
+// their packets, a Client connects to a gateway.
+package ws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+)
+
+// period at which the gateway pings its clients
+var pingPeriod = 10 * time.Second
+
+// time after which a connection without any ping or pong is considered dead
+var pongWait = 3 * pingPeriod
+
+// time allowed to write a message or to complete the handshake
+var writeWait = 5 * time.Second
+
+// bounds of the delay between two reconnection attempts of a client
+var minBackoff = 50 * time.Millisecond
+var maxBackoff = 5 * time.Second
+
+// encodeHandshake returns the first message a client sends after connecting:
+// the id of its identity in the registry.
+func encodeHandshake(id int32) []byte {
+	var buff [4]byte
+	binary.BigEndian.PutUint32(buff[:], uint32(id))
+	return buff[:]
+}
+
+func decodeHandshake(msg []byte) (int32, error) {
+	if len(msg) != 4 {
+		return 0, errors.New("ws: invalid handshake")
+	}
+	return int32(binary.BigEndian.Uint32(msg)), nil
+}
+
+// encodeFrame returns the message a client sends to its gateway: the number
+// of recipients, their ids and the encoded packet.
+func encodeFrame(ids []int32, p *h.Packet, enc network.Encoding) ([]byte, error) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint16(len(ids)))
+	for _, id := range ids {
+		binary.Write(&b, binary.BigEndian, id)
+	}
+	if err := enc.Encode(p, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeFrame returns the recipients and the encoded packet of the frame.
+func decodeFrame(msg []byte) ([]int32, []byte, error) {
+	if len(msg) < 2 {
+		return nil, nil, errors.New("ws: frame too short")
+	}
+	n := int(binary.BigEndian.Uint16(msg))
+	msg = msg[2:]
+	if len(msg) < 4*n {
+		return nil, nil, errors.New("ws: frame too short")
+	}
+	ids := make([]int32, n)
+	for i := range ids {
+		ids[i] = int32(binary.BigEndian.Uint32(msg[4*i:]))
+	}
+	return ids, msg[4*n:], nil
+}
+
+func encodePacket(p *h.Packet, enc network.Encoding) ([]byte, error) {
+	var b bytes.Buffer
+	if err := enc.Encode(p, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodePacket(msg []byte, enc network.Encoding) (*h.Packet, error) {
+	return enc.Decode(bytes.NewReader(msg))
+}
+
+// dispatch gives the packet to all the listeners
+func dispatch(listeners []h.Listener, p *h.Packet) {
+	for _, l := range listeners {
+		l.NewPacket(p)
+	}
+}
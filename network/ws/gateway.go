@@ -0,0 +1,421 @@
+package ws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/gorilla/websocket"
+)
+
+// Gateway is a handel.Network that accepts the WebSocket connections of
+// clients. A client authenticates itself by sending its id in the handshake,
+// which must be present in the registry. The gateway delivers the packets
+// addressed to its own identity to its listeners and forwards the others to
+// the recipients connected to it. Sending to an identity that is not
+// connected drops the packet.
+type Gateway struct {
+	sync.RWMutex
+	own       h.Identity
+	reg       h.Registry
+	enc       network.Encoding
+	ln        net.Listener
+	srv       *http.Server
+	upgrader  websocket.Upgrader
+	conns     map[int32]*peer
+	listeners []h.Listener
+	// mesh gateways dial the gateways of the identities not connected
+	mesh    bool
+	clients map[string]*Client
+	stopped bool
+	wg      sync.WaitGroup
+	// notified on each connection, disconnection and counter change
+	changes notifier
+
+	sent      int
+	rcvd      int
+	forwarded int
+	dropped   int
+	rejected  int
+	invalid   int
+	pongs     int
+}
+
+// peer is the connection of an authenticated client. Its lock serializes the
+// writes on the connection, so a slow client only delays its own packets.
+type peer struct {
+	sync.Mutex
+	conn *websocket.Conn
+	// closed when the connection is unregistered
+	done chan bool
+}
+
+func (p *peer) write(msg []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return p.conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// NewGateway returns a Gateway listening on the port of the address of the
+// given identity.
+func NewGateway(own h.Identity, reg h.Registry, enc network.Encoding) (*Gateway, error) {
+	_, port, err := net.SplitHostPort(own.Address())
+	if err != nil {
+		return nil, err
+	}
+	// bind to 0.0.0.0 as the udp network does
+	ln, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", port))
+	if err != nil {
+		return nil, err
+	}
+	g := &Gateway{
+		own:     own,
+		reg:     reg,
+		enc:     enc,
+		ln:      ln,
+		conns:   make(map[int32]*peer),
+		clients: make(map[string]*Client),
+		upgrader: websocket.Upgrader{
+			// clients are authenticated by the handshake, not by their origin
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+	g.srv = &http.Server{Handler: g}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.srv.Serve(ln)
+	}()
+	return g, nil
+}
+
+// NewNetwork returns a mesh Gateway, used for server-side nodes: when sending
+// to an identity that is not connected to it, the gateway connects as a
+// client to the gateway listening on the address of that identity.
+func NewNetwork(own h.Identity, reg h.Registry, enc network.Encoding) (*Gateway, error) {
+	g, err := NewGateway(own, reg, enc)
+	if err != nil {
+		return nil, err
+	}
+	g.mesh = true
+	return g, nil
+}
+
+// Addr returns the address the gateway listens on
+func (g *Gateway) Addr() string {
+	return g.ln.Addr().String()
+}
+
+// ServeHTTP upgrades the connection, authenticates the client and reads its
+// packets until the connection drops.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	id, err := g.handshake(conn)
+	if err != nil {
+		g.inc(&g.rejected)
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+	p := g.register(id, conn)
+	if p == nil {
+		conn.Close()
+		return
+	}
+	defer g.wg.Done()
+	defer g.unregister(id, p)
+	go g.pingLoop(p)
+	g.readLoop(id, p)
+}
+
+func (g *Gateway) handshake(conn *websocket.Conn) (int32, error) {
+	conn.SetReadDeadline(time.Now().Add(writeWait))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	id, err := decodeHandshake(msg)
+	if err != nil {
+		return 0, err
+	}
+	if id == g.own.ID() {
+		return 0, errors.New("ws: handshake with the gateway's own id")
+	}
+	if _, ok := g.reg.Identity(int(id)); !ok {
+		return 0, errors.New("ws: unknown identity")
+	}
+	return id, nil
+}
+
+// register replaces any previous connection of the client and returns nil if
+// the gateway is stopped.
+func (g *Gateway) register(id int32, conn *websocket.Conn) *peer {
+	g.Lock()
+	defer g.Unlock()
+	if g.stopped {
+		return nil
+	}
+	if old, ok := g.conns[id]; ok {
+		old.conn.Close()
+	}
+	p := &peer{conn: conn, done: make(chan bool)}
+	g.conns[id] = p
+	// the read and the ping loops
+	g.wg.Add(2)
+	g.changes.notify()
+	return p
+}
+
+func (g *Gateway) unregister(id int32, p *peer) {
+	g.Lock()
+	defer g.Unlock()
+	if g.conns[id] == p {
+		delete(g.conns, id)
+	}
+	close(p.done)
+	p.conn.Close()
+	g.changes.notify()
+}
+
+func (g *Gateway) pingLoop(p *peer) {
+	defer g.wg.Done()
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		if err := p.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+			return
+		}
+	}
+}
+
+func (g *Gateway) readLoop(id int32, p *peer) {
+	conn := p.conn
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		g.inc(&g.pongs)
+		return nil
+	})
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		ids, raw, err := decodeFrame(msg)
+		if err != nil {
+			g.inc(&g.invalid)
+			continue
+		}
+		packet, err := decodePacket(raw, g.enc)
+		if err != nil || packet.Origin != id {
+			// a client can only send its own packets
+			g.inc(&g.invalid)
+			continue
+		}
+		for _, to := range ids {
+			if to == g.own.ID() {
+				dispatch(g.getListeners(), packet)
+				continue
+			}
+			g.forward(to, raw)
+		}
+	}
+}
+
+// inc increments one of the counters of the gateway
+func (g *Gateway) inc(counter *int) {
+	g.Lock()
+	defer g.Unlock()
+	*counter++
+	g.changes.notify()
+}
+
+func (g *Gateway) getListeners() []h.Listener {
+	g.Lock()
+	defer g.Unlock()
+	g.rcvd++
+	g.changes.notify()
+	return g.listeners
+}
+
+// peer returns the connection of the given client, or nil if it is not
+// connected or if the gateway is stopped
+func (g *Gateway) peer(id int32) *peer {
+	g.RLock()
+	defer g.RUnlock()
+	if g.stopped {
+		return nil
+	}
+	return g.conns[id]
+}
+
+// forward sends the encoded packet to the given client if connected. The
+// write happens outside of the gateway's lock.
+func (g *Gateway) forward(to int32, raw []byte) {
+	p := g.peer(to)
+	if p == nil || p.write(raw) != nil {
+		g.inc(&g.dropped)
+		return
+	}
+	g.inc(&g.forwarded)
+}
+
+// Send implements the handel.Network interface. Packets to connected clients
+// go over their connection. Packets to other identities are dropped, unless
+// the gateway is a mesh one.
+func (g *Gateway) Send(ids []h.Identity, p *h.Packet) {
+	raw, err := encodePacket(p, g.enc)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		g.send(id, p, raw)
+	}
+}
+
+// send writes the packet outside of the gateway's lock, on the connection
+// of the client or through the mesh client of the identity
+func (g *Gateway) send(id h.Identity, p *h.Packet, raw []byte) {
+	if c := g.peer(id.ID()); c != nil && c.write(raw) == nil {
+		g.inc(&g.sent)
+		return
+	}
+	if !g.mesh || id.Address() == "" {
+		g.inc(&g.dropped)
+		return
+	}
+	client := g.client(id.Address())
+	if client == nil {
+		return
+	}
+	// the client counts the packets dropped while it connects
+	client.Send([]h.Identity{id}, p)
+	g.inc(&g.sent)
+}
+
+// client returns the mesh client connected to the gateway at the given
+// address, creating it if needed, or nil if the gateway is stopped
+func (g *Gateway) client(addr string) *Client {
+	g.Lock()
+	defer g.Unlock()
+	if g.stopped {
+		return nil
+	}
+	client, ok := g.clients[addr]
+	if !ok {
+		client = NewClient(addr, g.own, g.enc)
+		client.RegisterListener(h.ListenFunc(func(p *h.Packet) {
+			dispatch(g.getListeners(), p)
+		}))
+		g.clients[addr] = client
+	}
+	return client
+}
+
+// RegisterListener implements the handel.Network interface
+func (g *Gateway) RegisterListener(l h.Listener) {
+	g.Lock()
+	defer g.Unlock()
+	g.listeners = append(g.listeners, l)
+}
+
+// Connected returns true if the client with the given id has an active
+// connection to the gateway.
+func (g *Gateway) Connected(id int32) bool {
+	g.RLock()
+	defer g.RUnlock()
+	_, ok := g.conns[id]
+	return ok
+}
+
+// Stop closes the listener and all the connections, and waits for the
+// gateway's routines to return.
+func (g *Gateway) Stop() {
+	g.Lock()
+	if g.stopped {
+		g.Unlock()
+		return
+	}
+	g.stopped = true
+	g.srv.Close()
+	for _, p := range g.conns {
+		p.conn.Close()
+	}
+	clients := g.clients
+	g.Unlock()
+	for _, c := range clients {
+		c.Stop()
+	}
+	g.wg.Wait()
+}
+
+// Values implements the monitor.CounterMeasure interface
+func (g *Gateway) Values() map[string]float64 {
+	g.RLock()
+	defer g.RUnlock()
+	toSend := map[string]float64{
+		"sent":      float64(g.sent),
+		"rcvd":      float64(g.rcvd),
+		"forwarded": float64(g.forwarded),
+		"dropped":   float64(g.dropped),
+		"rejected":  float64(g.rejected),
+		"invalid":   float64(g.invalid),
+		"pongs":     float64(g.pongs),
+		"clients":   float64(len(g.conns)),
+	}
+	for _, c := range g.clients {
+		toSend["dropped"] += c.Values()["dropped"]
+	}
+	if counter, ok := g.enc.(*network.CounterEncoding); ok {
+		for k, v := range counter.Values() {
+			toSend[k] = v
+		}
+	}
+	return toSend
+}
+
+// changed returns a channel closed at the next change of the gateway's
+// state
+func (g *Gateway) changed() <-chan struct{} {
+	return g.changes.wait()
+}
+
+// notifier wakes up the routines waiting for a change of state
+type notifier struct {
+	sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed at the next call to notify
+func (n *notifier) wait() <-chan struct{} {
+	n.Lock()
+	defer n.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.Lock()
+	defer n.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"crypto/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/ConsenSys/handel/network"
+	"github.com/stretchr/testify/require"
+)
+
+var msg = []byte("Sun is Shining...")
+
+// registry returns n identities with BLS keys; only the first one, the
+// gateway, has an address.
+func registry(n int) ([]handel.SecretKey, handel.Registry) {
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		sk, pk, err := bn256.NewKeyPair(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		var addr string
+		if i == 0 {
+			addr = "127.0.0.1:0"
+		}
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), addr, pk)
+	}
+	return secrets, handel.NewArrayRegistry(ids)
+}
+
+func identity(reg handel.Registry, i int) handel.Identity {
+	id, ok := reg.Identity(i)
+	if !ok {
+		panic("no identity")
+	}
+	return id
+}
+
+// changer is a gateway or a client, notifying the changes of its state
+type changer interface {
+	changed() <-chan struct{}
+}
+
+// waitFor waits for the condition on the state of the given gateways and
+// clients, checking it again at each of their changes
+func waitFor(t *testing.T, cond func() bool, sources ...changer) {
+	timeout := time.After(5 * time.Second)
+	for {
+		cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout)}}
+		for _, s := range sources {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.changed())})
+		}
+		if cond() {
+			return
+		}
+		if i, _, _ := reflect.Select(cases); i == 0 {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+func TestWSAggregation(t *testing.T) {
+	n := 3
+	secrets, reg := registry(n)
+	g, err := NewGateway(identity(reg, 0), reg, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer g.Stop()
+	nets := []handel.Network{g}
+	for i := 1; i < n; i++ {
+		c := NewClient(g.Addr(), identity(reg, i), network.NewGOBEncoding())
+		defer c.Stop()
+		nets = append(nets, c)
+	}
+	waitFor(t, func() bool { return g.Connected(1) && g.Connected(2) }, g)
+
+	cons := bn256.NewConstructor()
+	config := handel.DefaultConfig(n)
+	config.Contributions = n
+	handels := make([]*handel.Handel, n)
+	for i := range handels {
+		sig, err := secrets[i].Sign(msg, rand.Reader)
+		require.NoError(t, err)
+		handels[i] = handel.NewHandel(nets[i], reg, identity(reg, i), cons, msg, sig, config)
+		defer handels[i].Close()
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+			require.NoError(t, handel.VerifyMultiSignature(msg, &ms, reg, cons))
+		case <-time.After(10 * time.Second):
+			t.Fatalf("node %d did not finish", i)
+		}
+	}
+	values := g.Values()
+	require.True(t, values["forwarded"] > 0)
+	require.Equal(t, 2.0, values["clients"])
+}
+
+func TestWSReconnect(t *testing.T) {
+	defer func(p time.Duration) { minBackoff = p }(minBackoff)
+	minBackoff = 10 * time.Millisecond
+	_, reg := registry(2)
+	g, err := NewGateway(identity(reg, 0), reg, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer g.Stop()
+	c := NewClient(g.Addr(), identity(reg, 1), network.NewGOBEncoding())
+	defer c.Stop()
+	received := make(chan *handel.Packet, 1)
+	c.RegisterListener(handel.ListenFunc(func(p *handel.Packet) {
+		received <- p
+	}))
+	waitFor(t, func() bool { return g.Connected(1) && c.Connected() }, g, c)
+
+	// drop the connection from the gateway side
+	g.Lock()
+	g.conns[1].conn.Close()
+	g.Unlock()
+	waitFor(t, func() bool { return c.Values()["reconnects"] == 1 && g.Connected(1) }, g, c)
+
+	g.Send([]handel.Identity{identity(reg, 1)}, &handel.Packet{Origin: 0, Level: 1})
+	select {
+	case p := <-received:
+		require.Equal(t, byte(1), p.Level)
+	case <-time.After(time.Second):
+		t.Fatal("packet not received after reconnection")
+	}
+
+	// the client stopped, the packets to it are dropped
+	c.Stop()
+	waitFor(t, func() bool { return !g.Connected(1) }, g)
+	g.Send([]handel.Identity{identity(reg, 1)}, &handel.Packet{Origin: 0})
+	require.Equal(t, 1.0, g.Values()["dropped"])
+}
+
+func TestWSKeepalive(t *testing.T) {
+	defer func(p, w time.Duration) { pingPeriod, pongWait = p, w }(pingPeriod, pongWait)
+	pingPeriod = 10 * time.Millisecond
+	pongWait = 50 * time.Millisecond
+	_, reg := registry(2)
+	g, err := NewGateway(identity(reg, 0), reg, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer g.Stop()
+	c := NewClient(g.Addr(), identity(reg, 1), network.NewGOBEncoding())
+	defer c.Stop()
+	waitFor(t, c.Connected, c)
+
+	// idle connections stay alive thanks to the pings: the pongs span
+	// several times the read deadline
+	pongs := float64(5 * pongWait / pingPeriod)
+	waitFor(t, func() bool { return g.Values()["pongs"] >= pongs }, g)
+	require.True(t, g.Connected(1))
+	require.Equal(t, 0.0, c.Values()["reconnects"])
+}
+
+func TestWSHandshake(t *testing.T) {
+	_, reg := registry(2)
+	g, err := NewGateway(identity(reg, 0), reg, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer g.Stop()
+
+	var tests = []struct {
+		id       int32
+		accepted bool
+	}{
+		{1, true},
+		{0, false},
+		{5, false},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		rejected := g.Values()["rejected"]
+		c := NewClient(g.Addr(), handel.NewStaticIdentity(test.id, "", nil), network.NewGOBEncoding())
+		if test.accepted {
+			waitFor(t, func() bool { return g.Connected(test.id) }, g)
+		} else {
+			waitFor(t, func() bool { return g.Values()["rejected"] > rejected }, g)
+			require.False(t, g.Connected(test.id))
+		}
+		c.Stop()
+	}
+
+	// a client can only send its own packets
+	c := NewClient(g.Addr(), identity(reg, 1), network.NewGOBEncoding())
+	defer c.Stop()
+	waitFor(t, c.Connected, c)
+	c.Send([]handel.Identity{identity(reg, 0)}, &handel.Packet{Origin: 0})
+	waitFor(t, func() bool { return g.Values()["invalid"] == 1 }, g)
+}
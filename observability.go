@@ -0,0 +1,88 @@
+package handel
+
+import "errors"
+
+// collector is a structure tracking observations about the aggregation, which
+// can be disabled when the observability budget is exceeded, see
+// Config.ObservabilityBudgetBytes.
+type collector interface {
+	// approxSize returns the approximate size in bytes of the structure
+	approxSize() int
+	// disable stops the collection, and releases what it can
+	disable()
+}
+
+// namedCollector is a collector with the name it is logged and reported with
+type namedCollector struct {
+	name string
+	collector
+}
+
+// observability enforces the budget of the collectors. It is guarded by the
+// lock of Handel.
+type observability struct {
+	// collectors in the order they are disabled when over the budget
+	collectors []namedCollector
+	// number of collectors disabled so far
+	disabled int
+	// approximate size of the collectors at the last check
+	bytes int
+}
+
+// collectors returns the collectors of Handel in the order they are disabled
+// when over the budget: the staleness first, since it tracks every origin,
+// then the execution times of the actors and last the efficiency, which
+// feeds the Result. Disabling the staleness stops Config.DeprioritizeStale.
+func (h *Handel) collectors() []namedCollector {
+	return []namedCollector{
+		{name: "staleness", collector: h.staleness},
+		{name: "actors", collector: h.actorStats},
+		{name: "efficiency", collector: h.efficiency},
+	}
+}
+
+// checkObservability disables the collectors, in their order, as long as
+// their sum is over Config.ObservabilityBudgetBytes. The lock must be held.
+func (h *Handel) checkObservability() {
+	o := h.observability
+	budget := h.c.ObservabilityBudgetBytes
+	if budget <= 0 || o.disabled == len(o.collectors) {
+		return
+	}
+	o.bytes = 0
+	for _, c := range o.collectors[o.disabled:] {
+		o.bytes += c.approxSize()
+	}
+	for o.bytes > budget && o.disabled < len(o.collectors) {
+		c := o.collectors[o.disabled]
+		size := c.approxSize()
+		c.disable()
+		o.disabled++
+		o.bytes -= size
+		h.log.Info("observability_disabled", c.name, "bytes", size, "budget", budget)
+	}
+}
+
+// observabilityValues returns the approximate size of the enabled collectors
+// at the last check, the number of collectors disabled and the number of
+// packets rejected because of their origin, out of the registry, outside of
+// the subtree or not authenticated
+func (h *Handel) observabilityValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	return map[string]float64{
+		"bytes":           float64(h.observability.bytes),
+		"disabled":        float64(h.observability.disabled),
+		"rejected":        float64(h.stats.rejected),
+		"rejectedSubtree": float64(h.stats.outsideSubtree),
+		"unauthenticated": float64(h.stats.unauthenticated),
+	}
+}
+
+// errOriginRange is the error of the packets whose origin is not in the
+// registry. The rejection of such packets must not allocate, since anyone can
+// flood a node with them.
+var errOriginRange = errors.New("packet's origin out of range")
+
+// originRangeLog are the preallocated arguments of the log of errOriginRange
+var originRangeLog = []interface{}{"invalid_packet", errOriginRange}
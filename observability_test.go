@@ -0,0 +1,104 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservabilityFlood(t *testing.T) {
+	n := 16
+	_, handels := FakeSetupWith(n, func(c *Config) { c.EnableNegotiation = true })
+	defer CloseHandels(handels)
+	h := handels[0]
+	spoofed := []int32{-1, int32(n), 1000, 1 << 30}
+	for i := 0; i < 100; i++ {
+		for _, origin := range spoofed {
+			h.NewPacket(aggregatePacket(t, origin, 4, 8, 0))
+			p := aggregatePacket(t, origin, 4, 8, 0)
+			p.Flags = FlagDigest
+			h.NewPacket(p)
+			h.staleness.observe(origin, 0)
+		}
+	}
+	require.Empty(t, h.staleness.origins)
+	require.Empty(t, h.negotiation.answered)
+	require.Equal(t, 800, h.stats.rejected)
+	require.Equal(t, 800.0, h.observabilityValues()["rejected"])
+
+	// the origins of the registry are still tracked
+	h.NewPacket(aggregatePacket(t, 8, 4, 8, 0))
+	require.Len(t, h.staleness.origins, 1)
+}
+
+func TestObservabilityRejectionAllocs(t *testing.T) {
+	h := healthHandel(&Config{LogLevel: LogError})
+	defer h.Stop()
+	p := aggregatePacket(t, 1000, 2, 2, 0)
+	allocs := testing.AllocsPerRun(100, func() { h.NewPacket(p) })
+	require.Zero(t, allocs)
+}
+
+func TestObservabilityBudget(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[0]
+	for i := int32(0); i < int32(n); i++ {
+		h.staleness.observe(i, 1)
+	}
+	h.actorStats.called("checkFinalSignature", time.Millisecond)
+	h.efficiency.stored(&IncomingSig{level: 1, ms: newSig(bitsOf(1, 0))}, h.store)
+	staleness := h.staleness.approxSize()
+	actors := h.actorStats.approxSize()
+	efficiency := h.efficiency.approxSize()
+
+	check := func(budget int) {
+		h.Lock()
+		defer h.Unlock()
+		h.c.ObservabilityBudgetBytes = budget
+		h.checkObservability()
+	}
+	// no budget
+	check(0)
+	require.Equal(t, 0, h.observability.disabled)
+	// within the budget
+	check(staleness + actors + efficiency)
+	require.Equal(t, 0, h.observability.disabled)
+	require.Equal(t, staleness+actors+efficiency, h.observability.bytes)
+
+	// the staleness goes first
+	check(actors + efficiency)
+	require.Equal(t, 1, h.observability.disabled)
+	require.True(t, h.staleness.disabled)
+	require.Empty(t, h.staleness.origins)
+	h.staleness.observe(1, 1)
+	require.Empty(t, h.staleness.origins)
+	require.False(t, h.actorStats.disabled)
+	require.Equal(t, actors+efficiency, h.observability.bytes)
+
+	// then the actors, then the efficiency
+	check(efficiency)
+	require.Equal(t, 2, h.observability.disabled)
+	require.True(t, h.actorStats.disabled)
+	require.False(t, h.efficiency.disabled)
+	check(1)
+	require.Equal(t, 3, h.observability.disabled)
+	require.True(t, h.efficiency.disabled)
+	check(1)
+	require.Equal(t, 3, h.observability.disabled)
+}
+
+// BenchmarkRejectedPacket measures the handling of a packet whose origin is
+// not in the registry, which must not allocate.
+func BenchmarkRejectedPacket(b *testing.B) {
+	h := healthHandel(&Config{LogLevel: LogError})
+	defer h.Stop()
+	ms, _ := newSig(fullBitset(2)).MarshalBinary()
+	p := &Packet{Origin: 1000, Level: 2, MultiSig: ms}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.NewPacket(p)
+	}
+}
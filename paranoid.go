@@ -0,0 +1,122 @@
+// +build paranoid
+
+package handel
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// paranoidMode is true with the "paranoid" build tag
+const paranoidMode = true
+
+// ParanoidDeadline is the time a Network has to read a Packet given to Send,
+// in the paranoid mode enabled by the "paranoid" build tag. In this mode,
+// Handel wraps its Network and checks, once the deadline expires, that
+// nobody modified the Packet since Send. The Packet is then poisoned: a
+// Network reading it afterwards sends a poisoned packet, reported by the
+// paranoid Network of the node receiving it.
+var ParanoidDeadline = time.Second
+
+// paranoidViolation reports a violation of the ownership of the packets,
+// tests replace it to record the violations instead of panicking.
+var paranoidViolation = func(msg string) {
+	panic("handel: paranoid: " + msg)
+}
+
+// poisonOrigin is the origin of the poisoned packets
+const poisonOrigin int32 = -0x5a5a5a5a
+
+// paranoidNetwork checks that the packets sent are immutable after Send and
+// read before ParanoidDeadline.
+type paranoidNetwork struct {
+	Network
+}
+
+func wrapNetwork(n Network) Network {
+	if _, ok := n.(*paranoidNetwork); ok {
+		return n
+	}
+	return &paranoidNetwork{n}
+}
+
+// unwrapNetwork returns the network given to wrapNetwork
+func unwrapNetwork(n Network) Network {
+	if p, ok := n.(*paranoidNetwork); ok {
+		return p.Network
+	}
+	return n
+}
+
+// Send implements the Network interface
+func (p *paranoidNetwork) Send(ids []Identity, packet *Packet) {
+	snapshot := copyPacket(packet)
+	p.Network.Send(ids, packet)
+	time.AfterFunc(ParanoidDeadline, func() {
+		if !equalPackets(snapshot, packet) {
+			paranoidViolation(fmt.Sprintf("packet of level %d modified after Send", snapshot.Level))
+		}
+		poisonPacket(packet)
+	})
+}
+
+// RegisterListener implements the Network interface
+func (p *paranoidNetwork) RegisterListener(l Listener) {
+	p.Network.RegisterListener(ListenFunc(func(packet *Packet) {
+		if packet.Origin == poisonOrigin {
+			paranoidViolation("packet read after the deadline")
+			return
+		}
+		l.NewPacket(packet)
+	}))
+}
+
+func copyPacket(p *Packet) *Packet {
+	return &Packet{
+		Origin:        p.Origin,
+		Level:         p.Level,
+		MultiSig:      append([]byte(nil), p.MultiSig...),
+		IndividualSig: append([]byte(nil), p.IndividualSig...),
+		Flags:         p.Flags,
+		Progress:      p.Progress,
+		PreviousKeys:  append([]byte(nil), p.PreviousKeys...),
+		Aggregation:   p.Aggregation,
+		Signature:     append([]byte(nil), p.Signature...),
+	}
+}
+
+func equalPackets(p1, p2 *Packet) bool {
+	return p1.Origin == p2.Origin && p1.Level == p2.Level && p1.Flags == p2.Flags &&
+		p1.Progress == p2.Progress && p1.Aggregation == p2.Aggregation &&
+		bytes.Equal(p1.MultiSig, p2.MultiSig) &&
+		bytes.Equal(p1.IndividualSig, p2.IndividualSig) &&
+		bytes.Equal(p1.PreviousKeys, p2.PreviousKeys) &&
+		bytes.Equal(p1.Signature, p2.Signature)
+}
+
+// poisonPacket overwrites the fields of the packet. The bytes referenced are
+// left untouched, as the receivers of an in-memory network may hold them.
+func poisonPacket(p *Packet) {
+	p.Origin = poisonOrigin
+	p.Level = 0xff
+	p.MultiSig = []byte("poisoned")
+	p.IndividualSig = nil
+}
+
+// poisonedBitSet and poisonedSignature replace the bitset and the signature
+// of the signatures returned to the pool: any use panics
+type poisonedBitSet struct{ BitSet }
+type poisonedSignature struct{ Signature }
+
+// releaseSig poisons the signature returned to the pool instead of recycling
+// it, so that a use after the return panics. Its bitset is not recycled
+// either.
+func releaseSig(p *sigPool, s *pooledSig) {
+	if s.sig.origin == poisonOrigin {
+		paranoidViolation("signature returned twice to the pool")
+		return
+	}
+	s.ms = MultiSignature{BitSet: poisonedBitSet{}, Signature: poisonedSignature{}}
+	s.sig = IncomingSig{origin: poisonOrigin, level: 0xff, ms: &s.ms, shell: s}
+}
@@ -0,0 +1,23 @@
+// +build !paranoid
+
+package handel
+
+// paranoidMode is true with the "paranoid" build tag
+const paranoidMode = false
+
+// wrapNetwork returns the network as is, see paranoid.go for the checks
+// enabled by the "paranoid" build tag
+func wrapNetwork(n Network) Network {
+	return n
+}
+
+// unwrapNetwork returns the network given to wrapNetwork
+func unwrapNetwork(n Network) Network {
+	return n
+}
+
+// releaseSig recycles the signature returned to the pool, see paranoid.go
+// for the poisoning enabled by the "paranoid" build tag
+func releaseSig(p *sigPool, s *pooledSig) {
+	p.recycle(s)
+}
@@ -0,0 +1,112 @@
+// +build paranoid
+
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordViolations makes the paranoid mode record the violations instead of
+// panicking, with the given deadline. The returned channel signals each
+// violation, and the returned function restores the defaults and returns the
+// violations.
+func recordViolations(deadline time.Duration) (<-chan bool, func() []string) {
+	var mu sync.Mutex
+	var violations []string
+	violated := make(chan bool, 10)
+	oldDeadline, oldViolation := ParanoidDeadline, paranoidViolation
+	ParanoidDeadline = deadline
+	paranoidViolation = func(msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		violations = append(violations, msg)
+		select {
+		case violated <- true:
+		default:
+		}
+	}
+	return violated, func() []string {
+		ParanoidDeadline, paranoidViolation = oldDeadline, oldViolation
+		mu.Lock()
+		defer mu.Unlock()
+		return violations
+	}
+}
+
+func TestParanoidExchange(t *testing.T) {
+	deadline := 100 * time.Millisecond
+	var tests = []func() []*Handel{
+		func() []*Handel { _, handels := FakeSetup(32); return handels },
+		func() []*Handel { return transportSetup(32, func(int) bool { return false }) },
+		func() []*Handel { return transportSetup(32, func(i int) bool { return i%2 == 0 }) },
+	}
+	for i, setup := range tests {
+		t.Logf(" -- test %d -- ", i)
+		violated, violations := recordViolations(deadline)
+		waitFullSignatures(t, setup())
+		// the checks of the last packets sent
+		select {
+		case <-violated:
+		case <-time.After(2 * deadline):
+		}
+		require.Empty(t, violations())
+	}
+}
+
+func TestParanoidViolations(t *testing.T) {
+	deadline := 20 * time.Millisecond
+	// the faulty senders send the packets after a delay, or modify them
+	// after Send
+	var tests = []struct {
+		send   func(net Network, ids []Identity, p *Packet)
+		mutate bool
+		exp    string
+	}{
+		{func(net Network, ids []Identity, p *Packet) {
+			time.AfterFunc(5*deadline, func() { net.Send(ids, p) })
+		}, false, "packet read after the deadline"},
+		{func(net Network, ids []Identity, p *Packet) {
+			net.Send(ids, p)
+			p.Level++
+		}, true, "packet of level 1 modified after Send"},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		violated, violations := recordViolations(deadline)
+		nets := NewTestNetworks(2)
+		send := test.send
+		net := &recordNetwork{send: func(ids []Identity, p *Packet) { send(nets[0], ids, p) }}
+		received := make(chan bool, 1)
+		wrapNetwork(nets[1]).RegisterListener(ListenFunc(func(*Packet) { received <- true }))
+		wrapNetwork(net).Send([]Identity{NewStaticIdentity(1, "", nil)}, &Packet{Origin: 0, Level: 1})
+		select {
+		case <-violated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no violation")
+		}
+		require.Equal(t, []string{test.exp}, violations())
+		if !test.mutate {
+			// the poisoned packet never reaches the listener
+			require.Len(t, received, 0)
+		}
+	}
+}
+
+func TestParanoidSigPool(t *testing.T) {
+	_, violations := recordViolations(ParanoidDeadline)
+	pool := newSigPool(DefaultBitSet)
+	sp := pool.rent(8)
+	bs := sp.ms.BitSet
+	pool.put(sp)
+	// the signature returned is poisoned, not recycled
+	require.Panics(t, func() { sp.ms.BitSet.Cardinality() })
+	require.Panics(t, func() { sp.ms.Signature.MarshalBinary() })
+	rented := pool.rent(8)
+	require.True(t, rented != sp && rented.ms.BitSet != bs)
+	pool.put(sp)
+	require.Equal(t, []string{"signature returned twice to the pool"}, violations())
+}
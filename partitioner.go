@@ -1,9 +1,13 @@
 package handel
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"math"
+
+	"github.com/ConsenSys/handel/bits"
 )
 
 // Partitioner is a generic interface holding the logic used to partition the
@@ -33,20 +37,29 @@ type Partitioner interface {
 	// ID. The returned index is usable inside a bitset for the same level.
 	IndexAtLevel(globalID int32, level int) (int, error)
 
+	// LevelOf returns the level through which the contribution of the given
+	// global index reaches this node, and its position in the bitsets of
+	// this level. The node's own index is at the level 0, position 0.
+	LevelOf(globalIndex int) (level int, pos int, err error)
+
+	// GlobalOf is the inverse of LevelOf: it returns the global index of
+	// the given position in the bitsets of the level.
+	GlobalOf(level, pos int) (int, error)
+
 	// Combine takes a list of signature paired with their level and returns all
 	// signatures correctly combined according to the partition strategy.  The
 	// resulting signatures has the size denoted by the given level,i.e.
 	// Size(level). All signatures must be valid signatures and have their size
 	// be inferior or equal to the size denoted by the level. The return value
-	// can be nil if no incomingSig have been given.It returns a MultiSignature
+	// can be nil if no IncomingSig have been given.It returns a MultiSignature
 	// whose's BitSet's size is equal to the size of the level given in
 	// parameter + 1. The +1 is there because it is a combined signature,
 	// therefore, encompassing all signatures of levels up to the given level
 	// included.
-	Combine(sigs []*incomingSig, level int, nbs func(int) BitSet) *MultiSignature
+	Combine(sigs []*IncomingSig, level int, nbs func(int) BitSet) *MultiSignature
 	// CombineFull is similar to Combine but it returns the full multisignature
 	// whose bitset's length is equal to the size of the registry.
-	CombineFull(sigs []*incomingSig, nbs func(int) BitSet) *MultiSignature
+	CombineFull(sigs []*IncomingSig, nbs func(int) BitSet) *MultiSignature
 }
 
 // binomialPartitioner is a partitioner implementation using the common prefix
@@ -57,22 +70,26 @@ type binomialPartitioner struct {
 	size    int
 	reg     Registry
 	logger  Logger
+	// errors of the combinations and of the indexes requested
+	errors errorCounter
 }
 
 // NewBinPartitioner returns a binTreePartition using the given ID as its
-// anchor point in the ID list, and the given registry.
+// anchor point in the ID list, and the given registry. The errors are logged
+// with the given logger, which Handel gives with the id of the node, or
+// discarded if it is nil. Their number is reported by Values.
 func NewBinPartitioner(id int32, reg Registry, logger Logger) Partitioner {
 	return &binomialPartitioner{
 		size:    reg.Size(),
 		reg:     reg,
 		id:      int(id),
-		bitsize: log2(reg.Size()),
-		logger:  logger,
+		bitsize: bits.Log2Ceil(reg.Size()),
+		logger:  componentLogger(logger, "partitioner"),
 	}
 }
 
 func (c *binomialPartitioner) MaxLevel() int {
-	return log2(c.reg.Size())
+	return bits.LevelsForSize(c.size)
 }
 
 // IdentitiesAt returns the set of identities that corresponds to the given
@@ -105,17 +122,48 @@ func (c *binomialPartitioner) Levels() []int {
 }
 
 func (c *binomialPartitioner) IndexAtLevel(globalID int32, level int) (int, error) {
-	min, max, err := c.rangeLevel(level)
+	lvl, pos, err := c.LevelOf(int(globalID))
+	if err == nil && lvl != level {
+		err = fmt.Errorf("globalID outside level's range. id=%d, level=%d, id's level=%d", globalID, level, lvl)
+	}
 	if err != nil {
+		// If it happens it's either a bug either an attack from a byzantine node
+		c.logger.Warn("index_at_level", err)
+		c.errors.inc()
 		return 0, err
 	}
-	id := int(globalID)
-	if id < min || id >= max {
-		err := fmt.Errorf("globalID outside level's range. id=%d, min=%d, max=%d, level=%d", id, min, max, level)
-		c.logger.Warn(err) // If it happens it's either a bug either an attack from a byzantine node
-		return 0, err
+	return pos, nil
+}
+
+// LevelOf implements the Partitioner interface. The level of another index
+// is one more than the highest bit where it differs from our id, and the
+// level's range starts at this index with the lower bits cleared.
+func (c *binomialPartitioner) LevelOf(globalIndex int) (int, int, error) {
+	if globalIndex < 0 || globalIndex >= c.size {
+		return 0, 0, fmt.Errorf("handel: index %d out of the registry of %d", globalIndex, c.size)
+	}
+	if globalIndex == c.id {
+		return 0, 0, nil
 	}
-	return id - min, nil
+	highest := bits.Log2Floor(globalIndex ^ c.id)
+	return highest + 1, globalIndex & (bits.Pow2(highest) - 1), nil
+}
+
+// GlobalOf implements the Partitioner interface
+func (c *binomialPartitioner) GlobalOf(level, pos int) (int, error) {
+	if level == 0 && pos == 0 {
+		return c.id, nil
+	}
+	if level < 1 || level > c.bitsize {
+		return 0, fmt.Errorf("handel: invalid level %d", level)
+	}
+	size := bits.Pow2(level - 1)
+	// our id with the bit of the level flipped and the lower bits cleared
+	min := (c.id ^ size) &^ (size - 1)
+	if pos < 0 || pos >= size || min+pos >= c.size {
+		return 0, fmt.Errorf("handel: position %d out of level %d", pos, level)
+	}
+	return min + pos, nil
 }
 
 // errEmptyLevel is returned when a range for a requested level is empty. This
@@ -135,13 +183,13 @@ func (c *binomialPartitioner) rangeLevel(level int) (min int, max int, err error
 		return 0, 0, errors.New("handel: invalid level for computing candidate set")
 	}
 
-	max = pow2(log2(c.size))
+	max = bits.Pow2(c.bitsize)
 	var inverseIdx = level - 1
 	// Use a binary-search like algo over the bitstring of the id from highest
 	// bit to lower bits as long as we are above the requested common prefix
 	// length to pinpoint the requested range.
 	for idx := c.bitsize - 1; idx >= inverseIdx && idx >= 0 && min < max; idx-- {
-		middle := int(math.Floor(float64(max+min) / 2))
+		middle := (max + min) / 2
 		//fmt.Printf("id %d, idx %d, inverseIdx %d, bitsize %d, min %d, middle %d, max %d\n", c.id, idx, inverseIdx, c.bitsize, min, middle, max)
 
 		if isSet(uint(c.id), uint(idx)) {
@@ -187,13 +235,13 @@ func (c *binomialPartitioner) rangeLevelInverse(level int) (min int, max int, er
 		return 0, 0, errors.New("handel: invalid level for computing candidate set")
 	}
 
-	max = pow2(log2(c.size))
+	max = bits.Pow2(c.bitsize)
 	var maxIdx = level - 1
 	// Use a binary-search like algo over the bitstring of the id from highest
 	// bit to lower bits as long as we are above the requested common prefix
 	// length to pinpoint the requested range.
 	for idx := c.bitsize - 1; idx >= maxIdx && idx >= 0 && min < max; idx-- {
-		middle := int(math.Floor(float64(max+min) / 2))
+		middle := (max + min) / 2
 		//fmt.Printf("id %d, idx %d, inverseIdx %d, bitsize %d, min %d, middle %d, max %d\n", c.id, idx, maxIdx, c.bitsize, min, middle, max)
 
 		if isSet(uint(c.id), uint(idx)) {
@@ -221,14 +269,15 @@ func (c *binomialPartitioner) Size(level int) int {
 	return max - min
 }
 
-func (c *binomialPartitioner) Combine(sigs []*incomingSig, level int, nbs func(int) BitSet) *MultiSignature {
+func (c *binomialPartitioner) Combine(sigs []*IncomingSig, level int, nbs func(int) BitSet) *MultiSignature {
 	if len(sigs) == 0 {
 		return nil
 	}
 
 	for _, s := range sigs {
 		if int(s.level) > level {
-			logf("invalid combination of signature / requested level")
+			c.logger.Warn("invalid_combination", "sig_level", s.level, "level", level)
+			c.errors.inc()
 			return nil
 		}
 	}
@@ -241,12 +290,13 @@ func (c *binomialPartitioner) Combine(sigs []*incomingSig, level int, nbs func(i
 	// to receive.
 	globalMin, globalMax, err := c.rangeLevelInverse(level)
 	if err != nil {
-		logf(err.Error())
+		c.logger.Warn("combine", err, "level", level)
+		c.errors.inc()
 		return nil
 	}
 	size := globalMax - globalMin
 	bitset := nbs(size)
-	combined := func(s *incomingSig, final BitSet) {
+	combined := func(s *IncomingSig, final BitSet) {
 		// compute the offset of this signature compared to the global bitset
 		// index
 		min, _, _ := c.rangeLevel(int(s.level))
@@ -260,14 +310,14 @@ func (c *binomialPartitioner) Combine(sigs []*incomingSig, level int, nbs func(i
 	return c.combineSize(sigs, bitset, combined)
 }
 
-func (c *binomialPartitioner) CombineFull(sigs []*incomingSig, nbs func(int) BitSet) *MultiSignature {
+func (c *binomialPartitioner) CombineFull(sigs []*IncomingSig, nbs func(int) BitSet) *MultiSignature {
 	if len(sigs) == 0 {
 		return nil
 	}
 	var finalBitSet = nbs(c.reg.Size())
 
 	// set the bits corresponding to the level to the final bitset
-	var combineBitSet = func(s *incomingSig, final BitSet) {
+	var combineBitSet = func(s *IncomingSig, final BitSet) {
 		min, _, _ := c.rangeLevel(int(s.level))
 		bs := s.ms.BitSet
 		for i := 0; i < bs.BitLength(); i++ {
@@ -277,9 +327,14 @@ func (c *binomialPartitioner) CombineFull(sigs []*incomingSig, nbs func(int) Bit
 	return c.combineSize(sigs, finalBitSet, combineBitSet)
 }
 
+// Values implements the Reporter interface: the number of errors logged
+func (c *binomialPartitioner) Values() map[string]float64 {
+	return c.errors.Values()
+}
+
 // combineSize combines all given signature with he combine function on the
 // bitset using `bs`.
-func (c *binomialPartitioner) combineSize(sigs []*incomingSig, bs BitSet, combine func(*incomingSig, BitSet)) *MultiSignature {
+func (c *binomialPartitioner) combineSize(sigs []*IncomingSig, bs BitSet, combine func(*IncomingSig, BitSet)) *MultiSignature {
 
 	var finalSig = sigs[0].ms.Signature
 	combine(sigs[0], bs)
@@ -294,3 +349,79 @@ func (c *binomialPartitioner) combineSize(sigs []*incomingSig, bs BitSet, combin
 		Signature: finalSig,
 	}
 }
+
+// ContactOrderer is implemented by partitioners that decide themselves in
+// which order the nodes of a level are contacted. When the partitioner given to
+// Handel implements it, Handel contacts the nodes in this order instead of
+// shuffling them with Config.Rand.
+type ContactOrderer interface {
+	// ContactOrder returns the identities of the given level in the order they
+	// must be contacted. It contains the same identities as IdentitiesAt.
+	ContactOrder(level int) ([]Identity, error)
+}
+
+// randomBinPartitioner is a binomial partitioner whose contact order at each
+// level is a permutation derived from a seed, optionally mixed with a salt.
+// The bitset indexes are the same as the binomial partitioner, only the order
+// of contact changes.
+type randomBinPartitioner struct {
+	*binomialPartitioner
+	seed []byte
+	salt []byte
+}
+
+// NewRandomBinPartitioner returns a binomial partitioner whose contact order
+// at each level is a permutation derived from the given seed. All nodes using
+// the same seed generate the same permutation for the same level.
+func NewRandomBinPartitioner(id int32, reg Registry, logger Logger, seed []byte) Partitioner {
+	return NewRandomBinPartitionerSalted(id, reg, logger, seed, nil)
+}
+
+// NewRandomBinPartitionerSalted is similar to NewRandomBinPartitioner but the
+// permutation is derived from the seed mixed with the given salt, typically
+// the node's own ID. Nodes sharing the same seed still generate different
+// contact orders, so they don't all contact the same first peers, while the
+// order remains unpredictable to anyone not knowing the seed.
+func NewRandomBinPartitionerSalted(id int32, reg Registry, logger Logger, seed, salt []byte) Partitioner {
+	return &randomBinPartitioner{
+		binomialPartitioner: NewBinPartitioner(id, reg, logger).(*binomialPartitioner),
+		seed:                seed,
+		salt:                salt,
+	}
+}
+
+// ContactOrder implements the ContactOrderer interface.
+func (r *randomBinPartitioner) ContactOrder(level int) ([]Identity, error) {
+	ids, err := r.IdentitiesAt(level)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(r.seed)
+	h.Write(r.salt)
+	h.Write([]byte{byte(level)})
+	order := make([]Identity, len(ids))
+	copy(order, ids)
+	shuffle(order, bytes.NewReader(h.Sum(nil)))
+	return order, nil
+}
+
+// Partitioner modes that can be used in Config.PartitionerMode
+const (
+	// PartitionerDeterministic uses the binomial partitioner and contacts the
+	// nodes in the registry order.
+	PartitionerDeterministic = "deterministic"
+	// PartitionerSharedSeed uses the random binomial partitioner seeded by
+	// Config.PartitionerSeed: all nodes generate the same permutations.
+	PartitionerSharedSeed = "shared-seed"
+	// PartitionerSalted uses the random binomial partitioner seeded by
+	// Config.PartitionerSeed mixed with the node's own ID.
+	PartitionerSalted = "salted"
+)
+
+// saltFromID returns the salt used by the salted partitioner mode
+func saltFromID(id int32) []byte {
+	var salt [4]byte
+	binary.BigEndian.PutUint32(salt[:], uint32(id))
+	return salt[:]
+}
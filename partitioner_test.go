@@ -3,6 +3,7 @@ package handel
 import (
 	"testing"
 
+	"github.com/ConsenSys/handel/bits"
 	"github.com/stretchr/testify/require"
 )
 
@@ -83,7 +84,7 @@ func TestPartitionerBinTreeCombine(t *testing.T) {
 
 	type combineTest struct {
 		id    int32
-		sigs  []*incomingSig
+		sigs  []*IncomingSig
 		level int
 		isErr bool
 		exp   *MultiSignature
@@ -94,7 +95,7 @@ func TestPartitionerBinTreeCombine(t *testing.T) {
 		bs3.Set(i, true)
 	}
 	sig2 := &fakeSig{true}
-	bs2 := NewWilffBitset(pow2(3 - 1))
+	bs2 := NewWilffBitset(bits.Pow2(3 - 1))
 	// only the level-2 bits are set
 	for i := 2; i < 4; i++ {
 		bs2.Set(i, true)
@@ -157,13 +158,13 @@ func TestPartitionerBinTreeCombineFull(t *testing.T) {
 
 	sig3 := &fakeSig{true}
 	bs3 := NewWilffBitset(n)
-	for i := 0; i < pow2(3); i++ {
+	for i := 0; i < bits.Pow2(3); i++ {
 		bs3.Set(i, true)
 	}
 	sig2 := &fakeSig{true}
 	bs2 := NewWilffBitset(n)
 	// only the second sig is there so no 0,1
-	for i := 2; i < pow2(2); i++ {
+	for i := 2; i < bits.Pow2(2); i++ {
 		bs2.Set(i, true)
 	}
 
@@ -171,7 +172,7 @@ func TestPartitionerBinTreeCombineFull(t *testing.T) {
 	// 4-1 -> because that's how you compute the size of a level
 	// -1 -> to just spread out holes to other levels and leave this one still
 	// having one contribution
-	for i := 0; i < pow2(4-1)-1; i++ {
+	for i := 0; i < bits.Pow2(4-1)-1; i++ {
 		pairs3[4].ms.BitSet.Set(i, false)
 	}
 	pairs3[3].ms.BitSet.Set(1, false)
@@ -201,7 +202,7 @@ func TestPartitionerBinTreeCombineFull(t *testing.T) {
 
 	type combineTest struct {
 		id    int32
-		sigs  []*incomingSig
+		sigs  []*IncomingSig
 		isErr bool
 		exp   *MultiSignature
 	}
@@ -393,6 +394,107 @@ func TestPartitionerBinTreeRangeAtInverse(t *testing.T) {
 	}
 }
 
+// TestPartitionerLevelOf checks, from the point of view of every node of
+// registries of 2 to 1025 nodes, that LevelOf and GlobalOf are inverses and
+// agree with the identities returned by IdentitiesAt.
+func TestPartitionerErrors(t *testing.T) {
+	logger := newCaptureLogger()
+	part := NewBinPartitioner(1, FakeRegistry(16), logger.With("id", 1))
+
+	// a signature above the level requested
+	sigs := []*IncomingSig{{level: 3, ms: newSig(bitsOf(4, 0))}}
+	require.Nil(t, part.Combine(sigs, 2, NewWilffBitset))
+	// a level out of range
+	require.Nil(t, part.Combine(sigs, 10, NewWilffBitset))
+	// an id outside the level
+	_, err := part.IndexAtLevel(0, 2)
+	require.Error(t, err)
+
+	warns := logger.warnings()
+	require.Len(t, warns, 3)
+	require.Equal(t, []interface{}{"id", 1, "component", "partitioner", "invalid_combination", "sig_level", byte(3), "level", 2}, warns[0])
+	require.Equal(t, []interface{}{"id", 1, "component", "partitioner", "combine"}, warns[1][:5])
+	require.Equal(t, []interface{}{"level", 10}, warns[1][6:])
+	require.Equal(t, []interface{}{"id", 1, "component", "partitioner", "index_at_level"}, warns[2][:5])
+	require.Equal(t, map[string]float64{"errors": 3}, asReporter(part).Values())
+
+	// the errors are counted without a logger
+	part = NewBinPartitioner(1, FakeRegistry(16), nil)
+	require.Nil(t, part.Combine(sigs, 2, NewWilffBitset))
+	require.Equal(t, map[string]float64{"errors": 1}, asReporter(part).Values())
+}
+
+func TestPartitionerLevelOf(t *testing.T) {
+	max := 1025
+	if testing.Short() {
+		max = 130
+	}
+	for n := 2; n <= max; n++ {
+		reg := FakeRegistry(n)
+		for id := 0; id < n; id++ {
+			var part Partitioner = NewBinPartitioner(int32(id), reg, DefaultLogger)
+			if id%64 == 0 {
+				part = NewRandomBinPartitioner(int32(id), reg, DefaultLogger, nil)
+			}
+			checkLevelOf(t, part, n, id)
+		}
+	}
+}
+
+func checkLevelOf(t *testing.T, part Partitioner, n, id int) {
+	seen := 0
+	for _, lvl := range append([]int{0}, part.Levels()...) {
+		ids, err := part.IdentitiesAt(lvl)
+		if err != nil {
+			t.Fatalf("n=%d id=%d: level %d: %v", n, id, lvl, err)
+		}
+		for pos, identity := range ids {
+			global, err := part.GlobalOf(lvl, pos)
+			if err != nil || global != int(identity.ID()) {
+				t.Fatalf("n=%d id=%d: GlobalOf(%d,%d)=%d,%v, expected %d", n, id, lvl, pos, global, err, identity.ID())
+			}
+			l, p, err := part.LevelOf(global)
+			if err != nil || l != lvl || p != pos {
+				t.Fatalf("n=%d id=%d: LevelOf(%d)=%d,%d,%v, expected %d,%d", n, id, global, l, p, err, lvl, pos)
+			}
+			seen++
+		}
+		if _, err := part.GlobalOf(lvl, len(ids)); err == nil {
+			t.Fatalf("n=%d id=%d: no error past the level %d", n, id, lvl)
+		}
+	}
+	if seen != n {
+		t.Fatalf("n=%d id=%d: %d identities in the levels", n, id, seen)
+	}
+	if _, _, err := part.LevelOf(n); err == nil {
+		t.Fatalf("n=%d id=%d: no error out of the registry", n, id)
+	}
+}
+
+// TestPartitionerAggregatesExcludeReceiver checks that the aggregate a node
+// sends at a level, over its inverse range, never covers the nodes receiving
+// it: a node can't tell from the aggregates it receives whether its own
+// contribution is relayed, those covering it are only sent to the others.
+func TestPartitionerAggregatesExcludeReceiver(t *testing.T) {
+	for i, n := range []int{2, 16, 17, 33} {
+		t.Logf(" -- test %d -- ", i)
+		reg := FakeRegistry(n)
+		for me := 0; me < n; me++ {
+			part := NewBinPartitioner(int32(me), reg, DefaultLogger)
+			for _, level := range part.Levels() {
+				senders, err := part.IdentitiesAt(level)
+				require.NoError(t, err)
+				for _, sender := range senders {
+					sp := NewBinPartitioner(sender.ID(), reg, DefaultLogger).(*binomialPartitioner)
+					min, max, err := sp.rangeLevelInverse(level)
+					require.NoError(t, err)
+					require.True(t, me < min || me >= max, "n=%d me=%d sender=%d level=%d", n, me, sender.ID(), level)
+				}
+			}
+		}
+	}
+}
+
 func TestIsSet(t *testing.T) {
 	type setTest struct {
 		nb       uint
@@ -413,3 +515,180 @@ func TestIsSet(t *testing.T) {
 		require.Equal(t, test.expected, res, "%d - failed: %v", i, test)
 	}
 }
+
+func TestPartitionerRandomBinContactOrder(t *testing.T) {
+	n := 64
+	reg := FakeRegistry(n)
+	seed := []byte("shared seed")
+	shared1 := NewRandomBinPartitioner(1, reg, DefaultLogger, seed).(ContactOrderer)
+	salted1 := NewRandomBinPartitionerSalted(1, reg, DefaultLogger, seed, saltFromID(1)).(ContactOrderer)
+	salted1b := NewRandomBinPartitionerSalted(1, reg, DefaultLogger, seed, saltFromID(1)).(ContactOrderer)
+	bin := NewBinPartitioner(1, reg, DefaultLogger)
+
+	for _, lvl := range bin.Levels() {
+		ids, err := bin.IdentitiesAt(lvl)
+		require.NoError(t, err)
+		for _, p := range []ContactOrderer{shared1, salted1} {
+			order, err := p.ContactOrder(lvl)
+			require.NoError(t, err)
+			require.ElementsMatch(t, ids, order)
+		}
+		// same seed and salt give the same order
+		o1, _ := salted1.ContactOrder(lvl)
+		o2, _ := salted1b.ContactOrder(lvl)
+		require.Equal(t, o1, o2)
+	}
+	_, err := shared1.ContactOrder(20)
+	require.Error(t, err)
+}
+
+// firstPickInDegree returns, for each level, the maximum number of nodes that
+// contact the same peer first at this level.
+func firstPickInDegree(n int, newPart func(id int32, reg Registry) Partitioner) map[int]int {
+	reg := FakeRegistry(n)
+	counts := make(map[int]map[int32]int)
+	for id := 0; id < n; id++ {
+		p := newPart(int32(id), reg)
+		for _, lvl := range p.Levels() {
+			order, err := p.(ContactOrderer).ContactOrder(lvl)
+			if err != nil {
+				panic(err)
+			}
+			if counts[lvl] == nil {
+				counts[lvl] = make(map[int32]int)
+			}
+			counts[lvl][order[0].ID()]++
+		}
+	}
+	max := make(map[int]int)
+	for lvl, c := range counts {
+		for _, v := range c {
+			if v > max[lvl] {
+				max[lvl] = v
+			}
+		}
+	}
+	return max
+}
+
+func TestPartitionerRandomBinFirstPickDistribution(t *testing.T) {
+	n := 1024
+	seed := []byte("shared seed")
+	shared := firstPickInDegree(n, func(id int32, reg Registry) Partitioner {
+		return NewRandomBinPartitioner(id, reg, DefaultLogger, seed)
+	})
+	salted := firstPickInDegree(n, func(id int32, reg Registry) Partitioner {
+		return NewRandomBinPartitionerSalted(id, reg, DefaultLogger, seed, saltFromID(id))
+	})
+	// at the last level, half the nodes contact the other half: with a shared
+	// seed, they all pick the same peer first.
+	last := bits.LevelsForSize(n)
+	require.Equal(t, n/2, shared[last])
+	for lvl := 4; lvl <= last; lvl++ {
+		t.Logf(" -- level %d: shared %d, salted %d -- ", lvl, shared[lvl], salted[lvl])
+		require.True(t, salted[lvl] <= shared[lvl])
+	}
+	// with 512 nodes picking among 512 peers, the max in-degree is expected
+	// to be in the order of log(n)/log(log(n))
+	require.True(t, salted[last] < shared[last]/10, "salted max in-degree %d", salted[last])
+}
+
+func TestPartitionerModes(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	seed := []byte("shared seed")
+	bin := NewBinPartitioner(1, reg, DefaultLogger)
+
+	var tests = []struct {
+		mode    string
+		ordered bool
+	}{
+		{PartitionerDeterministic, false},
+		{PartitionerSharedSeed, true},
+		{PartitionerSalted, true},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		c := mergeWithDefault(&Config{PartitionerMode: test.mode, PartitionerSeed: seed}, n)
+		part := c.NewPartitioner(1, reg, DefaultLogger)
+		_, ordered := part.(ContactOrderer)
+		require.Equal(t, test.ordered, ordered)
+		levels := createLevels(c, part)
+		for lvl, l := range levels {
+			ids, _ := bin.IdentitiesAt(lvl)
+			if test.mode == PartitionerDeterministic {
+				require.Equal(t, ids, l.nodes)
+			} else {
+				order, _ := part.(ContactOrderer).ContactOrder(lvl)
+				require.Equal(t, order, l.nodes)
+			}
+			// every peer is still picked exactly once
+			picked := make(map[int32]bool)
+			for len(picked) < len(ids) {
+				ids, _ := l.selectNextPeers(1)
+				for _, id := range ids {
+					require.False(t, picked[id.ID()])
+					picked[id.ID()] = true
+				}
+			}
+		}
+	}
+	require.Panics(t, func() { mergeWithDefault(&Config{PartitionerMode: "unknown"}, n) })
+}
+
+// TestPartitionerLevelsForSize checks for every registry size up to 5000 that
+// the levels of the partitioner match bits.LevelsForSize and cover the whole
+// registry, the last level included.
+func TestPartitionerLevelsForSize(t *testing.T) {
+	all, _ := FakeRegistry(5000).Identities(0, 5000)
+	for n := 1; n <= 5000; n++ {
+		reg := NewArrayRegistry(all[:n])
+		for _, id := range []int{0, n / 2, n - 1} {
+			part := NewBinPartitioner(int32(id), reg, DefaultLogger)
+			max := part.MaxLevel()
+			require.Equal(t, bits.LevelsForSize(n), max, "n=%d", n)
+			levels := part.Levels()
+			if n == 1 {
+				require.Empty(t, levels)
+				continue
+			}
+			// the top level is never empty
+			require.Equal(t, max, levels[len(levels)-1], "n=%d id=%d", n, id)
+			covered := 0
+			for _, lvl := range levels {
+				require.True(t, lvl >= 1 && lvl <= max, "n=%d id=%d", n, id)
+				covered += part.Size(lvl)
+			}
+			require.Equal(t, n-1, covered, "n=%d id=%d", n, id)
+			ids, err := part.IdentitiesAt(max)
+			require.NoError(t, err)
+			for _, sender := range ids {
+				_, err := part.IndexAtLevel(sender.ID(), max)
+				require.NoError(t, err, "n=%d id=%d sender=%d", n, id, sender.ID())
+			}
+		}
+	}
+}
+
+// TestHandelTopLevelPackets checks the packets sent on the top level by every
+// valid sender pass the validation, including for exact powers of two.
+func TestHandelTopLevelPackets(t *testing.T) {
+	for i, n := range []int{2, 3, 4, 5, 1023, 1024, 1025} {
+		t.Logf(" -- test %d -- ", i)
+		reg := FakeRegistry(n)
+		for _, id := range []int{0, n - 1} {
+			me, _ := reg.Identity(id)
+			config := DefaultConfig(n)
+			config.Logger = &warnLogger{}
+			h := NewHandel(NewTestNetworks(n)[id], reg, me, new(fakeCons), msg, &fakeSig{true}, config)
+			top := bits.LevelsForSize(n)
+			senders, err := h.Partitioner.IdentitiesAt(top)
+			require.NoError(t, err)
+			for _, sender := range senders {
+				p := &Packet{Origin: sender.ID(), Level: byte(top)}
+				require.NoError(t, h.validatePacket(p), "n=%d id=%d sender=%d", n, id, sender.ID())
+			}
+			require.Error(t, h.validatePacket(&Packet{Origin: 0, Level: byte(top + 1)}))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package perf measures the performance of the cryptographic operations of a
+// handel.Constructor. It is meant to catch regressions of the constructors,
+// e.g. a change making Combine allocate much more, and to record the crypto
+// baseline of the binary running a simulation.
+package perf
+
+import (
+	"crypto/rand"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// DefaultIterations is the number of iterations used by MeasureConstructor.
+const DefaultIterations = 20
+
+// Message is the message signed during the measurements.
+var Message = []byte("Sun is Shining...")
+
+// KeyPairGen generates a new key pair using the given randomness.
+type KeyPairGen func(r io.Reader) (handel.SecretKey, handel.PublicKey)
+
+// Measure holds the average cost of one operation.
+type Measure struct {
+	// NsPerOp is the average time of one operation in nanoseconds
+	NsPerOp float64
+	// AllocsPerOp is the average number of heap allocations of one operation
+	AllocsPerOp float64
+}
+
+// ConstructorProfile holds the cost of each operation of a constructor.
+type ConstructorProfile struct {
+	Sign       Measure
+	Verify     Measure
+	SigCombine Measure
+	KeyCombine Measure
+	Marshal    Measure
+	Unmarshal  Measure
+}
+
+// Values returns the profile as a map, with keys of the form "sign_ns" and
+// "sign_allocs" for each operation. It can be reported as monitor measures.
+func (p *ConstructorProfile) Values() map[string]float64 {
+	values := make(map[string]float64)
+	for name, m := range map[string]Measure{
+		"sign":       p.Sign,
+		"verify":     p.Verify,
+		"sigCombine": p.SigCombine,
+		"keyCombine": p.KeyCombine,
+		"marshal":    p.Marshal,
+		"unmarshal":  p.Unmarshal,
+	} {
+		values[name+"_ns"] = m.NsPerOp
+		values[name+"_allocs"] = m.AllocsPerOp
+	}
+	return values
+}
+
+// MeasureConstructor measures the constructor over DefaultIterations. See
+// MeasureConstructorN.
+func MeasureConstructor(c handel.Constructor, kp KeyPairGen) ConstructorProfile {
+	return MeasureConstructorN(c, kp, DefaultIterations)
+}
+
+// MeasureConstructorN returns the average cost of signing, verifying,
+// combining signatures and public keys and marshalling / unmarshalling
+// signatures with the given constructor over the given number of iterations.
+// It panics if any of these operations fails.
+func MeasureConstructorN(c handel.Constructor, kp KeyPairGen, iterations int) ConstructorProfile {
+	sk1, pk1 := kp(rand.Reader)
+	sk2, pk2 := kp(rand.Reader)
+	sig1, err := sk1.Sign(Message, nil)
+	if err != nil {
+		panic(err)
+	}
+	sig2, err := sk2.Sign(Message, nil)
+	if err != nil {
+		panic(err)
+	}
+	buff, err := sig1.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+
+	var p ConstructorProfile
+	p.Sign = measure(iterations, func() {
+		if _, err := sk1.Sign(Message, nil); err != nil {
+			panic(err)
+		}
+	})
+	p.Verify = measure(iterations, func() {
+		if err := pk1.VerifySignature(Message, sig1); err != nil {
+			panic(err)
+		}
+	})
+	p.SigCombine = measure(iterations, func() {
+		sig1.Combine(sig2)
+	})
+	p.KeyCombine = measure(iterations, func() {
+		pk1.Combine(pk2)
+	})
+	p.Marshal = measure(iterations, func() {
+		if _, err := sig1.MarshalBinary(); err != nil {
+			panic(err)
+		}
+	})
+	p.Unmarshal = measure(iterations, func() {
+		if err := c.Signature().UnmarshalBinary(buff); err != nil {
+			panic(err)
+		}
+	})
+	return p
+}
+
+// measure runs the function the given number of times and returns its
+// average cost.
+func measure(iterations int, fn func()) Measure {
+	// warm up so one-time initializations are not measured
+	fn()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		fn()
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return Measure{
+		NsPerOp:     float64(elapsed.Nanoseconds()) / float64(iterations),
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(iterations),
+	}
+}
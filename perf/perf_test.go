@@ -0,0 +1,148 @@
+package perf
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	cf "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSig is a signature combining into a new value at each call, so its
+// operations allocate like a real implementation.
+type fakeSig struct{ n []byte }
+
+func (f *fakeSig) MarshalBinary() ([]byte, error) { return append([]byte{}, f.n...), nil }
+func (f *fakeSig) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("fake: empty signature")
+	}
+	f.n = append([]byte{}, b...)
+	return nil
+}
+func (f *fakeSig) Combine(s handel.Signature) handel.Signature {
+	return &fakeSig{append(append([]byte{}, f.n...), s.(*fakeSig).n...)}
+}
+
+type fakePublic struct{}
+
+func (f *fakePublic) String() string { return "fake" }
+func (f *fakePublic) VerifySignature(msg []byte, s handel.Signature) error {
+	if len(s.(*fakeSig).n) == 0 {
+		return errors.New("fake: invalid signature")
+	}
+	return nil
+}
+func (f *fakePublic) Combine(handel.PublicKey) handel.PublicKey { return new(fakePublic) }
+
+type fakeSecret struct{}
+
+func (f *fakeSecret) Sign(msg []byte, r io.Reader) (handel.Signature, error) {
+	return &fakeSig{append([]byte{}, msg...)}, nil
+}
+
+type fakeCons struct{}
+
+func (f *fakeCons) Signature() handel.Signature { return new(fakeSig) }
+func (f *fakeCons) PublicKey() handel.PublicKey { return new(fakePublic) }
+
+func fakeKeyPair(io.Reader) (handel.SecretKey, handel.PublicKey) {
+	return new(fakeSecret), new(fakePublic)
+}
+
+func TestMeasureConstructor(t *testing.T) {
+	var tests = []struct {
+		name string
+		cons handel.Constructor
+		kp   KeyPairGen
+		// whether combining allocates
+		allocs bool
+	}{
+		{"bn256/cf", cf.NewConstructor(), cf.NewConstructor().KeyPair, true},
+		{"fake", new(fakeCons), fakeKeyPair, false},
+	}
+	for _, test := range tests {
+		t.Logf(" -- test %s -- ", test.name)
+		p := MeasureConstructorN(test.cons, test.kp, 3)
+		for _, m := range []Measure{p.Sign, p.Verify, p.SigCombine, p.KeyCombine, p.Marshal, p.Unmarshal} {
+			require.True(t, m.NsPerOp > 0)
+			require.True(t, m.AllocsPerOp >= 0)
+		}
+		if test.allocs {
+			require.True(t, p.SigCombine.AllocsPerOp > 0)
+			require.True(t, p.KeyCombine.AllocsPerOp > 0)
+		}
+		values := p.Values()
+		require.Len(t, values, 12)
+		require.Equal(t, p.Verify.NsPerOp, values["verify_ns"])
+		require.Equal(t, p.KeyCombine.AllocsPerOp, values["keyCombine_allocs"])
+	}
+}
+
+type benchSetup struct {
+	cons     *cf.Constructor
+	sk       handel.SecretKey
+	pk1, pk2 handel.PublicKey
+	s1, s2   handel.Signature
+	buff     []byte
+}
+
+func newBenchSetup(b *testing.B) *benchSetup {
+	cons := cf.NewConstructor()
+	sk1, pk1 := cons.KeyPair(rand.Reader)
+	sk2, pk2 := cons.KeyPair(rand.Reader)
+	s1, err := sk1.Sign(Message, nil)
+	require.NoError(b, err)
+	s2, err := sk2.Sign(Message, nil)
+	require.NoError(b, err)
+	buff, err := s1.MarshalBinary()
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	return &benchSetup{cons, sk1, pk1, pk2, s1, s2, buff}
+}
+
+func BenchmarkSign(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.sk.Sign(Message, nil)
+	}
+}
+
+func BenchmarkVerifySignature(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.pk1.VerifySignature(Message, s.s1)
+	}
+}
+
+func BenchmarkSignatureCombine(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.s1.Combine(s.s2)
+	}
+}
+
+func BenchmarkPublicKeyCombine(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.pk1.Combine(s.pk2)
+	}
+}
+
+func BenchmarkSignatureMarshal(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.s1.MarshalBinary()
+	}
+}
+
+func BenchmarkSignatureUnmarshal(b *testing.B) {
+	s := newBenchSetup(b)
+	for i := 0; i < b.N; i++ {
+		s.cons.Signature().UnmarshalBinary(s.buff)
+	}
+}
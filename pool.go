@@ -0,0 +1,107 @@
+package handel
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// sigPool recycles the signatures parsed from the packets, so that parsing a
+// packet allocates little more than its signature. Each Handel has its own
+// pool, whose bitsets are the ones of its Session if any. A signature rented
+// from the pool has a single owner at a time:
+//   - parseSignatures and parseIndividual rent the signatures,
+//   - Handel returns the ones it drops before the processing, and the
+//     processing the ones it discards or fails to verify,
+//   - the verified ones belong to the store and the actors, and are never
+//     returned.
+//
+// In the paranoid mode, the signatures returned are poisoned instead of
+// recycled, so that a use after the return panics.
+type sigPool struct {
+	shells sync.Pool
+	// recycled bitsets, nil if Config.NewBitSet is not the default one
+	bitsets *BitSetPool
+	nbs     func(int) BitSet
+	// number of signatures rented and recycled, read atomically
+	rented   int64
+	recycled int64
+}
+
+// pooledSig is the shell of a signature of the pool: the signature and its
+// multi-signature are allocated at once.
+type pooledSig struct {
+	sig IncomingSig
+	ms  MultiSignature
+}
+
+// newSigPool returns a pool of signatures whose bitsets are created with nbs.
+// They are recycled if nbs is DefaultBitSet.
+func newSigPool(nbs func(int) BitSet) *sigPool {
+	p := &sigPool{nbs: nbs}
+	if reflect.ValueOf(nbs).Pointer() == reflect.ValueOf(DefaultBitSet).Pointer() {
+		p.bitsets = NewBitSetPool()
+	}
+	return p
+}
+
+// rent returns a signature whose multi-signature has an empty bitset of the
+// given length, and no signature yet.
+func (p *sigPool) rent(length int) *IncomingSig {
+	s, ok := p.shells.Get().(*pooledSig)
+	if !ok {
+		s = new(pooledSig)
+	}
+	var bs BitSet
+	if p.bitsets != nil {
+		bs = p.bitsets.New(length)
+	} else {
+		bs = p.nbs(length)
+	}
+	s.ms = MultiSignature{BitSet: bs}
+	s.sig = IncomingSig{ms: &s.ms, shell: s}
+	atomic.AddInt64(&p.rented, 1)
+	return &s.sig
+}
+
+// put returns a signature rented from the pool. It is a no-op for the
+// signatures which were not rented, and on a nil pool. The caller must not
+// use the signature anymore.
+func (p *sigPool) put(sp *IncomingSig) {
+	if p == nil || sp == nil || sp.shell == nil {
+		return
+	}
+	releaseSig(p, sp.shell)
+}
+
+// recycle makes the shell and its bitset available to the next rents
+func (p *sigPool) recycle(s *pooledSig) {
+	p.bitsets.Put(s.ms.BitSet)
+	s.sig = IncomingSig{}
+	s.ms = MultiSignature{}
+	p.shells.Put(s)
+	atomic.AddInt64(&p.recycled, 1)
+}
+
+// Values returns the number of signatures rented and recycled
+func (p *sigPool) Values() map[string]float64 {
+	return map[string]float64{
+		"sigPoolRented":   float64(atomic.LoadInt64(&p.rented)),
+		"sigPoolRecycled": float64(atomic.LoadInt64(&p.recycled)),
+	}
+}
+
+// pooledProcessing is implemented by the processings returning the
+// signatures they discard to the pool
+type pooledProcessing interface {
+	setPool(p *sigPool)
+}
+
+// rentSig returns an empty signature of the given level size, rented from the
+// pool if any
+func (h *Handel) rentSig(length int) *IncomingSig {
+	if h.pool == nil {
+		return &IncomingSig{ms: &MultiSignature{BitSet: h.c.NewBitSet(length)}}
+	}
+	return h.pool.rent(length)
+}
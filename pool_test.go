@@ -0,0 +1,196 @@
+package handel
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigPool(t *testing.T) {
+	pool := newSigPool(DefaultBitSet)
+	require.NotNil(t, pool.bitsets)
+	sp := pool.rent(16)
+	sp.ms.BitSet.Set(3, true)
+	sp.origin = 3
+	pool.put(sp)
+	for i := 0; i < 10; i++ {
+		// recycled or not, a signature is always empty
+		sp := pool.rent(16)
+		require.Equal(t, IncomingSig{ms: sp.ms, shell: sp.shell}, *sp)
+		require.Equal(t, 16, sp.ms.BitLength())
+		require.Equal(t, 0, sp.ms.Cardinality())
+		require.Nil(t, sp.ms.Signature)
+		pool.put(sp)
+	}
+	recycled := 11.0
+	if paranoidMode {
+		// the signatures returned are poisoned, see TestParanoidSigPool
+		recycled = 0
+	}
+	require.Equal(t, 11.0, pool.Values()["sigPoolRented"])
+	require.Equal(t, recycled, pool.Values()["sigPoolRecycled"])
+
+	// the signatures not rented, and the nil pool, are ignored
+	pool.put(&IncomingSig{ms: newSig(fullBitset(2))})
+	var nilPool *sigPool
+	nilPool.put(pool.rent(4))
+	require.Equal(t, recycled, pool.Values()["sigPoolRecycled"])
+
+	// the custom bitsets are not recycled
+	custom := newSigPool(func(n int) BitSet { return NewWilffBitset(n) })
+	require.Nil(t, custom.bitsets)
+	sp = custom.rent(8)
+	require.Equal(t, 8, sp.ms.BitLength())
+	custom.put(sp)
+}
+
+func TestWilffBitSetUnmarshalInPlace(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for _, n := range []int{1, 7, 64, 65, 130, 1000} {
+		bs := NewWilffBitset(n)
+		for k := 0; k < n; k++ {
+			bs.Set(k, r.Intn(3) == 0)
+		}
+		buff, err := bs.MarshalBinary()
+		require.NoError(t, err)
+		// in place, into a stale bitset of the same length, or allocated
+		for _, into := range []BitSet{NewWilffBitset(n), bs.Clone().Xor(NewWilffBitset(n)), NewWilffBitset(n + 64), new(WilffBitSet)} {
+			words := into.(*WilffBitSet).b
+			require.NoError(t, into.UnmarshalBinary(buff))
+			require.Equal(t, n, into.BitLength())
+			require.True(t, bs.(*WilffBitSet).b.Equal(into.(*WilffBitSet).b))
+			if into.(*WilffBitSet).b == words {
+				require.Equal(t, n, int(words.Len()))
+			}
+		}
+		require.Error(t, NewWilffBitset(n).UnmarshalBinary(buff[:len(buff)-1]))
+	}
+	require.Error(t, NewWilffBitset(8).UnmarshalBinary(nil))
+	require.Error(t, NewWilffBitset(8).UnmarshalBinary([]byte{0}))
+}
+
+// poolStream returns the packets of a random stream received by the node 0 of
+// n nodes: multi-signatures of random contributions at random levels, with
+// the individual signature of their origin or not, some of them invalid.
+func poolStream(h *Handel, packets int) []*Packet {
+	r := rand.New(rand.NewSource(7))
+	levels := h.Partitioner.Levels()
+	stream := make([]*Packet, packets)
+	for i := range stream {
+		lvl := levels[r.Intn(len(levels))]
+		ids, _ := h.Partitioner.IdentitiesAt(lvl)
+		bs := NewWilffBitset(len(ids))
+		for !bs.Any() {
+			for k := range ids {
+				bs.Set(k, r.Intn(4) == 0)
+			}
+		}
+		ms := &MultiSignature{BitSet: bs, Signature: &fakeSig{r.Intn(10) > 0}}
+		buff, _ := ms.MarshalBinary()
+		p := &Packet{Origin: ids[r.Intn(len(ids))].ID(), Level: byte(lvl), MultiSig: buff}
+		if r.Intn(2) == 0 {
+			p.IndividualSig, _ = (&fakeSig{r.Intn(10) > 0}).MarshalBinary()
+		}
+		stream[i] = p
+	}
+	return stream
+}
+
+// runPacket gives the packet to the Handel, not started, and runs its
+// processing and its store synchronously.
+func runPacket(h *Handel, p *Packet) {
+	proc := h.proc.(*evaluatorProcessing)
+	h.NewPacket(p)
+	for proc.hasTodos() {
+		proc.processStep()
+	}
+	for len(proc.out) > 0 {
+		h.store.Store(<-proc.out)
+	}
+}
+
+// runStream runs the packets as runPacket, and returns the marshalled best
+// signatures of the store.
+func runStream(t *testing.T, h *Handel, stream []*Packet) [][]byte {
+	for _, p := range stream {
+		runPacket(h, p)
+	}
+	var bests [][]byte
+	for _, lvl := range h.Partitioner.Levels() {
+		best, ok := h.store.Best(byte(lvl))
+		if !ok {
+			bests = append(bests, nil)
+			continue
+		}
+		buff, err := best.MarshalBinary()
+		require.NoError(t, err)
+		bests = append(bests, buff)
+	}
+	full, err := h.store.FullSignature().MarshalBinary()
+	require.NoError(t, err)
+	return append(bests, full)
+}
+
+// unpooled makes the Handel allocate the signatures of the packets
+func unpooled(h *Handel) {
+	h.pool = nil
+	h.proc.(*evaluatorProcessing).setPool(nil)
+}
+
+func TestSigPoolDifferential(t *testing.T) {
+	quiet := func(c *Config) { c.Logger = &warnLogger{} }
+	_, handels := FakeSetupWith(64, quiet)
+	defer CloseHandels(handels)
+	_, references := FakeSetupWith(64, quiet)
+	defer CloseHandels(references)
+	pooled, reference := handels[0], references[0]
+	unpooled(reference)
+
+	stream := poolStream(pooled, 5000)
+	exp := runStream(t, reference, stream)
+	require.Equal(t, exp, runStream(t, pooled, stream))
+	if paranoidMode {
+		// the signatures returned are poisoned, not recycled
+		return
+	}
+	values := pooled.proc.(*evaluatorProcessing).Values()
+	require.True(t, values["sigPoolRecycled"] > 0)
+	require.True(t, values["sigPoolRecycled"] < values["sigPoolRented"])
+	require.Equal(t, values["sigCheckedCt"], reference.proc.(*evaluatorProcessing).Values()["sigCheckedCt"])
+
+	// the pool halves the allocations of the packets
+	allocs := func(h *Handel) float64 {
+		i := 0
+		return testing.AllocsPerRun(1000, func() {
+			runPacket(h, stream[i%len(stream)])
+			i++
+		})
+	}
+	require.True(t, 2*allocs(pooled) <= allocs(reference)+1)
+}
+
+// BenchmarkPacketPath measures the handling of the packets of a stream of
+// 100k packets, from their parsing to their storage.
+func BenchmarkPacketPath(b *testing.B) {
+	for _, pool := range []bool{false, true} {
+		name := "unpooled"
+		if pool {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			_, handels := FakeSetupWith(64, func(c *Config) { c.Logger = &warnLogger{} })
+			defer CloseHandels(handels)
+			h := handels[0]
+			if !pool {
+				unpooled(h)
+			}
+			stream := poolStream(h, 100000)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runPacket(h, stream[i%len(stream)])
+			}
+		})
+	}
+}
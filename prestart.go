@@ -0,0 +1,217 @@
+package handel
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPreStartCapacity, DefaultPreStartPerOrigin and DefaultPreStartMaxAge
+// are the bounds of a PacketBuffer given zero values
+const (
+	DefaultPreStartCapacity  = 1024
+	DefaultPreStartPerOrigin = 4
+	DefaultPreStartMaxAge    = 5 * time.Second
+)
+
+// PacketBuffer keeps the packets received while no aggregation runs, e.g.
+// the packets of the peers already aggregating the next message while we are
+// still finalizing the current one, so they are not lost. It is given to the
+// Handels in Config.PreStart, and registered as the Listener of the network
+// in their place: each Handel takes the packets kept so far once started, then
+// the buffer forwards it the packets until it stops, and keeps them again
+// afterwards.
+//
+// The packets are kept by aggregation, see AggregationID: a Handel only takes
+// and is only forwarded the packets of its own aggregation, so the late
+// packets of the previous aggregation never cost a verification to the next
+// one, and the packets of the next aggregation are kept while the current one
+// runs. The bounds limit the cost of the packets which are never taken: the
+// buffer keeps the last packets of each origin of each aggregation only, at
+// most a capacity overall - the oldest ones are evicted first - and drops the
+// packets older than a maximum age.
+type PacketBuffer struct {
+	sync.Mutex
+	capacity  int
+	perOrigin int
+	maxAge    time.Duration
+	now       func() time.Time
+	// kept packets, in their order of arrival
+	packets []bufferedPacket
+	origins map[bufferKey]int
+	// the running Handel the packets are forwarded to
+	target *Handel
+
+	buffered int
+	evicted  int
+	expired  int
+	drained  int
+}
+
+type bufferedPacket struct {
+	p  *Packet
+	at time.Time
+}
+
+// bufferKey is the key of the packets bounded by the perOrigin limit
+type bufferKey struct {
+	aggregation uint32
+	origin      int32
+}
+
+func keyOf(p *Packet) bufferKey {
+	return bufferKey{p.Aggregation, p.Origin}
+}
+
+// AggregationID returns the identifier of the aggregation of the given
+// message, stamped by Handel on the packets it sends: the first bytes of the
+// hash of the message.
+func AggregationID(msg []byte) uint32 {
+	digest := sha256.Sum256(msg)
+	return binary.BigEndian.Uint32(digest[:])
+}
+
+// NewPacketBuffer returns a buffer keeping at most capacity packets, the last
+// perOrigin packets of each origin, for at most maxAge. The zero values are
+// the defaults DefaultPreStartCapacity, DefaultPreStartPerOrigin and
+// DefaultPreStartMaxAge.
+func NewPacketBuffer(capacity, perOrigin int, maxAge time.Duration) *PacketBuffer {
+	if capacity <= 0 {
+		capacity = DefaultPreStartCapacity
+	}
+	if perOrigin <= 0 {
+		perOrigin = DefaultPreStartPerOrigin
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultPreStartMaxAge
+	}
+	return &PacketBuffer{
+		capacity:  capacity,
+		perOrigin: perOrigin,
+		maxAge:    maxAge,
+		now:       time.Now,
+		origins:   make(map[bufferKey]int),
+	}
+}
+
+// NewPacket implements the Listener interface: it forwards the packet to the
+// running Handel if it is of its aggregation, or keeps it otherwise.
+func (b *PacketBuffer) NewPacket(p *Packet) {
+	b.Lock()
+	h := b.target
+	if h != nil && h.aggregation != p.Aggregation {
+		h = nil
+	}
+	if h == nil {
+		b.keep(p)
+	}
+	b.Unlock()
+	if h != nil {
+		h.NewPacket(p)
+	}
+}
+
+// keep adds the packet, evicting the oldest packet of its origin in its
+// aggregation, or the oldest packet, if the bounds are reached
+func (b *PacketBuffer) keep(p *Packet) {
+	now := b.now()
+	b.expire(now)
+	b.buffered++
+	key := keyOf(p)
+	if b.origins[key] >= b.perOrigin {
+		for i, bp := range b.packets {
+			if keyOf(bp.p) == key {
+				b.remove(i)
+				break
+			}
+		}
+		b.evicted++
+	} else if len(b.packets) >= b.capacity {
+		b.remove(0)
+		b.evicted++
+	}
+	b.packets = append(b.packets, bufferedPacket{p: p, at: now})
+	b.origins[key]++
+}
+
+func (b *PacketBuffer) remove(i int) {
+	key := keyOf(b.packets[i].p)
+	if b.origins[key]--; b.origins[key] == 0 {
+		delete(b.origins, key)
+	}
+	b.packets = append(b.packets[:i], b.packets[i+1:]...)
+}
+
+// expire drops the packets older than the maximum age, which are the first
+// ones
+func (b *PacketBuffer) expire(now time.Time) {
+	for len(b.packets) > 0 && now.Sub(b.packets[0].at) > b.maxAge {
+		b.remove(0)
+		b.expired++
+	}
+}
+
+// Len returns the number of packets kept
+func (b *PacketBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	b.expire(b.now())
+	return len(b.packets)
+}
+
+// attach forwards the packets of its aggregation to the started Handel,
+// starting with the ones kept so far, unless it stopped in the meantime. The
+// packets of the other aggregations are still kept.
+func (b *PacketBuffer) attach(h *Handel) {
+	b.Lock()
+	if atomic.LoadInt64(&h.beats.stopped) == 1 {
+		b.Unlock()
+		return
+	}
+	b.expire(b.now())
+	var packets []*Packet
+	kept := b.packets[:0]
+	for _, bp := range b.packets {
+		if bp.p.Aggregation != h.aggregation {
+			kept = append(kept, bp)
+			continue
+		}
+		packets = append(packets, bp.p)
+		key := keyOf(bp.p)
+		if b.origins[key]--; b.origins[key] == 0 {
+			delete(b.origins, key)
+		}
+	}
+	b.packets = kept
+	b.drained += len(packets)
+	b.target = h
+	b.Unlock()
+	for _, p := range packets {
+		h.NewPacket(p)
+	}
+}
+
+// detach keeps the packets again once the Handel stops
+func (b *PacketBuffer) detach(h *Handel) {
+	b.Lock()
+	defer b.Unlock()
+	if b.target == h {
+		b.target = nil
+	}
+}
+
+// Values returns the number of packets kept, evicted by the bounds, expired
+// and drained into the Handels so far.
+func (b *PacketBuffer) Values() map[string]float64 {
+	b.Lock()
+	defer b.Unlock()
+	return map[string]float64{
+		"buffered": float64(b.buffered),
+		"evicted":  float64(b.evicted),
+		"expired":  float64(b.expired),
+		"drained":  float64(b.drained),
+		"kept":     float64(len(b.packets)),
+	}
+}
@@ -0,0 +1,186 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// earlyPackets returns the complete aggregates of the levels 3 and 4 of the
+// node 0 out of 16, for the aggregation of the given message
+func earlyPackets(t *testing.T, msg []byte) []*Packet {
+	return []*Packet{
+		withAggregation(aggregatePacket(t, 4, 3, 4), msg),
+		withAggregation(aggregatePacket(t, 8, 4, 8), msg),
+	}
+}
+
+// waitFinal waits for a final signature of the given cardinality
+func waitFinal(t *testing.T, h *Handel, card int) {
+	for {
+		select {
+		case ms := <-h.FinalSignatures():
+			if ms.Cardinality() >= card {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+	}
+}
+
+func TestPreStartDrain(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := NewTestNetworks(n)
+	buffer := NewPacketBuffer(0, 0, 0)
+	nets[0].RegisterListener(buffer)
+	// the peers send their aggregates before the node creates its Handel
+	for _, p := range earlyPackets(t, msg) {
+		nets[0].(*TestNetwork).dispatch(p)
+	}
+	require.Equal(t, 2, buffer.Len())
+
+	id, _ := reg.Identity(0)
+	// the other nodes don't run: the contributions only come from the buffer
+	conf := &Config{Contributions: 13, PreStart: buffer}
+	h := NewHandel(nets[0], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	defer h.Close()
+	require.Len(t, nets[0].(*TestNetwork).lis, 1)
+	h.Start()
+	waitFinal(t, h, 13)
+	for lvl, card := range map[int]int{3: 4, 4: 8} {
+		best, ok := h.store.Best(byte(lvl))
+		require.True(t, ok)
+		require.Equal(t, card, best.Cardinality())
+	}
+	require.Equal(t, 0, buffer.Len())
+	require.Equal(t, 2.0, buffer.Values()["drained"])
+
+	// the packets go to the running Handel, then to the buffer once it stops
+	nets[0].(*TestNetwork).dispatch(withAggregation(aggregatePacket(t, 2, 2, 2, 0, 1), msg))
+	require.Equal(t, 0, buffer.Len())
+	h.Close()
+	nets[0].(*TestNetwork).dispatch(withAggregation(aggregatePacket(t, 2, 2, 2, 0, 1), msg))
+	require.Equal(t, 1, buffer.Len())
+
+	// a Handel stopped before it started takes nothing
+	h2 := NewHandel(nets[0], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	h2.Close()
+	h2.Start()
+	require.Equal(t, 1, buffer.Len())
+}
+
+func TestPreStartSession(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := NewTestNetworks(n)
+	id, _ := reg.Identity(0)
+	buffer := NewPacketBuffer(0, 0, 0)
+	s := NewSession(nets[0], reg, id, new(fakeCons), &Config{Contributions: 13, PreStart: buffer})
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		// the packets arrive between the aggregations
+		for _, p := range earlyPackets(t, msg) {
+			nets[0].(*TestNetwork).dispatch(p)
+		}
+		require.Equal(t, 2, buffer.Len())
+		h, err := s.NewAggregation(msg, &fakeSig{true}, &Config{Contributions: 13})
+		require.NoError(t, err)
+		require.True(t, h.c.PreStart == buffer)
+		h.Start()
+		waitFinal(t, h, 13)
+		// the application is done with the aggregation
+		h.Close()
+	}
+	require.Equal(t, 4.0, buffer.Values()["drained"])
+}
+
+func TestPreStartBounds(t *testing.T) {
+	capacity, perOrigin, maxAge := 50, 2, time.Second
+	buffer := NewPacketBuffer(capacity, perOrigin, maxAge)
+	clock := newFakeClock()
+	buffer.now = clock.now
+
+	// a flood of 100 origins sending 10 packets each
+	for i := 0; i < 10; i++ {
+		for origin := int32(0); origin < 100; origin++ {
+			buffer.NewPacket(&Packet{Origin: origin, Level: byte(i)})
+		}
+	}
+	require.Equal(t, capacity, buffer.Len())
+	for key, count := range buffer.origins {
+		require.True(t, count <= perOrigin, "origin %v", key)
+	}
+	// the last packets are kept
+	last := buffer.packets[len(buffer.packets)-1].p
+	require.Equal(t, int32(99), last.Origin)
+	require.Equal(t, byte(9), last.Level)
+	require.Equal(t, 1000.0, buffer.Values()["buffered"])
+	require.Equal(t, float64(1000-capacity), buffer.Values()["evicted"])
+
+	// the last packets of each origin replace its older ones
+	buffer = NewPacketBuffer(capacity, perOrigin, maxAge)
+	buffer.now = clock.now
+	for i := 0; i < 5; i++ {
+		buffer.NewPacket(&Packet{Origin: 1, Level: byte(i)})
+	}
+	require.Equal(t, 2, buffer.Len())
+	require.Equal(t, byte(3), buffer.packets[0].p.Level)
+	require.Equal(t, byte(4), buffer.packets[1].p.Level)
+
+	// the packets expire after the maximum age
+	clock.advance(maxAge / 2)
+	buffer.NewPacket(&Packet{Origin: 2})
+	clock.advance(maxAge/2 + time.Millisecond)
+	require.Equal(t, 1, buffer.Len())
+	require.Equal(t, int32(2), buffer.packets[0].p.Origin)
+	clock.advance(maxAge)
+	require.Equal(t, 0, buffer.Len())
+	require.Equal(t, 3.0, buffer.Values()["expired"])
+}
+
+func TestPreStartAggregations(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	nets := NewTestNetworks(n)
+	net := nets[0].(*TestNetwork)
+	id, _ := reg.Identity(0)
+	buffer := NewPacketBuffer(0, 0, 0)
+	s := NewSession(nets[0], reg, id, new(fakeCons), &Config{Contributions: 13, PreStart: buffer})
+	defer s.Close()
+	rounds := [][]byte{[]byte("round 1"), []byte("round 2"), []byte("round 3")}
+	// the packets of the current round are interleaved with the early ones
+	// of the next round
+	interleave := func(current, next []byte) {
+		for i, p := range earlyPackets(t, next) {
+			net.dispatch(withAggregation(aggregatePacket(t, 2, 2, 2, 0, 1), current))
+			net.dispatch(p)
+			net.dispatch(withAggregation(aggregatePacket(t, int32(4+i), 3, 4, 0), current))
+		}
+	}
+
+	// the late packets of a previous round are kept but never taken
+	previous := []byte("round 0")
+	interleave(previous, rounds[0])
+	require.Equal(t, 6, buffer.Len())
+	for i, round := range rounds {
+		h, err := s.NewAggregation(round, &fakeSig{true}, &Config{Contributions: 13})
+		require.NoError(t, err)
+		h.Start()
+		require.Equal(t, float64(2*(i+1)), buffer.Values()["drained"])
+		require.Equal(t, 4, buffer.Len())
+		waitFinal(t, h, 13)
+		// the packets of this round go to the Handel, the ones of the next
+		// round are kept
+		if i+1 < len(rounds) {
+			interleave(round, rounds[i+1])
+			require.Equal(t, 6, buffer.Len())
+		}
+		h.Close()
+	}
+	for _, bp := range buffer.packets {
+		require.Equal(t, AggregationID(previous), bp.p.Aggregation)
+	}
+}
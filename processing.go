@@ -1,19 +1,24 @@
 package handel
 
 // this contains the logic for processing signatures asynchronously. Each
-// incoming packet from the network is passed down to the signatureProcessing
+// incoming packet from the network is passed down to the SignatureProcessing
 // interface, and may be returned to main Handel logic when verified.
 
 import (
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// incomingSig represents a parsed signature from the network. It can represents
-// a individual signature or a multisignature.
-type incomingSig struct {
+// IncomingSig represents a parsed signature from the network. It can represents
+// a individual signature or a multisignature. It is created by Handel and read
+// by the SignatureStore and SignatureProcessing implementations through its
+// accessors.
+type IncomingSig struct {
 	origin int32
 	level  byte
 	ms     *MultiSignature
@@ -22,13 +27,51 @@ type incomingSig struct {
 	// mapped index of the origin to the level's range - only useful when this
 	// signature is an individual signature.
 	mappedIndex int
+	// contributors signing with their previous key by index in the registry,
+	// as hinted by the packet, nil if none - see Config.KeyRotationGrace
+	previous BitSet
+	// shell of the signature if rented from the pool of Handel, see sigPool
+	shell *pooledSig
+	// the signature is the one of the full signature it was decomposed from,
+	// see DecomposeFull
+	decomposed bool
 }
 
 // Individual returns true if this incoming sig is an individual signature
-func (is *incomingSig) Individual() bool {
+func (is *IncomingSig) Individual() bool {
 	return is.isInd
 }
 
+// Origin returns the ID of the node that sent the signature
+func (is *IncomingSig) Origin() int32 {
+	return is.origin
+}
+
+// Level returns the level at which the signature was received
+func (is *IncomingSig) Level() byte {
+	return is.level
+}
+
+// MultiSig returns the multi-signature, whose bitset is indexed by the
+// identities of the level
+func (is *IncomingSig) MultiSig() *MultiSignature {
+	return is.ms
+}
+
+// Decomposed returns true if the signature is one of the levels of a full
+// signature decomposed by DecomposeFull: its signature is the one of the full
+// signature, only valid for the union of the bitsets of the decomposition,
+// and it can't be verified alone. See RecomposeFull.
+func (is *IncomingSig) Decomposed() bool {
+	return is.decomposed
+}
+
+// MappedIndex returns the index of the origin in the bitset of its level. It
+// is only meaningful for an individual signature.
+func (is *IncomingSig) MappedIndex() int {
+	return is.mappedIndex
+}
+
 // SigEvaluator is an interface responsible to evaluate incoming *non-verified*
 // signature according to their relevance regarding the running handel protocol.
 // This is an important part of Handel because the aggregation function (pairing
@@ -38,7 +81,7 @@ type SigEvaluator interface {
 	// Evaluate the interest to verify a signature
 	//   0: no interest, the signature can be discarded definitively
 	//  >0: the greater the more interesting
-	Evaluate(sp *incomingSig) int
+	Evaluate(sp *IncomingSig) int
 }
 
 // Evaluator1 returns 1 for all signatures, leading to having all signatures
@@ -46,7 +89,7 @@ type SigEvaluator interface {
 type Evaluator1 struct{}
 
 // Evaluate implements the SigEvaluator interface.
-func (f *Evaluator1) Evaluate(sp *incomingSig) int {
+func (f *Evaluator1) Evaluate(sp *IncomingSig) int {
 	return 1
 }
 
@@ -54,13 +97,61 @@ func newEvaluator1() SigEvaluator {
 	return &Evaluator1{}
 }
 
+// rejection is the reason a signature is evaluated to zero and discarded by
+// the evaluator processing
+type rejection string
+
+const (
+	// the level of the signature is complete
+	rejectCompleteLevel rejection = "completeLevel"
+	// the individual signature is verified already
+	rejectVerifiedIndividual rejection = "verifiedIndividual"
+	// the best signature of the level is a superset of the signature
+	rejectSuperset rejection = "superset"
+	// the signature adds no contribution to the best of the level
+	rejectNoValue rejection = "noValue"
+	// the evaluator does not tell, or the packet holds no signature
+	rejectOther rejection = "other"
+)
+
+// rejections are all the reasons, in the order they are reported
+var rejections = []rejection{rejectCompleteLevel, rejectVerifiedIndividual, rejectSuperset, rejectNoValue, rejectOther}
+
+// explainingEvaluator is implemented by the evaluators telling why they
+// evaluate a signature to zero. The wrappers of an evaluator implement it to
+// pass on the reasons of the evaluator they wrap.
+type explainingEvaluator interface {
+	// evaluateReason returns the evaluation of the signature, and the
+	// reason if it is zero
+	evaluateReason(sp *IncomingSig) (int, rejection)
+}
+
+// evaluateReason evaluates the signature with the evaluator, and returns the
+// reason of a zero evaluation, rejectOther if the evaluator does not tell it.
+func evaluateReason(e SigEvaluator, sp *IncomingSig) (int, rejection) {
+	score := 0
+	var reason rejection
+	if ee, ok := e.(explainingEvaluator); ok {
+		score, reason = ee.evaluateReason(sp)
+	} else {
+		score = e.Evaluate(sp)
+	}
+	if score > 0 {
+		return score, ""
+	}
+	if reason == "" {
+		reason = rejectOther
+	}
+	return score, reason
+}
+
 // EvaluatorStore is a wrapper around the store's evaluation strategy.
 type EvaluatorStore struct {
 	store SignatureStore
 }
 
 // Evaluate implements the SigEvaluator strategy.
-func (f *EvaluatorStore) Evaluate(sp *incomingSig) int {
+func (f *EvaluatorStore) Evaluate(sp *IncomingSig) int {
 	return f.store.Evaluate(sp)
 }
 
@@ -68,24 +159,38 @@ func newEvaluatorStore(store SignatureStore) SigEvaluator {
 	return &EvaluatorStore{store: store}
 }
 
-// signatureProcessing is an interface responsible for verifying incoming
+// evaluateReason implements the explainingEvaluator interface, with the
+// reasons of the store if it gives them
+func (f *EvaluatorStore) evaluateReason(sp *IncomingSig) (int, rejection) {
+	return evaluateReason(f.store, sp)
+}
+
+// SignatureProcessing is an interface responsible for verifying incoming
 // (multi-)signatures. It continuously evaluate (with an Evaluator) the stream
 // of incoming signatures and prune some depending on the evaluation. It signals
 // back verified signatures to the main handel processing logic It is an
 // asynchronous processing interface that needs to be started and stopped by the
-// Handel logic.
-type signatureProcessing interface {
-	// Start is a blocking call that starts the processing routine
+// Handel logic. Custom implementations are given to Handel with
+// Config.NewProcessing.
+//
+// Add is called concurrently with the processing routine, from the network
+// routines, and must never block for long. Start is called once, in its own
+// routine, and Stop at most once. The signatures can be verified and output
+// in any order, but each one at most once.
+type SignatureProcessing interface {
+	// Start runs the processing routine: it blocks until the routine is
+	// stopped.
 	Start()
-	// Stop is a blocking call that stops the processing routine
+	// Stop signals the processing routine to stop. Once stopped, the routine
+	// closes the Verified channel and Start returns.
 	Stop()
-	// Add an incomingSig to the processing list
-	Add(sp *incomingSig)
+	// Add an IncomingSig to the processing list
+	Add(sp *IncomingSig)
 	// channel that outputs verified signatures. Implementation must guarantee
 	// that all verified signatures are signatures that have been verified
 	// correctly and sent on the incoming channel. No new signatures must be
 	// outputted on this channel ( is the role of the Store)
-	Verified() chan incomingSig
+	Verified() chan *IncomingSig
 }
 
 // evaluator processing processing incoming signatures according to an signature
@@ -99,8 +204,8 @@ type evaluatorProcessing struct {
 	cons Constructor
 	msg  []byte
 
-	out       chan incomingSig
-	todos     []*incomingSig
+	out       chan *IncomingSig
+	todos     []*queued
 	evaluator SigEvaluator
 	log       Logger
 	// to filter out signatures before inserting into processing queue
@@ -108,6 +213,10 @@ type evaluatorProcessing struct {
 
 	sigSleepTime int64
 
+	// reject the individual signatures not sent by their signer, see
+	// Config.StrictIndividualOrigin
+	strictOrigin bool
+
 	// Statistics on the activity
 	// number of signatures checked by the processing
 	sigCheckedCt int
@@ -115,14 +224,47 @@ type evaluatorProcessing struct {
 	// Size of the queue after the cleanup (removal of the redundant signatures)
 	sigQueueSize int
 
-	// Number of signatures identified as redundant by the evaluation
-	sigSuppressed int
+	// Number of signatures discarded by the evaluation, by reason, and the
+	// number of them that were worth verifying when evaluated before
+	sigSuppressed map[rejection]int
+	sigSuperseded int
+
+	// time the signatures picked waited in the queue, in milliseconds
+	queueLatencies []float64
 
 	// Time spent checking the signature
 	sigCheckingTime int
+
+	// cache of the aggregate public keys used to verify the signatures
+	keys *keyCache
+
+	// spans of the verifications, see Config.Tracer
+	tracing *tracing
+
+	// pool the signatures discarded are returned to, nil if none
+	pool *sigPool
+
+	// last time a signature was picked, verified or queued while idle, in unix
+	// nanoseconds, and number of signatures waiting, read atomically by
+	// Handel.Health
+	lastStep int64
+	pending  int64
+
+	// expected capacity of the queue, see Config.QueueCapacity, read
+	// atomically
+	capacity int64
+
+	// clock of the heartbeat, time.Now by default
+	now func() time.Time
+
+	// true while a signature picked is verified, and the channels to close
+	// once no signature is queued nor verified, see Drained. Guarded by the
+	// lock of cond.
+	busy    bool
+	drained []chan struct{}
 }
 
-func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, sigSleepTime int, e SigEvaluator, log Logger) signatureProcessing {
+func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, sigSleepTime int, strictOrigin bool, capacity int, e SigEvaluator, log Logger) SignatureProcessing {
 	m := sync.Mutex{}
 
 	ev := &evaluatorProcessing{
@@ -131,92 +273,143 @@ func newEvaluatorProcessing(part Partitioner, c Constructor, msg []byte, sigSlee
 		cons:         c,
 		msg:          msg,
 		sigSleepTime: int64(sigSleepTime),
+		strictOrigin: strictOrigin,
+		capacity:     int64(capacity),
 
-		out:       make(chan incomingSig, 1000),
-		todos:     make([]*incomingSig, 0),
+		out:       make(chan *IncomingSig, 1000),
+		todos:     make([]*queued, 0),
 		evaluator: e,
 		log:       log,
 		filter:    newIndividualSigFilter(),
+		keys:      newKeyCache(c),
+		now:       time.Now,
+
+		sigSuppressed: make(map[rejection]int),
 	}
 	return ev
 }
 
+// queued is a signature waiting in the queue of the evaluator processing
+type queued struct {
+	sig *IncomingSig
+	// time the signature entered the queue
+	at time.Time
+	// evaluation of the signature in the last pass, zero before the first
+	mark int
+}
+
 func (f *evaluatorProcessing) Start() {
-	go f.processLoop()
+	f.processLoop()
 }
 
 // deathPillPair is used to stop the processing routine.
-var deathPillPair = incomingSig{origin: -1}
+var deathPillPair = IncomingSig{origin: -1}
 
 func (f *evaluatorProcessing) Stop() {
 	f.Add(&deathPillPair)
 }
 
-func (f *evaluatorProcessing) Verified() chan incomingSig {
+func (f *evaluatorProcessing) Verified() chan *IncomingSig {
 	return f.out
 }
 
-func (f *evaluatorProcessing) Add(sp *incomingSig) {
+func (f *evaluatorProcessing) Add(sp *IncomingSig) {
 	f.cond.L.Lock()
 	defer f.cond.L.Unlock()
 
 	if f.filter.Accept(sp) {
-		f.todos = append(f.todos, sp)
+		now := f.now()
+		if len(f.todos) == 0 {
+			// the processing is given work: it is stuck from now on if it
+			// does not pick it
+			beat(&f.lastStep, now)
+		}
+		f.todos = append(f.todos, &queued{sig: sp, at: now})
+		atomic.StoreInt64(&f.pending, int64(len(f.todos)))
 		f.cond.Signal()
+	} else {
+		f.pool.put(sp)
 	}
 }
 
 // Look at the signatures received so far and select the one
 //  that should be processed first.
-func (f *evaluatorProcessing) readTodos() (bool, *incomingSig) {
+func (f *evaluatorProcessing) readTodos() (bool, *IncomingSig) {
 	f.cond.L.Lock()
 	defer f.cond.L.Unlock()
 	for len(f.todos) == 0 {
 		f.cond.Wait()
 	}
 
-	previousLen := len(f.todos)
+	survivors, done := f.suppress()
+	if done {
+		return true, nil
+	}
+	best, rest := pickBest(survivors)
+
+	f.todos = rest
+	now := f.now()
+	atomic.StoreInt64(&f.pending, int64(len(f.todos)))
+	beat(&f.lastStep, now)
+	f.busy = best != nil
+	f.notifyDrained()
 
-	// We need to iterate on our list. We put in
-	//   'newTodos' the signatures not selected in this round
-	//   but possibly interesting next time
-	var newTodos []*incomingSig
-	var best *incomingSig
-	bestMark := 0
-	for _, pair := range f.todos {
-		if *pair == deathPillPair {
-			return true, nil
+	if best == nil {
+		return false, nil
+	}
+	f.sigCheckedCt++
+	f.sigQueueSize += len(rest)
+	f.queueLatencies = append(f.queueLatencies, float64(now.Sub(best.at).Nanoseconds())/1e6)
+	return false, best.sig
+}
+
+// suppress evaluates the signatures of the queue, and returns the ones worth
+// verifying in the order of the queue. The others are discarded for good and
+// counted by reason. It returns true if the processing must stop. It must be
+// called with the lock held.
+func (f *evaluatorProcessing) suppress() ([]*queued, bool) {
+	var survivors []*queued
+	for _, q := range f.todos {
+		if *q.sig == deathPillPair {
+			return nil, true
 		}
-		if pair.ms == nil {
+		if q.sig.ms == nil {
+			f.sigSuppressed[rejectOther]++
 			continue
 		}
-
-		mark := f.evaluator.Evaluate(pair)
-		if mark > 0 {
-			if mark <= bestMark {
-				newTodos = append(newTodos, pair)
-			} else {
-				if best != nil {
-					newTodos = append(newTodos, best)
-				}
-				best = pair
-				bestMark = mark
+		mark, reason := evaluateReason(f.evaluator, q.sig)
+		if mark <= 0 {
+			f.sigSuppressed[reason]++
+			if q.mark > 0 {
+				// it lost its value while waiting
+				f.sigSuperseded++
 			}
+			f.pool.put(q.sig)
+			continue
 		}
+		q.mark = mark
+		survivors = append(survivors, q)
 	}
-
-	f.todos = newTodos
-
-	newLen := len(f.todos)
-
-	f.sigSuppressed += previousLen - newLen
-	if best != nil {
-		f.sigSuppressed-- // we don't want to count 'best' as a suppressed sig.
-		f.sigCheckedCt++
-		f.sigQueueSize += newLen
+	return survivors, false
+}
+
+// pickBest returns the signature with the highest mark, the first one among
+// equals, and the others. A signature displaced as the best goes after the
+// ones before it, as it always did in the queue.
+func pickBest(survivors []*queued) (*queued, []*queued) {
+	var best *queued
+	var rest []*queued
+	for _, q := range survivors {
+		if best != nil && q.mark <= best.mark {
+			rest = append(rest, q)
+			continue
+		}
+		if best != nil {
+			rest = append(rest, best)
+		}
+		best = q
 	}
-
-	return false, best
+	return best, rest
 }
 
 func (f *evaluatorProcessing) hasTodos() bool {
@@ -247,12 +440,47 @@ func (f *evaluatorProcessing) Values() map[string]float64 {
 		sigCheckingTime = float64(f.sigCheckingTime) / float64(f.sigCheckedCt)
 	}
 
-	return map[string]float64{
+	values := map[string]float64{
 		"sigCheckedCt":    float64(f.sigCheckedCt),
 		"sigQueueSize":    sigQueueSize,
-		"sigSuppressed":   float64(f.sigSuppressed),
 		"sigCheckingTime": sigCheckingTime,
 	}
+	f.cond.L.Lock()
+	suppressed := 0
+	for _, reason := range rejections {
+		suppressed += f.sigSuppressed[reason]
+		values["sigSuppressed_"+string(reason)] = float64(f.sigSuppressed[reason])
+	}
+	values["sigSuppressed"] = float64(suppressed)
+	values["sigSuperseded"] = float64(f.sigSuperseded)
+	latencies := append([]float64(nil), f.queueLatencies...)
+	f.cond.L.Unlock()
+	// the time the signatures picked waited in the queue, in milliseconds
+	sort.Float64s(latencies)
+	quantile := func(q float64) float64 {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(math.Floor(q*float64(len(latencies)-1)))]
+	}
+	values["sigQueueLatencyP50"] = quantile(0.5)
+	values["sigQueueLatencyP90"] = quantile(0.9)
+	values["sigQueueLatencyP99"] = quantile(0.99)
+	for k, v := range f.keys.Values() {
+		values[k] = v
+	}
+	if f.pool != nil {
+		for k, v := range f.pool.Values() {
+			values[k] = v
+		}
+	}
+	// the evaluator may report its own values, e.g. the cost calibration
+	if r, ok := f.evaluator.(Reporter); ok {
+		for k, v := range r.Values() {
+			values[k] = v
+		}
+	}
+	return values
 }
 
 func (f *evaluatorProcessing) processStep() bool {
@@ -263,27 +491,94 @@ func (f *evaluatorProcessing) processStep() bool {
 	}
 	if best != nil {
 		f.verifyAndPublish(best)
+		f.cond.L.Lock()
+		f.busy = false
+		f.notifyDrained()
+		f.cond.L.Unlock()
 	}
 	return false
 }
 
-func (f *evaluatorProcessing) verifyAndPublish(sp *incomingSig) {
+// Drained returns a channel closed once no signature is queued nor being
+// verified: the signatures added before were all published on Verified or
+// discarded. Tests use it to await the processing.
+func (f *evaluatorProcessing) Drained() <-chan struct{} {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	ch := make(chan struct{})
+	f.drained = append(f.drained, ch)
+	f.notifyDrained()
+	return ch
+}
+
+// notifyDrained closes the channels returned by Drained if no signature is
+// queued nor being verified. It must be called with the lock held.
+func (f *evaluatorProcessing) notifyDrained() {
+	if len(f.todos) > 0 || f.busy {
+		return
+	}
+	for _, ch := range f.drained {
+		close(ch)
+	}
+	f.drained = nil
+}
+
+func (f *evaluatorProcessing) verifyAndPublish(sp *IncomingSig) {
 	startTime := time.Now()
+	span := f.tracing.verification(sp)
 	err := (error)(nil)
 	if f.sigSleepTime <= 0 {
-		err = verifySignature(sp, f.msg, f.part, f.cons)
+		err = verifySignature(sp, f.msg, f.part, f.keys, f.strictOrigin)
 	} else {
 		time.Sleep(time.Duration(f.sigSleepTime * 1000000))
 	}
+	verified(span, err)
 	endTime := time.Now()
 
 	f.sigCheckingTime += int(endTime.Sub(startTime).Nanoseconds() / 1000000)
 
+	beat(&f.lastStep, f.now())
 	if err != nil {
-		f.log.Warn("verify", err)
+		f.log.Warn("verify", err, "origin", sp.origin, "level", sp.level)
+		f.pool.put(sp)
 	} else {
-		f.out <- *sp
+		f.out <- sp
+	}
+}
+
+// heartbeat implements the processingHeartbeat interface
+func (f *evaluatorProcessing) heartbeat() (time.Time, int) {
+	return lastBeat(&f.lastStep), int(atomic.LoadInt64(&f.pending))
+}
+
+// QueuePressure implements the PressureReporter interface, it is zero without
+// capacity
+func (f *evaluatorProcessing) QueuePressure() float64 {
+	capacity := atomic.LoadInt64(&f.capacity)
+	if capacity <= 0 {
+		return 0
 	}
+	return math.Min(1, float64(atomic.LoadInt64(&f.pending))/float64(capacity))
+}
+
+// setCapacity implements the capacitySetter interface
+func (f *evaluatorProcessing) setCapacity(capacity int) {
+	atomic.StoreInt64(&f.capacity, int64(capacity))
+}
+
+// setRotation implements the rotatingProcessing interface
+func (f *evaluatorProcessing) setRotation(r *keyRotation) {
+	f.keys.rotation = r
+}
+
+// setPool implements the pooledProcessing interface
+func (f *evaluatorProcessing) setPool(p *sigPool) {
+	f.pool = p
+}
+
+// setTracing implements the tracedProcessing interface
+func (f *evaluatorProcessing) setTracing(t *tracing) {
+	f.tracing = t
 }
 
 // Filter holds the responsibility of filtering out the signatures before they
@@ -293,7 +588,7 @@ func (f *evaluatorProcessing) verifyAndPublish(sp *incomingSig) {
 type Filter interface {
 	// Accept returns false if the signature must be evicted before inserting it
 	// in the queue.
-	Accept(*incomingSig) bool
+	Accept(*IncomingSig) bool
 }
 
 // individualSigFilter is a filter than only accepts *once* individual
@@ -306,7 +601,7 @@ func newIndividualSigFilter() Filter {
 	return &individualSigFilter{make(map[int]bool)}
 }
 
-func (i *individualSigFilter) Accept(inc *incomingSig) bool {
+func (i *individualSigFilter) Accept(inc *IncomingSig) bool {
 	if !inc.Individual() {
 		// only refuse individual signatures
 		return true
@@ -327,7 +622,7 @@ func (i *individualSigFilter) Accept(inc *incomingSig) bool {
 // as well.
 type combinedFilter struct{ filters []Filter }
 
-func (c *combinedFilter) Accept(inc *incomingSig) bool {
+func (c *combinedFilter) Accept(inc *IncomingSig) bool {
 	for _, f := range c.filters {
 		if !f.Accept(inc) {
 			return false
@@ -337,9 +632,13 @@ func (c *combinedFilter) Accept(inc *incomingSig) bool {
 }
 
 // verifySignature returns true if the given signature is valid. The function
-// constructs the aggregate public key from all public keys denoted in the
-// bitset.
-func verifySignature(pair *incomingSig, msg []byte, part Partitioner, cons Constructor) error {
+// gets the aggregate public key of all public keys denoted in the bitset from
+// the given cache. An individual signature is verified directly against the
+// public key of its signer, which must be the origin of the packet if strict.
+func verifySignature(pair *IncomingSig, msg []byte, part Partitioner, keys *keyCache, strict bool) error {
+	if pair.decomposed {
+		return errDecomposedVerified
+	}
 	level := pair.level
 	ms := pair.ms
 	ids, err := part.IdentitiesAt(int(level))
@@ -351,30 +650,48 @@ func verifySignature(pair *incomingSig, msg []byte, part Partitioner, cons Const
 		return errors.New("handel: inconsistent bitset with given level")
 	}
 
-	// compute the aggregate public key corresponding to bitset
-	aggregateKey := cons.PublicKey()
-	for i := 0; i < ms.BitSet.BitLength(); i++ {
-		if !ms.BitSet.Get(i) {
-			continue
+	var key PublicKey
+	if ms.BitSet.Cardinality() == 1 {
+		// no aggregation needed: use the key of the signer as is
+		idx, _ := ms.BitSet.NextSet(0)
+		if strict {
+			signer, err := part.GlobalOf(int(level), idx)
+			if err != nil {
+				return err
+			}
+			if int32(signer) != pair.origin {
+				return fmt.Errorf("handel: individual signature of %d sent by %d", signer, pair.origin)
+			}
 		}
-		aggregateKey = aggregateKey.Combine(ids[i].PublicKey())
+		key = keys.keyOf(ids[idx], pair.previous)
+		keys.individuals++
+	} else if key = keys.aggregateRotated(ms.BitSet, ids, pair.previous); key == nil {
+		// compute the aggregate public key corresponding to bitset
+		key = keys.aggregate(level, ms.BitSet, ids)
 	}
-
-	if err := aggregateKey.VerifySignature(msg, ms.Signature); err != nil {
-		logf("processing err: from %d -> level %d -> %s", pair.origin, pair.level, ms.String())
-		return fmt.Errorf("handel: %s", err)
+	if err := key.VerifySignature(msg, ms.Signature); err != nil {
+		return fmt.Errorf("handel: signature from %d at level %d: %s", pair.origin, pair.level, err)
 	}
 	return nil
 }
 
-func (is *incomingSig) String() string {
+// VerifyIncomingSig returns an error if the signature is not valid for the
+// message, aggregating from scratch the public keys of the identities of its
+// level given by the partitioner. It lets custom SignatureProcessing
+// implementations verify the signatures as Handel does. The individual
+// signatures relayed by another node than their signer are accepted.
+func VerifyIncomingSig(sig *IncomingSig, msg []byte, part Partitioner, cons Constructor) error {
+	return verifySignature(sig, msg, part, newKeyCache(cons), false)
+}
+
+func (is *IncomingSig) String() string {
 	if is.ms == nil {
 		return fmt.Sprintf("sig(lvl %d): <nil>", is.level)
 	}
 	return fmt.Sprintf("sig(lvl %d): %s", is.level, is.ms.String())
 }
 
-// fifoProcessing implements the signatureProcessing interface using a simple
+// fifoProcessing implements the SignatureProcessing interface using a simple
 // fifo queue, verifying all incoming signatures, not matter relevant or not.
 // XXX Deprecated
 type fifoProcessing struct {
@@ -383,89 +700,116 @@ type fifoProcessing struct {
 	part  Partitioner
 	cons  Constructor
 	msg   []byte
-	in    chan incomingSig
-	out   chan incomingSig
+	in    chan *IncomingSig
+	out   chan *IncomingSig
 	done  bool
+	// discards the verification errors
+	log Logger
+	// signatures added and handled, and the channels of Drained
+	added   int
+	handled int
+	drained []chan struct{}
 }
 
-// newFifoProcessing returns a signatureProcessing implementation using a fifo
+// newFifoProcessing returns a SignatureProcessing implementation using a fifo
 // queue. It needs the store to store the valid signatures, the partitioner +
 // constructor and the messages to verify the signatures.
 // XXX: deprecated, used only for testing.
 func newFifoProcessing(store SignatureStore, part Partitioner,
-	c Constructor, msg []byte) signatureProcessing {
+	c Constructor, msg []byte) SignatureProcessing {
 	return &fifoProcessing{
 		part:  part,
 		store: store,
 		cons:  c,
 		msg:   msg,
-		in:    make(chan incomingSig, 100),
-		out:   make(chan incomingSig, 100),
+		in:    make(chan *IncomingSig, 100),
+		out:   make(chan *IncomingSig, 100),
+		log:   nopLogger{},
 	}
 }
 
 // processIncoming verifies the signature, stores it, and outputs it
 func (f *fifoProcessing) processIncoming() {
 	for pair := range f.in {
-		score := f.store.Evaluate(&pair)
-		if score == 0 {
-			//logf("handel: fifo: skipping verification of signature %s", pair.String())
-			continue
-		}
-
-		err := f.verifySignature(&pair)
-		if err != nil {
-			logf("handel: fifo: verifying err: %s", err)
-			continue
-		}
-
-		f.Lock()
-		done := f.done
-		if !done {
-			//logf("handel: handling back verified signature to actors")
-			f.out <- pair
-		}
-		f.Unlock()
-		if done {
+		if !f.process(pair) {
 			break
 		}
 	}
 }
 
-func (f *fifoProcessing) verifySignature(pair *incomingSig) error {
-	level := pair.level
-	ms := pair.ms
-	ids, err := f.part.IdentitiesAt(int(level))
-	if err != nil {
-		return err
+// process verifies and outputs the signature if it's worth it. It returns
+// false once the processing is stopped.
+func (f *fifoProcessing) process(pair *IncomingSig) bool {
+	defer f.handle()
+	score := f.store.Evaluate(pair)
+	if score == 0 {
+		return true
 	}
 
-	if ms.BitSet.BitLength() != len(ids) {
-		return errors.New("handel: inconsistent bitset with given level")
+	err := f.verifySignature(pair)
+	if err != nil {
+		f.log.Warn("verify", err)
+		return true
 	}
 
-	// compute the aggregate public key corresponding to bitset
-	aggregateKey := f.cons.PublicKey()
-	for i := 0; i < ms.BitSet.BitLength(); i++ {
-		if !ms.BitSet.Get(i) {
-			continue
-		}
-		aggregateKey = aggregateKey.Combine(ids[i].PublicKey())
+	f.Lock()
+	defer f.Unlock()
+	if f.done {
+		return false
 	}
+	f.out <- pair
+	return true
+}
 
-	if err := aggregateKey.VerifySignature(f.msg, ms.Signature); err != nil {
-		logf("processing err: from %d -> level %d -> %s", pair.origin, pair.level, ms.String())
-		return fmt.Errorf("handel: %s", err)
+// handle counts a signature handled and closes the channels of Drained if
+// all the signatures added are
+func (f *fifoProcessing) handle() {
+	f.Lock()
+	defer f.Unlock()
+	f.handled++
+	f.notifyDrained()
+}
+
+// Drained returns a channel closed once all the signatures added before were
+// output on Verified or discarded
+func (f *fifoProcessing) Drained() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+	ch := make(chan struct{})
+	f.drained = append(f.drained, ch)
+	f.notifyDrained()
+	return ch
+}
+
+// notifyDrained must be called with the lock held
+func (f *fifoProcessing) notifyDrained() {
+	if f.handled < f.added {
+		return
+	}
+	for _, ch := range f.drained {
+		close(ch)
 	}
+	f.drained = nil
+}
 
-	return nil
+func (f *fifoProcessing) verifySignature(pair *IncomingSig) error {
+	return VerifyIncomingSig(pair, f.msg, f.part, f.cons)
+}
+
+func (f *fifoProcessing) Add(sp *IncomingSig) {
+	f.Lock()
+	f.added++
+	f.Unlock()
+	f.in <- sp
 }
 
-func (f *fifoProcessing) Add(sp *incomingSig) {
-	f.in <- *sp
+// QueuePressure implements the PressureReporter interface, relative to the
+// capacity of the incoming channel
+func (f *fifoProcessing) QueuePressure() float64 {
+	return float64(len(f.in)) / float64(cap(f.in))
 }
 
-func (f *fifoProcessing) Verified() chan incomingSig {
+func (f *fifoProcessing) Verified() chan *IncomingSig {
 	return f.out
 }
 
@@ -1,6 +1,7 @@
 package handel
 
 import (
+	"math/rand"
 	"testing"
 	"time"
 
@@ -10,7 +11,7 @@ import (
 type EvaluatorLevel struct {
 }
 
-func (f *EvaluatorLevel) Evaluate(sp *incomingSig) int {
+func (f *EvaluatorLevel) Evaluate(sp *IncomingSig) int {
 	return int(sp.level)
 }
 
@@ -23,7 +24,7 @@ func TestSigProcessingStrategy(t *testing.T) {
 	sig1 := fullIncomingSig(1)
 	sig2 := fullIncomingSig(2)
 
-	s := newEvaluatorProcessing(partitioner, cons, nil, 0, &EvaluatorLevel{}, nil)
+	s := newEvaluatorProcessing(partitioner, cons, nil, 0, false, 0, &EvaluatorLevel{}, nil)
 	ss := s.(*evaluatorProcessing)
 
 	require.Equal(t, 0, len(ss.todos))
@@ -42,7 +43,7 @@ func TestSigProcessingStrategy(t *testing.T) {
 	ss.Add(sig0)
 	ss.processStep()
 	require.Equal(t, 1, len(ss.todos))
-	require.Equal(t, sig1, ss.todos[0])
+	require.Equal(t, sig1, ss.todos[0].sig)
 
 	ss.Add(&deathPillPair)
 	stop2 := ss.processStep()
@@ -57,15 +58,15 @@ func TestProcessingFifo(t *testing.T) {
 	store := newStore(partitioner, NewWilffBitset, cons)
 
 	type testProcess struct {
-		in  []*incomingSig
-		out []*incomingSig
+		in  []*IncomingSig
+		out []*IncomingSig
 	}
 	sig2 := fullIncomingSig(2)
 	sig2Inv := fullIncomingSig(2)
 	sig2Inv.ms.Signature.(*fakeSig).verify = false
 	sig3 := fullIncomingSig(3)
 
-	var s = func(sigs ...*incomingSig) []*incomingSig { return sigs }
+	var s = func(sigs ...*IncomingSig) []*IncomingSig { return sigs }
 
 	var tests = []testProcess{
 		// all good, one one
@@ -82,15 +83,14 @@ func TestProcessingFifo(t *testing.T) {
 
 	fifo := newFifoProcessing(store, partitioner, cons, msg).(*fifoProcessing)
 	go fifo.Start()
-	time.Sleep(20 * time.Millisecond)
 	fifo.Stop()
 
-	fifos := make([]signatureProcessing, 0, len(tests))
+	fifos := make([]SignatureProcessing, 0, len(tests))
 	for i, test := range tests {
 		t.Logf(" -- test %d -- ", i)
 
 		store := newStore(partitioner, NewWilffBitset, cons)
-		fifo := newFifoProcessing(store, partitioner, cons, msg)
+		fifo := newFifoProcessing(store, partitioner, cons, msg).(*fifoProcessing)
 		fifos = append(fifos, fifo)
 		go fifo.Start()
 
@@ -100,14 +100,13 @@ func TestProcessingFifo(t *testing.T) {
 		// input all signature pairs
 		for i, sp := range test.in {
 			fifo.Add(sp)
+			<-fifo.Drained()
 			// expect same order of verified
 			out := test.out[i]
-			var s *incomingSig
+			var s *IncomingSig
 			select {
-			case p := <-verified:
-				s = &p
-			case <-time.After(20 * time.Millisecond):
-				s = nil
+			case s = <-verified:
+			default:
 			}
 			require.Equal(t, out, s)
 			// simulate storage
@@ -119,3 +118,197 @@ func TestProcessingFifo(t *testing.T) {
 		fifo.Stop()
 	}
 }
+
+func TestVerifyIndividualSignature(t *testing.T) {
+	n := 16
+	registry := FakeRegistry(n)
+	partitioner := NewBinPartitioner(1, registry, DefaultLogger)
+	cons := new(fakeCons)
+	level := 3
+	ids, err := partitioner.IdentitiesAt(level)
+	require.NoError(t, err)
+	signer := ids[1].ID()
+	relay := ids[2].ID()
+
+	var individual = func(origin int32, pos int, valid bool) *IncomingSig {
+		bs := NewWilffBitset(len(ids))
+		bs.Set(pos, true)
+		ms := newSig(bs)
+		ms.Signature.(*fakeSig).verify = valid
+		return &IncomingSig{origin: origin, level: byte(level), ms: ms, isInd: true}
+	}
+	multi := &IncomingSig{origin: signer, level: byte(level), ms: fullSig(level)}
+
+	type verifyTest struct {
+		sig    *IncomingSig
+		strict bool
+		ok     bool
+		// number of signatures verified without aggregation
+		individuals int
+	}
+	var tests = []verifyTest{
+		// sent by its signer
+		{individual(signer, 1, true), true, true, 1},
+		{individual(signer, 1, true), false, true, 1},
+		// relayed by another node
+		{individual(relay, 1, true), true, false, 0},
+		{individual(relay, 1, true), false, true, 1},
+		// invalid signature
+		{individual(signer, 1, false), false, false, 1},
+		// multi signatures take the aggregate path
+		{multi, true, true, 0},
+	}
+
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		keys := newKeyCache(cons)
+		err := verifySignature(test.sig, nil, partitioner, keys, test.strict)
+		if test.ok {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+		require.Equal(t, test.individuals, keys.individuals)
+		require.Equal(t, float64(test.individuals), keys.Values()["individualKeys"])
+		if test.individuals > 0 {
+			// no aggregate key was computed nor cached
+			require.Equal(t, 0, keys.hits+keys.misses)
+		}
+	}
+}
+
+// refReadTodos is the selection readTodos made before the suppression and the
+// pick were separate passes: it returns the signature picked and the queue
+// left.
+func refReadTodos(todos []*IncomingSig, e SigEvaluator) (*IncomingSig, []*IncomingSig) {
+	var newTodos []*IncomingSig
+	var best *IncomingSig
+	bestMark := 0
+	for _, pair := range todos {
+		if pair.ms == nil {
+			continue
+		}
+		mark := e.Evaluate(pair)
+		if mark > 0 {
+			if mark <= bestMark {
+				newTodos = append(newTodos, pair)
+			} else {
+				if best != nil {
+					newTodos = append(newTodos, best)
+				}
+				best = pair
+				bestMark = mark
+			}
+		}
+	}
+	return best, newTodos
+}
+
+func TestProcessingPickDifferential(t *testing.T) {
+	n := 64
+	reg := FakeRegistry(n)
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 50; i++ {
+		t.Logf(" -- test %d -- ", i)
+		part := NewBinPartitioner(int32(r.Intn(n)), reg, DefaultLogger)
+		sigs := randomIncomingSigs(r, part, 6, 100)
+		for j, sp := range sigs {
+			// distinct origins, for the individual signatures to pass the
+			// filter of the processing
+			sp.origin = int32(j)
+		}
+		refStore := newStore(part, NewWilffBitset, new(fakeCons))
+		store := newStore(part, NewWilffBitset, new(fakeCons))
+		proc := newEvaluatorProcessing(part, new(fakeCons), nil, 0, false, 0, newEvaluatorStore(store), DefaultLogger).(*evaluatorProcessing)
+
+		var refTodos []*IncomingSig
+		var refPicks, picks []*IncomingSig
+		for len(sigs) > 0 || len(refTodos) > 0 {
+			// a few signatures arrive between two picks
+			for k := r.Intn(4); k > 0 && len(sigs) > 0; k-- {
+				refTodos = append(refTodos, sigs[0])
+				proc.Add(sigs[0])
+				sigs = sigs[1:]
+			}
+			if len(refTodos) == 0 {
+				continue
+			}
+			var best *IncomingSig
+			best, refTodos = refReadTodos(refTodos, newEvaluatorStore(refStore))
+			refPicks = append(refPicks, best)
+			if best != nil {
+				refStore.Store(best)
+			}
+
+			require.True(t, proc.hasTodos())
+			_, picked := proc.readTodos()
+			picks = append(picks, picked)
+			if picked != nil {
+				store.Store(picked)
+			}
+			require.Equal(t, len(refTodos), len(proc.todos))
+		}
+		require.Equal(t, refPicks, picks)
+	}
+}
+
+func TestProcessingSuppressedReasons(t *testing.T) {
+	n := 16
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	store.Store(fullIncomingSig(2))
+	store.Store(individualSig(3, 4, 0))
+	store.Store(&IncomingSig{level: 4, ms: newSig(bitsOf(8, 0, 1))})
+
+	proc := newEvaluatorProcessing(part, new(fakeCons), nil, 0, false, 0, newEvaluatorStore(store), DefaultLogger).(*evaluatorProcessing)
+	now := time.Now()
+	proc.now = func() time.Time { return now }
+
+	verified := individualSig(3, 4, 0)
+	verified.origin = 4
+	// the level 3 is completed by the aggregate, verified first
+	complete := &IncomingSig{origin: 5, level: 3, ms: newSig(bitsOf(4, 1, 2, 3))}
+	partial := &IncomingSig{origin: 6, level: 3, ms: newSig(bitsOf(4, 1, 2))}
+	for _, sp := range []*IncomingSig{
+		fullIncomingSig(2),
+		verified,
+		&IncomingSig{origin: 7, level: 4, ms: newSig(bitsOf(8, 0))},
+		&IncomingSig{origin: 8, level: 4, ms: newSig(bitsOf(8, 1, 2))},
+		&IncomingSig{origin: 9, level: 4},
+		complete,
+		partial,
+	} {
+		proc.Add(sp)
+	}
+	now = now.Add(10 * time.Millisecond)
+	_, best := proc.readTodos()
+	require.Equal(t, complete, best)
+	require.Len(t, proc.todos, 1)
+	require.Equal(t, partial, proc.todos[0].sig)
+	values := proc.Values()
+	require.Equal(t, 1.0, values["sigSuppressed_completeLevel"])
+	require.Equal(t, 1.0, values["sigSuppressed_verifiedIndividual"])
+	require.Equal(t, 1.0, values["sigSuppressed_superset"])
+	require.Equal(t, 1.0, values["sigSuppressed_noValue"])
+	require.Equal(t, 1.0, values["sigSuppressed_other"])
+	require.Equal(t, 5.0, values["sigSuppressed"])
+	require.Equal(t, 0.0, values["sigSuperseded"])
+	require.Equal(t, 10.0, values["sigQueueLatencyP50"])
+
+	// the partial aggregate loses its value while waiting
+	store.Store(best)
+	_, best = proc.readTodos()
+	require.Nil(t, best)
+	values = proc.Values()
+	require.Equal(t, 2.0, values["sigSuppressed_completeLevel"])
+	require.Equal(t, 6.0, values["sigSuppressed"])
+	require.Equal(t, 1.0, values["sigSuperseded"])
+	require.Equal(t, 1.0, values["sigCheckedCt"])
+
+	// the evaluators without reasons
+	proc = newEvaluatorProcessing(part, new(fakeCons), nil, 0, false, 0, NewCostEvaluator(&EvaluatorLevel{}, &VerifyCost{}), DefaultLogger).(*evaluatorProcessing)
+	proc.Add(fullIncomingSig(0))
+	_, best = proc.readTodos()
+	require.Nil(t, best)
+	require.Equal(t, 1.0, proc.Values()["sigSuppressed_other"])
+}
@@ -0,0 +1,115 @@
+package handel
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// progressWindow is the number of update periods a progress hint is part of
+// the estimate of the network's progress
+const progressWindow = 20
+
+// ProgressEstimate is the progress of the network estimated from the hints
+// of the packets received, see Config.GossipProgress.
+type ProgressEstimate struct {
+	// Own is the cardinality of our full signature
+	Own int
+	// Max and Median are the maximum and the median of the last cardinality
+	// of the full signature of the recent origins, zero if none
+	Max    int
+	Median int
+	// Origins is the number of recent origins the estimate is made of
+	Origins int
+}
+
+// progress keeps the last progress hint of each origin. It is guarded by the
+// lock of Handel.
+type progress struct {
+	origins map[int32]originProgress
+	// number of hints received
+	hints int
+}
+
+type originProgress struct {
+	card int
+	at   time.Time
+}
+
+func newProgress() *progress {
+	return &progress{origins: make(map[int32]originProgress)}
+}
+
+// observe records the hint of the origin, received at the given time
+func (p *progress) observe(origin int32, card int, now time.Time) {
+	p.hints++
+	p.origins[origin] = originProgress{card: card, at: now}
+}
+
+// estimate returns the estimate over the origins whose last hint was received
+// after the given time
+func (p *progress) estimate(since time.Time) ProgressEstimate {
+	var cards []int
+	for _, o := range p.origins {
+		if !o.at.Before(since) {
+			cards = append(cards, o.card)
+		}
+	}
+	if len(cards) == 0 {
+		return ProgressEstimate{}
+	}
+	sort.Ints(cards)
+	return ProgressEstimate{
+		Max:     cards[len(cards)-1],
+		Median:  cards[(len(cards)-1)/2],
+		Origins: len(cards),
+	}
+}
+
+// progressHint returns the hint of our progress carried by the packets: the
+// cardinality of our last full signature, capped to fit. The lock must be
+// held.
+func (h *Handel) progressHint() uint16 {
+	return uint16(min(h.guard.card, math.MaxUint16))
+}
+
+// observeProgress records the progress hint of the packet, if any. The lock
+// must be held.
+func (h *Handel) observeProgress(p *Packet) {
+	if !h.c.GossipProgress || p.Progress == 0 {
+		return
+	}
+	h.progress.observe(p.Origin, int(p.Progress), h.now())
+}
+
+// unsafeNetworkProgress is the unlocked version of NetworkProgress
+func (h *Handel) unsafeNetworkProgress() ProgressEstimate {
+	since := h.now().Add(-progressWindow * h.c.UpdatePeriod)
+	e := h.progress.estimate(since)
+	e.Own = h.guard.card
+	return e
+}
+
+// NetworkProgress returns the progress of the network estimated from the
+// hints received during the last update periods, see Config.GossipProgress.
+// It is empty but for our own progress if the hints are not gossiped.
+func (h *Handel) NetworkProgress() ProgressEstimate {
+	h.Lock()
+	defer h.Unlock()
+	return h.unsafeNetworkProgress()
+}
+
+// progressValues returns the estimate of the network's progress and the
+// number of hints received
+func (h *Handel) progressValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	e := h.unsafeNetworkProgress()
+	return map[string]float64{
+		"own":     float64(e.Own),
+		"max":     float64(e.Max),
+		"median":  float64(e.Median),
+		"origins": float64(e.Origins),
+		"hints":   float64(h.progress.hints),
+	}
+}
@@ -0,0 +1,110 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestNetworkProgress runs a node lagging behind its peers: it never starts,
+// so its full signature stays empty, while the hints of its peers tell it
+// they reached the threshold.
+func TestNetworkProgress(t *testing.T) {
+	n := 16
+	_, handels := FakeSetupWith(n, func(c *Config) { c.GossipProgress = true })
+	defer CloseHandels(handels)
+	lagging := handels[0]
+	// called once the lagging node handled the packet
+	hints := make(chan uint16, 1000)
+	unwrapNetwork(lagging.net).RegisterListener(ListenFunc(func(p *Packet) {
+		select {
+		case hints <- p.Progress:
+		default:
+		}
+	}))
+	for _, h := range handels[1:] {
+		h.Start()
+	}
+	for hint := uint16(0); int(hint) < lagging.threshold; {
+		select {
+		case hint = <-hints:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no peer reached the threshold")
+		}
+	}
+
+	e := lagging.NetworkProgress()
+	require.Zero(t, e.Own)
+	require.True(t, e.Max >= lagging.threshold, "%+v", e)
+	require.True(t, e.Median > 0 && e.Median <= e.Max, "%+v", e)
+	require.True(t, e.Origins > 0 && e.Origins < n)
+	values := lagging.progressValues()
+	require.Equal(t, float64(e.Origins), values["origins"])
+	require.True(t, values["hints"] >= values["origins"])
+
+	// the peers measure their own progress
+	require.True(t, handels[1].NetworkProgress().Own >= lagging.threshold)
+}
+
+func TestNetworkProgressWindow(t *testing.T) {
+	_, handels := FakeSetupWith(16, func(c *Config) { c.GossipProgress = true })
+	defer CloseHandels(handels)
+	h := handels[0]
+	clock := newFakeClock()
+	clock.use(h)
+	hint := func(origin int32, progress uint16) {
+		p := aggregatePacket(t, origin, 4, 8, 0)
+		p.Progress = progress
+		h.NewPacket(p)
+	}
+	period := h.c.UpdatePeriod
+
+	hint(8, 5)
+	clock.advance(10 * period)
+	hint(9, 3)
+	require.Equal(t, ProgressEstimate{Max: 5, Median: 3, Origins: 2}, h.NetworkProgress())
+	// the last hint of an origin replaces the previous one
+	hint(9, 4)
+	require.Equal(t, ProgressEstimate{Max: 5, Median: 4, Origins: 2}, h.NetworkProgress())
+	// no hint
+	hint(10, 0)
+	require.Equal(t, 2, h.NetworkProgress().Origins)
+
+	// the hints of origin 8 are too old
+	clock.advance(15 * period)
+	require.Equal(t, ProgressEstimate{Max: 4, Median: 4, Origins: 1}, h.NetworkProgress())
+	require.Equal(t, 3.0, h.progressValues()["hints"])
+
+	// the hint fits in the packet
+	h.guard.card = 70000
+	require.Equal(t, uint16(65535), h.progressHint())
+}
+
+func TestNetworkProgressDisabled(t *testing.T) {
+	_, handels := FakeSetup(16)
+	defer CloseHandels(handels)
+	h := handels[0]
+	// the hints received are ignored
+	p := aggregatePacket(t, 8, 4, 8, 0)
+	p.Progress = 5
+	h.NewPacket(p)
+	require.Equal(t, ProgressEstimate{}, h.NetworkProgress())
+	require.Zero(t, h.progress.hints)
+
+	// and none is sent
+	sent := make(chan *Packet, 100)
+	unwrapNetwork(handels[1].net).RegisterListener(ListenFunc(func(p *Packet) {
+		select {
+		case sent <- p:
+		default:
+		}
+	}))
+	h.Start()
+	select {
+	case p := <-sent:
+		require.Zero(t, p.Progress)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet sent")
+	}
+}
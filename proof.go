@@ -0,0 +1,162 @@
+package handel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ProofStore is implemented by the stores which can prove their best
+// multi-signatures were honestly formed, see Config.RetainProofMaterial.
+type ProofStore interface {
+	// ProofFor returns the verified signatures the best multi-signature of
+	// the level was combined from: their bitsets partition the bitset of the
+	// best, and their combination is the best.
+	ProofFor(level byte) ([]*MultiSignature, error)
+}
+
+// retainProofs makes the store keep the parts of its best multi-signatures
+func (r *store) retainProofs() {
+	r.Lock()
+	defer r.Unlock()
+	r.proofs = make(map[byte][]*MultiSignature)
+}
+
+// storeProof records the parts of the new best of the level of sp, as
+// combined by unsafeCheckMerge: the parts of the current best if sp was merged
+// with it, sp, then the individual signatures added.
+func (r *store) storeProof(sp *IncomingSig, best *MultiSignature) {
+	var parts []*MultiSignature
+	covered := sp.ms.BitSet.Clone()
+	if prev := r.m[sp.level]; prev != nil && !sp.ms.BitSet.AnyIntersect(prev.BitSet) {
+		parts = append(parts, r.proofs[sp.level]...)
+		covered = covered.Or(prev.BitSet)
+	}
+	parts = append(parts, sp.ms)
+	added := best.BitSet.Xor(covered)
+	for pos, ok := added.NextSet(0); ok; pos, ok = added.NextSet(pos + 1) {
+		parts = append(parts, r.individualSigs[sp.level][pos])
+	}
+
+	if r.sigBytes == 0 {
+		if sig, err := sp.ms.Signature.MarshalBinary(); err == nil {
+			r.sigBytes = len(sig)
+		}
+	}
+	r.proofBytes -= r.partsBytes(r.proofs[sp.level])
+	r.proofBytes += r.partsBytes(parts)
+	r.proofs[sp.level] = parts
+}
+
+// partsBytes returns the approximate size of the parts
+func (r *store) partsBytes(parts []*MultiSignature) int {
+	var size int
+	for _, ms := range parts {
+		size += (ms.BitLength()+7)/8 + r.sigBytes
+	}
+	return size
+}
+
+// ProofFor implements the ProofStore interface. It returns an error if the
+// proofs are not retained or if there is no signature for the level.
+func (r *store) ProofFor(level byte) ([]*MultiSignature, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.proofs == nil {
+		return nil, errors.New("handel: proof material not retained")
+	}
+	parts, ok := r.proofs[level]
+	if !ok {
+		return nil, fmt.Errorf("handel: no signature at level %d", level)
+	}
+	return append([]*MultiSignature(nil), parts...), nil
+}
+
+// Values reports the number of errors logged, the number of signatures
+// compared by the tie-break, and the number of parts retained for the proofs
+// and their approximate size if the proofs are retained.
+func (r *store) Values() map[string]float64 {
+	values := r.errors.Values()
+	r.Lock()
+	defer r.Unlock()
+	values["tieBreaks"] = float64(r.tieBreaks)
+	if r.proofs == nil {
+		return values
+	}
+	var parts int
+	for _, p := range r.proofs {
+		parts += len(p)
+	}
+	values["proofParts"] = float64(parts)
+	values["proofBytes"] = float64(r.proofBytes)
+	return values
+}
+
+// ProofFor returns the verified signatures the best multi-signature of the
+// level was combined from, see Config.RetainProofMaterial. Their bitsets are
+// relative to the level, as the one of the best, and can be checked with
+// VerifyDecomposition against the registry of the identities of the level.
+func (h *Handel) ProofFor(level byte) ([]*MultiSignature, error) {
+	store := h.store
+	if r, ok := store.(*ReportStore); ok {
+		store = r.SignatureStore
+	}
+	ps, ok := store.(ProofStore)
+	if !ok {
+		return nil, errors.New("handel: the store does not retain proofs")
+	}
+	return ps.ProofFor(level)
+}
+
+// VerifyDecomposition checks the claimed multi-signature was honestly formed
+// from the given parts: each part must be a valid multi-signature of the
+// message, their bitsets must partition the bitset of the claim, and their
+// combination must be the signature of the claim. The bitsets index the
+// identities of the registry.
+func VerifyDecomposition(msg []byte, reg Registry, cons Constructor, parts []*MultiSignature, claimed *MultiSignature) error {
+	if claimed == nil || len(parts) == 0 {
+		return errors.New("verify decomposition: nothing to verify")
+	}
+	n := claimed.BitLength()
+	if n != reg.Size() {
+		return errors.New("verify decomposition: inconsistent sizes")
+	}
+	// the bits covered by the parts so far, empty
+	union := claimed.BitSet.Xor(claimed.BitSet)
+	var sig Signature
+	for i, part := range parts {
+		if part.BitLength() != n {
+			return fmt.Errorf("verify decomposition: part %d: inconsistent sizes", i)
+		}
+		if part.Cardinality() == 0 {
+			return fmt.Errorf("verify decomposition: part %d: empty", i)
+		}
+		if part.AnyIntersect(union) {
+			return fmt.Errorf("verify decomposition: part %d: overlaps the others", i)
+		}
+		if err := VerifyMultiSignature(msg, part, reg, cons); err != nil {
+			return fmt.Errorf("verify decomposition: part %d: %s", i, err)
+		}
+		union = union.Or(part.BitSet)
+		if sig == nil {
+			sig = part.Signature
+		} else {
+			sig = sig.Combine(part.Signature)
+		}
+	}
+	if union.Cardinality() != claimed.Cardinality() || !claimed.IsSuperSet(union) {
+		return errors.New("verify decomposition: the parts don't cover the claim")
+	}
+	combined, err := sig.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	claim, err := claimed.Signature.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(combined, claim) {
+		return errors.New("verify decomposition: the parts don't combine to the claim")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package proof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Export bundles everything a verifier storing only the root of the key tree
+// needs to check a final multi-signature: the message, the multi-signature and
+// the multiproof of the public keys of its set bits.
+type Export struct {
+	Message  []byte
+	MultiSig *handel.MultiSignature
+	Proof    *MultiProof
+}
+
+// NewExport returns the export of the multi-signature over the message, with
+// the multiproof of the keys of its set bits taken from the tree.
+func NewExport(msg []byte, ms *handel.MultiSignature, tree *KeyTree) (*Export, error) {
+	proof, err := tree.MultiProof(ms.BitSet)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{
+		Message:  msg,
+		MultiSig: ms,
+		Proof:    proof,
+	}, nil
+}
+
+// Verify checks the keys are committed under the root and that the aggregate
+// signature is valid under their aggregation. The keys must be given in the
+// order of the set bits, and cons is used to aggregate them.
+func (e *Export) Verify(root [32]byte, keys []handel.PublicKey, cons handel.Constructor) error {
+	if err := VerifyMultiProof(root, e.MultiSig.BitSet, keys, e.Proof); err != nil {
+		return err
+	}
+	aggregate := cons.PublicKey()
+	for _, k := range keys {
+		aggregate = aggregate.Combine(k)
+	}
+	return aggregate.VerifySignature(e.Message, e.MultiSig.Signature)
+}
+
+// MarshalBinary returns the version, the length prefixed message, the length
+// prefixed multi-signature and the multiproof. Lengths are big endian uint32.
+func (e *Export) MarshalBinary() ([]byte, error) {
+	ms, err := e.MultiSig.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	proof, err := e.Proof.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteByte(Version)
+	binary.Write(&b, binary.BigEndian, uint32(len(e.Message)))
+	b.Write(e.Message)
+	binary.Write(&b, binary.BigEndian, uint32(len(ms)))
+	b.Write(ms)
+	b.Write(proof)
+	return b.Bytes(), nil
+}
+
+// Unmarshal reads an export from the given slice, using the *empty* signature
+// and bitset interface given, as handel.MultiSignature.Unmarshal does.
+func (e *Export) Unmarshal(buff []byte, s handel.Signature, nbs func(int) handel.BitSet) error {
+	r := bytes.NewReader(buff)
+	version, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != Version {
+		return fmt.Errorf("proof: unsupported export version %d", version)
+	}
+	msg, err := readChunk(r)
+	if err != nil {
+		return err
+	}
+	raw, err := readChunk(r)
+	if err != nil {
+		return err
+	}
+	ms := new(handel.MultiSignature)
+	if err := ms.Unmarshal(raw, s, nbs); err != nil {
+		return err
+	}
+	proof := new(MultiProof)
+	if err := proof.read(r, true); err != nil {
+		return err
+	}
+	e.Message = msg
+	e.MultiSig = ms
+	e.Proof = proof
+	return nil
+}
+
+func readChunk(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int(length) > r.Len() {
+		return nil, errors.New("proof: truncated export")
+	}
+	chunk := make([]byte, length)
+	r.Read(chunk)
+	return chunk, nil
+}
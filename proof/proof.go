@@ -0,0 +1,325 @@
+// Package proof commits to the public keys of a Handel registry with a Merkle
+// tree, so a verifier storing only the root can check that the public keys
+// used to verify a multi-signature are the ones committed under that root.
+//
+// A leaf is the SHA-256 hash of the index and the marshalled public key of an
+// identity, prefixed by 0x00. The leaf of an identity whose key is being
+// rotated, see handel.RotatingIdentity, commits to its previous key as well:
+// it is the SHA-256 hash of the leaf of its current key and its marshalled
+// previous key, prefixed by 0x02. An inner node is the SHA-256 hash of its two
+// children, prefixed by 0x01. The leaves are padded with zero hashes up to the
+// next power of two.
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Version is the version of the serialization of the proofs and exports
+const Version byte = 1
+
+const (
+	leafPrefix    byte = 0x00
+	nodePrefix    byte = 0x01
+	rotatedPrefix byte = 0x02
+)
+
+// LeafHash returns the leaf of the public key at the given index
+func LeafHash(index int, pub handel.PublicKey) ([32]byte, error) {
+	buff, err := marshalKey(pub)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	binary.Write(h, binary.BigEndian, uint32(index))
+	h.Write(buff)
+	var leaf [32]byte
+	copy(leaf[:], h.Sum(nil))
+	return leaf, nil
+}
+
+// IdentityLeafHash returns the leaf of the identity at the given index: the
+// leaf of its public key, or of both its keys if it has a previous one.
+func IdentityLeafHash(index int, id handel.Identity) ([32]byte, error) {
+	leaf, err := LeafHash(index, id.PublicKey())
+	prev := handel.PreviousPublicKey(id)
+	if err != nil || prev == nil {
+		return leaf, err
+	}
+	buff, err := marshalKey(prev)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	h := sha256.New()
+	h.Write([]byte{rotatedPrefix})
+	h.Write(leaf[:])
+	h.Write(buff)
+	copy(leaf[:], h.Sum(nil))
+	return leaf, nil
+}
+
+func marshalKey(pub handel.PublicKey) ([]byte, error) {
+	m, ok := pub.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.New("proof: public key is not marshallable")
+	}
+	return m.MarshalBinary()
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left[:])
+	h.Write(right[:])
+	var node [32]byte
+	copy(node[:], h.Sum(nil))
+	return node
+}
+
+// KeyTree is the Merkle tree of the public keys of a registry
+type KeyTree struct {
+	size int
+	// levels[0] are the padded leaves, the last level is the root
+	levels [][][32]byte
+}
+
+// BuildKeyTree returns the tree of the public keys of the registry, in the
+// order of the registry. The previous keys of the identities are committed as
+// well, see IdentityLeafHash.
+func BuildKeyTree(reg handel.Registry) (*KeyTree, error) {
+	n := reg.Size()
+	if n == 0 {
+		return nil, errors.New("proof: empty registry")
+	}
+	leaves := make([][32]byte, paddedSize(n))
+	for i := 0; i < n; i++ {
+		id, ok := reg.Identity(i)
+		if !ok {
+			return nil, fmt.Errorf("proof: no identity at index %d", i)
+		}
+		leaf, err := IdentityLeafHash(i, id)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	levels := [][][32]byte{leaves}
+	for current := leaves; len(current) > 1; {
+		next := make([][32]byte, len(current)/2)
+		for i := range next {
+			next[i] = nodeHash(current[2*i], current[2*i+1])
+		}
+		levels = append(levels, next)
+		current = next
+	}
+	return &KeyTree{size: n, levels: levels}, nil
+}
+
+// paddedSize returns the smallest power of two greater or equal to n
+func paddedSize(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// Root returns the root of the tree
+func (t *KeyTree) Root() [32]byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Size returns the number of public keys in the tree
+func (t *KeyTree) Size() int {
+	return t.size
+}
+
+// MultiProof holds the sibling hashes needed to recompute the root from the
+// leaves of the set bits of a bitset. The hashes are ordered level by level
+// from the leaves, and by index within a level.
+type MultiProof struct {
+	// Size is the number of public keys in the tree
+	Size   int
+	Hashes [][32]byte
+}
+
+// MultiProof returns the minimal set of sibling hashes proving the leaves of
+// the bits set in the bitset.
+func (t *KeyTree) MultiProof(bits handel.BitSet) (*MultiProof, error) {
+	indexes, err := setIndexes(bits, t.size)
+	if err != nil {
+		return nil, err
+	}
+	proof := &MultiProof{Size: t.size}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		known := make(map[int]bool, len(indexes))
+		for _, i := range indexes {
+			known[i] = true
+		}
+		for _, i := range indexes {
+			if !known[i^1] {
+				proof.Hashes = append(proof.Hashes, level[i^1])
+			}
+		}
+		indexes = parents(indexes)
+	}
+	return proof, nil
+}
+
+// setIndexes returns the indexes of the set bits of the bitset, checking it
+// is of the size of the tree and not empty.
+func setIndexes(bits handel.BitSet, size int) ([]int, error) {
+	if bits.BitLength() != size {
+		return nil, fmt.Errorf("proof: bitset of length %d for %d keys", bits.BitLength(), size)
+	}
+	var indexes []int
+	for i := 0; i < size; i++ {
+		if bits.Get(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, errors.New("proof: empty bitset")
+	}
+	return indexes, nil
+}
+
+// parents returns the sorted and unique indexes of the parents of the nodes
+func parents(indexes []int) []int {
+	var next []int
+	for _, i := range indexes {
+		if len(next) == 0 || next[len(next)-1] != i/2 {
+			next = append(next, i/2)
+		}
+	}
+	return next
+}
+
+// VerifyMultiProof checks that the public keys are the ones committed under
+// the root at the indexes of the bits set in the bitset. The keys must be
+// given in the order of the set bits.
+func VerifyMultiProof(root [32]byte, bits handel.BitSet, keys []handel.PublicKey, proof *MultiProof) error {
+	return verifyLeaves(root, bits, len(keys), func(j, i int) ([32]byte, error) {
+		return LeafHash(i, keys[j])
+	}, proof)
+}
+
+// VerifyIdentitiesProof checks that the keys of the identities, previous keys
+// included, are the ones committed under the root at the indexes of the bits
+// set in the bitset. The identities must be given in the order of the set
+// bits.
+func VerifyIdentitiesProof(root [32]byte, bits handel.BitSet, ids []handel.Identity, proof *MultiProof) error {
+	return verifyLeaves(root, bits, len(ids), func(j, i int) ([32]byte, error) {
+		return IdentityLeafHash(i, ids[j])
+	}, proof)
+}
+
+// verifyLeaves checks that the n leaves, given by their rank j among the set
+// bits and their index i, are the ones committed under the root.
+func verifyLeaves(root [32]byte, bits handel.BitSet, n int, leafOf func(j, i int) ([32]byte, error), proof *MultiProof) error {
+	indexes, err := setIndexes(bits, proof.Size)
+	if err != nil {
+		return err
+	}
+	if n != len(indexes) {
+		return fmt.Errorf("proof: %d keys for %d set bits", n, len(indexes))
+	}
+	nodes := make(map[int][32]byte, len(indexes))
+	for j, i := range indexes {
+		leaf, err := leafOf(j, i)
+		if err != nil {
+			return err
+		}
+		nodes[i] = leaf
+	}
+	hashes := proof.Hashes
+	for width := paddedSize(proof.Size); width > 1; width /= 2 {
+		// consume the siblings in the same order as they were produced
+		for _, i := range indexes {
+			if _, ok := nodes[i^1]; ok {
+				continue
+			}
+			if len(hashes) == 0 {
+				return errors.New("proof: not enough hashes")
+			}
+			nodes[i^1] = hashes[0]
+			hashes = hashes[1:]
+		}
+		next := make(map[int][32]byte, len(nodes)/2)
+		for i := range nodes {
+			if i%2 == 0 {
+				next[i/2] = nodeHash(nodes[i], nodes[i+1])
+			}
+		}
+		nodes = next
+		indexes = parents(indexes)
+	}
+	if len(hashes) != 0 {
+		return errors.New("proof: too many hashes")
+	}
+	if nodes[0] != root {
+		return errors.New("proof: invalid root")
+	}
+	return nil
+}
+
+// MarshalBinary returns the version, the size, the number of hashes and the
+// hashes, all integers being big endian uint32.
+func (p *MultiProof) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte(Version)
+	binary.Write(&b, binary.BigEndian, uint32(p.Size))
+	binary.Write(&b, binary.BigEndian, uint32(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		b.Write(h[:])
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (p *MultiProof) UnmarshalBinary(buff []byte) error {
+	return p.read(bytes.NewReader(buff), true)
+}
+
+// read reads the proof from the reader, and checks the reader is consumed if
+// asked to.
+func (p *MultiProof) read(r *bytes.Reader, full bool) error {
+	version, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != Version {
+		return fmt.Errorf("proof: unsupported version %d", version)
+	}
+	var size, count uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	if int(count)*32 > r.Len() {
+		return errors.New("proof: truncated hashes")
+	}
+	var hashes [][32]byte
+	if count > 0 {
+		hashes = make([][32]byte, count)
+	}
+	for i := range hashes {
+		r.Read(hashes[i][:])
+	}
+	if full && r.Len() != 0 {
+		return errors.New("proof: trailing bytes")
+	}
+	p.Size = int(size)
+	p.Hashes = hashes
+	return nil
+}
@@ -0,0 +1,241 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	mathRand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKey is a marshallable public key
+type fakeKey []byte
+
+func (f fakeKey) MarshalBinary() ([]byte, error)                 { return f, nil }
+func (f fakeKey) VerifySignature([]byte, handel.Signature) error { return nil }
+func (f fakeKey) Combine(handel.PublicKey) handel.PublicKey      { return f }
+func (f fakeKey) String() string                                 { return string(f) }
+
+func fakeRegistry(n int) handel.Registry {
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], uint64(i))
+		ids[i] = handel.NewStaticIdentity(int32(i), "", fakeKey(key[:]))
+	}
+	return handel.NewArrayRegistry(ids)
+}
+
+// setKeys returns the public keys of the set bits
+func setKeys(reg handel.Registry, bits handel.BitSet) []handel.PublicKey {
+	var keys []handel.PublicKey
+	for i := 0; i < bits.BitLength(); i++ {
+		if bits.Get(i) {
+			id, _ := reg.Identity(i)
+			keys = append(keys, id.PublicKey())
+		}
+	}
+	return keys
+}
+
+func bitset(n int, set ...int) handel.BitSet {
+	bs := handel.NewWilffBitset(n)
+	for _, i := range set {
+		bs.Set(i, true)
+	}
+	return bs
+}
+
+func TestMultiProofRandom(t *testing.T) {
+	r := mathRand.New(mathRand.NewSource(42))
+	for i, n := range []int{1, 2, 3, 5, 8, 13, 64, 100, 257} {
+		t.Logf(" -- test %d -- ", i)
+		reg := fakeRegistry(n)
+		tree, err := BuildKeyTree(reg)
+		require.NoError(t, err)
+		for j := 0; j < 20; j++ {
+			bits := handel.NewWilffBitset(n)
+			bits.Set(r.Intn(n), true)
+			for k := 0; k < n; k++ {
+				if r.Intn(3) == 0 {
+					bits.Set(k, true)
+				}
+			}
+			proof, err := tree.MultiProof(bits)
+			require.NoError(t, err)
+			require.NoError(t, VerifyMultiProof(tree.Root(), bits, setKeys(reg, bits), proof))
+
+			// the serialization is deterministic and round trips
+			buff, err := proof.MarshalBinary()
+			require.NoError(t, err)
+			proof2 := new(MultiProof)
+			require.NoError(t, proof2.UnmarshalBinary(buff))
+			require.Equal(t, proof, proof2)
+			buff2, err := proof2.MarshalBinary()
+			require.NoError(t, err)
+			require.Equal(t, buff, buff2)
+		}
+	}
+}
+
+func TestMultiProofMinimal(t *testing.T) {
+	var tests = []struct {
+		n      int
+		set    []int
+		hashes int
+	}{
+		// a single leaf needs one sibling per level
+		{16, []int{5}, 4},
+		// a full tree needs none
+		{16, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, 0},
+		// siblings share their path
+		{16, []int{4, 5}, 3},
+		// only the padding is needed
+		{5, []int{0, 1, 2, 3, 4}, 2},
+		{1, []int{0}, 0},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		tree, err := BuildKeyTree(fakeRegistry(test.n))
+		require.NoError(t, err)
+		proof, err := tree.MultiProof(bitset(test.n, test.set...))
+		require.NoError(t, err)
+		require.Len(t, proof.Hashes, test.hashes)
+	}
+}
+
+func TestMultiProofRotated(t *testing.T) {
+	n := 8
+	reg := fakeRegistry(n)
+	plain, err := BuildKeyTree(reg)
+	require.NoError(t, err)
+
+	// the identity 3 rotated its key
+	ids, _ := reg.Identities(0, n)
+	ids = append([]handel.Identity(nil), ids...)
+	ids[3] = handel.NewRotatingIdentity(3, []string{"127.0.0.1:3003"}, fakeKey("new"), ids[3].PublicKey())
+	rotated, err := BuildKeyTree(handel.NewArrayRegistry(ids))
+	require.NoError(t, err)
+	require.NotEqual(t, plain.Root(), rotated.Root())
+
+	bits := bitset(n, 2, 3)
+	proof, err := rotated.MultiProof(bits)
+	require.NoError(t, err)
+	require.NoError(t, VerifyIdentitiesProof(rotated.Root(), bits, ids[2:4], proof))
+	// the current key alone does not match the leaf
+	require.Error(t, VerifyMultiProof(rotated.Root(), bits, []handel.PublicKey{ids[2].PublicKey(), fakeKey("new")}, proof))
+	// nor another previous key
+	other := handel.NewRotatingIdentity(3, []string{"127.0.0.1:3003"}, fakeKey("new"), fakeKey("old"))
+	require.Error(t, VerifyIdentitiesProof(rotated.Root(), bits, []handel.Identity{ids[2], other}, proof))
+
+	// the identities without previous key have the same leaves
+	plainProof, err := plain.MultiProof(bits)
+	require.NoError(t, err)
+	plainIDs, _ := reg.Identities(2, 4)
+	require.NoError(t, VerifyIdentitiesProof(plain.Root(), bits, plainIDs, plainProof))
+}
+
+func TestMultiProofTampered(t *testing.T) {
+	n := 33
+	reg := fakeRegistry(n)
+	tree, err := BuildKeyTree(reg)
+	require.NoError(t, err)
+	bits := bitset(n, 0, 3, 4, 17, 32)
+	keys := setKeys(reg, bits)
+	proof, err := tree.MultiProof(bits)
+	require.NoError(t, err)
+	require.NoError(t, VerifyMultiProof(tree.Root(), bits, keys, proof))
+
+	// a key that is not committed under the root
+	tampered := append([]handel.PublicKey{}, keys...)
+	tampered[2] = fakeKey("not a key")
+	require.Error(t, VerifyMultiProof(tree.Root(), bits, tampered, proof))
+
+	// a committed key at the wrong index
+	tampered[2] = keys[1]
+	require.Error(t, VerifyMultiProof(tree.Root(), bits, tampered, proof))
+
+	// a modified sibling hash
+	hashes := append([][32]byte{}, proof.Hashes...)
+	hashes[1][0] ^= 1
+	require.Error(t, VerifyMultiProof(tree.Root(), bits, keys, &MultiProof{Size: n, Hashes: hashes}))
+
+	// missing and extra hashes
+	require.Error(t, VerifyMultiProof(tree.Root(), bits, keys, &MultiProof{Size: n, Hashes: proof.Hashes[1:]}))
+	extra := append(append([][32]byte{}, proof.Hashes...), [32]byte{})
+	require.Error(t, VerifyMultiProof(tree.Root(), bits, keys, &MultiProof{Size: n, Hashes: extra}))
+
+	// another bitset with the same keys
+	require.Error(t, VerifyMultiProof(tree.Root(), bitset(n, 0, 3, 4, 17, 31), keys, proof))
+
+	// an empty bitset proves nothing
+	_, err = tree.MultiProof(bitset(n))
+	require.Error(t, err)
+
+	// unknown version
+	buff, err := proof.MarshalBinary()
+	require.NoError(t, err)
+	buff[0] = Version + 1
+	require.Error(t, new(MultiProof).UnmarshalBinary(buff))
+}
+
+// TestExportFlow runs a Handel aggregation with bn256 keys and verifies the
+// export of its final signature against the root of the key tree.
+func TestExportFlow(t *testing.T) {
+	n := 8
+	msg := []byte("Sun is Shining...")
+	cons := bn256.NewConstructor()
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		sk, pk, err := bn256.NewKeyPair(rand.Reader)
+		require.NoError(t, err)
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(int32(i), "", pk)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	nets := handeltest.NewBus(n).Networks()
+	config := handel.DefaultConfig(n)
+	handels := make([]*handel.Handel, n)
+	for i := range handels {
+		sig, err := secrets[i].Sign(msg, rand.Reader)
+		require.NoError(t, err)
+		handels[i] = handel.NewHandel(nets[i], reg, ids[i], cons, msg, sig, config)
+		defer handels[i].Close()
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	var ms handel.MultiSignature
+	select {
+	case ms = <-handels[0].FinalSignatures():
+	case <-time.After(10 * time.Second):
+		t.Fatal("no final signature")
+	}
+
+	tree, err := BuildKeyTree(reg)
+	require.NoError(t, err)
+	export, err := NewExport(msg, &ms, tree)
+	require.NoError(t, err)
+	buff, err := export.MarshalBinary()
+	require.NoError(t, err)
+
+	// the verifier only knows the root, the export and the keys of the set bits
+	received := new(Export)
+	require.NoError(t, received.Unmarshal(buff, cons.Signature(), handel.DefaultBitSet))
+	buff2, err := received.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(buff, buff2))
+	keys := setKeys(reg, received.MultiSig.BitSet)
+	require.NoError(t, received.Verify(tree.Root(), keys, cons))
+
+	// a signature over another message is rejected
+	received.Message = []byte("Moon is Rising...")
+	require.Error(t, received.Verify(tree.Root(), keys, cons))
+}
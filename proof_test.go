@@ -0,0 +1,88 @@
+package handel_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProofDecomposition checks the decompositions of the levels of lossy
+// runs, where the holes of the aggregates are filled by individual signatures.
+func TestProofDecomposition(t *testing.T) {
+	n := 32
+	// the runs until one merges individual signatures into an aggregate
+	var merged int
+	for seed := int64(1); merged == 0 && seed <= 10; seed++ {
+		merged = proofRun(t, n, seed)
+	}
+	require.NotZero(t, merged)
+}
+
+// proofRun verifies the decompositions of a run dropping a fraction of the
+// packets, and tampered ones. It returns the number of decompositions
+// merging individual signatures with other parts.
+func proofRun(t *testing.T, n int, seed int64) int {
+	c := handeltest.NewCluster(n, func(id int32, c *handel.Config) {
+		c.RetainProofMaterial = true
+	})
+	defer c.Stop()
+	c.Bus.SetHook(handeltest.DropRate(0.3, seed))
+	c.Start()
+	c.WaitThreshold(t, n*3/4, 10*time.Second)
+	c.Stop()
+
+	var merged int
+	for i, h := range c.Handels {
+		best := make(map[byte]*handel.MultiSignature)
+		h.ForEachSignature(func(level byte, kind handel.SigKind, pos int, ms *handel.MultiSignature) bool {
+			if kind == handel.SigBest {
+				best[level] = ms
+			}
+			return true
+		})
+		for _, lvl := range h.Partitioner.Levels() {
+			claimed, ok := best[byte(lvl)]
+			if !ok {
+				continue
+			}
+			parts, err := h.ProofFor(byte(lvl))
+			require.NoError(t, err)
+			ids, err := h.Partitioner.IdentitiesAt(lvl)
+			require.NoError(t, err)
+			reg := handel.NewArrayRegistry(ids)
+			require.NoError(t, handel.VerifyDecomposition(c.Msg, reg, c.Cons, parts, claimed), "node %d level %d", i, lvl)
+			if len(parts) < 2 {
+				continue
+			}
+			for _, p := range parts {
+				if p.Cardinality() == 1 {
+					merged++
+					break
+				}
+			}
+			// a part signed by someone else
+			tampered := append([]*handel.MultiSignature(nil), parts...)
+			tampered[0] = &handel.MultiSignature{BitSet: parts[0].BitSet, Signature: parts[1].Signature}
+			require.Error(t, handel.VerifyDecomposition(c.Msg, reg, c.Cons, tampered, claimed))
+			// a missing part
+			require.Error(t, handel.VerifyDecomposition(c.Msg, reg, c.Cons, parts[1:], claimed))
+			// a part twice
+			require.Error(t, handel.VerifyDecomposition(c.Msg, reg, c.Cons, append(parts, parts[0]), claimed))
+		}
+	}
+
+	values := handel.NewReportHandel(c.Handels[0]).Store().Values()
+	require.NotZero(t, values["proofParts"])
+	require.NotZero(t, values["proofBytes"])
+	return merged
+}
+
+func TestProofNotRetained(t *testing.T) {
+	c := handeltest.NewCluster(4, nil)
+	defer c.Stop()
+	_, err := c.Handels[0].ProofFor(0)
+	require.Error(t, err)
+}
@@ -0,0 +1,278 @@
+package handel
+
+import (
+	"errors"
+	mathBits "math/bits"
+	"strconv"
+	"strings"
+)
+
+// RawBitSet implements a BitSet with a plain slice of bytes, the bit i being
+// the bit i%8 of the byte i/8. Unlike WilffBitSet, its binary representation
+// is its bytes as is, without any length: a bitset unmarshals the bytes of a
+// bitset of its own length, and takes a length of 8 bits per byte otherwise.
+// The bitsets of another length, as the ones of Config.TolerantLevels, are
+// then only recognised by their number of bytes.
+type RawBitSet struct {
+	b []byte
+	l int
+}
+
+// NewRawBitset returns a BitSet of the given length backed by a slice of
+// bytes. It can be used as Config.NewBitSet.
+func NewRawBitset(length int) BitSet {
+	return &RawBitSet{
+		b: make([]byte, rawBytes(length)),
+		l: length,
+	}
+}
+
+// rawBytes returns the number of bytes of a bitset of the given length
+func rawBytes(length int) int {
+	return (length + 7) / 8
+}
+
+// rawOf returns the bitset as a RawBitSet, copied if it is another
+// implementation
+func rawOf(bs BitSet) *RawBitSet {
+	if r, ok := bs.(*RawBitSet); ok {
+		return r
+	}
+	r := NewRawBitset(bs.BitLength()).(*RawBitSet)
+	for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+		r.Set(i, true)
+	}
+	return r
+}
+
+// BitLength implements the BitSet interface
+func (r *RawBitSet) BitLength() int {
+	return r.l
+}
+
+// Cardinality implements the BitSet interface
+func (r *RawBitSet) Cardinality() int {
+	var card int
+	for _, b := range r.b {
+		card += mathBits.OnesCount8(b)
+	}
+	return card
+}
+
+// Set implements the BitSet interface
+func (r *RawBitSet) Set(idx int, status bool) {
+	if !r.inBound(idx) {
+		panic("bitset: set out of bounds")
+	}
+	if status {
+		r.b[idx/8] |= 1 << uint(idx%8)
+	} else {
+		r.b[idx/8] &^= 1 << uint(idx%8)
+	}
+}
+
+// Get implements the BitSet interface
+func (r *RawBitSet) Get(idx int) bool {
+	if !r.inBound(idx) {
+		panic("bitset: get out of bounds")
+	}
+	return r.b[idx/8]&(1<<uint(idx%8)) != 0
+}
+
+func (r *RawBitSet) inBound(idx int) bool {
+	return !(idx < 0 || idx >= r.l)
+}
+
+// sorted returns r and b2 as RawBitSets, the longest first
+func (r *RawBitSet) sorted(b2 BitSet) (*RawBitSet, *RawBitSet) {
+	r2 := rawOf(b2)
+	if r2.l > r.l {
+		return r2, r
+	}
+	return r, r2
+}
+
+// Or implements the BitSet interface. The union has the length of the longest
+// bitset.
+func (r *RawBitSet) Or(b2 BitSet) BitSet {
+	long, short := r.sorted(b2)
+	res := long.Clone().(*RawBitSet)
+	for i, b := range short.b {
+		res.b[i] |= b
+	}
+	return res
+}
+
+// And implements the BitSet interface. The intersection has the length of the
+// shortest bitset.
+func (r *RawBitSet) And(b2 BitSet) BitSet {
+	long, short := r.sorted(b2)
+	res := short.Clone().(*RawBitSet)
+	for i := range res.b {
+		res.b[i] &= long.b[i]
+	}
+	return res
+}
+
+// Xor implements the BitSet interface. The symmetric difference has the
+// length of the longest bitset.
+func (r *RawBitSet) Xor(b2 BitSet) BitSet {
+	long, short := r.sorted(b2)
+	res := long.Clone().(*RawBitSet)
+	for i, b := range short.b {
+		res.b[i] ^= b
+	}
+	return res
+}
+
+// Clone implements the BitSet interface
+func (r *RawBitSet) Clone() BitSet {
+	b := make([]byte, len(r.b))
+	copy(b, r.b)
+	return &RawBitSet{b: b, l: r.l}
+}
+
+// IsSuperSet implements the BitSet interface
+func (r *RawBitSet) IsSuperSet(b2 BitSet) bool {
+	r2 := rawOf(b2)
+	for i, b := range r2.b {
+		if i >= len(r.b) {
+			if b != 0 {
+				return false
+			}
+			continue
+		}
+		if b&^r.b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary implements the go Marshaler interface. It returns the
+// ceil(BitLength/8) bytes of the bitset.
+func (r *RawBitSet) MarshalBinary() ([]byte, error) {
+	buff := make([]byte, len(r.b))
+	copy(buff, r.b)
+	return buff, nil
+}
+
+// UnmarshalBinary implements the go Marshaler interface. The bitset keeps its
+// length if the buffer has its number of bytes, and has a length of 8 bits
+// per byte otherwise. It returns an error if a bit beyond the length is set.
+func (r *RawBitSet) UnmarshalBinary(buff []byte) error {
+	if len(buff) != len(r.b) {
+		r.b = make([]byte, len(buff))
+		r.l = 8 * len(buff)
+	}
+	if pad := r.l % 8; pad != 0 && buff[len(buff)-1]>>uint(pad) != 0 {
+		return errors.New("bitset: bit set beyond the length")
+	}
+	copy(r.b, buff)
+	return nil
+}
+
+func (r *RawBitSet) String() string {
+	var s strings.Builder
+	s.WriteString("{")
+	for i, ok := r.NextSet(0); ok; i, ok = r.NextSet(i + 1) {
+		if s.Len() > 1 {
+			s.WriteString(",")
+		}
+		s.WriteString(strconv.Itoa(i))
+	}
+	s.WriteString("}")
+	return s.String()
+}
+
+// All implements the BitSet interface
+func (r *RawBitSet) All() bool {
+	return r.Cardinality() == r.l
+}
+
+// None implements the BitSet interface
+func (r *RawBitSet) None() bool {
+	for _, b := range r.b {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Any implements the BitSet interface
+func (r *RawBitSet) Any() bool {
+	return !r.None()
+}
+
+// NextSet implements the BitSet interface
+func (r *RawBitSet) NextSet(i int) (int, bool) {
+	if i < 0 {
+		i = 0
+	}
+	if i >= r.l {
+		return 0, false
+	}
+	idx := i / 8
+	if b := r.b[idx] >> uint(i%8); b != 0 {
+		return i + mathBits.TrailingZeros8(b), true
+	}
+	for idx++; idx < len(r.b); idx++ {
+		if r.b[idx] != 0 {
+			return 8*idx + mathBits.TrailingZeros8(r.b[idx]), true
+		}
+	}
+	return 0, false
+}
+
+// IntersectionCardinality implements the BitSet interface
+func (r *RawBitSet) IntersectionCardinality(b2 BitSet) int {
+	return r.AndCardinality(b2)
+}
+
+// OrCardinality implements the BitSet interface
+func (r *RawBitSet) OrCardinality(b2 BitSet) int {
+	long, short := r.sorted(b2)
+	var card int
+	for i, b := range long.b {
+		if i < len(short.b) {
+			b |= short.b[i]
+		}
+		card += mathBits.OnesCount8(b)
+	}
+	return card
+}
+
+// AndCardinality implements the BitSet interface
+func (r *RawBitSet) AndCardinality(b2 BitSet) int {
+	long, short := r.sorted(b2)
+	var card int
+	for i, b := range short.b {
+		card += mathBits.OnesCount8(b & long.b[i])
+	}
+	return card
+}
+
+// DifferenceCardinality implements the BitSet interface
+func (r *RawBitSet) DifferenceCardinality(b2 BitSet) int {
+	r2 := rawOf(b2)
+	var card int
+	for i, b := range r.b {
+		if i < len(r2.b) {
+			b &^= r2.b[i]
+		}
+		card += mathBits.OnesCount8(b)
+	}
+	return card
+}
+
+// AnyIntersect implements the BitSet interface
+func (r *RawBitSet) AnyIntersect(b2 BitSet) bool {
+	long, short := r.sorted(b2)
+	for i, b := range short.b {
+		if b&long.b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,10 @@
 package handel
 
+import (
+	"strconv"
+	"sync/atomic"
+)
+
 // ReportHandel holds a handel struct but modifies it so it is able to issue
 // some stats.
 type ReportHandel struct {
@@ -32,12 +37,92 @@ func (r *ReportHandel) Values() map[string]float64 {
 	for k, v := range storeValues {
 		merged["store_"+k] = float64(v)
 	}
+	for k, v := range r.Handel.actorStats.Values() {
+		merged["actors_"+k] = v
+	}
+	for k, v := range r.Handel.starvationValues() {
+		merged["starvation_"+k] = v
+	}
+	for k, v := range r.Handel.efficiency.Values() {
+		merged["efficiency_"+k] = v
+	}
+	for k, v := range r.Handel.resendValues() {
+		merged["resend_"+k] = v
+	}
+	for k, v := range r.Handel.updateValues() {
+		merged["update_"+k] = v
+	}
+	for k, v := range r.Handel.fanoutValues() {
+		merged["fanout_"+k] = v
+	}
+	for k, v := range r.Handel.invariantValues() {
+		merged["invariants_"+k] = v
+	}
+	for k, v := range r.Handel.shedValues() {
+		merged["shed_"+k] = v
+	}
+	for k, v := range r.Handel.stalenessValues() {
+		merged["staleness_"+k] = v
+	}
+	for k, v := range r.Handel.negotiationValues() {
+		merged["negotiation_"+k] = v
+	}
+	for k, v := range r.Handel.observabilityValues() {
+		merged["observability_"+k] = v
+	}
+	for k, v := range r.Handel.unresponsiveValues() {
+		merged["unresponsive_"+k] = v
+	}
+	for k, v := range r.Handel.progressValues() {
+		merged["progress_"+k] = v
+	}
+	for k, v := range r.Handel.completionValues() {
+		merged["completion_"+k] = v
+	}
+	for k, v := range r.Handel.remapValues() {
+		merged["remap_"+k] = v
+	}
+	if r.Handel.c.PreStart != nil {
+		for k, v := range r.Handel.c.PreStart.Values() {
+			merged["prestart_"+k] = v
+		}
+	}
+	for k, v := range asReporter(r.Handel.Partitioner).Values() {
+		merged["partitioner_"+k] = v
+	}
+	if len(r.Handel.c.Groups) > 0 {
+		for g, ct := range r.GroupContributions() {
+			merged["group_"+strconv.Itoa(g)] = float64(ct)
+		}
+	}
 	return merged
 }
 
-// Network returns the Network reporter interface
+// noValues is the Reporter of a component that reports nothing
+type noValues struct{}
+
+func (noValues) Values() map[string]float64 {
+	return map[string]float64{}
+}
+
+// asReporter returns the component as a Reporter if it implements it, or a
+// Reporter without any value otherwise.
+func asReporter(component interface{}) Reporter {
+	if r, ok := component.(Reporter); ok {
+		return r
+	}
+	return noValues{}
+}
+
+// Network returns the Network reporter interface. It reports no value if the
+// network does not implement Reporter.
 func (r *ReportHandel) Network() Reporter {
-	return r.Handel.net.(Reporter)
+	return asReporter(r.Handel.net)
+}
+
+// Actors returns the reporter of the execution time of the actors
+func (r *ReportHandel) Actors() Reporter {
+	return r.Handel.actorStats
 }
 
 // Store returns the Store reporter interface
@@ -45,9 +130,10 @@ func (r *ReportHandel) Store() Reporter {
 	return r.Handel.store.(*ReportStore)
 }
 
-// Processing returns the Store reporter interface
+// Processing returns the Processing reporter interface. It reports no value if
+// the processing does not implement Reporter.
 func (r *ReportHandel) Processing() Reporter {
-	return r.Handel.proc.(Reporter)
+	return asReporter(r.Handel.proc)
 }
 
 // ReportStore is a Store that can report some statistics about the storage
@@ -66,22 +152,29 @@ func newReportStore(s SignatureStore) SignatureStore {
 }
 
 // Store overload the signatureStore interface's method.
-func (r *ReportStore) Store(sp *incomingSig) *MultiSignature {
+func (r *ReportStore) Store(sp *IncomingSig) *MultiSignature {
 	ms := r.SignatureStore.Store(sp)
 	if ms != nil {
-		r.sucessReplaced++
+		atomic.AddInt64(&r.sucessReplaced, 1)
 	} else {
-		r.replacedTrial++
+		atomic.AddInt64(&r.replacedTrial, 1)
 	}
 	return ms
 }
 
-// Values implements the simul/monitor/counterIO interface
+// evaluateReason implements the explainingEvaluator interface, with the
+// reasons of the wrapped store if it gives them
+func (r *ReportStore) evaluateReason(sp *IncomingSig) (int, rejection) {
+	return evaluateReason(r.SignatureStore, sp)
+}
+
+// Values implements the simul/monitor/counterIO interface. The values of the
+// wrapped store are included if it implements Reporter.
 func (r *ReportStore) Values() map[string]float64 {
-	return map[string]float64{
-		// how many times did we successfully replaced a signature
-		"successReplace": float64(r.sucessReplaced),
-		// how many times did we tried to
-		"replaceTrial": float64(r.replacedTrial),
-	}
+	values := asReporter(r.SignatureStore).Values()
+	// how many times did we successfully replaced a signature
+	values["successReplace"] = float64(atomic.LoadInt64(&r.sucessReplaced))
+	// how many times did we tried to
+	values["replaceTrial"] = float64(atomic.LoadInt64(&r.replacedTrial))
+	return values
 }
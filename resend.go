@@ -0,0 +1,73 @@
+package handel
+
+import (
+	"encoding/binary"
+	"io"
+	mathRand "math/rand"
+	"time"
+)
+
+// sentSig is the memory of the last signature sent to a peer of a level, kept
+// by the level for each of its peers
+type sentSig struct {
+	// size of the signature of the level when sent, see level.sendSigSize,
+	// -1 if none
+	card int
+	// true if the peer must be contacted in the current rotation
+	pending bool
+	// time after which the same signature can be sent again
+	resend time.Time
+}
+
+// resendFilter skips the sends of a signature to a peer that already received
+// it at this level, until its resend time: a signature is the same as long as
+// the size of the level did not grow, see level.sendSigSize. The memory of the
+// sends is kept by the levels, one entry per peer. It is only used with
+// Handel's lock held.
+type resendFilter struct {
+	after time.Duration
+	rnd   *mathRand.Rand
+	// number of sends skipped
+	skipped int
+	// number of signatures sent again to a peer, once the resend time elapsed
+	identical int
+}
+
+func newResendFilter(after time.Duration, r io.Reader) *resendFilter {
+	var seed int64
+	if err := binary.Read(r, binary.BigEndian, &seed); err != nil {
+		panic(err)
+	}
+	return &resendFilter{after: after, rnd: mathRand.New(mathRand.NewSource(seed))}
+}
+
+// skipper returns the function telling if the signature of the level must be
+// skipped for the peer at the given position in the level, and recording the
+// resend time otherwise. With a negative resend delay, nothing is skipped.
+func (f *resendFilter) skipper(l *level, now time.Time) func(pos int) bool {
+	return func(pos int) bool {
+		last := &l.sent[pos]
+		if l.sendSigSize <= last.card {
+			if f.after >= 0 && now.Before(last.resend) {
+				f.skipped++
+				return true
+			}
+			f.identical++
+		}
+		if f.after >= 0 {
+			last.resend = now.Add(f.after + time.Duration(f.rnd.Int63n(int64(f.after)+1)))
+		}
+		return false
+	}
+}
+
+// resendValues returns the number of sends skipped and of identical
+// signatures sent again.
+func (h *Handel) resendValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	return map[string]float64{
+		"skipped":   float64(h.resend.skipped),
+		"identical": float64(h.resend.identical),
+	}
+}
@@ -0,0 +1,190 @@
+package handel
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// identicalResends returns the number of packets sent to a peer at a level
+// with the same payload as the previous one
+func identicalResends(net *recordNetwork) int {
+	last := make(map[[2]int][]byte)
+	identical := 0
+	for _, s := range net.to() {
+		key := [2]int{int(s.p.Level), int(s.to)}
+		if prev, sent := last[key]; sent && bytes.Equal(prev, s.p.MultiSig) {
+			identical++
+		}
+		last[key] = s.p.MultiSig
+	}
+	return identical
+}
+
+// deliveredPeers returns the number of pairs of level and peer to which a
+// packet was delivered. The first packet of each pair is lost if lossy is set.
+func deliveredPeers(net *recordNetwork, lossy bool) int {
+	sent := make(map[[2]int]int)
+	delivered := 0
+	for _, s := range net.to() {
+		key := [2]int{int(s.p.Level), int(s.to)}
+		sent[key]++
+		if lossy && sent[key] == 2 || !lossy && sent[key] == 1 {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// stalledHandel returns a Handel whose levels are all started, as if none of
+// its peers answered.
+func stalledHandel(t *testing.T, n int, net Network, after time.Duration) *Handel {
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{StarvationCheck: 1, UpdateCount: 2, ResendIdenticalAfter: after}
+	h := NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	for _, lvl := range h.levels {
+		lvl.setStarted()
+	}
+	return h
+}
+
+func TestResendIdenticalStalled(t *testing.T) {
+	n := 16
+	var tests = []struct {
+		after time.Duration
+		// expected number of identical resends
+		identical int
+	}{
+		// the whole candidate sets are contacted twice before the levels are
+		// starved, all the resends are identical
+		{-1, n - 1},
+		// the resends are all skipped
+		{time.Hour, 0},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		net := new(recordNetwork)
+		h := stalledHandel(t, n, net, test.after)
+		for tick := 0; tick < 100; tick++ {
+			h.periodicUpdate()
+		}
+		require.Equal(t, test.identical, identicalResends(net))
+		require.Equal(t, test.identical, h.resend.identical)
+		if test.after > 0 {
+			require.True(t, h.resend.skipped > 0)
+			// the candidate sets are not counted as contacted twice, the
+			// levels are not starved yet
+			require.Len(t, h.starved, 0)
+		} else {
+			require.Len(t, h.starved, len(h.levels))
+		}
+		require.Equal(t, n-1, deliveredPeers(net, false))
+	}
+}
+
+func TestResendIdenticalRandomized(t *testing.T) {
+	h := stalledHandel(t, 16, new(recordNetwork), time.Hour)
+	h.periodicUpdate()
+	h.periodicUpdate()
+	// the two peers contacted at the top level on a same tick have different
+	// resend times
+	top := h.levels[h.Partitioner.MaxLevel()]
+	require.Len(t, top.sent, 8)
+	require.NotEqual(t, top.sent[0].resend, top.sent[1].resend)
+	for _, s := range top.sent[:4] {
+		require.True(t, s.resend.Sub(time.Now()) > 59*time.Minute)
+		require.True(t, s.resend.Sub(time.Now()) <= 2*time.Hour)
+	}
+}
+
+func TestResendIdenticalPending(t *testing.T) {
+	n := 16
+	h := stalledHandel(t, n, new(recordNetwork), time.Hour)
+	top := h.levels[h.Partitioner.MaxLevel()]
+	size := len(top.nodes)
+	h.Lock()
+	defer h.Unlock()
+	// a first rotation sends the signature to all the peers
+	for i := 0; i < size/2; i++ {
+		h.sendUpdate(top, 2)
+	}
+	require.False(t, top.active())
+	require.Equal(t, size, top.contacted)
+
+	// rearmed, the peers which got the signature are skipped by the filter:
+	// they are not pending anymore, and the level is done with it
+	top.rearm()
+	h.sendUpdate(top, size)
+	require.Equal(t, size, h.resend.skipped)
+	require.False(t, top.active())
+	require.Equal(t, size, top.contacted)
+	for _, s := range top.sent {
+		require.False(t, s.pending)
+	}
+
+	// a better signature is sent to all the peers in one rotation
+	top.updateSigSize(top.sendSigSize + 1)
+	for i := 0; i < size/2; i++ {
+		require.True(t, top.active())
+		h.sendUpdate(top, 2)
+	}
+	require.False(t, top.active())
+	require.Equal(t, 2*size, top.contacted)
+	require.Equal(t, size, h.resend.skipped)
+	for _, s := range top.sent {
+		require.Equal(t, top.sendSigSize, s.card)
+	}
+}
+
+func TestResendIdenticalLoss(t *testing.T) {
+	n := 16
+	net := new(recordNetwork)
+	h := stalledHandel(t, n, net, 2*time.Millisecond)
+	clock := newFakeClock()
+	clock.use(h)
+	for tick := 0; deliveredPeers(net, true) < n-1; tick++ {
+		require.True(t, tick < 1000, "not delivered to all peers")
+		h.periodicUpdate()
+		clock.advance(time.Millisecond)
+	}
+	// the first packets are lost, all the peers received a resend
+	require.True(t, h.resend.identical >= n-1)
+	require.Equal(t, h.resend.identical, identicalResends(net))
+}
+
+func TestResendIdenticalFakeSetup(t *testing.T) {
+	n := 32
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	// the identical signatures are never sent again
+	for _, h := range handels {
+		h.resend.after = time.Hour
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range handels {
+		wg.Add(1)
+		go func(h *Handel) {
+			defer wg.Done()
+			for ms := range h.FinalSignatures() {
+				if ms.Cardinality() == n {
+					return
+				}
+			}
+		}(h)
+	}
+	done := make(chan bool)
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("handels did not complete")
+	}
+}
@@ -0,0 +1,216 @@
+package handel
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Outcome is the reason why an aggregation ended, see AggregationResult.
+type Outcome int
+
+const (
+	// Running means the aggregation is not done yet: there is no result
+	Running Outcome = iota
+	// ThresholdMet means Handel produced a final signature with at least the
+	// threshold of contributions before it stopped
+	ThresholdMet
+	// DeadlineExpired means Config.Deadline elapsed before Handel reached the
+	// threshold
+	DeadlineExpired
+	// Stopped means Handel was stopped before reaching the threshold
+	Stopped
+	// Starved means the starved levels made the threshold unreachable before
+	// Handel stopped, see LevelStarved
+	Starved
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Running:
+		return "running"
+	case ThresholdMet:
+		return "threshold_met"
+	case DeadlineExpired:
+		return "deadline_expired"
+	case Stopped:
+		return "stopped"
+	case Starved:
+		return "starved"
+	default:
+		return fmt.Sprintf("outcome(%d)", int(o))
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface
+func (o Outcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// LevelResult is the state of a level when the aggregation ended
+type LevelResult struct {
+	Level int `json:"level"`
+	// Cardinality of the best signature received at this level
+	Cardinality int `json:"cardinality"`
+	// Size is the number of nodes of the level
+	Size      int  `json:"size"`
+	Completed bool `json:"completed"`
+	Starved   bool `json:"starved"`
+	// FirstContribution is the time since the start of the first signature
+	// verified at this level, and CompletionTime the time of its completion,
+	// zero if none
+	FirstContribution time.Duration `json:"firstContribution,omitempty"`
+	CompletionTime    time.Duration `json:"completionTime,omitempty"`
+}
+
+// AggregationResult summarizes how an aggregation ended, see Handel.Result.
+type AggregationResult struct {
+	Outcome Outcome `json:"outcome"`
+	// Best is the best final signature produced, nil if the threshold was
+	// not met
+	Best *MultiSignature `json:"-"`
+	// Cardinality of the best full signature stored, even below the
+	// threshold
+	Cardinality int `json:"cardinality"`
+	Threshold   int `json:"threshold"`
+	// Elapsed is the time between the start and the end of the aggregation,
+	// zero if Handel never started
+	Elapsed time.Duration `json:"elapsed"`
+	Levels  []LevelResult `json:"levels"`
+	// number of packets sent and of valid packets received, and number of
+	// signatures verified
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+	Verified int `json:"verified"`
+	// StarvedLevels and MaxAchievable are set if levels were starved, see
+	// LevelStarved
+	StarvedLevels []int `json:"starvedLevels,omitempty"`
+	MaxAchievable int   `json:"maxAchievable"`
+	// External is true if the aggregation was completed by a peer, see
+	// Config.BroadcastCompletion
+	External bool `json:"external,omitempty"`
+	// Error explains why the threshold was not met
+	Error string `json:"error,omitempty"`
+}
+
+// copy returns a deep copy of the result, so the recorded one can't be
+// modified by the callers.
+func (r *AggregationResult) copy() AggregationResult {
+	c := *r
+	if r.Best != nil {
+		c.Best = &MultiSignature{BitSet: r.Best.BitSet.Clone(), Signature: r.Best.Signature}
+	}
+	c.Levels = append([]LevelResult(nil), r.Levels...)
+	c.StarvedLevels = append([]int(nil), r.StarvedLevels...)
+	return c
+}
+
+// Values returns the outcome, as its numerical value, the elapsed time in
+// milliseconds and the counts of the result.
+func (r AggregationResult) Values() map[string]float64 {
+	return map[string]float64{
+		"outcome":     float64(r.Outcome),
+		"elapsed":     float64(r.Elapsed) / float64(time.Millisecond),
+		"cardinality": float64(r.Cardinality),
+		"sent":        float64(r.Sent),
+		"rcvd":        float64(r.Received),
+		"verified":    float64(r.Verified),
+		"starved":     float64(len(r.StarvedLevels)),
+	}
+}
+
+// Result returns the summary of the aggregation once Handel is done, i.e.
+// once the channel returned by Done is closed. Its outcome is Running before.
+// The result does not change once set and can be called concurrently.
+func (h *Handel) Result() AggregationResult {
+	r, ok := h.result.Load().(*AggregationResult)
+	if !ok || r == nil {
+		return AggregationResult{Outcome: Running, Threshold: h.threshold}
+	}
+	return r.copy()
+}
+
+// CurrentResult returns the summary of the aggregation so far, as Result
+// would return it if Handel stopped now, without stopping it: its outcome is
+// ThresholdMet once the threshold is met, Starved once it is known to be
+// unreachable, and Running otherwise. Once Handel is done, it returns the
+// Result.
+func (h *Handel) CurrentResult() AggregationResult {
+	h.Lock()
+	defer h.Unlock()
+	if h.done {
+		return h.Result()
+	}
+	return h.buildResult(Running).copy()
+}
+
+// Done returns a channel closed when Handel stops, whether it was stopped by
+// the caller or by the deadline. The Result is set by then.
+func (h *Handel) Done() <-chan bool {
+	return h.stopCh
+}
+
+// setResult records the result of the aggregation, ended for the given
+// reason unless the threshold was met or was known to be unreachable. The
+// lock must be held.
+func (h *Handel) setResult(end Outcome) {
+	h.result.Store(h.buildResult(end))
+}
+
+// buildResult returns the result of the aggregation ending for the given
+// reason, or still running. The lock must be held.
+func (h *Handel) buildResult(end Outcome) *AggregationResult {
+	r := &AggregationResult{
+		Threshold:     h.threshold,
+		Sent:          h.stats.msgSentCt,
+		Received:      h.stats.msgRcvCt,
+		MaxAchievable: h.maxAchievable(),
+	}
+	if !h.startTime.IsZero() {
+		r.Elapsed = time.Since(h.startTime)
+	}
+	eff := h.efficiency.snapshot()
+	r.Verified = eff.Useful + eff.Redundant
+	r.External = h.completion.external
+	full := h.store.FullSignature()
+	r.Cardinality = full.Cardinality()
+	h.bitsets.Put(full.BitSet)
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		lr := LevelResult{
+			Level:             id,
+			Size:              len(lvl.nodes),
+			Completed:         lvl.rcvCompleted,
+			Starved:           h.starved[id],
+			FirstContribution: lvl.firstVerified,
+			CompletionTime:    lvl.completedIn,
+		}
+		if ms, ok := h.store.Best(byte(id)); ok && ms != nil {
+			lr.Cardinality = ms.Cardinality()
+		}
+		r.Levels = append(r.Levels, lr)
+	}
+	for id := range h.starved {
+		r.StarvedLevels = append(r.StarvedLevels, id)
+	}
+	sort.Ints(r.StarvedLevels)
+
+	switch {
+	case h.best != nil:
+		r.Outcome = ThresholdMet
+		r.Best = h.best
+		r.Cardinality = h.best.Cardinality()
+	case r.MaxAchievable < h.threshold:
+		r.Outcome = Starved
+		r.Error = fmt.Sprintf("threshold %d unreachable: levels %v starved, at most %d contributions", h.threshold, r.StarvedLevels, r.MaxAchievable)
+	case end == Running:
+		r.Outcome = Running
+	case end == DeadlineExpired:
+		r.Outcome = end
+		r.Error = fmt.Sprintf("deadline of %s expired with %d/%d contributions", h.c.Deadline, r.Cardinality, h.threshold)
+	default:
+		r.Outcome = Stopped
+		r.Error = fmt.Sprintf("stopped with %d/%d contributions", r.Cardinality, h.threshold)
+	}
+	return r
+}
@@ -0,0 +1,204 @@
+package handel
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// waitDone waits for the Handel to be done and returns its result
+func waitDone(t *testing.T, h *Handel) AggregationResult {
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("handel not done")
+	}
+	return h.Result()
+}
+
+func TestResultThresholdMet(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	require.Equal(t, Running, handels[0].Result().Outcome)
+	require.Equal(t, Running, handels[0].CurrentResult().Outcome)
+	for _, h := range handels {
+		h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case <-h.FinalSignatures():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+	}
+	h := handels[0]
+	require.Equal(t, Running, h.Result().Outcome)
+	// the result so far already has the outcome
+	current := h.CurrentResult()
+	require.Equal(t, ThresholdMet, current.Outcome)
+	require.NotNil(t, current.Best)
+	h.Stop()
+	r := waitDone(t, h)
+	require.Equal(t, ThresholdMet, r.Outcome)
+	require.Equal(t, r, h.CurrentResult())
+	require.NotNil(t, r.Best)
+	require.True(t, r.Cardinality >= r.Threshold)
+	require.Equal(t, r.Best.Cardinality(), r.Cardinality)
+	require.True(t, r.Elapsed > 0)
+	require.True(t, r.Sent > 0)
+	require.True(t, r.Received > 0)
+	require.True(t, r.Verified > 0)
+	require.Empty(t, r.StarvedLevels)
+	require.Equal(t, n, r.MaxAchievable)
+	require.Empty(t, r.Error)
+	require.Len(t, r.Levels, 4)
+	for i, l := range r.Levels {
+		require.Equal(t, i+1, l.Level)
+		require.Equal(t, 1<<uint(i), l.Size)
+		require.False(t, l.Starved)
+		if l.Completed {
+			require.Equal(t, l.Size, l.Cardinality)
+		}
+	}
+
+	// the result is immutable, the channels keep working
+	r.Levels[0].Cardinality = -1
+	r.Best.BitSet.Set(0, false)
+	r2 := h.Result()
+	require.NotEqual(t, -1, r2.Levels[0].Cardinality)
+	require.Equal(t, r.Cardinality, r2.Best.Cardinality())
+	h.Stop()
+	require.Equal(t, r2, h.Result())
+	_, open := <-h.FinalSignatures()
+	for open {
+		_, open = <-h.FinalSignatures()
+	}
+}
+
+func TestResultStopped(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	// the other nodes never answer
+	h := handels[0]
+	h.c.StarvationCheck = -1
+	h.Start()
+	// an update to the active levels
+	h.periodicUpdate()
+
+	// concurrent readers while stopping
+	var wg sync.WaitGroup
+	results := make(chan AggregationResult, 10)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-h.Done()
+			results <- h.Result()
+		}()
+	}
+	h.Stop()
+	wg.Wait()
+	close(results)
+	r := h.Result()
+	for r2 := range results {
+		require.Equal(t, r, r2)
+	}
+
+	require.Equal(t, Stopped, r.Outcome)
+	require.Nil(t, r.Best)
+	require.Equal(t, 1, r.Cardinality)
+	require.True(t, r.Sent > 0)
+	require.Equal(t, 0, r.Received)
+	require.Equal(t, 0, r.Verified)
+	require.True(t, strings.HasPrefix(r.Error, "stopped"), r.Error)
+	for _, l := range r.Levels {
+		require.Equal(t, 0, l.Cardinality)
+		require.False(t, l.Completed)
+	}
+}
+
+func TestResultNeverStarted(t *testing.T) {
+	_, handels := FakeSetup(4)
+	h := handels[0]
+	h.Close()
+	r := waitDone(t, h)
+	require.Equal(t, Stopped, r.Outcome)
+	require.Equal(t, time.Duration(0), r.Elapsed)
+	require.Equal(t, 0, r.Sent)
+}
+
+func TestResultDeadlineExpired(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	h := handels[0]
+	h.c.StarvationCheck = -1
+	h.c.Deadline = 50 * time.Millisecond
+	h.Start()
+	r := waitDone(t, h)
+	require.Equal(t, DeadlineExpired, r.Outcome)
+	require.Nil(t, r.Best)
+	require.True(t, r.Elapsed >= h.c.Deadline)
+	require.True(t, strings.HasPrefix(r.Error, "deadline of 50ms expired"), r.Error)
+	// the final signatures channel is closed as well
+	_, open := <-h.FinalSignatures()
+	require.False(t, open)
+}
+
+func TestResultStarved(t *testing.T) {
+	n := 16
+	// the nodes 8 to 15 are down: the threshold of 9 is unreachable
+	dead := 8
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	// the ids of the nodes detecting it
+	unreachable := make(chan int32, dead)
+	for _, h := range handels[:dead] {
+		id := h.id.ID()
+		h.c.StarvationCheck = 1
+		h.c.OnThresholdUnreachable = func(max int) {
+			unreachable <- id
+		}
+		h.Start()
+	}
+	// wait for the first node to detect it
+	for id := int32(-1); id != 0; {
+		select {
+		case id = <-unreachable:
+		case <-time.After(5 * time.Second):
+			t.Fatal("unreachable threshold not signaled")
+		}
+	}
+	h := handels[0]
+	h.Stop()
+	r := waitDone(t, h)
+	require.Equal(t, Starved, r.Outcome)
+	require.Nil(t, r.Best)
+	require.Equal(t, []int{4}, r.StarvedLevels)
+	require.Equal(t, dead, r.MaxAchievable)
+	require.Equal(t, dead, r.Cardinality)
+	require.True(t, r.Levels[3].Starved)
+	require.Equal(t, 0, r.Levels[3].Cardinality)
+	require.True(t, strings.HasPrefix(r.Error, "threshold 9 unreachable: levels [4] starved"), r.Error)
+	values := r.Values()
+	require.Equal(t, float64(Starved), values["outcome"])
+	require.Equal(t, 1.0, values["starved"])
+}
+
+func TestOutcomeString(t *testing.T) {
+	for o, name := range map[Outcome]string{
+		Running:         "running",
+		ThresholdMet:    "threshold_met",
+		DeadlineExpired: "deadline_expired",
+		Stopped:         "stopped",
+		Starved:         "starved",
+		Outcome(42):     "outcome(42)",
+	} {
+		require.Equal(t, name, o.String())
+	}
+}
@@ -0,0 +1,159 @@
+package handel
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// keyRotation tracks the identities signing with their previous key, see
+// Config.KeyRotationGrace. Each packet carries, in Packet.PreviousKeys, the
+// contributors of its signatures which the sender knows to sign with their
+// previous key: the receiver verifies the signatures with the previous keys
+// of these contributors, and learns them once a signature verifies. Once the
+// grace window is over, only the current keys are used.
+type keyRotation struct {
+	grace time.Duration
+	// end of the grace window in unix nanoseconds, zero until Handel starts,
+	// read atomically by the processing routine
+	until int64
+	// identities known to sign with their previous key, by index in the
+	// registry. Guarded by the lock of Handel.
+	previous BitSet
+	now      func() time.Time
+}
+
+// rotatingProcessing is implemented by the processings verifying the
+// signatures with the previous keys given by the packets
+type rotatingProcessing interface {
+	setRotation(r *keyRotation)
+}
+
+func newKeyRotation(grace time.Duration, size int, nbs func(int) BitSet) *keyRotation {
+	return &keyRotation{grace: grace, previous: nbs(size), now: time.Now}
+}
+
+// start starts the grace window
+func (r *keyRotation) start(now time.Time) {
+	atomic.StoreInt64(&r.until, now.Add(r.grace).UnixNano())
+}
+
+// inGrace returns true until the end of the grace window
+func (r *keyRotation) inGrace() bool {
+	until := atomic.LoadInt64(&r.until)
+	return until == 0 || r.now().UnixNano() < until
+}
+
+// previousKey returns the previous key of the identity if the signature of
+// the given global hint uses it and the grace window is not over, nil
+// otherwise.
+func (r *keyRotation) previousKey(id Identity, hint BitSet) PublicKey {
+	if r == nil || hint == nil || !hint.Get(int(id.ID())) || !r.inGrace() {
+		return nil
+	}
+	return PreviousPublicKey(id)
+}
+
+// previousKeysHint returns the marshalled hint of the signature of the given
+// level, i.e. the identities known to sign with their previous key by index
+// in the registry, or nil if there is none. The level is FullLevel for a full
+// signature, whose hint is restricted to its contributors; the receiver of a
+// level signature only looks at the bits of the contributors.
+func (h *Handel) previousKeysHint(level byte, ms *MultiSignature) ([]byte, error) {
+	r := h.rotation
+	if r == nil || r.previous.Cardinality() == 0 || !r.inGrace() {
+		return nil, nil
+	}
+	hint := r.previous
+	if level == FullLevel {
+		if hint = ms.BitSet.And(r.previous); hint.Cardinality() == 0 {
+			return nil, nil
+		}
+	}
+	return hint.MarshalBinary()
+}
+
+// parsePreviousKeys returns the hint of the packet, nil if none.
+func (h *Handel) parsePreviousKeys(p *Packet) (BitSet, error) {
+	if h.rotation == nil || len(p.PreviousKeys) == 0 {
+		return nil, nil
+	}
+	hint := h.c.NewBitSet(h.reg.Size())
+	if err := hint.UnmarshalBinary(p.PreviousKeys); err != nil {
+		return nil, err
+	}
+	if hint.BitLength() != h.reg.Size() {
+		return nil, errors.New("invalid previous keys' size")
+	}
+	return hint, nil
+}
+
+// learnPreviousKeys records the contributors of the verified signature which
+// signed with their previous key. The lock must be held.
+func (h *Handel) learnPreviousKeys(sp *IncomingSig) {
+	r := h.rotation
+	if r == nil || sp.previous == nil || !r.inGrace() {
+		return
+	}
+	ids, err := h.Partitioner.IdentitiesAt(int(sp.level))
+	if err != nil {
+		return
+	}
+	for i, ok := sp.ms.BitSet.NextSet(0); ok; i, ok = sp.ms.BitSet.NextSet(i + 1) {
+		if r.previousKey(ids[i], sp.previous) != nil {
+			r.previous.Set(int(ids[i].ID()), true)
+		}
+	}
+}
+
+// checkOwnKey records our identity as signing with its previous key if our
+// signature only verifies under it
+func (h *Handel) checkOwnKey() {
+	prev := PreviousPublicKey(h.id)
+	if prev == nil {
+		return
+	}
+	if h.id.PublicKey().VerifySignature(h.msg, h.sig) != nil && prev.VerifySignature(h.msg, h.sig) == nil {
+		h.rotation.previous.Set(int(h.id.ID()), true)
+	}
+}
+
+// PreviousKeys returns the identities known to sign with their previous key,
+// by index in the registry, during Config.KeyRotationGrace: they are the
+// ones whose previous key verifies the final signatures, see
+// VerifyRotatedMultiSignature. It returns nil without key rotation.
+func (h *Handel) PreviousKeys() BitSet {
+	h.Lock()
+	defer h.Unlock()
+	if h.rotation == nil {
+		return nil
+	}
+	return h.rotation.previous.Clone()
+}
+
+// VerifyRotatedMultiSignature verifies a multisignature as
+// VerifyMultiSignature, with the previous key of the identities set in
+// previous, as given by Handel.PreviousKeys. A nil previous uses the current
+// keys only.
+func VerifyRotatedMultiSignature(msg []byte, ms *MultiSignature, previous BitSet, reg Registry, cons Constructor) error {
+	n := ms.BitSet.BitLength()
+	if n != reg.Size() || (previous != nil && previous.BitLength() != n) {
+		return errors.New("verify multisignature: inconsistent sizes")
+	}
+	aggregate := cons.PublicKey()
+	for i, ok := ms.BitSet.NextSet(0); ok; i, ok = ms.BitSet.NextSet(i + 1) {
+		id, ok := reg.Identity(i)
+		if !ok {
+			return fmt.Errorf("registry returned empty identity at %d", i)
+		}
+		key := id.PublicKey()
+		if previous != nil && previous.Get(i) {
+			if key = PreviousPublicKey(id); key == nil {
+				return fmt.Errorf("verify multisignature: no previous key at %d", i)
+			}
+		}
+		aggregate = aggregate.Combine(key)
+	}
+	return aggregate.VerifySignature(msg, ms.Signature)
+}
@@ -0,0 +1,88 @@
+package handel_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// rotatedSetup returns the handels of n nodes where the even ones rotated
+// their key but still sign with the previous one
+func rotatedSetup(t *testing.T, n int, msg []byte, config func(c *handel.Config)) (handel.Registry, []*handel.Handel) {
+	ids := make([]handel.Identity, n)
+	secrets := make([]handel.SecretKey, n)
+	for i := range ids {
+		current := handeltest.NewSecretKey([]byte("current"), i)
+		if i%2 == 0 {
+			previous := handeltest.NewSecretKey([]byte("previous"), i)
+			ids[i] = handel.NewRotatingIdentity(int32(i), []string{""}, current.PublicKey(), previous.PublicKey())
+			secrets[i] = previous
+		} else {
+			ids[i] = handel.NewStaticIdentity(int32(i), "", current.PublicKey())
+			secrets[i] = current
+		}
+	}
+	reg := handel.NewArrayRegistry(ids)
+	cons := handeltest.NewConstructor()
+	nets := handeltest.NewBus(n).Networks()
+	handels := make([]*handel.Handel, n)
+	for i := range handels {
+		sig, err := secrets[i].Sign(msg, nil)
+		require.NoError(t, err)
+		c := handel.DefaultConfig(n)
+		config(c)
+		handels[i] = handel.NewHandel(nets[i], reg, ids[i], cons, msg, sig, c)
+	}
+	return reg, handels
+}
+
+func TestKeyRotationGrace(t *testing.T) {
+	n := 16
+	msg := []byte("rotation")
+	cons := handeltest.NewConstructor()
+	reg, handels := rotatedSetup(t, n, msg, func(c *handel.Config) {
+		c.Contributions = n
+		c.KeyRotationGrace = time.Minute
+	})
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	for i, h := range handels {
+		ms := handeltest.WaitThreshold(t, h, n, 10*time.Second)
+		previous := h.PreviousKeys()
+		require.NotNil(t, previous)
+		for j := 0; j < n; j++ {
+			require.Equal(t, j%2 == 0, previous.Get(j), "node %d: previous key of %d", i, j)
+		}
+		require.NoError(t, handel.VerifyRotatedMultiSignature(msg, ms, previous, reg, cons))
+		require.Error(t, handel.VerifyMultiSignature(msg, ms, reg, cons))
+	}
+}
+
+func TestKeyRotationExpired(t *testing.T) {
+	n := 16
+	msg := []byte("rotation")
+	cons := handeltest.NewConstructor()
+	reg, handels := rotatedSetup(t, n, msg, func(c *handel.Config) {
+		c.Contributions = n / 2
+		c.KeyRotationGrace = time.Nanosecond
+	})
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	// only the signatures of the current keys are accepted: the nodes which
+	// did not rotate their key aggregate their signatures only
+	for i := 1; i < n; i += 2 {
+		ms := handeltest.WaitThreshold(t, handels[i], n/2, 10*time.Second)
+		for j := 0; j < n; j++ {
+			require.Equal(t, j%2 == 1, ms.Get(j), "node %d: signature of %d", i, j)
+		}
+		require.NoError(t, handel.VerifyMultiSignature(msg, ms, reg, cons))
+		require.Equal(t, 0, handels[i].PreviousKeys().Cardinality())
+	}
+}
@@ -0,0 +1,69 @@
+package handel
+
+// LevelSchedule is the schedule of the contacts of a node over its levels, as
+// Handel runs it: the peers of each level in their contact order, the
+// rotation over them, and the activation of the levels. It lets the tools
+// model the dissemination of Handel without running it, e.g.
+// cmd/handel-whatif, with the same code as Handel. It is not thread-safe.
+type LevelSchedule struct {
+	levels map[int]*level
+	ids    []int
+}
+
+// NewLevelSchedule returns the schedule of the node of the partitioner. The
+// contact order of the peers follows the config, merged with the default
+// one - see Config.DisableShuffling and Config.Rand. The first level is
+// started, as in Handel.
+func NewLevelSchedule(c *Config, part Partitioner) *LevelSchedule {
+	return &LevelSchedule{
+		levels: createLevels(mergeWithDefault(c, 0), part),
+		ids:    part.Levels(),
+	}
+}
+
+// Levels returns the ids of the levels, in increasing order
+func (s *LevelSchedule) Levels() []int {
+	return s.ids
+}
+
+// Peers returns the peers of the level in their contact order
+func (s *LevelSchedule) Peers(level int) []Identity {
+	return s.levels[level].nodes
+}
+
+// ExpectedSize returns the number of contributions of the signature sent at
+// the level once complete: ours and the ones of the levels below.
+func (s *LevelSchedule) ExpectedSize(level int) int {
+	return s.levels[level].sendExpectedFullSize
+}
+
+// Start starts the level, and returns true if it was not started before: the
+// first update of the level is then due, as Handel.StartLevel does.
+func (s *LevelSchedule) Start(level int) bool {
+	lvl := s.levels[level]
+	if lvl.started() {
+		return false
+	}
+	lvl.setStarted()
+	return true
+}
+
+// Active returns true if the periodic updates send to the level: it is
+// started and some of its peers did not get our last signature for it yet.
+func (s *LevelSchedule) Active(level int) bool {
+	return s.levels[level].active()
+}
+
+// Next returns the count next peers of the rotation of the level, to send an
+// update to.
+func (s *LevelSchedule) Next(level, count int) []Identity {
+	return s.levels[level].selectNextPeersBut(count, nil)
+}
+
+// Improve records the cardinality of the signature we can send at the level,
+// which restarts the rotation if it is better than the previous one. It
+// returns true if the signature is complete: the level is started then, and
+// Handel sends it to Config.FastPath peers, spread over Config.FanoutSpread.
+func (s *LevelSchedule) Improve(level, card int) bool {
+	return s.levels[level].updateSigSize(card)
+}
@@ -0,0 +1,44 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ids(identities []Identity) []int32 {
+	var res []int32
+	for _, id := range identities {
+		res = append(res, id.ID())
+	}
+	return res
+}
+
+func TestLevelSchedule(t *testing.T) {
+	n := 8
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	s := NewLevelSchedule(&Config{DisableShuffling: true}, part)
+	require.Equal(t, []int{1, 2, 3}, s.Levels())
+	require.Equal(t, []int32{4, 5, 6, 7}, ids(s.Peers(3)))
+	require.Equal(t, 4, s.ExpectedSize(3))
+
+	// the first level is started at once, the others on demand
+	require.True(t, s.Active(1))
+	require.False(t, s.Active(3))
+	require.False(t, s.Start(1))
+	require.True(t, s.Start(3))
+	require.False(t, s.Start(3))
+
+	// the rotation goes over the peers once, then the level is idle
+	require.Equal(t, []int32{4, 5, 6}, ids(s.Next(3, 3)))
+	require.Equal(t, []int32{7}, ids(s.Next(3, 2)))
+	require.False(t, s.Active(3))
+
+	// a better signature restarts the rotation, a complete one the level
+	require.False(t, s.Improve(3, 2))
+	require.True(t, s.Active(3))
+	require.False(t, s.Improve(3, 2))
+	require.False(t, s.Active(2))
+	require.True(t, s.Improve(2, 2))
+	require.True(t, s.Active(2))
+}
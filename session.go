@@ -0,0 +1,210 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// Session keeps the long-lived state of a Handel node across consecutive
+// aggregations: the network, the registry, the partitioner of the local
+// identity, the cache of the aggregate public keys and the pools of bitsets
+// and marshalling buffers. Building a Handel from a Session avoids
+// recomputing this state for each message, and avoids registering a new
+// listener to the network for each aggregation.
+//
+// A Session runs one aggregation at a time: starting a new aggregation closes
+// the previous one. Packets still in flight from the previous aggregation are
+// delivered to the new one, where their signatures fail the verification,
+// unless the Session has a Config.PreStart buffer, which only delivers the
+// packets of the running aggregation.
+type Session struct {
+	sync.Mutex
+	net  Network
+	reg  Registry
+	id   Identity
+	cons Constructor
+	conf *Config
+	log  Logger
+	part Partitioner
+	// shared by the processing of all the aggregations, which never run
+	// concurrently
+	keys    *keyCache
+	bitsets *BitSetPool
+	buffers *bufferPool
+	// the aggregation receiving the packets
+	current *Handel
+	closed  bool
+}
+
+// NewSession returns a Session for the given identity, which registers itself
+// as a listener of the network. The first config in the slice is taken if not
+// nil, otherwise the default config is used. The partitioner is created once
+// from this config. It panics if the config is invalid for the registry, see
+// Config.Validate.
+func NewSession(n Network, r Registry, id Identity, c Constructor, conf ...*Config) *Session {
+	var config *Config
+	if len(conf) > 0 && conf[0] != nil {
+		config = mergeWithDefault(conf[0], r.Size())
+	} else {
+		config = DefaultConfig(r.Size())
+	}
+	if err := config.Validate(r.Size()); err != nil {
+		panic(err)
+	}
+	log := config.Logger.With("id", id.ID())
+	s := &Session{
+		net:     wrapNetwork(n),
+		reg:     r,
+		id:      id,
+		cons:    c,
+		conf:    config,
+		log:     log,
+		part:    capPartitioner(config, config.NewPartitioner(id.ID(), r, log), id.ID(), r.Size()),
+		keys:    newKeyCache(c),
+		bitsets: NewBitSetPool(),
+		buffers: newBufferPool(),
+	}
+	s.net.RegisterListener(s)
+	return s
+}
+
+// NewAggregation closes the current aggregation, if any, and returns a new
+// Handel aggregating the signature over the given message. The returned Handel
+// must be started as usual.
+//
+// The config may differ from one aggregation to the next, except for the
+// fields defining the state kept by the Session: NewPartitioner,
+// PartitionerMode, PartitionerSeed, NewBitSet and PreStart are always taken
+// from the config of the Session. A nil config uses the config of the Session.
+func (s *Session) NewAggregation(msg []byte, sig Signature, conf *Config) (*Handel, error) {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil, errors.New("handel: session closed")
+	}
+	prev := s.current
+	s.current = nil
+	s.Unlock()
+	s.release(prev)
+
+	var config *Config
+	if conf != nil {
+		config = mergeWithDefault(conf, s.reg.Size())
+	} else {
+		c2 := *s.conf
+		config = &c2
+	}
+	config.NewPartitioner = s.conf.NewPartitioner
+	config.PartitionerMode = s.conf.PartitionerMode
+	config.PartitionerSeed = s.conf.PartitionerSeed
+	config.MaxAggregationLevel = s.conf.MaxAggregationLevel
+	config.NewBitSet = s.bitsets.New
+	config.PreStart = s.conf.PreStart
+	if err := config.Validate(s.reg.Size()); err != nil {
+		return nil, err
+	}
+	if config.AuthenticatePackets {
+		return nil, errNoSecret
+	}
+
+	h := newHandel(s.net, s.reg, s.id, s.cons, msg, sig, config, s.part, s.log)
+	if proc, ok := h.proc.(*evaluatorProcessing); ok {
+		proc.keys = s.keys
+	}
+	h.bitsets = s.bitsets
+	h.buffers = s.buffers
+	h.pool.bitsets = s.bitsets
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		// closed while the aggregation was built
+		h.Close()
+		return nil, errors.New("handel: session closed")
+	}
+	s.current = h
+	return h, nil
+}
+
+// release closes the aggregation and recycles its bitsets
+func (s *Session) release(h *Handel) {
+	if h == nil {
+		return
+	}
+	h.Close()
+	if st, ok := h.store.(*store); ok {
+		st.recycle(s.bitsets)
+	}
+}
+
+// NewPacket implements the Listener interface: it forwards the packet to the
+// current aggregation, or to the PreStart buffer of the config if any, which
+// keeps the packets received between the aggregations.
+func (s *Session) NewPacket(p *Packet) {
+	if s.conf.PreStart != nil {
+		s.conf.PreStart.NewPacket(p)
+		return
+	}
+	s.Lock()
+	h := s.current
+	s.Unlock()
+	if h != nil {
+		h.NewPacket(p)
+	}
+}
+
+// Close closes the current aggregation. No aggregation can be started
+// afterwards. The network is not owned by the Session and is left untouched.
+func (s *Session) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+	s.closed = true
+	h := s.current
+	s.current = nil
+	s.Unlock()
+	s.release(h)
+	return nil
+}
+
+// bufferPool recycles the scratch buffers used to marshal multi-signatures
+type bufferPool struct {
+	sync.Pool
+}
+
+func newBufferPool() *bufferPool {
+	return &bufferPool{Pool: sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}}
+}
+
+// marshal returns the same encoding as MultiSignature.MarshalBinary, building
+// it in a recycled buffer so only the returned slice is allocated. A nil pool
+// falls back to MarshalBinary.
+func (b *bufferPool) marshal(ms *MultiSignature) ([]byte, error) {
+	w, ok := ms.BitSet.(*WilffBitSet)
+	if b == nil || !ok {
+		return ms.MarshalBinary()
+	}
+	sig, err := ms.Signature.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buff := b.Get().(*bytes.Buffer)
+	defer b.Put(buff)
+	buff.Reset()
+
+	var lengths [4]byte
+	binary.BigEndian.PutUint16(lengths[:2], uint16(2+w.b.BinaryStorageSize()))
+	binary.BigEndian.PutUint16(lengths[2:], uint16(w.l))
+	buff.Write(lengths[:])
+	if _, err := w.b.WriteTo(buff); err != nil {
+		return nil, err
+	}
+	buff.Write(sig)
+	out := make([]byte, buff.Len())
+	copy(out, buff.Bytes())
+	return out, nil
+}
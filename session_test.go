@@ -0,0 +1,146 @@
+package handel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeSessions(n int, conf *Config) []*Session {
+	reg := FakeRegistry(n)
+	nets := NewTestNetworks(n)
+	sessions := make([]*Session, n)
+	for i := range sessions {
+		id, _ := reg.Identity(i)
+		sessions[i] = NewSession(nets[i], reg, id, new(fakeCons), conf)
+	}
+	return sessions
+}
+
+// aggregate runs one aggregation over the sessions and waits for the final
+// signature of each node.
+func aggregate(t testing.TB, sessions []*Session, msg []byte, conf *Config) {
+	handels := make([]*Handel, len(sessions))
+	for i, s := range sessions {
+		h, err := s.NewAggregation(msg, &fakeSig{true}, conf)
+		require.NoError(t, err)
+		handels[i] = h
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, len(sessions), ms.Cardinality())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("node %d: no final signature", i)
+		}
+	}
+}
+
+func TestSessionAggregations(t *testing.T) {
+	n := 16
+	conf := &Config{Contributions: n, Logger: &warnLogger{}}
+	sessions := fakeSessions(n, conf)
+	for i := 0; i < 3; i++ {
+		t.Logf(" -- test %d -- ", i)
+		msg := []byte(fmt.Sprintf("Sun is Shining... %d", i))
+		aggregate(t, sessions, msg, conf)
+		// the session is the only listener of its network
+		require.Len(t, unwrapNetwork(sessions[0].net).(*TestNetwork).lis, 1)
+		require.Equal(t, msg, sessions[0].current.msg)
+		require.True(t, sessions[0].current.Partitioner == sessions[0].part)
+	}
+	// the aggregate keys computed in the first aggregations are reused
+	require.True(t, sessions[0].keys.hits > 0)
+
+	for _, s := range sessions {
+		require.NoError(t, s.Close())
+	}
+	_, err := sessions[0].NewAggregation(msg, &fakeSig{true}, conf)
+	require.Error(t, err)
+}
+
+func TestSessionConfig(t *testing.T) {
+	n := 4
+	sessions := fakeSessions(n, &Config{Contributions: n})
+	defer func() {
+		for _, s := range sessions {
+			s.Close()
+		}
+	}()
+	// the fields kept by the session are overridden
+	h, err := sessions[0].NewAggregation(msg, &fakeSig{true}, &Config{
+		Contributions:   n - 1,
+		NewBitSet:       DefaultBitSet,
+		PartitionerMode: PartitionerSalted,
+	})
+	require.NoError(t, err)
+	require.Equal(t, n-1, h.c.Contributions)
+	require.Equal(t, "", h.c.PartitionerMode)
+	require.True(t, h.Partitioner == sessions[0].part)
+	require.True(t, h.bitsets == sessions[0].bitsets)
+
+	// a new aggregation closes the previous one
+	h2, err := sessions[0].NewAggregation(msg, &fakeSig{true}, nil)
+	require.NoError(t, err)
+	require.True(t, h.done)
+	require.Equal(t, n, h2.c.Contributions)
+}
+
+func TestSessionMarshal(t *testing.T) {
+	pool := newBufferPool()
+	for _, size := range []int{1, 7, 64, 100} {
+		bs := NewWilffBitset(size)
+		for i := 0; i < size; i += 3 {
+			bs.Set(i, true)
+		}
+		ms := &MultiSignature{BitSet: bs, Signature: &fakeSig{true}}
+		exp, err := ms.MarshalBinary()
+		require.NoError(t, err)
+		buff, err := pool.marshal(ms)
+		require.NoError(t, err)
+		require.Equal(t, exp, buff)
+	}
+}
+
+func BenchmarkAggregationCold(b *testing.B) {
+	n := 16
+	conf := &Config{Contributions: n, Logger: &warnLogger{}}
+	reg := FakeRegistry(n)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// a new network each time, as Handel can't unregister its listener
+		nets := NewTestNetworks(n)
+		handels := make([]*Handel, n)
+		for j := range handels {
+			id, _ := reg.Identity(j)
+			handels[j] = NewHandel(nets[j], reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		}
+		for _, h := range handels {
+			h.Start()
+		}
+		for _, h := range handels {
+			<-h.FinalSignatures()
+		}
+		CloseHandels(handels)
+	}
+}
+
+func BenchmarkAggregationSession(b *testing.B) {
+	n := 16
+	conf := &Config{Contributions: n, Logger: &warnLogger{}}
+	sessions := fakeSessions(n, conf)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregate(b, sessions, msg, conf)
+	}
+	b.StopTimer()
+	for _, s := range sessions {
+		s.Close()
+	}
+}
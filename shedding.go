@@ -0,0 +1,107 @@
+package handel
+
+// PressureReporter is implemented by the processings reporting the saturation
+// of their queue, see Config.QueueCapacity.
+type PressureReporter interface {
+	// QueuePressure returns the number of signatures waiting relative to the
+	// capacity of the queue, between 0 and 1.
+	QueuePressure() float64
+}
+
+// ShedPolicy holds the queue pressures, between 0 and 1, above which Handel
+// sheds the incoming packets instead of giving them to the processing. Each
+// tier drops more than the previous one, a pressure above 1 disables a tier.
+type ShedPolicy struct {
+	// CompletedLevels drops the packets of the levels already completed
+	// before parsing them
+	CompletedLevels float64
+	// NotBetter drops the aggregates whose cardinality is smaller than the
+	// one of the best signature of their level
+	NotBetter float64
+	// AllButCandidates drops all the aggregates but the ones completing their
+	// level when combined with its best signature
+	AllButCandidates float64
+}
+
+// DefaultShedPolicy is the default shedding policy used by Handel
+var DefaultShedPolicy = ShedPolicy{
+	CompletedLevels:  0.5,
+	NotBetter:        0.75,
+	AllButCandidates: 0.9,
+}
+
+// the shedding tiers, in the order they engage
+const (
+	shedNone = iota
+	shedCompleted
+	shedNotBetter
+	shedAllButCandidates
+)
+
+// shedStats counts the packets and aggregates dropped by each tier
+type shedStats struct {
+	completed  int
+	notBetter  int
+	candidates int
+}
+
+// shedTier returns the shedding tier engaged by the current pressure of the
+// processing queue
+func (h *Handel) shedTier() int {
+	p, ok := h.proc.(PressureReporter)
+	if !ok {
+		return shedNone
+	}
+	pressure := p.QueuePressure()
+	if pressure <= 0 {
+		return shedNone
+	}
+	policy := h.c.Shedding
+	switch {
+	case pressure >= policy.AllButCandidates:
+		return shedAllButCandidates
+	case pressure >= policy.NotBetter:
+		return shedNotBetter
+	case pressure >= policy.CompletedLevels:
+		return shedCompleted
+	}
+	return shedNone
+}
+
+// shed returns true if the given aggregate must be dropped under the given
+// tier, without verifying it: only its bitset is compared to the best
+// signature of its level.
+func (h *Handel) shed(tier int, s *IncomingSig) bool {
+	if tier < shedNotBetter || s.Individual() {
+		return false
+	}
+	best, _ := h.store.Best(s.level)
+	if best != nil && s.ms.Cardinality() < best.Cardinality() {
+		h.shedding.notBetter++
+		return true
+	}
+	if tier < shedAllButCandidates {
+		return false
+	}
+	union := s.ms.Cardinality()
+	if best != nil {
+		union = best.BitSet.OrCardinality(s.ms.BitSet)
+	}
+	if union < s.ms.BitLength() {
+		h.shedding.candidates++
+		return true
+	}
+	return false
+}
+
+// shedValues returns the number of packets and aggregates dropped by each
+// tier
+func (h *Handel) shedValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	return map[string]float64{
+		"completed":  float64(h.shedding.completed),
+		"not_better": float64(h.shedding.notBetter),
+		"candidates": float64(h.shedding.candidates),
+	}
+}
@@ -0,0 +1,89 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSheddingTiers(t *testing.T) {
+	n := 16
+	_, handels := FakeSetup(n)
+	h := handels[0]
+	// the node doesn't run during the flood: its verifier is as slow as it
+	// gets and the queue only grows
+	capacity := 20
+	proc := h.proc.(*evaluatorProcessing)
+	proc.capacity = int64(capacity)
+	proc.sigSleepTime = 1
+	h.store.Store(fullIncomingSig(1))
+	h.getLevel(1).rcvCompleted = true
+	h.store.Store(&IncomingSig{level: 3, ms: newSig(bitsOf(4, 0, 1))})
+
+	flood := []*Packet{
+		// a completed level
+		aggregatePacket(t, 1, 1, 1, 0),
+		// smaller than the best of the level 3
+		aggregatePacket(t, 4, 3, 4, 2),
+		// better but not completing the level 3
+		aggregatePacket(t, 5, 3, 4, 0, 1, 2),
+	}
+	engaged := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		h.NewPacket(flood[i%len(flood)])
+		for k, v := range h.shedValues() {
+			if _, ok := engaged[k]; !ok && v > 0 {
+				engaged[k] = i
+			}
+		}
+	}
+	require.Len(t, engaged, 3)
+	require.True(t, engaged["completed"] < engaged["not_better"])
+	require.True(t, engaged["not_better"] < engaged["candidates"])
+	_, pending := proc.heartbeat()
+	require.True(t, pending <= capacity, "%d signatures waiting", pending)
+	require.True(t, proc.QueuePressure() >= DefaultShedPolicy.AllButCandidates)
+
+	// an aggregate completing the level and an individual signature still
+	// get through
+	h.NewPacket(aggregatePacket(t, 6, 3, 4, 2, 3))
+	_, after := proc.heartbeat()
+	require.Equal(t, pending+1, after)
+	p := aggregatePacket(t, 7, 3, 4, 3)
+	p.IndividualSig, _ = new(fakeSig).MarshalBinary()
+	h.NewPacket(p)
+	_, after = proc.heartbeat()
+	require.Equal(t, pending+2, after)
+
+	// the flood stopped
+	waitFullSignatures(t, handels)
+}
+
+func TestSheddingDisabled(t *testing.T) {
+	_, handels := FakeSetup(16)
+	defer CloseHandels(handels)
+	h := handels[0]
+	h.getLevel(1).rcvCompleted = true
+	for i := 0; i < 100; i++ {
+		h.NewPacket(aggregatePacket(t, 4, 3, 4, 2))
+		h.NewPacket(aggregatePacket(t, 1, 1, 1, 0))
+	}
+	_, pending := h.proc.(*evaluatorProcessing).heartbeat()
+	require.Equal(t, 100, pending)
+	require.Equal(t, shedNone, h.shedTier())
+	for _, v := range h.shedValues() {
+		require.Zero(t, v)
+	}
+}
+
+func TestQueuePressureFifo(t *testing.T) {
+	n := 16
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	fifo := newFifoProcessing(store, part, new(fakeCons), msg).(*fifoProcessing)
+	require.Zero(t, fifo.QueuePressure())
+	for i := 0; i < cap(fifo.in)/2; i++ {
+		fifo.Add(fullIncomingSig(1))
+	}
+	require.Equal(t, 0.5, fifo.QueuePressure())
+}
@@ -0,0 +1,106 @@
+// Package main rebuilds the results of a simulation offline from the raw
+// measures dumped by the monitor, see the RawDump field of the config. It
+// writes the same CSV as the master, one row per run, possibly with another
+// filtering of the measures:
+//
+//	go run analyze/main.go -from-raw results -percentiles sigen_wall=90 -out new.csv
+//
+// With -rtt, it also writes the theoretical dissemination time of each run
+// over a network of this RTT and of the -loss rate, see lib.Optimum.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/monitor"
+)
+
+var fromRaw = flag.String("from-raw", "", "directory holding the raw_runX.ndjson.gz dumps to rebuild the results from")
+var percentiles = flag.String("percentiles", "", "percentile filters as name=percentile,... applied to the measures")
+var node = flag.String("node", "", "only take the measures of the given node tag into account")
+var out = flag.String("out", "", "CSV file to write - stdout if empty")
+var rtt = flag.Duration("rtt", 0, "median RTT between the nodes to compute the theoretical dissemination time with - none if zero")
+var loss = flag.Float64("loss", 0, "packet loss rate to compute the theoretical dissemination time with")
+var slowFactor = flag.Float64("slow-factor", lib.DefaultSlowFactor, "factor of the expected dissemination time above which a run is flagged as slow")
+
+func main() {
+	flag.Parse()
+	if *fromRaw == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	p, err := monitor.ParsePercentiles(*percentiles)
+	if err != nil {
+		exit(err)
+	}
+	var filter monitor.DataFilter
+	if len(p) > 0 {
+		f := monitor.NewPercentileFilter(p)
+		filter = &f
+	}
+	var keep func(*monitor.RawRecord) bool
+	if *node != "" {
+		keep = func(r *monitor.RawRecord) bool { return r.Node == *node }
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			exit(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	var optimum *lib.OptimumConfig
+	if *rtt > 0 {
+		optimum = &lib.OptimumConfig{RTT: lib.Duration(*rtt), Loss: *loss, SlowFactor: *slowFactor}
+	}
+	runs, err := rebuild(*fromRaw, filter, keep, optimum, w)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Fprintf(os.Stderr, "[+] %d runs rebuilt from %s\n", runs, *fromRaw)
+}
+
+// rebuild writes the CSV of the consecutive runs dumped in the directory, from
+// the run 0, and returns the number of runs written. The theoretical
+// dissemination time is added to each run if the optimum is not nil.
+func rebuild(dir string, filter monitor.DataFilter, keep func(*monitor.RawRecord) bool, optimum *lib.OptimumConfig, w io.Writer) (int, error) {
+	run := 0
+	for ; ; run++ {
+		paths := monitor.RawPaths(dir, run)
+		if len(paths) == 0 {
+			break
+		}
+		stats, err := monitor.StatsFromRaw(paths, filter, keep)
+		if err != nil {
+			return run, err
+		}
+		if optimum != nil {
+			o, err := lib.OptimumFromStats(stats, time.Duration(optimum.RTT), optimum.Loss)
+			if err != nil {
+				return run, err
+			}
+			lib.AddOptimum(stats, o, optimum.SlowFactor)
+		}
+		if run == 0 {
+			stats.WriteHeader(w)
+		}
+		stats.WriteValues(w)
+	}
+	if run == 0 {
+		return 0, fmt.Errorf("no raw dump in %s", dir)
+	}
+	return run, nil
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "[-]", err)
+	os.Exit(1)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ConsenSys/handel/simul/monitor"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRebuild dumps two runs as the master does and checks the rebuilt CSV is
+// the one the master writes.
+func TestRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analyze")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var live bytes.Buffer
+	for run := 0; run < 2; run++ {
+		stats := monitor.NewStats(map[string]string{"run": strconv.Itoa(run)}, nil)
+		raw, err := monitor.NewRawWriter(dir, run, stats, 0)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			value := float64(i * (run + 1))
+			stats.Store("sigen_wall", value)
+			require.NoError(t, raw.Write(&monitor.RawRecord{Name: "sigen_wall", Value: value, Run: run}))
+		}
+		require.NoError(t, raw.Close())
+		if run == 0 {
+			stats.WriteHeader(&live)
+		}
+		stats.WriteValues(&live)
+	}
+
+	var rebuilt bytes.Buffer
+	runs, err := rebuild(dir, nil, nil, nil, &rebuilt)
+	require.NoError(t, err)
+	require.Equal(t, 2, runs)
+	require.Equal(t, live.String(), rebuilt.String())
+
+	_, err = rebuild(os.TempDir()+"/none", nil, nil, nil, &rebuilt)
+	require.Error(t, err)
+}
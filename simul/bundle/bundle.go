@@ -0,0 +1,292 @@
+// Package bundle packs the artifacts of a simulation in a single tar.gz file
+// that can be shared with collaborators: config, registry, results, logs...
+// The bundle contains a manifest listing the SHA-256 checksum of every file
+// and the build information of the binary that created it, so the bundle can
+// be verified by whoever receives it.
+//
+// Registry files are always stripped of their private keys before being
+// bundled.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// ManifestName is the name of the manifest inside the bundle.
+const ManifestName = "MANIFEST.json"
+
+// registryPrivateField is the index of the private key in a registry line.
+// See lib.NewCSVParser.
+const registryPrivateField = 2
+
+// Manifest describes the content of a bundle.
+type Manifest struct {
+	// Created is the creation time of the bundle
+	Created time.Time
+	// Build describes the binary that created the bundle
+	Build BuildInfo
+	// Files maps the name of each file in the bundle to its hex encoded
+	// SHA-256 checksum
+	Files map[string]string
+}
+
+// BuildInfo describes a binary.
+type BuildInfo struct {
+	// Path of the main package
+	Path string
+	// Version of the main module
+	Version string
+	// GoVersion is the version of Go used to build the binary
+	GoVersion string
+	// Settings are the build settings such as the VCS revision
+	Settings map[string]string
+	// Checksum is the hex encoded SHA-256 of the binary itself
+	Checksum string
+}
+
+// Bundler collects the files to put in a bundle.
+type Bundler struct {
+	files map[string][]byte
+}
+
+// NewBundler returns an empty Bundler.
+func NewBundler() *Bundler {
+	return &Bundler{files: make(map[string][]byte)}
+}
+
+// Add adds the file at the given path under the given name.
+func (b *Bundler) Add(name, path string) error {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return b.AddBytes(name, buff)
+}
+
+// AddBytes adds the given content under the given name.
+func (b *Bundler) AddBytes(name string, content []byte) error {
+	if name == ManifestName {
+		return errors.New("bundle: reserved name " + name)
+	}
+	if _, exists := b.files[name]; exists {
+		return errors.New("bundle: duplicate file " + name)
+	}
+	b.files[name] = content
+	return nil
+}
+
+// AddRegistry adds the CSV registry file at the given path under the given
+// name, after removing the private keys of all the nodes.
+func (b *Bundler) AddRegistry(name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stripped, err := StripRegistry(file)
+	if err != nil {
+		return fmt.Errorf("bundle: registry %s: %s", path, err)
+	}
+	return b.AddBytes(name, stripped)
+}
+
+// AddDir adds all the regular files of the given directory, non recursively,
+// under the given prefix. A missing directory is not an error.
+func (b *Bundler) AddDir(prefix, dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := b.Add(prefix+info.Name(), filepath.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StripRegistry returns the CSV registry read from r where the private key of
+// every node is emptied.
+func StripRegistry(r io.Reader) ([]byte, error) {
+	reader := csv.NewReader(r)
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(line) <= registryPrivateField {
+			return nil, errors.New("unexpected registry format")
+		}
+		line[registryPrivateField] = ""
+		if err := writer.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return out.Bytes(), writer.Error()
+}
+
+// WriteTo writes the bundle with its manifest as a tar.gz file at the given
+// path.
+func (b *Bundler) WriteTo(path string) error {
+	manifest := &Manifest{
+		Created: time.Now(),
+		Build:   currentBuild(),
+		Files:   make(map[string]string),
+	}
+	var names []string
+	for name, content := range b.files {
+		manifest.Files[name] = checksum(content)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifestBuff, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	write := func(name string, content []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: manifest.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	if err := write(ManifestName, manifestBuff); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := write(name, b.files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Verify checks that the bundle at the given path contains exactly the files
+// listed in its manifest, with the same checksums. The returned error names
+// the first offending file. It returns the manifest if the bundle is valid.
+func Verify(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	var manifest *Manifest
+	checksums := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == ManifestName {
+			manifest = new(Manifest)
+			if err := json.Unmarshal(content, manifest); err != nil {
+				return nil, fmt.Errorf("bundle: invalid manifest: %s", err)
+			}
+			continue
+		}
+		checksums[hdr.Name] = checksum(content)
+	}
+	if manifest == nil {
+		return nil, errors.New("bundle: no manifest")
+	}
+	for name, sum := range manifest.Files {
+		got, exists := checksums[name]
+		if !exists {
+			return nil, fmt.Errorf("bundle: missing file %s", name)
+		}
+		if got != sum {
+			return nil, fmt.Errorf("bundle: checksum mismatch for file %s", name)
+		}
+	}
+	for name := range checksums {
+		if _, listed := manifest.Files[name]; !listed {
+			return nil, fmt.Errorf("bundle: unlisted file %s", name)
+		}
+	}
+	return manifest, nil
+}
+
+// FileChecksum returns the hex encoded SHA-256 checksum of the given file.
+func FileChecksum(path string) (string, error) {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return checksum(buff), nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// currentBuild returns the build information of the running binary.
+func currentBuild() BuildInfo {
+	var b BuildInfo
+	if info, ok := debug.ReadBuildInfo(); ok {
+		b.Path = info.Path
+		b.Version = info.Main.Version
+		b.GoVersion = info.GoVersion
+		b.Settings = make(map[string]string)
+		for _, s := range info.Settings {
+			b.Settings[s.Key] = s.Value
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		b.Checksum, _ = FileChecksum(exe)
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const registry = `0,127.0.0.1:3000,6b1f0c5e9d0a7f3e,1a2b3c4d
+1,127.0.0.1:3001,7c2e1d6f8e1b8a4f,5e6f7a8b
+`
+
+var privates = []string{"6b1f0c5e9d0a7f3e", "7c2e1d6f8e1b8a4f"}
+
+// rewrite copies the bundle at path, replacing the content of the given file.
+func rewrite(t *testing.T, path, out, name string, content []byte) {
+	in, err := os.Open(path)
+	require.NoError(t, err)
+	defer in.Close()
+	gzr, err := gzip.NewReader(in)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+
+	file, err := os.Create(out)
+	require.NoError(t, err)
+	defer file.Close()
+	gzw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gzw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		buff, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == name {
+			buff = content
+			hdr.Size = int64(len(buff))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(buff)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	regPath := write("local.csv", registry)
+	csvPath := write("results.csv", "run,nodes\n0,2\n")
+	write("logs/node-0.log", "line\n")
+	write("logs/node-1.log", "line\n")
+
+	b := NewBundler()
+	require.NoError(t, b.AddRegistry("registry.csv", regPath))
+	require.NoError(t, b.Add("results.csv", csvPath))
+	require.NoError(t, b.AddDir("logs/", filepath.Join(dir, "logs")))
+	require.NoError(t, b.AddDir("none/", filepath.Join(dir, "none")))
+	require.Error(t, b.Add("results.csv", csvPath))
+	require.Error(t, b.AddBytes(ManifestName, nil))
+
+	path := filepath.Join(dir, "test-bundle.tar.gz")
+	require.NoError(t, b.WriteTo(path))
+	manifest, err := Verify(path)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 4)
+	require.NotEmpty(t, manifest.Build.GoVersion)
+
+	// no private key material anywhere in the bundle
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	gzr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	raw, err := ioutil.ReadAll(gzr)
+	require.NoError(t, err)
+	f.Close()
+	for _, priv := range privates {
+		require.False(t, bytes.Contains(raw, []byte(priv)))
+	}
+	require.True(t, bytes.Contains(raw, []byte("1a2b3c4d")))
+
+	// a corrupted file is detected and named
+	corrupted := filepath.Join(dir, "corrupted.tar.gz")
+	rewrite(t, path, corrupted, "logs/node-1.log", []byte("forged\n"))
+	_, err = Verify(corrupted)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "logs/node-1.log"), err.Error())
+}
+
+func TestBundleStripRegistry(t *testing.T) {
+	out, err := StripRegistry(strings.NewReader(registry))
+	require.NoError(t, err)
+	require.Equal(t, "0,127.0.0.1:3000,,1a2b3c4d\n1,127.0.0.1:3001,,5e6f7a8b\n", string(out))
+
+	_, err = StripRegistry(strings.NewReader("0,127.0.0.1:3000\n"))
+	require.Error(t, err)
+}
@@ -85,7 +85,7 @@ func evaluatorScenario(dir string, defaultConf lib.Config, handel *lib.HandelCon
 
 			run := lib.RunConfig{
 				Nodes:     node,
-				Threshold: thrF(thr)(node),
+				Threshold: lib.Threshold{Count: thrF(thr)(node)},
 				Failing:   failing,
 				Processes: procF(node),
 				Handel:    handelConf,
@@ -118,7 +118,7 @@ func nodeCountScenario(dir string, defaultConf lib.Config, handel *lib.HandelCon
 
 			run := lib.RunConfig{
 				Nodes:     node,
-				Threshold: thrF(thr)(node),
+				Threshold: lib.Threshold{Count: thrF(thr)(node)},
 				Failing:   failing,
 				Processes: procF(node),
 				Handel:    handelConf,
@@ -151,7 +151,7 @@ func updateCountScenario(dir string, defaultConf lib.Config, handel *lib.HandelC
 
 			run := lib.RunConfig{
 				Nodes:     node,
-				Threshold: thrF(thr)(node),
+				Threshold: lib.Threshold{Count: thrF(thr)(node)},
 				Failing:   failing,
 				Processes: procF(node),
 				Handel:    handelConf,
@@ -174,7 +174,7 @@ func practicalScenario(dir string, defaultConf lib.Config, handel *lib.HandelCon
 	for _, node := range nodes {
 		run := lib.RunConfig{
 			Nodes:     node,
-			Threshold: thrF(thr)(node),
+			Threshold: lib.Threshold{Count: thrF(thr)(node)},
 			Failing:   thrF(failing)(node),
 			Processes: procF(node),
 			Handel:    handel,
@@ -202,7 +202,7 @@ func libp2pScenario(dir string, defaultConf lib.Config, handel *lib.HandelConfig
 			for _, n := range nodes {
 				run := lib.RunConfig{
 					Nodes:     n,
-					Threshold: thrF(thr)(n),
+					Threshold: lib.Threshold{Count: thrF(thr)(n)},
 					Failing:   0,
 					Processes: procF(n),
 					Handel:    handel,
@@ -237,7 +237,7 @@ func nsquareScenario(dir string, defaultConf lib.Config, handel *lib.HandelConfi
 			for _, n := range nodes {
 				run := lib.RunConfig{
 					Nodes:     n,
-					Threshold: thrF(thr)(n),
+					Threshold: lib.Threshold{Count: thrF(thr)(n)},
 					Failing:   0,
 					Processes: procF(n),
 					Handel:    handel,
@@ -281,7 +281,7 @@ func periodIncScenario(dir string, defaultConf lib.Config, handel *lib.HandelCon
 			}
 			run := lib.RunConfig{
 				Nodes:     nodes,
-				Threshold: threshold,
+				Threshold: lib.Threshold{Count: threshold},
 				Failing:   failing,
 				Processes: procF(nodes),
 				Handel:    handelConf,
@@ -323,7 +323,7 @@ func timeoutIncScenario(dir string, defaultConf lib.Config, handel *lib.HandelCo
 				}
 				run := lib.RunConfig{
 					Nodes:     node,
-					Threshold: threshold,
+					Threshold: lib.Threshold{Count: threshold},
 					Failing:   failing,
 					Processes: procF(node),
 					Handel:    handelConf,
@@ -357,7 +357,7 @@ func failingIncScenario(dir string, defaultConf lib.Config, handel *lib.HandelCo
 			fmt.Printf("failing %d ,thr %d for %d nodes\n", failing, threshold, nodes)
 			run := lib.RunConfig{
 				Nodes:     nodes,
-				Threshold: threshold,
+				Threshold: lib.Threshold{Count: threshold},
 				Failing:   failing,
 				Processes: procF(nodes),
 				Handel:    handel,
@@ -384,7 +384,7 @@ func thresholdIncScenario2(dir string, defaultConf lib.Config, handel *lib.Hande
 			threshold := thrF(thr)(nodes)
 			run := lib.RunConfig{
 				Nodes:     nodes,
-				Threshold: threshold,
+				Threshold: lib.Threshold{Count: threshold},
 				Failing:   0,
 				Processes: procF(nodes),
 				Handel:    handel,
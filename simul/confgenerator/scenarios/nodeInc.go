@@ -34,7 +34,7 @@ func (n NodesInc) Generate(proc int, nodesCt []int) lib.Config {
 	for _, nodeCt := range nodesCt{
 		run := lib.RunConfig{
 			Nodes:     nodeCt,
-			Threshold: n.calcThreshold(nodeCt),
+			Threshold: lib.Threshold{Count: n.calcThreshold(nodeCt)},
 			Failing:   n.failing,
 			Processes: nodeCt / proc,
 			Handel:    n.defaultHandel,
@@ -0,0 +1,70 @@
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// width of the progress bars of the barriers
+const barWidth = 40
+
+// clear moves the cursor home and clears the screen
+const clear = "\033[H\033[2J"
+
+// IsTerminal returns true if the file is a terminal, in which case the
+// dashboard can redraw it.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Render draws the snapshot on the writer, replacing the previous one
+func Render(w io.Writer, s *Snapshot) {
+	var b strings.Builder
+	b.WriteString(clear)
+	fmt.Fprintf(&b, "Handel simulation - %s elapsed\n\n", s.Elapsed.Truncate(time.Second))
+	for _, barrier := range s.Barriers {
+		fmt.Fprintf(&b, "%-8s %s %d/%d\n", barrier.Name, bar(barrier.Ready, barrier.Expected), barrier.Ready, barrier.Expected)
+	}
+	fmt.Fprintf(&b, "\nmeasures %d (%.1f/s)\n\n", s.Received, s.Rate)
+	if len(s.Measures) > 0 {
+		fmt.Fprintf(&b, "%-20s %8s %12s %12s %12s %12s\n", "measure", "count", "avg", "min", "max", "sum")
+	}
+	for _, m := range s.Measures {
+		fmt.Fprintf(&b, "%-20s %8d %12.4g %12.4g %12.4g %12.4g\n", m.Name, m.Count, m.Avg, m.Min, m.Max, m.Sum)
+	}
+	io.WriteString(w, b.String())
+}
+
+func bar(ready, expected int) string {
+	filled := 0
+	if expected > 0 {
+		filled = ready * barWidth / expected
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled) + "]"
+}
+
+// Run renders a snapshot on the writer at every period until stop is closed,
+// and renders a last one before returning.
+func Run(w io.Writer, b *Builder, period time.Duration, stop chan bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		Render(w, b.Snapshot(time.Now()))
+		select {
+		case <-stop:
+			Render(w, b.Snapshot(time.Now()))
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package dashboard renders the progress of a simulation run in the terminal
+// of the master: the synchronization barriers, the rate at which measures are
+// received and the statistics of the key measures received so far.
+package dashboard
+
+import (
+	"time"
+
+	"github.com/ConsenSys/handel/simul/monitor"
+)
+
+// KeyMeasures are the measures shown by the dashboard when received
+var KeyMeasures = []string{
+	"sigen_wall",
+	"net_sent",
+	"net_rcvd",
+	"sigs_sigCheckedCt",
+	"sigs_sigQueueSize",
+}
+
+// Barrier is the progress of a synchronization barrier of the master
+type Barrier struct {
+	Name     string
+	Ready    int
+	Expected int
+}
+
+// Measure holds the statistics received so far for a measure
+type Measure struct {
+	Name string
+	monitor.Summary
+}
+
+// Snapshot is the state of a run at a given time, as shown by the dashboard
+type Snapshot struct {
+	Time     time.Time
+	Elapsed  time.Duration
+	Barriers []Barrier
+	// Received is the total number of measures received
+	Received int
+	// Rate is the number of measures received per second since the previous
+	// snapshot
+	Rate     float64
+	Measures []Measure
+}
+
+// Builder builds the successive snapshots of a run from the stats of the
+// monitor and the barriers of the master.
+type Builder struct {
+	stats    *monitor.Stats
+	barriers func() []Barrier
+	start    time.Time
+	prev     *Snapshot
+}
+
+// NewBuilder returns a Builder reading the given stats and barriers, for a run
+// started at the given time.
+func NewBuilder(stats *monitor.Stats, barriers func() []Barrier, start time.Time) *Builder {
+	return &Builder{
+		stats:    stats,
+		barriers: barriers,
+		start:    start,
+	}
+}
+
+// Snapshot returns the state of the run at the given time
+func (b *Builder) Snapshot(now time.Time) *Snapshot {
+	s := &Snapshot{
+		Time:     now,
+		Elapsed:  now.Sub(b.start),
+		Barriers: b.barriers(),
+		Received: b.stats.Received(),
+	}
+	last, lastTime := 0, b.start
+	if b.prev != nil {
+		last, lastTime = b.prev.Received, b.prev.Time
+	}
+	if secs := now.Sub(lastTime).Seconds(); secs > 0 {
+		s.Rate = float64(s.Received-last) / secs
+	}
+	for _, name := range KeyMeasures {
+		if sum, ok := b.stats.Summary(name); ok {
+			s.Measures = append(s.Measures, Measure{Name: name, Summary: sum})
+		}
+	}
+	b.prev = s
+	return s
+}
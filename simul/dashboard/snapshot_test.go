@@ -0,0 +1,51 @@
+package dashboard
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel/simul/monitor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderSnapshot(t *testing.T) {
+	stats := monitor.NewStats(map[string]string{"run": "0"}, nil)
+	barriers := []Barrier{{Name: "start", Ready: 3, Expected: 10}}
+	start := time.Unix(1000, 0)
+	b := NewBuilder(stats, func() []Barrier { return barriers }, start)
+
+	s := b.Snapshot(start.Add(time.Second))
+	require.Equal(t, time.Second, s.Elapsed)
+	require.Equal(t, barriers, s.Barriers)
+	require.Equal(t, 0, s.Received)
+	require.Equal(t, 0.0, s.Rate)
+	require.Len(t, s.Measures, 0)
+
+	for _, v := range []float64{1, 2, 3, 6} {
+		stats.Store("sigen_wall", v)
+	}
+	stats.Store("net_sent", 100)
+	stats.Store("net_sent", 300)
+	stats.Store("unknown", 1)
+	barriers = []Barrier{{Name: "start", Ready: 10, Expected: 10}, {Name: "end", Ready: 4, Expected: 10}}
+
+	s = b.Snapshot(start.Add(3 * time.Second))
+	require.Equal(t, 3*time.Second, s.Elapsed)
+	require.Equal(t, barriers, s.Barriers)
+	require.Equal(t, 7, s.Received)
+	// 7 measures in the 2 seconds since the previous snapshot
+	require.Equal(t, 3.5, s.Rate)
+	require.Equal(t, []Measure{
+		{Name: "sigen_wall", Summary: monitor.Summary{Count: 4, Min: 1, Max: 6, Sum: 12, Avg: 3}},
+		{Name: "net_sent", Summary: monitor.Summary{Count: 2, Min: 100, Max: 300, Sum: 400, Avg: 200}},
+	}, s.Measures)
+
+	s = b.Snapshot(start.Add(4 * time.Second))
+	require.Equal(t, 0.0, s.Rate)
+
+	var out bytes.Buffer
+	Render(&out, s)
+	require.Contains(t, out.String(), "4/10")
+	require.Contains(t, out.String(), "sigen_wall")
+}
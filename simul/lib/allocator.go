@@ -93,12 +93,15 @@ func (r *RoundRobin) Allocate(plats []Platform, total, offline int) map[string][
 // randomly offline nodes in a round robin way for each platforms
 type RoundRandomOffline struct {
 	Allocator
+	rnd *rand.Rand
 }
 
-// NewRoundRandomOffline returns a RoundRandomOffline allocator
-func NewRoundRandomOffline() Allocator {
+// NewRoundRandomOffline returns a RoundRandomOffline allocator choosing the
+// offline nodes from the given seed
+func NewRoundRandomOffline(seed int64) Allocator {
 	return &RoundRandomOffline{
 		Allocator: new(RoundRobin),
+		rnd:       rand.New(rand.NewSource(seed)),
 	}
 }
 
@@ -151,7 +154,7 @@ func (r *RoundRandomOffline) Allocate(plats []Platform, total, offline int) map[
 			n := len(list)
 			var i int
 			// search first index where we have an active one randomly
-			for i = rand.Intn(n); list[i].Active == false; i = rand.Intn(n) {
+			for i = r.rnd.Intn(n); list[i].Active == false; i = r.rnd.Intn(n) {
 			}
 			list[i].Active = false
 			offline--
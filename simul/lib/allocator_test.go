@@ -23,7 +23,7 @@ func TestAllocator(t *testing.T) {
 	}
 
 	robin := new(RoundRobin)
-	random := NewRoundRandomOffline()
+	random := NewRoundRandomOffline(1)
 
 	// create one platform from the integer
 	p := func(n int) Platform {
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+// Bandwidth limits the upload bandwidth of a fraction of the nodes of a run,
+// e.g. to model home validators with a slow uplink. The download bandwidth is
+// not limited.
+type Bandwidth struct {
+	// Fraction of the nodes limited, between 0 and 1
+	Fraction float64
+	// UploadKbps is the sustained upload rate of these nodes, in kilobits
+	// per second
+	UploadKbps int
+	// Burst is the number of bytes these nodes can send at once above the
+	// sustained rate. Zero means DefaultBurst.
+	Burst int
+	// Drop makes these nodes drop the packets sent above the rate instead of
+	// delaying them
+	Drop bool
+}
+
+// DefaultBurst is the default burst of the limited nodes, in bytes
+const DefaultBurst = 16 * 1024
+
+// GetBurst returns the burst of the limited nodes, in bytes
+func (b *Bandwidth) GetBurst() int {
+	if b.Burst == 0 {
+		return DefaultBurst
+	}
+	return b.Burst
+}
+
+// GetUploadLimits returns the upload rate in Kbps of the limited nodes of
+// this run, by ID. As GetChurn, the assignment is deterministic, seeded by the
+// index of the run, so the platform and the nodes compute the same one.
+func (r *RunConfig) GetUploadLimits(run int) map[int]int {
+	limits := make(map[int]int)
+	if r.Bandwidth == nil {
+		return limits
+	}
+	// not the permutation of the churn, so slow nodes are not all late ones
+	perm := rand.New(rand.NewSource(int64(run) + 1)).Perm(r.Nodes)
+	n := int(r.Bandwidth.Fraction * float64(r.Nodes))
+	for _, id := range perm[:n] {
+		limits[id] = r.Bandwidth.UploadKbps
+	}
+	return limits
+}
+
+// UploadStats returns the static fields describing the upload limits of the
+// run: the IDs of the limited nodes, separated by dashes, and their rate.
+func (r *RunConfig) UploadStats(run int) map[string]string {
+	ids := make(map[int]bool)
+	for id := range r.GetUploadLimits(run) {
+		ids[id] = true
+	}
+	kbps := ""
+	if len(ids) > 0 {
+		kbps = strconv.Itoa(r.Bandwidth.UploadKbps)
+	}
+	return map[string]string{
+		"slowUpload": joinIDs(ids),
+		"uploadKbps": kbps,
+	}
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LateStart makes a fraction of the nodes of a run join the protocol late.
+type LateStart struct {
+	// Fraction of the nodes starting late, between 0 and 1
+	Fraction float64
+	// Delay after the START signal at which these nodes start Handel
+	Delay string
+}
+
+// EarlyStop makes a fraction of the nodes of a run leave the protocol before
+// the end.
+type EarlyStop struct {
+	// Fraction of the nodes stopping early, between 0 and 1
+	Fraction float64
+	// After is the time after the START signal at which these nodes stop
+	// Handel
+	After string
+}
+
+// Churn holds the churn behaviors assigned to the nodes of a run. A node is
+// either late, early or neither, never both.
+type Churn struct {
+	// Late contains the IDs of the nodes starting late
+	Late map[int]bool
+	// Delay is the time late nodes wait before starting Handel
+	Delay time.Duration
+	// Early contains the IDs of the nodes stopping early
+	Early map[int]bool
+	// After is the time early nodes wait before stopping Handel
+	After time.Duration
+}
+
+// GetChurn returns the churn behaviors assigned to the nodes of this run. The
+// assignment is deterministic, seeded by the index of the run, so the
+// platform and all the nodes compute the same assignment without exchanging
+// it. Fractions are applied to the total number of nodes of the run, failing
+// nodes included.
+func (r *RunConfig) GetChurn(run int) *Churn {
+	c := &Churn{
+		Late:  make(map[int]bool),
+		Early: make(map[int]bool),
+	}
+	perm := rand.New(rand.NewSource(int64(run))).Perm(r.Nodes)
+	if r.LateStart != nil {
+		d, err := time.ParseDuration(r.LateStart.Delay)
+		if err != nil {
+			panic(err)
+		}
+		c.Delay = d
+		n := int(r.LateStart.Fraction * float64(r.Nodes))
+		for _, id := range perm[:n] {
+			c.Late[id] = true
+		}
+		perm = perm[n:]
+	}
+	if r.EarlyStop != nil {
+		d, err := time.ParseDuration(r.EarlyStop.After)
+		if err != nil {
+			panic(err)
+		}
+		c.After = d
+		n := int(r.EarlyStop.Fraction * float64(r.Nodes))
+		if n > len(perm) {
+			panic("churn: more late and early nodes than nodes")
+		}
+		for _, id := range perm[:n] {
+			c.Early[id] = true
+		}
+	}
+	return c
+}
+
+// Stats returns the static fields describing the churn behaviors of the run.
+// The lists of IDs are separated by dashes to fit in a single CSV field.
+func (c *Churn) Stats() map[string]string {
+	return map[string]string{
+		"lateStart":  joinIDs(c.Late),
+		"lateDelay":  c.Delay.String(),
+		"earlyStop":  joinIDs(c.Early),
+		"earlyAfter": c.After.String(),
+	}
+}
+
+func joinIDs(ids map[int]bool) string {
+	var sorted []int
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+	var strs = make([]string, len(sorted))
+	for i, id := range sorted {
+		strs[i] = strconv.Itoa(id)
+	}
+	return strings.Join(strs, "-")
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChurn(t *testing.T) {
+	r := &RunConfig{
+		Nodes:     100,
+		LateStart: &LateStart{Fraction: 0.2, Delay: "2s"},
+		EarlyStop: &EarlyStop{Fraction: 0.1, After: "500ms"},
+	}
+	c := r.GetChurn(1)
+	require.Len(t, c.Late, 20)
+	require.Len(t, c.Early, 10)
+	require.Equal(t, 2*time.Second, c.Delay)
+	require.Equal(t, 500*time.Millisecond, c.After)
+	for id := range c.Early {
+		require.False(t, c.Late[id])
+	}
+
+	// same run gives the same assignment, other runs a different one
+	require.Equal(t, c, r.GetChurn(1))
+	require.NotEqual(t, c.Late, r.GetChurn(2).Late)
+
+	stats := c.Stats()
+	require.Equal(t, "2s", stats["lateDelay"])
+	require.Equal(t, joinIDs(c.Early), stats["earlyStop"])
+
+	empty := (&RunConfig{Nodes: 10}).GetChurn(0)
+	require.Len(t, empty.Late, 0)
+	require.Equal(t, "", empty.Stats()["lateStart"])
+
+	tooMany := &RunConfig{
+		Nodes:     10,
+		LateStart: &LateStart{Fraction: 0.8, Delay: "1s"},
+		EarlyStop: &EarlyStop{Fraction: 0.5, After: "1s"},
+	}
+	require.Panics(t, func() { tooMany.GetChurn(0) })
+}
@@ -2,6 +2,8 @@ package lib
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"path"
@@ -12,11 +14,13 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/bits"
 	cf "github.com/ConsenSys/handel/bn256/cf"
 	golang "github.com/ConsenSys/handel/bn256/go"
 	"github.com/ConsenSys/handel/network"
 	"github.com/ConsenSys/handel/network/quic"
 	"github.com/ConsenSys/handel/network/udp"
+	"github.com/ConsenSys/handel/network/ws"
 	"github.com/ConsenSys/handel/simul/monitor"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -31,6 +35,7 @@ func init() {
 	}
 	resultsDir = path.Join(currentDir, "results")
 	os.MkdirAll(resultsDir, 0777)
+	handel.RegisterConfigFunc("equal", equalEvaluatorStrategy)
 }
 
 // Message that will get signed
@@ -42,10 +47,11 @@ type Config struct {
 	// private fields do not get marshalled
 	configPath string
 	// which network should we use
-	// Valid value: "udp" (default)
+	// Valid value: "udp" (default), "quic-test-insecure", "quic" with
+	// MutualTLS or "ws"
 	Network string
-	// which "curve system" should we use
-	// Valid value: "bn256" (default)
+	// which "curve system" should we use - runs can override it
+	// Valid value: "bn256" (default), "bn256/go" or "fake"
 	Curve string
 	// which encoding should we use on the network
 	// valid value: "gob" (default)
@@ -67,6 +73,70 @@ type Config struct {
 	Retrials int
 	// to which file should we write the results
 	ResultFile string
+	// LogSink is the address of the orchestrator's log sink where nodes stream
+	// their logs to. It is set by the orchestrator when log streaming is
+	// enabled - empty means nodes only log locally.
+	LogSink string
+	// CoalesceDelay enables the send coalescing of the udp network: packets
+	// wait this delay in a queue per destination where a newer packet of the
+	// same level replaces them. Empty disables it. Parsed by
+	// time.ParseDuration.
+	CoalesceDelay string
+	// RawDump makes the monitor dump every measure it receives in the results
+	// directory, in results/raw_runX.ndjson.gz, so the statistics can be
+	// recomputed offline with simul/analyze.
+	RawDump bool
+	// MaxSamples is the maximum number of samples the monitor keeps per
+	// measure, past which it keeps a random sample of them: the min, max,
+	// avg, sum and dev of the results stay exact while the percentiles become
+	// estimates - see monitor.Value. 0 means monitor.DefaultMaxSamples and a
+	// negative value keeps all the samples.
+	MaxSamples int
+	// CheckpointPeriod is the period, as "10s", at which the master writes the
+	// state of the monitor in the results directory, so a master restarted
+	// with -recover resumes the run it was collecting. Empty disables it.
+	CheckpointPeriod string
+	// SharedSocket makes the nodes run the sync protocol over the socket of
+	// their first Handel identity instead of a dedicated sync port. Only
+	// supported with the "udp" network.
+	SharedSocket bool
+	// PortPerLevel makes each node bind one more port per level, following
+	// the port of its address, and send the packets of each level from and
+	// to the port of the level, so the traffic of each level can be told
+	// apart. Only supported with the "udp" network.
+	PortPerLevel bool
+	// Encrypted makes the nodes encrypt the packets exchanged with each peer
+	// with a key derived from their keys in the registry - see
+	// network.EncryptedNetwork. Only supported by the curves whose secret
+	// keys implement handel.DHSecretKey.
+	Encrypted bool
+	// MutualTLS makes the nodes of the "quic" network authenticate each other
+	// with certificates binding the connections to their identities - see
+	// quic.NewMutualTLSConfig. The certificates are read from TLSDir.
+	MutualTLS bool
+	// TLSDir is the directory of the certificates of MutualTLS, as written
+	// by WriteCertificates. When empty, the localhost platform generates
+	// them for each run; the other platforms need it to hold them on every
+	// host.
+	TLSDir string
+	// SyncRelease is the policy of the sync master releasing the START and
+	// END barriers: "all", "fraction:p" or "adaptive", optionally followed by
+	// the window as in "adaptive:10s" - see ParseReleasePolicy. Empty means
+	// DefaultSyncRelease.
+	SyncRelease string
+	// RegistryLoad are the options of the nodes loading the registry file -
+	// see LoadOptions. By default a malformed record aborts the run. The nodes
+	// of the skipped records don't run: the sync master only releases the
+	// barriers without them with a SyncRelease other than "all".
+	RegistryLoad LoadOptions
+	// Optimum makes the master write, along the results of each run, the
+	// theoretical dissemination time of the run over a network of the given
+	// RTT and loss rate, and flag the runs too slow compared to it - see
+	// Optimum. Nil disables it.
+	Optimum *OptimumConfig
+	// Tracing makes the nodes export the timeline of their aggregations as
+	// OpenTelemetry traces - see TracingConfig. Nil disables it.
+	Tracing *TracingConfig
 	// config for each run
 	Runs []RunConfig
 }
@@ -76,14 +146,44 @@ type Config struct {
 type RunConfig struct {
 	// How many nodes should we spin for this run
 	Nodes int
-	// threshold of signatures to wait for
-	Threshold int
+	// threshold of signatures to wait for, a number or a percentage of the
+	// nodes - see Threshold
+	Threshold Threshold
 	// Number of failing nodes
 	Failing int
 	// Number of processes for this run
 	Processes int
 	// Handel items configurable  - will be merged with defaults
 	Handel *HandelConfig
+	// LateStart makes a fraction of the nodes start late - see GetChurn
+	LateStart *LateStart
+	// EarlyStop makes a fraction of the nodes stop early - see GetChurn
+	EarlyStop *EarlyStop
+	// Bandwidth limits the upload rate of a fraction of the nodes - see
+	// GetUploadLimits
+	Bandwidth *Bandwidth
+	// Curve overrides the curve system of the config for this run, to compare
+	// crypto backends within a sweep - empty means the global curve
+	Curve string
+	// Repetitions is the number of times each node runs the aggregation
+	// back-to-back within the run, on a distinct message each time, so the
+	// startup of the processes is amortized - see GetRepetitions. The
+	// results aggregate the measures of all the repetitions. The platforms
+	// wait MaxTimeout for the end of all of them. 0 means 1.
+	Repetitions int
+	// AdaptiveSchedule makes the nodes activate the levels on the schedule
+	// learned from the first contribution times of their previous
+	// repetitions, from the second one, instead of the schedule of the
+	// Handel config - see handel.ScheduleAdvisor. It requires Repetitions.
+	AdaptiveSchedule bool
+	// Seed is the seed of the random components of the run, from which each
+	// one derives its own seed - see SubSeed. Zero means a random seed,
+	// recorded in the results so the run can be reproduced.
+	Seed int64
+	// Protocol is the aggregation protocol run by the nodes, ProtocolHandel
+	// or ProtocolCollector to measure the baseline Handel is compared to.
+	// Empty means ProtocolHandel.
+	Protocol string
 	// extra for particular information for specific platform for examples
 	Extra map[string]string
 }
@@ -95,6 +195,13 @@ type HandelConfig struct {
 	Period string
 	// Number of node do we contact for each periodic update
 	UpdateCount int
+	// UpdateFraction is the fraction of the peers of a level contacted for
+	// each periodic update, at least UpdateCount, so the higher levels are
+	// covered faster. Zero contacts UpdateCount peers at all levels.
+	UpdateFraction float64
+	// UpdateOnImprovement makes the nodes send the signature of a level as
+	// soon as it improves, see handel.Config.UpdateOnImprovement
+	UpdateOnImprovement bool
 	// Number of node do we contact when starting level + when finishing level
 	// XXX - maybe remove in the futur ! -
 	NodeCount int
@@ -104,7 +211,26 @@ type HandelConfig struct {
 	UnsafeSleepTimeOnSigVerify int
 
 	// which queue evaluator are we choosing
+	// valid values: "store" (default), "equal" or "cost"
 	Evaluator string
+
+	// which signatures are sent in the updates
+	// valid values: "combined-prefix" (default), "best-level" or "full"
+	UpdatePayload string
+
+	// StrictInvariants makes the nodes panic when an invariant of Handel is
+	// violated instead of only logging it
+	StrictInvariants bool
+
+	// QueueCapacity is the capacity of the processing queue of the nodes,
+	// they shed the incoming packets as it fills up. Zero disables the
+	// shedding.
+	QueueCapacity int
+
+	// KeyRotationGrace is the time during which the nodes accept the previous
+	// keys of the records, as "30s", see handel.Config.KeyRotationGrace.
+	// Empty accepts the current keys only.
+	KeyRotationGrace string
 }
 
 // LoadConfig looks up the given file to unmarshal a TOML encoded Config.
@@ -120,6 +246,14 @@ func LoadConfig(path string) *Config {
 	if c.Simulation == "" {
 		c.Simulation = "handel"
 	}
+	for i := range c.Runs {
+		if err := c.Runs[i].Threshold.resolve(c.Runs[i].Nodes); err != nil {
+			panic(fmt.Errorf("run %d: %s", i, err))
+		}
+		if p := c.Runs[i].GetProtocol(); p != ProtocolHandel && p != ProtocolCollector {
+			panic(fmt.Errorf("run %d: unknown protocol %q", i, p))
+		}
+	}
 	c.configPath = path
 	return c
 }
@@ -138,14 +272,40 @@ func (c *Config) WriteTo(path string) error {
 
 // Logger returns the logger set to the right verbosity with timestamp added
 func (c *Config) Logger() handel.Logger {
-	var logger handel.Logger
+	return c.LoggerTo(os.Stdout)
+}
+
+// LoggerTo is similar to Logger but writes the statements to the given writer
+// instead of stdout.
+func (c *Config) LoggerTo(w io.Writer) handel.Logger {
+	return c.loggerTo(w, c.Debug != 0)
+}
+
+// VerboseLoggerTo is similar to LoggerTo but always forwards the debug
+// output, for the Handel instances whose level is set by LogLevel and can be
+// changed at runtime.
+func (c *Config) VerboseLoggerTo(w io.Writer) handel.Logger {
+	return c.loggerTo(w, true)
+}
+
+// LogLevel returns the lowest level of the statements logged by Handel
+func (c *Config) LogLevel() handel.LogLevel {
 	if c.Debug != 0 {
-		logger = handel.NewKitLogger(level.AllowDebug())
+		return handel.LogDebug
+	}
+	return handel.LogInfo
+}
+
+func (c *Config) loggerTo(w io.Writer, debug bool) handel.Logger {
+	var logger = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	if debug {
+		logger = level.NewFilter(logger, level.AllowDebug())
 	} else {
-		logger = handel.NewKitLogger(level.AllowInfo())
+		logger = level.NewFilter(logger, level.AllowInfo())
 	}
+	logger = log.With(logger, "call", log.Caller(8))
 	//return logger.With("ts", log.DefaultTimestamp)
-	return logger.With("ts", log.TimestampFormat(time.Now, time.StampMilli))
+	return handel.NewKitLoggerFrom(logger).With("ts", log.TimestampFormat(time.Now, time.StampMilli))
 }
 
 // MaxNodes returns the maximum number of nodes to test
@@ -160,34 +320,92 @@ func (c *Config) MaxNodes() int {
 }
 
 // NewNetwork returns the network implementation designated by this config for this
-// given identity
-func (c *Config) NewNetwork(id handel.Identity) handel.Network {
+// given identity. The registry is used by networks authenticating their peers.
+func (c *Config) NewNetwork(id handel.Identity, reg handel.Registry) handel.Network {
 	if c.Network == "" {
 		c.Network = "udp"
 	}
-	netw, err := c.selectNetwork(id)
+	netw, err := c.selectNetwork(id, reg)
 	if err != nil {
 		panic(err)
 	}
 	return netw
 }
 
-func (c *Config) selectNetwork(id handel.Identity) (handel.Network, error) {
+// Encrypt returns the network of the node encrypting its packets if the
+// config is Encrypted, and the network as is otherwise
+func (c *Config) Encrypt(n handel.Network, node *Node, reg handel.Registry) handel.Network {
+	if !c.Encrypted {
+		return n
+	}
+	// fails now rather than on each packet with a curve without key exchange
+	if dh, ok := node.SecretKey.(handel.DHSecretKey); ok {
+		if _, err := dh.DiffieHellman(node.Identity.PublicKey()); err != nil {
+			panic(err)
+		}
+	}
+	encrypted, err := network.NewEncryptedNetwork(n, node.ID(), node.SecretKey, reg)
+	if err != nil {
+		panic(err)
+	}
+	return encrypted
+}
+
+func (c *Config) selectNetwork(id handel.Identity, reg handel.Registry) (handel.Network, error) {
 	encoding := c.NewEncoding()
+	if c.PortPerLevel && c.Network != "udp" {
+		return nil, errors.New("port per level only implemented for the udp network")
+	}
 	switch c.Network {
 	case "udp":
-		return udp.NewNetwork(id.Address(), encoding)
+		return udp.NewNetworkWithOptions(id.Address(), encoding, c.UDPOptions(reg.Size()))
 	case "quic-test-insecure":
 		cfg := quic.NewInsecureTestConfig()
 		return quic.NewNetwork(id.Address(), encoding, cfg)
 	case "quic":
-		return nil, errors.New("quic implemented implemented only in test mode")
+		if !c.MutualTLS {
+			return nil, errors.New("quic implemented only in test mode or with MutualTLS")
+		}
+		if c.TLSDir == "" {
+			return nil, errors.New("quic: MutualTLS without TLSDir")
+		}
+		cfg, err := quic.LoadMutualTLSConfig(c.TLSDir, id.ID(), quic.DefaultHandshakeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return quic.NewNetwork(id.Address(), encoding, cfg)
+	case "ws":
+		return ws.NewNetwork(id, reg, encoding)
 
 	default:
 		return nil, errors.New("not implemented yet")
 	}
 }
 
+// UDPOptions returns the options of the udp network set in the config, for a
+// registry of the given size
+func (c *Config) UDPOptions(nodes int) udp.Options {
+	opts := udp.Options{LevelPorts: c.LevelPorts(nodes)}
+	if c.CoalesceDelay != "" {
+		delay, err := time.ParseDuration(c.CoalesceDelay)
+		if err != nil {
+			panic(err)
+		}
+		opts.CoalesceDelay = delay
+	}
+	return opts
+}
+
+// LevelPorts returns the number of ports each node binds after the port of
+// its address for a registry of the given size, i.e. one per level if
+// PortPerLevel is set, zero otherwise.
+func (c *Config) LevelPorts(nodes int) int {
+	if !c.PortPerLevel {
+		return 0
+	}
+	return bits.LevelsForSize(nodes)
+}
+
 // NewEncoding returns the corresponding network encoding
 func (c *Config) NewEncoding() network.Encoding {
 	newEnc := func() network.Encoding {
@@ -207,31 +425,59 @@ func (c *Config) NewEncoding() network.Encoding {
 }
 
 // NewConstructor returns a Constructor that is using the curve denoted by the
-// curve field of the config. Valid input so far is "bn256".
+// curve field of the config. See NewCurveConstructor for the valid values.
 func (c *Config) NewConstructor() Constructor {
 	if c.Curve == "" {
 		c.Curve = "bn256/cf"
 	}
-	switch c.Curve {
+	return NewCurveConstructor(c.Curve)
+}
+
+// GetUpdatePayload returns the payload of the updates of the given run
+func (c *Config) GetUpdatePayload(r *RunConfig) string {
+	if r.Handel == nil || r.Handel.UpdatePayload == "" {
+		return handel.UpdateCombinedPrefix
+	}
+	return r.Handel.UpdatePayload
+}
+
+// GetCurve returns the curve system used by the given run: the curve of the
+// run if set, the curve of the config otherwise.
+func (c *Config) GetCurve(r *RunConfig) string {
+	if r.Curve != "" {
+		return r.Curve
+	}
+	if c.Curve == "" {
+		c.Curve = "bn256/cf"
+	}
+	return c.Curve
+}
+
+// NewCurveConstructor returns a Constructor using the given curve system.
+// Valid input so far is "bn256" (same as "bn256/cf"), "bn256/go" and "fake".
+func NewCurveConstructor(curve string) Constructor {
+	switch curve {
 	case "bn256":
 		fallthrough
 	case "bn256/cf":
 		return &SimulConstructor{cf.NewConstructor()}
 	case "bn256/go":
 		return &SimulConstructor{golang.NewConstructor()}
+	case "fake":
+		return NewFakeConstructor()
 	default:
 		panic("not implemented yet")
 	}
 }
 
 // NewAllocator returns the allocation determined by the "Allocator" string field
-// of the config.
-func (c *Config) NewAllocator() Allocator {
+// of the config, for the given run.
+func (c *Config) NewAllocator(r *RunConfig) Allocator {
 	switch c.Allocator {
 	case "round":
 		return new(RoundRobin)
 	case "random":
-		return NewRoundRandomOffline()
+		return NewRoundRandomOffline(r.SubSeed(SeedFailures))
 	default:
 		return new(RoundRobin)
 	}
@@ -246,14 +492,40 @@ func (c *Config) GetMaxTimeout() time.Duration {
 	return dd
 }
 
+// GetCheckpointPeriod returns the period of the checkpoints of the monitor,
+// zero if disabled
+func (c *Config) GetCheckpointPeriod() time.Duration {
+	if c.CheckpointPeriod == "" {
+		return 0
+	}
+	dd, err := time.ParseDuration(c.CheckpointPeriod)
+	if err != nil {
+		panic(err)
+	}
+	return dd
+}
+
+// NewReleasePolicy returns the release policy of the sync master
+func (c *Config) NewReleasePolicy() ReleasePolicy {
+	p, err := ParseReleasePolicy(c.SyncRelease)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
 // GetMonitorAddress returns a full IP address composed of the given address
 // apprended with the port from the config.
 func (c *Config) GetMonitorAddress(ip string) string {
 	return net.JoinHostPort(ip, strconv.Itoa(c.MonitorPort))
 }
 
-// GetCSVFile returns a name of the CSV file
+// GetCSVFile returns a name of the CSV file: ResultFile if set, otherwise
+// the name of the config file with a csv extension.
 func (c *Config) GetCSVFile() string {
+	if c.ResultFile != "" {
+		return c.ResultFile
+	}
 	csvName := strings.Replace(filepath.Base(c.configPath), ".toml", ".csv", 1)
 	return csvName
 }
@@ -278,13 +550,74 @@ func (c *Config) GetBinaryPath() string {
 	return filepath.Join(base, "node")
 }
 
-// GetThreshold returns the threshold to use for this run config - if 0 it
-// returns the number of nodes
+// GetThreshold returns the threshold to use for this run config, with its
+// percentage resolved - if 0 it returns the number of nodes
 func (r *RunConfig) GetThreshold() int {
-	if r.Threshold == 0 {
-		return r.Nodes
+	if n := r.Threshold.count(r.Nodes); n != 0 {
+		return n
 	}
-	return r.Threshold
+	return r.Nodes
+}
+
+// ThresholdFraction returns the threshold as a fraction of the nodes, to
+// compare the runs of different sizes
+func (r *RunConfig) ThresholdFraction() float64 {
+	if r.Nodes == 0 {
+		return 0
+	}
+	return float64(r.GetThreshold()) / float64(r.Nodes)
+}
+
+// The aggregation protocols of the runs, see RunConfig.Protocol
+const (
+	// ProtocolHandel runs the Handel aggregation
+	ProtocolHandel = "handel"
+	// ProtocolCollector makes all the nodes send their individual signature
+	// to the active node of the lowest id, which verifies and aggregates them
+	// alone
+	ProtocolCollector = "collector"
+)
+
+// GetProtocol returns the aggregation protocol of the run, ProtocolHandel by
+// default
+func (r *RunConfig) GetProtocol() string {
+	if r.Protocol == "" {
+		return ProtocolHandel
+	}
+	return strings.ToLower(r.Protocol)
+}
+
+// GetRepetitions returns the number of repetitions of the aggregation in the
+// run, at least 1
+func (r *RunConfig) GetRepetitions() int {
+	if r.Repetitions < 1 {
+		return 1
+	}
+	return r.Repetitions
+}
+
+// RepetitionMessage returns the message signed in the given repetition of
+// the run, from 0: Message if the run is not repeated, Message followed by
+// the run and the repetition otherwise, so the signatures of a repetition are
+// not valid in the others.
+func (r *RunConfig) RepetitionMessage(run, rep int) []byte {
+	if r.GetRepetitions() == 1 {
+		return Message
+	}
+	return append(append([]byte{}, Message...), fmt.Sprintf(" run %d repetition %d", run, rep)...)
+}
+
+// TopologyStats returns the topology file of the p2p simulations connecting
+// the nodes with the "topology" connector, and the seed it was generated from
+// given by the TopologySeed extra, so the run can be reproduced. Both are
+// empty with another connector.
+func (r *RunConfig) TopologyStats() map[string]string {
+	stats := map[string]string{"topologyFile": "", "topologySeed": ""}
+	if strings.ToLower(r.Extra["Connector"]) == "topology" {
+		stats["topologyFile"] = r.Extra["TopologyFile"]
+		stats["topologySeed"] = r.Extra["TopologySeed"]
+	}
+	return stats
 }
 
 // GetHandelConfig returns the config to pass down to handel instances
@@ -293,7 +626,7 @@ func (r *RunConfig) GetHandelConfig() *handel.Config {
 	ch := &handel.Config{}
 	if r.Handel == nil {
 		ch = handel.DefaultConfig(r.Nodes)
-		ch.Contributions = r.Threshold
+		ch.Contributions = r.GetThreshold()
 	}
 	period, err := time.ParseDuration(r.Handel.Period)
 	if err != nil {
@@ -302,8 +635,23 @@ func (r *RunConfig) GetHandelConfig() *handel.Config {
 	ch.UpdatePeriod = period
 	ch.UpdateCount = r.Handel.UpdateCount
 	ch.FastPath = r.Handel.NodeCount
+	if r.Handel.UpdateFraction > 0 {
+		ch.LevelUpdateCount = handel.ProportionalUpdateCount(r.Handel.UpdateFraction, r.Handel.UpdateCount)
+	}
+	ch.UpdateOnImprovement = r.Handel.UpdateOnImprovement
 	ch.Contributions = r.GetThreshold()
 	ch.UnsafeSleepTimeOnSigVerify = r.Handel.UnsafeSleepTimeOnSigVerify
+	ch.UpdatePayload = r.Handel.UpdatePayload
+	ch.StrictInvariants = r.Handel.StrictInvariants
+	ch.QueueCapacity = r.Handel.QueueCapacity
+	ch.PartitionerSeed = r.PartitionerSeed()
+	if r.Handel.KeyRotationGrace != "" {
+		grace, err := time.ParseDuration(r.Handel.KeyRotationGrace)
+		if err != nil {
+			panic(err)
+		}
+		ch.KeyRotationGrace = grace
+	}
 
 	dd, err := time.ParseDuration(r.Handel.Timeout)
 	if err == nil {
@@ -313,11 +661,31 @@ func (r *RunConfig) GetHandelConfig() *handel.Config {
 	case "store":
 		ch.NewEvaluatorStrategy = handel.DefaultEvaluatorStrategy
 	case "equal":
-		ch.NewEvaluatorStrategy = func(handel.SignatureStore, *handel.Handel) handel.SigEvaluator { return new(handel.Evaluator1) }
+		ch.NewEvaluatorStrategy = equalEvaluatorStrategy
+	case "cost":
+		ch.NewEvaluatorStrategy = handel.CostEvaluatorStrategy
 	}
 	return ch
 }
 
+// GetLevelSchedule returns the activation schedule of the levels of the
+// Handel config: the linear one of its Timeout, or of the default level
+// timeout.
+func (r *RunConfig) GetLevelSchedule() handel.LevelActivationSchedule {
+	period := handel.DefaultLevelTimeout
+	if r.Handel != nil {
+		if dd, err := time.ParseDuration(r.Handel.Timeout); err == nil {
+			period = dd
+		}
+	}
+	return handel.LinearSchedule(period)
+}
+
+// equalEvaluatorStrategy gives the same score to all the signatures
+func equalEvaluatorStrategy(handel.SignatureStore, *handel.Handel) handel.SigEvaluator {
+	return new(handel.Evaluator1)
+}
+
 // Duration is an alias for time.Duration
 type Duration time.Duration
 
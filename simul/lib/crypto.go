@@ -115,6 +115,33 @@ func (e *emptyConstructor) KeyPair(r io.Reader) (SecretKey, PublicKey) {
 	return nil, nil
 }
 
+// fakeConstructor is a handel.Constructor of fake keys and signatures
+type fakeConstructor struct{}
+
+// NewFakeConstructor returns a Constructor whose keys and signatures are fake:
+// signing and verifying cost nothing and always succeed. It gives the baseline
+// of a simulation without any cryptographic cost, to compare the real curves
+// against.
+func NewFakeConstructor() Constructor {
+	return &SimulConstructor{new(fakeConstructor)}
+}
+
+func (f *fakeConstructor) Signature() handel.Signature {
+	return new(fakeSig)
+}
+
+func (f *fakeConstructor) PublicKey() handel.PublicKey {
+	return new(fakePublic)
+}
+
+func (f *fakeConstructor) SecretKey() handel.SecretKey {
+	return new(fakeSecret)
+}
+
+func (f *fakeConstructor) KeyPair(r io.Reader) (handel.SecretKey, handel.PublicKey) {
+	return new(fakeSecret), new(fakePublic)
+}
+
 type fakePublic struct{}
 
 func (f *fakePublic) String() string {
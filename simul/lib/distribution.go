@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CompressedExt is the extension of the compressed registries
+const CompressedExt = ".gz"
+
+// RegistryDigest returns the hex encoded SHA-256 digest of the registry file.
+// The platforms pin the registry of a run with it, so the nodes refuse a
+// registry truncated or corrupted on its way to their host.
+func RegistryDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyRegistry returns an error if the digest of the registry file is not
+// the given one. An empty digest accepts any registry.
+func VerifyRegistry(path, digest string) error {
+	if digest == "" {
+		return nil
+	}
+	actual, err := RegistryDigest(path)
+	if err != nil {
+		return err
+	}
+	if actual != digest {
+		return fmt.Errorf("registry %s: digest %s instead of %s - truncated or corrupted", path, actual, digest)
+	}
+	return nil
+}
+
+// CompressRegistry writes the registry file gzip compressed to dst, to be
+// sent once to all the hosts, and returns the digest of the registry.
+func CompressRegistry(path, dst string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	h := sha256.New()
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(io.MultiWriter(zw, h), src); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), out.Close()
+}
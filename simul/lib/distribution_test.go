@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryDigest(t *testing.T) {
+	name := writeCSV(csvContent())
+	defer os.RemoveAll(name)
+	digest, err := RegistryDigest(name)
+	require.NoError(t, err)
+	require.Len(t, digest, 64)
+
+	// the compressed registry decompresses to the registry of the digest
+	compressed := name + CompressedExt
+	defer os.RemoveAll(compressed)
+	cdigest, err := CompressRegistry(name, compressed)
+	require.NoError(t, err)
+	require.Equal(t, digest, cdigest)
+	f, err := os.Open(compressed)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	original, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, original, content)
+
+	// the nodes load the registry of their digest only
+	parser := NewCSVParser()
+	cons := new(sizedConstructor)
+	nodeList, _, err := ReadAllWith(name, parser, cons, LoadOptions{Digest: digest})
+	require.NoError(t, err)
+	require.Equal(t, 3, nodeList.Registry().Size())
+	require.NoError(t, ioutil.WriteFile(name, original[:len(original)-10], 0644))
+	_, _, err = ReadAllWith(name, parser, cons, LoadOptions{Digest: digest})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instead of "+digest)
+	// without digest, the truncated registry is parsed
+	_, _, err = ReadAllWith(name, parser, cons, LoadOptions{})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "digest")
+	require.NoError(t, VerifyRegistry(name, ""))
+}
@@ -24,6 +24,14 @@ func GenerateNode(cons Constructor, idx int, addr string) *Node {
 	return &Node{SecretKey: sec, Identity: id}
 }
 
+// GenerateNodeAddresses is like GenerateNode for a node reachable on several
+// addresses, in their order of preference.
+func GenerateNodeAddresses(cons Constructor, idx int, addrs []string) *Node {
+	sec, pub := cons.KeyPair(rand.Reader)
+	id := h.NewStaticIdentityAddresses(int32(idx), addrs, pub)
+	return &Node{SecretKey: sec, Identity: id}
+}
+
 // GenerateNodesFromAllocation returns a list of Node from the allocation
 // returned by Allocator + filled with the addresses
 func GenerateNodesFromAllocation(cons Constructor, alloc map[string][]*NodeInfo) []*Node {
@@ -36,6 +44,51 @@ func GenerateNodesFromAllocation(cons Constructor, alloc map[string][]*NodeInfo)
 	return nodes
 }
 
+// KeyCache keeps the key pairs generated for each curve system, so a sweep
+// generates the keys of a curve only once for all the runs using it. The
+// addresses are not cached since they change from one run to the next.
+type KeyCache struct {
+	keys map[string][]*keyPair
+}
+
+type keyPair struct {
+	sec SecretKey
+	pub PublicKey
+}
+
+// NewKeyCache returns an empty KeyCache
+func NewKeyCache() *KeyCache {
+	return &KeyCache{keys: make(map[string][]*keyPair)}
+}
+
+// GenerateNodesFromAllocation is like GenerateNodesFromAllocation but reuses
+// the key pairs previously generated for the same curve and ID, generating
+// only the missing ones with the given constructor.
+func (k *KeyCache) GenerateNodesFromAllocation(curve string, cons Constructor, alloc map[string][]*NodeInfo) []*Node {
+	var nodes []*Node
+	for _, list := range alloc {
+		for _, ni := range list {
+			kp := k.keyPair(curve, cons, ni.ID)
+			id := h.NewStaticIdentity(int32(ni.ID), ni.Address, kp.pub)
+			nodes = append(nodes, &Node{SecretKey: kp.sec, Identity: id})
+		}
+	}
+	return nodes
+}
+
+func (k *KeyCache) keyPair(curve string, cons Constructor, idx int) *keyPair {
+	pairs := k.keys[curve]
+	if idx >= len(pairs) {
+		pairs = append(pairs, make([]*keyPair, idx+1-len(pairs))...)
+		k.keys[curve] = pairs
+	}
+	if pairs[idx] == nil {
+		sec, pub := cons.KeyPair(rand.Reader)
+		pairs[idx] = &keyPair{sec: sec, pub: pub}
+	}
+	return pairs[idx]
+}
+
 // WriteAll writes down all the given nodes to the specified URI with the given
 // parser.
 func WriteAll(nodes []*Node, p NodeParser, uri string) {
@@ -4,6 +4,8 @@ import (
 	"net"
 	"strconv"
 	"time"
+
+	"github.com/ConsenSys/handel/network/quic"
 )
 
 var afterPort = 11000 // Keeps the last port allocated
@@ -35,19 +37,49 @@ var baseUDP = 30000
 // We need to keep an history of the previous port we
 //  allocated, we do this with this global variable.
 func GetFreeUDPPort() int {
+	return GetFreeUDPPorts(1)
+}
+
+// GetFreeUDPPorts returns the first of n consecutive free UDP ports, or panics
+func GetFreeUDPPorts(n int) int {
 	for i := baseUDP + 1; i < baseUDP+30000; i++ {
+		if !freeUDPPorts(i, n) {
+			continue
+		}
+		time.Sleep(2 * time.Millisecond)
+		baseUDP = i + n - 1
+		return i
+	}
+	panic("free UDP port not found")
+}
+
+// freeUDPPorts returns true if the n ports from the given one can be bound
+func freeUDPPorts(port, n int) bool {
+	for i := port; i < port+n; i++ {
 		udpAddr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(i))
 		if err != nil {
-			continue
+			return false
 		}
 		sock, err := net.ListenUDP("udp4", udpAddr)
 		if err != nil {
-			continue
+			return false
 		}
 		sock.Close()
-		time.Sleep(2 * time.Millisecond)
-		baseUDP = i
-		return baseUDP
 	}
-	panic("free UDP port not found")
+	return true
+}
+
+// WriteCertificates writes in the directory the certificates of the mutual TLS
+// of the nodes of the registry file, issued by a new CA - see
+// quic.WriteCertificates and Config.MutualTLS.
+func WriteCertificates(registryFile string, cons Constructor, dir string) error {
+	nodes, err := ReadAll(registryFile, NewCSVParser(), cons)
+	if err != nil {
+		return err
+	}
+	ids := make([]int32, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID()
+	}
+	return quic.WriteCertificates(dir, ids)
 }
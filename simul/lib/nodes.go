@@ -2,6 +2,10 @@ package lib
 
 import (
 	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
 
 	"github.com/ConsenSys/handel"
 )
@@ -12,6 +16,14 @@ type NodeRecord struct {
 	Addr    string
 	Private string // hex encoded
 	Public  string // hex encoded
+	// Alternates are the other addresses of the node, tried in order when
+	// Addr doesn't work, see handel.MultiAddressIdentity
+	Alternates []string
+	// Previous is the public key the node rotated from, hex encoded, empty
+	// if none, see handel.RotatingIdentity
+	Previous string
+	// Line of the record in the file it was read from, 0 if unknown
+	Line int
 }
 
 // Node is similar to a NodeRecord but decoded
@@ -21,11 +33,24 @@ type Node struct {
 	Active bool
 }
 
+// Addresses implements the handel.MultiAddressIdentity interface
+func (n *Node) Addresses() []string {
+	return handel.Addresses(n.Identity)
+}
+
+// PreviousPublicKey implements the handel.RotatingIdentity interface
+func (n *Node) PreviousPublicKey() handel.PublicKey {
+	return handel.PreviousPublicKey(n.Identity)
+}
+
 // ToRecord maps a Node to a NodeRecord, its string-human-readable equivalent
 func (n *Node) ToRecord() (*NodeRecord, error) {
 	nr := new(NodeRecord)
 	nr.ID = n.ID()
 	nr.Addr = n.Address()
+	if addrs := handel.Addresses(n.Identity); len(addrs) > 1 {
+		nr.Alternates = addrs[1:]
+	}
 	buff, err := n.SecretKey.MarshalBinary()
 	if err != nil {
 		return nil, err
@@ -36,29 +61,114 @@ func (n *Node) ToRecord() (*NodeRecord, error) {
 		return nil, err
 	}
 	nr.Public = hex.EncodeToString(buff)
+	if prev := handel.PreviousPublicKey(n.Identity); prev != nil {
+		if buff, err = prev.(PublicKey).MarshalBinary(); err != nil {
+			return nil, err
+		}
+		nr.Previous = hex.EncodeToString(buff)
+	}
 	return nr, nil
 }
 
 // ToNode the private and public key from the given constructor and returns the
 // secret key and the corresponding identity
 func (n *NodeRecord) ToNode(c Constructor) (*Node, error) {
-	buff, err := hex.DecodeString(n.Private)
+	node, err := n.toNode(c, LoadOptions{})
 	if err != nil {
 		return nil, err
 	}
-	sk := c.SecretKey()
-	if err := sk.UnmarshalBinary(buff); err != nil {
-		return nil, err
+	return node, nil
+}
+
+// toNode decodes the record according to the Lazy and PublicOnly options.
+// The error has no file nor line.
+func (n *NodeRecord) toNode(c Constructor, opts LoadOptions) (*Node, *RecordError) {
+	addrs := append([]string{n.Addr}, n.Alternates...)
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, &RecordError{Field: "address", Reason: err.Error()}
+		}
+	}
+	buff, err := hex.DecodeString(n.Private)
+	if err != nil {
+		return nil, &RecordError{Field: "private", Reason: "invalid hex: " + err.Error()}
+	}
+	var sk SecretKey
+	switch {
+	case opts.PublicOnly && len(buff) == 0:
+	case opts.Lazy:
+		sk = &lazySecret{c: c, buff: buff}
+	default:
+		sk = c.SecretKey()
+		if err := sk.UnmarshalBinary(buff); err != nil {
+			return nil, &RecordError{Field: "private", Reason: "invalid key: " + err.Error()}
+		}
 	}
 
 	buff, err = hex.DecodeString(n.Public)
 	if err != nil {
-		return nil, err
+		return nil, &RecordError{Field: "public", Reason: "invalid hex: " + err.Error()}
 	}
 	pk := c.PublicKey().(PublicKey)
 	if err = pk.UnmarshalBinary(buff); err != nil {
-		return nil, err
+		return nil, &RecordError{Field: "public", Reason: "invalid key: " + err.Error()}
+	}
+	identity := handel.NewStaticIdentityAddresses(int32(n.ID), addrs, pk)
+	if n.Previous != "" {
+		buff, err = hex.DecodeString(n.Previous)
+		if err != nil {
+			return nil, &RecordError{Field: "previous", Reason: "invalid hex: " + err.Error()}
+		}
+		prev := c.PublicKey().(PublicKey)
+		if err = prev.UnmarshalBinary(buff); err != nil {
+			return nil, &RecordError{Field: "previous", Reason: "invalid key: " + err.Error()}
+		}
+		identity = handel.NewRotatingIdentity(int32(n.ID), addrs, pk, prev)
 	}
-	identity := handel.NewStaticIdentity(int32(n.ID), n.Addr, pk)
-	return &Node{SecretKey: sk, Identity: identity}, nil
+	return &Node{SecretKey: sk, Identity: identity, Active: true}, nil
+}
+
+// lazySecret is a SecretKey unmarshalled at its first use. It panics if the
+// key is invalid.
+type lazySecret struct {
+	once sync.Once
+	c    Constructor
+	buff []byte
+	sk   SecretKey
+}
+
+func (l *lazySecret) key() SecretKey {
+	l.once.Do(func() {
+		sk := l.c.SecretKey()
+		if err := sk.UnmarshalBinary(l.buff); err != nil {
+			panic(fmt.Sprintf("invalid secret key: %s", err))
+		}
+		l.sk = sk
+	})
+	return l.sk
+}
+
+// Sign implements the handel.SecretKey interface
+func (l *lazySecret) Sign(msg []byte, r io.Reader) (handel.Signature, error) {
+	return l.key().Sign(msg, r)
+}
+
+// DiffieHellman implements the handel.DHSecretKey interface, if the key of
+// the curve does
+func (l *lazySecret) DiffieHellman(peer handel.PublicKey) ([]byte, error) {
+	dh, ok := l.key().(handel.DHSecretKey)
+	if !ok {
+		return nil, fmt.Errorf("secret key %T does not support key exchange", l.key())
+	}
+	return dh.DiffieHellman(peer)
+}
+
+// MarshalBinary implements the Marshallable interface
+func (l *lazySecret) MarshalBinary() ([]byte, error) {
+	return l.key().MarshalBinary()
+}
+
+// UnmarshalBinary implements the Marshallable interface
+func (l *lazySecret) UnmarshalBinary(buff []byte) error {
+	return l.key().UnmarshalBinary(buff)
 }
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/monitor"
+)
+
+// DefaultSlowFactor is the default factor of the expected dissemination time
+// above which the median measured one flags a run as slow
+const DefaultSlowFactor = 2.0
+
+// OptimumConfig holds the parameters of the network the runs are compared
+// against, see Optimum
+type OptimumConfig struct {
+	// RTT is the median round trip time between the nodes
+	RTT Duration
+	// Loss is the rate of packets lost, in [0, 1)
+	Loss float64
+	// SlowFactor is DefaultSlowFactor if zero
+	SlowFactor float64
+}
+
+// Optimum is a model of the theoretical dissemination time of a run, the time
+// for a node to gather the signatures of all the nodes. Handel needs one
+// round per level, i.e. ceil(log2(Nodes)) rounds, and each round takes at
+// least a round trip, for the aggregate of the level to reach the node, plus
+// a verification before the aggregate is forwarded:
+//
+//	lower = rounds * (RTT + Verify)
+//
+// A lost packet is only sent again at the next periodic update. With a loss
+// rate p, the number of packets lost before one gets through is geometric,
+// p/(1-p) on average, so each round waits that many periods more:
+//
+//	expected = lower + rounds * Period * p/(1-p)
+type Optimum struct {
+	Nodes  int
+	Period time.Duration
+	RTT    time.Duration
+	// Verify is the cost of a verification
+	Verify time.Duration
+	Loss   float64
+}
+
+// Rounds returns the number of communication rounds, ceil(log2(Nodes))
+func (o Optimum) Rounds() int {
+	if o.Nodes <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(o.Nodes))))
+}
+
+// LowerBound returns the dissemination time without any loss
+func (o Optimum) LowerBound() time.Duration {
+	return time.Duration(o.Rounds()) * (o.RTT + o.Verify)
+}
+
+// Expected returns the dissemination time expected under the loss rate
+func (o Optimum) Expected() time.Duration {
+	resends := o.Loss / (1 - o.Loss)
+	wait := time.Duration(float64(time.Duration(o.Rounds())*o.Period) * resends)
+	return o.LowerBound() + wait
+}
+
+// OptimumFromStats returns the model of the run of the stats over a network
+// of the given RTT and loss rate. The number of nodes and the update period
+// are read from the static values of the stats, the update period being
+// handel.DefaultUpdatePeriod if not set. The cost of a verification is the
+// UnsafeSleepTimeOnSigVerify of the run if any, the perf_verify_ns measured
+// by the nodes otherwise, and zero if the nodes did not measure it.
+func OptimumFromStats(s *monitor.Stats, rtt time.Duration, loss float64) (Optimum, error) {
+	if loss < 0 || loss >= 1 {
+		return Optimum{}, fmt.Errorf("optimum: loss rate %v not in [0, 1)", loss)
+	}
+	o := Optimum{RTT: rtt, Loss: loss, Period: handel.DefaultUpdatePeriod}
+	nodes, ok := s.Static("totalNbOfNodes")
+	if !ok {
+		nodes, ok = s.Static("nodes")
+	}
+	if !ok {
+		return Optimum{}, fmt.Errorf("optimum: number of nodes unknown")
+	}
+	var err error
+	if o.Nodes, err = strconv.Atoi(nodes); err != nil {
+		return Optimum{}, fmt.Errorf("optimum: nodes: %s", err)
+	}
+	if period, ok := s.Static("period"); ok && period != "" {
+		if o.Period, err = time.ParseDuration(period); err != nil {
+			return Optimum{}, fmt.Errorf("optimum: period: %s", err)
+		}
+	}
+	if sleep, ok := s.Static("UnsafeSleepTimeOnSigVerify"); ok && sleep != "0" && sleep != "" {
+		ms, err := strconv.Atoi(sleep)
+		if err != nil {
+			return Optimum{}, fmt.Errorf("optimum: sleep time: %s", err)
+		}
+		o.Verify = time.Duration(ms) * time.Millisecond
+	} else if verify, ok := s.Summary("perf_verify_ns"); ok {
+		o.Verify = time.Duration(verify.Avg)
+	}
+	return o, nil
+}
+
+// AddOptimum writes the lower bound and the expected value of the model, in
+// seconds like sigen_wall, as the static values sigen_lower_bound and
+// sigen_expected of the stats. The static value sigen_slow flags, with 1, a
+// run whose median sigen_wall exceeds the expected value by more than the
+// factor, DefaultSlowFactor if zero.
+func AddOptimum(s *monitor.Stats, o Optimum, factor float64) {
+	if factor == 0 {
+		factor = DefaultSlowFactor
+	}
+	expected := o.Expected().Seconds()
+	s.SetStatic("sigen_lower_bound", strconv.FormatFloat(o.LowerBound().Seconds(), 'f', -1, 64))
+	s.SetStatic("sigen_expected", strconv.FormatFloat(expected, 'f', -1, 64))
+	slow := "0"
+	if median, ok := s.Median("sigen_wall"); ok && median > factor*expected {
+		slow = "1"
+	}
+	s.SetStatic("sigen_slow", slow)
+}
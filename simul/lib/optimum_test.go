@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/monitor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptimum(t *testing.T) {
+	ms := time.Millisecond
+	var tests = []struct {
+		o        Optimum
+		rounds   int
+		lower    time.Duration
+		expected time.Duration
+	}{
+		{Optimum{Nodes: 1, Period: 10 * ms, RTT: 100 * ms}, 0, 0, 0},
+		// 4 rounds of 100ms, no loss
+		{Optimum{Nodes: 16, Period: 10 * ms, RTT: 100 * ms}, 4, 400 * ms, 400 * ms},
+		// one more round as soon as the nodes are not a power of two
+		{Optimum{Nodes: 17, Period: 10 * ms, RTT: 100 * ms, Verify: 10 * ms}, 5, 550 * ms, 550 * ms},
+		// half the packets lost: one period more per round
+		{Optimum{Nodes: 1000, Period: 20 * ms, RTT: 50 * ms, Verify: 2 * ms, Loss: 0.5}, 10, 520 * ms, 720 * ms},
+		// 11 * 205ms, plus a quarter of a period per round
+		{Optimum{Nodes: 2000, Period: 10 * ms, RTT: 200 * ms, Verify: 5 * ms, Loss: 0.2}, 11, 2255 * ms, 2282500 * time.Microsecond},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.rounds, test.o.Rounds(), "test %d", i)
+		require.Equal(t, test.lower, test.o.LowerBound(), "test %d", i)
+		require.InDelta(t, float64(test.expected), float64(test.o.Expected()), float64(time.Microsecond), "test %d", i)
+	}
+}
+
+func TestOptimumFromStats(t *testing.T) {
+	static := map[string]string{"totalNbOfNodes": "64", "period": "20ms", "UnsafeSleepTimeOnSigVerify": "0"}
+	stats := monitor.NewStats(static, nil)
+	stats.Store("perf_verify_ns", 1e6)
+	stats.Store("perf_verify_ns", 3e6)
+	o, err := OptimumFromStats(stats, 100*time.Millisecond, 0.5)
+	require.NoError(t, err)
+	require.Equal(t, Optimum{Nodes: 64, Period: 20 * time.Millisecond, RTT: 100 * time.Millisecond, Verify: 2 * time.Millisecond, Loss: 0.5}, o)
+
+	// the sleep time replaces the verification
+	static["UnsafeSleepTimeOnSigVerify"] = "5"
+	o, err = OptimumFromStats(monitor.NewStats(static, nil), 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Millisecond, o.Verify)
+
+	// the platforms only write the nodes
+	o, err = OptimumFromStats(monitor.NewStats(map[string]string{"nodes": "8"}, nil), 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, Optimum{Nodes: 8, Period: handel.DefaultUpdatePeriod}, o)
+
+	_, err = OptimumFromStats(monitor.NewStats(nil, nil), 0, 0)
+	require.Error(t, err)
+	_, err = OptimumFromStats(stats, 0, 1)
+	require.Error(t, err)
+}
+
+func TestAddOptimum(t *testing.T) {
+	// 3 rounds of 100ms expected
+	o := Optimum{Nodes: 8, Period: 10 * time.Millisecond, RTT: 100 * time.Millisecond}
+	for _, test := range []struct {
+		wall []float64
+		slow string
+	}{
+		{nil, "0"},
+		{[]float64{0.3, 0.5, 0.6}, "0"},
+		{[]float64{0.3, 0.7, 0.8}, "1"},
+	} {
+		stats := monitor.NewStats(nil, nil)
+		for _, v := range test.wall {
+			stats.Store("sigen_wall", v)
+		}
+		AddOptimum(stats, o, 0)
+		values := map[string]string{}
+		for _, k := range []string{"sigen_lower_bound", "sigen_expected", "sigen_slow"} {
+			values[k], _ = stats.Static(k)
+		}
+		require.Equal(t, map[string]string{"sigen_lower_bound": "0.3", "sigen_expected": "0.3", "sigen_slow": test.slow}, values)
+	}
+}
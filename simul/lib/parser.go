@@ -3,9 +3,10 @@ package lib
 import (
 	"bufio"
 	"encoding/csv"
-	"io"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/ConsenSys/handel"
 )
@@ -77,25 +78,142 @@ func (n *NodeList) Node(i int) *Node {
 }
 
 // ReadAll reads the whole set of nodes from the given parser to the given URI.
-// It returns the node list which can be used as a Registry as well
+// It returns the node list which can be used as a Registry as well. The ids
+// must be unique and contiguous from zero.
 func ReadAll(uri string, parser NodeParser, c Constructor) (NodeList, error) {
-	records, err := parser.Read(uri)
+	nodes, _, err := ReadAllWith(uri, parser, c, LoadOptions{})
+	return nodes, err
+}
+
+// LoadOptions tunes how ReadAllWith loads the nodes
+type LoadOptions struct {
+	// AllowGaps accepts ids which are not contiguous: the missing ids are
+	// inactive placeholder nodes without a secret key.
+	AllowGaps bool
+	// Lazy unmarshals the secret keys at their first use instead of at load
+	// time, which then panics if the key is invalid. The public keys are
+	// needed by the registry and always unmarshalled.
+	Lazy bool
+	// PartialLoad skips the malformed records instead of failing: their ids,
+	// when known, are inactive placeholder nodes as the gaps are, and the
+	// records are listed in the report.
+	PartialLoad bool
+	// PublicOnly accepts the records without a private key, for the
+	// verifiers of the signatures of the nodes: their nodes have no secret
+	// key.
+	PublicOnly bool
+	// Digest is the digest of the registry file, see RegistryDigest: the
+	// nodes are not loaded from a file of another digest. Empty accepts any
+	// file. The nodes set it from their command line, as the registry of
+	// each run has its own.
+	Digest string
+}
+
+// LoadReport describes the nodes ReadAllWith could not load
+type LoadReport struct {
+	// Skipped are the records skipped by a partial load, in the order of the
+	// file
+	Skipped []*RecordError
+	// Inactive are the ids of the placeholder nodes, in increasing order
+	Inactive []int32
+}
+
+// RecordError is the error of a malformed record
+type RecordError struct {
+	File string
+	// Line of the record, 0 if unknown
+	Line int
+	// Field of the record at fault, "id", "address", "private", "public" or
+	// "previous", empty if the whole record is malformed
+	Field  string
+	Reason string
+}
+
+func (r *RecordError) Error() string {
+	if r.Field == "" {
+		return fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", r.File, r.Line, r.Field, r.Reason)
+}
+
+// recordReader is a NodeParser that can return its malformed records instead
+// of failing on the first one
+type recordReader interface {
+	readRecords(uri string) ([]*NodeRecord, []*RecordError, error)
+}
+
+// ReadAllWith reads the whole set of nodes like ReadAll, with the given
+// options. The report lists the skipped records and the placeholder nodes.
+func ReadAllWith(uri string, parser NodeParser, c Constructor, opts LoadOptions) (NodeList, *LoadReport, error) {
+	if err := VerifyRegistry(uri, opts.Digest); err != nil {
+		return nil, nil, err
+	}
+	report := new(LoadReport)
+	var records []*NodeRecord
+	var err error
+	if rr, ok := parser.(recordReader); ok {
+		records, report.Skipped, err = rr.readRecords(uri)
+	} else {
+		records, err = parser.Read(uri)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var nodes = make([]*Node, len(records))
+	if len(report.Skipped) > 0 && !opts.PartialLoad {
+		return nil, nil, report.Skipped[0]
+	}
+	// without gaps, the ids are bounded by the number of records of the file
+	bound := len(records) + len(report.Skipped)
+	nodes := make(map[int32]*Node)
+	lines := make(map[int32]int)
+	maxID := int32(-1)
 	for _, rec := range records {
-		node, err := rec.ToNode(c)
-		if err != nil {
-			return nil, err
+		var rerr *RecordError
+		prev, dup := lines[rec.ID]
+		switch {
+		case rec.ID < 0:
+			rerr = &RecordError{Field: "id", Reason: fmt.Sprintf("negative id %d", rec.ID)}
+		case !opts.AllowGaps && int(rec.ID) >= bound:
+			rerr = &RecordError{Field: "id", Reason: fmt.Sprintf("id %d out of range, the file has %d records", rec.ID, bound)}
+		case dup:
+			rerr = &RecordError{Field: "id", Reason: fmt.Sprintf("duplicate id %d, first at line %d", rec.ID, prev)}
+		}
+		if rerr == nil {
+			lines[rec.ID] = rec.Line
+			if rec.ID > maxID {
+				maxID = rec.ID
+			}
+			var node *Node
+			if node, rerr = rec.toNode(c, opts); rerr == nil {
+				nodes[rec.ID] = node
+				continue
+			}
 		}
-		nodes[int(node.ID())] = node
+		rerr.File, rerr.Line = uri, rec.Line
+		if !opts.PartialLoad {
+			return nil, nil, rerr
+		}
+		report.Skipped = append(report.Skipped, rerr)
+	}
+
+	list := make(NodeList, maxID+1)
+	for i := range list {
+		id := int32(i)
+		if node, ok := nodes[id]; ok {
+			list[i] = node
+			continue
+		}
+		list[i] = &Node{Identity: handel.NewStaticIdentity(id, "", c.PublicKey())}
+		report.Inactive = append(report.Inactive, id)
 	}
-	return nodes, nil
+	return list, report, nil
 }
 
 type csvParser struct{}
 
+// previousPrefix prefixes the previous public key in a CSV record
+const previousPrefix = "previous="
+
 // NewCSVParser is a NodeParser that reads/writes to a CSV file
 func NewCSVParser() NodeParser {
 	return &csvParser{}
@@ -103,36 +221,70 @@ func NewCSVParser() NodeParser {
 
 // Read implements NodeParser
 func (c *csvParser) Read(uri string) ([]*NodeRecord, error) {
+	records, malformed, err := c.readRecords(uri)
+	if err != nil {
+		return nil, err
+	}
+	if len(malformed) > 0 {
+		return nil, malformed[0]
+	}
+	return records, nil
+}
+
+// readRecords implements recordReader: each non empty line of the file is a
+// record "id,address,private,public", followed by the alternate addresses of
+// the node if any, and by "previous=<hex>" if the node rotated its key.
+func (c *csvParser) readRecords(uri string) ([]*NodeRecord, []*RecordError, error) {
 	file, err := os.Open(uri)
-	defer file.Close()
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
+	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	csvReader := csv.NewReader(reader)
-	csvReader.FieldsPerRecord = 4
 	var nodes []*NodeRecord
-	for {
+	var malformed []*RecordError
+	scanner := bufio.NewScanner(file)
+	for lineNb := 1; scanner.Scan(); lineNb++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		csvReader := csv.NewReader(strings.NewReader(scanner.Text()))
+		csvReader.FieldsPerRecord = -1
 		line, err := csvReader.Read()
+		if err == nil && len(line) < 4 {
+			err = fmt.Errorf("%d fields instead of at least 4", len(line))
+		}
 		if err != nil {
-			if err == io.EOF {
-				return nodes, nil
+			if perr, ok := err.(*csv.ParseError); ok {
+				err = perr.Err
 			}
-			return nil, err
+			malformed = append(malformed, &RecordError{File: uri, Line: lineNb, Reason: err.Error()})
+			continue
 		}
 
 		i, err := strconv.ParseInt(line[0], 10, 32)
 		if err != nil {
-			return nil, err
+			malformed = append(malformed, &RecordError{File: uri, Line: lineNb, Field: "id", Reason: fmt.Sprintf("invalid id %q", line[0])})
+			continue
 		}
 		id := int32(i)
 		addr := line[1]
 		priv := line[2]
 		pub := line[3]
-		nodeRecord := &NodeRecord{ID: id, Addr: addr, Private: priv, Public: pub}
+		nodeRecord := &NodeRecord{ID: id, Addr: addr, Private: priv, Public: pub, Line: lineNb}
+		for _, field := range line[4:] {
+			if strings.HasPrefix(field, previousPrefix) {
+				nodeRecord.Previous = strings.TrimPrefix(field, previousPrefix)
+			} else {
+				nodeRecord.Alternates = append(nodeRecord.Alternates, field)
+			}
+		}
 		nodes = append(nodes, nodeRecord)
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return nodes, malformed, nil
 }
 
 func (c *csvParser) Write(uri string, records []*NodeRecord) error {
@@ -147,6 +299,10 @@ func (c *csvParser) Write(uri string, records []*NodeRecord) error {
 			record.Addr,
 			record.Private,
 			record.Public}
+		line = append(line, record.Alternates...)
+		if record.Previous != "" {
+			line = append(line, previousPrefix+record.Previous)
+		}
 		if err := w.Write(line); err != nil {
 			return err
 		}
@@ -2,11 +2,13 @@ package lib
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/ConsenSys/handel"
 	"github.com/stretchr/testify/require"
 )
 
@@ -77,3 +79,242 @@ func TestCSVParser(t *testing.T) {
 	}
 
 }
+
+func writeRaw(content string) string {
+	file, err := ioutil.TempFile("/tmp", "*")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		panic(err)
+	}
+	return file.Name()
+}
+
+func TestCSVParserMalformed(t *testing.T) {
+	parser := NewCSVParser()
+	cons := NewEmptyConstructor()
+
+	type malformedTest struct {
+		content string
+		line    int
+		field   string
+	}
+
+	var tests = []malformedTest{
+		// duplicate id
+		{"0,127.0.0.1:3000,aed142,aed142\n1,127.0.0.1:3001,aed142,aed142\n1,127.0.0.1:3002,aed142,aed142\n", 3, "id"},
+		// truncated line
+		{"0,127.0.0.1:3000,aed142,aed142\n1,127.0.0.1:3001,aed1\n", 2, ""},
+		// bad hex
+		{"0,127.0.0.1:3000,aed142,aed142\n1,127.0.0.1:3001,aed142,xyz\n", 2, "public"},
+		{"0,127.0.0.1:3000,aed14,aed142\n", 1, "private"},
+		// invalid id
+		{"0,127.0.0.1:3000,aed142,aed142\n\nx,127.0.0.1:3001,aed142,aed142\n", 3, "id"},
+		// out of range id
+		{"0,127.0.0.1:3000,aed142,aed142\n5,127.0.0.1:3001,aed142,aed142\n", 2, "id"},
+		{"-1,127.0.0.1:3000,aed142,aed142\n", 1, "id"},
+		// invalid address
+		{"0,127.0.0.1,aed142,aed142\n", 1, "address"},
+		{"0,127.0.0.1:3000,aed142,aed142,10.0.0.1\n", 1, "address"},
+	}
+
+	for i, test := range tests {
+		t.Logf(" --- test %d ---", i)
+		name := writeRaw(test.content)
+		defer os.RemoveAll(name)
+
+		_, err := ReadAll(name, parser, cons)
+		require.Error(t, err)
+		rerr, ok := err.(*RecordError)
+		require.True(t, ok, "%v", err)
+		require.Equal(t, name, rerr.File)
+		require.Equal(t, test.line, rerr.Line)
+		require.Equal(t, test.field, rerr.Field)
+		require.Contains(t, err.Error(), fmt.Sprintf("%s:%d:", name, test.line))
+	}
+
+	_, err := ReadAll("/tmp/does-not-exist.csv", parser, cons)
+	require.Error(t, err)
+}
+
+func TestCSVParserAlternates(t *testing.T) {
+	parser := NewCSVParser()
+	cons := NewEmptyConstructor()
+	name := writeCSV([][]string{
+		{"0", "54.0.0.1:3000", "aed142", "aed142", "10.0.0.1:3000"},
+		{"1", "127.0.0.1:3001", "aed142", "aed142"},
+	})
+	defer os.RemoveAll(name)
+
+	nodeList, err := ReadAll(name, parser, cons)
+	require.NoError(t, err)
+	require.Equal(t, "54.0.0.1:3000", nodeList.Node(0).Address())
+	require.Equal(t, []string{"54.0.0.1:3000", "10.0.0.1:3000"}, handel.Addresses(nodeList.Node(0)))
+	require.Equal(t, []string{"127.0.0.1:3001"}, handel.Addresses(nodeList.Node(1)))
+
+	// the alternates are written back
+	records, err := parser.Read(name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:3000"}, records[0].Alternates)
+	require.Nil(t, records[1].Alternates)
+	out := name + ".out"
+	defer os.RemoveAll(out)
+	require.NoError(t, parser.Write(out, records))
+	again, err := parser.Read(out)
+	require.NoError(t, err)
+	require.Equal(t, records, again)
+}
+
+func TestCSVParserPrevious(t *testing.T) {
+	parser := NewCSVParser()
+	cons := NewEmptyConstructor()
+	name := writeCSV([][]string{
+		{"0", "54.0.0.1:3000", "aed142", "aed142", "10.0.0.1:3000", "previous=bed142"},
+		{"1", "127.0.0.1:3001", "aed142", "aed142"},
+		{"2", "127.0.0.1:3002", "aed142", "aed142", "previous=xx"},
+	})
+	defer os.RemoveAll(name)
+
+	_, err := ReadAll(name, parser, cons)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), fmt.Sprintf("%s:3: previous", name))
+
+	records, err := parser.Read(name)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:3000"}, records[0].Alternates)
+	require.Equal(t, "bed142", records[0].Previous)
+	require.Equal(t, "", records[1].Previous)
+	records = records[:2]
+	out := name + ".out"
+	defer os.RemoveAll(out)
+	require.NoError(t, parser.Write(out, records))
+	again, err := parser.Read(out)
+	require.NoError(t, err)
+	require.Equal(t, records, again)
+
+	nodeList, err := ReadAll(out, parser, cons)
+	require.NoError(t, err)
+	require.NotNil(t, handel.PreviousPublicKey(nodeList.Node(0)))
+	require.Nil(t, handel.PreviousPublicKey(nodeList.Node(1)))
+}
+
+func TestCSVParserGaps(t *testing.T) {
+	parser := NewCSVParser()
+	cons := NewEmptyConstructor()
+	name := writeCSV([][]string{
+		{"0", "127.0.0.1:3000", "aed142", "aed142"},
+		{"3", "127.0.0.1:3003", "aed142", "aed142"},
+		{"1", "127.0.0.1:3001", "aed142", "aed142"},
+	})
+	defer os.RemoveAll(name)
+
+	_, err := ReadAll(name, parser, cons)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "id 3 out of range")
+
+	nodeList, report, err := ReadAllWith(name, parser, cons, LoadOptions{AllowGaps: true})
+	require.NoError(t, err)
+	require.Empty(t, report.Skipped)
+	require.Equal(t, []int32{2}, report.Inactive)
+	require.Equal(t, 4, nodeList.Registry().Size())
+	for i := 0; i < 4; i++ {
+		node := nodeList.Node(i)
+		require.Equal(t, int32(i), node.ID())
+		require.Equal(t, i != 2, node.Active)
+	}
+	require.Nil(t, nodeList.Node(2).SecretKey)
+}
+
+func TestCSVParserPartialLoad(t *testing.T) {
+	parser := NewCSVParser()
+	cons := NewEmptyConstructor()
+	name := writeRaw(`0,127.0.0.1:3000,aed142,aed142
+1,127.0.0.1:3001,aed142,zz
+2,127.0.0.1:3002
+3,127.0.0.1:3003,aed142,aed142
+3,127.0.0.1:3004,aed142,aed142
+x,127.0.0.1:3005,aed142,aed142
+5,127.0.0.1:3005,aed142,aed142
+`)
+	defer os.RemoveAll(name)
+
+	nodeList, report, err := ReadAllWith(name, parser, cons, LoadOptions{PartialLoad: true})
+	require.NoError(t, err)
+	// the malformed lines come first, then the invalid records
+	var skipped []string
+	for _, s := range report.Skipped {
+		require.Equal(t, name, s.File)
+		skipped = append(skipped, fmt.Sprintf("%d:%s", s.Line, s.Field))
+	}
+	require.Equal(t, []string{"3:", "6:id", "2:public", "5:id"}, skipped)
+	require.Contains(t, report.Skipped[3].Reason, "first at line 4")
+	require.Equal(t, []int32{1, 2, 4}, report.Inactive)
+
+	require.Equal(t, 6, nodeList.Registry().Size())
+	for i := 0; i < 6; i++ {
+		require.Equal(t, i == 0 || i == 3 || i == 5, nodeList.Node(i).Active, "node %d", i)
+	}
+	require.Equal(t, "127.0.0.1:3003", nodeList.Node(3).Address())
+}
+
+// sizedSecret is a fake secret key of 4 bytes
+type sizedSecret struct {
+	fakeSecret
+}
+
+func (s *sizedSecret) UnmarshalBinary(b []byte) error {
+	if len(b) != 4 {
+		return errors.New("invalid size")
+	}
+	return nil
+}
+
+type sizedConstructor struct {
+	emptyConstructor
+}
+
+func (s *sizedConstructor) SecretKey() SecretKey {
+	return new(sizedSecret)
+}
+
+func TestCSVParserLazy(t *testing.T) {
+	parser := NewCSVParser()
+	cons := new(sizedConstructor)
+	name := writeCSV([][]string{
+		{"0", "127.0.0.1:3000", "aed14201", "aed142"},
+		{"1", "127.0.0.1:3001", "aed142", "aed142"},
+	})
+	defer os.RemoveAll(name)
+
+	_, err := ReadAll(name, parser, cons)
+	require.Error(t, err)
+	require.Equal(t, "private", err.(*RecordError).Field)
+	// the invalid secret key is only seen at its first use in lazy mode
+	nodeList, _, err := ReadAllWith(name, parser, cons, LoadOptions{Lazy: true})
+	require.NoError(t, err)
+	_, err = nodeList.Node(0).Sign(Message, nil)
+	require.NoError(t, err)
+	require.Panics(t, func() { nodeList.Node(1).Sign(Message, nil) })
+}
+
+func TestCSVParserPublicOnly(t *testing.T) {
+	parser := NewCSVParser()
+	cons := new(sizedConstructor)
+	name := writeCSV([][]string{
+		{"0", "127.0.0.1:3000", "", "aed142"},
+		{"1", "127.0.0.1:3001", "aed14201", "aed142"},
+	})
+	defer os.RemoveAll(name)
+
+	_, err := ReadAll(name, parser, cons)
+	require.Error(t, err)
+	require.Equal(t, "private", err.(*RecordError).Field)
+	nodeList, _, err := ReadAllWith(name, parser, cons, LoadOptions{PublicOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, nodeList.Registry().Size())
+	require.Nil(t, nodeList.Node(0).SecretKey)
+	require.NotNil(t, nodeList.Node(1).SecretKey)
+	require.NotNil(t, nodeList.Node(0).PublicKey())
+}
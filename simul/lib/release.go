@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSyncRelease is the release policy of the sync master when the config
+// does not specify one. It keeps the historical behavior of releasing at
+// 99.5% of the expected nodes.
+const DefaultSyncRelease = "fraction:0.995"
+
+// ReleasePolicy decides when the sync master releases a barrier, i.e. sends
+// the state to the nodes, before all the expected nodes are ready. It is given
+// the arrival times of the distinct ids signaled so far, in order, the number
+// of expected ids and the current time.
+type ReleasePolicy interface {
+	Release(arrivals []time.Time, expected int, now time.Time) bool
+	String() string
+}
+
+// AllPolicy releases once all the expected ids are ready
+type AllPolicy struct{}
+
+// Release implements the ReleasePolicy interface
+func (AllPolicy) Release(arrivals []time.Time, expected int, now time.Time) bool {
+	return len(arrivals) >= expected
+}
+
+func (AllPolicy) String() string { return "all" }
+
+// FractionPolicy releases once a fraction of the expected ids are ready,
+// rounded up and at least one.
+type FractionPolicy float64
+
+// Release implements the ReleasePolicy interface
+func (f FractionPolicy) Release(arrivals []time.Time, expected int, now time.Time) bool {
+	return len(arrivals) >= fractionOf(float64(f), expected)
+}
+
+func (f FractionPolicy) String() string {
+	return "fraction:" + strconv.FormatFloat(float64(f), 'f', -1, 64)
+}
+
+// Default values of the AdaptivePolicy
+const (
+	DefaultAdaptiveWindow      = 5 * time.Second
+	DefaultAdaptiveFactor      = 0.1
+	DefaultAdaptiveMinFraction = 0.9
+)
+
+// AdaptivePolicy releases once the remaining nodes look stuck rather than
+// slow: the rate of arrivals over the last Window has fallen below Factor
+// times the rate observed before it. The history must cover at least two
+// windows, and at least MinFraction of the expected ids must be ready. A
+// steady flow of arrivals thus never releases early, while a flow which
+// stalls releases one window after the stall.
+type AdaptivePolicy struct {
+	Window      time.Duration
+	Factor      float64
+	MinFraction float64
+}
+
+// NewAdaptivePolicy returns an AdaptivePolicy over the given window, with the
+// default factor and minimum fraction.
+func NewAdaptivePolicy(window time.Duration) *AdaptivePolicy {
+	return &AdaptivePolicy{
+		Window:      window,
+		Factor:      DefaultAdaptiveFactor,
+		MinFraction: DefaultAdaptiveMinFraction,
+	}
+}
+
+// Release implements the ReleasePolicy interface
+func (a *AdaptivePolicy) Release(arrivals []time.Time, expected int, now time.Time) bool {
+	n := len(arrivals)
+	if n >= expected {
+		return true
+	}
+	if n == 0 || n < fractionOf(a.MinFraction, expected) {
+		return false
+	}
+	span := now.Sub(arrivals[0])
+	if span < 2*a.Window {
+		return false
+	}
+	start := now.Add(-a.Window)
+	var recent int
+	for i := n - 1; i >= 0 && arrivals[i].After(start); i-- {
+		recent++
+	}
+	pastRate := float64(n-recent) / (span - a.Window).Seconds()
+	recentRate := float64(recent) / a.Window.Seconds()
+	return recentRate < a.Factor*pastRate
+}
+
+func (a *AdaptivePolicy) String() string {
+	return "adaptive:" + a.Window.String()
+}
+
+// ParseReleasePolicy returns the policy of the given description: "all",
+// "fraction:p" with 0 < p <= 1, or "adaptive" optionally followed by the
+// window, as in "adaptive:10s". An empty description gives the
+// DefaultSyncRelease policy.
+func ParseReleasePolicy(s string) (ReleasePolicy, error) {
+	if s == "" {
+		s = DefaultSyncRelease
+	}
+	parts := strings.SplitN(s, ":", 2)
+	switch parts[0] {
+	case "all":
+		if len(parts) > 1 {
+			break
+		}
+		return AllPolicy{}, nil
+	case "fraction":
+		if len(parts) < 2 {
+			break
+		}
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || p <= 0 || p > 1 {
+			return nil, fmt.Errorf("sync release: invalid fraction %q", parts[1])
+		}
+		return FractionPolicy(p), nil
+	case "adaptive":
+		window := DefaultAdaptiveWindow
+		if len(parts) > 1 {
+			w, err := time.ParseDuration(parts[1])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("sync release: invalid window %q", parts[1])
+			}
+			window = w
+		}
+		return NewAdaptivePolicy(window), nil
+	}
+	return nil, fmt.Errorf("sync release: unknown policy %q", s)
+}
+
+// fractionOf returns the fraction of n rounded up, at least one
+func fractionOf(f float64, n int) int {
+	k := int(math.Ceil(f * float64(n)))
+	if k < 1 {
+		return 1
+	}
+	return k
+}
@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Unix(1500000000, 0)
+
+// steady returns n arrivals every interval
+func steady(n int, interval time.Duration) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = time.Duration(i) * interval
+	}
+	return out
+}
+
+// longTail returns the arrivals of steady followed by the tail arrivals, each
+// one waiting twice as long as the previous one, starting at the interval
+func longTail(n int, interval time.Duration, tail int) []time.Duration {
+	out := steady(n, interval)
+	last, wait := out[n-1], interval
+	for i := 0; i < tail; i++ {
+		last += wait
+		wait *= 2
+		out = append(out, last)
+	}
+	return out
+}
+
+// simulate steps the time by 10ms from the first arrival until the horizon
+// and returns the time of the release and the number of ids ready then, or
+// -1 if the policy never released.
+func simulate(p ReleasePolicy, arrivals []time.Duration, expected int, horizon time.Duration) (time.Duration, int) {
+	var ready []time.Time
+	for now := time.Duration(0); now <= horizon; now += 10 * time.Millisecond {
+		for len(ready) < len(arrivals) && arrivals[len(ready)] <= now {
+			ready = append(ready, epoch.Add(arrivals[len(ready)]))
+		}
+		if p.Release(ready, expected, epoch.Add(now)) {
+			return now, len(ready)
+		}
+	}
+	return -1, len(ready)
+}
+
+func TestReleasePolicies(t *testing.T) {
+	ms := time.Millisecond
+	adaptive := NewAdaptivePolicy(time.Second)
+	var tests = []struct {
+		policy   ReleasePolicy
+		arrivals []time.Duration
+		expected int
+		at       time.Duration // -1 if never released
+		ready    int
+	}{
+		// steady: everyone arrives, only "all" and the adaptive policy wait
+		// for the last one
+		{AllPolicy{}, steady(100, 100*ms), 100, 9900 * ms, 100},
+		{FractionPolicy(0.9), steady(100, 100*ms), 100, 8900 * ms, 90},
+		{adaptive, steady(100, 100*ms), 100, 9900 * ms, 100},
+		// 0.995 rounds up to all the nodes of small runs
+		{FractionPolicy(0.995), steady(50, 100*ms), 50, 4900 * ms, 50},
+		// stalled: 5 nodes never arrive
+		{AllPolicy{}, steady(95, 100*ms), 100, -1, 95},
+		{FractionPolicy(0.995), steady(95, 100*ms), 100, -1, 95},
+		{adaptive, steady(95, 100*ms), 100, 10300 * ms, 95},
+		// stalled below the minimum fraction of the adaptive policy
+		{adaptive, steady(80, 100*ms), 100, -1, 80},
+		// long tail: the adaptive policy gives up on the stragglers once
+		// their rate drops
+		{AllPolicy{}, longTail(90, 100*ms, 10), 100, 111200 * ms, 100},
+		{adaptive, longTail(90, 100*ms, 10), 100, 11400 * ms, 94},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- %s", i, test.policy)
+		at, ready := simulate(test.policy, test.arrivals, test.expected, 2*time.Minute)
+		require.Equal(t, test.at, at)
+		require.Equal(t, test.ready, ready)
+	}
+}
+
+func TestParseReleasePolicy(t *testing.T) {
+	var tests = []struct {
+		s   string
+		exp string // "" if invalid
+	}{
+		{"", "fraction:0.995"},
+		{"all", "all"},
+		{"fraction:0.9", "fraction:0.9"},
+		{"fraction:1", "fraction:1"},
+		{"adaptive", "adaptive:5s"},
+		{"adaptive:10s", "adaptive:10s"},
+		{"all:1", ""},
+		{"fraction", ""},
+		{"fraction:0", ""},
+		{"fraction:1.5", ""},
+		{"adaptive:-1s", ""},
+		{"adaptive:soon", ""},
+		{"random", ""},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- %q", i, test.s)
+		p, err := ParseReleasePolicy(test.s)
+		if test.exp == "" {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, test.exp, p.String())
+	}
+}
+
+type nopNetwork struct{}
+
+func (nopNetwork) RegisterListener(handel.Listener)       {}
+func (nopNetwork) Send([]handel.Identity, *handel.Packet) {}
+
+// fakeClock is a clock moved by the test
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestStateReleaseMissing(t *testing.T) {
+	var tests = []struct {
+		policy ReleasePolicy
+		// ids signaled, one every 100ms
+		ids []int
+		// time waited after the last id before checking
+		wait    time.Duration
+		missing []int // nil if not released
+	}{
+		{AllPolicy{}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 0, []int{}},
+		{AllPolicy{}, []int{0, 1, 2, 3, 4, 5, 6, 8, 9}, time.Minute, nil},
+		{FractionPolicy(0.8), []int{9, 0, 7, 1, 6, 2, 5, 3}, 0, []int{4, 8}},
+		{NewAdaptivePolicy(time.Second), []int{0, 1, 2, 3, 5, 6, 7, 8, 9}, 0, nil},
+		{NewAdaptivePolicy(time.Second), []int{0, 1, 2, 3, 5, 6, 7, 8, 9}, 2 * time.Second, []int{4}},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- %s", i, test.policy)
+		clock := &fakeClock{now: epoch}
+		stop := make(chan bool)
+		var wg sync.WaitGroup
+		spawn := func(fn func()) bool {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fn()
+			}()
+			return true
+		}
+		s := newState(nopNetwork{}, START, 10, 10, test.policy, stop, spawn, ioutil.Discard)
+		s.now = clock.Now
+		for _, id := range test.ids {
+			s.newMessage(&syncMessage{State: START, IDs: []int{id}, Address: "127.0.0.1:3000"})
+			clock.Add(100 * time.Millisecond)
+		}
+		clock.Add(test.wait)
+		s.Lock()
+		s.evaluate(clock.Now())
+		s.Unlock()
+		if test.missing == nil {
+			select {
+			case <-s.WaitFinish():
+				t.Fatal("state released")
+			default:
+			}
+		} else {
+			select {
+			case <-s.WaitFinish():
+			default:
+				t.Fatal("state not released")
+			}
+			s.Lock()
+			require.Equal(t, test.missing, s.missing)
+			s.Unlock()
+		}
+		close(stop)
+		wg.Wait()
+	}
+}
+
+func TestSyncMasterStats(t *testing.T) {
+	masterAddr := "127.0.0.1:3020"
+	// 4 nodes, the node 3 is offline and the node 2 is stuck
+	master := NewSyncMaster(masterAddr, 3, 4)
+	master.SetPolicy(FractionPolicy(0.5))
+	master.SetOutput(ioutil.Discard)
+	defer master.Stop()
+	require.Nil(t, master.Missing(START))
+
+	slave := NewSyncSlave("127.0.0.1:3021", masterAddr, []int{0, 1})
+	defer slave.Stop()
+	slave.SignalAll(START)
+	select {
+	case <-master.WaitAll(START):
+	case <-time.After(2 * time.Second):
+		t.Fatal("master not released")
+	}
+	select {
+	case <-slave.WaitMaster(START):
+	case <-time.After(2 * time.Second):
+		t.Fatal("slave not released")
+	}
+	require.Equal(t, []int{2, 3}, master.Missing(START))
+	require.Equal(t, map[string]string{
+		"sync_release":       "fraction:0.5",
+		"sync_missing_start": "2",
+		"sync_missing_end":   "",
+	}, master.Stats(map[int]bool{3: true}))
+}
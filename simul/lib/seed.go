@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	mathRand "math/rand"
+	"strconv"
+	"sync"
+)
+
+// The components of a run drawing random numbers, each from its own seed
+// derived from the seed of the run - see RunConfig.SubSeed.
+const (
+	// SeedPartitioner seeds the order in which the nodes contact their
+	// peers at each level, and the seed of the shared-seed partitioners
+	SeedPartitioner = "partitioner"
+	// SeedConnector seeds the overlay of the p2p simulations
+	SeedConnector = "connector"
+	// SeedFailures seeds the selection of the failing nodes
+	SeedFailures = "failures"
+	// SeedLatency seeds the latencies injected in the network
+	SeedLatency = "latency"
+)
+
+// seedDomain separates the sub-seeds of the runs from any other use of the
+// same hash
+const seedDomain = "handel/simul/seed"
+
+// ResolveSeeds gives a random seed to the runs without one, so that all the
+// runs have the seed they are recorded with. It must be called once, before
+// the config is written for the nodes.
+func (c *Config) ResolveSeeds() {
+	for i := range c.Runs {
+		for c.Runs[i].Seed == 0 {
+			var b [8]byte
+			if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+				panic(err)
+			}
+			c.Runs[i].Seed = int64(binary.BigEndian.Uint64(b[:]))
+		}
+	}
+}
+
+// SubSeed returns the seed of the given component of the run: the first 8
+// bytes of SHA-256("handel/simul/seed" || seed || component), the seed as 8
+// big-endian bytes. The components of a same run thus draw independent
+// numbers, and two runs of the same seed draw the same ones.
+func (r *RunConfig) SubSeed(component string) int64 {
+	return int64(binary.BigEndian.Uint64(r.expand(component)))
+}
+
+// expand returns the SHA-256 hash the sub-seed of the component is taken
+// from
+func (r *RunConfig) expand(component string) []byte {
+	h := sha256.New()
+	h.Write([]byte(seedDomain))
+	binary.Write(h, binary.BigEndian, r.Seed)
+	h.Write([]byte(component))
+	return h.Sum(nil)
+}
+
+// NodeSeed returns the seed of the given component for the node of the given
+// id, so the nodes of a run draw different numbers
+func (r *RunConfig) NodeSeed(component string, id int) int64 {
+	return r.SubSeed(component + "/" + strconv.Itoa(id))
+}
+
+// PartitionerSeed returns the seed of the shared-seed partitioners of the run,
+// see handel.Config.PartitionerSeed
+func (r *RunConfig) PartitionerSeed() []byte {
+	return r.expand(SeedPartitioner)
+}
+
+// SeedStats returns the static field recording the seed of the run
+func (r *RunConfig) SeedStats() map[string]string {
+	return map[string]string{"seed": strconv.FormatInt(r.Seed, 10)}
+}
+
+// NewSeededReader returns a reader of the pseudo-random bytes drawn from the
+// seed, safe for concurrent use, to use as handel.Config.Rand
+func NewSeededReader(seed int64) io.Reader {
+	return &seededReader{rnd: mathRand.New(mathRand.NewSource(seed))}
+}
+
+type seededReader struct {
+	sync.Mutex
+	rnd *mathRand.Rand
+}
+
+func (s *seededReader) Read(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.rnd.Read(p)
+}
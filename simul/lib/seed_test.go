@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubSeed(t *testing.T) {
+	r := &RunConfig{Seed: 42}
+	same := &RunConfig{Seed: 42}
+	other := &RunConfig{Seed: 43}
+	components := []string{SeedPartitioner, SeedConnector, SeedFailures, SeedLatency}
+	seen := make(map[int64]bool)
+	for _, c := range components {
+		require.Equal(t, r.SubSeed(c), same.SubSeed(c))
+		require.NotEqual(t, r.SubSeed(c), other.SubSeed(c))
+		seen[r.SubSeed(c)] = true
+	}
+	// the components draw independent numbers
+	require.Len(t, seen, len(components))
+	require.NotEqual(t, r.NodeSeed(SeedPartitioner, 1), r.NodeSeed(SeedPartitioner, 2))
+	require.Equal(t, r.PartitionerSeed(), same.PartitionerSeed())
+	require.NotEqual(t, r.PartitionerSeed(), other.PartitionerSeed())
+	require.Equal(t, map[string]string{"seed": "42"}, r.SeedStats())
+}
+
+func TestResolveSeeds(t *testing.T) {
+	c := &Config{Runs: []RunConfig{{Seed: 7}, {}, {}}}
+	c.ResolveSeeds()
+	require.Equal(t, int64(7), c.Runs[0].Seed)
+	require.NotEqual(t, int64(0), c.Runs[1].Seed)
+	require.NotEqual(t, c.Runs[1].Seed, c.Runs[2].Seed)
+	// the seeds are kept once resolved
+	seed := c.Runs[1].Seed
+	c.ResolveSeeds()
+	require.Equal(t, seed, c.Runs[1].Seed)
+}
+
+func TestSeededReader(t *testing.T) {
+	read := func(seed int64) []byte {
+		buff := make([]byte, 64)
+		_, err := io.ReadFull(NewSeededReader(seed), buff)
+		require.NoError(t, err)
+		return buff
+	}
+	require.Equal(t, read(1), read(1))
+	require.NotEqual(t, read(1), read(2))
+}
+
+func TestSeededFailures(t *testing.T) {
+	plats := make([]Platform, 3)
+	for i := range plats {
+		p := PlatString(string('a' + rune(i)))
+		plats[i] = &p
+	}
+	offline := func(seed int64) []int {
+		r := &RunConfig{Seed: seed}
+		c := &Config{Allocator: "random"}
+		var ids []int
+		for _, nodes := range c.NewAllocator(r).Allocate(plats, 30, 10) {
+			for _, n := range nodes {
+				if !n.Active {
+					ids = append(ids, n.ID)
+				}
+			}
+		}
+		sort.Ints(ids)
+		return ids
+	}
+	require.Equal(t, offline(1), offline(1))
+	require.NotEqual(t, offline(1), offline(2))
+}
+
+func TestSeededPartitioner(t *testing.T) {
+	ids := make([]handel.Identity, 32)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", nil)
+	}
+	reg := handel.NewArrayRegistry(ids)
+	// the peers of the node 3 in the order of the levels
+	picks := func(seed int64) []int32 {
+		r := &RunConfig{Seed: seed}
+		part := handel.NewRandomBinPartitioner(3, reg, handel.DefaultLogger, r.PartitionerSeed())
+		var order []int32
+		for _, lvl := range part.Levels() {
+			level, err := part.IdentitiesAt(lvl)
+			require.NoError(t, err)
+			for _, id := range level {
+				order = append(order, id.ID())
+			}
+		}
+		return order
+	}
+	require.Len(t, picks(1), 31)
+	require.Equal(t, picks(1), picks(1))
+	require.NotEqual(t, picks(1), picks(2))
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+)
+
+// PacketOverhead is the size of the IP and UDP headers, in bytes, counted in
+// the size of each packet sent through a ShapedNetwork
+const PacketOverhead = 28
+
+// ShapedNetwork wraps a handel.Network to limit its upload rate with a token
+// bucket: each packet sent to a destination consumes its encoded size in
+// tokens, refilled at the sustained rate up to the burst. A packet sent
+// without enough tokens is delayed until the tokens it lacks are refilled,
+// or dropped if the network drops. The packets are sent to the wrapped
+// network in order, once their serialization delay elapsed: any propagation
+// delay of the wrapped network comes after it.
+type ShapedNetwork struct {
+	handel.Network
+	enc network.Encoding
+	// bytes per second
+	rate  float64
+	burst float64
+	drop  bool
+
+	sync.Mutex
+	tokens float64
+	last   time.Time
+	queue  chan *shapedPacket
+	done   chan bool
+	// statistics
+	sent    int
+	delayed int
+	dropped int
+	delay   time.Duration
+	bytes   int
+}
+
+type shapedPacket struct {
+	at time.Time
+	id handel.Identity
+	p  *handel.Packet
+}
+
+// NewShapedNetwork returns the network n with an upload rate limited to the
+// given Kbps, and burst in bytes. The encoding computes the size of the
+// packets.
+func NewShapedNetwork(n handel.Network, enc network.Encoding, kbps, burst int, drop bool) *ShapedNetwork {
+	s := &ShapedNetwork{
+		Network: n,
+		enc:     enc,
+		rate:    float64(kbps) * 1000 / 8,
+		burst:   float64(burst),
+		drop:    drop,
+		tokens:  float64(burst),
+		last:    time.Now(),
+		queue:   make(chan *shapedPacket, 100000),
+		done:    make(chan bool),
+	}
+	go s.sendLoop()
+	return s
+}
+
+// Send implements the handel.Network interface: each destination is an upload
+// of the packet.
+func (s *ShapedNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	size := s.size(p)
+	for _, id := range ids {
+		at, ok := s.reserve(size)
+		if !ok {
+			continue
+		}
+		select {
+		case s.queue <- &shapedPacket{at: at, id: id, p: p}:
+		default:
+			// the queue is full
+			s.Lock()
+			s.sent--
+			s.dropped++
+			s.Unlock()
+		}
+	}
+}
+
+// reserve takes the tokens of a packet of the given size and returns the time
+// at which it can be sent, or false if it is dropped.
+func (s *ShapedNetwork) reserve(size int) (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.last = now
+	missing := float64(size) - s.tokens
+	if missing > 0 && s.drop {
+		s.dropped++
+		return now, false
+	}
+	// without enough tokens, the bucket goes in debt: the next packets wait
+	// for this one
+	s.tokens -= float64(size)
+	s.sent++
+	s.bytes += size
+	if missing <= 0 {
+		return now, true
+	}
+	wait := time.Duration(missing / s.rate * float64(time.Second))
+	s.delayed++
+	s.delay += wait
+	return now.Add(wait), true
+}
+
+func (s *ShapedNetwork) sendLoop() {
+	for {
+		select {
+		case sp := <-s.queue:
+			if d := time.Until(sp.at); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-s.done:
+					return
+				}
+			}
+			s.Network.Send([]handel.Identity{sp.id}, sp.p)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// size returns the number of bytes sent on the wire for the packet
+func (s *ShapedNetwork) size(p *handel.Packet) int {
+	var c byteCounter
+	if err := s.enc.Encode(p, &c); err != nil {
+		panic(err)
+	}
+	return int(c) + PacketOverhead
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
+
+// Stop stops sending the queued packets. It does not stop the wrapped
+// network.
+func (s *ShapedNetwork) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// Values implements the monitor.CounterMeasure interface: it returns the
+// values of the wrapped network, if any, along the packets sent, delayed
+// and dropped by the shaper, and their average delay in milliseconds.
+func (s *ShapedNetwork) Values() map[string]float64 {
+	values := make(map[string]float64)
+	if r, ok := s.Network.(handel.Reporter); ok {
+		for k, v := range r.Values() {
+			values[k] = v
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	avg := 0.0
+	if s.delayed > 0 {
+		avg = float64(s.delay) / float64(s.delayed) / float64(time.Millisecond)
+	}
+	values["shaped_sent"] = float64(s.sent)
+	values["shaped_bytes"] = float64(s.bytes)
+	values["shaped_delayed"] = float64(s.delayed)
+	values["shaped_dropped"] = float64(s.dropped)
+	values["shaped_delay"] = avg
+	return values
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/stretchr/testify/require"
+)
+
+// timedNetwork records the time and the size of the packets sent
+type timedNetwork struct {
+	sync.Mutex
+	times   []time.Time
+	waiters []countWaiter
+}
+
+func (n *timedNetwork) RegisterListener(handel.Listener) {}
+
+func (n *timedNetwork) Send(ids []handel.Identity, p *handel.Packet) {
+	n.Lock()
+	defer n.Unlock()
+	n.times = append(n.times, time.Now())
+	n.waiters = notifyCount(n.waiters, len(n.times))
+}
+
+// waitSent returns the channel signaled once count packets are sent
+func (n *timedNetwork) waitSent(count int) chan bool {
+	n.Lock()
+	defer n.Unlock()
+	w := countWaiter{count, make(chan bool, 1)}
+	n.waiters = notifyCount(append(n.waiters, w), len(n.times))
+	return w.ch
+}
+
+func (n *timedNetwork) sent() []time.Time {
+	n.Lock()
+	defer n.Unlock()
+	return append([]time.Time{}, n.times...)
+}
+
+// realisticPacket returns a packet of the size of a bn256 multi-signature of
+// a level of 128 nodes, with the individual signature
+func realisticPacket() *handel.Packet {
+	return &handel.Packet{
+		Origin:        12,
+		Level:         8,
+		MultiSig:      make([]byte, 64+128/8+8),
+		IndividualSig: make([]byte, 64),
+	}
+}
+
+func TestShapedNetworkRate(t *testing.T) {
+	kbps := 2000
+	burst := 4096
+	inner := new(timedNetwork)
+	enc := network.NewGOBEncoding()
+	shaped := NewShapedNetwork(inner, enc, kbps, burst, false)
+	defer shaped.Stop()
+
+	p := realisticPacket()
+	size := shaped.size(p)
+	// one second of upload at the sustained rate, sent at once
+	n := kbps * 1000 / 8 / size
+	ids := []handel.Identity{handel.NewStaticIdentity(1, "", nil)}
+	for i := 0; i < n; i++ {
+		shaped.Send(ids, p)
+	}
+	select {
+	case <-inner.waitSent(n):
+	case <-time.After(3 * time.Second):
+		t.Fatal("packets not sent")
+	}
+
+	// the burst goes at once, the rest at the sustained rate
+	times := inner.sent()
+	burstCt := burst / size
+	window := times[n-1].Sub(times[burstCt]).Seconds()
+	rate := float64((n-1-burstCt)*size*8) / 1000 / window
+	require.InEpsilon(t, float64(kbps), rate, 0.05, "rate %f Kbps", rate)
+
+	values := shaped.Values()
+	require.Equal(t, float64(n), values["shaped_sent"])
+	// the tokens refilled while sending may spare a few packets the delay
+	require.InDelta(t, float64(n-burstCt), values["shaped_delayed"], 2)
+	require.Equal(t, 0.0, values["shaped_dropped"])
+}
+
+func TestShapedNetworkDrop(t *testing.T) {
+	inner := new(timedNetwork)
+	enc := network.NewGOBEncoding()
+	shaped := NewShapedNetwork(inner, enc, 100, 4096, true)
+	defer shaped.Stop()
+
+	p := realisticPacket()
+	size := shaped.size(p)
+	ids := []handel.Identity{handel.NewStaticIdentity(1, "", nil)}
+	for i := 0; i < 100; i++ {
+		shaped.Send(ids, p)
+	}
+	// only the burst is sent, the rate is too low to refill a packet
+	burstCt := 4096 / size
+	select {
+	case <-inner.waitSent(burstCt + 1):
+		t.Fatal("more than the burst sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Len(t, inner.sent(), burstCt)
+	values := shaped.Values()
+	require.Equal(t, float64(burstCt), values["shaped_sent"])
+	require.Equal(t, float64(100-burstCt), values["shaped_dropped"])
+	require.Equal(t, 0.0, values["shaped_delayed"])
+}
+
+func TestUploadLimits(t *testing.T) {
+	r := &RunConfig{
+		Nodes:     100,
+		Bandwidth: &Bandwidth{Fraction: 0.25, UploadKbps: 10000},
+	}
+	limits := r.GetUploadLimits(1)
+	require.Len(t, limits, 25)
+	for _, kbps := range limits {
+		require.Equal(t, 10000, kbps)
+	}
+	require.Equal(t, limits, r.GetUploadLimits(1))
+	require.NotEqual(t, limits, r.GetUploadLimits(2))
+	require.Equal(t, DefaultBurst, r.Bandwidth.GetBurst())
+
+	stats := r.UploadStats(1)
+	require.Equal(t, "10000", stats["uploadKbps"])
+	require.Len(t, strings.Split(stats["slowUpload"], "-"), 25)
+
+	none := &RunConfig{Nodes: 10}
+	require.Len(t, none.GetUploadLimits(0), 0)
+	require.Equal(t, "", none.UploadStats(0)["slowUpload"])
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// skewSamples is the number of the last round trips to the master the
+// offset of the clock is estimated from
+const skewSamples = 9
+
+// Skew is the estimated offset of the clock of a node to the clock of the
+// master, so the times measured by different nodes can be compared.
+type Skew struct {
+	// Offset is the time of the master minus the local time
+	Offset time.Duration
+	// RTT is the round trip time to the master
+	RTT time.Duration
+	// Samples is the number of round trips the estimation is made of, zero
+	// if none
+	Samples int
+}
+
+// Correct returns the local time t in the time of the master
+func (s Skew) Correct(t time.Time) time.Time {
+	return t.Add(s.Offset)
+}
+
+// skewEstimator estimates the offset of the local clock to the clock of the
+// master with Cristian's algorithm: the master echoes the time a message was
+// sent with its own time, assumed to be read in the middle of the round
+// trip. The estimation is the median of the last skewSamples round trips, so
+// a few delayed messages don't move it.
+type skewEstimator struct {
+	sync.Mutex
+	offsets []time.Duration
+	rtts    []time.Duration
+	// signaled once enough samples are recorded, see wait
+	waiters []countWaiter
+}
+
+// countWaiter is signaled once a count reaches n
+type countWaiter struct {
+	n  int
+	ch chan bool
+}
+
+// notifyCount signals the waiters whose count is reached and returns the
+// others
+func notifyCount(waiters []countWaiter, count int) []countWaiter {
+	waiting := waiters[:0]
+	for _, w := range waiters {
+		if count >= w.n {
+			w.ch <- true
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	return waiting
+}
+
+// add records the round trip of a message sent at the given local time,
+// echoed by the master at the given time of the master and received back at
+// the given local time
+func (e *skewEstimator) add(sent, master, received time.Time) {
+	rtt := received.Sub(sent)
+	if rtt < 0 {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.offsets = append(e.offsets, master.Add(rtt/2).Sub(received))
+	e.rtts = append(e.rtts, rtt)
+	if len(e.offsets) > skewSamples {
+		e.offsets = e.offsets[1:]
+		e.rtts = e.rtts[1:]
+	}
+	e.waiters = notifyCount(e.waiters, len(e.offsets))
+}
+
+// wait returns the channel signaled once the estimation is made of the given
+// number of samples, at most skewSamples
+func (e *skewEstimator) wait(samples int) chan bool {
+	e.Lock()
+	defer e.Unlock()
+	if samples > skewSamples {
+		samples = skewSamples
+	}
+	w := countWaiter{samples, make(chan bool, 1)}
+	e.waiters = notifyCount(append(e.waiters, w), len(e.offsets))
+	return w.ch
+}
+
+// estimate returns the median of the offsets and round trip times recorded
+func (e *skewEstimator) estimate() Skew {
+	e.Lock()
+	defer e.Unlock()
+	return Skew{
+		Offset:  median(e.offsets),
+		RTT:     median(e.rtts),
+		Samples: len(e.offsets),
+	}
+}
+
+func median(values []time.Duration) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
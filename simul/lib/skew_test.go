@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkewEstimator(t *testing.T) {
+	e := new(skewEstimator)
+	require.Equal(t, Skew{}, e.estimate())
+	// the master is 40ms ahead, 10ms away
+	base := time.Unix(1000, 0)
+	for i := 0; i < 12; i++ {
+		sent := base.Add(time.Duration(i) * time.Second)
+		rtt := 10 * time.Millisecond
+		if i%4 == 0 {
+			// delayed on the way back
+			rtt = 300 * time.Millisecond
+		}
+		e.add(sent, sent.Add(40*time.Millisecond+5*time.Millisecond), sent.Add(rtt))
+	}
+	// received before sent
+	e.add(base, base, base.Add(-time.Second))
+	skew := e.estimate()
+	require.Equal(t, skewSamples, skew.Samples)
+	require.Len(t, e.wait(skewSamples+1), 1)
+	require.Equal(t, 40*time.Millisecond, skew.Offset)
+	require.Equal(t, 10*time.Millisecond, skew.RTT)
+	require.Equal(t, base.Add(40*time.Millisecond), skew.Correct(base))
+
+	require.Equal(t, 15*time.Millisecond, median([]time.Duration{20 * time.Millisecond, 10 * time.Millisecond}))
+}
+
+// TestSyncSkew runs a master and slaves whose clocks are skewed: the offsets
+// estimated by the slaves recover the skews and their corrected times align.
+func TestSyncSkew(t *testing.T) {
+	masterAddr := "127.0.0.1:3020"
+	skews := []time.Duration{-80 * time.Millisecond, 0, 35 * time.Millisecond, 2 * time.Second}
+	master := NewSyncMaster(masterAddr, len(skews), len(skews))
+	master.SetOutput(ioutil.Discard)
+	defer master.Stop()
+	masterSkew := 5 * time.Millisecond
+	master.SetClock(func() time.Time { return time.Now().Add(masterSkew) })
+
+	slaves := make([]*SyncSlave, len(skews))
+	for i, skew := range skews {
+		slaves[i] = NewSyncSlave("127.0.0.1:"+strconv.Itoa(3021+i), masterAddr, []int{i})
+		defer slaves[i].Stop()
+		skew := skew
+		slaves[i].SetClock(func() time.Time { return time.Now().Add(skew) })
+		slaves[i].SignalAll(START)
+	}
+	for i, slave := range slaves {
+		select {
+		case <-slave.WaitMaster(START):
+		case <-time.After(2 * time.Second):
+			t.Fatalf("slave %d not synced", i)
+		}
+		// the echo may come after the release
+		select {
+		case <-slave.WaitSkew(1):
+		case <-time.After(2 * time.Second):
+			t.Fatalf("slave %d without echo", i)
+		}
+	}
+
+	tolerance := 5 * time.Millisecond
+	now := time.Now()
+	for i, slave := range slaves {
+		t.Logf(" -- test %d -- ", i)
+		skew := slave.Skew()
+		require.True(t, skew.Samples > 0)
+		require.InDelta(t, float64(masterSkew-skews[i]), float64(skew.Offset), float64(tolerance))
+		// the same instant measured by each slave
+		local := now.Add(skews[i])
+		require.InDelta(t, float64(now.Add(masterSkew).UnixNano()), float64(skew.Correct(local).UnixNano()), float64(tolerance))
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
-	"math"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,44 +22,71 @@ import (
 // The "Protocol" looks like this:
 // - the SyncMaster listens on a UDP socket
 // - each node sends a "READY" message to the starter over that socket.
-// - the SyncMaster waits for n different READY messages.
+// - the SyncMaster waits for n different READY messages - see ReleasePolicy.
 // - once that is done, the SyncMaster sends a START message to all nodes.
 //
 // A READY message is a Packet which contains a structure inside the MultiSig
 // field, as to re-use the UDP code already present.
+//
+// The master echoes each READY message with its own time, so the nodes can
+// estimate the offset of their clock to its clock - see SyncSlave.Skew.
 type SyncMaster struct {
 	sync.Mutex
-	addr    string
-	exp     int
-	probExp int // probabilistically expected nb,i.e. 95% of exp
-	total   int
-	n       *udp.Network
-	states  map[int]*state
+	addr   string
+	exp    int
+	policy ReleasePolicy
+	total  int
+	n      *udp.Network
+	states map[int]*state
+	// closed on Stop to cancel the sending routines
+	stop    chan bool
+	stopped bool
+	wg      sync.WaitGroup
+	// where the progress of the states is printed
+	out io.Writer
+	// clock of the master, time.Now by default
+	now func() time.Time
 }
 
 type state struct {
 	sync.Mutex
-	n         handel.Network
-	id        int
-	total     int
-	probExp   int
-	exp       int
-	readys    map[int]bool
+	n      handel.Network
+	id     int
+	total  int
+	policy ReleasePolicy
+	exp    int
+	readys map[int]bool
+	// arrival times of the ids, in order
+	arrivals []time.Time
+	// ids not ready when the state was released
+	missing []int
+	// signaled once enough ids are ready, see SyncMaster.WaitReady
+	waiters   []countWaiter
+	now       func() time.Time
 	addresses map[string]bool
 	finished  chan bool
+	checking  bool
 	done      bool
 	fullDone  bool // true only when exp received - to stop sending out
 	ticker    *time.Ticker
 	doneCh    chan bool
+	stop      chan bool
+	// spawn runs a routine of the state, unless the master is stopped
+	spawn func(func()) bool
+	out   io.Writer
 }
 
-func newState(net handel.Network, id, total, exp, probExp int) *state {
+func newState(net handel.Network, id, total, exp int, policy ReleasePolicy, stop chan bool, spawn func(func()) bool, out io.Writer) *state {
 	return &state{
+		stop:      stop,
+		spawn:     spawn,
+		out:       out,
 		n:         net,
 		id:        id,
 		total:     total,
 		exp:       exp,
-		probExp:   probExp,
+		policy:    policy,
+		now:       time.Now,
 		readys:    make(map[int]bool),
 		addresses: make(map[string]bool),
 		finished:  make(chan bool, 1),
@@ -76,53 +106,91 @@ func (s *state) newMessage(msg *syncMessage) {
 		panic("this should not happen")
 	}
 	// list all IDs received
+	now := s.now()
 	for _, id := range msg.IDs {
 		_, stored := s.readys[id]
 		if !stored {
 			// only store them once
 			s.readys[id] = true
+			s.arrivals = append(s.arrivals, now)
 		}
 	}
+	s.waiters = notifyCount(s.waiters, len(s.readys))
 	// and store the address to send back the OK
 	_, stored := s.addresses[msg.Address]
 	if !stored {
 		s.addresses[msg.Address] = true
 	}
-	fmt.Print(s.String())
-	if len(s.readys) < s.exp {
-		if len(s.readys) >= s.probExp {
-			fmt.Printf("\n\n\n PROBABLILISTICALLY SYNCED AT 0.95\n\n\n")
-		} else {
-			return
-		}
+	fmt.Fprint(s.out, s.String())
+	// the policy may release without new arrivals
+	if !s.checking {
+		s.checking = s.spawn(s.checkLoop)
 	}
+	s.evaluate(now)
+}
 
+// evaluate releases the state if the policy allows it. It must be called with
+// the lock held.
+func (s *state) evaluate(now time.Time) {
+	if !s.policy.Release(s.arrivals, s.exp, now) {
+		return
+	}
 	// start sending if we were not before
 	if !s.done {
 		s.done = true
+		s.missing = []int{}
+		for id := 0; id < s.total; id++ {
+			if !s.readys[id] {
+				s.missing = append(s.missing, id)
+			}
+		}
+		if len(s.readys) < s.exp {
+			fmt.Fprintf(s.out, "\n\n\n SYNC %d RELEASED WITH %d/%d (%s)\n\n\n", s.id, len(s.readys), s.exp, s.policy)
+		}
 		s.finished <- true
-		go s.sendLoop()
+		s.spawn(s.sendLoop)
 	}
 
 	// only stop when we got all signature, after 5 sec
 	if len(s.readys) >= s.exp && !s.fullDone {
 		s.fullDone = true
-		go func() {
-			time.Sleep(5 * time.Minute)
-			s.ticker.Stop()
-			s.doneCh <- true
-		}()
+		s.spawn(func() {
+			timer := time.NewTimer(5 * time.Minute)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				s.doneCh <- true
+			case <-s.stop:
+			}
+		})
 	}
 }
 
-func (s *state) sendLoop() {
+// checkLoop evaluates the policy periodically until the state is released
+func (s *state) checkLoop() {
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-s.doneCh:
+		case <-s.stop:
 			return
-		case <-s.ticker.C:
+		case <-ticker.C:
 		}
+		s.Lock()
+		s.evaluate(s.now())
+		done := s.done
+		s.Unlock()
+		if done {
+			return
+		}
+	}
+}
 
+// sendLoop sends the release to the nodes right away, then periodically for
+// the nodes which missed it
+func (s *state) sendLoop() {
+	defer s.ticker.Stop()
+	for {
 		outgoing := &syncMessage{State: s.id}
 		buff, err := outgoing.ToBytes()
 		if err != nil {
@@ -135,12 +203,20 @@ func (s *state) sendLoop() {
 			ids = append(ids, id)
 		}
 		s.n.Send(ids, packet)
+
+		select {
+		case <-s.doneCh:
+			return
+		case <-s.stop:
+			return
+		case <-s.ticker.C:
+		}
 	}
 }
 
 func (s *state) String() string {
 	var b bytes.Buffer
-	fmt.Fprintf(&b, "Sync Master ID %d received %d/%d (prob. %d) status\n", s.id, len(s.readys), s.exp, s.probExp)
+	fmt.Fprintf(&b, "Sync Master ID %d received %d/%d (%s) status\n", s.id, len(s.readys), s.exp, s.policy)
 	for id := 0; id < s.total; id++ {
 		_, ok := s.readys[id]
 		if !ok {
@@ -159,7 +235,8 @@ func (s *state) String() string {
 }
 
 // NewSyncMaster returns an SyncMaster that listens on the given address,
-// for a expected number of READY messages.
+// for a expected number of READY messages. It releases the states following
+// the DefaultSyncRelease policy, see SetPolicy.
 func NewSyncMaster(addr string, expected, total int) *SyncMaster {
 	n, err := udp.NewNetwork(addr, network.NewGOBEncoding())
 	if err != nil {
@@ -167,30 +244,85 @@ func NewSyncMaster(addr string, expected, total int) *SyncMaster {
 	}
 	s := new(SyncMaster)
 	n.RegisterListener(s)
-	s.probExp = int(math.Ceil(float64(expected) * 0.995))
+	s.policy, _ = ParseReleasePolicy(DefaultSyncRelease)
 	s.states = make(map[int]*state)
 	s.total = total
 	s.exp = expected
 	s.n = n
+	s.stop = make(chan bool)
+	s.out = os.Stdout
+	s.now = time.Now
 	return s
 }
 
+// SetClock sets the clock of the master, time.Now by default. It must be
+// called before any state is waited on.
+func (s *SyncMaster) SetClock(now func() time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.now = now
+}
+
+// SetOutput sets where the progress of the synchronization is printed,
+// os.Stdout by default. It must be called before any state is waited on.
+func (s *SyncMaster) SetOutput(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+	s.out = w
+}
+
+// SetPolicy sets the policy releasing the states, START and END alike. It
+// must be called before any state is waited on.
+func (s *SyncMaster) SetPolicy(p ReleasePolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.policy = p
+}
+
 // WaitAll returns
 func (s *SyncMaster) WaitAll(id int) chan bool {
 	return s.getOrCreate(id).WaitFinish()
 }
 
+// WaitReady returns the channel signaled once n ids signaled the given state,
+// whether it is released or not.
+func (s *SyncMaster) WaitReady(id, n int) chan bool {
+	state := s.getOrCreate(id)
+	state.Lock()
+	defer state.Unlock()
+	w := countWaiter{n, make(chan bool, 1)}
+	state.waiters = notifyCount(append(state.waiters, w), len(state.readys))
+	return w.ch
+}
+
 func (s *SyncMaster) getOrCreate(id int) *state {
 	s.Lock()
 	defer s.Unlock()
 	state, exist := s.states[id]
 	if !exist {
-		state = newState(s.n, id, s.total, s.exp, s.probExp)
+		state = newState(s.n, id, s.total, s.exp, s.policy, s.stop, s.spawn, s.out)
+		state.now = s.now
 		s.states[id] = state
 	}
 	return state
 }
 
+// spawn runs a routine waited on by Stop and returns true, or returns false
+// without running it once stopped.
+func (s *SyncMaster) spawn(fn func()) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+	return true
+}
+
 // NewPacket implements the Listener interface
 func (s *SyncMaster) NewPacket(p *handel.Packet) {
 	msg := new(syncMessage)
@@ -198,12 +330,79 @@ func (s *SyncMaster) NewPacket(p *handel.Packet) {
 		panic(err)
 	}
 	s.getOrCreate(msg.State).newMessage(msg)
+	if msg.Sent != 0 {
+		s.echo(msg)
+	}
+}
+
+// echo sends back the time the message was sent with the time of the master
+func (s *SyncMaster) echo(msg *syncMessage) {
+	s.Lock()
+	now := s.now
+	s.Unlock()
+	echo := &syncMessage{State: msg.State, Echo: true, Sent: msg.Sent, Master: now().UnixNano()}
+	buff, err := echo.ToBytes()
+	if err != nil {
+		panic(err)
+	}
+	id := handel.NewStaticIdentity(0, msg.Address, nil)
+	s.n.Send([]handel.Identity{id}, &handel.Packet{MultiSig: buff})
+}
+
+// Status returns the number of nodes that signaled the given state so far and
+// the number of nodes expected.
+func (s *SyncMaster) Status(id int) (ready, expected int) {
+	state := s.getOrCreate(id)
+	state.Lock()
+	defer state.Unlock()
+	return len(state.readys), state.exp
+}
+
+// Missing returns the ids which had not signaled the given state when it was
+// released, nil if it is not released yet. The ids of the offline nodes are
+// part of it.
+func (s *SyncMaster) Missing(id int) []int {
+	state := s.getOrCreate(id)
+	state.Lock()
+	defer state.Unlock()
+	if state.missing == nil {
+		return nil
+	}
+	return append([]int{}, state.missing...)
+}
+
+// Stats returns the static stats of the synchronization: the release policy
+// and the ids missing at the release of the START and END states, space
+// separated, leaving out the given offline ids.
+func (s *SyncMaster) Stats(offline map[int]bool) map[string]string {
+	s.Lock()
+	policy := s.policy
+	s.Unlock()
+	stats := map[string]string{"sync_release": policy.String()}
+	for name, id := range map[string]int{"start": START, "end": END} {
+		var ids []string
+		for _, m := range s.Missing(id) {
+			if !offline[m] {
+				ids = append(ids, strconv.Itoa(m))
+			}
+		}
+		stats["sync_missing_"+name] = strings.Join(ids, " ")
+	}
+	return stats
 }
 
-// Stop stops the network layer of the syncmaster
+// Stop cancels the sending routines of the syncmaster, waits for them to
+// return and stops its network layer.
 func (s *SyncMaster) Stop() {
 	s.Lock()
-	defer s.Unlock()
+	if s.stopped {
+		s.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+	s.Unlock()
+	s.wg.Wait()
 	s.n.Stop()
 }
 
@@ -213,8 +412,17 @@ type SyncSlave struct {
 	own    string
 	master string
 	net    *udp.Network
+	// false when the network is shared with Handel and not stopped by Stop
+	ownNet bool
 	ids    []int
 	states map[int]*slaveState
+	// closed on Stop to cancel the signaling routines
+	stop    chan bool
+	stopped bool
+	wg      sync.WaitGroup
+	// clock of the slave, time.Now by default
+	now  func() time.Time
+	skew *skewEstimator
 }
 
 type slaveState struct {
@@ -226,19 +434,21 @@ type slaveState struct {
 	sent     bool
 	finished chan bool
 	done     bool
-	ticker   *time.Ticker
 	doneCh   chan bool
+	stop     chan bool
+	now      func() time.Time
 }
 
-func newSlaveState(n handel.Network, master, addr string, id int) *slaveState {
+func newSlaveState(n handel.Network, master, addr string, id int, stop chan bool, now func() time.Time) *slaveState {
 	return &slaveState{
+		now:      now,
 		n:        n,
 		id:       id,
 		master:   master,
 		addr:     addr,
 		finished: make(chan bool, 1),
-		ticker:   time.NewTicker(wait),
 		doneCh:   make(chan bool, 1),
+		stop:     stop,
 	}
 }
 
@@ -248,7 +458,7 @@ func (s *slaveState) WaitFinish() chan bool {
 
 func (s *slaveState) signal(ids []int) {
 	send := func() {
-		msg := &syncMessage{State: s.id, IDs: ids, Address: s.addr}
+		msg := &syncMessage{State: s.id, IDs: ids, Address: s.addr, Sent: s.now().UnixNano()}
 		buff, err := msg.ToBytes()
 		if err != nil {
 			panic(err)
@@ -258,11 +468,15 @@ func (s *slaveState) signal(ids []int) {
 		s.n.Send([]handel.Identity{id}, packet)
 	}
 	send()
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-s.doneCh:
 			return
-		case <-s.ticker.C:
+		case <-s.stop:
+			return
+		case <-ticker.C:
 		}
 		send()
 	}
@@ -289,16 +503,59 @@ func NewSyncSlave(own, master string, ids []int) *SyncSlave {
 	if err != nil {
 		panic(err)
 	}
+	slave := newSyncSlave(n, own, master, ids)
+	slave.ownNet = true
+	return slave
+}
+
+// NewSyncSlaveOn returns a Sync running over the given network, which is
+// listening on the own address. The slave only receives the sync packets of
+// the network, see IsSyncPacket, so a Handel listening to the non-sync
+// packets can share the same socket. Stop does not stop the network.
+func NewSyncSlaveOn(n *udp.Network, own, master string, ids []int) *SyncSlave {
+	return newSyncSlave(n, own, master, ids)
+}
+
+// IsSyncPacket returns true if the packet belongs to the sync protocol. Sync
+// packets are sent on level 0, which is never used by Handel.
+func IsSyncPacket(p *handel.Packet) bool {
+	return p.Level == 0
+}
+
+func newSyncSlave(n *udp.Network, own, master string, ids []int) *SyncSlave {
 	slave := new(SyncSlave)
-	n.RegisterListener(slave)
+	n.RegisterListenerFunc(IsSyncPacket, slave)
 	slave.ids = ids
 	slave.net = n
 	slave.own = own
 	slave.master = master
 	slave.states = make(map[int]*slaveState)
+	slave.stop = make(chan bool)
+	slave.now = time.Now
+	slave.skew = new(skewEstimator)
 	return slave
 }
 
+// SetClock sets the clock of the slave, time.Now by default. It must be
+// called before any signal.
+func (s *SyncSlave) SetClock(now func() time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.now = now
+}
+
+// Skew returns the offset of the clock of the slave to the clock of the
+// master, estimated from the echoes of the messages signaled so far
+func (s *SyncSlave) Skew() Skew {
+	return s.skew.estimate()
+}
+
+// WaitSkew returns the channel signaled once the skew is estimated from the
+// given number of echoes, at most the number of samples the estimation keeps.
+func (s *SyncSlave) WaitSkew(samples int) chan bool {
+	return s.skew.wait(samples)
+}
+
 const wait = 500 * time.Millisecond
 
 // WaitMaster first signals the master node for this state and returns the channel
@@ -312,15 +569,28 @@ func (s *SyncSlave) WaitMaster(stateID int) chan bool {
 // SignalAll sends a signal for the given state by sending all ids given to the
 // slave in one packet.
 func (s *SyncSlave) SignalAll(stateID int) {
-	state := s.getOrCreate(stateID)
-	go state.signal(s.ids)
+	s.spawn(stateID, s.ids)
 }
 
 // Signal sends an individual signal for the given state signalling only the
 // given ID.
 func (s *SyncSlave) Signal(stateID int, id int) {
+	s.spawn(stateID, []int{id})
+}
+
+// spawn runs a signaling routine for the given state, cancelled by Stop.
+func (s *SyncSlave) spawn(stateID int, ids []int) {
 	state := s.getOrCreate(stateID)
-	go state.signal([]int{id})
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		state.signal(ids)
+	}()
 }
 
 func (s *SyncSlave) getOrCreate(id int) *slaveState {
@@ -328,7 +598,7 @@ func (s *SyncSlave) getOrCreate(id int) *slaveState {
 	defer s.Unlock()
 	state, exists := s.states[id]
 	if !exists {
-		state = newSlaveState(s.net, s.master, s.own, id)
+		state = newSlaveState(s.net, s.master, s.own, id, s.stop, s.now)
 		s.states[id] = state
 	}
 	return state
@@ -340,12 +610,31 @@ func (s *SyncSlave) NewPacket(p *handel.Packet) {
 	if err := msg.FromBytes(p.MultiSig); err != nil {
 		panic(err)
 	}
+	if msg.Echo {
+		s.Lock()
+		now := s.now
+		s.Unlock()
+		s.skew.add(time.Unix(0, msg.Sent), time.Unix(0, msg.Master), now())
+		return
+	}
 	s.getOrCreate(msg.State).newMessage(msg)
 }
 
-// Stop the network layer of the syncslave
+// Stop cancels the signaling routines of the syncslave, waits for them to
+// return and stops its network layer.
 func (s *SyncSlave) Stop() {
-	s.net.Stop()
+	s.Lock()
+	if s.stopped {
+		s.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+	s.Unlock()
+	s.wg.Wait()
+	if s.ownNet {
+		s.net.Stop()
+	}
 }
 
 const (
@@ -357,11 +646,23 @@ const (
 	P2P
 )
 
+// RepetitionState returns the id of the state ending the given repetition of
+// a run, from 0, and starting the next one. The last repetition ends with the
+// END state instead.
+func RepetitionState(rep int) int {
+	return P2P + 1 + rep
+}
+
 // syncMessage is what is sent between a SyncMaster and a SyncSlave
 type syncMessage struct {
 	State   int    // the id of the state
 	Address string // address of the slave
 	IDs     []int  // ID of the slave - useful for debugging
+	// time of the slave when sending, in unix nanoseconds, echoed by the
+	// master with its own time
+	Sent   int64
+	Echo   bool
+	Master int64
 }
 
 func (s *syncMessage) ToBytes() ([]byte, error) {
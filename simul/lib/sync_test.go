@@ -3,6 +3,11 @@ package lib
 import (
 	"testing"
 	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network"
+	"github.com/ConsenSys/handel/network/udp"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSyncer(t *testing.T) {
@@ -54,3 +59,68 @@ func TestSyncer(t *testing.T) {
 	tryWait(END, master, slaves)
 	tryWait(5, master, slaves)
 }
+
+func TestSyncerSharedSocket(t *testing.T) {
+	masterAddr := "127.0.0.1:3010"
+	slaveAddr := "127.0.0.1:3011"
+	master := NewSyncMaster(masterAddr, 1, 1)
+	defer master.Stop()
+
+	net, err := udp.NewNetwork(slaveAddr, network.NewGOBEncoding())
+	require.NoError(t, err)
+	defer net.Stop()
+	slave := NewSyncSlaveOn(net, slaveAddr, masterAddr, []int{0})
+	handelPackets := make(chan *handel.Packet, 10)
+	net.Filtered(func(p *handel.Packet) bool { return !IsSyncPacket(p) }).
+		RegisterListener(handel.ListenFunc(func(p *handel.Packet) { handelPackets <- p }))
+
+	ready := master.WaitReady(START, 1)
+	require.Len(t, ready, 0)
+	slave.SignalAll(START)
+	select {
+	case <-slave.WaitMaster(START):
+	case <-time.After(2 * time.Second):
+		t.Fatal("slave not synced")
+	}
+	require.Len(t, ready, 1)
+	// the handel listener only gets the handel packets
+	id := handel.NewStaticIdentity(0, slaveAddr, nil)
+	net.Send([]handel.Identity{id}, &handel.Packet{Level: 1, MultiSig: []byte{0x01}})
+	select {
+	case p := <-handelPackets:
+		require.Equal(t, byte(1), p.Level)
+	case <-time.After(time.Second):
+		t.Fatal("handel packet not received")
+	}
+	require.Len(t, handelPackets, 0)
+
+	// stopping the slave leaves the shared network running
+	slave.Stop()
+	net.Send([]handel.Identity{id}, &handel.Packet{Level: 2, MultiSig: []byte{0x01}})
+	select {
+	case p := <-handelPackets:
+		require.Equal(t, byte(2), p.Level)
+	case <-time.After(time.Second):
+		t.Fatal("handel packet not received")
+	}
+}
+
+func TestRepetitions(t *testing.T) {
+	r := &RunConfig{}
+	require.Equal(t, 1, r.GetRepetitions())
+	require.Equal(t, Message, r.RepetitionMessage(2, 0))
+
+	r.Repetitions = 3
+	seen := map[string]bool{string(Message): true}
+	states := map[int]bool{START: true, END: true, P2P: true}
+	for rep := 0; rep < r.GetRepetitions(); rep++ {
+		// each repetition signs its own message, and has its own barrier
+		msg := string(r.RepetitionMessage(2, rep))
+		require.False(t, seen[msg])
+		seen[msg] = true
+		require.False(t, states[RepetitionState(rep)])
+		states[RepetitionState(rep)] = true
+	}
+	require.NotEqual(t, r.RepetitionMessage(2, 0), r.RepetitionMessage(3, 0))
+	require.Equal(t, Message, r.RepetitionMessage(3, 0)[:len(Message)])
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Threshold is the number of signatures the nodes of a run wait for. In the
+// TOML config, it is either a number of signatures, as `Threshold = 34`, or a
+// percentage of the nodes of the run, as `Threshold = "75%"`, which LoadConfig
+// resolves rounding up, so the same config sweeps the number of nodes. Zero
+// waits for all the nodes.
+type Threshold struct {
+	// Count is the number of signatures, resolved from Percent if it is set
+	Count int
+	// Percent is the percentage of the nodes, in (0, 100], zero for a number
+	// of signatures
+	Percent float64
+}
+
+// UnmarshalTOML implements the toml.Unmarshaler interface
+func (t *Threshold) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		*t = Threshold{Count: int(v)}
+		return nil
+	case string:
+		return t.UnmarshalText([]byte(v))
+	}
+	return fmt.Errorf("threshold: invalid value %v, want a number or a percentage as \"75%%\"", v)
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface, parsing a
+// number or a percentage as "75%"
+func (t *Threshold) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || !(p > 0 && p <= 100) {
+			return fmt.Errorf("threshold: invalid percentage %q, want one in (0%%, 100%%]", s)
+		}
+		*t = Threshold{Percent: p}
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("threshold: invalid value %q, want a number or a percentage as \"75%%\"", s)
+	}
+	*t = Threshold{Count: n}
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface: the configs
+// written keep the percentages.
+func (t Threshold) MarshalText() ([]byte, error) {
+	if t.Percent > 0 {
+		return []byte(strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"), nil
+	}
+	return []byte(strconv.Itoa(t.Count)), nil
+}
+
+// count returns the number of signatures of the threshold for the given
+// number of nodes, at least one for a percentage
+func (t Threshold) count(nodes int) int {
+	if t.Percent <= 0 {
+		return t.Count
+	}
+	// the margin absorbs the rounding errors, e.g. of 0.07 * 100
+	n := int(math.Ceil(t.Percent*float64(nodes)/100 - 1e-9))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// resolve sets the number of signatures of a percentage for the given number
+// of nodes, and checks the threshold
+func (t *Threshold) resolve(nodes int) error {
+	if t.Percent < 0 || t.Percent > 100 {
+		return fmt.Errorf("threshold: invalid percentage %v%%", t.Percent)
+	}
+	t.Count = t.count(nodes)
+	if t.Count < 0 {
+		return fmt.Errorf("threshold: negative threshold %d", t.Count)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholdDecode(t *testing.T) {
+	var tests = []struct {
+		toml  string
+		nodes int
+		exp   Threshold
+		frac  float64
+		err   bool
+	}{
+		{`Threshold = 34`, 40, Threshold{Count: 34}, 0.85, false},
+		{`Threshold = 0`, 40, Threshold{}, 1, false},
+		{`Threshold = "75%"`, 100, Threshold{Count: 75, Percent: 75}, 0.75, false},
+		{`Threshold = "75%"`, 10, Threshold{Count: 8, Percent: 75}, 0.8, false},
+		{`Threshold = "7%"`, 100, Threshold{Count: 7, Percent: 7}, 0.07, false},
+		{`Threshold = "100%"`, 33, Threshold{Count: 33, Percent: 100}, 1, false},
+		{`Threshold = "0.1%"`, 10, Threshold{Count: 1, Percent: 0.1}, 0.1, false},
+		{`Threshold = " 50 % "`, 9, Threshold{Count: 5, Percent: 50}, 5.0 / 9, false},
+		{`Threshold = "12"`, 20, Threshold{Count: 12}, 0.6, false},
+		{`Threshold = "0%"`, 10, Threshold{}, 0, true},
+		{`Threshold = "101%"`, 10, Threshold{}, 0, true},
+		{`Threshold = "-5%"`, 10, Threshold{}, 0, true},
+		{`Threshold = "NaN%"`, 10, Threshold{}, 0, true},
+		{`Threshold = "75"`, 100, Threshold{Count: 75}, 0.75, false},
+		{`Threshold = "three quarters"`, 10, Threshold{}, 0, true},
+		{`Threshold = 0.75`, 10, Threshold{}, 0, true},
+	}
+	for i, test := range tests {
+		var r RunConfig
+		_, err := toml.Decode(test.toml, &r)
+		if test.err {
+			require.Error(t, err, "test %d", i)
+			continue
+		}
+		require.NoError(t, err, "test %d", i)
+		r.Nodes = test.nodes
+		require.NoError(t, r.Threshold.resolve(r.Nodes), "test %d", i)
+		require.Equal(t, test.exp, r.Threshold, "test %d", i)
+		require.InDelta(t, test.frac, r.ThresholdFraction(), 1e-9, "test %d", i)
+	}
+}
+
+func TestThresholdResolve(t *testing.T) {
+	// GetThreshold resolves the percentages of the configs built in code
+	r := &RunConfig{Nodes: 2000, Threshold: Threshold{Percent: 51}}
+	require.Equal(t, 1020, r.GetThreshold())
+	r = &RunConfig{Nodes: 3, Threshold: Threshold{Percent: 1}}
+	require.Equal(t, 1, r.GetThreshold())
+	require.Error(t, (&Threshold{Count: -1}).resolve(10))
+	require.Error(t, (&Threshold{Percent: 150}).resolve(10))
+}
+
+func TestThresholdEncode(t *testing.T) {
+	c := &Config{Runs: []RunConfig{
+		{Nodes: 10, Threshold: Threshold{Percent: 75}},
+		{Nodes: 10, Threshold: Threshold{Count: 6}},
+	}}
+	var buff bytes.Buffer
+	require.NoError(t, toml.NewEncoder(&buff).Encode(c))
+	var c2 Config
+	_, err := toml.Decode(buff.String(), &c2)
+	require.NoError(t, err)
+	require.Equal(t, Threshold{Percent: 75}, c2.Runs[0].Threshold)
+	require.Equal(t, Threshold{Count: 6}, c2.Runs[1].Threshold)
+}
@@ -0,0 +1,294 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// TracingConfig makes the nodes export the timeline of their aggregations as
+// OpenTelemetry traces, e.g. to Jaeger, see OTLPTracer.
+type TracingConfig struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, as
+	// "http://jaeger:4318": the spans are posted to its /v1/traces
+	Endpoint string
+	// SampleRatio is the fraction of the nodes traced, in (0, 1]. Zero
+	// traces all the nodes.
+	SampleRatio float64
+}
+
+// Sampled returns true if the node of the given ID is traced. The nodes
+// traced are the same in each run.
+func (t *TracingConfig) Sampled(id int32) bool {
+	if t.SampleRatio <= 0 || t.SampleRatio >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strconv.Itoa(int(id))))
+	return float64(h.Sum32())/float64(1<<32) < t.SampleRatio
+}
+
+// NewTracer returns the tracer of the nodes, nil if the config has no Tracing
+// section
+func (c *Config) NewTracer(logger handel.Logger) *OTLPTracer {
+	if c.Tracing == nil || c.Tracing.Endpoint == "" {
+		return nil
+	}
+	return NewOTLPTracer(c.Tracing.Endpoint, logger)
+}
+
+const (
+	// size of the queue of the spans ended waiting to be exported, past which
+	// they are dropped
+	otlpQueueSize = 8192
+	// maximum number of spans posted at once
+	otlpBatchSize = 512
+	// maximum time a span ended waits before being exported
+	otlpFlushPeriod = time.Second
+	// maximum time the last spans are exported for when closing
+	otlpCloseTimeout = 5 * time.Second
+)
+
+// OTLPTracer is a handel.Tracer exporting the spans to an OpenTelemetry
+// collector, in the JSON encoding of OTLP/HTTP, so it doesn't depend on the
+// OpenTelemetry SDK. Each aggregation is a trace.
+//
+// The export never blocks Handel: the spans ended are queued, and a routine
+// posts them in batches. They are dropped if the queue is full, and the
+// batches the collector does not accept are lost.
+type OTLPTracer struct {
+	url    string
+	client *http.Client
+	log    handel.Logger
+
+	spans chan *otlpSpan
+	done  chan bool
+	wg    sync.WaitGroup
+
+	sync.Mutex
+	exported int
+	dropped  int
+	failed   int
+}
+
+// NewOTLPTracer returns a tracer exporting the spans to the OTLP/HTTP
+// collector at the given endpoint
+func NewOTLPTracer(endpoint string, logger handel.Logger) *OTLPTracer {
+	t := &OTLPTracer{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: otlpCloseTimeout},
+		log:    logger,
+		spans:  make(chan *otlpSpan, otlpQueueSize),
+		done:   make(chan bool),
+	}
+	t.wg.Add(1)
+	go t.exportLoop()
+	return t
+}
+
+// Start implements the handel.Tracer interface
+func (t *OTLPTracer) Start(parent handel.Span, name string) handel.Span {
+	s := &otlpSpan{t: t, name: name, start: time.Now(), spanID: randomID(8)}
+	if p, ok := parent.(*otlpSpan); ok {
+		s.traceID = p.traceID
+		s.parentID = p.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	return s
+}
+
+// Close exports the spans queued and stops the export
+func (t *OTLPTracer) Close() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+// Values implements the monitor.Counter interface
+func (t *OTLPTracer) Values() map[string]float64 {
+	t.Lock()
+	defer t.Unlock()
+	return map[string]float64{
+		"tracing_exported": float64(t.exported),
+		"tracing_dropped":  float64(t.dropped),
+		"tracing_failed":   float64(t.failed),
+	}
+}
+
+// ended queues the span for the export, or drops it if the queue is full
+func (t *OTLPTracer) ended(s *otlpSpan) {
+	select {
+	case t.spans <- s:
+	default:
+		t.Lock()
+		t.dropped++
+		t.Unlock()
+	}
+}
+
+// exportLoop posts the spans queued once the batch is full or the flush
+// period elapsed, and the remaining ones once closed
+func (t *OTLPTracer) exportLoop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(otlpFlushPeriod)
+	defer ticker.Stop()
+	var batch []*otlpSpan
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.done:
+			for {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+		t.export(batch)
+		batch = nil
+	}
+}
+
+// export posts the spans to the collector
+func (t *OTLPTracer) export(spans []*otlpSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest(spans))
+	if err == nil {
+		var resp *http.Response
+		resp, err = t.client.Post(t.url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = &otlpError{resp.Status}
+			}
+		}
+	}
+	t.Lock()
+	defer t.Unlock()
+	if err != nil {
+		t.failed += len(spans)
+		t.log.Warn("tracing_export", err, "spans", len(spans))
+		return
+	}
+	t.exported += len(spans)
+}
+
+type otlpError struct {
+	status string
+}
+
+func (e *otlpError) Error() string {
+	return "otlp collector: " + e.status
+}
+
+// otlpSpan is a span of the OTLPTracer, whose attributes are only set by one
+// routine at a time, as Handel does
+type otlpSpan struct {
+	t        *OTLPTracer
+	name     string
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    []otlpAttribute
+}
+
+// SetInt implements the handel.Span interface
+func (s *otlpSpan) SetInt(key string, value int64) {
+	v := strconv.FormatInt(value, 10)
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: otlpValue{Int: &v}})
+}
+
+// SetString implements the handel.Span interface
+func (s *otlpSpan) SetString(key, value string) {
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: otlpValue{String: &value}})
+}
+
+// End implements the handel.Span interface
+func (s *otlpSpan) End() {
+	s.end = time.Now()
+	s.t.ended(s)
+}
+
+// The messages of the JSON encoding of OTLP, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding: the IDs
+// are in hexadecimal, and the 64 bits integers in strings.
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"`
+}
+
+type otlpJSONSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+// otlpInternal is the kind of the spans, SPAN_KIND_INTERNAL
+const otlpInternal = 1
+
+// otlpRequest returns the ExportTraceServiceRequest of the spans
+func otlpRequest(spans []*otlpSpan) interface{} {
+	service := "handel"
+	encoded := make([]otlpJSONSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpJSONSpan{
+			TraceID:      s.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parentID,
+			Name:         s.name,
+			Kind:         otlpInternal,
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:   s.attrs,
+		}
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{String: &service}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/ConsenSys/handel"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+// randomID returns a random ID of the given size in hexadecimal
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+// collector is an OTLP/HTTP collector keeping the spans posted
+type collector struct {
+	sync.Mutex
+	spans []map[string]interface{}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{}
+			}
+		}
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Path != "/v1/traces" || json.Unmarshal(body, &req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func TestOTLPTracer(t *testing.T) {
+	c := new(collector)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	tracer := NewOTLPTracer(srv.URL+"/", handel.DefaultLogger)
+	root := tracer.Start(nil, handel.SpanAggregation)
+	root.SetInt("handel.id", 3)
+	lvl := tracer.Start(root, handel.SpanLevel)
+	lvl.SetInt("handel.level", 2)
+	lvl.End()
+	root.SetString("handel.outcome", "threshold_met")
+	root.End()
+	other := tracer.Start(nil, handel.SpanAggregation)
+	other.End()
+	tracer.Close()
+
+	require.Equal(t, 3.0, tracer.Values()["tracing_exported"])
+	require.Len(t, c.spans, 3)
+	l, r, o := c.spans[0], c.spans[1], c.spans[2]
+	require.Equal(t, handel.SpanLevel, l["name"])
+	require.Equal(t, r["traceId"], l["traceId"])
+	require.Equal(t, r["spanId"], l["parentSpanId"])
+	require.Len(t, r["traceId"], 32)
+	require.Len(t, r["spanId"], 16)
+	require.Nil(t, r["parentSpanId"])
+	require.NotEqual(t, r["traceId"], o["traceId"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"key": "handel.id", "value": map[string]interface{}{"intValue": "3"}},
+		map[string]interface{}{"key": "handel.outcome", "value": map[string]interface{}{"stringValue": "threshold_met"}},
+	}, r["attributes"])
+	require.True(t, r["startTimeUnixNano"].(string) <= r["endTimeUnixNano"].(string))
+}
+
+func TestOTLPTracerFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	tracer := NewOTLPTracer(srv.URL, handel.DefaultLogger)
+	tracer.Start(nil, handel.SpanAggregation).End()
+	tracer.Close()
+	require.Equal(t, 1.0, tracer.Values()["tracing_failed"])
+	require.Equal(t, 0.0, tracer.Values()["tracing_exported"])
+}
+
+func TestTracingSampled(t *testing.T) {
+	all := &TracingConfig{}
+	sampled := &TracingConfig{SampleRatio: 0.25}
+	var n int
+	for id := int32(0); id < 1000; id++ {
+		require.True(t, all.Sampled(id))
+		if sampled.Sampled(id) {
+			n++
+		}
+		require.Equal(t, sampled.Sampled(id), sampled.Sampled(id))
+	}
+	require.InDelta(t, 250, n, 60)
+}
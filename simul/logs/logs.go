@@ -0,0 +1,276 @@
+// Package logs allows simulation nodes to stream their logs back to the
+// orchestrator. A node uses a Streamer, an io.Writer that sends each line it
+// receives as a length-prefixed frame over TCP to a Sink. The Sink, run by the
+// orchestrator, multiplexes the lines of all nodes to the console, prefixed by
+// the name of the node, and writes one log file per node.
+//
+// The first frame sent by a Streamer is the name of the node. A Streamer never
+// blocks the node: when the connection is too slow, lines are dropped and
+// counted.
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxLineSize is the maximum size of a line accepted by the Sink.
+const MaxLineSize = 64 * 1024
+
+// DefaultQueueSize is the number of lines a Streamer buffers before dropping
+// new ones.
+const DefaultQueueSize = 1000
+
+// CloseTimeout is the maximum time a Streamer waits for the Sink to
+// acknowledge the end of its stream when closing.
+const CloseTimeout = 5 * time.Second
+
+// writeFrame writes the given line prefixed by its length.
+func writeFrame(w io.Writer, line []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(line))); err != nil {
+		return err
+	}
+	_, err := w.Write(line)
+	return err
+}
+
+// readFrame reads a length-prefixed line.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > MaxLineSize {
+		return nil, fmt.Errorf("logs: line too long (%d bytes)", length)
+	}
+	buff := make([]byte, length)
+	_, err := io.ReadFull(r, buff)
+	return buff, err
+}
+
+// Streamer is an io.Writer sending each line written to a Sink. Writes never
+// block: if the queue of lines is full, the lines are dropped.
+type Streamer struct {
+	sync.Mutex
+	conn    net.Conn
+	lines   chan []byte
+	done    chan bool
+	closed  bool
+	dropped int
+}
+
+// NewStreamer connects to the sink at the given address and announces itself
+// with the given name.
+func NewStreamer(addr, name string) (*Streamer, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, []byte(name)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &Streamer{
+		conn:  conn,
+		lines: make(chan []byte, DefaultQueueSize),
+		done:  make(chan bool),
+	}
+	go s.sendLoop()
+	return s, nil
+}
+
+// Write implements the io.Writer interface. Each line of p is sent as a
+// separate frame.
+func (s *Streamer) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return 0, errors.New("logs: streamer closed")
+	}
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		cp := make([]byte, len(line))
+		copy(cp, line)
+		select {
+		case s.lines <- cp:
+		default:
+			s.dropped++
+		}
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of lines dropped because the sink was too slow.
+func (s *Streamer) Dropped() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.dropped
+}
+
+// Close sends the remaining lines and closes the connection. It returns once
+// the sink acknowledged, by closing its side of the connection, that all the
+// lines were written, or after CloseTimeout.
+func (s *Streamer) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.lines)
+	s.Unlock()
+	<-s.done
+	if tcp, ok := s.conn.(*net.TCPConn); ok {
+		if err := tcp.CloseWrite(); err == nil {
+			s.conn.SetReadDeadline(time.Now().Add(CloseTimeout))
+			io.Copy(ioutil.Discard, s.conn)
+		}
+	}
+	return s.conn.Close()
+}
+
+func (s *Streamer) sendLoop() {
+	defer close(s.done)
+	w := bufio.NewWriter(s.conn)
+	for line := range s.lines {
+		if err := writeFrame(w, line); err != nil {
+			// connection is broken, drain what's left
+			s.Lock()
+			s.dropped++
+			s.Unlock()
+			continue
+		}
+		if len(s.lines) == 0 {
+			w.Flush()
+		}
+	}
+	w.Flush()
+}
+
+// Sink listens for Streamers and multiplexes their lines to the console and to
+// one file per node in a directory.
+type Sink struct {
+	sync.Mutex
+	listener net.Listener
+	dir      string
+	console  io.Writer
+	// mu guards stopped so no stream is added to wg once Stop waits on it
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewSink listens on the given address and writes the logs of each node in
+// the given directory, as well as to the console, which can be nil.
+func NewSink(addr, dir string, console io.Writer) (*Sink, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sink{
+		listener: l,
+		dir:      dir,
+		console:  console,
+	}
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address the sink is listening on.
+func (s *Sink) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Path returns the path of the log file of the given node.
+func (s *Sink) Path(name string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return filepath.Join(s.dir, clean+".log")
+}
+
+// Stop closes the listener and waits for all the accepted streams to be
+// closed and written. Streamers should be closed before the sink is stopped:
+// connections not yet accepted are dropped.
+func (s *Sink) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.listener.Close()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Sink) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle writes the lines of a stream until its end. The connection is closed
+// only once the log file is, which acknowledges the end of the stream to the
+// Streamer.
+func (s *Sink) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	name, err := readFrame(r)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(s.Path(string(name)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		s.print(string(name), "logs: can't open log file: "+err.Error())
+		return
+	}
+	defer file.Close()
+	for {
+		line, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				s.print(string(name), "logs: stream error: "+err.Error())
+			}
+			return
+		}
+		file.Write(append(line, '\n'))
+		s.print(string(name), string(line))
+	}
+}
+
+// print writes a full line to the console, so lines from different nodes are
+// never interleaved.
+func (s *Sink) print(name, line string) {
+	if s.console == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	fmt.Fprintf(s.console, "[%s] %s\n", name, line)
+}
@@ -0,0 +1,88 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a concurrent-safe console.
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.Buffer.Write(p)
+}
+
+func TestLogsStreamToSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	console := new(lockedBuffer)
+	sink, err := NewSink("127.0.0.1:0", dir, console)
+	require.NoError(t, err)
+
+	nodes := 5
+	lines := 200
+	var wg sync.WaitGroup
+	for i := 0; i < nodes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := NewStreamer(sink.Addr(), fmt.Sprintf("node-%d", i))
+			require.NoError(t, err)
+			for j := 0; j < lines; j++ {
+				fmt.Fprintf(s, "node %d line %d\n", i, j)
+			}
+			require.NoError(t, s.Close())
+			require.Equal(t, 0, s.Dropped())
+		}(i)
+	}
+	wg.Wait()
+	sink.Stop()
+
+	// each file is complete and in order
+	for i := 0; i < nodes; i++ {
+		buff, err := ioutil.ReadFile(sink.Path(fmt.Sprintf("node-%d", i)))
+		require.NoError(t, err)
+		fileLines := strings.Split(strings.TrimRight(string(buff), "\n"), "\n")
+		require.Len(t, fileLines, lines)
+		for j, l := range fileLines {
+			require.Equal(t, fmt.Sprintf("node %d line %d", i, j), l)
+		}
+	}
+
+	// each console line is complete and prefixed by the right node
+	consoleLines := strings.Split(strings.TrimRight(console.String(), "\n"), "\n")
+	require.Len(t, consoleLines, nodes*lines)
+	for _, l := range consoleLines {
+		var prefix, node, line int
+		_, err := fmt.Sscanf(l, "[node-%d] node %d line %d", &prefix, &node, &line)
+		require.NoError(t, err, l)
+		require.Equal(t, prefix, node)
+	}
+}
+
+func TestLogsStreamerDrops(t *testing.T) {
+	// a streamer whose sending routine is stuck: nothing consumes the queue
+	s := &Streamer{lines: make(chan []byte, 2)}
+	n, err := s.Write([]byte("line 1\nline 2\nline 3\n"))
+	require.NoError(t, err)
+	require.Equal(t, 21, n)
+	_, err = s.Write([]byte("line 4"))
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Dropped())
+	require.Equal(t, []byte("line 1"), <-s.lines)
+	require.Equal(t, []byte("line 2"), <-s.lines)
+}
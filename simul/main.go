@@ -8,9 +8,16 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/ConsenSys/handel/simul/bundle"
 	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/logs"
 	"github.com/ConsenSys/handel/simul/platform"
 )
 
@@ -19,17 +26,77 @@ var platformFlag = flag.String("platform", "", "name of the platform to run on")
 var runTimeout = flag.Duration("run-timeout", 10*time.Minute, "timeout of a given run")
 
 var awsConfigPath = flag.String("awsConfig", "", "TOML encoded config file AWS specyfic config")
+var inventoryPath = flag.String("inventory", "", "TOML encoded inventory of the hosts of the inventory and ssh platforms")
 var debug = flag.Bool("debug", false, "debug flag")
+var logSink = flag.String("logsink", "", "address reachable by the nodes to stream their logs to - empty disables log streaming")
+var bundleFlag = flag.Bool("bundle", false, "bundle the config, registry and results at the end of the simulation")
+var verifyBundle = flag.String("verify-bundle", "", "verify the manifest of the given bundle and exit")
+var rerunFailedFlag = flag.String("rerun-failed", "", "results csv file of a previous sweep whose failed runs are run again - requires the threshold_met and aborted columns")
+var rerunFactor = flag.Int("rerun-factor", 2, "factor applied to the retrials of the runs of -rerun-failed")
+var reserveFlag = flag.String("reserve", "", "provision the instances of -awsConfig, write their reservation to the given file and exit")
+var useReservation = flag.String("use-reservation", "", "reservation file of the instances the aws platform runs on, kept up after the simulation")
+var releaseFlag = flag.String("release", "", "stop the instances of the given reservation file, remove it and exit")
 
 func main() {
 	flag.Parse()
+	if *verifyBundle != "" {
+		manifest, err := bundle.Verify(*verifyBundle)
+		if err != nil {
+			fmt.Println("[-] invalid bundle:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] bundle valid: %d files\n", len(manifest.Files))
+		return
+	}
+	if *reserveFlag != "" {
+		r, err := platform.ReserveAws(*awsConfigPath, *reserveFlag)
+		if err != nil {
+			fmt.Println("[-] reservation:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] %d instances reserved in %s\n", len(r.Instances), *reserveFlag)
+		return
+	}
+	if *releaseFlag != "" {
+		if err := platform.ReleaseAws(*awsConfigPath, *releaseFlag); err != nil {
+			fmt.Println("[-] release:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] reservation %s released\n", *releaseFlag)
+		return
+	}
 
 	c := lib.LoadConfig(*configFlag)
 	if c.Debug == 0 && *debug {
 		// cmd line override config
 		c.Debug = 1
 	}
-	plat := platform.NewPlatform(*platformFlag, *awsConfigPath)
+	if *logSink != "" {
+		sink := startLogSink(c, *logSink)
+		defer sink.Stop()
+	}
+	if *rerunFailedFlag != "" {
+		err := rerunFailed(c, *rerunFailedFlag, *rerunFactor, func(c *lib.Config, runs []int) {
+			plat := newPlatform()
+			if err := plat.Configure(c); err != nil {
+				panic(err)
+			}
+			defer plat.Cleanup()
+			timeout := *runTimeout * time.Duration(c.Retrials)
+			for _, run := range runs {
+				startRun(c, run, plat, timeout)
+			}
+		})
+		if err != nil {
+			fmt.Println("[-] rerun of the failed runs:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] failed runs merged in %s\n", *rerunFailedFlag)
+		return
+	}
+	c.ResolveSeeds()
+
+	plat := newPlatform()
 	if err := plat.Configure(c); err != nil {
 		panic(err)
 	}
@@ -45,6 +112,79 @@ func main() {
 	}
 
 	fmt.Println("[+] simulation finished")
+
+	if *bundleFlag {
+		path, err := writeBundle(c, plat)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("[+] Results bundled in %s\n", path)
+	}
+}
+
+// newPlatform returns the platform of the flags, on the instances of the
+// reservation if any
+func newPlatform() platform.Platform {
+	plat := platform.NewPlatform(*platformFlag, *awsConfigPath, *inventoryPath)
+	if *useReservation != "" {
+		if err := platform.UseReservation(plat, *useReservation); err != nil {
+			panic(err)
+		}
+	}
+	return plat
+}
+
+// writeBundle bundles the config, the registry without private keys, the
+// results and the logs of the nodes under the results directory.
+func writeBundle(c *lib.Config, plat platform.Platform) (string, error) {
+	b := bundle.NewBundler()
+	// write the config as expanded by the platforms
+	tmp, err := ioutil.TempFile("", "bundle-config")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+	if err := c.WriteTo(tmp.Name()); err != nil {
+		return "", err
+	}
+	if err := b.Add("config.toml", tmp.Name()); err != nil {
+		return "", err
+	}
+	if err := b.Add(c.GetCSVFile(), c.GetResultsFile()); err != nil {
+		return "", err
+	}
+	if err := b.AddDir("logs/", filepath.Join(c.GetResultsDir(), "logs")); err != nil {
+		return "", err
+	}
+	if a, ok := plat.(platform.Artifacts); ok {
+		if err := b.AddRegistry("registry.csv", a.RegistryPath()); err != nil {
+			return "", err
+		}
+		if sum, err := bundle.FileChecksum(a.BinaryPath()); err == nil {
+			b.AddBytes("node.sha256", []byte(sum+"\n"))
+		}
+	}
+	name := strings.Replace(c.GetCSVFile(), ".csv", "-bundle.tar.gz", 1)
+	path := filepath.Join(c.GetResultsDir(), name)
+	return path, b.WriteTo(path)
+}
+
+// startLogSink listens on the port of the given address for the logs of the
+// nodes and writes them under the results directory.
+func startLogSink(c *lib.Config, addr string) *logs.Sink {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		panic(err)
+	}
+	dir := filepath.Join(c.GetResultsDir(), "logs")
+	sink, err := logs.NewSink(net.JoinHostPort("0.0.0.0", port), dir, os.Stdout)
+	if err != nil {
+		panic(err)
+	}
+	c.LogSink = addr
+	fmt.Printf("[+] Log sink listening on %s, writing to %s\n", sink.Addr(), dir)
+	return sink
 }
 
 func startRun(c *lib.Config, run int, p platform.Platform, t time.Duration) {
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ConsenSys/handel/simul/bundle"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/monitor"
 	"github.com/ConsenSys/handel/simul/platform"
 	"github.com/stretchr/testify/require"
 )
@@ -17,7 +25,7 @@ import (
 func TestMainLocalHost(t *testing.T) {
 	resultsDir := "results"
 	baseDir := "tests"
-	configs := []string{"handel", "udp"}
+	configs := []string{"handel", "udp", "ws"}
 	//configs := []string{"gossip"}
 
 	for _, c := range configs {
@@ -58,3 +66,313 @@ func TestMainLocalHost(t *testing.T) {
 
 	}
 }
+
+// This test runs a simulation where 20% of the nodes start late and 10% stop
+// early, and checks the churned ids are written in the CSV file.
+func TestMainLocalHostChurn(t *testing.T) {
+	configName := "churn"
+	fullPath := filepath.Join("tests", configName+".toml")
+	cmd := platform.NewCommand("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost")
+	chLine := cmd.LineOutput()
+	foundCh := make(chan bool, 1)
+	go func() {
+		found := false
+		for line := range chLine {
+			fmt.Println(line)
+			if strings.Contains(line, "success") {
+				found = true
+			}
+		}
+		foundCh <- found
+	}()
+	require.NoError(t, cmd.Cmd.Run())
+	select {
+	case out := <-foundCh:
+		require.True(t, out)
+	case <-time.After(1 * time.Minute):
+		t.Fatalf("timeout in simulation " + configName)
+	}
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+
+	conf := lib.LoadConfig(fullPath)
+	churn := conf.Runs[0].GetChurn(0)
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header := strings.Split(lines[0], ",")
+	values := strings.Split(lines[1], ",")
+	fields := make(map[string]string)
+	for i, h := range header {
+		fields[h] = values[i]
+	}
+	require.Equal(t, churn.Stats()["lateStart"], fields["lateStart"])
+	require.Equal(t, churn.Stats()["earlyStop"], fields["earlyStop"])
+	require.Len(t, strings.Split(fields["lateStart"], "-"), 8)
+	require.Len(t, strings.Split(fields["earlyStop"], "-"), 4)
+}
+
+// This test runs a sweep of two runs using different curves and checks each
+// run is written in the CSV file with its curve.
+func TestMainLocalHostCurves(t *testing.T) {
+	configName := "curves"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 3)
+	header := strings.Split(lines[0], ",")
+	var curves []string
+	for _, line := range lines[1:] {
+		values := strings.Split(line, ",")
+		for i, h := range header {
+			if h == "curve" {
+				curves = append(curves, values[i])
+			}
+		}
+	}
+	require.Equal(t, []string{"bn256", "fake"}, curves)
+}
+
+// This test runs a simulation whose threshold is a percentage of the nodes
+// and checks the CSV file has the resolved threshold and its fraction.
+func TestMainLocalHostThresholdPercent(t *testing.T) {
+	configName := "percent"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header := strings.Split(lines[0], ",")
+	values := strings.Split(lines[1], ",")
+	fields := make(map[string]string)
+	for i, h := range header {
+		fields[h] = values[i]
+	}
+	require.Equal(t, "24", fields["threshold"])
+	require.Equal(t, "0.75", fields["threshold_frac"])
+}
+
+// This test runs the localhost simulation with bundling enabled, verifies the
+// bundle and checks a corrupted bundle is rejected.
+func TestMainLocalHostBundle(t *testing.T) {
+	fullPath := filepath.Join("tests", "udp.toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost",
+		"-bundle").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+
+	path := filepath.Join("results", "udp-bundle.tar.gz")
+	manifest, err := bundle.Verify(path)
+	require.NoError(t, err)
+	require.Contains(t, manifest.Files, "registry.csv")
+	require.Contains(t, manifest.Files, "udp.csv")
+	require.Contains(t, manifest.Files, "config.toml")
+
+	// the registry is bundled without the private keys
+	registry, err := ioutil.ReadFile("/tmp/local.csv")
+	require.NoError(t, err)
+	content := bundleContent(t, path)
+	for _, line := range strings.Split(strings.TrimSpace(string(registry)), "\n") {
+		private := strings.Split(line, ",")[2]
+		require.NotContains(t, content["registry.csv"], private)
+	}
+
+	out, err = exec.Command("go", "run", "main.go", "-verify-bundle", path).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	// corrupt the results and check the verification names the file
+	corrupted := filepath.Join("results", "udp-corrupted.tar.gz")
+	defer os.Remove(corrupted)
+	content["udp.csv"] += "0,0,0\n"
+	writeBundleContent(t, corrupted, content)
+	out, err = exec.Command("go", "run", "main.go", "-verify-bundle", corrupted).CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(out), "udp.csv")
+}
+
+// bundleContent returns the content of all the files of the given tar.gz
+func bundleContent(t *testing.T, path string) map[string]string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	content := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return content
+		}
+		require.NoError(t, err)
+		buff, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		content[hdr.Name] = string(buff)
+	}
+}
+
+// writeBundleContent writes the given files as a tar.gz
+func writeBundleContent(t *testing.T, path string, content map[string]string) {
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for name, c := range content {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(c))}))
+		_, err := tw.Write([]byte(c))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestMainLocalHostPortPerLevel(t *testing.T) {
+	configName := "portperlevel"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header, values := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	column := func(name string) string {
+		for i, h := range header {
+			if h == name {
+				return values[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	// the levels are sent on their own port, and each port only receives its
+	// level
+	require.NotEqual(t, "0", column("net_port1_rcvd_sum"))
+	require.Equal(t, "0", column("net_level_mismatch_sum"))
+}
+
+func TestMainLocalHostEncrypted(t *testing.T) {
+	configName := "encrypted"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header, values := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	column := func(name string) string {
+		for i, h := range header {
+			if h == name {
+				return values[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	// all the packets received decrypt
+	require.NotEqual(t, "0", column("net_encrypted_rcvd_sum"))
+	require.Equal(t, "0", column("net_encrypted_rejected_sum"))
+}
+
+func TestMainLocalHostBandwidth(t *testing.T) {
+	configName := "bandwidth"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header, values := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	column := func(name string) string {
+		for i, h := range header {
+			if h == name {
+				return values[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	require.Equal(t, "500", column("uploadKbps"))
+	require.Len(t, strings.Split(column("slowUpload"), "-"), 8)
+	// the slow nodes delayed some of their packets
+	require.NotEqual(t, "0", column("net_shaped_delayed_sum"))
+}
+
+// This test runs a simulation whose nodes run the aggregation three times and
+// checks each node measured each repetition, in a single row of results.
+func TestMainLocalHostRepetitions(t *testing.T) {
+	configName := "repetitions"
+	fullPath := filepath.Join("tests", configName+".toml")
+	out, err := exec.Command("go", "run", "main.go",
+		"-config", fullPath,
+		"-platform", "localhost").CombinedOutput()
+	require.NoError(t, err, string(out))
+	defer exec.Command("pkill", "-9", "local.bin").Run()
+	require.Contains(t, string(out), "success")
+
+	csv, err := ioutil.ReadFile(filepath.Join("results", configName+".csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lines, 2)
+	header, values := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	for i, h := range header {
+		if h == "repetitions" {
+			require.Equal(t, "3", values[i])
+		}
+	}
+
+	// the processes tag the measures of each repetition, 8 nodes each
+	reps := make(map[string]map[int]int)
+	_, _, err = monitor.ReadRaw(monitor.RawPaths("results", 0), func(r *monitor.RawRecord) error {
+		if r.Name != "sigen_wall" {
+			return nil
+		}
+		if reps[r.Node] == nil {
+			reps[r.Node] = make(map[int]int)
+		}
+		reps[r.Node][r.Rep]++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reps, 2)
+	for _, counts := range reps {
+		require.Equal(t, map[int]int{1: 8, 2: 8, 3: 8}, counts)
+	}
+}
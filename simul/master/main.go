@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ConsenSys/handel/simul/dashboard"
 	"github.com/ConsenSys/handel/simul/lib"
 	"github.com/ConsenSys/handel/simul/monitor"
 )
@@ -23,6 +25,8 @@ var run = flag.Int("run", 0, "run index")
 
 var resultFile = flag.String("resultFile", "", "result file")
 var monitorPort = flag.Int("monitorPort", 0, "monitor port")
+var dashboardFlag = flag.Bool("dashboard", false, "render a live dashboard of the run when stdout is a terminal")
+var recoverFlag = flag.Bool("recover", false, "resume the run from the checkpoint of the monitor of a crashed master")
 
 var resultsDir string
 
@@ -40,6 +44,7 @@ func main() {
 	nbOfNodes := runConf.Nodes
 	//nbOffline := runConf.Failing
 	master := lib.NewSyncMaster(*masterAddr, nbOfNodes-runConf.Failing, nbOfNodes)
+	master.SetPolicy(config.NewReleasePolicy())
 	fmt.Println("Master: listen on", *masterAddr)
 
 	os.MkdirAll(resultsDir, 0777)
@@ -51,15 +56,50 @@ func main() {
 	}
 	defer csvFile.Close()
 
-	stats := defaultStats(runConf,
-		*run,
-		*network,
-		runConf.Handel.Period,
-		config.Simulation,
-	)
+	checkpoint := filepath.Join(resultsDir, fmt.Sprintf(".monitor-checkpoint-run%d.gob", *run))
+	var stats *monitor.Stats
+	if *recoverFlag {
+		stats, err = monitor.ReadCheckpoint(checkpoint)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("[+] Master recovered %d measurements from %s\n", stats.Received(), checkpoint)
+	} else {
+		stats = defaultStats(runConf,
+			*run,
+			*network,
+			runConf.Handel.Period,
+			config.Simulation,
+			config.GetCurve(&runConf),
+		)
+	}
+	stats.SetMaxSamples(config.MaxSamples)
 	mon := monitor.NewMonitor(10000, stats)
+	if period := config.GetCheckpointPeriod(); period > 0 {
+		mon.SetCheckpoint(checkpoint, period)
+	}
+	if config.RawDump {
+		raw, err := monitor.NewRawWriter(resultsDir, *run, stats, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer raw.Close()
+		mon.SetRawDump(raw)
+	}
 	go mon.Listen()
 
+	// stops the dashboard before printing the final results
+	stopDashboard := func() {}
+	if *dashboardFlag {
+		if dashboard.IsTerminal(os.Stdout) {
+			// the dashboard shows the progress of the barriers instead
+			master.SetOutput(ioutil.Discard)
+			stopDashboard = startDashboard(master, stats, strings.Contains(config.Simulation, "libp2p"))
+		} else {
+			fmt.Println("[-] stdout is not a terminal, dashboard disabled")
+		}
+	}
+
 	if strings.Contains(config.Simulation, "libp2p") {
 		fmt.Println(" MASTER --->> SYNCING P2P ")
 		select {
@@ -73,13 +113,28 @@ func main() {
 		fmt.Println(" MASTER --->> SYNCING P2P DONE ")
 	}
 
-	select {
-	case <-master.WaitAll(lib.START):
-		fmt.Printf("[+] Master full synchronization done.\n")
+	// the nodes passed the START barrier already when the master recovers,
+	// while they signal END until the master answers - the barriers between
+	// the repetitions are released as they arrive all the same
+	if !*recoverFlag {
+		select {
+		case <-master.WaitAll(lib.START):
+			fmt.Printf("[+] Master full synchronization done.\n")
 
-	case <-time.After(time.Duration(*timeOut) * time.Minute):
-		msg := fmt.Sprintf("timeout after %d mn", *timeOut)
-		fmt.Println(msg)
+		case <-time.After(time.Duration(*timeOut) * time.Minute):
+			msg := fmt.Sprintf("timeout after %d mn", *timeOut)
+			fmt.Println(msg)
+		}
+
+		// the nodes of a repeated run synchronize between the repetitions
+		for rep := 0; rep < runConf.GetRepetitions()-1; rep++ {
+			select {
+			case <-master.WaitAll(lib.RepetitionState(rep)):
+				fmt.Printf("[+] Master - repetition %d synchronization done.\n", rep+1)
+			case <-time.After(time.Duration(*timeOut) * time.Minute):
+				fmt.Printf("timeout after %d mn\n", *timeOut)
+			}
+		}
 	}
 
 	select {
@@ -89,6 +144,19 @@ func main() {
 		msg := fmt.Sprintf("timeout after %d sec", 25)
 		fmt.Println(msg)
 	}
+	stopDashboard()
+	// the master does not know the offline ids, they are part of the missing
+	for k, v := range master.Stats(nil) {
+		stats.SetStatic(k, v)
+	}
+	if o := config.Optimum; o != nil {
+		optimum, err := lib.OptimumFromStats(stats, time.Duration(o.RTT), o.Loss)
+		if err != nil {
+			fmt.Println("[-] optimum:", err)
+		} else {
+			lib.AddOptimum(stats, optimum, o.SlowFactor)
+		}
+	}
 
 	fmt.Println("Writting to", csvName)
 
@@ -98,21 +166,64 @@ func main() {
 	stats.WriteValues(csvFile)
 	fmt.Printf("[+] -- MASTER monitor received %d measurements --\n", stats.Received())
 	mon.Stop()
+	// the row is written, a restarted master must not write it again
+	os.Remove(checkpoint)
 }
 
-func defaultStats(runConf lib.RunConfig, run int, network, period, simulation string) *monitor.Stats {
-	return monitor.NewStats(map[string]string{
+// startDashboard renders the dashboard every second until the returned
+// function is called.
+func startDashboard(master *lib.SyncMaster, stats *monitor.Stats, p2p bool) func() {
+	barriers := func() []dashboard.Barrier {
+		var bs []dashboard.Barrier
+		states := []int{lib.START, lib.END}
+		names := []string{"start", "end"}
+		if p2p {
+			states = append([]int{lib.P2P}, states...)
+			names = append([]string{"p2p"}, names...)
+		}
+		for i, state := range states {
+			ready, expected := master.Status(state)
+			bs = append(bs, dashboard.Barrier{Name: names[i], Ready: ready, Expected: expected})
+		}
+		return bs
+	}
+	stop := make(chan bool)
+	done := make(chan bool)
+	builder := dashboard.NewBuilder(stats, barriers, time.Now())
+	go func() {
+		dashboard.Run(os.Stdout, builder, time.Second, stop)
+		close(done)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func defaultStats(runConf lib.RunConfig, run int, network, period, simulation, curve string) *monitor.Stats {
+	defaults := map[string]string{
 		"run":                        strconv.Itoa(run),
 		"totalNbOfNodes":             strconv.Itoa(runConf.Nodes),
 		"nbOfInstances":              strconv.Itoa(runConf.Processes),
-		"threshold":                  strconv.Itoa(runConf.Threshold),
+		"threshold":                  strconv.Itoa(runConf.GetThreshold()),
+		"threshold_frac":             strconv.FormatFloat(runConf.ThresholdFraction(), 'f', -1, 64),
 		"failing":                    strconv.Itoa(runConf.Failing),
 		"network":                    network,
 		"period":                     runConf.Handel.Period,
 		"updateCount":                strconv.Itoa(runConf.Handel.UpdateCount),
 		"simulation":                 simulation,
+		"curve":                      curve,
 		"UnsafeSleepTimeOnSigVerify": strconv.Itoa(runConf.Handel.UnsafeSleepTimeOnSigVerify),
 		"NodeCount":                  strconv.Itoa(runConf.Handel.NodeCount),
 		"timeout":                    runConf.Handel.Timeout,
-	}, nil)
+		"repetitions":                strconv.Itoa(runConf.GetRepetitions()),
+		"protocol":                   runConf.GetProtocol(),
+	}
+	for k, v := range runConf.GetChurn(run).Stats() {
+		defaults[k] = v
+	}
+	for k, v := range runConf.SeedStats() {
+		defaults[k] = v
+	}
+	return monitor.NewStats(defaults, nil)
 }
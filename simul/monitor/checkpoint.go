@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// statsCheckpoint is the state of a Stats written by Checkpoint
+type statsCheckpoint struct {
+	Static     map[string]string
+	StaticKeys []string
+	Values     []valueCheckpoint
+	Received   int
+	MaxSamples int
+	Seen       map[string][]uint64
+	Duplicates int
+}
+
+// valueCheckpoint is the state of a Value written by Checkpoint
+type valueCheckpoint struct {
+	Name                string
+	Min, Max, Sum, Mean float64
+	M2                  float64
+	N                   int
+	Store               []float64
+	MaxSamples          int
+}
+
+// Checkpoint writes the state of the stats to w, so LoadStats restores them,
+// e.g. on a master restarted in the middle of a run. The filter is not part
+// of the state. Past the maximum number of samples, the samples stored after
+// the restore are sampled with a fresh random source, so they may differ from
+// the ones of stats never restored.
+func (s *Stats) Checkpoint(w io.Writer) error {
+	s.Lock()
+	c := &statsCheckpoint{
+		Static:     make(map[string]string, len(s.static)),
+		StaticKeys: append([]string{}, s.staticKeys...),
+		Received:   s.rcvd,
+		MaxSamples: s.maxSamples,
+		Seen:       make(map[string][]uint64, len(s.seen)),
+		Duplicates: s.duplicates,
+	}
+	for k, v := range s.static {
+		c.Static[k] = v
+	}
+	for node, bits := range s.seen {
+		c.Seen[node] = append([]uint64{}, bits...)
+	}
+	for _, k := range s.keys {
+		v := s.values[k]
+		v.Lock()
+		c.Values = append(c.Values, valueCheckpoint{
+			Name:       v.name,
+			Min:        v.min,
+			Max:        v.max,
+			Sum:        v.sum,
+			Mean:       v.mean,
+			M2:         v.m2,
+			N:          v.n,
+			Store:      append([]float64{}, v.store...),
+			MaxSamples: v.maxSamples,
+		})
+		v.Unlock()
+	}
+	s.Unlock()
+	return gob.NewEncoder(w).Encode(c)
+}
+
+// LoadStats returns the stats whose state Checkpoint wrote to r
+func LoadStats(r io.Reader) (*Stats, error) {
+	var c statsCheckpoint
+	if err := gob.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("monitor: invalid checkpoint: %v", err)
+	}
+	s := new(Stats).init()
+	for k, v := range c.Static {
+		s.static[k] = v
+	}
+	s.staticKeys = append(s.staticKeys, c.StaticKeys...)
+	s.rcvd = c.Received
+	s.maxSamples = c.MaxSamples
+	s.duplicates = c.Duplicates
+	for node, bits := range c.Seen {
+		s.seen[node] = bits
+	}
+	for _, vc := range c.Values {
+		v := NewValue(vc.Name)
+		v.min, v.max, v.sum, v.mean, v.m2, v.n = vc.Min, vc.Max, vc.Sum, vc.Mean, vc.M2, vc.N
+		v.store = append(v.store, vc.Store...)
+		v.maxSamples = vc.MaxSamples
+		s.values[vc.Name] = v
+		s.keys = append(s.keys, vc.Name)
+	}
+	return s, nil
+}
+
+// WriteCheckpoint writes the state of the stats to the file at path. It
+// writes a temporary file first, renamed once complete, so the file always
+// holds a complete checkpoint even if the process is killed meanwhile.
+func (s *Stats) WriteCheckpoint(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := s.Checkpoint(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadCheckpoint returns the stats written to the file at path by
+// WriteCheckpoint
+func ReadCheckpoint(path string) (*Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadStats(f)
+}
+
+// checkpointLoop writes the checkpoint of the stats every period until the
+// monitor stops
+func (m *Monitor) checkpointLoop(path string, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.Lock()
+			waiters := m.checkpoints
+			m.checkpoints = nil
+			m.Unlock()
+			if err := m.stats.WriteCheckpoint(path); err != nil {
+				log.Error("Error writing the monitor checkpoint:", err)
+				m.Lock()
+				m.checkpoints = append(m.checkpoints, waiters...)
+				m.Unlock()
+				continue
+			}
+			for _, ch := range waiters {
+				close(ch)
+			}
+		}
+	}
+}
+
+// Checkpointed returns a channel closed once the monitor wrote a checkpoint
+// begun after the call, i.e. holding all the measures ingested before.
+func (m *Monitor) Checkpointed() <-chan struct{} {
+	m.Lock()
+	defer m.Unlock()
+	ch := make(chan struct{})
+	m.checkpoints = append(m.checkpoints, ch)
+	return ch
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seqMeasure returns the measure of the given sequence number of a node
+func seqMeasure(node string, seq int) *singleMeasure {
+	m := newSingleMeasure("round", float64(seq%7)*1.5)
+	if seq%3 == 0 {
+		m.Name = "setup"
+	}
+	m.Node = node
+	m.Seq = uint64(seq)
+	return m
+}
+
+// sendSeqs sends the measures of the sequence numbers [from, to] to the
+// monitor listening on port
+func sendSeqs(t *testing.T, port int, from, to int) {
+	conn, err := net.Dial("udp4", "localhost:"+strconv.Itoa(port))
+	require.NoError(t, err)
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	for i := from; i <= to; i++ {
+		require.NoError(t, enc.Encode(seqMeasure("node1", i)))
+	}
+}
+
+func waitFlushed(t *testing.T, mon *Monitor, n int) {
+	select {
+	case <-mon.Flushed(n):
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%d measures not received", n)
+	}
+}
+
+func TestMonitorCheckpointRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "monitor.gob")
+	defs := map[string]string{"nodes": "10"}
+
+	control := NewStats(defs, nil)
+	for i := 1; i <= 100; i++ {
+		control.Update(seqMeasure("node1", i))
+	}
+
+	port := DefaultSinkPort + 2
+	mon := NewMonitor(port, NewStats(defs, nil))
+	mon.SetCheckpoint(path, 5*time.Millisecond)
+	go mon.Listen()
+	<-mon.Listening()
+	sendSeqs(t, port, 1, 50)
+	waitFlushed(t, mon, 50)
+	// waits for a checkpoint of all the measures received, then kills the
+	// monitor
+	select {
+	case <-mon.Checkpointed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no checkpoint written")
+	}
+	mon.Stop()
+
+	recovered, err := ReadCheckpoint(path)
+	require.NoError(t, err)
+	port++
+	mon = NewMonitor(port, recovered)
+	defer mon.Stop()
+	go mon.Listen()
+	<-mon.Listening()
+	// the sender sends again the measures it is not sure were received
+	sendSeqs(t, port, 31, 100)
+	waitFlushed(t, mon, 70)
+
+	require.Equal(t, 20, recovered.Duplicates())
+	require.Equal(t, 100, recovered.Received())
+	require.Equal(t, string(statsOutput(control)), string(statsOutput(recovered)))
+}
+
+func TestStatsCheckpoint(t *testing.T) {
+	s := NewStats(map[string]string{"nodes": "10", "run": "1"}, nil)
+	s.SetMaxSamples(4)
+	for i := 1; i <= 20; i++ {
+		s.Update(seqMeasure("node1", i))
+		s.Update(seqMeasure("node2", i))
+	}
+	s.Update(seqMeasure("node1", 3))
+	s.Store("untagged", 2)
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "monitor.gob")
+	require.NoError(t, ioutil.WriteFile(path, []byte("stale"), 0644))
+	require.NoError(t, s.WriteCheckpoint(path))
+	_, err = os.Stat(path + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	loaded, err := ReadCheckpoint(path)
+	require.NoError(t, err)
+	require.Equal(t, s.Received(), loaded.Received())
+	require.Equal(t, 1, loaded.Duplicates())
+	require.Equal(t, string(statsOutput(s)), string(statsOutput(loaded)))
+	// the sequence numbers received are restored
+	loaded.Update(seqMeasure("node2", 20))
+	require.Equal(t, 2, loaded.Duplicates())
+	loaded.Update(seqMeasure("node2", 21))
+	require.Equal(t, s.Received()+1, loaded.Received())
+
+	require.Error(t, s.WriteCheckpoint(filepath.Join(dir, "missing", "monitor.gob")))
+	_, err = LoadStats(strings.NewReader("garbage"))
+	require.Error(t, err)
+}
@@ -13,7 +13,7 @@ import (
 )
 
 var global struct {
-	// Sink is the server address where all measures are transmitted to for
+	// sink is the server address where all measures are transmitted to for
 	// further analysis.
 	sink string
 
@@ -21,6 +21,13 @@ var global struct {
 	encoder *json.Encoder
 	conn    *net.UDPConn
 
+	// node tags the measures sent to the sink, see SetNodeTag
+	node string
+	// repetition of the run tagging the measures, see SetRepetitionTag
+	rep int
+	// sequence number of the last measure sent
+	seq uint64
+
 	sync.Mutex
 }
 
@@ -47,6 +54,14 @@ type Measure interface {
 type singleMeasure struct {
 	Name  string
 	Value float64
+	// Node is the tag of the sender, if any
+	Node string `json:",omitempty"`
+	// Rep is the repetition of the run the measure belongs to, from 1, if
+	// any - see SetRepetitionTag
+	Rep int `json:",omitempty"`
+	// Seq is the sequence number of the measure from its sender, from 1, so
+	// the monitor stores a measure received twice once - see Stats.Update
+	Seq uint64 `json:",omitempty"`
 }
 
 // TimeMeasure represents a measure regarding time: It includes the wallclock
@@ -63,8 +78,9 @@ type TimeMeasure struct {
 }
 
 // ConnectSink connects to the given endpoint and initialises a json
-// encoder. It can be the address of a proxy or a monitoring process.
-// Returns an error if it could not connect to the endpoint.
+// encoder. It can be the address of a proxy or a monitoring process. The
+// measures are then sent to this endpoint, unless another sink is set with
+// SetSink. Returns an error if it could not connect to the endpoint.
 func ConnectSink(addr string) error {
 	global.Lock()
 	defer global.Unlock()
@@ -84,9 +100,29 @@ func ConnectSink(addr string) error {
 	global.sink = addr
 	global.conn = conn
 	global.encoder = json.NewEncoder(conn)
+	SetSink(nil)
 	return nil
 }
 
+// SetNodeTag tags all the measures sent to the sink opened by ConnectSink with
+// the given string, so a monitor dumping the raw measures can tell the nodes
+// apart. Empty removes the tag.
+func SetNodeTag(tag string) {
+	global.Lock()
+	defer global.Unlock()
+	global.node = tag
+}
+
+// SetRepetitionTag tags all the measures sent to the sink opened by
+// ConnectSink with the given repetition of the run, from 1, so the measures of
+// a node running the aggregation several times can be told apart in the raw
+// dump. The stats aggregate the repetitions alike. 0 removes the tag.
+func SetRepetitionTag(rep int) {
+	global.Lock()
+	defer global.Unlock()
+	global.rep = rep
+}
+
 // RecordSingleMeasure sends the pair name - value to the monitor directly.
 func RecordSingleMeasure(name string, value float64) {
 	sm := newSingleMeasure(name, value)
@@ -101,7 +137,7 @@ func newSingleMeasure(name string, value float64) *singleMeasure {
 }
 
 func (s *singleMeasure) Record() {
-	if err := send(s); err != nil {
+	if err := currentSink().Record(s.Name, s.Value); err != nil {
 		log.Error("Error sending SingleMeasure", s.Name, " to monitor:", err)
 	}
 }
@@ -215,6 +251,9 @@ func send(v interface{}) error {
 func EndAndCleanup() {
 	global.Lock()
 	defer global.Unlock()
+	if global.conn == nil {
+		return
+	}
 	if err := global.conn.Close(); err != nil {
 		// at least tell that we could not close the connection:
 		log.Error("Could not close connection:", err)
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
-	"time"
 )
 
 type DummyCounter struct {
@@ -22,8 +21,8 @@ func (dm *DummyCounter) Values() map[string]float64 {
 }
 
 func TestCounterMeasureRecord(t *testing.T) {
-	mon, _ := setupMonitor(t)
-	defer mon.Stop()
+	stat := setupLocalSink()
+	defer SetSink(nil)
 	dm := &DummyCounter{0, 0}
 	// create the counter measure
 	cm := NewCounterMeasure("dummy", dm)
@@ -38,10 +37,7 @@ func TestCounterMeasureRecord(t *testing.T) {
 		t.Fatal("Record() not working for CounterIOMeasure")
 	}
 
-	// Important otherwise data don't get written down to the monitor yet.
-	time.Sleep(100 * time.Millisecond)
 	str := new(bytes.Buffer)
-	stat := mon.stats
 	stat.Collect()
 	stat.WriteHeader(str)
 	stat.WriteValues(str)
@@ -59,6 +55,4 @@ func TestCounterMeasureRecord(t *testing.T) {
 	if re == nil || re.Avg() != 10 {
 		t.Fatal("Stats doesn't have the right value (read)")
 	}
-	EndAndCleanup()
-	time.Sleep(100 * time.Millisecond)
 }
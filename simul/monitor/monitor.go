@@ -20,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.dedis.ch/onet/v3/log"
 )
@@ -27,9 +28,9 @@ import (
 // This file handles the collection of measurements, aggregates them and
 // write CSV file reports
 
-// Sink is the address where to listen for the monitor. The endpoint can be a
-// monitor.Proxy or a direct connection with measure.go
-const Sink = "0.0.0.0"
+// SinkAddress is the address where to listen for the monitor. The endpoint can
+// be a monitor.Proxy or a direct connection with measure.go
+const SinkAddress = "0.0.0.0"
 
 // DefaultSinkPort is the default port where a monitor will listen and a proxy
 // will contact the monitor.
@@ -55,15 +56,37 @@ type Monitor struct {
 
 	sinkPort     uint16
 	sinkPortChan chan uint16
+
+	// raw dump of the measures, nil if disabled
+	raw *RawWriter
+	// file where the stats are checkpointed every checkpointPeriod, empty
+	// if disabled
+	checkpoint       string
+	checkpointPeriod time.Duration
+	// the waiters of Checkpointed
+	checkpoints []chan struct{}
+
+	// closed once the monitor is bound to its address
+	listening chan struct{}
+	// number of measures ingested, and the waiters of Flushed
+	ingested int
+	flushes  []flushWaiter
+}
+
+// flushWaiter is closed once n measures are ingested
+type flushWaiter struct {
+	n  int
+	ch chan struct{}
 }
 
 // NewDefaultMonitor returns a new monitor given the stats
 func NewDefaultMonitor(stats *Stats) *Monitor {
 	return &Monitor{
-		stats:    stats,
-		sinkPort: DefaultSinkPort,
-		measures: make(chan *singleMeasure),
-		done:     make(chan string),
+		stats:     stats,
+		sinkPort:  DefaultSinkPort,
+		measures:  make(chan *singleMeasure),
+		done:      make(chan string),
+		listening: make(chan struct{}),
 	}
 }
 
@@ -74,11 +97,30 @@ func NewMonitor(port int, stats *Stats) *Monitor {
 	return m
 }
 
+// SetRawDump makes the monitor write every measure it receives to the given
+// raw dump, on top of updating its stats. It must be called before Listen.
+// The dump is not closed by the monitor.
+func (m *Monitor) SetRawDump(w *RawWriter) {
+	m.Lock()
+	defer m.Unlock()
+	m.raw = w
+}
+
+// SetCheckpoint makes the monitor write the checkpoint of its stats to the
+// file at path every period while it listens, see Stats.WriteCheckpoint. It
+// must be called before Listen.
+func (m *Monitor) SetCheckpoint(path string, period time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.checkpoint = path
+	m.checkpointPeriod = period
+}
+
 // Listen will start listening for incoming connections on this address
 // It needs the stats struct pointer to update when measures come
 // Return an error if something went wrong during the connection setup
 func (m *Monitor) Listen() error {
-	addr := net.JoinHostPort(Sink, strconv.Itoa(int(m.sinkPort)))
+	addr := net.JoinHostPort(SinkAddress, strconv.Itoa(int(m.sinkPort)))
 	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
 	if err != nil {
 		return err
@@ -89,13 +131,38 @@ func (m *Monitor) Listen() error {
 	}
 	m.Lock()
 	m.sock = udpSock
+	checkpoint, period := m.checkpoint, m.checkpointPeriod
 	m.Unlock()
+	close(m.listening)
 	go m.handleConnection()
-	log.Lvl2("Monitor listening for stats on", Sink, ":", m.sinkPort)
+	if checkpoint != "" && period > 0 {
+		go m.checkpointLoop(checkpoint, period)
+	}
+	log.Lvl2("Monitor listening for stats on", SinkAddress, ":", m.sinkPort)
 	<-m.done
 	return nil
 }
 
+// Listening returns a channel closed once the monitor is bound to its
+// address, so the measures sent from then on are received.
+func (m *Monitor) Listening() <-chan struct{} {
+	return m.listening
+}
+
+// Flushed returns a channel closed once the monitor ingested n measures in
+// total, i.e. updated its stats and its raw dump with them.
+func (m *Monitor) Flushed(n int) <-chan struct{} {
+	m.Lock()
+	defer m.Unlock()
+	ch := make(chan struct{})
+	if m.ingested >= n {
+		close(ch)
+		return ch
+	}
+	m.flushes = append(m.flushes, flushWaiter{n, ch})
+	return ch
+}
+
 // Stop will close every connections it has
 // And will stop updating the stats
 func (m *Monitor) Stop() {
@@ -153,4 +220,25 @@ func (m *Monitor) handleConnection() {
 func (m *Monitor) update(meas *singleMeasure) {
 	// updating
 	m.stats.Update(meas)
+	m.Lock()
+	raw := m.raw
+	m.Unlock()
+	if raw != nil {
+		if err := raw.record(meas); err != nil {
+			log.Error("Error dumping measure", meas.Name, ":", err)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.ingested++
+	waiting := m.flushes[:0]
+	for _, w := range m.flushes {
+		if m.ingested >= w.n {
+			close(w.ch)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	m.flushes = waiting
 }
@@ -17,7 +17,7 @@ func TestMonitorReadyNormal(t *testing.T) {
 	mon := NewDefaultMonitor(stat)
 	defer mon.Stop()
 	go mon.Listen()
-	time.Sleep(100 * time.Millisecond)
+	<-mon.Listening()
 
 	// Then measure
 	err := ConnectSink("localhost:" + strconv.Itoa(DefaultSinkPort))
@@ -28,10 +28,13 @@ func TestMonitorReadyNormal(t *testing.T) {
 
 	meas := newSingleMeasure("round", 10)
 	meas.Record()
-	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-mon.Flushed(1):
+	case <-time.After(5 * time.Second):
+		t.Fatal("measure not received")
+	}
 	newSingleMeasure("round", 20)
 	EndAndCleanup()
-	time.Sleep(100 * time.Millisecond)
 	updated := mon.stats.String()
 	if updated == fresh {
 		t.Fatal("Stats not updated ?")
@@ -65,21 +68,12 @@ func TestStatsKeyOrder(t *testing.T) {
 	}
 }
 
-// setupMonitor launches a basic monitor with a created Stats object
-// When finished with the monitor, just call `End()`
-func setupMonitor(t *testing.T) (*Monitor, *Stats) {
+// setupLocalSink records the measures into a fresh Stats object. When
+// finished, just call `SetSink(nil)`.
+func setupLocalSink() *Stats {
 	m := make(map[string]string)
 	m["servers"] = "1"
 	stat := NewStats(m, nil)
-	// First set up monitor listening
-	mon := NewDefaultMonitor(stat)
-	go mon.Listen()
-	time.Sleep(100 * time.Millisecond)
-
-	// Then measure
-	err := ConnectSink("localhost:" + strconv.Itoa(int(mon.sinkPort)))
-	if err != nil {
-		t.Fatal(fmt.Sprintf("Error starting monitor: %s", err))
-	}
-	return mon, stat
+	SetSink(NewLocalSink(stat))
+	return stat
 }
@@ -0,0 +1,287 @@
+package monitor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The raw dump keeps every measure received by a Monitor so the statistics can
+// be recomputed offline, with other filters or per node. A dump is a set of
+// gzip compressed NDJSON files: each file starts with a header line holding
+// the static values of the Stats, followed by one line per measure.
+
+// DefaultRawMaxSize is the default size of the content of a raw dump file,
+// before compression, after which the dump continues in a new file. Bounding
+// the uncompressed size bounds the compressed size as well, without flushing
+// the compression.
+const DefaultRawMaxSize = 64 << 20
+
+// RawRecord is a measure as written in a raw dump
+type RawRecord struct {
+	Name  string
+	Value float64
+	// Node is the tag of the node which sent the measure, if any
+	Node string `json:",omitempty"`
+	// Rep is the repetition of the run the measure belongs to, from 1, if
+	// the run is repeated
+	Rep int `json:",omitempty"`
+	// Run is the index of the run
+	Run int
+	// Time is the unix time in nanoseconds at which the measure was received
+	Time int64
+}
+
+// rawHeader is the first line of each raw dump file
+type rawHeader struct {
+	Static map[string]string
+	Keys   []string
+}
+
+// RawWriter writes the raw measures of a run in a dump, rotating the files
+// once they reach the maximum size. It is safe for concurrent use.
+type RawWriter struct {
+	sync.Mutex
+	dir     string
+	run     int
+	maxSize int64
+	header  rawHeader
+	// current file
+	index int
+	file  *os.File
+	count *countingWriter
+	gz    *gzip.Writer
+	enc   *json.Encoder
+}
+
+// RawPath returns the path of the i-th file of the raw dump of the run in the
+// given directory: results/raw_run0.ndjson.gz, then raw_run0.1.ndjson.gz, etc.
+func RawPath(dir string, run, i int) string {
+	name := "raw_run" + strconv.Itoa(run)
+	if i > 0 {
+		name += "." + strconv.Itoa(i)
+	}
+	return filepath.Join(dir, name+".ndjson.gz")
+}
+
+// RawPaths returns the files of the raw dump of the run in the given
+// directory, in the order in which they were written.
+func RawPaths(dir string, run int) []string {
+	var paths []string
+	for i := 0; ; i++ {
+		path := RawPath(dir, run, i)
+		if _, err := os.Stat(path); err != nil {
+			return paths
+		}
+		paths = append(paths, path)
+	}
+}
+
+// NewRawWriter returns a writer dumping the measures of the run in the given
+// directory, with the static values of the stats in the header of each file.
+// A maxSize of 0 means DefaultRawMaxSize.
+func NewRawWriter(dir string, run int, stats *Stats, maxSize int64) (*RawWriter, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultRawMaxSize
+	}
+	stats.Lock()
+	header := rawHeader{Static: make(map[string]string), Keys: append([]string{}, stats.staticKeys...)}
+	for k, v := range stats.static {
+		header.Static[k] = v
+	}
+	stats.Unlock()
+	w := &RawWriter{dir: dir, run: run, maxSize: maxSize, header: header}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open creates the current file and writes its header
+func (w *RawWriter) open() error {
+	file, err := os.Create(RawPath(w.dir, w.run, w.index))
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.gz = gzip.NewWriter(file)
+	w.count = &countingWriter{w: w.gz}
+	w.enc = json.NewEncoder(w.count)
+	return w.enc.Encode(&w.header)
+}
+
+func (w *RawWriter) closeFile() error {
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// Write appends the measure to the dump
+func (w *RawWriter) Write(r *RawRecord) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("monitor: raw dump closed")
+	}
+	if w.count.n >= w.maxSize {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+		w.index++
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	return w.enc.Encode(r)
+}
+
+// record dumps the measure received by a monitor
+func (w *RawWriter) record(m *singleMeasure) error {
+	return w.Write(&RawRecord{
+		Name:  m.Name,
+		Value: m.Value,
+		Node:  m.Node,
+		Rep:   m.Rep,
+		Run:   w.run,
+		Time:  time.Now().UnixNano(),
+	})
+}
+
+// Close flushes and closes the current file of the dump
+func (w *RawWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.closeFile()
+	w.file = nil
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadRaw reads the raw dump files in the given order and calls fn for each
+// measure. It returns the static values found in the header of the first
+// file, in their original order.
+func ReadRaw(paths []string, fn func(*RawRecord) error) (map[string]string, []string, error) {
+	var header *rawHeader
+	for _, path := range paths {
+		h, err := readRawFile(path, fn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("monitor: %s: %s", path, err)
+		}
+		if header == nil {
+			header = h
+		}
+	}
+	if header == nil {
+		return nil, nil, fmt.Errorf("monitor: no raw dump file")
+	}
+	return header.Static, header.Keys, nil
+}
+
+func readRawFile(path string, fn func(*RawRecord) error) (*rawHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	header := new(rawHeader)
+	if err := dec.Decode(header); err != nil {
+		return nil, err
+	}
+	for {
+		r := new(RawRecord)
+		if err := dec.Decode(r); err == io.EOF {
+			return header, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if err := fn(r); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// StatsFromRaw rebuilds the Stats of a run from its raw dump files, with the
+// given filter, which may be nil. The keep function selects the measures to
+// take into account, nil keeps them all. The rebuilt Stats writes the same CSV
+// as the Stats of the monitor which made the dump, when no other filter and
+// selection are given.
+func StatsFromRaw(paths []string, df DataFilter, keep func(*RawRecord) bool) (*Stats, error) {
+	var records []*RawRecord
+	static, keys, err := ReadRaw(paths, func(r *RawRecord) error {
+		if keep == nil || keep(r) {
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := new(Stats).init()
+	if df != nil {
+		s.filter = df
+	}
+	// the static keys keep the order of the original stats
+	for _, k := range keys {
+		s.static[k] = static[k]
+		s.staticKeys = append(s.staticKeys, k)
+	}
+	for k, v := range static {
+		if _, ok := s.static[k]; !ok {
+			s.static[k] = v
+			s.staticKeys = append(s.staticKeys, k)
+		}
+	}
+	for _, r := range records {
+		s.Store(r.Name, r.Value)
+	}
+	return s, nil
+}
+
+// ParsePercentiles parses a list of percentiles "name=percentile,..." as used
+// by NewPercentileFilter.
+func ParsePercentiles(s string) (map[string]float64, error) {
+	percentiles := make(map[string]float64)
+	if s == "" {
+		return percentiles, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("monitor: invalid percentile %q", pair)
+		}
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("monitor: invalid percentile %q: %s", pair, err)
+		}
+		percentiles[parts[0]] = p
+	}
+	return percentiles, nil
+}
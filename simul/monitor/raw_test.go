@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawDumpRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defaults := map[string]string{"run": "3", "nodes": "16", "network": "udp"}
+	percentiles := map[string]float64{"sigen_wall": 90}
+	filter := NewPercentileFilter(percentiles)
+	live := NewStats(defaults, &filter)
+	mon := NewMonitor(0, live)
+	// a tiny size to rotate the files
+	raw, err := NewRawWriter(dir, 3, live, 256)
+	require.NoError(t, err)
+	mon.SetRawDump(raw)
+	for i := 0; i < 200; i++ {
+		node := "node-" + string(rune('a'+i%4))
+		mon.update(&singleMeasure{Name: "sigen_wall", Value: float64(i % 37), Node: node})
+		mon.update(&singleMeasure{Name: "net_sent", Value: float64(i), Node: node})
+	}
+	require.NoError(t, raw.Close())
+
+	paths := RawPaths(dir, 3)
+	require.True(t, len(paths) > 1, "no rotation")
+	require.Equal(t, RawPath(dir, 3, 0), paths[0])
+
+	rebuilt, err := StatsFromRaw(paths, &filter, nil)
+	require.NoError(t, err)
+	csv := func(s *Stats) string {
+		var b bytes.Buffer
+		s.WriteHeader(&b)
+		s.WriteValues(&b)
+		return b.String()
+	}
+	require.Equal(t, csv(live), csv(rebuilt))
+
+	// the measures keep their node tag
+	var nodes = make(map[string]int)
+	_, _, err = ReadRaw(paths, func(r *RawRecord) error {
+		require.Equal(t, 3, r.Run)
+		nodes[r.Node]++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, nodes, 4)
+	require.Equal(t, 100, nodes["node-a"])
+
+	// another selection gives other stats
+	single, err := StatsFromRaw(paths, nil, func(r *RawRecord) bool { return r.Node == "node-a" })
+	require.NoError(t, err)
+	single.Collect()
+	require.Equal(t, 50, single.Value("net_sent").NumValue())
+}
+
+func TestParsePercentiles(t *testing.T) {
+	p, err := ParsePercentiles("sigen_wall=90,net_sent=50.5")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"sigen_wall": 90, "net_sent": 50.5}, p)
+	_, err = ParsePercentiles("sigen_wall")
+	require.Error(t, err)
+	p, err = ParsePercentiles("")
+	require.NoError(t, err)
+	require.Empty(t, p)
+}
+
+func TestRawDumpRepetitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stats := NewStats(map[string]string{"run": "0"}, nil)
+	port := DefaultSinkPort + 4
+	mon := NewMonitor(port, stats)
+	raw, err := NewRawWriter(dir, 0, stats, 0)
+	require.NoError(t, err)
+	mon.SetRawDump(raw)
+	defer mon.Stop()
+	go mon.Listen()
+	<-mon.Listening()
+	require.NoError(t, ConnectSink("localhost:"+strconv.Itoa(port)))
+	defer EndAndCleanup()
+	SetNodeTag("node-1")
+	defer SetNodeTag("")
+	defer SetRepetitionTag(0)
+	for rep := 1; rep <= 3; rep++ {
+		SetRepetitionTag(rep)
+		RecordSingleMeasure("sigen_wall", float64(rep))
+	}
+	select {
+	case <-mon.Flushed(3):
+	case <-time.After(5 * time.Second):
+		t.Fatal("measures not received")
+	}
+	require.NoError(t, raw.Close())
+
+	// the raw measures keep their repetition, the stats aggregate them
+	var reps []int
+	_, _, err = ReadRaw(RawPaths(dir, 0), func(r *RawRecord) error {
+		require.Equal(t, "node-1", r.Node)
+		reps = append(reps, r.Rep)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3}, reps)
+	stats.Collect()
+	require.Equal(t, 3, stats.Value("sigen_wall").NumValue())
+	require.Equal(t, 2.0, stats.Value("sigen_wall").Avg())
+}
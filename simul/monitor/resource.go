@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"runtime"
+	"sort"
+)
+
+// ResourceMeasure records a snapshot of the resources used by the process:
+// the memory and the garbage collections of the Go runtime, the CPU time,
+// the number of goroutines and, on Linux, the fields of /proc/self/status
+// and the number of open file descriptors. Each value is sent as the measure
+// *name*_*key*, see Values for the keys.
+//
+// The values are cumulative since the start of the process: recording a
+// measure at the start and another one at the end of a run, under different
+// names, gives the resources used by the run.
+type ResourceMeasure struct {
+	name string
+}
+
+// NewResourceMeasure returns a ResourceMeasure sending its values under the
+// given name
+func NewResourceMeasure(name string) *ResourceMeasure {
+	return &ResourceMeasure{name: name}
+}
+
+// Values returns a snapshot of the resources used by the process:
+//
+// - heap, sys: bytes allocated in the heap and obtained from the OS
+//
+// - gc, gc_pause: number of garbage collections and their total pause, in
+// seconds
+//
+// - goroutines: number of goroutines
+//
+// - cpu_system, cpu_user: CPU time, in seconds, absent if unknown
+//
+// - rss, rss_peak, threads, fds: resident memory and its peak in bytes,
+// number of threads and open file descriptors, only on Linux
+func (r *ResourceMeasure) Values() map[string]float64 {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	values := map[string]float64{
+		"heap":       float64(mem.HeapAlloc),
+		"sys":        float64(mem.Sys),
+		"gc":         float64(mem.NumGC),
+		"gc_pause":   float64(mem.PauseTotalNs) / 1.0e9,
+		"goroutines": float64(runtime.NumGoroutine()),
+	}
+	if sys, usr := getRTime(); sys >= 0 && usr >= 0 {
+		values["cpu_system"] = sys
+		values["cpu_user"] = usr
+	}
+	for k, v := range procValues() {
+		values[k] = v
+	}
+	return values
+}
+
+// Record sends a snapshot of the resources to the monitor. There is nothing
+// to reset, the values are snapshots.
+func (r *ResourceMeasure) Record() {
+	values := r.Values()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		newSingleMeasure(r.name+"_"+k, values[k]).Record()
+	}
+}
@@ -0,0 +1,53 @@
+// +build linux
+
+package monitor
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procStatusFields maps the fields of /proc/self/status to the keys of the
+// values, with the factor converting them
+var procStatusFields = map[string]struct {
+	key    string
+	factor float64
+}{
+	"VmRSS":   {"rss", 1024},
+	"VmHWM":   {"rss_peak", 1024},
+	"Threads": {"threads", 1},
+}
+
+// procValues returns the values of /proc/self/status listed in
+// procStatusFields and the number of open file descriptors. The values which
+// can't be read are absent.
+func procValues() map[string]float64 {
+	values := make(map[string]float64)
+	if f, err := os.Open("/proc/self/status"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// e.g. "VmRSS:	   12345 kB"
+			parts := strings.Fields(scanner.Text())
+			if len(parts) < 2 {
+				continue
+			}
+			field, ok := procStatusFields[strings.TrimSuffix(parts[0], ":")]
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				continue
+			}
+			values[field.key] = v * field.factor
+		}
+	}
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		values["fds"] = float64(len(fds))
+	}
+	return values
+}
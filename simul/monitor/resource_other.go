@@ -0,0 +1,8 @@
+// +build !linux
+
+package monitor
+
+// procValues returns nothing, /proc is only read on Linux
+func procValues() map[string]float64 {
+	return nil
+}
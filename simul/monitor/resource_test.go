@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceMeasureValues(t *testing.T) {
+	r := NewResourceMeasure("res")
+	values := r.Values()
+	keys := []string{"heap", "sys", "gc", "gc_pause", "goroutines", "cpu_system", "cpu_user"}
+	if runtime.GOOS == "linux" {
+		keys = append(keys, "rss", "rss_peak", "threads", "fds")
+	}
+	for _, k := range keys {
+		require.Contains(t, values, k)
+		require.True(t, values[k] >= 0, "%s = %f", k, values[k])
+	}
+	require.True(t, values["heap"] > 0)
+	require.True(t, values["sys"] >= values["heap"])
+	require.True(t, values["goroutines"] >= 1)
+	if runtime.GOOS == "linux" {
+		// at least stdin, stdout and stderr
+		require.True(t, values["fds"] >= 3)
+		require.True(t, values["rss"] > 0)
+		require.True(t, values["rss_peak"] >= values["rss"])
+		require.True(t, values["threads"] >= 1)
+	}
+
+	// some work between the snapshots
+	var garbage [][]byte
+	for i := 0; i < 100; i++ {
+		garbage = append(garbage, make([]byte, 1<<16))
+	}
+	runtime.GC()
+	after := r.Values()
+	cumulative := []string{"gc", "gc_pause", "cpu_system", "cpu_user"}
+	if runtime.GOOS == "linux" {
+		cumulative = append(cumulative, "rss_peak")
+	}
+	for _, k := range cumulative {
+		require.True(t, after[k] >= values[k], "%s: %f then %f", k, values[k], after[k])
+	}
+	require.True(t, after["gc"] > values["gc"])
+	require.Len(t, garbage, 100)
+}
+
+func TestResourceMeasureRecord(t *testing.T) {
+	stat := setupLocalSink()
+	defer SetSink(nil)
+	r := NewResourceMeasure("res")
+	r.Record()
+	stat.Collect()
+	for k := range r.Values() {
+		require.NotNil(t, stat.Value("res_"+k), k)
+	}
+	require.True(t, stat.Value("res_heap").Avg() > 0)
+}
@@ -0,0 +1,8 @@
+// +build !freebsd,!linux,!darwin,!windows
+
+package monitor
+
+// Returns -1 for the system and the user CPU time, unknown on this system.
+func getRTime() (tSys, tUsr float64) {
+	return -1, -1
+}
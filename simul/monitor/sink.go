@@ -0,0 +1,86 @@
+package monitor
+
+import "sync"
+
+// Sink receives the measures recorded by the Measure implementations. The
+// default sink sends them to the endpoint given to ConnectSink; SetSink
+// replaces it, for example to record the measures in-process.
+type Sink interface {
+	// Record records one value of the named measure
+	Record(name string, value float64) error
+}
+
+var sinks struct {
+	sync.RWMutex
+	current Sink
+}
+
+// SetSink sets the sink all the measures are recorded to. It can be called at
+// any time, including while measures are being recorded. A nil sink restores
+// the default sink, i.e. the connection opened by ConnectSink.
+func SetSink(s Sink) {
+	sinks.Lock()
+	defer sinks.Unlock()
+	sinks.current = s
+}
+
+func currentSink() Sink {
+	sinks.RLock()
+	defer sinks.RUnlock()
+	if sinks.current == nil {
+		return connSink{}
+	}
+	return sinks.current
+}
+
+// connSink is the default sink, encoding the measures over the connection
+// opened by ConnectSink.
+type connSink struct{}
+
+func (connSink) Record(name string, value float64) error {
+	m := newSingleMeasure(name, value)
+	global.Lock()
+	m.Node = global.node
+	m.Rep = global.rep
+	global.seq++
+	m.Seq = global.seq
+	global.Unlock()
+	return send(m)
+}
+
+// localSink records the measures directly into a Stats
+type localSink struct {
+	stats *Stats
+}
+
+// NewLocalSink returns a sink storing the measures into the given stats, as
+// a Monitor does for the measures it receives. It lets tests and services
+// embedding Handel collect the measures without any network.
+func NewLocalSink(stats *Stats) Sink {
+	return &localSink{stats: stats}
+}
+
+func (l *localSink) Record(name string, value float64) error {
+	l.stats.Store(name, value)
+	return nil
+}
+
+// fanOutSink records the measures to several sinks
+type fanOutSink []Sink
+
+// NewFanOutSink returns a sink recording each measure to all the given
+// sinks. It returns the first error returned by the sinks, after having
+// recorded the measure to all of them.
+func NewFanOutSink(s ...Sink) Sink {
+	return fanOutSink(s)
+}
+
+func (f fanOutSink) Record(name string, value float64) error {
+	var first error
+	for _, s := range f {
+		if err := s.Record(name, value); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
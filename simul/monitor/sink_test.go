@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordAll records the same measures through the current sink and returns
+// how many values were recorded.
+func recordAll() int {
+	RecordSingleMeasure("round", 10)
+	RecordSingleMeasure("round", 20)
+	counter := NewCounterMeasure("dummy", &DummyCounter{0, 0})
+	counter.Record()
+	counter.Record()
+	return 6
+}
+
+func statsOutput(s *Stats) []byte {
+	var b bytes.Buffer
+	s.Collect()
+	s.WriteHeader(&b)
+	s.WriteValues(&b)
+	return b.Bytes()
+}
+
+func TestSinkIdentical(t *testing.T) {
+	defer SetSink(nil)
+	local := NewStats(nil, nil)
+	SetSink(NewLocalSink(local))
+	recordAll()
+
+	fan1, fan2 := NewStats(nil, nil), NewStats(nil, nil)
+	SetSink(NewFanOutSink(NewLocalSink(fan1), NewLocalSink(fan2)))
+	recordAll()
+
+	// the default sink sends the measures to a monitor
+	remote := NewStats(nil, nil)
+	port := DefaultSinkPort + 1
+	mon := NewMonitor(port, remote)
+	defer mon.Stop()
+	go mon.Listen()
+	<-mon.Listening()
+	require.NoError(t, ConnectSink("localhost:"+strconv.Itoa(port)))
+	defer EndAndCleanup()
+	n := recordAll()
+	select {
+	case <-mon.Flushed(n):
+	case <-time.After(5 * time.Second):
+	}
+	require.Equal(t, n, remote.Received())
+
+	exp := statsOutput(local)
+	require.Equal(t, n, local.Received())
+	require.Equal(t, exp, statsOutput(fan1))
+	require.Equal(t, exp, statsOutput(fan2))
+	require.Equal(t, exp, statsOutput(remote))
+}
+
+func TestSinkSwap(t *testing.T) {
+	defer SetSink(nil)
+	stats := []*Stats{NewStats(nil, nil), NewStats(nil, nil)}
+	SetSink(NewLocalSink(stats[0]))
+
+	routines, perRoutine := 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < routines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				RecordSingleMeasure("round", 1)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		SetSink(NewLocalSink(stats[i%2]))
+	}
+	wg.Wait()
+	// every measure landed in exactly one of the stats
+	require.Equal(t, routines*perRoutine, stats[0].Received()+stats[1].Received())
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,6 +35,12 @@ type Stats struct {
 	sync.Mutex
 
 	rcvd int
+	// maximum number of samples kept by each Value - see SetMaxSamples
+	maxSamples int
+	// sequence numbers of the measures received by sender, as bitmaps, and
+	// the number of measures received twice - see Update
+	seen       map[string][]uint64
+	duplicates int
 }
 
 // NewStats return a Stats with the given defaults values. For example:
@@ -55,23 +62,83 @@ func (s *Stats) init() *Stats {
 	s.keys = make([]string, 0)
 	s.static = make(map[string]string)
 	s.staticKeys = make([]string, 0)
+	s.maxSamples = DefaultMaxSamples
+	s.seen = make(map[string][]uint64)
 	return s
 }
 
-// Update will update the Stats with this given measure
+// SetMaxSamples sets the maximum number of samples kept by each measure, past
+// which the samples are kept by reservoir sampling - see Value. 0 means
+// DefaultMaxSamples and a negative maximum keeps all the samples.
+func (s *Stats) SetMaxSamples(max int) {
+	s.Lock()
+	defer s.Unlock()
+	if max == 0 {
+		max = DefaultMaxSamples
+	}
+	s.maxSamples = max
+	for _, v := range s.values {
+		v.setMaxSamples(max)
+	}
+}
+
+// Update will update the Stats with this given measure. A measure of a tagged
+// sender whose sequence number was received already is a duplicate, and is
+// dropped: a sender can send its measures again, e.g. to a monitor recovered
+// from a checkpoint, without counting them twice.
 func (s *Stats) Update(m *singleMeasure) {
 	s.Lock()
 	defer s.Unlock()
+	if m.Seq > 0 && m.Node != "" && !s.markSeen(m.Node, m.Seq) {
+		s.duplicates++
+		return
+	}
+	s.store(m.Name, m.Value)
+}
+
+// markSeen records the sequence number of the sender, and returns false if it
+// was recorded already
+func (s *Stats) markSeen(node string, seq uint64) bool {
+	bits := s.seen[node]
+	word, bit := int(seq/64), uint64(1)<<(seq%64)
+	if word >= len(bits) {
+		bits = append(bits, make([]uint64, word+1-len(bits))...)
+		s.seen[node] = bits
+	}
+	if bits[word]&bit != 0 {
+		return false
+	}
+	bits[word] |= bit
+	return true
+}
+
+// Duplicates returns the number of measures dropped by Update as received
+// already
+func (s *Stats) Duplicates() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.duplicates
+}
+
+// Store adds the value to the measure of the given name
+func (s *Stats) Store(name string, v float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.store(name, v)
+}
+
+func (s *Stats) store(name string, v float64) {
 	var value *Value
 	var ok bool
-	value, ok = s.values[m.Name]
+	value, ok = s.values[name]
 	if !ok {
-		value = NewValue(m.Name)
-		s.values[m.Name] = value
-		s.keys = append(s.keys, m.Name)
+		value = NewValue(name)
+		value.setMaxSamples(s.maxSamples)
+		s.values[name] = value
+		s.keys = append(s.keys, name)
 		sort.Strings(s.keys)
 	}
-	value.Store(m.Value)
+	value.Store(v)
 	s.rcvd++
 }
 
@@ -126,6 +193,9 @@ func (s *Stats) WriteValues(w io.Writer) {
 // making averages. Each value should either be:
 //   - represented once - then it'll be copied to all runs
 //   - have the same frequency as the other non-once values
+//
+// Past the maximum number of samples, the values written are the ones of the
+// reservoirs.
 func (s *Stats) WriteIndividualStats(w io.Writer) error {
 	// by default
 	s.Lock()
@@ -186,6 +256,7 @@ func AverageStats(stats []*Stats) *Stats {
 	s.static = stats[0].static
 	s.staticKeys = stats[0].staticKeys
 	s.keys = stats[0].keys
+	s.maxSamples = stats[0].maxSamples
 	stats[0].Unlock()
 	// Average
 	for _, k := range s.keys {
@@ -285,6 +356,63 @@ func (s *Stats) Value(name string) *Value {
 	return nil
 }
 
+// Summary holds basic statistics over the values received so far for a
+// measure.
+type Summary struct {
+	Count int
+	Min   float64
+	Max   float64
+	Sum   float64
+	Avg   float64
+}
+
+// Summary returns the statistics of the values received so far for the given
+// measure, and false if none were received. Contrary to Collect, it does not
+// modify the Stats so it can be called while measures are still coming in.
+func (s *Stats) Summary(name string) (Summary, bool) {
+	s.Lock()
+	val, ok := s.values[name]
+	s.Unlock()
+	if !ok {
+		return Summary{}, false
+	}
+	val.Lock()
+	defer val.Unlock()
+	sum := Summary{Count: val.n, Min: val.min, Max: val.max, Sum: val.sum}
+	if sum.Count > 0 {
+		sum.Avg = sum.Sum / float64(sum.Count)
+	}
+	return sum, true
+}
+
+// Median returns the median of the values received so far for the given
+// measure, and false if none were received. Like Summary, it does not modify
+// the Stats. Past the maximum number of samples, it is an estimate taken over
+// the reservoir.
+func (s *Stats) Median(name string) (float64, bool) {
+	s.Lock()
+	val, ok := s.values[name]
+	s.Unlock()
+	if !ok {
+		return 0, false
+	}
+	val.Lock()
+	defer val.Unlock()
+	if len(val.store) == 0 {
+		return 0, false
+	}
+	median, err := stats.Median(val.store)
+	return median, err == nil
+}
+
+// Static returns the value of the static field, and false if it is not set
+func (s *Stats) Static(key string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.static[key]
+	return v, ok
+}
+
 // Returns an overview of the stats - not complete data returned!
 func (s *Stats) String() string {
 	s.Collect()
@@ -312,103 +440,225 @@ func (s *Stats) setDefaultValues(defaults map[string]string) {
 	sort.Strings(s.staticKeys)
 }
 
+// SetStatic sets the value of a static field, adding the field if it is new.
+// It lets the values only known once the run is over, such as the outcome of
+// the synchronization, be written along the default values.
+func (s *Stats) SetStatic(key, value string) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.static[key]; !ok {
+		s.staticKeys = append(s.staticKeys, key)
+		sort.Strings(s.staticKeys)
+	}
+	s.static[key] = value
+}
+
+// DefaultMaxSamples is the default maximum number of samples kept by a Value,
+// 8MB of samples
+const DefaultMaxSamples = 1 << 20
+
 // Value is used to compute the statistics
 // it represent the time to an action (setup, shamir round, coll round etc)
 // use it to compute streaming mean + dev
+//
+// The count, min, max, sum, mean and deviation are exact, computed as the
+// samples are stored. The samples themselves are kept for the percentiles -
+// the Median, the DataFilter and WriteIndividualStats - up to a maximum,
+// past which they are kept by reservoir sampling: the store is then a uniform
+// sample of all the values and the percentiles are estimates.
 type Value struct {
 	name string
 	min  float64
 	max  float64
 	sum  float64
 	n    int
-	oldM float64
-	newM float64
-	oldS float64
-	newS float64
+	// mean and sum of the squared differences to the mean, of the streaming
+	// dev algo taken from http://www.johndcook.com/blog/standard_deviation/
+	mean float64
+	m2   float64
 	dev  float64
 
-	// Store where are kept the values
-	store []float64
+	// Store where are kept the values, at most maxSamples of them if positive
+	store      []float64
+	maxSamples int
+	rnd        *rand.Rand
 	sync.Mutex
 }
 
-// NewValue returns a new value object with this name
+// NewValue returns a new value object with this name, keeping all its samples
 func NewValue(name string) *Value {
 	return &Value{name: name, store: make([]float64, 0)}
 }
 
-// Store takes this new time and stores it for later analysis
-// Since we might want to do percentile sorting, we need to have all the Values
-// For the moment, we do a simple store of the Value, but note that some
-// streaming percentile algorithm exists in case the number of messages is
-// growing to big.
+func (t *Value) setMaxSamples(max int) {
+	t.Lock()
+	defer t.Unlock()
+	t.maxSamples = max
+	if max > 0 && len(t.store) > max {
+		t.store = t.reservoir().sample(t.store, max)
+	}
+}
+
+// reservoir returns the random source of the reservoir sampling, seeded
+// identically for all the values so the results are reproducible
+func (t *Value) reservoir() *sampler {
+	if t.rnd == nil {
+		t.rnd = rand.New(rand.NewSource(1))
+	}
+	return &sampler{t.rnd}
+}
+
+// Store takes this new time and stores it for later analysis. Past the
+// maximum number of samples, it replaces a random stored sample with the
+// probability max/n, n being the number of values stored so far, so each
+// value has the same probability to be kept.
 func (t *Value) Store(newTime float64) {
 	t.Lock()
 	defer t.Unlock()
-	t.store = append(t.store, newTime)
+	t.add(1, newTime, newTime, newTime, newTime, 0)
+	if t.maxSamples <= 0 || len(t.store) < t.maxSamples {
+		t.store = append(t.store, newTime)
+		return
+	}
+	if j := t.reservoir().rnd.Intn(t.n); j < t.maxSamples {
+		t.store[j] = newTime
+	}
+}
+
+// add merges into the exact statistics the ones of n values, with the
+// parallel algorithm of Chan et al. for the mean and deviation
+func (t *Value) add(n int, min, max, sum, mean, m2 float64) {
+	if n == 0 {
+		return
+	}
+	if t.n == 0 || min < t.min {
+		t.min = min
+	}
+	if t.n == 0 || max > t.max {
+		t.max = max
+	}
+	total := t.n + n
+	delta := mean - t.mean
+	t.m2 += m2 + delta*delta*float64(t.n)*float64(n)/float64(total)
+	t.mean += delta * float64(n) / float64(total)
+	t.sum += sum
+	t.n = total
 }
 
-// Collect will collect all float64 stored in the store's Value and will compute
-// the basic statistics about them such as min, max, dev and avg.
+// Collect computes the deviation of the values. The other statistics are
+// kept up to date as the values are stored, so it only accounts for the
+// values stored since the last call, and can be called many times.
 func (t *Value) Collect() {
 	t.Lock()
 	defer t.Unlock()
-	// It is kept as a streaming average / dev processus for the moment (not the most
-	// optimized).
-	// streaming dev algo taken from http://www.johndcook.com/blog/standard_deviation/
-	t.sum = 0
-	for _, newTime := range t.store {
-		// nothings takes 0 ms to complete, so we know it's the first time
-		if t.min > newTime || t.n == 0 {
-			t.min = newTime
-		}
-		if t.max < newTime {
-			t.max = newTime
-		}
-
-		t.n++
-		if t.n == 1 {
-			t.oldM = newTime
-			t.newM = newTime
-			t.oldS = 0.0
-		} else {
-			t.newM = t.oldM + (newTime-t.oldM)/float64(t.n)
-			t.newS = t.oldS + (newTime-t.oldM)*(newTime-t.newM)
-			t.oldM = t.newM
-			t.oldS = t.newS
-		}
-		t.dev = math.Sqrt(t.newS / float64(t.n-1))
-		t.sum += newTime
+	if t.n > 0 {
+		t.dev = math.Sqrt(t.m2 / float64(t.n-1))
 	}
 }
 
-// Filter outs its Values
+// Filter outs its Values. The statistics are then the ones of the filtered
+// samples, which are only a part of the values past the maximum number of
+// samples.
 func (t *Value) Filter(filt DataFilter) {
 	t.Lock()
 	defer t.Unlock()
-	t.store = filt.Filter(t.name, t.store)
+	filtered := filt.Filter(t.name, t.store)
+	if len(filtered) == len(t.store) {
+		return
+	}
+	t.store = filtered
+	t.min, t.max, t.sum, t.n, t.mean, t.m2 = 0, 0, 0, 0, 0, 0
+	for _, v := range t.store {
+		t.add(1, v, v, v, v, 0)
+	}
 }
 
-// AverageValue will create a Value averaging all Values given
+// AverageValue will create a Value averaging all Values given: its statistics
+// are the ones of all their values. Its samples are all theirs up to the
+// maximum number of samples of the first one, otherwise a sample of them where
+// each Value weighs as many values as it stored.
 func AverageValue(st ...*Value) *Value {
 	if len(st) < 1 {
 		return new(Value)
 	}
 	var t Value
 	name := st[0].name
+	t.maxSamples = st[0].maxSamples
+	var stores [][]float64
+	var weights []int
 	for _, s := range st {
 		if s.name != name {
 			log.Error("Averaging not the sames Values ...?")
 			return new(Value)
 		}
 		s.Lock()
-		t.store = append(t.store, s.store...)
+		t.add(s.n, s.min, s.max, s.sum, s.mean, s.m2)
+		stores = append(stores, s.store)
+		weights = append(weights, s.n)
 		s.Unlock()
 	}
 	t.name = name
+	t.store = t.reservoir().merge(stores, weights, t.maxSamples)
 	return &t
 }
 
+// sampler picks the samples of the reservoirs
+type sampler struct {
+	rnd *rand.Rand
+}
+
+// sample returns k values picked uniformly without replacement
+func (s *sampler) sample(values []float64, k int) []float64 {
+	if k >= len(values) {
+		return append([]float64(nil), values...)
+	}
+	picked := make([]float64, k)
+	for i, j := range s.rnd.Perm(len(values))[:k] {
+		picked[i] = values[j]
+	}
+	return picked
+}
+
+// merge returns the samples of all the stores if there are at most max of them
+// or max is not positive. Otherwise, it returns max samples where each store
+// contributes in proportion of the number of values it stands for, its weight.
+func (s *sampler) merge(stores [][]float64, weights []int, max int) []float64 {
+	var size, total int
+	for i, store := range stores {
+		size += len(store)
+		total += weights[i]
+	}
+	merged := make([]float64, 0, size)
+	if max <= 0 || size <= max {
+		for _, store := range stores {
+			merged = append(merged, store...)
+		}
+		return merged
+	}
+	// the shares of the stores, by largest remainder
+	shares := make([]int, len(stores))
+	remainders := make([]float64, len(stores))
+	var given int
+	for i, w := range weights {
+		exact := float64(max) * float64(w) / float64(total)
+		shares[i] = int(exact)
+		remainders[i] = exact - float64(shares[i])
+		given += shares[i]
+	}
+	order := make([]int, len(stores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:max-given] {
+		shares[i]++
+	}
+	for i, store := range stores {
+		merged = append(merged, s.sample(store, shares[i])...)
+	}
+	return merged
+}
+
 // Min returns the minimum of all stored float64
 func (t *Value) Min() float64 {
 	t.Lock()
@@ -441,7 +691,7 @@ func (t *Value) NumValue() int {
 func (t *Value) Avg() float64 {
 	t.Lock()
 	defer t.Unlock()
-	return t.newM
+	return t.mean
 }
 
 // Dev returns the standard deviation of the Values
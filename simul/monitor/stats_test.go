@@ -2,12 +2,15 @@ package monitor
 
 import (
 	"bytes"
-	"strconv"
+	"math"
+	"math/rand"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
-	"go.dedis.ch/onet/v3/log"
+	"github.com/montanaflynn/stats"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewDataFilter(t *testing.T) {
@@ -84,6 +87,16 @@ func TestStatsOrder(t *testing.T) {
 	}
 }
 
+func TestStatsSetStatic(t *testing.T) {
+	stat := NewStats(map[string]string{"nodes": "10", "run": "0"}, nil)
+	stat.SetStatic("missing", "3 7")
+	stat.SetStatic("nodes", "12")
+	str := new(bytes.Buffer)
+	stat.WriteHeader(str)
+	stat.WriteValues(str)
+	require.Equal(t, "missing,nodes,run\n3 7,12,0\n", str.String())
+}
+
 func TestValues(t *testing.T) {
 	v1 := NewValue("test")
 	v1.Store(5.0)
@@ -183,23 +196,128 @@ func TestStatsAverageFiltered(t *testing.T) {
 func TestStatsString(t *testing.T) {
 	rc := map[string]string{"servers": "10", "hosts": "10"}
 	rs := NewStats(rc, nil)
-	m := NewDefaultMonitor(rs)
-	defer m.Stop()
-	go func() {
-		if err := m.Listen(); err != nil {
-			log.Fatal("Could not Listen():", err)
-		}
-	}()
-	defer EndAndCleanup()
+	SetSink(NewLocalSink(rs))
+	defer SetSink(nil)
 
-	time.Sleep(100 * time.Millisecond)
-	log.ErrFatal(ConnectSink("localhost:" + strconv.Itoa(DefaultSinkPort)))
 	measure := NewTimeMeasure("test")
 	time.Sleep(time.Millisecond * 100)
 	measure.Record()
-	time.Sleep(time.Millisecond * 100)
 
 	if !strings.Contains(rs.String(), "0.1") {
 		t.Fatal("The measurement should contain 0.1:", rs.String())
 	}
 }
+
+func TestStatsSummary(t *testing.T) {
+	stats := NewStats(nil, nil)
+	_, ok := stats.Summary("round_wall")
+	require.False(t, ok)
+
+	for _, v := range []float64{10, 30, 20} {
+		stats.Store("round_wall", v)
+	}
+	// the summary can be taken many times while the measures come in
+	for i := 0; i < 2; i++ {
+		sum, ok := stats.Summary("round_wall")
+		require.True(t, ok)
+		require.Equal(t, Summary{Count: 3, Min: 10, Max: 30, Sum: 60, Avg: 20}, sum)
+	}
+	stats.Collect()
+	require.Equal(t, 3, stats.Value("round_wall").NumValue())
+	require.Equal(t, 20.0, stats.Value("round_wall").Avg())
+}
+
+func TestStatsMedianStatic(t *testing.T) {
+	stats := NewStats(map[string]string{"nodes": "16"}, nil)
+	_, ok := stats.Median("sigen_wall")
+	require.False(t, ok)
+	for _, v := range []float64{4, 1, 3, 2} {
+		stats.Store("sigen_wall", v)
+	}
+	median, ok := stats.Median("sigen_wall")
+	require.True(t, ok)
+	require.Equal(t, 2.5, median)
+
+	nodes, ok := stats.Static("nodes")
+	require.True(t, ok)
+	require.Equal(t, "16", nodes)
+	_, ok = stats.Static("period")
+	require.False(t, ok)
+}
+
+func TestValueReservoir(t *testing.T) {
+	n, max := 1000000, 10000
+	s := NewStats(nil, nil)
+	s.SetMaxSamples(max)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	// a uniform distribution over [0, n), in a random order
+	rnd := rand.New(rand.NewSource(42))
+	for _, v := range rnd.Perm(n) {
+		s.Store("packets", float64(v))
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	val := s.Value("packets")
+	require.Len(t, val.store, max)
+	require.True(t, cap(val.store) <= 2*max)
+	// far from the 8MB of all the samples
+	require.True(t, after.HeapAlloc < before.HeapAlloc+uint64(20*8*max), "heap grew by %d", after.HeapAlloc-before.HeapAlloc)
+
+	// the exact statistics are exact
+	s.Collect()
+	s.Collect()
+	require.Equal(t, n, val.NumValue())
+	require.Equal(t, 0.0, val.Min())
+	require.Equal(t, float64(n-1), val.Max())
+	require.Equal(t, float64(n)*float64(n-1)/2, val.Sum())
+	require.InDelta(t, float64(n-1)/2, val.Avg(), 1e-6)
+	// the deviation of the uniform distribution
+	require.InDelta(t, float64(n)/math.Sqrt(12), val.Dev(), 1)
+	sum, ok := s.Summary("packets")
+	require.True(t, ok)
+	require.Equal(t, Summary{Count: n, Min: 0, Max: float64(n - 1), Sum: val.Sum(), Avg: val.Sum() / float64(n)}, sum)
+
+	// the percentiles are estimates
+	median, ok := s.Median("packets")
+	require.True(t, ok)
+	require.InDelta(t, float64(n)/2, median, 0.02*float64(n))
+	p90, err := stats.Percentile(val.store, 90)
+	require.NoError(t, err)
+	require.InDelta(t, 0.9*float64(n), p90, 0.02*float64(n))
+
+	// the reservoirs merge in proportion of their values: the small one
+	// below weighs 1% of the merged samples
+	small := NewStats(nil, nil)
+	small.SetMaxSamples(max)
+	for i := 0; i < n/100; i++ {
+		small.Store("packets", float64(2*n))
+	}
+	avg := AverageValue(val, small.Value("packets"))
+	require.Len(t, avg.store, max)
+	var high int
+	for _, v := range avg.store {
+		if v == float64(2*n) {
+			high++
+		}
+	}
+	require.InDelta(t, max/101, high, 1)
+	avg.Collect()
+	require.Equal(t, n+n/100, avg.NumValue())
+	require.Equal(t, float64(2*n), avg.Max())
+	require.Equal(t, val.Sum()+float64(n/100)*float64(2*n), avg.Sum())
+	require.InDelta(t, avg.Sum()/float64(avg.NumValue()), avg.Avg(), 1e-6)
+
+	// below the maximum, the merge keeps all the samples
+	require.Len(t, AverageValue(NewValue("a"), NewValue("a")).store, 0)
+	v1, v2 := NewValue("a"), NewValue("a")
+	v1.Store(1)
+	v2.Store(3)
+	v2.Store(5)
+	merged := AverageValue(v1, v2)
+	require.Equal(t, []float64{1, 3, 5}, merged.store)
+	merged.Collect()
+	require.Equal(t, 3.0, merged.Avg())
+	require.Equal(t, 2.0, merged.Dev())
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/monitor"
+)
+
+// The levels of the packets of the collector protocol, the level 0 being the
+// one of the sync packets
+const (
+	// collectorSigLevel marks the individual signature sent to the collector
+	collectorSigLevel byte = 1
+	// collectorAckLevel marks the ack of the collector, echoing the
+	// signature it acks
+	collectorAckLevel byte = 2
+)
+
+// CollectorResendPeriod is the period at which the nodes send their signature
+// again to the collector, until it acks it
+const CollectorResendPeriod = 200 * time.Millisecond
+
+// collectorOf returns the id of the collector of the run: the active node of
+// the lowest id
+func collectorOf(nodes lib.NodeList) int {
+	for i := 0; i < nodes.Size(); i++ {
+		if nodes.Node(i).Active {
+			return i
+		}
+	}
+	panic("no active node in the registry to collect the signatures")
+}
+
+// collector verifies the individual signatures sent by the nodes and
+// aggregates them in a multi-signature over the whole registry, acking each of
+// them. The signatures are verified one at a time against the key of their
+// signer in the registry, as Handel verifies the individual signatures.
+type collector struct {
+	sync.Mutex
+	net       h.Network
+	reg       h.Registry
+	cons      h.Constructor
+	msg       []byte
+	id        int32
+	threshold int
+	ms        *h.MultiSignature
+	incoming  chan *h.Packet
+	final     chan h.MultiSignature
+	reached   bool
+	done      chan bool
+
+	// statistics of the individual signatures received
+	checked      int
+	checkingTime time.Duration
+	invalid      int
+	duplicated   int
+	dropped      int
+}
+
+// newCollector returns a collector whose aggregate starts with its own
+// signature. The final signature is sent once it reaches the threshold.
+func newCollector(net h.Network, reg h.Registry, cons h.Constructor, id int32, own h.Signature, msg []byte, threshold int) *collector {
+	ms := &h.MultiSignature{BitSet: h.NewWilffBitset(reg.Size()), Signature: own}
+	ms.BitSet.Set(int(id), true)
+	c := &collector{
+		net:       net,
+		reg:       reg,
+		cons:      cons,
+		msg:       msg,
+		id:        id,
+		threshold: threshold,
+		ms:        ms,
+		incoming:  make(chan *h.Packet, reg.Size()),
+		final:     make(chan h.MultiSignature, 1),
+		done:      make(chan bool),
+	}
+	c.checkThreshold()
+	go c.process()
+	return c
+}
+
+// NewPacket implements the handel.Listener interface, queuing the individual
+// signatures to verify. They are dropped when the queue is full, the senders
+// send them again.
+func (c *collector) NewPacket(p *h.Packet) {
+	if p.Level != collectorSigLevel {
+		return
+	}
+	select {
+	case c.incoming <- p:
+	default:
+		c.Lock()
+		c.dropped++
+		c.Unlock()
+	}
+}
+
+func (c *collector) process() {
+	for {
+		select {
+		case p := <-c.incoming:
+			c.handle(p)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handle verifies the individual signature of the packet and adds it to the
+// aggregate. A signature already aggregated is acked again without being
+// verified, its sender missed the ack.
+func (c *collector) handle(p *h.Packet) {
+	if p.Origin < 0 || int(p.Origin) >= c.reg.Size() {
+		c.Lock()
+		c.invalid++
+		c.Unlock()
+		return
+	}
+	c.Lock()
+	duplicated := c.ms.BitSet.Get(int(p.Origin))
+	if duplicated {
+		c.duplicated++
+	}
+	c.Unlock()
+	if duplicated {
+		c.ack(p)
+		return
+	}
+	start := time.Now()
+	sig, err := c.verify(p)
+	c.Lock()
+	c.checked++
+	c.checkingTime += time.Since(start)
+	if err != nil {
+		c.invalid++
+		c.Unlock()
+		return
+	}
+	c.ms.BitSet.Set(int(p.Origin), true)
+	c.ms.Signature = c.ms.Signature.Combine(sig)
+	c.checkThreshold()
+	c.Unlock()
+	c.ack(p)
+}
+
+// verify returns the individual signature of the packet if it is valid for
+// the key of its origin
+func (c *collector) verify(p *h.Packet) (h.Signature, error) {
+	id, ok := c.reg.Identity(int(p.Origin))
+	if !ok {
+		return nil, fmt.Errorf("collector: no identity %d", p.Origin)
+	}
+	sig := c.cons.Signature()
+	if err := sig.UnmarshalBinary(p.IndividualSig); err != nil {
+		return nil, err
+	}
+	if err := id.PublicKey().VerifySignature(c.msg, sig); err != nil {
+		return nil, fmt.Errorf("collector: signature of %d: %s", p.Origin, err)
+	}
+	return sig, nil
+}
+
+// ack sends back the signature of the packet to its origin
+func (c *collector) ack(p *h.Packet) {
+	id, ok := c.reg.Identity(int(p.Origin))
+	if !ok {
+		return
+	}
+	c.net.Send([]h.Identity{id}, &h.Packet{
+		Origin:        c.id,
+		Level:         collectorAckLevel,
+		IndividualSig: p.IndividualSig,
+	})
+}
+
+// checkThreshold sends a copy of the aggregate once it reaches the threshold.
+// The lock must be held.
+func (c *collector) checkThreshold() {
+	if c.reached || c.ms.BitSet.Cardinality() < c.threshold {
+		return
+	}
+	c.reached = true
+	c.final <- h.MultiSignature{BitSet: c.ms.BitSet.Clone(), Signature: c.ms.Signature}
+}
+
+// FinalSignature returns the channel of the aggregate reaching the threshold
+func (c *collector) FinalSignature() chan h.MultiSignature {
+	return c.final
+}
+
+// Values implements the monitor.Counter interface, with the names of the
+// values of the Handel processing where they compare
+func (c *collector) Values() map[string]float64 {
+	c.Lock()
+	defer c.Unlock()
+	checkingTime := 0.0
+	if c.checked > 0 {
+		checkingTime = float64(c.checkingTime) / float64(time.Millisecond) / float64(c.checked)
+	}
+	return map[string]float64{
+		"sigCheckedCt":    float64(c.checked),
+		"sigCheckingTime": checkingTime,
+		"sigInvalid":      float64(c.invalid),
+		"sigDuplicated":   float64(c.duplicated),
+		"sigDropped":      float64(c.dropped),
+	}
+}
+
+// Stop stops the verification of the signatures
+func (c *collector) Stop() {
+	close(c.done)
+}
+
+// sender sends the individual signature of a node to the collector until the
+// collector acks it
+type sender struct {
+	sync.Mutex
+	net       h.Network
+	collector h.Identity
+	id        int32
+	sig       []byte
+	acked     chan bool
+	sent      int
+	start     time.Time
+	latency   time.Duration
+}
+
+func newSender(net h.Network, collector h.Identity, id int32, sig []byte) *sender {
+	return &sender{
+		net:       net,
+		collector: collector,
+		id:        id,
+		sig:       sig,
+		acked:     make(chan bool),
+	}
+}
+
+// NewPacket implements the handel.Listener interface, looking for the ack of
+// the collector. The acks of the previous repetitions echo another signature.
+func (s *sender) NewPacket(p *h.Packet) {
+	if p.Level != collectorAckLevel || p.Origin != s.collector.ID() || !bytes.Equal(p.IndividualSig, s.sig) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.acked:
+		return
+	default:
+	}
+	s.latency = time.Since(s.start)
+	close(s.acked)
+}
+
+// Run sends the signature every period until the collector acks it, and
+// returns false if it did not before the stop
+func (s *sender) Run(period time.Duration, stop <-chan time.Time) bool {
+	s.Lock()
+	s.start = time.Now()
+	s.Unlock()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		s.send()
+		select {
+		case <-s.acked:
+			return true
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *sender) send() {
+	s.Lock()
+	s.sent++
+	s.Unlock()
+	s.net.Send([]h.Identity{s.collector}, &h.Packet{
+		Origin:        s.id,
+		Level:         collectorSigLevel,
+		IndividualSig: s.sig,
+	})
+}
+
+// Values implements the monitor.Counter interface: the number of times the
+// signature was sent, and the time the ack took in milliseconds
+func (s *sender) Values() map[string]float64 {
+	s.Lock()
+	defer s.Unlock()
+	return map[string]float64{
+		"sent":       float64(s.sent),
+		"ackLatency": float64(s.latency) / float64(time.Millisecond),
+	}
+}
+
+// collectorNode dispatches the packets of the network of an identity to its
+// collector or its sender of the current repetition
+type collectorNode struct {
+	sync.Mutex
+	net      h.Network
+	listener h.Listener
+}
+
+func newCollectorNode(net h.Network) *collectorNode {
+	n := &collectorNode{net: net}
+	net.RegisterListener(n)
+	return n
+}
+
+func (n *collectorNode) NewPacket(p *h.Packet) {
+	n.Lock()
+	l := n.listener
+	n.Unlock()
+	if l != nil {
+		l.NewPacket(p)
+	}
+}
+
+func (n *collectorNode) set(l h.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listener = l
+}
+
+// Values returns the values of the network if it reports some
+func (n *collectorNode) Values() map[string]float64 {
+	if r, ok := n.net.(monitor.Counter); ok {
+		return r.Values()
+	}
+	return map[string]float64{}
+}
+
+// collectorRun runs the collector protocol on the identities of the node
+// instead of Handel, see lib.ProtocolCollector. The churn of the run does not
+// apply to it.
+type collectorRun struct {
+	runConf    lib.RunConfig
+	run        int
+	nodeList   lib.NodeList
+	registry   h.Registry
+	cons       h.Constructor
+	ids        arrayFlags
+	maxTimeout time.Duration
+	logger     h.Logger
+	newNetwork func(id int) h.Network
+	newSyncer  func() *lib.SyncSlave
+}
+
+func (r *collectorRun) Run() {
+	collectorID := collectorOf(r.nodeList)
+	nodes := make([]*collectorNode, len(r.ids))
+	for i, id := range r.ids {
+		nodes[i] = newCollectorNode(r.newNetwork(id))
+	}
+	syncer := r.newSyncer()
+	defer syncer.Stop()
+	syncStart(syncer, r.ids, r.logger)
+	r.logger.Info("nodes", r.ids.String(), "protocol", lib.ProtocolCollector, "collector", collectorID)
+
+	reps := r.runConf.GetRepetitions()
+	for rep := 0; rep < reps; rep++ {
+		if reps > 1 {
+			monitor.SetRepetitionTag(rep + 1)
+			r.logger.Info("nodes", r.ids.String(), "repetition", rep+1)
+		}
+		barrier := lib.END
+		if rep < reps-1 {
+			barrier = lib.RepetitionState(rep)
+		}
+		msg := r.runConf.RepetitionMessage(r.run, rep)
+		collectors := make(chan *collector, 1)
+		var wg sync.WaitGroup
+		for i, id := range r.ids {
+			wg.Add(1)
+			go func(node *collectorNode, id int) {
+				if id == collectorID {
+					collectors <- r.collect(node, id, msg)
+				} else {
+					r.send(node, id, collectorID, msg)
+				}
+				wg.Done()
+				syncer.Signal(barrier, id)
+			}(nodes[i], id)
+		}
+		wg.Wait()
+		if rep == reps-1 {
+			r.logger.Info("simul", "finished")
+			monitor.NewResourceMeasure("resources_end").Record()
+		}
+		// the collector acks the signatures sent again until all the nodes
+		// passed the barrier
+		waitMaster(syncer, barrier, r.logger)
+		select {
+		case c := <-collectors:
+			c.Stop()
+		default:
+		}
+	}
+}
+
+// collect aggregates the signatures of the nodes up to the threshold, and
+// records the measures Handel records
+func (r *collectorRun) collect(node *collectorNode, id int, msg []byte) *collector {
+	own, err := r.nodeList.Node(id).Sign(msg, nil)
+	if err != nil {
+		panic(err)
+	}
+	threshold := r.runConf.GetThreshold()
+	signatureGen := monitor.NewTimeMeasure("sigen")
+	netMeasure := monitor.NewCounterMeasure("net", node)
+	c := newCollector(node.net, r.registry, r.cons, int32(id), own, msg, threshold)
+	processingMeasure := monitor.NewCounterMeasure("sigs", c)
+	node.set(c)
+	var sig h.MultiSignature
+	select {
+	case sig = <-c.FinalSignature():
+	case <-time.After(r.maxTimeout):
+		panic("max timeout")
+	}
+	r.logger.Info("FINISHED", id, "sig", fmt.Sprintf("%d/%d", sig.Cardinality(), threshold))
+	netMeasure.Record()
+	signatureGen.Record()
+	processingMeasure.Record()
+	if err := h.VerifyMultiSignature(msg, &sig, r.registry, r.cons); err != nil {
+		panic("signature invalid !!")
+	}
+	return c
+}
+
+// send sends the signature of the node to the collector until it acks it,
+// and records the number of sends and the latency of the ack
+func (r *collectorRun) send(node *collectorNode, id, collectorID int, msg []byte) {
+	sig, err := r.nodeList.Node(id).Sign(msg, nil)
+	if err != nil {
+		panic(err)
+	}
+	buff, err := sig.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	netMeasure := monitor.NewCounterMeasure("net", node)
+	s := newSender(node.net, r.nodeList.Node(collectorID).Identity, int32(id), buff)
+	senderMeasure := monitor.NewCounterMeasure("sender", s)
+	node.set(s)
+	if !s.Run(CollectorResendPeriod, time.After(r.maxTimeout)) {
+		panic("max timeout")
+	}
+	netMeasure.Record()
+	senderMeasure.Record()
+	r.logger.Info("node", id, "acked", s.Values()["sent"])
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+// localNetwork delivers the packets to the listeners of the other
+// localNetworks of the same router
+type localNetwork struct {
+	router *localRouter
+	id     int32
+}
+
+type localRouter struct {
+	sync.Mutex
+	listeners map[int32]h.Listener
+	// drop tells whether to drop a packet sent to the given id
+	drop func(to int32, p *h.Packet) bool
+}
+
+func (r *localRouter) network(id int32) *localNetwork {
+	return &localNetwork{router: r, id: id}
+}
+
+func (n *localNetwork) RegisterListener(l h.Listener) {
+	n.router.Lock()
+	defer n.router.Unlock()
+	n.router.listeners[n.id] = l
+}
+
+func (n *localNetwork) Send(ids []h.Identity, p *h.Packet) {
+	n.router.Lock()
+	defer n.router.Unlock()
+	for _, id := range ids {
+		if n.router.drop != nil && n.router.drop(id.ID(), p) {
+			continue
+		}
+		if l, ok := n.router.listeners[id.ID()]; ok {
+			go l.NewPacket(p)
+		}
+	}
+}
+
+func collectorSetup(t *testing.T, n int) ([]lib.SecretKey, h.Registry, lib.Constructor) {
+	cons := lib.NewCurveConstructor("bn256/cf")
+	sks := make([]lib.SecretKey, n)
+	ids := make([]h.Identity, n)
+	for i := range ids {
+		sk, pk := cons.KeyPair(rand.Reader)
+		sks[i] = sk
+		ids[i] = h.NewStaticIdentity(int32(i), "", pk)
+	}
+	return sks, h.NewArrayRegistry(ids), cons
+}
+
+func TestCollector(t *testing.T) {
+	n := 8
+	msg := []byte("collector")
+	sks, reg, cons := collectorSetup(t, n)
+	router := &localRouter{listeners: make(map[int32]h.Listener)}
+	// the first packet of each sender is lost
+	lost := make(map[int32]bool)
+	router.drop = func(to int32, p *h.Packet) bool {
+		if p.Level != collectorSigLevel || lost[p.Origin] {
+			return false
+		}
+		lost[p.Origin] = true
+		return true
+	}
+
+	own, err := sks[0].Sign(msg, nil)
+	require.NoError(t, err)
+	c := newCollector(router.network(0), reg, cons.Handel(), 0, own, msg, n)
+	defer c.Stop()
+	router.network(0).RegisterListener(c)
+
+	var wg sync.WaitGroup
+	senders := make([]*sender, n)
+	for i := 1; i < n; i++ {
+		sig, err := sks[i].Sign(msg, nil)
+		require.NoError(t, err)
+		buff, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		collectorID, _ := reg.Identity(0)
+		net := router.network(int32(i))
+		senders[i] = newSender(net, collectorID, int32(i), buff)
+		net.RegisterListener(senders[i])
+		wg.Add(1)
+		go func(s *sender) {
+			defer wg.Done()
+			require.True(t, s.Run(10*time.Millisecond, time.After(5*time.Second)))
+		}(senders[i])
+	}
+
+	var ms h.MultiSignature
+	select {
+	case ms = <-c.FinalSignature():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no final signature")
+	}
+	wg.Wait()
+	require.Equal(t, n, ms.Cardinality())
+	require.Equal(t, reg.Size(), ms.BitLength())
+	require.NoError(t, h.VerifyMultiSignature(msg, &ms, reg, cons.Handel()))
+	for _, s := range senders[1:] {
+		require.True(t, s.Values()["sent"] >= 2)
+		require.True(t, s.Values()["ackLatency"] > 0)
+	}
+	require.Equal(t, float64(n-1), c.Values()["sigCheckedCt"])
+	require.Equal(t, 0.0, c.Values()["sigInvalid"])
+}
+
+func TestCollectorInvalid(t *testing.T) {
+	n := 4
+	msg := []byte("collector")
+	sks, reg, cons := collectorSetup(t, n)
+	router := &localRouter{listeners: make(map[int32]h.Listener)}
+	own, err := sks[0].Sign(msg, nil)
+	require.NoError(t, err)
+	c := newCollector(router.network(0), reg, cons.Handel(), 0, own, msg, 3)
+	defer c.Stop()
+
+	packet := func(origin int, sk lib.SecretKey, msg []byte) *h.Packet {
+		sig, err := sk.Sign(msg, nil)
+		require.NoError(t, err)
+		buff, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		return &h.Packet{Origin: int32(origin), Level: collectorSigLevel, IndividualSig: buff}
+	}
+	// signed by another key, over another message, from outside the
+	// registry: none is aggregated
+	c.handle(packet(1, sks[2], msg))
+	c.handle(packet(1, sks[1], []byte("another message")))
+	c.handle(packet(n, sks[1], msg))
+	c.handle(&h.Packet{Origin: 2, Level: collectorSigLevel, IndividualSig: []byte{1, 2, 3}})
+	require.Equal(t, 4.0, c.Values()["sigInvalid"])
+	select {
+	case <-c.FinalSignature():
+		t.Fatal("threshold reached with invalid signatures")
+	default:
+	}
+
+	c.handle(packet(1, sks[1], msg))
+	c.handle(packet(1, sks[1], msg))
+	c.handle(packet(3, sks[3], msg))
+	require.Equal(t, 1.0, c.Values()["sigDuplicated"])
+	ms := <-c.FinalSignature()
+	require.Equal(t, 3, ms.Cardinality())
+	require.False(t, ms.Get(2))
+	require.NoError(t, h.VerifyMultiSignature(msg, &ms, reg, cons.Handel()))
+}
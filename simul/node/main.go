@@ -4,27 +4,44 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network/udp"
+	"github.com/ConsenSys/handel/perf"
 	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/logs"
 	"github.com/ConsenSys/handel/simul/monitor"
 )
 
 // BeaconTimeout represents how much time do we wait to receive the beacon
 const BeaconTimeout = 10 * time.Minute
 
+// StatusLogPeriod is the period at which each node logs its progress, so the
+// logs of the nodes contain breadcrumbs of the run
+const StatusLogPeriod = 10 * time.Second
+
 var configFile = flag.String("config", "", "config file created for the exp.")
 var registryFile = flag.String("registry", "", "registry file based - array registry")
+var registryDigest = flag.String("registry-digest", "", "SHA-256 digest of the registry file - the node refuses to start from a registry of another digest, empty disables the check")
+var curve = flag.String("curve", "", "curve system of the registry - empty means the curve of the run")
 var ids arrayFlags
 
 var run = flag.Int("run", -1, "which RunConfig should we run")
 var master = flag.String("master", "", "master address to synchronize")
 var syncAddr = flag.String("sync", "", "address to listen for master START")
 var monitorAddr = flag.String("monitor", "", "address to send measurements")
+var logSink = flag.String("logsink", "", "address of the log sink to stream logs to")
+var perfIterations = flag.Int("perf", 0, "number of iterations to measure the crypto operations at startup - 0 disables it")
+var tlsDir = flag.String("tls", "", "directory of the certificates of the mutual TLS - overrides the TLSDir of the config")
+var scheduleFile = flag.String("schedule", "", "JSON file of the level timings of a prior run the nodes learn their schedule from with AdaptiveSchedule, and write the timings of this run to - empty disables it")
+var overridesFile = flag.String("overrides", "", "TOML file of the Handel knobs to reload on SIGHUP - empty disables it")
 
 func init() {
 	flag.Var(&ids, "id", "ID to run on this node - can specify multiple -id flags")
@@ -40,109 +57,449 @@ func main() {
 			panic(err)
 		}
 		defer monitor.EndAndCleanup()
+		monitor.SetNodeTag("node-" + ids.String())
 	}
 	// first load the measurement unit if needed
 	// load all needed structures
 	// XXX maybe try with a database-backed registry if loading file in memory is
 	// too much when overloading
 	config := lib.LoadConfig(*configFile)
+	if *tlsDir != "" {
+		config.TLSDir = *tlsDir
+	}
+	var out io.Writer = os.Stdout
 	logger := config.Logger()
+	if *logSink != "" {
+		streamer, err := logs.NewStreamer(*logSink, "node-"+ids.String())
+		if err != nil {
+			panic(err)
+		}
+		defer streamer.Close()
+		// stream the panics of the main routine as well before exiting
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(streamer, "panic: %v\n", r)
+				streamer.Close()
+				panic(r)
+			}
+		}()
+		out = io.MultiWriter(os.Stdout, streamer)
+		logger = config.LoggerTo(out)
+	}
+	// the level of the Handel instances can be raised by the overrides, so
+	// their logger forwards everything
+	handelLogger := logger
+	live := new(liveHandels)
+	if *overridesFile != "" {
+		handelLogger = config.VerboseLoggerTo(out)
+		defer live.watch(*overridesFile, logger)()
+	}
+	tracer := config.NewTracer(logger)
+	if tracer != nil {
+		defer tracer.Close()
+	}
 	runConf := config.Runs[*run]
-	cons := config.NewConstructor()
+	if *curve == "" {
+		*curve = config.GetCurve(&runConf)
+	}
+	cons := lib.NewCurveConstructor(*curve)
+	if *perfIterations > 0 {
+		// record the crypto baseline of this binary along the results
+		profile := perf.MeasureConstructorN(cons.Handel(), func(r io.Reader) (h.SecretKey, h.PublicKey) {
+			return cons.KeyPair(r)
+		}, *perfIterations)
+		for name, value := range profile.Values() {
+			monitor.RecordSingleMeasure("perf_"+name, value)
+		}
+		logger.Info("perf", "measured", "iterations", *perfIterations)
+	}
 	parser := lib.NewCSVParser()
-	nodeList, err := lib.ReadAll(*registryFile, parser, cons)
+	loadOpts := config.RegistryLoad
+	loadOpts.Digest = *registryDigest
+	nodeList, report, err := lib.ReadAllWith(*registryFile, parser, cons, loadOpts)
 	if err != nil {
 		panic(err)
 	}
+	for _, skipped := range report.Skipped {
+		logger.Warn("registry", "skipped", "record", skipped.Error())
+	}
+	// the placeholder nodes have no key and can't run
+	var active arrayFlags
+	for _, id := range ids {
+		if id < nodeList.Size() && nodeList.Node(id).Active {
+			active = append(active, id)
+			continue
+		}
+		logger.Warn("node", id, "registry", "inactive")
+	}
+	if len(active) == 0 {
+		panic("no active node to run in the registry")
+	}
+	ids = active
 	//registry := nodeList.Registry()
 
 	registry := nodeList.Registry()
 
-	// instantiate handel for all specified ids in the flags
-	var handels []*h.ReportHandel
-	for _, id := range ids {
+	churn := runConf.GetChurn(*run)
+	uploads := runConf.GetUploadLimits(*run)
+	reps := runConf.GetRepetitions()
+	// with a shared socket, the sync protocol runs over the network of the
+	// first identity, which Handel listens to for the non-sync packets only
+	var shared *udp.Network
+	if config.SharedSocket {
+		net, ok := config.NewNetwork(nodeList.Node(ids[0]).Identity, registry).(*udp.Network)
+		if !ok {
+			panic("shared socket is only supported with the udp network")
+		}
+		shared = net
+		*syncAddr = nodeList.Node(ids[0]).Address()
+	}
+	handelConfig := func(id int) *h.Config {
+		// Setup report handel and the id of the logger
+		hconf := runConf.GetHandelConfig()
+		hconf.Logger = handelLogger
+		hconf.LogLevel = config.LogLevel()
+		hconf.PortPerLevel = config.PortPerLevel
+		hconf.StatusLogPeriod = StatusLogPeriod
+		hconf.Rand = lib.NewSeededReader(runConf.NodeSeed(lib.SeedPartitioner, id))
+		if tracer != nil && config.Tracing.Sampled(int32(id)) {
+			hconf.Tracer = tracer
+		}
+		return hconf
+	}
+	// each identity keeps its network and its session across the
+	// repetitions, the session runs one aggregation per repetition
+	newNetwork := func(id int) h.Network {
 		node := nodeList.Node(id)
-		network := config.NewNetwork(node.Identity)
-
+		var network h.Network
+		if shared != nil && id == ids[0] {
+			network = shared.Filtered(func(p *h.Packet) bool { return !lib.IsSyncPacket(p) })
+		} else {
+			network = config.NewNetwork(node.Identity, registry)
+		}
+		network = config.Encrypt(network, node, registry)
+		if kbps, limited := uploads[id]; limited {
+			bw := runConf.Bandwidth
+			network = lib.NewShapedNetwork(network, config.NewEncoding(), kbps, bw.GetBurst(), bw.Drop)
+			logger.Info("node", id, "upload_kbps", kbps)
+		}
+		return network
+	}
+	newSyncer := func() *lib.SyncSlave {
+		if shared != nil {
+			return lib.NewSyncSlaveOn(shared, *syncAddr, *master, ids)
+		}
+		return lib.NewSyncSlave(*syncAddr, *master, ids)
+	}
+	if runConf.GetProtocol() == lib.ProtocolCollector {
+		r := &collectorRun{
+			runConf:    runConf,
+			run:        *run,
+			nodeList:   nodeList,
+			registry:   registry,
+			cons:       cons.Handel(),
+			ids:        ids,
+			maxTimeout: config.GetMaxTimeout(),
+			logger:     logger,
+			newNetwork: newNetwork,
+			newSyncer:  newSyncer,
+		}
+		r.Run()
+		return
+	}
+	newSession := func(id int) *h.Session {
+		node := nodeList.Node(id)
+		return h.NewSession(newNetwork(id), registry, node.Identity, cons.Handel(), handelConfig(id))
+	}
+	sessions := make([]*h.Session, len(ids))
+	// the advisor learns from the repetitions of all the identities of the
+	// process - see RunConfig.AdaptiveSchedule
+	adaptive := runConf.AdaptiveSchedule && reps > 1
+	advisor := h.NewScheduleAdvisor(h.DefaultAdvisorPercentile, h.DefaultAdvisorLead)
+	if adaptive && *scheduleFile != "" {
+		loadSchedule(advisor, *scheduleFile, logger)
+	}
+	// the schedule learned from the previous repetitions, nil while cold
+	var learned h.LevelActivationSchedule
+	newHandel := func(i, rep int) *h.ReportHandel {
+		id := ids[i]
+		if sessions[i] == nil {
+			sessions[i] = newSession(id)
+		}
 		// make the signature
-		signature, err := node.Sign(lib.Message, nil)
+		msg := runConf.RepetitionMessage(*run, rep)
+		signature, err := nodeList.Node(id).Sign(msg, nil)
 		if err != nil {
 			panic(err)
 		}
-		// Setup report handel and the id of the logger
-		config := runConf.GetHandelConfig()
-		config.Logger = logger
-		handel := h.NewHandel(network, registry, node.Identity, cons.Handel(), lib.Message, signature, config)
-		reporter := h.NewReportHandel(handel)
-		handels = append(handels, reporter)
+		hconf := handelConfig(id)
+		if learned != nil {
+			hconf.NewTimeoutStrategy = h.ScheduledTimeoutConstructor(learned)
+		}
+		handel, err := sessions[i].NewAggregation(msg, signature, hconf)
+		if err != nil {
+			panic(err)
+		}
+		live.add(handel)
+		recordConfig(logger, id, handel.EffectiveConfig())
+		return h.NewReportHandel(handel)
 	}
 
-	// Sync with master - wait for the START signal
-	syncer := lib.NewSyncSlave(*syncAddr, *master, ids)
-	syncer.SignalAll(lib.START)
-	select {
-	case <-syncer.WaitMaster(lib.START):
-		logger.Debug("sync", "finished", "nodes", ids.String())
-	case <-time.After(BeaconTimeout):
-		logger.Error("Haven't received beacon in time!")
-		panic("Haven't received beacon in time!")
+	// instantiate handel for all specified ids in the flags - late nodes only
+	// join the network once their delay has elapsed, in each repetition
+	newHandels := func(rep int) []*h.ReportHandel {
+		handels := make([]*h.ReportHandel, len(ids))
+		for i, id := range ids {
+			if churn.Late[id] {
+				continue
+			}
+			handels[i] = newHandel(i, rep)
+		}
+		return handels
 	}
-	logger.Debug("nodes", ids.String(), "sync", "finished")
+	learnSchedule := func() {
+		if !adaptive || advisor.Results() == 0 {
+			return
+		}
+		learned = advisor.Schedule(runConf.GetLevelSchedule())
+		for _, level := range advisor.Levels() {
+			at, _ := advisor.Activation(level)
+			monitor.RecordSingleMeasure(fmt.Sprintf("schedule_level%d", level), float64(at)/float64(time.Millisecond))
+		}
+	}
+	learnSchedule()
+	handels := newHandels(0)
+	// the signature generation time of each identity on the schedule of the
+	// config, the baseline of the learned one
+	coldSigen := make([]time.Duration, len(ids))
+
+	// Sync with master - wait for the START signal
+	syncer := newSyncer()
+	defer syncer.Stop()
+	skew := syncStart(syncer, ids, logger)
+
+	for rep := 0; rep < reps; rep++ {
+		// the aggregations of the previous repetition are closed once all
+		// the nodes passed its barrier
+		if rep > 0 {
+			learnSchedule()
+			handels = newHandels(rep)
+		}
+		adapted := learned != nil
+		if reps > 1 {
+			monitor.SetRepetitionTag(rep + 1)
+			logger.Info("nodes", ids.String(), "repetition", rep+1)
+		}
+		// the last repetition ends with the run
+		barrier := lib.END
+		if rep < reps-1 {
+			barrier = lib.RepetitionState(rep)
+		}
+		msg := runConf.RepetitionMessage(*run, rep)
 
-	// Start all handels and run a timeout on the signature generation time
-	var wg sync.WaitGroup
-	for i := range handels {
-		wg.Add(1)
-		go func(j int) {
-			handel := handels[j]
-			id := ids[j]
-			signatureGen := monitor.NewTimeMeasure("sigen")
-			netMeasure := monitor.NewCounterMeasure("net", handel.Network())
-			storeMeasure := monitor.NewCounterMeasure("store", handel.Store())
-			processingMeasure := monitor.NewCounterMeasure("sigs", handel.Processing())
-			go handel.Start()
-			// Wait for final signatures !
-			enough := false
-			var sig h.MultiSignature
-			for !enough {
-				select {
-				case sig = <-handel.FinalSignatures():
-					if sig.BitSet.Cardinality() >= runConf.Threshold {
+		// Start all handels and run a timeout on the signature generation time
+		var wg sync.WaitGroup
+		for i := range handels {
+			wg.Add(1)
+			go func(j, rep int) {
+				handel := handels[j]
+				id := ids[j]
+				if handel == nil {
+					time.Sleep(churn.Delay)
+					logger.Info("node", id, "churn", "late_start")
+					handel = newHandel(j, rep)
+					handels[j] = handel
+				}
+				var stop <-chan time.Time
+				if churn.Early[id] {
+					stop = time.After(churn.After)
+				}
+				signatureGen := monitor.NewTimeMeasure("sigen")
+				begin := time.Now()
+				netMeasure := monitor.NewCounterMeasure("net", handel.Network())
+				storeMeasure := monitor.NewCounterMeasure("store", handel.Store())
+				processingMeasure := monitor.NewCounterMeasure("sigs", handel.Processing())
+				timings := newTimings(skew, monitor.RecordSingleMeasure)
+				handel.RegisterActor("timings", timings)
+				go handel.Start()
+				// Wait for final signatures !
+				enough := false
+				stopped := false
+				var sig h.MultiSignature
+				for !enough {
+					select {
+					case sig = <-handel.FinalSignatures():
+						if sig.BitSet.Cardinality() >= runConf.GetThreshold() {
+							timings.threshold()
+							enough = true
+							wg.Done()
+							logger.Info("FINISHED", id, "sig", fmt.Sprintf("%d/%d",
+								sig.Cardinality(), runConf.GetThreshold()))
+							break
+						}
+					case <-stop:
+						handel.Close()
 						enough = true
+						stopped = true
 						wg.Done()
-						logger.Info("FINISHED", id, "sig", fmt.Sprintf("%d/%d",
-							sig.Cardinality(), runConf.Threshold))
-						break
+						logger.Info("node", id, "churn", "early_stop")
+					case <-time.After(config.GetMaxTimeout()):
+						panic("max timeout")
 					}
-				case <-time.After(config.GetMaxTimeout()):
-					panic("max timeout")
 				}
+				if stopped {
+					// the node left before completing, only its result is measured
+					handel.Close()
+					recordResult(handel.Result())
+					syncer.Signal(barrier, id)
+					return
+				}
+				netMeasure.Record()
+				storeMeasure.Record()
+				signatureGen.Record()
+				if sigen := time.Since(begin); !adapted {
+					coldSigen[j] = sigen
+				} else if coldSigen[j] > 0 {
+					// how much sooner the learned schedule produced the
+					// signature
+					monitor.RecordSingleMeasure("sigen_gain", float64(coldSigen[j]-sigen)/float64(time.Millisecond))
+				}
+				processingMeasure.Record()
+				// fraction of the verified signatures that grew the aggregate
+				monitor.RecordSingleMeasure("useful_sig_ratio", handel.Efficiency().UsefulRatio())
+				logger.Info("node", id, "sigen", "finished")
+
+				if err := h.VerifyRotatedMultiSignature(msg, &sig, handel.PreviousKeys(), registry, cons.Handel()); err != nil {
+					panic("signature invalid !!")
+				}
+				// recorded before the barrier, as the platforms stop
+				// collecting the measures at the END
+				recordResult(handel.CurrentResult())
+				syncer.Signal(barrier, id)
+			}(i, rep)
+		}
+		wg.Wait()
+		if rep == reps-1 {
+			logger.Info("simul", "finished")
+			monitor.NewResourceMeasure("resources_end").Record()
+		}
+
+		// Sync with master - wait to close our node, or to start the next
+		// repetition
+		waitMaster(syncer, barrier, logger)
+
+		// the nodes kept helping the others until now: their result is final
+		// once closed
+		for i, handel := range handels {
+			if churn.Early[ids[i]] {
+				continue
 			}
-			netMeasure.Record()
-			storeMeasure.Record()
-			signatureGen.Record()
-			processingMeasure.Record()
-			logger.Info("node", id, "sigen", "finished")
-
-			if err := h.VerifyMultiSignature(lib.Message, &sig, registry, cons.Handel()); err != nil {
-				panic("signature invalid !!")
-			}
-			syncer.Signal(lib.END, id)
-		}(i)
+			handel.Close()
+			advisor.Add(handel.Result())
+		}
 	}
-	wg.Wait()
-	logger.Info("simul", "finished")
+	if adaptive && *scheduleFile != "" {
+		saveSchedule(advisor, *scheduleFile, logger)
+	}
+	for _, session := range sessions {
+		if session != nil {
+			session.Close()
+		}
+	}
+}
+
+// syncStart signals the master the identities of the node are ready and waits
+// for its START. It returns the offset of our clock to the clock of the
+// master, to correct the timings, and records it with the resources of the
+// process at the start.
+func syncStart(syncer *lib.SyncSlave, ids arrayFlags, logger h.Logger) lib.Skew {
+	syncer.SignalAll(lib.START)
+	waitMaster(syncer, lib.START, logger)
+	logger.Debug("nodes", ids.String(), "sync", "finished")
+	skew := syncer.Skew()
+	logger.Info("nodes", ids.String(), "clock_offset", skew.Offset, "rtt", skew.RTT, "samples", skew.Samples)
+	monitor.RecordSingleMeasure("sync_offset", float64(skew.Offset)/float64(time.Millisecond))
+	monitor.RecordSingleMeasure("sync_rtt", float64(skew.RTT)/float64(time.Millisecond))
+	// resources of the process, the difference with the end gives the
+	// resources used by the run
+	monitor.NewResourceMeasure("resources_start").Record()
+	return skew
+}
 
-	// Sync with master - wait to close our node
+// waitMaster waits for the master to release the state, and panics if it
+// does not before the BeaconTimeout
+func waitMaster(syncer *lib.SyncSlave, state int, logger h.Logger) {
 	select {
-	case <-syncer.WaitMaster(lib.END):
-		logger.Debug("sync", "finished", "nodes", ids.String())
+	case <-syncer.WaitMaster(state):
+		logger.Debug("sync", "finished", "state", state)
 	case <-time.After(BeaconTimeout):
 		logger.Error("Haven't received beacon in time!")
 		panic("Haven't received beacon in time!")
 	}
 }
 
+// recordResult records the values of the result of a Handel, along with its
+// other measures.
+func recordResult(r h.AggregationResult) {
+	for name, value := range r.Values() {
+		monitor.RecordSingleMeasure("result_"+name, value)
+	}
+}
+
+// loadSchedule adds the level timings of the file to the advisor, if it
+// exists
+func loadSchedule(advisor *h.ScheduleAdvisor, path string, logger h.Logger) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		logger.Info("schedule", path, "records", "none")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := advisor.Load(f); err != nil {
+		panic(err)
+	}
+	logger.Info("schedule", path, "records", advisor.Results())
+}
+
+// saveSchedule writes the level timings of the advisor to the file, for a
+// later run to learn from
+func saveSchedule(advisor *h.ScheduleAdvisor, path string, logger h.Logger) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := advisor.Save(f); err != nil {
+		panic(err)
+	}
+	logger.Info("schedule", path, "records", advisor.Results())
+}
+
+// recordConfig logs the effective config of the Handel of the node, and
+// records its fingerprint so the results tell whether all the nodes ran the
+// same config: its first 48 bits fit in the float of a measure.
+func recordConfig(logger h.Logger, id int, c h.Config) {
+	fingerprint := c.Fingerprint()
+	desc := c.Describe()
+	names := make([]string, 0, len(desc))
+	for name := range desc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kv := []interface{}{"node", id, "config_fingerprint", fingerprint}
+	for _, name := range names {
+		kv = append(kv, name, desc[name])
+	}
+	logger.Info(kv...)
+	prefix, err := strconv.ParseUint(fingerprint[:12], 16, 64)
+	if err != nil {
+		panic(err)
+	}
+	monitor.RecordSingleMeasure("config_fingerprint", float64(prefix))
+}
+
 type arrayFlags []int
 
 func (i *arrayFlags) String() string {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	h "github.com/ConsenSys/handel"
+)
+
+// overrides are the knobs of Handel which can change during a run. The node
+// reads them from the TOML file of the -overrides flag when it receives
+// SIGHUP, for instance:
+//
+//	LogLevel = "debug"
+//	StatusLogPeriod = "2s"
+//	QueueCapacity = 500
+//
+// A knob missing from the file is left unchanged.
+type overrides struct {
+	LogLevel        *h.LogLevel
+	StatusLogPeriod *time.Duration
+	QueueCapacity   *int
+}
+
+// readOverrides returns the overrides of the file and the keys it rejects,
+// which are not knobs. It fails if the file is malformed or a value invalid.
+func readOverrides(path string) (*overrides, []string, error) {
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return nil, nil, err
+	}
+	o := new(overrides)
+	var rejected []string
+	for key, value := range values {
+		switch key {
+		case "LogLevel":
+			name, ok := value.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: %v is not a string", key, value)
+			}
+			level, err := h.ParseLogLevel(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", key, err)
+			}
+			o.LogLevel = &level
+		case "StatusLogPeriod":
+			s, ok := value.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: %v is not a string", key, value)
+			}
+			period, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", key, err)
+			}
+			if period < 0 {
+				return nil, nil, fmt.Errorf("%s: negative period %s", key, period)
+			}
+			o.StatusLogPeriod = &period
+		case "QueueCapacity":
+			n, ok := value.(int64)
+			if !ok || n < 0 {
+				return nil, nil, fmt.Errorf("%s: %v is not a positive integer", key, value)
+			}
+			capacity := int(n)
+			o.QueueCapacity = &capacity
+		default:
+			rejected = append(rejected, key)
+		}
+	}
+	sort.Strings(rejected)
+	return o, rejected, nil
+}
+
+// liveHandels holds the Handel instances running on the node, which the
+// overrides apply to
+type liveHandels struct {
+	sync.Mutex
+	handels []*h.Handel
+}
+
+func (l *liveHandels) add(handel *h.Handel) {
+	l.Lock()
+	defer l.Unlock()
+	l.handels = append(l.handels, handel)
+}
+
+// reload reads the overrides file and applies it to all the instances, and
+// returns the changes as "knob: old -> new". The rejected keys are logged as
+// warnings. Nothing changes if the file is malformed.
+func (l *liveHandels) reload(path string, logger h.Logger) ([]string, error) {
+	o, rejected, err := readOverrides(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range rejected {
+		logger.Warn("overrides", path, "rejected", key)
+	}
+	l.Lock()
+	defer l.Unlock()
+	if len(l.handels) == 0 {
+		return nil, errors.New("no Handel running")
+	}
+	// all the instances run with the same knobs, the first one gives the
+	// previous values
+	first := l.handels[0]
+	var diff []string
+	if o.LogLevel != nil {
+		diff = append(diff, fmt.Sprintf("LogLevel: %s -> %s", first.LogLevel(), *o.LogLevel))
+	}
+	if o.StatusLogPeriod != nil {
+		diff = append(diff, fmt.Sprintf("StatusLogPeriod: %s -> %s", first.StatusLogPeriod(), *o.StatusLogPeriod))
+	}
+	if o.QueueCapacity != nil {
+		diff = append(diff, fmt.Sprintf("QueueCapacity: %d -> %d", first.QueueCapacity(), *o.QueueCapacity))
+	}
+	for _, handel := range l.handels {
+		if o.LogLevel != nil {
+			handel.SetLogLevel(*o.LogLevel)
+		}
+		if o.StatusLogPeriod != nil {
+			handel.SetStatusLogPeriod(*o.StatusLogPeriod)
+		}
+		if o.QueueCapacity != nil && !handel.SetQueueCapacity(*o.QueueCapacity) {
+			logger.Warn("overrides", path, "QueueCapacity", "unsupported by the processing")
+		}
+	}
+	return diff, nil
+}
+
+// watch reloads the overrides file at each SIGHUP until the returned function
+// is called
+func (l *liveHandels) watch(path string, logger h.Logger) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				diff, err := l.reload(path, logger)
+				if err != nil {
+					logger.Warn("overrides", path, "err", err)
+					continue
+				}
+				for _, change := range diff {
+					logger.Info("overrides", path, "applied", change)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
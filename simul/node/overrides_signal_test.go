@@ -0,0 +1,49 @@
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverridesSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overrides")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cluster := handeltest.NewCluster(4, nil)
+	live := new(liveHandels)
+	for _, handel := range cluster.Handels {
+		live.add(handel)
+	}
+	logger := new(recordLogger)
+	path := writeOverrides(t, dir, `LogLevel = "error"`)
+	stop := live.watch(path, logger)
+	defer stop()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	require.True(t, logger.eventually(func() bool {
+		infos, _ := logger.logged()
+		return len(infos) == 1
+	}))
+	for _, handel := range cluster.Handels {
+		require.Equal(t, h.LogError, handel.LogLevel())
+	}
+
+	// a bad file is rejected with a warning
+	writeOverrides(t, dir, `LogLevel = `)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	require.True(t, logger.eventually(func() bool {
+		_, warns := logger.logged()
+		return len(warns) == 1
+	}))
+	for _, handel := range cluster.Handels {
+		require.Equal(t, h.LogError, handel.LogLevel())
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// recordLogger records the statements logged at the info and warn levels
+type recordLogger struct {
+	sync.Mutex
+	infos []string
+	warns []string
+	// signaled on each statement recorded, see eventually
+	changed chan bool
+}
+
+func (r *recordLogger) Info(kv ...interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.infos = append(r.infos, fmt.Sprint(kv...))
+	r.signal()
+}
+
+func (r *recordLogger) Warn(kv ...interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.warns = append(r.warns, fmt.Sprint(kv...))
+	r.signal()
+}
+
+// signal notifies a statement was recorded. The lock must be held.
+func (r *recordLogger) signal() {
+	select {
+	case r.changes() <- true:
+	default:
+	}
+}
+
+// changes returns the channel signaled on the statements recorded. The lock
+// must be held.
+func (r *recordLogger) changes() chan bool {
+	if r.changed == nil {
+		r.changed = make(chan bool, 1)
+	}
+	return r.changed
+}
+
+func (r *recordLogger) Debug(kv ...interface{})         {}
+func (r *recordLogger) Error(kv ...interface{})         {}
+func (r *recordLogger) With(kv ...interface{}) h.Logger { return r }
+
+func (r *recordLogger) logged() ([]string, []string) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.infos...), append([]string{}, r.warns...)
+}
+
+// eventually returns true once the condition on the statements recorded
+// holds, false if it doesn't within a second
+func (r *recordLogger) eventually(cond func() bool) bool {
+	r.Lock()
+	changed := r.changes()
+	r.Unlock()
+	timeout := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-changed:
+		case <-timeout:
+			return cond()
+		}
+	}
+	return true
+}
+
+func writeOverrides(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "overrides.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestOverridesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overrides")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cluster := handeltest.NewCluster(4, nil)
+	live := new(liveHandels)
+	for _, handel := range cluster.Handels {
+		live.add(handel)
+	}
+	logger := new(recordLogger)
+
+	path := writeOverrides(t, dir, `
+LogLevel = "warn"
+StatusLogPeriod = "2s"
+QueueCapacity = 500
+Contributions = 2
+`)
+	diff, err := live.reload(path, logger)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"LogLevel: debug -> warn",
+		"StatusLogPeriod: 0s -> 2s",
+		"QueueCapacity: 0 -> 500",
+	}, diff)
+	for _, handel := range cluster.Handels {
+		require.Equal(t, h.LogWarn, handel.LogLevel())
+		require.Equal(t, 2*time.Second, handel.StatusLogPeriod())
+		require.Equal(t, 500, handel.QueueCapacity())
+	}
+	_, warns := logger.logged()
+	require.Len(t, warns, 1)
+	require.Contains(t, warns[0], "Contributions")
+
+	// a missing knob is left unchanged
+	path = writeOverrides(t, dir, `LogLevel = "info"`)
+	diff, err = live.reload(path, logger)
+	require.NoError(t, err)
+	require.Equal(t, []string{"LogLevel: warn -> info"}, diff)
+	require.Equal(t, 2*time.Second, cluster.Handels[0].StatusLogPeriod())
+
+	// nothing changes with a malformed file or an invalid value
+	for i, content := range []string{
+		`LogLevel = "debug`,
+		"LogLevel = \"debug\"\nQueueCapacity = -1",
+		"LogLevel = \"debug\"\nStatusLogPeriod = 12",
+		`LogLevel = "verbose"`,
+	} {
+		t.Logf(" -- test %d -- ", i)
+		_, err = live.reload(writeOverrides(t, dir, content), logger)
+		require.Error(t, err)
+		for _, handel := range cluster.Handels {
+			require.Equal(t, h.LogInfo, handel.LogLevel())
+			require.Equal(t, 500, handel.QueueCapacity())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+)
+
+// timings records the times of the events of a Handel meant to be compared
+// across the nodes: the completion of each level and the threshold. Each time
+// is recorded in unix milliseconds both raw, from the local clock, and
+// corrected by the offset of the clock to the master - see lib.Skew.
+type timings struct {
+	sync.Mutex
+	skew   lib.Skew
+	now    func() time.Time
+	record func(name string, value float64)
+	// union of the verified signatures of each level not completed yet
+	levels    map[byte]h.BitSet
+	completed map[byte]bool
+}
+
+func newTimings(skew lib.Skew, record func(string, float64)) *timings {
+	return &timings{
+		skew:      skew,
+		now:       time.Now,
+		record:    record,
+		levels:    make(map[byte]h.BitSet),
+		completed: make(map[byte]bool),
+	}
+}
+
+// OnVerifiedSignature implements the handel.Actor interface, recording the
+// completion time of the level of the signature
+func (t *timings) OnVerifiedSignature(s *h.VerifiedSignature) {
+	if s.Level == 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.completed[s.Level] {
+		return
+	}
+	bs, ok := t.levels[s.Level]
+	if !ok {
+		bs = s.MultiSig.BitSet
+	} else {
+		bs = bs.Or(s.MultiSig.BitSet)
+	}
+	t.levels[s.Level] = bs
+	if !bs.All() {
+		return
+	}
+	t.completed[s.Level] = true
+	delete(t.levels, s.Level)
+	t.at("level"+strconv.Itoa(int(s.Level)), t.now())
+}
+
+// threshold records the time the threshold was reached
+func (t *timings) threshold() {
+	t.at("threshold", t.now())
+}
+
+func (t *timings) at(name string, when time.Time) {
+	t.record("timing_"+name+"_raw", unixMillis(when))
+	t.record("timing_"+name, unixMillis(t.skew.Correct(when)))
+}
+
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
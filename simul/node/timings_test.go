@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	h "github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+func verified(level byte, size int, bits ...int) *h.VerifiedSignature {
+	bs := h.NewWilffBitset(size)
+	for _, b := range bits {
+		bs.Set(b, true)
+	}
+	return &h.VerifiedSignature{Level: level, MultiSig: &h.MultiSignature{BitSet: bs}}
+}
+
+func TestTimings(t *testing.T) {
+	records := make(map[string]float64)
+	skew := lib.Skew{Offset: -30 * time.Millisecond}
+	timings := newTimings(skew, func(name string, value float64) { records[name] = value })
+	now := time.Unix(100, 0)
+	timings.now = func() time.Time { return now }
+
+	timings.OnVerifiedSignature(verified(0, 1, 0))
+	timings.OnVerifiedSignature(verified(2, 2, 0))
+	require.Empty(t, records)
+	// the level completes with the union of its signatures
+	timings.OnVerifiedSignature(verified(2, 2, 1))
+	require.Equal(t, map[string]float64{
+		"timing_level2_raw": 100000,
+		"timing_level2":     99970,
+	}, records)
+	now = now.Add(time.Second)
+	timings.OnVerifiedSignature(verified(2, 2, 0, 1))
+	require.Equal(t, 100000.0, records["timing_level2_raw"])
+
+	timings.threshold()
+	require.Equal(t, 101000.0, records["timing_threshold_raw"])
+	require.Equal(t, 100970.0, records["timing_threshold"])
+}
@@ -55,18 +55,26 @@ func (*neighbor) Connect(node Node, reg handel.Registry, max int) error {
 	return nil
 }
 
-type random struct{}
+type random struct {
+	seed int64
+}
 
-// NewRandomConnector returns a Connector that connects nodes randomly
-func NewRandomConnector() Connector { return &random{} }
+// NewRandomConnector returns a Connector that connects nodes randomly. Each
+// node draws its peers from the seed and its own id, so the same seed gives
+// the same overlay.
+func NewRandomConnector(seed int64) Connector { return &random{seed: seed} }
 
-func (*random) Connect(node Node, reg handel.Registry, max int) error {
+func (r *random) Connect(node Node, reg handel.Registry, max int) error {
 	n := reg.Size()
 	own := node.Identity().ID()
+	if max > n-1 {
+		max = n - 1
+	}
+	rnd := rand.New(rand.NewSource(r.seed + int64(own)))
 	var ids []int32
 	fmt.Println("connection of ", own)
 	for len(ids) < max {
-		identity, ok := reg.Identity(rand.Intn(n))
+		identity, ok := reg.Identity(rnd.Intn(n))
 		if !ok {
 			return errors.New("invalid index")
 		}
@@ -87,6 +95,7 @@ func (*random) Connect(node Node, reg handel.Registry, max int) error {
 		}
 
 		fmt.Printf(" %d connects to %d", own, identity.ID())
+		ids = append(ids, identity.ID())
 		if err := node.Connect(identity); err != nil {
 			fmt.Println(node.Identity().ID(), "error connecting to ", identity.ID(), ":", err)
 			continue
@@ -96,7 +105,12 @@ func (*random) Connect(node Node, reg handel.Registry, max int) error {
 	return nil
 }
 
-// ExtractConnector returns connector
+// ExtractConnector returns the connector given by the Connector opt,
+// "neighbor" by default, "random" or "topology", and the maximum number of
+// connections given by the Count opt. The random connector draws the peers
+// from the Seed opt. The topology connector reads the file
+// given by the TopologyFile opt, and checks the graph is connected if the
+// TopologyConnected opt is 1 - see NewTopologyConnector.
 func ExtractConnector(opts Opts) (Connector, int) {
 	c, exists := opts.String("Connector")
 	if !exists {
@@ -112,8 +126,17 @@ func ExtractConnector(opts Opts) (Connector, int) {
 		con = NewNeighborConnector()
 		fmt.Println(" selecting NEIGHBOR connector with ", count)
 	case "random":
-		con = NewRandomConnector()
+		seed, _ := opts.Int64("Seed")
+		con = NewRandomConnector(seed)
 		fmt.Println(" selecting RANDOM connector with ", count)
+	case "topology":
+		path, exists := opts.String("TopologyFile")
+		if !exists {
+			panic("topology connector without TopologyFile")
+		}
+		connected, _ := opts.Int("TopologyConnected")
+		con = &topologyConnector{path: path, connected: connected != 0}
+		fmt.Println(" selecting TOPOLOGY connector from ", path)
 	}
 	return con, count
 
@@ -0,0 +1,35 @@
+package p2p
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomConnector(t *testing.T) {
+	n, max := 20, 4
+	reg := topologyRegistry(n)
+	overlay := func(seed int64) [][]int {
+		con, count := ExtractConnector(Opts{"Connector": "random", "Count": "4", "Seed": strconv.FormatInt(seed, 10)})
+		require.Equal(t, max, count)
+		var links [][]int
+		for i := 0; i < n; i++ {
+			id, _ := reg.Identity(i)
+			node := &connectNode{id: id}
+			require.NoError(t, con.Connect(node, reg, count))
+			require.Len(t, node.connected, max)
+			require.NotContains(t, node.connected, i)
+			links = append(links, node.connected)
+		}
+		return links
+	}
+	require.Equal(t, overlay(1), overlay(1))
+	require.NotEqual(t, overlay(1), overlay(2))
+
+	// a node can't connect to more peers than the others
+	node := &connectNode{id: handel.NewStaticIdentity(0, "", nil)}
+	require.NoError(t, NewRandomConnector(1).Connect(node, topologyRegistry(3), 5))
+	require.Len(t, node.connected, 2)
+}
@@ -76,8 +76,13 @@ func Run(a Adaptor) {
 	requireNil(err)
 	// transform into lib.Node
 	libNodes, err := toLibNodes(cons, records)
-	registry, p2pNodes := a.Make(ctx, libNodes, Ids, runConf.GetThreshold(), runConf.Extra)
-	aggregators := MakeAggregators(ctx, cons, p2pNodes, registry, runConf.GetThreshold(), runConf.Extra)
+	// the overlay is drawn from the seed of the run
+	opts := Opts{"Seed": strconv.FormatInt(runConf.SubSeed(lib.SeedConnector), 10)}
+	for k, v := range runConf.Extra {
+		opts[k] = v
+	}
+	registry, p2pNodes := a.Make(ctx, libNodes, Ids, runConf.GetThreshold(), opts)
+	aggregators := MakeAggregators(ctx, cons, p2pNodes, registry, runConf.GetThreshold(), opts)
 
 	// Sync with master - wait for the START signal
 	syncer := lib.NewSyncSlave(*SyncAddr, *Master, Ids)
@@ -113,8 +118,8 @@ func Run(a Adaptor) {
 			for !enough {
 				select {
 				case sig = <-agg.FinalMultiSignature():
-					if sig.BitSet.Cardinality() >= runConf.Threshold {
-						//fmt.Printf(" --- NODE %d outputted signature of %d / %d contributions\n", id, sig.BitSet.Cardinality(), runConf.Threshold)
+					if sig.BitSet.Cardinality() >= runConf.GetThreshold() {
+						//fmt.Printf(" --- NODE %d outputted signature of %d / %d contributions\n", id, sig.BitSet.Cardinality(), runConf.GetThreshold())
 						enough = true
 						report <- int(id)
 						wg.Done()
@@ -25,3 +25,16 @@ func (o *Opts) Int(k string) (int, bool) {
 	return i, true
 
 }
+
+// Int64 returns the value stored at the given key converted to an int64
+func (o *Opts) Int64(k string) (int64, bool) {
+	s, e := (*o)[k]
+	if !e {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
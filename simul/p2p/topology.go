@@ -0,0 +1,372 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Topology is the adjacency list of an overlay network: the ids of the peers
+// each node connects to.
+type Topology map[int][]int
+
+// ReadTopology reads the topology file at the path. A file with the .json
+// extension holds a JSON object mapping each id to the list of its peers,
+// e.g. {"0": [1, 2], "1": [0]}. Any other file is read as CSV: one line per
+// node, its id followed by the ids of its peers, the lines starting with #
+// being comments.
+func ReadTopology(path string) (Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if isJSON(path) {
+		t := make(Topology)
+		if err := json.NewDecoder(f).Decode(&t); err != nil {
+			return nil, fmt.Errorf("topology %s: %s", path, err)
+		}
+		return t, nil
+	}
+	t := make(Topology)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var ids []int
+		for _, field := range strings.Split(text, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("topology %s: line %d: %s", path, line, err)
+			}
+			ids = append(ids, id)
+		}
+		if _, ok := t[ids[0]]; ok {
+			return nil, fmt.Errorf("topology %s: line %d: node %d listed twice", path, line, ids[0])
+		}
+		t[ids[0]] = ids[1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("topology %s: %s", path, err)
+	}
+	return t, nil
+}
+
+// WriteTo writes the topology to the path, in the format given by its
+// extension - see ReadTopology.
+func (t Topology) WriteTo(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if isJSON(path) {
+		return json.NewEncoder(f).Encode(t)
+	}
+	w := bufio.NewWriter(f)
+	for _, id := range t.ids() {
+		fields := []string{strconv.Itoa(id)}
+		for _, peer := range t[id] {
+			fields = append(fields, strconv.Itoa(peer))
+		}
+		fmt.Fprintln(w, strings.Join(fields, ","))
+	}
+	return w.Flush()
+}
+
+func isJSON(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".json"
+}
+
+// ids returns the ids of the nodes of the topology, sorted
+func (t Topology) ids() []int {
+	ids := make([]int, 0, len(t))
+	for id := range t {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Validate returns an error if a node or a peer of the topology is not in the
+// registry, or if a node lists itself.
+func (t Topology) Validate(reg handel.Registry) error {
+	n := reg.Size()
+	for _, id := range t.ids() {
+		if id < 0 || id >= n {
+			return fmt.Errorf("topology: node %d not in the registry of %d nodes", id, n)
+		}
+		for _, peer := range t[id] {
+			if peer < 0 || peer >= n {
+				return fmt.Errorf("topology: node %d: peer %d not in the registry of %d nodes", id, peer, n)
+			}
+			if peer == id {
+				return fmt.Errorf("topology: node %d lists itself", id)
+			}
+		}
+	}
+	return nil
+}
+
+// Components returns the connected components of the topology over the n
+// nodes of a registry, its links being used both ways. The components are
+// sorted by their smallest id, and their ids in increasing order.
+func (t Topology) Components(n int) [][]int {
+	links := make([][]int, n)
+	for id, peers := range t {
+		for _, peer := range peers {
+			if id < 0 || id >= n || peer < 0 || peer >= n {
+				continue
+			}
+			links[id] = append(links[id], peer)
+			links[peer] = append(links[peer], id)
+		}
+	}
+	seen := make([]bool, n)
+	var components [][]int
+	for start := 0; start < n; start++ {
+		if seen[start] {
+			continue
+		}
+		seen[start] = true
+		component := []int{start}
+		for i := 0; i < len(component); i++ {
+			for _, peer := range links[component[i]] {
+				if !seen[peer] {
+					seen[peer] = true
+					component = append(component, peer)
+				}
+			}
+		}
+		sort.Ints(component)
+		components = append(components, component)
+	}
+	return components
+}
+
+// CheckConnected returns an error naming the components of the topology over
+// the n nodes of a registry if there are more than one.
+func (t Topology) CheckConnected(n int) error {
+	components := t.Components(n)
+	if len(components) <= 1 {
+		return nil
+	}
+	names := make([]string, len(components))
+	for i, c := range components {
+		names[i] = componentName(c)
+	}
+	return fmt.Errorf("topology: not connected, %d components: %s", len(components), strings.Join(names, ", "))
+}
+
+// componentName lists the first ids of the component and its size
+func componentName(c []int) string {
+	const shown = 5
+	ids := make([]string, 0, shown)
+	for _, id := range c[:min(shown, len(c))] {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	if len(c) > shown {
+		ids = append(ids, "...")
+	}
+	return fmt.Sprintf("{%s} (%d nodes)", strings.Join(ids, " "), len(c))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// SmallWorldTopology returns a Watts-Strogatz graph over n nodes: a ring where
+// each node links to its k/2 closest nodes on each side, each link being
+// rewired to a random node with the probability beta. k must be even and
+// lower than n.
+func SmallWorldTopology(n, k int, beta float64, seed int64) (Topology, error) {
+	if k <= 0 || k%2 != 0 || k >= n {
+		return nil, fmt.Errorf("small world: k=%d must be even, positive and lower than n=%d", k, n)
+	}
+	if beta < 0 || beta > 1 {
+		return nil, fmt.Errorf("small world: beta=%f not in [0, 1]", beta)
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	g := newGraph(n)
+	for id := 0; id < n; id++ {
+		for j := 1; j <= k/2; j++ {
+			g.link(id, (id+j)%n)
+		}
+	}
+	for j := 1; j <= k/2; j++ {
+		for id := 0; id < n; id++ {
+			peer := (id + j) % n
+			if rnd.Float64() >= beta || len(g.links[id]) >= n-1 {
+				continue
+			}
+			other := rnd.Intn(n)
+			for other == id || g.links[id][other] {
+				other = rnd.Intn(n)
+			}
+			g.unlink(id, peer)
+			g.link(id, other)
+		}
+	}
+	return g.topology(), nil
+}
+
+// ScaleFreeTopology returns a Barabasi-Albert graph over n nodes: starting
+// from m+1 nodes all linked together, each new node links to m distinct nodes
+// picked with a probability proportional to their degree. m must be positive
+// and lower than n.
+func ScaleFreeTopology(n, m int, seed int64) (Topology, error) {
+	if m <= 0 || m >= n {
+		return nil, fmt.Errorf("scale free: m=%d must be positive and lower than n=%d", m, n)
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	g := newGraph(n)
+	// each node appears once per link: a uniform pick among them is
+	// proportional to the degree
+	var ends []int
+	for id := 0; id <= m; id++ {
+		for peer := id + 1; peer <= m; peer++ {
+			g.link(id, peer)
+			ends = append(ends, id, peer)
+		}
+	}
+	for id := m + 1; id < n; id++ {
+		picked := make(map[int]bool, m)
+		var targets []int
+		for len(targets) < m {
+			target := ends[rnd.Intn(len(ends))]
+			if !picked[target] {
+				picked[target] = true
+				targets = append(targets, target)
+			}
+		}
+		for _, target := range targets {
+			g.link(id, target)
+			ends = append(ends, id, target)
+		}
+	}
+	return g.topology(), nil
+}
+
+// WriteSmallWorldTopology writes a SmallWorldTopology to the path
+func WriteSmallWorldTopology(n, k int, beta float64, seed int64, path string) error {
+	t, err := SmallWorldTopology(n, k, beta, seed)
+	if err != nil {
+		return err
+	}
+	return t.WriteTo(path)
+}
+
+// WriteScaleFreeTopology writes a ScaleFreeTopology to the path
+func WriteScaleFreeTopology(n, m int, seed int64, path string) error {
+	t, err := ScaleFreeTopology(n, m, seed)
+	if err != nil {
+		return err
+	}
+	return t.WriteTo(path)
+}
+
+// graph is an undirected graph used by the generators
+type graph struct {
+	links []map[int]bool
+}
+
+func newGraph(n int) *graph {
+	g := &graph{links: make([]map[int]bool, n)}
+	for i := range g.links {
+		g.links[i] = make(map[int]bool)
+	}
+	return g
+}
+
+func (g *graph) link(a, b int) {
+	g.links[a][b] = true
+	g.links[b][a] = true
+}
+
+func (g *graph) unlink(a, b int) {
+	delete(g.links[a], b)
+	delete(g.links[b], a)
+}
+
+// topology returns the graph as a Topology listing each link on both ends
+func (g *graph) topology() Topology {
+	t := make(Topology, len(g.links))
+	for id, links := range g.links {
+		peers := make([]int, 0, len(links))
+		for peer := range links {
+			peers = append(peers, peer)
+		}
+		sort.Ints(peers)
+		t[id] = peers
+	}
+	return t
+}
+
+type topologyConnector struct {
+	path string
+	// checks the topology is connected
+	connected bool
+
+	once sync.Once
+	t    Topology
+	err  error
+}
+
+// NewTopologyConnector returns a Connector that connects each node exactly to
+// the peers listed in the topology file at the path - see ReadTopology. The
+// file is read at the first connection, and the ids it references must be in
+// the registry. The maximum number of connections is ignored.
+func NewTopologyConnector(path string) Connector {
+	return &topologyConnector{path: path}
+}
+
+// load reads and validates the topology once
+func (c *topologyConnector) load(reg handel.Registry) (Topology, error) {
+	c.once.Do(func() {
+		c.t, c.err = ReadTopology(c.path)
+		if c.err == nil {
+			c.err = c.t.Validate(reg)
+		}
+		if c.err == nil && c.connected {
+			c.err = c.t.CheckConnected(reg.Size())
+		}
+	})
+	return c.t, c.err
+}
+
+func (c *topologyConnector) Connect(node Node, reg handel.Registry, max int) error {
+	t, err := c.load(reg)
+	if err != nil {
+		return err
+	}
+	own := int(node.Identity().ID())
+	peers, ok := t[own]
+	if !ok {
+		return fmt.Errorf("topology: node %d not in %s", own, c.path)
+	}
+	for _, peer := range peers {
+		id, ok := reg.Identity(peer)
+		if !ok {
+			return errors.New("invalid index")
+		}
+		if err := node.Connect(id); err != nil {
+			return fmt.Errorf("topology: node %d connecting to %d: %s", own, peer, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package p2p
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+// connectNode records the connections of a node
+type connectNode struct {
+	id        handel.Identity
+	connected []int
+}
+
+func (c *connectNode) Values() map[string]float64 { return nil }
+func (c *connectNode) Identity() handel.Identity  { return c.id }
+func (c *connectNode) SecretKey() lib.SecretKey   { return nil }
+func (c *connectNode) Diffuse(*handel.Packet)     {}
+func (c *connectNode) Next() chan handel.Packet   { return nil }
+func (c *connectNode) Connect(id handel.Identity) error {
+	c.connected = append(c.connected, int(id.ID()))
+	return nil
+}
+
+func topologyRegistry(n int) handel.Registry {
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(int32(i), "", nil)
+	}
+	return handel.NewArrayRegistry(ids)
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "topology")
+	require.NoError(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func degrees(t Topology) []int {
+	var d []int
+	for _, peers := range t {
+		d = append(d, len(peers))
+	}
+	sort.Ints(d)
+	return d
+}
+
+// symmetric checks each link is listed on both ends
+func symmetric(t *testing.T, top Topology) {
+	for id, peers := range top {
+		for _, peer := range peers {
+			require.Contains(t, top[peer], id, "link %d-%d", id, peer)
+		}
+	}
+}
+
+func TestTopologySmallWorld(t *testing.T) {
+	n, k := 200, 6
+	// without rewiring, a ring lattice
+	ring, err := SmallWorldTopology(n, k, 0, 1)
+	require.NoError(t, err)
+	for id, peers := range ring {
+		require.Equal(t, sortedCopy([]int{(id + n - 3) % n, (id + n - 2) % n, (id + n - 1) % n, (id + 1) % n, (id + 2) % n, (id + 3) % n}), peers)
+	}
+
+	top, err := SmallWorldTopology(n, k, 0.2, 1)
+	require.NoError(t, err)
+	symmetric(t, top)
+	require.NoError(t, top.Validate(topologyRegistry(n)))
+	// the rewiring keeps the number of links and the mean degree
+	d := degrees(top)
+	var sum int
+	for _, v := range d {
+		sum += v
+	}
+	require.Equal(t, n*k, sum)
+	// but spreads the degrees around it
+	require.True(t, d[0] < k && d[len(d)-1] > k, "degrees %v", d)
+	require.True(t, d[0] >= k/2, "degrees %v", d)
+
+	same, err := SmallWorldTopology(n, k, 0.2, 1)
+	require.NoError(t, err)
+	require.Equal(t, top, same)
+
+	_, err = SmallWorldTopology(n, 5, 0.2, 1)
+	require.Error(t, err)
+	_, err = SmallWorldTopology(4, 4, 0.2, 1)
+	require.Error(t, err)
+	_, err = SmallWorldTopology(n, k, 1.5, 1)
+	require.Error(t, err)
+}
+
+func TestTopologyScaleFree(t *testing.T) {
+	n, m := 1000, 2
+	top, err := ScaleFreeTopology(n, m, 1)
+	require.NoError(t, err)
+	symmetric(t, top)
+	require.NoError(t, top.CheckConnected(n))
+	d := degrees(top)
+	// every node links to m nodes at least
+	require.Equal(t, m, d[0])
+	// the mean degree is 2m
+	var sum int
+	for _, v := range d {
+		sum += v
+	}
+	require.InDelta(t, 2*m, float64(sum)/float64(n), 0.1)
+	// a heavy tail: hubs far above the mean, most nodes below it
+	require.True(t, d[n-1] > 10*2*m, "max degree %d", d[n-1])
+	require.True(t, d[n/2] <= 2*m, "median degree %d", d[n/2])
+
+	_, err = ScaleFreeTopology(n, 0, 1)
+	require.Error(t, err)
+	_, err = ScaleFreeTopology(3, 3, 1)
+	require.Error(t, err)
+}
+
+func TestTopologyFiles(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	for _, name := range []string{"small.csv", "small.json"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, WriteSmallWorldTopology(50, 4, 0.3, 7, path))
+		read, err := ReadTopology(path)
+		require.NoError(t, err)
+		exp, _ := SmallWorldTopology(50, 4, 0.3, 7)
+		require.Equal(t, exp, read, name)
+	}
+	path := filepath.Join(dir, "free.csv")
+	require.NoError(t, WriteScaleFreeTopology(50, 3, 7, path))
+	read, err := ReadTopology(path)
+	require.NoError(t, err)
+	exp, _ := ScaleFreeTopology(50, 3, 7)
+	require.Equal(t, exp, read)
+
+	// comments and a node without peers
+	path = filepath.Join(dir, "hand.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# a line\n0, 1,2\n1,0\n\n2,0\n3\n"), 0644))
+	read, err = ReadTopology(path)
+	require.NoError(t, err)
+	require.Equal(t, Topology{0: {1, 2}, 1: {0}, 2: {0}, 3: {}}, read)
+
+	for content, name := range map[string]string{
+		"0,1\n1,x\n":    "invalid.csv",
+		"0,1\n0,2\n":    "twice.csv",
+		`{"0": [1, 2}`:  "invalid.json",
+		`{"a": [1, 2]}`: "key.json",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err := ReadTopology(path)
+		require.Error(t, err, name)
+	}
+	_, err = ReadTopology(filepath.Join(dir, "missing.csv"))
+	require.Error(t, err)
+}
+
+func TestTopologyConnector(t *testing.T) {
+	dir, clean := tempDir(t)
+	defer clean()
+	reg := topologyRegistry(5)
+	write := func(name string, top Topology) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, top.WriteTo(path))
+		return path
+	}
+	connect := func(c Connector, id int) ([]int, error) {
+		node := &connectNode{id: handel.NewStaticIdentity(int32(id), "", nil)}
+		err := c.Connect(node, reg, MaxCount)
+		return node.connected, err
+	}
+
+	path := write("ok.json", Topology{0: {1, 4}, 1: {0, 2}, 2: {1, 3}, 3: {2}, 4: {0}})
+	c, count := ExtractConnector(Opts{"Connector": "topology", "TopologyFile": path, "TopologyConnected": "1"})
+	require.Equal(t, MaxCount, count)
+	peers, err := connect(c, 0)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 4}, peers)
+	// exactly the listed peers, whatever the maximum
+	peers, err = connect(c, 3)
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, peers)
+
+	// a node outside the topology
+	path = write("partial.csv", Topology{0: {1}, 1: {0}})
+	_, err = connect(NewTopologyConnector(path), 2)
+	require.Error(t, err)
+
+	// a dangling id
+	path = write("dangling.csv", Topology{0: {1, 7}, 1: {0}})
+	_, err = connect(NewTopologyConnector(path), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "peer 7")
+	path = write("dangling_node.csv", Topology{9: {0}, 0: {9}})
+	_, err = connect(NewTopologyConnector(path), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "9 not in the registry")
+
+	// a disconnected graph, accepted unless the check is on
+	path = write("split.csv", Topology{0: {1}, 1: {0}, 2: {3, 4}, 3: {2}, 4: {}})
+	_, err = connect(NewTopologyConnector(path), 0)
+	require.NoError(t, err)
+	c, _ = ExtractConnector(Opts{"Connector": "topology", "TopologyFile": path, "TopologyConnected": "1"})
+	_, err = connect(c, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 components: {0 1} (2 nodes), {2 3 4} (3 nodes)")
+
+	require.Panics(t, func() { ExtractConnector(Opts{"Connector": "topology"}) })
+}
+
+func sortedCopy(a []int) []int {
+	c := append([]int(nil), a...)
+	sort.Ints(c)
+	return c
+}
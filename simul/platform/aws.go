@@ -33,6 +33,12 @@ type awsPlatform struct {
 	c             *lib.Config
 	copyBinFiles  bool
 	confTimeout   time.Duration
+	// runs the master and the monitor instead of the master instance, if
+	// launched - see aws.Config.CoordinatorInstanceType
+	coordinator *aws.Coordinator
+	// fleet provisioned beforehand and kept up after the simulation, if
+	// any - see UseReservation
+	reservation *aws.Reservation
 }
 
 const s3Dir = "pegasysrndbucketvirginiav1"
@@ -52,6 +58,47 @@ func NewAws(aws aws.Manager, awsConfig *aws.Config) Platform {
 	}
 }
 
+// UseReservation makes the aws platform run on the fleet of the reservation
+// file, written by ReserveAws, instead of provisioning its instances. The
+// fleet is checked by Configure and left running by Cleanup.
+func UseReservation(p Platform, path string) error {
+	a, ok := p.(*awsPlatform)
+	if !ok {
+		return errors.New("reservations are only supported by the aws platform")
+	}
+	r, err := aws.ReadReservation(path)
+	if err != nil {
+		return err
+	}
+	a.reservation = r
+	return nil
+}
+
+// ReserveAws provisions the instances of the aws config and writes their
+// reservation to the given path
+func ReserveAws(awsConfig, path string) (*aws.Reservation, error) {
+	config := aws.LoadConfig(awsConfig)
+	r, err := aws.Reserve(aws.NewMultiRegionAWSManager(config.Regions))
+	if err != nil {
+		return nil, err
+	}
+	return r, aws.WriteReservation(path, r)
+}
+
+// ReleaseAws stops the instances of the reservation written at the given path
+// and removes it
+func ReleaseAws(awsConfig, path string) error {
+	r, err := aws.ReadReservation(path)
+	if err != nil {
+		return err
+	}
+	config := aws.LoadConfig(awsConfig)
+	if err := aws.Release(aws.NewMultiRegionAWSManager(config.Regions), r); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
 func (a *awsPlatform) pack(path string, c *lib.Config, binPath string) error {
 	// Compile binaries
 	//GOOS=linux GOARCH=amd64 go build
@@ -77,6 +124,12 @@ func transferToS3(path string) error {
 	return nil
 }
 
+// RegistryPath implements the Artifacts interface
+func (a *awsPlatform) RegistryPath() string { return a.slaveCMDS.RegPath }
+
+// BinaryPath implements the Artifacts interface
+func (a *awsPlatform) BinaryPath() string { return a.slaveCMDS.SlaveBinPath }
+
 func (a *awsPlatform) Configure(c *lib.Config) error {
 
 	CMDS := aws.NewCommands(
@@ -88,12 +141,22 @@ func (a *awsPlatform) Configure(c *lib.Config) error {
 		a.copyBinFiles)
 
 	a.masterCMDS = aws.MasterCommands{Commands: CMDS}
-	a.slaveCMDS = aws.SlaveCommands{Commands: CMDS, SameBinary: true, SyncBasePort: 6000}
+	a.slaveCMDS = aws.SlaveCommands{Commands: CMDS, SameBinary: true, SyncBasePort: 6000, SharedSocket: c.SharedSocket, LogSink: c.LogSink}
 	a.network = c.Network
 	a.resFile = c.GetCSVFile()
 	a.monitorPort = c.MonitorPort
 	a.c = c
 
+	instances := a.aws.Instances()
+	if a.reservation != nil {
+		reserved, err := a.reservation.Check(a.aws, maxProcesses(c))
+		if err != nil {
+			return err
+		}
+		fmt.Println("[+] Using the reservation of", len(reserved), "instances")
+		instances = reserved
+	}
+
 	// Compile binaries
 	a.pack(c.GetBinaryPath(), c, CMDS.SlaveBinPath)
 	//a.pack("github.com/ConsenSys/handel/simul/node", c, CMDS.SlaveBinPath)
@@ -110,19 +173,36 @@ func (a *awsPlatform) Configure(c *lib.Config) error {
 	}*/
 
 	// Create master and slave instances
-	masterInstance, slaveInstances, err := makeMasterAndSlaves(a.aws.Instances())
+	masterInstance, slaveInstances, err := makeMasterAndSlaves(instances)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
+	if instanceType := a.awsConfig.CoordinatorInstanceType; instanceType != "" && a.coordinator == nil {
+		fmt.Println("[+] Launching the coordinator", instanceType)
+		coordinator, err := aws.LaunchCoordinator(a.aws, instanceType, *masterInstance)
+		if err != nil {
+			return err
+		}
+		a.coordinator = coordinator
+	}
+
 	//	slaveInstances = slaveInstances[0:2005]
 	cons := c.NewConstructor()
 	a.cons = cons
 	masterAddr := aws.GenRemoteAddress(*masterInstance.PublicIP, 5000)
+	a.monitorAddr = aws.GenRemoteAddress(*masterInstance.PublicIP, c.MonitorPort)
+	if a.coordinator != nil {
+		// the nodes reach the coordinator and are reached by it inside the
+		// VPC
+		masterInstance = a.coordinator.Instance
+		masterAddr = a.coordinator.Address(5000)
+		a.monitorAddr = a.coordinator.Address(c.MonitorPort)
+		a.slaveCMDS.PrivateSync = true
+	}
 	a.masterAddr = masterAddr
 	a.masterIP = *masterInstance.PublicIP
-	a.monitorAddr = aws.GenRemoteAddress(*masterInstance.PublicIP, c.MonitorPort)
 	masterNode := lib.GenerateNode(cons, -1, masterAddr)
 	masterInstance.Nodes = []*lib.Node{masterNode}
 	//Create master controller
@@ -232,7 +312,15 @@ func configureSlave(slaveNodeController aws.NodeController, slaveCmds map[int]st
 }
 
 func (a *awsPlatform) Cleanup() error {
+	// the instances of a reservation are kept up, see ReleaseAws
 	//return a.aws.StopInstances()
+	if a.coordinator != nil {
+		fmt.Println("[+] Terminating the coordinator", *a.coordinator.ID)
+		if err := a.coordinator.Terminate(); err != nil {
+			return err
+		}
+		a.coordinator = nil
+	}
 	return nil
 }
 
@@ -293,19 +381,29 @@ func (a *awsPlatform) Start(idx int, r *lib.RunConfig) error {
 	}
 
 	slaveNodes := a.getBalancedOnRegionNode(min(r.Processes, len(a.allSlaveNodes)))
-	allocator := a.c.NewAllocator()
+	allocator := a.c.NewAllocator(r)
 	platforms := make([]lib.Platform, len(slaveNodes))
 	for i := 0; i < len(slaveNodes); i++ {
 		platforms[i] = slaveNodes[i]
 	}
 
 	allocation := allocator.Allocate(platforms, r.Nodes, r.Failing)
-	aws.UpdateInstances(slaveNodes, allocation, a.cons)
+	aws.UpdateInstances(slaveNodes, allocation, a.cons, a.c.LevelPorts(r.Nodes))
 	writeRegFile(r.Nodes, slaveNodes, a.masterCMDS.RegPath)
 	//*** Start Master
 	fmt.Println("[+] Registry file written to local storage(", r.Nodes, " nodes)")
+	// the slaves download the registry compressed, and their nodes check it
+	// wasn't truncated on the way
+	compressed := a.masterCMDS.RegPath + lib.CompressedExt
+	digest, err := lib.CompressRegistry(a.masterCMDS.RegPath, compressed)
+	if err != nil {
+		return err
+	}
+	a.slaveCMDS.RegDigest = digest
 	fmt.Println("[*] Transferring registry file to S3")
-	transferToS3(a.masterCMDS.RegPath)
+	if err := transferToS3(compressed); err != nil {
+		return err
+	}
 
 	masterStart := a.masterCMDS.Start(
 		a.masterAddr,
@@ -364,6 +462,13 @@ func (a *awsPlatform) Start(idx int, r *lib.RunConfig) error {
 	//	wg.Wait()
 	fmt.Println("Waiting for master")
 	<-masterDone
+	if a.coordinator != nil {
+		if err := a.coordinator.Collect(master, a.masterCMDS, idx, a.resFile); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println("[+] Results and output of the coordinator collected in", a.resFile, aws.LogPath(a.resFile, idx))
+		}
+	}
 	master.Close()
 	return nil
 }
@@ -481,6 +586,17 @@ func makeMasterAndSlaves(allAwsInstances []aws.Instance) (*aws.Instance, []*aws.
 	return &masterInstance, slaveInstances, nil
 }
 
+// maxProcesses returns the number of instances the largest run needs
+func maxProcesses(c *lib.Config) int {
+	max := 0
+	for _, rc := range c.Runs {
+		if rc.Processes > max {
+			max = rc.Processes
+		}
+	}
+	return max
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
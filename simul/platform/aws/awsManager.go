@@ -12,6 +12,8 @@ type Instance struct {
 	ID *string
 	// IP Visible to the outside world
 	PublicIP *string
+	// IP inside the VPC of the instance
+	PrivateIP *string
 	// State: running, pending, stopped
 	State *string
 	//EC2 Instance region
@@ -41,20 +43,36 @@ type Manager interface {
 
 const base = 3000
 
-// GenRemoteAddresses generates n * 2 addresses: one for handel, one for the sync
-func GenRemoteAddresses(instances []Instance) ([]string, []string) {
+// GenRemoteAddresses generates n * 2 addresses: one for handel, one for the
+// sync. With a shared socket the sync address is the handel one, so only one
+// port per node is used.
+func GenRemoteAddresses(instances []Instance, shared bool) ([]string, []string) {
 	n := len(instances)
 	var addresses = make([]string, 0, n)
 	var syncs = make([]string, 0, n)
 	for _, i := range instances {
 		addr1 := GenRemoteAddress(*i.PublicIP, base)
 		addr2 := GenRemoteAddress(*i.PublicIP, base+1)
+		if shared {
+			addr2 = addr1
+		}
 		addresses = append(addresses, addr1)
 		syncs = append(syncs, addr2)
 	}
 	return addresses, syncs
 }
 
+// GenNodeAddresses generates the addresses of a node of the instance on the
+// given port: its public address first, then its private one if known, so
+// the nodes of the same VPC can reach each other on the private address.
+func GenNodeAddresses(inst *Instance, port int) []string {
+	addrs := []string{GenRemoteAddress(*inst.PublicIP, port)}
+	if inst.PrivateIP != nil && *inst.PrivateIP != "" && *inst.PrivateIP != *inst.PublicIP {
+		addrs = append(addrs, GenRemoteAddress(*inst.PrivateIP, port))
+	}
+	return addrs
+}
+
 // GenRemoteAddress generates Node address
 func GenRemoteAddress(ip string, port int) string {
 	addr := fmt.Sprintf("%s:%d", ip, port)
@@ -66,11 +84,13 @@ type info struct {
 	active bool
 }
 
-// UpdateInstances updates the address of the instances
-func UpdateInstances(inst []*Instance, allocations map[string][]*lib.NodeInfo, cons lib.Constructor) {
+// UpdateInstances updates the address of the instances. Each node binds the
+// given number of ports following its own for the levels, see
+// lib.Config.PortPerLevel.
+func UpdateInstances(inst []*Instance, allocations map[string][]*lib.NodeInfo, cons lib.Constructor, levelPorts int) {
 	for _, inst := range inst {
 		list := allocations[inst.String()]
-		UpdateInstance(inst, list, cons)
+		UpdateInstance(inst, list, cons, levelPorts)
 	}
 }
 
@@ -84,12 +104,12 @@ func isContained(arr []int, v int) bool {
 }
 
 // UpdateInstance bla
-func UpdateInstance(instances *Instance, nodes []*lib.NodeInfo, cons lib.Constructor) {
+func UpdateInstance(instances *Instance, nodes []*lib.NodeInfo, cons lib.Constructor, levelPorts int) {
 	var ls []*lib.Node
 	for i, n := range nodes {
-		addr1 := GenRemoteAddress(*instances.PublicIP, base+i)
-		n.Address = addr1
-		node := lib.GenerateNode(cons, n.ID, addr1)
+		addrs := GenNodeAddresses(instances, base+i*(1+levelPorts))
+		n.Address = addrs[0]
+		node := lib.GenerateNodeAddresses(cons, n.ID, addrs)
 		node.Active = n.Active
 		ls = append(ls, node)
 	}
@@ -15,24 +15,35 @@ type idsAndSync struct {
 
 type oneBin struct {
 	syncBasePort int
+	// shared syncs over the address of the first node
+	shared bool
+	// private syncs over the private IP of the instance
+	private bool
 }
 
 func (p *oneBin) startSlave(inst Instance) []idsAndSync {
 	var iDS []string
+	sync := GenRemoteAddress(syncIP(inst, p.private), p.syncBasePort)
 	for _, n := range inst.Nodes {
 		if !n.Active {
 			continue
 		}
 		id := int(n.ID())
 		idsStr := " -id " + strconv.Itoa(id)
+		if p.shared && len(iDS) == 0 {
+			sync = n.Address()
+		}
 		iDS = append(iDS, idsStr)
 	}
-	sync := GenRemoteAddress(*inst.PublicIP, p.syncBasePort)
 	return []idsAndSync{{iDS, sync}}
 }
 
 type multiBin struct {
 	syncBasePort int
+	// shared syncs over the address of each node
+	shared bool
+	// private syncs over the private IP of the instance
+	private bool
 }
 
 func (p *multiBin) startSlave(inst Instance) []idsAndSync {
@@ -43,16 +54,27 @@ func (p *multiBin) startSlave(inst Instance) []idsAndSync {
 		}
 		id := int(n.ID())
 		idsStr := " -id " + strconv.Itoa(id)
-		sync := GenRemoteAddress(*inst.PublicIP, p.syncBasePort+id)
+		sync := GenRemoteAddress(syncIP(inst, p.private), p.syncBasePort+id)
+		if p.shared {
+			sync = n.Address()
+		}
 		iAS = append(iAS, idsAndSync{[]string{idsStr}, sync})
 	}
 	return iAS
 }
 
-func newCmdbuilder(sameBinary bool, syncBasePort int) cmdbuilder {
+// syncIP returns the IP the nodes of the instance listen for the sync at
+func syncIP(inst Instance, private bool) string {
+	if private {
+		return *inst.PrivateIP
+	}
+	return *inst.PublicIP
+}
+
+func newCmdbuilder(sameBinary bool, syncBasePort int, shared, private bool) cmdbuilder {
 	if sameBinary {
-		return &oneBin{syncBasePort}
+		return &oneBin{syncBasePort, shared, private}
 	} else {
-		return &multiBin{syncBasePort}
+		return &multiBin{syncBasePort, shared, private}
 	}
 }
@@ -3,6 +3,8 @@ package aws
 import (
 	"strconv"
 	"strings"
+
+	"github.com/ConsenSys/handel/simul/lib"
 )
 
 // Commands represents AWS platform specyfic commands.
@@ -25,9 +27,24 @@ type SlaveCommands struct {
 	Commands
 	SameBinary   bool
 	SyncBasePort int
+	// SharedSocket makes the nodes sync over the address of their first
+	// Handel identity instead of the sync ports
+	SharedSocket bool
+	// LogSink is the address of the log sink nodes stream their logs to, if
+	// not empty
+	LogSink string
+	// PrivateSync makes the nodes listen for the sync at the private IP of
+	// their instance, for a master running in their VPC
+	PrivateSync bool
+	// RegDigest is the digest of the registry of the run, the nodes refuse
+	// a registry of another digest. Not checked if empty.
+	RegDigest string
 }
 
 const logFile = "log"
+
+// registryTries is the number of attempts at downloading the registry
+const registryTries = 5
 const sharedDir = "$HOME/sharedDir"
 
 // NewCommands creates an instance of Commands
@@ -60,7 +77,13 @@ func (c MasterCommands) Kill() string {
 
 // Start starts master executable
 func (c MasterCommands) Start(masterAddr string, timeOut int, run int, network, resFile string, monitorPort int) string {
-	return "nohup " + c.MasterBinPath + " -masterAddr " + masterAddr + " -timeOut " + strconv.Itoa(timeOut) + " -run " + strconv.Itoa(run) + " -network " + network + " -resultFile " + resFile + " -config " + c.ConfPath + " -monitorPort " + strconv.Itoa(monitorPort) + " &> " + logFile + "_" + strconv.Itoa(run)
+	return "nohup " + c.MasterBinPath + " -masterAddr " + masterAddr + " -timeOut " + strconv.Itoa(timeOut) + " -run " + strconv.Itoa(run) + " -network " + network + " -resultFile " + resFile + " -config " + c.ConfPath + " -monitorPort " + strconv.Itoa(monitorPort) + " &> " + c.Log(run)
+}
+
+// Log returns the file of the output of the master for the given run,
+// relative to the home directory
+func (c MasterCommands) Log(run int) string {
+	return logFile + "_" + strconv.Itoa(run)
 }
 
 //Kill previous run
@@ -79,26 +102,38 @@ func (c SlaveCommands) Configure() map[int]string {
 	return cmds
 }
 
+// CopyRegistryFileFromSharedDirToLocalStorage returns the commands
+// downloading the compressed registry from S3, retrying on failures, and
+// decompressing it
 func (c SlaveCommands) CopyRegistryFileFromSharedDirToLocalStorage() map[int]string {
+	compressed := c.RegPath + lib.CompressedExt
 	cmds := make(map[int]string)
-	cmds[0] = "wget -O " + c.RegPath + " " + c.S3 + c.RegPath
-	cmds[1] = "chmod 777 " + c.RegPath
+	cmds[0] = "wget --tries=" + strconv.Itoa(registryTries) + " -O " + compressed + " " + c.S3 + compressed
+	cmds[1] = "gzip -dc " + compressed + " > " + c.RegPath
+	cmds[2] = "chmod 777 " + c.RegPath
 	return cmds
 }
 
 func (c SlaveCommands) CopyRegistryFileFromSharedDirToLocalStorageQuitSSH() map[int]string {
-	cmds := make(map[int]string)
-	cmds[0] = "nohup " + "wget -O " + c.RegPath + " " + c.S3 + c.RegPath + " & " + "chmod 777 " + c.RegPath + " &> cpy.log"
-	return cmds
+	cmds := c.CopyRegistryFileFromSharedDirToLocalStorage()
+	return map[int]string{0: "nohup sh -c '" + cmds[0] + " && " + cmds[1] + " && " + cmds[2] + "' &> cpy.log"}
 }
 
 // Start starts executable
 func (c SlaveCommands) start(masterAddr, sync string, monitorAddr, ids string, run int) string {
-	return c.SlaveBinPath + " -config " + c.ConfPath + " -registry " + c.RegPath + " -monitor " + monitorAddr + " -master " + masterAddr + ids + " -sync " + sync + " -run " + strconv.Itoa(run)
+	cmd := c.SlaveBinPath + " -config " + c.ConfPath + " -registry " + c.RegPath
+	if c.RegDigest != "" {
+		cmd += " -registry-digest " + c.RegDigest
+	}
+	cmd += " -monitor " + monitorAddr + " -master " + masterAddr + ids + " -sync " + sync + " -run " + strconv.Itoa(run)
+	if c.LogSink != "" {
+		cmd += " -logsink " + c.LogSink
+	}
+	return cmd
 }
 
 func (c SlaveCommands) Start(masterAddr, monitorAddr string, inst Instance, run int) string {
-	startBuilder := newCmdbuilder(c.SameBinary, c.SyncBasePort)
+	startBuilder := newCmdbuilder(c.SameBinary, c.SyncBasePort, c.SharedSocket, c.PrivateSync)
 
 	idsAndSyncLS := startBuilder.startSlave(inst)
 	var strCmds []string
@@ -43,3 +43,20 @@ func TestMultiBinCMDBuilder(t *testing.T) {
 	res := []idsAndSync{res1, res2, res3}
 	require.Equal(t, idAndSyncs, res)
 }
+
+func TestSharedSocketCMDBuilder(t *testing.T) {
+	publicIP := "48.224.166.183"
+	inst := fakeInstance(publicIP, 1, 2)
+	for i, n := range inst.Nodes {
+		n.Identity = handel.NewStaticIdentity(n.ID(), GenRemoteAddress(publicIP, 3000+i), nil)
+	}
+
+	one := oneBin{syncBasePort: 4000, shared: true}
+	res := idsAndSync{ids: []string{" -id 1", " -id 2"}, sync: publicIP + ":3000"}
+	require.Equal(t, []idsAndSync{res}, one.startSlave(inst))
+
+	multi := multiBin{syncBasePort: 4000, shared: true}
+	res1 := idsAndSync{ids: []string{" -id 1"}, sync: publicIP + ":3000"}
+	res2 := idsAndSync{ids: []string{" -id 2"}, sync: publicIP + ":3001"}
+	require.Equal(t, []idsAndSync{res1, res2}, multi.startSlave(inst))
+}
@@ -13,6 +13,11 @@ type Config struct {
 	TargetArch    string
 	CopyBinFiles  bool
 	ConfTimeout   int
+	// CoordinatorInstanceType is the EC2 instance type of a coordinator
+	// instance launched in the VPC of the master instance for the
+	// simulation. It runs the master and the monitor instead of the master
+	// instance, and the nodes reach it at its private IP. Empty disables it.
+	CoordinatorInstanceType string
 }
 
 func LoadConfig(path string) *Config {
@@ -8,6 +8,8 @@ type NodeController interface {
 	// for example "/tmp/aws.csv" from localhost will be placed in
 	// "/tmp/aws.csv" on the remote host
 	CopyFiles(files ...string) error
+	// CopyBack copies the remote file to the local path
+	CopyBack(remote, local string) error
 	// Run runs command on a remote node, for example Run("ls -l") and blocks until completion
 	Run(command string, pw *io.PipeWriter) error
 	// Run starts command on a remote node
@@ -0,0 +1,174 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// CoordinatorLauncher can launch the coordinator instance of a simulation
+type CoordinatorLauncher interface {
+	// LaunchCoordinator launches an instance of the given type like the
+	// template instance: in its region, with its image, subnet, key pair and
+	// security groups. It blocks until the instance is running.
+	LaunchCoordinator(instanceType string, template Instance) (*Instance, error)
+	// TerminateCoordinator terminates an instance returned by
+	// LaunchCoordinator
+	TerminateCoordinator(inst *Instance) error
+}
+
+// Coordinator is an instance launched for the simulation in the VPC of the
+// nodes, to run the master and the monitor. The nodes reach it at its private
+// IP while the orchestrator controls it over SSH at its public IP.
+type Coordinator struct {
+	*Instance
+	launcher CoordinatorLauncher
+}
+
+// LaunchCoordinator launches a coordinator of the given instance type in the
+// VPC of the template instance. The manager must implement
+// CoordinatorLauncher.
+func LaunchCoordinator(m Manager, instanceType string, template Instance) (*Coordinator, error) {
+	launcher, ok := m.(CoordinatorLauncher)
+	if !ok {
+		return nil, errors.New("aws: the manager can't launch a coordinator")
+	}
+	inst, err := launcher.LaunchCoordinator(instanceType, template)
+	if err != nil {
+		return nil, err
+	}
+	if inst.PublicIP == nil || inst.PrivateIP == nil {
+		launcher.TerminateCoordinator(inst)
+		return nil, fmt.Errorf("aws: coordinator %s without public or private IP", *inst.ID)
+	}
+	return &Coordinator{Instance: inst, launcher: launcher}, nil
+}
+
+// Address returns the address of the given port of the coordinator for the
+// nodes, at its private IP
+func (c *Coordinator) Address(port int) string {
+	return GenRemoteAddress(*c.PrivateIP, port)
+}
+
+// Collect copies back the files the master produced on the coordinator for
+// the given run: the results to the same path and its output next to them,
+// see LogPath.
+func (c *Coordinator) Collect(ctrl NodeController, cmds MasterCommands, run int, resFile string) error {
+	if err := ctrl.CopyBack(resFile, resFile); err != nil {
+		return fmt.Errorf("aws: collecting the results: %s", err)
+	}
+	if err := ctrl.CopyBack(cmds.Log(run), LogPath(resFile, run)); err != nil {
+		return fmt.Errorf("aws: collecting the master output: %s", err)
+	}
+	return nil
+}
+
+// LogPath returns the local path of the output of the master of the given
+// run, next to the results file
+func LogPath(resFile string, run int) string {
+	return filepath.Join(filepath.Dir(resFile), "coordinator_"+strconv.Itoa(run)+".log")
+}
+
+// Terminate terminates the coordinator instance
+func (c *Coordinator) Terminate() error {
+	return c.launcher.TerminateCoordinator(c.Instance)
+}
+
+// LaunchCoordinator implements the CoordinatorLauncher interface
+func (a *singleRegionAWSManager) LaunchCoordinator(instanceType string, template Instance) (*Instance, error) {
+	tmpl, err := a.describe(template.ID)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*string
+	for _, g := range tmpl.SecurityGroups {
+		groups = append(groups, g.GroupId)
+	}
+	input := &ec2.RunInstancesInput{
+		ImageId:          tmpl.ImageId,
+		InstanceType:     aws.String(instanceType),
+		KeyName:          tmpl.KeyName,
+		SubnetId:         tmpl.SubnetId,
+		SecurityGroupIds: groups,
+		MinCount:         aws.Int64(1),
+		MaxCount:         aws.Int64(1),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags:         []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(RnDCoordinatorTag)}},
+		}},
+	}
+	res, err := a.svc.RunInstances(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Instances) != 1 {
+		return nil, fmt.Errorf("aws: %d coordinator instances launched", len(res.Instances))
+	}
+	launched := &Instance{ID: res.Instances[0].InstanceId, Region: a.region, Tag: RnDCoordinatorTag}
+	err = a.svc.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{InstanceIds: []*string{launched.ID}})
+	if err != nil {
+		a.TerminateCoordinator(launched)
+		return nil, err
+	}
+	// the public IP is only known once the instance runs
+	running, err := a.describe(launched.ID)
+	if err != nil {
+		a.TerminateCoordinator(launched)
+		return nil, err
+	}
+	launched.PublicIP = running.PublicIpAddress
+	launched.PrivateIP = running.PrivateIpAddress
+	launched.State = running.State.Name
+	return launched, nil
+}
+
+// TerminateCoordinator implements the CoordinatorLauncher interface
+func (a *singleRegionAWSManager) TerminateCoordinator(inst *Instance) error {
+	_, err := a.svc.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: []*string{inst.ID}})
+	return err
+}
+
+// describe returns the description of the given instance
+func (a *singleRegionAWSManager) describe(id *string) (*ec2.Instance, error) {
+	res, err := a.svc.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: []*string{id}})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Reservations) == 0 || len(res.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("aws: unknown instance %s", *id)
+	}
+	return res.Reservations[0].Instances[0], nil
+}
+
+// LaunchCoordinator implements the CoordinatorLauncher interface with the
+// manager of the region of the template
+func (a *multiRegionAWSManager) LaunchCoordinator(instanceType string, template Instance) (*Instance, error) {
+	l, err := a.launcher(template.Region)
+	if err != nil {
+		return nil, err
+	}
+	return l.LaunchCoordinator(instanceType, template)
+}
+
+// TerminateCoordinator implements the CoordinatorLauncher interface
+func (a *multiRegionAWSManager) TerminateCoordinator(inst *Instance) error {
+	l, err := a.launcher(inst.Region)
+	if err != nil {
+		return err
+	}
+	return l.TerminateCoordinator(inst)
+}
+
+// launcher returns the manager of the given region
+func (a *multiRegionAWSManager) launcher(region string) (CoordinatorLauncher, error) {
+	for _, m := range a.managers {
+		if s, ok := m.(*singleRegionAWSManager); ok && s.region == region {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("aws: no manager of the region %q", region)
+}
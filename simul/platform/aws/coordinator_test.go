@@ -0,0 +1,209 @@
+package aws
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/require"
+)
+
+// mockEC2 is an EC2 service knowing the master instance, whose launched
+// instances get their public IP once running
+type mockEC2 struct {
+	ec2iface.EC2API
+	instances  map[string]*ec2.Instance
+	launched   []*ec2.RunInstancesInput
+	terminated []string
+	waitErr    error
+}
+
+func newMockEC2() *mockEC2 {
+	master := &ec2.Instance{
+		InstanceId:       aws.String("i-master"),
+		ImageId:          aws.String("ami-1"),
+		KeyName:          aws.String("key"),
+		SubnetId:         aws.String("subnet-1"),
+		SecurityGroups:   []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1")}, {GroupId: aws.String("sg-2")}},
+		PublicIpAddress:  aws.String("52.0.0.1"),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		State:            &ec2.InstanceState{Name: aws.String(running)},
+	}
+	return &mockEC2{instances: map[string]*ec2.Instance{"i-master": master}}
+}
+
+func (m *mockEC2) DescribeInstances(in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	var instances []*ec2.Instance
+	for _, id := range in.InstanceIds {
+		if inst, ok := m.instances[*id]; ok {
+			instances = append(instances, inst)
+		}
+	}
+	if len(instances) == 0 {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: instances}}}, nil
+}
+
+func (m *mockEC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	m.launched = append(m.launched, in)
+	inst := &ec2.Instance{
+		InstanceId:       aws.String("i-coordinator"),
+		PrivateIpAddress: aws.String("10.0.0.9"),
+		State:            &ec2.InstanceState{Name: aws.String("pending")},
+	}
+	m.instances[*inst.InstanceId] = inst
+	return &ec2.Reservation{Instances: []*ec2.Instance{inst}}, nil
+}
+
+func (m *mockEC2) WaitUntilInstanceRunning(in *ec2.DescribeInstancesInput) error {
+	if m.waitErr != nil {
+		return m.waitErr
+	}
+	for _, id := range in.InstanceIds {
+		m.instances[*id].State.Name = aws.String(running)
+		m.instances[*id].PublicIpAddress = aws.String("52.0.0.9")
+	}
+	return nil
+}
+
+func (m *mockEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	for _, id := range in.InstanceIds {
+		m.terminated = append(m.terminated, *id)
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func newCoordinatorManager(svc ec2iface.EC2API) Manager {
+	return &multiRegionAWSManager{[]Manager{
+		&singleRegionAWSManager{region: "ap-south-1"},
+		&singleRegionAWSManager{region: "us-east-1", svc: svc},
+	}}
+}
+
+var templateInstance = Instance{ID: aws.String("i-master"), Region: "us-east-1", Tag: RnDMasterTag}
+
+func TestCoordinatorLifecycle(t *testing.T) {
+	svc := newMockEC2()
+	coordinator, err := LaunchCoordinator(newCoordinatorManager(svc), "c5.xlarge", templateInstance)
+	require.NoError(t, err)
+
+	// launched like the master instance
+	require.Len(t, svc.launched, 1)
+	in := svc.launched[0]
+	require.Equal(t, "c5.xlarge", *in.InstanceType)
+	require.Equal(t, "ami-1", *in.ImageId)
+	require.Equal(t, "subnet-1", *in.SubnetId)
+	require.Equal(t, "key", *in.KeyName)
+	require.Equal(t, []*string{aws.String("sg-1"), aws.String("sg-2")}, in.SecurityGroupIds)
+	require.Equal(t, RnDCoordinatorTag, *in.TagSpecifications[0].Tags[0].Value)
+
+	require.Equal(t, "i-coordinator", *coordinator.ID)
+	require.Equal(t, "52.0.0.9", *coordinator.PublicIP)
+	require.Equal(t, running, *coordinator.State)
+	require.Equal(t, "us-east-1", coordinator.Region)
+	require.Equal(t, "10.0.0.9:5000", coordinator.Address(5000))
+	require.Empty(t, svc.terminated)
+
+	require.NoError(t, coordinator.Terminate())
+	require.Equal(t, []string{"i-coordinator"}, svc.terminated)
+}
+
+func TestCoordinatorLaunchFailure(t *testing.T) {
+	// the instance launched is terminated if it doesn't run
+	svc := newMockEC2()
+	svc.waitErr = errors.New("timeout")
+	_, err := LaunchCoordinator(newCoordinatorManager(svc), "c5.xlarge", templateInstance)
+	require.Error(t, err)
+	require.Equal(t, []string{"i-coordinator"}, svc.terminated)
+
+	svc = newMockEC2()
+	_, err = LaunchCoordinator(newCoordinatorManager(svc), "c5.xlarge", Instance{ID: aws.String("i-unknown"), Region: "us-east-1"})
+	require.Error(t, err)
+	_, err = LaunchCoordinator(newCoordinatorManager(svc), "c5.xlarge", Instance{ID: aws.String("i-master"), Region: "eu-west-1"})
+	require.Error(t, err)
+	require.Empty(t, svc.launched)
+
+	_, err = LaunchCoordinator(&mockSingleRegionManager{}, "c5.xlarge", templateInstance)
+	require.Error(t, err)
+}
+
+func TestCoordinatorFlags(t *testing.T) {
+	coordinator, err := LaunchCoordinator(newCoordinatorManager(newMockEC2()), "c5.xlarge", templateInstance)
+	require.NoError(t, err)
+
+	publicIP, privateIP := "48.224.166.183", "10.0.0.2"
+	inst := Instance{PublicIP: &publicIP, PrivateIP: &privateIP}
+	for _, id := range []int{1, 2} {
+		node := &lib.Node{Identity: handel.NewStaticIdentity(int32(id), GenRemoteAddress(publicIP, 3000+id), nil), Active: true}
+		inst.Nodes = append(inst.Nodes, node)
+	}
+	cmds := SlaveCommands{Commands: NewCommands("/tmp/master", "/tmp/node", "/tmp/aws.conf", "/tmp/aws.csv", "", false), SameBinary: true, SyncBasePort: 6000}
+
+	cmd := cmds.Start(coordinator.Address(5000), coordinator.Address(10000), inst, 3)
+	require.Contains(t, cmd, " -monitor 10.0.0.9:10000 -master 10.0.0.9:5000")
+	require.Contains(t, cmd, " -sync 48.224.166.183:6000")
+	cmds.PrivateSync = true
+	cmd = cmds.Start(coordinator.Address(5000), coordinator.Address(10000), inst, 3)
+	require.Contains(t, cmd, " -sync 10.0.0.2:6000")
+	require.NotContains(t, cmd, publicIP)
+
+	cmds.SameBinary = false
+	cmd = cmds.Start(coordinator.Address(5000), coordinator.Address(10000), inst, 3)
+	require.Contains(t, cmd, " -id 1 -sync 10.0.0.2:6001")
+	require.Contains(t, cmd, " -id 2 -sync 10.0.0.2:6002")
+}
+
+// copyBackController copies back the remote files of its map
+type copyBackController struct {
+	NodeController
+	remote map[string]string
+	copied []string
+}
+
+func (c *copyBackController) CopyBack(remote, local string) error {
+	content, ok := c.remote[remote]
+	if !ok {
+		return errors.New("no such file")
+	}
+	c.copied = append(c.copied, remote)
+	return ioutil.WriteFile(local, []byte(content), 0644)
+}
+
+func TestCoordinatorCollect(t *testing.T) {
+	coordinator, err := LaunchCoordinator(newCoordinatorManager(newMockEC2()), "c5.xlarge", templateInstance)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "coordinator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	resFile := filepath.Join(dir, "results.csv")
+	cmds := MasterCommands{NewCommands("/tmp/master", "/tmp/node", "/tmp/aws.conf", "/tmp/aws.csv", "", false)}
+
+	ctrl := &copyBackController{remote: map[string]string{
+		resFile:     "run,sigen_wall_avg\n2,120\n",
+		cmds.Log(2): "master output",
+	}}
+	require.NoError(t, coordinator.Collect(ctrl, cmds, 2, resFile))
+	require.Equal(t, []string{resFile, "log_2"}, ctrl.copied)
+	content, err := ioutil.ReadFile(resFile)
+	require.NoError(t, err)
+	require.Equal(t, "run,sigen_wall_avg\n2,120\n", string(content))
+	logPath := LogPath(resFile, 2)
+	require.Equal(t, filepath.Join(dir, "coordinator_2.log"), logPath)
+	content, err = ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	require.Equal(t, "master output", string(content))
+	require.True(t, strings.Contains(cmds.Start("10.0.0.9:5000", 1, 2, "udp", resFile, 10000), "&> log_2"))
+
+	// the run produced no results
+	err = coordinator.Collect(ctrl, cmds, 3, filepath.Join(dir, "missing.csv"))
+	require.Error(t, err)
+}
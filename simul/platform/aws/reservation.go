@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Reservation is a fleet of instances provisioned once and kept up across
+// several simulations, so these skip the provisioning. It is written to a
+// JSON file by Reserve and read back by ReadReservation.
+type Reservation struct {
+	Instances []ReservedInstance `json:"instances"`
+}
+
+// ReservedInstance is an instance of a reservation
+type ReservedInstance struct {
+	ID        string `json:"id"`
+	PublicIP  string `json:"public_ip"`
+	PrivateIP string `json:"private_ip"`
+	Region    string `json:"region"`
+	Tag       string `json:"tag"`
+}
+
+// Reserve starts the instances of the manager and returns the reservation of
+// all of them
+func Reserve(m Manager) (*Reservation, error) {
+	if err := m.StartInstances(); err != nil {
+		return nil, err
+	}
+	instances, err := m.RefreshInstances()
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, errors.New("aws: no instance to reserve")
+	}
+	r := new(Reservation)
+	for _, inst := range instances {
+		r.Instances = append(r.Instances, ReservedInstance{
+			ID:        *inst.ID,
+			PublicIP:  stringOf(inst.PublicIP),
+			PrivateIP: stringOf(inst.PrivateIP),
+			Region:    inst.Region,
+			Tag:       inst.Tag,
+		})
+	}
+	return r, nil
+}
+
+// Slaves returns the number of instances of the reservation running nodes
+func (r *Reservation) Slaves() int {
+	n := 0
+	for _, inst := range r.Instances {
+		if inst.Tag == RnDTag {
+			n++
+		}
+	}
+	return n
+}
+
+// Check verifies that all the instances of the reservation still exist, run
+// and carry the same tag, and that it has at least the given number of
+// instances running nodes. It returns the instances of the reservation as
+// the manager currently lists them, as their IPs may have changed.
+func (r *Reservation) Check(m Manager, slaves int) ([]Instance, error) {
+	current, err := m.RefreshInstances()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Instance)
+	for _, inst := range current {
+		byID[*inst.ID] = inst
+	}
+	var instances []Instance
+	var gone, problems []string
+	for _, reserved := range r.Instances {
+		inst, ok := byID[reserved.ID]
+		switch {
+		case !ok:
+			gone = append(gone, reserved.ID)
+			continue
+		case inst.Tag != reserved.Tag:
+			problems = append(problems, fmt.Sprintf("%s tagged %s instead of %s", reserved.ID, inst.Tag, reserved.Tag))
+		case *inst.State != running:
+			problems = append(problems, fmt.Sprintf("%s is %s", reserved.ID, *inst.State))
+		}
+		instances = append(instances, inst)
+	}
+	if len(gone) > 0 {
+		return nil, fmt.Errorf("aws: stale reservation, instances gone: %s", strings.Join(gone, ", "))
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("aws: invalid reservation: %s", strings.Join(problems, ", "))
+	}
+	if r.Slaves() < slaves {
+		return nil, fmt.Errorf("aws: reservation of %d instances for the nodes, %d needed", r.Slaves(), slaves)
+	}
+	return instances, nil
+}
+
+// Release stops the instances of the manager, which must be the ones of the
+// reservation. The instances already gone are ignored.
+func Release(m Manager, r *Reservation) error {
+	current, err := m.RefreshInstances()
+	if err != nil {
+		return err
+	}
+	reserved := make(map[string]bool)
+	for _, inst := range r.Instances {
+		reserved[inst.ID] = true
+	}
+	for _, inst := range current {
+		if !reserved[*inst.ID] {
+			return fmt.Errorf("aws: instance %s is not part of the reservation", *inst.ID)
+		}
+	}
+	return m.StopInstances()
+}
+
+// WriteReservation writes the reservation to the given path
+func WriteReservation(path string, r *Reservation) error {
+	buff, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buff, 0644)
+}
+
+// ReadReservation reads the reservation written at the given path
+func ReadReservation(path string) (*Reservation, error) {
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Reservation)
+	if err := json.Unmarshal(buff, r); err != nil {
+		return nil, fmt.Errorf("aws: reservation %s: %s", path, err)
+	}
+	if len(r.Instances) == 0 {
+		return nil, fmt.Errorf("aws: reservation %s without instances", path)
+	}
+	return r, nil
+}
+
+func stringOf(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,124 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// newFleetManager returns a manager of a stopped master instance and the
+// given number of stopped slave instances
+func newFleetManager(slaves int) *mockSingleRegionManager {
+	instances := []Instance{{
+		ID:       aws.String("i-master"),
+		PublicIP: aws.String("52.0.0.1"),
+		State:    aws.String(stopped),
+		Region:   "us-east-1",
+		Tag:      RnDMasterTag,
+	}}
+	for i := 0; i < slaves; i++ {
+		instances = append(instances, Instance{
+			ID:       aws.String(fmt.Sprintf("i-slave%d", i)),
+			PublicIP: aws.String(fmt.Sprintf("52.0.1.%d", i)),
+			State:    aws.String(stopped),
+			Region:   "us-east-1",
+			Tag:      RnDTag,
+		})
+	}
+	return &mockSingleRegionManager{instances: instances, region: "us-east-1"}
+}
+
+func TestReservationFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reservation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fleet.json")
+
+	m := newFleetManager(3)
+	r, err := Reserve(m)
+	require.NoError(t, err)
+	require.Len(t, r.Instances, 4)
+	require.Equal(t, 3, r.Slaves())
+	require.Equal(t, ReservedInstance{ID: "i-master", PublicIP: "52.0.0.1", Region: "us-east-1", Tag: RnDMasterTag}, r.Instances[0])
+	require.NoError(t, WriteReservation(path, r))
+
+	read, err := ReadReservation(path)
+	require.NoError(t, err)
+	require.Equal(t, r, read)
+	instances, err := read.Check(m, 3)
+	require.NoError(t, err)
+	require.Len(t, instances, 4)
+	for _, inst := range instances {
+		require.Equal(t, running, *inst.State)
+	}
+	// the current IPs are used
+	*m.instances[1].PublicIP = "52.0.2.1"
+	instances, err = read.Check(m, 2)
+	require.NoError(t, err)
+	require.Equal(t, "52.0.2.1", *instances[1].PublicIP)
+
+	require.NoError(t, Release(m, read))
+	for _, inst := range m.Instances() {
+		require.Equal(t, stopped, *inst.State)
+	}
+	// a released fleet is not running anymore
+	_, err = read.Check(m, 3)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is stopped")
+
+	// an instance outside the reservation is not released
+	m = newFleetManager(4)
+	require.Error(t, Release(m, read))
+}
+
+func TestReservationInvalid(t *testing.T) {
+	m := newFleetManager(3)
+	r, err := Reserve(m)
+	require.NoError(t, err)
+
+	// not enough instances for the nodes
+	_, err = r.Check(m, 4)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "3 instances for the nodes, 4 needed")
+
+	// tag changed
+	m.instances[2].Tag = RnDMasterTag
+	_, err = r.Check(m, 3)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "i-slave1 tagged")
+	m.instances[2].Tag = RnDTag
+
+	// terminated instances are not listed anymore
+	m.instances = append(m.instances[:1], m.instances[3])
+	_, err = r.Check(m, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instances gone: i-slave0, i-slave1")
+	// the instances left are still released
+	require.NoError(t, Release(m, r))
+
+	_, err = Reserve(newFleetManager(0))
+	require.NoError(t, err)
+	_, err = Reserve(&mockSingleRegionManager{})
+	require.Error(t, err)
+}
+
+func TestReadReservation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reservation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fleet.json")
+
+	_, err = ReadReservation(path)
+	require.Error(t, err)
+	for i, content := range []string{`{"instances": [`, `{"instances": []}`} {
+		t.Logf(" -- test %d -- ", i)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err = ReadReservation(path)
+		require.Error(t, err)
+	}
+}
@@ -8,11 +8,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 type singleRegionAWSManager struct {
 	region    string
-	svc       *ec2.EC2
+	svc       ec2iface.EC2API
 	instances []Instance
 }
 
@@ -22,6 +23,10 @@ const RnDTag = "R&D"
 // RnDMasterTag is a filter for master instance
 const RnDMasterTag = "R&D_master"
 
+// RnDCoordinatorTag is the tag of the coordinator instances, which are not
+// listed by the managers
+const RnDCoordinatorTag = "R&D_coordinator"
+
 const running = "running"
 
 //NewAWS creates AWS manager for single region
@@ -81,13 +86,14 @@ func (a *singleRegionAWSManager) RefreshInstances() ([]Instance, error) {
 				continue
 			}
 			pubIP := i.PublicIpAddress
+			privIP := i.PrivateIpAddress
 			for _, tag := range i.Tags {
 				if *tag.Value == RnDMasterTag {
-					inst := Instance{id, pubIP, state, a.region, *tag.Value, nil}
+					inst := Instance{id, pubIP, privIP, state, a.region, *tag.Value, nil}
 					instances = append(instances, inst)
 				}
 				if *tag.Value == RnDTag {
-					inst := Instance{id, pubIP, state, a.region, *tag.Value, nil}
+					inst := Instance{id, pubIP, privIP, state, a.region, *tag.Value, nil}
 					instances = append(instances, inst)
 				}
 			}
@@ -83,6 +83,27 @@ func copyFile(sftpClient *sftp.Client, file string) error {
 	return nil
 }
 
+// CopyBack copies a file from the remote host to local using sftp
+func (sshCMD *sshController) CopyBack(remote, local string) error {
+	sftpClient, err := sftp.NewClient(sshCMD.client)
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+	srcFile, err := sftpClient.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
+
 //Run runs command on a remote host using ssh and waits for output
 func (sshCMD *sshController) Run(command string, pw *io.PipeWriter) error {
 	session, err := sshCMD.client.NewSession()
@@ -0,0 +1,227 @@
+package platform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/monitor"
+	"github.com/ConsenSys/handel/simul/platform/aws"
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+)
+
+// inventoryPlatform runs the nodes on the fixed hosts of an inventory, over
+// ssh. The sync master and the monitor run locally, on the master address of
+// the inventory.
+type inventoryPlatform struct {
+	c        *lib.Config
+	inv      *inventory.Inventory
+	pemBytes []byte
+	keys     *lib.KeyCache
+	cmds     inventory.Commands
+	csvFile  *os.File
+	// header is true once the header of the csv file is written
+	header bool
+}
+
+// NewInventory returns a Platform running the nodes on the hosts of the
+// inventory at the given path, see the inventory package.
+func NewInventory(path string) Platform {
+	inv, err := inventory.Load(path)
+	if err != nil {
+		panic(err)
+	}
+	pemBytes, err := ioutil.ReadFile(inv.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	return &inventoryPlatform{inv: inv, pemBytes: pemBytes, keys: lib.NewKeyCache()}
+}
+
+// RegistryPath implements the Artifacts interface
+func (p *inventoryPlatform) RegistryPath() string { return p.cmds.RegPath }
+
+// BinaryPath implements the Artifacts interface
+func (p *inventoryPlatform) BinaryPath() string { return p.cmds.BinPath }
+
+func (p *inventoryPlatform) Configure(c *lib.Config) error {
+	p.c = c
+	p.cmds = inventory.Commands{
+		BinPath:  "/tmp/inventory.bin",
+		ConfPath: "/tmp/inventory.conf",
+		RegPath:  "/tmp/inventory.csv",
+		Master:   net.JoinHostPort(p.inv.Master, strconv.Itoa(p.inv.MasterPort)),
+		Monitor:  c.GetMonitorAddress(p.inv.Master),
+		LogSink:  c.LogSink,
+	}
+	// Compile binaries for the hosts
+	cmd := NewCommand("go", "build", "-o", p.cmds.BinPath, c.GetBinaryPath())
+	cmd.Env = append(os.Environ(), "GOOS="+p.inv.TargetSystem, "GOARCH="+p.inv.TargetArch)
+	if err := cmd.Run(); err != nil {
+		fmt.Println("command output -> " + cmd.ReadAll())
+		return err
+	}
+	if err := c.WriteTo(p.cmds.ConfPath); err != nil {
+		return err
+	}
+	err := p.onHosts(p.inv.Hosts, func(h inventory.Host, ctrl aws.NodeController) error {
+		ctrl.Run(p.cmds.Kill(), nil)
+		return ctrl.CopyFiles(p.cmds.BinPath, p.cmds.ConfPath)
+	})
+	if err != nil {
+		return err
+	}
+	csvFile, err := os.Create(c.GetResultsFile())
+	if err != nil {
+		return err
+	}
+	p.csvFile = csvFile
+	return nil
+}
+
+// onHosts runs the function on each host concurrently with a connection to
+// it, and returns the first error.
+func (p *inventoryPlatform) onHosts(hosts []inventory.Host, fn func(inventory.Host, aws.NodeController) error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(hosts))
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h inventory.Host) {
+			defer wg.Done()
+			ctrl, err := aws.NewSSHNodeController(h.Address, p.pemBytes, p.inv.SSHUser)
+			if err == nil {
+				err = ctrl.Init()
+			}
+			if err != nil {
+				errs <- fmt.Errorf("host %s: %s", h.Name, err)
+				return
+			}
+			defer ctrl.Close()
+			if err := fn(h, ctrl); err != nil {
+				errs <- fmt.Errorf("host %s: %s", h.Name, err)
+			}
+		}(h)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (p *inventoryPlatform) Cleanup() error {
+	if p.csvFile != nil {
+		p.csvFile.Close()
+	}
+	return p.onHosts(p.inv.Hosts, func(h inventory.Host, ctrl aws.NodeController) error {
+		ctrl.Run(p.cmds.Kill(), nil)
+		return nil
+	})
+}
+
+func (p *inventoryPlatform) Start(idx int, r *lib.RunConfig) error {
+	// 1. lay out the nodes on the hosts and write the registry
+	placements, err := p.inv.Layout(r.Nodes, r.Failing, p.c.LevelPorts(r.Nodes))
+	if err != nil {
+		return err
+	}
+	allocation := make(map[string][]*lib.NodeInfo)
+	var hosts []inventory.Host
+	for _, pl := range placements {
+		for _, n := range pl.Nodes {
+			allocation[pl.Host.Name] = append(allocation[pl.Host.Name], &lib.NodeInfo{ID: n.ID, Active: n.Active, Address: n.Address})
+		}
+		hosts = append(hosts, pl.Host)
+	}
+	curve := p.c.GetCurve(r)
+	p.cmds.Curve = curve
+	nodes := p.keys.GenerateNodesFromAllocation(curve, lib.NewCurveConstructor(curve), allocation)
+	lib.WriteAll(nodes, lib.NewCSVParser(), p.cmds.RegPath)
+	fmt.Println("[+] Registry file written (", r.Nodes, " nodes,", curve, ")")
+	// the nodes refuse a registry truncated by the copy
+	if p.cmds.RegDigest, err = lib.RegistryDigest(p.cmds.RegPath); err != nil {
+		return err
+	}
+
+	// 2. run the monitor and the sync master
+	stats := defaultStats(p.c, idx, r)
+	mon := monitor.NewMonitor(p.c.MonitorPort, stats)
+	if p.c.RawDump {
+		raw, err := monitor.NewRawWriter(p.c.GetResultsDir(), idx, stats, 0)
+		if err != nil {
+			return err
+		}
+		defer raw.Close()
+		mon.SetRawDump(raw)
+	}
+	go mon.Listen()
+	master := lib.NewSyncMaster(p.cmds.Master, r.Nodes-r.Failing, r.Nodes)
+	master.SetPolicy(p.c.NewReleasePolicy())
+	defer master.Stop()
+
+	// 3. copy the registry and the launch scripts, and start the nodes
+	byHost := make(map[string]*inventory.Placement)
+	for _, pl := range placements {
+		byHost[pl.Host.Name] = pl
+	}
+	err = p.onHosts(hosts, func(h inventory.Host, ctrl aws.NodeController) error {
+		pl := byHost[h.Name]
+		script := p.cmds.Script(pl, idx)
+		if script == "" {
+			return nil
+		}
+		path := p.cmds.ScriptPath(h, idx)
+		if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+			return err
+		}
+		fmt.Printf("[+] %s: %s\n", h.Name, p.cmds.Start(pl, idx))
+		if err := ctrl.CopyFiles(p.cmds.RegPath, path); err != nil {
+			return err
+		}
+		return ctrl.Run("sh "+path, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 4. wait for the nodes to sync up and to finish
+	select {
+	case <-master.WaitAll(lib.START):
+		fmt.Printf("[+] Master full synchronization done.\n")
+	case <-time.After(5 * time.Minute):
+		return fmt.Errorf("nodes not started after 5mn")
+	}
+	select {
+	case <-master.WaitAll(lib.END):
+		fmt.Printf("[+] Master - finished synchronization done.\n")
+	case <-time.After(p.c.GetMaxTimeout()):
+		return fmt.Errorf("timeout after %s", p.c.GetMaxTimeout())
+	}
+
+	// 5. collect the output of the nodes, once the measures are in
+	time.Sleep(time.Second)
+	go mon.Stop()
+	logs := filepath.Join(p.c.GetResultsDir(), "logs")
+	os.MkdirAll(logs, 0777)
+	for _, h := range hosts {
+		cmd := NewCommand("scp", p.cmds.Collect(h, idx, p.inv.SSHUser, p.inv.KeyFile, logs)...)
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("[-] %s: collecting logs: %s %s\n", h.Name, err, cmd.ReadAll())
+		}
+	}
+
+	for k, v := range master.Stats(offlineIDs(allocation)) {
+		stats.SetStatic(k, v)
+	}
+	if !p.header {
+		stats.WriteHeader(p.csvFile)
+		p.header = true
+	}
+	stats.WriteValues(p.csvFile)
+	fmt.Printf("[+] Inventory round %d finished, stats written to\n\t%s\n", idx, p.c.GetResultsFile())
+	return nil
+}
@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Commands builds the commands run on the hosts. The files are at the same
+// paths on the hosts as on the machine running the simulation.
+type Commands struct {
+	BinPath  string
+	ConfPath string
+	RegPath  string
+	// RegDigest is the digest the nodes check the registry against, see
+	// lib.RegistryDigest. Not checked if empty.
+	RegDigest string
+	// Curve of the registry
+	Curve string
+	// Master and Monitor are the addresses of the sync master and of the
+	// monitor
+	Master  string
+	Monitor string
+	// LogSink is the address of the log sink, if not empty
+	LogSink string
+}
+
+// Start returns the command line running the active nodes of the placement
+// for the given run, empty if there is none.
+func (c *Commands) Start(p *Placement, run int) string {
+	args := []string{c.BinPath,
+		"-config", c.ConfPath,
+		"-registry", c.RegPath,
+		"-curve", c.Curve,
+		"-master", c.Master,
+		"-monitor", c.Monitor}
+	if c.RegDigest != "" {
+		args = append(args, "-registry-digest", c.RegDigest)
+	}
+	if c.LogSink != "" {
+		args = append(args, "-logsink", c.LogSink)
+	}
+	var active bool
+	for _, n := range p.Nodes {
+		if n.Active {
+			args = append(args, "-id", strconv.Itoa(n.ID))
+			active = true
+		}
+	}
+	if !active {
+		return ""
+	}
+	args = append(args, "-sync", p.Sync, "-run", strconv.Itoa(run))
+	return strings.Join(args, " ")
+}
+
+// Kill returns the command stopping the nodes of a previous run
+func (c *Commands) Kill() string {
+	return "killall " + c.BinPath
+}
+
+// LogPath returns the path of the output of the nodes of the host for the
+// given run
+func (c *Commands) LogPath(h Host, run int) string {
+	return fmt.Sprintf("/tmp/inventory-%s-%d.log", h.Name, run)
+}
+
+// ScriptPath returns the path of the launch script of the host for the given
+// run
+func (c *Commands) ScriptPath(h Host, run int) string {
+	return fmt.Sprintf("/tmp/inventory-%s-%d.sh", h.Name, run)
+}
+
+// Script returns the launch script of the placement for the given run: it
+// stops the nodes of the previous run and starts the new ones in the
+// background, empty if the placement has no active node.
+func (c *Commands) Script(p *Placement, run int) string {
+	start := c.Start(p, run)
+	if start == "" {
+		return ""
+	}
+	return "#!/bin/sh\n" +
+		c.Kill() + " > /dev/null 2>&1\n" +
+		"nohup " + start + " > " + c.LogPath(p.Host, run) + " 2>&1 &\n"
+}
+
+// Collect returns the scp arguments fetching the output of the nodes of the
+// host for the given run into the local directory.
+func (c *Commands) Collect(h Host, run int, user, keyFile, dir string) []string {
+	remote := c.LogPath(h, run)
+	return []string{"-i", keyFile, "-o", "StrictHostKeyChecking=no",
+		user + "@" + h.Address + ":" + remote,
+		filepath.Join(dir, filepath.Base(remote))}
+}
@@ -0,0 +1,53 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeCommands() *Commands {
+	return &Commands{
+		BinPath:  "/tmp/inventory.bin",
+		ConfPath: "/tmp/inventory.conf",
+		RegPath:  "/tmp/inventory.csv",
+		Curve:    "bn256",
+		Master:   "10.0.0.1:5000",
+		Monitor:  "10.0.0.1:10000",
+	}
+}
+
+func TestCommandsStart(t *testing.T) {
+	inv := fakeInventory(Stripe)
+	ps, err := inv.Layout(6, 2, 0)
+	require.NoError(t, err)
+	c := fakeCommands()
+
+	// the nodes 0 and 3 of the first host are offline
+	require.Equal(t, "", c.Start(ps[0], 2))
+	require.Equal(t, "", c.Script(ps[0], 2))
+	require.Equal(t, "/tmp/inventory.bin -config /tmp/inventory.conf -registry /tmp/inventory.csv"+
+		" -curve bn256 -master 10.0.0.1:5000 -monitor 10.0.0.1:10000 -id 2 -id 5"+
+		" -sync 10.0.0.12:3002 -run 2", c.Start(ps[2], 2))
+	require.Equal(t, "/tmp/inventory.bin -config /tmp/inventory.conf -registry /tmp/inventory.csv"+
+		" -curve bn256 -master 10.0.0.1:5000 -monitor 10.0.0.1:10000 -id 1 -id 4"+
+		" -sync 10.0.0.11:4002 -run 2", c.Start(ps[1], 2))
+
+	c.LogSink = "10.0.0.1:9000"
+	require.Contains(t, c.Start(ps[1], 2), " -logsink 10.0.0.1:9000 -id 1")
+}
+
+func TestCommandsScript(t *testing.T) {
+	inv := fakeInventory(Fill)
+	ps, err := inv.Layout(2, 0, 0)
+	require.NoError(t, err)
+	c := fakeCommands()
+	require.Equal(t, "/tmp/inventory-a-1.sh", c.ScriptPath(ps[0].Host, 1))
+	require.Equal(t, "#!/bin/sh\n"+
+		"killall /tmp/inventory.bin > /dev/null 2>&1\n"+
+		"nohup "+c.Start(ps[0], 1)+" > /tmp/inventory-a-1.log 2>&1 &\n", c.Script(ps[0], 1))
+
+	require.Equal(t, []string{"-i", "/home/lab/key.pem", "-o", "StrictHostKeyChecking=no",
+		"lab@10.0.0.10:/tmp/inventory-a-1.log", "results/logs/inventory-a-1.log"},
+		c.Collect(ps[0].Host, 1, "lab", "/home/lab/key.pem", "results/logs"))
+}
@@ -0,0 +1,212 @@
+// Package inventory lays out the nodes of a simulation on a fixed set of
+// hosts, such as the machines of a bare-metal lab, described by an inventory
+// file, and builds the commands launching them.
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Fill and Stripe are the strategies assigning the node ids to the hosts.
+const (
+	// Fill assigns the ids to a host until it is full, then to the next one
+	Fill = "fill"
+	// Stripe assigns the ids to the hosts in turn, skipping the full ones
+	Stripe = "stripe"
+)
+
+// maxPort is the highest port a node can listen on
+const maxPort = 65535
+
+// Host is a machine of the inventory
+type Host struct {
+	// Name identifies the host in the scripts and the logs. The address is
+	// used if empty.
+	Name string
+	// Address is the hostname or IP the nodes of the host listen on, and the
+	// platform connects to over ssh
+	Address string
+	// BasePort is the first port of the range of the host: the nodes listen
+	// on the ports following it, and the sync port follows the ports of the
+	// nodes
+	BasePort int
+	// MaxNodes is the number of nodes the host can run
+	MaxNodes int
+}
+
+// Inventory describes the hosts the nodes run on
+type Inventory struct {
+	// Master is the IP, reachable by the hosts, of the machine running the
+	// simulation, where the sync master and the monitor listen
+	Master string
+	// MasterPort is the port of the sync master
+	MasterPort int
+	// Strategy is Fill or Stripe. Fill is used if empty.
+	Strategy string
+	// SSHUser and KeyFile are the user and the PEM encoded private key used
+	// to connect to the hosts
+	SSHUser string
+	KeyFile string
+	// TargetSystem and TargetArch are the GOOS and GOARCH of the hosts,
+	// linux and amd64 if empty
+	TargetSystem string
+	TargetArch   string
+	Hosts        []Host
+}
+
+// Load reads the TOML encoded inventory at the given path, and validates it.
+func Load(path string) (*Inventory, error) {
+	i := new(Inventory)
+	if _, err := toml.DecodeFile(path, i); err != nil {
+		return nil, err
+	}
+	if i.Strategy == "" {
+		i.Strategy = Fill
+	}
+	if i.TargetSystem == "" {
+		i.TargetSystem = "linux"
+	}
+	if i.TargetArch == "" {
+		i.TargetArch = "amd64"
+	}
+	for j := range i.Hosts {
+		if i.Hosts[j].Name == "" {
+			i.Hosts[j].Name = i.Hosts[j].Address
+		}
+	}
+	return i, i.Validate()
+}
+
+// Validate returns an error if the inventory can't be used
+func (i *Inventory) Validate() error {
+	if i.Master == "" {
+		return errors.New("inventory: no master address")
+	}
+	if i.MasterPort <= 0 || i.MasterPort > maxPort {
+		return fmt.Errorf("inventory: invalid master port %d", i.MasterPort)
+	}
+	if i.Strategy != Fill && i.Strategy != Stripe {
+		return fmt.Errorf("inventory: unknown strategy %q", i.Strategy)
+	}
+	if len(i.Hosts) == 0 {
+		return errors.New("inventory: no host")
+	}
+	names := make(map[string]bool)
+	for _, h := range i.Hosts {
+		if h.Address == "" {
+			return fmt.Errorf("inventory: host %q without address", h.Name)
+		}
+		if names[h.Name] {
+			return fmt.Errorf("inventory: duplicate host %q", h.Name)
+		}
+		names[h.Name] = true
+		if h.BasePort <= 0 || h.BasePort > maxPort {
+			return fmt.Errorf("inventory: host %s: invalid base port %d", h.Name, h.BasePort)
+		}
+		if h.MaxNodes <= 0 {
+			return fmt.Errorf("inventory: host %s: invalid max nodes %d", h.Name, h.MaxNodes)
+		}
+	}
+	return nil
+}
+
+// Capacity returns the number of nodes the hosts can run
+func (i *Inventory) Capacity() int {
+	var c int
+	for _, h := range i.Hosts {
+		c += h.MaxNodes
+	}
+	return c
+}
+
+// Node is a node laid out on a host
+type Node struct {
+	ID      int
+	Active  bool
+	Address string
+}
+
+// Placement holds the nodes of a host, run by a single process syncing over
+// the Sync address.
+type Placement struct {
+	Host  Host
+	Nodes []Node
+	Sync  string
+}
+
+// Layout assigns the ids of the nodes of a run to the hosts following the
+// strategy, the same inputs always giving the same layout. As with the
+// allocators of the simulation, the offline nodes are evenly spread over the
+// ids. Each node uses 1+levelPorts consecutive ports, see the PortPerLevel
+// option of the simulation. The hosts without node are omitted. It returns an
+// error if the hosts can't run the nodes.
+func (i *Inventory) Layout(nodes, offline, levelPorts int) ([]*Placement, error) {
+	if nodes > i.Capacity() {
+		return nil, fmt.Errorf("inventory: %d nodes exceed the capacity of %d nodes", nodes, i.Capacity())
+	}
+	if offline > nodes {
+		return nil, fmt.Errorf("inventory: %d offline nodes out of %d", offline, nodes)
+	}
+	stride := 1 + levelPorts
+	for _, h := range i.Hosts {
+		// the last port is the sync port
+		if last := h.BasePort + h.MaxNodes*stride; last > maxPort {
+			return nil, fmt.Errorf("inventory: host %s: port range exceeds %d with %d ports per node", h.Name, maxPort, stride)
+		}
+	}
+
+	hosts := make([][]int, len(i.Hosts))
+	switch i.Strategy {
+	case Stripe:
+		for id, h := 0, 0; id < nodes; h = (h + 1) % len(hosts) {
+			if len(hosts[h]) < i.Hosts[h].MaxNodes {
+				hosts[h] = append(hosts[h], id)
+				id++
+			}
+		}
+	default:
+		for id, h := 0, 0; id < nodes; id++ {
+			if len(hosts[h]) == i.Hosts[h].MaxNodes {
+				h++
+			}
+			hosts[h] = append(hosts[h], id)
+		}
+	}
+
+	bucket := nodes
+	if offline > 0 {
+		bucket = nodes / offline
+	}
+	isOffline := func(id int) bool {
+		return offline > 0 && id%bucket == 0 && id/bucket < offline
+	}
+	var out []*Placement
+	for h, ids := range hosts {
+		if len(ids) == 0 {
+			continue
+		}
+		host := i.Hosts[h]
+		p := &Placement{
+			Host: host,
+			Sync: hostPort(host.Address, host.BasePort+host.MaxNodes*stride),
+		}
+		for k, id := range ids {
+			p.Nodes = append(p.Nodes, Node{
+				ID:      id,
+				Active:  !isOffline(id),
+				Address: hostPort(host.Address, host.BasePort+k*stride),
+			})
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func hostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
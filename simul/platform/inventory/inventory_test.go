@@ -0,0 +1,167 @@
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeInventory(strategy string) *Inventory {
+	return &Inventory{
+		Master:     "10.0.0.1",
+		MasterPort: 5000,
+		Strategy:   strategy,
+		Hosts: []Host{
+			{Name: "a", Address: "10.0.0.10", BasePort: 3000, MaxNodes: 3},
+			{Name: "b", Address: "10.0.0.11", BasePort: 4000, MaxNodes: 2},
+			{Name: "c", Address: "10.0.0.12", BasePort: 3000, MaxNodes: 2},
+		},
+	}
+}
+
+// ids returns the ids of the nodes of each placement
+func ids(ps []*Placement) map[string][]int {
+	out := make(map[string][]int)
+	for _, p := range ps {
+		for _, n := range p.Nodes {
+			out[p.Host.Name] = append(out[p.Host.Name], n.ID)
+		}
+	}
+	return out
+}
+
+func TestInventoryLayout(t *testing.T) {
+	var tests = []struct {
+		strategy string
+		nodes    int
+		exp      map[string][]int
+	}{
+		{Fill, 7, map[string][]int{"a": {0, 1, 2}, "b": {3, 4}, "c": {5, 6}}},
+		{Fill, 4, map[string][]int{"a": {0, 1, 2}, "b": {3}}},
+		{Stripe, 7, map[string][]int{"a": {0, 3, 6}, "b": {1, 4}, "c": {2, 5}}},
+		{Stripe, 4, map[string][]int{"a": {0, 3}, "b": {1}, "c": {2}}},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		inv := fakeInventory(test.strategy)
+		require.NoError(t, inv.Validate())
+		ps, err := inv.Layout(test.nodes, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, test.exp, ids(ps))
+		// the layout is deterministic
+		ps2, err := inv.Layout(test.nodes, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, ps, ps2)
+	}
+}
+
+func TestInventoryLayoutAddresses(t *testing.T) {
+	inv := fakeInventory(Stripe)
+	ps, err := inv.Layout(5, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, ps, 3)
+	require.Equal(t, []Node{{0, true, "10.0.0.10:3000"}, {3, true, "10.0.0.10:3001"}}, ps[0].Nodes)
+	// the sync port follows the range of the nodes
+	require.Equal(t, "10.0.0.10:3003", ps[0].Sync)
+	require.Equal(t, "10.0.0.11:4002", ps[1].Sync)
+
+	// with a port per level, each node uses 1+levelPorts ports
+	ps, err = inv.Layout(5, 0, 3)
+	require.NoError(t, err)
+	require.Equal(t, []Node{{0, true, "10.0.0.10:3000"}, {3, true, "10.0.0.10:3004"}}, ps[0].Nodes)
+	require.Equal(t, "10.0.0.10:3012", ps[0].Sync)
+}
+
+func TestInventoryLayoutOffline(t *testing.T) {
+	inv := fakeInventory(Fill)
+	ps, err := inv.Layout(6, 2, 0)
+	require.NoError(t, err)
+	var offline []int
+	for _, p := range ps {
+		for _, n := range p.Nodes {
+			if !n.Active {
+				offline = append(offline, n.ID)
+			}
+		}
+	}
+	// spread as the allocators of the simulation do
+	require.Equal(t, []int{0, 3}, offline)
+}
+
+func TestInventoryLayoutErrors(t *testing.T) {
+	inv := fakeInventory(Fill)
+	_, err := inv.Layout(8, 0, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceed the capacity of 7 nodes")
+	_, err = inv.Layout(4, 5, 0)
+	require.Error(t, err)
+
+	inv.Hosts[1].BasePort = 65530
+	_, err = inv.Layout(4, 0, 0)
+	require.NoError(t, err)
+	_, err = inv.Layout(4, 0, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "host b: port range exceeds")
+}
+
+func TestInventoryValidate(t *testing.T) {
+	var tests = []func(*Inventory){
+		func(i *Inventory) { i.Master = "" },
+		func(i *Inventory) { i.MasterPort = 0 },
+		func(i *Inventory) { i.Strategy = "random" },
+		func(i *Inventory) { i.Hosts = nil },
+		func(i *Inventory) { i.Hosts[1].Address = "" },
+		func(i *Inventory) { i.Hosts[1].Name = "a" },
+		func(i *Inventory) { i.Hosts[1].BasePort = 70000 },
+		func(i *Inventory) { i.Hosts[1].MaxNodes = 0 },
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		inv := fakeInventory(Fill)
+		test(inv)
+		require.Error(t, inv.Validate())
+	}
+}
+
+func TestInventoryLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "inventory")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+Master = "10.0.0.1"
+MasterPort = 5000
+SSHUser = "lab"
+KeyFile = "/home/lab/.ssh/id_rsa"
+
+[[Hosts]]
+Address = "10.0.0.10"
+BasePort = 3000
+MaxNodes = 3
+
+[[Hosts]]
+Name = "rack2"
+Address = "10.0.0.11"
+BasePort = 3000
+MaxNodes = 2
+`)
+	require.NoError(t, err)
+	f.Close()
+
+	inv, err := Load(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, Fill, inv.Strategy)
+	require.Equal(t, "linux", inv.TargetSystem)
+	require.Equal(t, "amd64", inv.TargetArch)
+	require.Equal(t, "10.0.0.10", inv.Hosts[0].Name)
+	require.Equal(t, "rack2", inv.Hosts[1].Name)
+	require.Equal(t, 5, inv.Capacity())
+}
+
+func TestInventoryExample(t *testing.T) {
+	inv, err := Load("../../inventory_example.toml")
+	require.NoError(t, err)
+	require.Equal(t, Stripe, inv.Strategy)
+	require.Equal(t, 128, inv.Capacity())
+}
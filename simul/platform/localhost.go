@@ -14,11 +14,15 @@ import (
 )
 
 type localPlatform struct {
-	c        *lib.Config
+	c *lib.Config
+	// registry of the last run
 	regPath  string
+	keys     *lib.KeyCache
 	binPath  string
 	confPath string
 	csvFile  *os.File
+	// header is true once the header of the csv file is written
+	header bool
 	sync.Mutex
 	cmds []*Command
 }
@@ -29,6 +33,7 @@ func NewLocalhost() Platform { return &localPlatform{} }
 func (l *localPlatform) Configure(c *lib.Config) error {
 	l.c = c
 	l.regPath = "/tmp/local.csv"
+	l.keys = lib.NewKeyCache()
 	l.binPath = "/tmp/local.bin"
 	l.confPath = "/tmp/local.conf"
 	// Compile binaries
@@ -53,6 +58,21 @@ func (l *localPlatform) Configure(c *lib.Config) error {
 
 }
 
+// RegistryPath implements the Artifacts interface
+func (l *localPlatform) RegistryPath() string { return l.regPath }
+
+// BinaryPath implements the Artifacts interface
+func (l *localPlatform) BinaryPath() string { return l.binPath }
+
+// registryPath returns the registry file of the given curve: the global curve
+// keeps the default path, the curves set by the runs get their own file.
+func (l *localPlatform) registryPath(curve string) string {
+	if curve == l.c.Curve {
+		return "/tmp/local.csv"
+	}
+	return "/tmp/local-" + strings.Replace(curve, "/", "-", -1) + ".csv"
+}
+
 func (l *localPlatform) Cleanup() error {
 	//os.RemoveAll(l.regPath)
 	l.Lock()
@@ -73,28 +93,53 @@ func (l *localPlatform) Start(idx int, r *lib.RunConfig) error {
 	// 0. setup monitor
 	stats := defaultStats(l.c, idx, r)
 	mon := monitor.NewMonitor(l.c.MonitorPort, stats)
+	if l.c.RawDump {
+		raw, err := monitor.NewRawWriter(l.c.GetResultsDir(), idx, stats, 0)
+		if err != nil {
+			return err
+		}
+		defer raw.Close()
+		mon.SetRawDump(raw)
+	}
 	go mon.Listen()
 
-	// 1. Generate & write the registry file
-	cons := l.c.NewConstructor()
+	// 1. Generate & write the registry file of the curve of the run
+	curve := l.c.GetCurve(r)
+	cons := lib.NewCurveConstructor(curve)
+	regPath := l.registryPath(curve)
 	parser := lib.NewCSVParser()
-	allocator := l.c.NewAllocator()
+	allocator := l.c.NewAllocator(r)
 
 	procs := make([]lib.Platform, r.Processes)
 	for i := 0; i < r.Processes; i++ {
 		procs[i] = &Proc{id: i}
 	}
 	allocation := allocator.Allocate(procs, r.Nodes, r.Failing)
-	updateAddresses(l.c, procs, allocation)
+	updateAddresses(l.c, procs, allocation, l.c.LevelPorts(r.Nodes))
 
-	nodes := lib.GenerateNodesFromAllocation(cons, allocation)
-	lib.WriteAll(nodes, parser, l.regPath)
-	fmt.Println("[+] Registry file written (", r.Nodes, " nodes)")
+	nodes := l.keys.GenerateNodesFromAllocation(curve, cons, allocation)
+	lib.WriteAll(nodes, parser, regPath)
+	l.regPath = regPath
+	fmt.Println("[+] Registry file written (", r.Nodes, " nodes,", curve, ")")
+	digest, err := lib.RegistryDigest(regPath)
+	if err != nil {
+		return err
+	}
+	// the certificates of the mutual TLS of the nodes of the registry
+	tlsDir := l.c.TLSDir
+	if l.c.Network == "quic" && l.c.MutualTLS && tlsDir == "" {
+		tlsDir = regPath + ".tls"
+		if err := lib.WriteCertificates(regPath, cons, tlsDir); err != nil {
+			return err
+		}
+		fmt.Println("[+] Certificates of the mutual TLS written to", tlsDir)
+	}
 
 	// 2. Run the sync master
 	masterPort := lib.GetFreeUDPPort()
 	masterAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(masterPort))
 	master := lib.NewSyncMaster(masterAddr, r.Nodes-r.Failing, r.Nodes)
+	master.SetPolicy(l.c.NewReleasePolicy())
 	fmt.Println("[+] Master synchronization daemon launched")
 
 	// 3. Run binaries
@@ -102,9 +147,17 @@ func (l *localPlatform) Start(idx int, r *lib.RunConfig) error {
 	doneCh := make(chan int, len(procs))
 	errCh := make(chan int, len(procs))
 	sameArgs := []string{"-config", l.confPath,
-		"-registry", l.regPath,
+		"-registry", regPath,
+		"-registry-digest", digest,
+		"-curve", curve,
 		"-master", masterAddr,
 		"-monitor", l.c.GetMonitorAddress("127.0.0.1")}
+	if l.c.LogSink != "" {
+		sameArgs = append(sameArgs, "-logsink", l.c.LogSink)
+	}
+	if tlsDir != "" {
+		sameArgs = append(sameArgs, "-tls", tlsDir)
+	}
 
 	for i := 0; i < len(procs); i++ {
 		proc := procs[i].(*Proc)
@@ -199,8 +252,12 @@ func (l *localPlatform) Start(idx int, r *lib.RunConfig) error {
 	fmt.Printf("[+] Localhost round %d finished - success !\n", idx)
 
 	go mon.Stop()
-	if idx == 0 {
+	for k, v := range master.Stats(offlineIDs(allocation)) {
+		stats.SetStatic(k, v)
+	}
+	if !l.header {
 		stats.WriteHeader(l.csvFile)
+		l.header = true
 	}
 	stats.WriteValues(l.csvFile)
 	fmt.Printf("[+] Closing down monitor & writing stats to\n\t%s\n", l.c.GetResultsFile())
@@ -234,7 +291,9 @@ func newLocalAddr(c *lib.Config) string {
 	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
 }
 
-func updateAddresses(c *lib.Config, procs []lib.Platform, allocation map[string][]*lib.NodeInfo) {
+// updateAddresses allocates the addresses of the nodes, followed by the given
+// number of free ports for the levels, see lib.Config.PortPerLevel.
+func updateAddresses(c *lib.Config, procs []lib.Platform, allocation map[string][]*lib.NodeInfo, levelPorts int) {
 	for _, p := range procs {
 		proc := p.(*Proc)
 		s := proc.String()
@@ -244,6 +303,11 @@ func updateAddresses(c *lib.Config, procs []lib.Platform, allocation map[string]
 		}
 		proc.syncAddr = newLocalAddr(c)
 		for _, node := range list {
+			if levelPorts > 0 {
+				port := lib.GetFreeUDPPorts(1 + levelPorts)
+				node.Address = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+				continue
+			}
 			node.Address = newLocalAddr(c)
 		}
 	}
@@ -49,14 +49,28 @@ type Platform interface {
 	Start(idx int, rc *lib.RunConfig) error
 }
 
+// Artifacts is implemented by platforms that can tell where the files they
+// generate for a simulation are, so these can be bundled with the results.
+type Artifacts interface {
+	// RegistryPath returns the path of the registry file of the last run
+	RegistryPath() string
+	// BinaryPath returns the path of the node binary
+	BinaryPath() string
+}
+
 var localhost = "localhost"
 var amazonAWS = "aws"
+var inventoryHosts = "inventory"
+var sshHosts = "ssh"
 
 //var regions = []string{"us-west-2"}
 
-// NewPlatform returns the appropriate platform [deterlab,localhost]
-// and setups the Cleanup call in case of a signal interruption
-func NewPlatform(t string, awsConfig string) Platform {
+// NewPlatform returns the appropriate platform [localhost,aws,inventory,ssh]
+// and setups the Cleanup call in case of a signal interruption. The aws
+// platform reads the awsConfig file, and the inventory and ssh platforms the
+// inventory file, in their own format - see the inventory and remote
+// packages.
+func NewPlatform(t string, awsConfig, inventoryPath string) Platform {
 	var p Platform
 	switch t {
 	case localhost:
@@ -66,6 +80,10 @@ func NewPlatform(t string, awsConfig string) Platform {
 		awsManager := aws.NewMultiRegionAWSManager(config.Regions)
 
 		p = NewAws(awsManager, config)
+	case inventoryHosts:
+		p = NewInventory(inventoryPath)
+	case sshHosts:
+		p = NewSSH(inventoryPath)
 
 	default:
 		panic("no platform of this name " + t)
@@ -0,0 +1,85 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultChunkSize is the size of the chunks of the resumable copies
+const DefaultChunkSize = 4 << 20
+
+// DefaultChunkRetries is the number of times a chunk is sent again before
+// the copy fails
+const DefaultChunkRetries = 5
+
+// ChunkWriter is implemented by the executors which can write a remote file
+// by chunks, so that a copy failing midway resumes where it stopped instead
+// of starting over, see CopyChunked
+type ChunkWriter interface {
+	// Truncate creates the remote file, or truncates it to the given size
+	Truncate(remote string, size int64) error
+	// Size returns the size of the remote file
+	Size(remote string) (int64, error)
+	// WriteAt writes the chunk at the offset of the remote file
+	WriteAt(remote string, chunk []byte, off int64) error
+}
+
+// CopyChunked copies the local file to the remote path by chunks of the given
+// size. A failing chunk is sent again up to retries times, from the end of
+// what the remote file received. The copy fails if the remote file doesn't
+// have the size of the local one in the end.
+func CopyChunked(w ChunkWriter, local, remote string, chunkSize, retries int) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	total := info.Size()
+	if err := w.Truncate(remote, 0); err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	buff := make([]byte, chunkSize)
+	var off int64
+	var failures int
+	for off < total {
+		n, err := src.ReadAt(buff, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		err = w.WriteAt(remote, buff[:n], off)
+		if err == nil {
+			off += int64(n)
+			failures = 0
+			continue
+		}
+		if failures++; failures > retries {
+			return fmt.Errorf("%s: chunk at %d failed %d times: %s", remote, off, failures, err)
+		}
+		// the start of the chunk the remote file received is kept
+		if size, err := w.Size(remote); err == nil && size > off && size <= off+int64(n) {
+			off = size
+		}
+	}
+	size, err := w.Size(remote)
+	if err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	if size != total {
+		return fmt.Errorf("%s: %d bytes copied instead of %d", remote, size, total)
+	}
+	return nil
+}
+
+// copyResumable copies the local file to the remote path by chunks if the
+// executor can, and at once otherwise
+func copyResumable(exec Executor, local, remote string) error {
+	if w, ok := exec.(ChunkWriter); ok {
+		return CopyChunked(w, local, remote, DefaultChunkSize, DefaultChunkRetries)
+	}
+	return exec.Copy(local, remote)
+}
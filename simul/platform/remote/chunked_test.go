@@ -0,0 +1,188 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUploader is a remote file in memory whose writes fail as scheduled
+type fakeUploader struct {
+	content []byte
+	writes  int
+	// the writes failing, by index, after writing the given number of bytes
+	// of their chunk
+	fails map[int]int
+}
+
+func (f *fakeUploader) Truncate(remote string, size int64) error {
+	f.content = f.content[:size]
+	return nil
+}
+
+func (f *fakeUploader) Size(remote string) (int64, error) {
+	return int64(len(f.content)), nil
+}
+
+func (f *fakeUploader) WriteAt(remote string, chunk []byte, off int64) error {
+	f.writes++
+	partial, fail := f.fails[f.writes]
+	if fail {
+		chunk = chunk[:partial]
+	}
+	if int(off) > len(f.content) {
+		return errors.New("write past the end")
+	}
+	f.content = append(f.content[:off], chunk...)
+	if fail {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func randomFile(t *testing.T, dir string, size int) (string, []byte) {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(content)
+	path := filepath.Join(dir, "registry")
+	require.NoError(t, ioutil.WriteFile(path, content, 0644))
+	return path, content
+}
+
+func TestCopyChunked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local, content := randomFile(t, dir, 1000)
+
+	// the chunks failing midway are resumed from what the remote received
+	f := &fakeUploader{content: []byte("stale content"), fails: map[int]int{2: 30, 3: 0, 6: 99}}
+	require.NoError(t, CopyChunked(f, local, "reg", 100, 2))
+	require.Equal(t, content, f.content)
+	// 10 chunks, the resumed ones are shorter
+	require.Equal(t, 12, f.writes)
+
+	// a chunk failing more than the retries fails the copy
+	f = &fakeUploader{fails: map[int]int{4: 0, 5: 10, 6: 10}}
+	err = CopyChunked(f, local, "reg", 100, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "chunk at 310 failed 3 times")
+
+	// the empty files are copied
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	f = &fakeUploader{content: []byte("stale")}
+	require.NoError(t, CopyChunked(f, empty, "reg", 100, 2))
+	require.Empty(t, f.content)
+}
+
+// localExecutor runs the commands of a host on the local machine, and
+// records the commands starting the nodes instead of running them
+type localExecutor struct {
+	streamed []string
+}
+
+func (l *localExecutor) Run(cmd string) error {
+	if out, err := exec.Command("sh", "-c", cmd).CombinedOutput(); err != nil {
+		return errors.New(cmd + ": " + string(out))
+	}
+	return nil
+}
+
+func (l *localExecutor) Stream(cmd string, w io.Writer) error {
+	l.streamed = append(l.streamed, cmd)
+	return nil
+}
+
+func (l *localExecutor) Copy(local, remote string) error {
+	return errors.New("not by chunks")
+}
+
+func (l *localExecutor) Truncate(remote string, size int64) error {
+	f, err := os.OpenFile(remote, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+func (l *localExecutor) Size(remote string) (int64, error) {
+	info, err := os.Stat(remote)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (l *localExecutor) WriteAt(remote string, chunk []byte, off int64) error {
+	f, err := os.OpenFile(remote, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteAt(chunk, off)
+	return err
+}
+
+func (l *localExecutor) Close() error { return nil }
+
+// TestDeploymentLocalRegistry distributes a registry to hosts on the local
+// machine, and checks the nodes start from a registry of the pinned digest
+func TestDeploymentLocalRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local, content := randomFile(t, dir, 3*DefaultChunkSize/2)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, ioutil.WriteFile(local+".gz", compressed.Bytes(), 0644))
+	sum := sha256.Sum256(content)
+	reg := Registry{Compressed: local + ".gz", Digest: hex.EncodeToString(sum[:])}
+
+	c := fakeConfig()
+	for i := range c.Hosts {
+		c.Hosts[i].WorkDir = filepath.Join(dir, c.Hosts[i].Name)
+		require.NoError(t, os.MkdirAll(c.Hosts[i].WorkDir, 0755))
+	}
+	execs := make(map[string]*localExecutor)
+	for _, h := range c.Hosts {
+		execs[h.Name] = new(localExecutor)
+	}
+	d := NewDeployment(c, func(h Host) (Executor, error) { return execs[h.Name], nil }, inventory.Commands{Curve: "bn256"})
+	ps, err := c.Layout(4, 0, 0)
+	require.NoError(t, err)
+	l, err := d.Launch(ps, 0, reg, filepath.Join(dir, "logs"))
+	require.NoError(t, err)
+	require.NoError(t, l.Wait())
+
+	for _, h := range c.Hosts {
+		received, err := ioutil.ReadFile(h.Files().Registry)
+		require.NoError(t, err)
+		sum := sha256.Sum256(received)
+		require.Equal(t, reg.Digest, hex.EncodeToString(sum[:]))
+		require.Len(t, execs[h.Name].streamed, 1)
+		require.True(t, strings.Contains(execs[h.Name].streamed[0], " -registry-digest "+reg.Digest+" "))
+	}
+
+	// a corrupted registry fails the launch
+	require.NoError(t, ioutil.WriteFile(reg.Compressed, compressed.Bytes()[:compressed.Len()/2], 0644))
+	_, err = d.Launch(ps, 1, reg, filepath.Join(dir, "logs"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "gzip")
+}
@@ -0,0 +1,170 @@
+// Package remote runs the nodes of a simulation on arbitrary Linux hosts
+// reachable over ssh, such as the machines of a lab. Each host has its own
+// user, key and working directory: the node binary, the config and the
+// registry are copied there, and the nodes run from there with their output
+// streamed back to the machine running the simulation.
+//
+// The nodes are laid out on the hosts as with the inventory package, and their
+// command line is the same.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+)
+
+// DefaultWorkDir is the working directory of a host without one
+const DefaultWorkDir = "/tmp/handel"
+
+// DefaultSSHPort is the port of the ssh server of a host without one
+const DefaultSSHPort = 22
+
+// Host is a machine the nodes run on
+type Host struct {
+	// Name identifies the host in the logs. The address is used if empty.
+	Name string
+	// Address is the hostname or IP the nodes of the host listen on, and the
+	// platform connects to over ssh
+	Address string
+	// SSHPort is DefaultSSHPort if zero
+	SSHPort int
+	// User and KeyFile are the user and the PEM encoded private key used to
+	// connect to the host
+	User    string
+	KeyFile string
+	// Nodes is the number of nodes the host can run
+	Nodes int
+	// BasePort is the first port of the range of the host, see
+	// inventory.Host
+	BasePort int
+	// WorkDir is the directory the files of the simulation are copied to,
+	// DefaultWorkDir if empty. It is removed by the cleanup.
+	WorkDir string
+}
+
+// Config describes the hosts and where the sync master and the monitor run
+type Config struct {
+	// Master is the IP, reachable by the hosts, of the machine running the
+	// simulation, where the sync master and the monitor listen
+	Master string
+	// MasterPort is the port of the sync master
+	MasterPort int
+	// Strategy lays out the nodes on the hosts: inventory.Fill, the default,
+	// or inventory.Stripe
+	Strategy string
+	// TargetSystem and TargetArch are the GOOS and GOARCH the node binary is
+	// built for, linux and amd64 if empty
+	TargetSystem string
+	TargetArch   string
+	// Binary is the path of a node binary built beforehand, copied as is to
+	// the hosts. The binary is built for the target if empty.
+	Binary string
+	// RegistryS3 is the "bucket/prefix" of S3 the compressed registry of each
+	// run is uploaded to, with the aws CLI, for the hosts to download it in
+	// parallel instead of receiving it from the machine running the
+	// simulation. The registries are public-read and named by their digest.
+	// The registry is sent to the hosts if empty.
+	RegistryS3 string
+	Hosts      []Host
+}
+
+// Load reads the TOML encoded config at the given path, and validates it
+func Load(path string) (*Config, error) {
+	c := new(Config)
+	if _, err := toml.DecodeFile(path, c); err != nil {
+		return nil, err
+	}
+	c.setDefaults()
+	return c, c.Validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Strategy == "" {
+		c.Strategy = inventory.Fill
+	}
+	if c.TargetSystem == "" {
+		c.TargetSystem = "linux"
+	}
+	if c.TargetArch == "" {
+		c.TargetArch = "amd64"
+	}
+	for i := range c.Hosts {
+		h := &c.Hosts[i]
+		if h.Name == "" {
+			h.Name = h.Address
+		}
+		if h.SSHPort == 0 {
+			h.SSHPort = DefaultSSHPort
+		}
+		if h.WorkDir == "" {
+			h.WorkDir = DefaultWorkDir
+		}
+	}
+}
+
+// Validate returns an error if the config can't be used
+func (c *Config) Validate() error {
+	if err := c.inventory().Validate(); err != nil {
+		return err
+	}
+	for _, h := range c.Hosts {
+		if h.User == "" || h.KeyFile == "" {
+			return fmt.Errorf("remote: host %s: no ssh user or key file", h.Name)
+		}
+		if !path.IsAbs(h.WorkDir) || path.Clean(h.WorkDir) == "/" {
+			return fmt.Errorf("remote: host %s: work dir %q must be an absolute path other than /", h.Name, h.WorkDir)
+		}
+	}
+	return nil
+}
+
+// RegistryObject returns the S3 object the compressed registry of the given
+// digest is uploaded to, and the URL the hosts download it from. Both are
+// empty without RegistryS3.
+func (c *Config) RegistryObject(digest string) (object, url string) {
+	if c.RegistryS3 == "" {
+		return "", ""
+	}
+	name := strings.Trim(c.RegistryS3, "/") + "/registry-" + digest + ".csv.gz"
+	return "s3://" + name, "https://s3.amazonaws.com/" + name
+}
+
+// inventory returns the hosts as an inventory, to lay out the nodes
+func (c *Config) inventory() *inventory.Inventory {
+	inv := &inventory.Inventory{
+		Master:       c.Master,
+		MasterPort:   c.MasterPort,
+		Strategy:     c.Strategy,
+		TargetSystem: c.TargetSystem,
+		TargetArch:   c.TargetArch,
+	}
+	for _, h := range c.Hosts {
+		inv.Hosts = append(inv.Hosts, inventory.Host{
+			Name:     h.Name,
+			Address:  h.Address,
+			BasePort: h.BasePort,
+			MaxNodes: h.Nodes,
+		})
+	}
+	return inv
+}
+
+// Layout assigns the nodes of a run to the hosts, see inventory.Layout
+func (c *Config) Layout(nodes, offline, levelPorts int) ([]*inventory.Placement, error) {
+	return c.inventory().Layout(nodes, offline, levelPorts)
+}
+
+// Host returns the host of the given name
+func (c *Config) Host(name string) (Host, error) {
+	for _, h := range c.Hosts {
+		if h.Name == name {
+			return h, nil
+		}
+	}
+	return Host{}, errors.New("remote: no host " + name)
+}
@@ -0,0 +1,104 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeConfig() *Config {
+	c := &Config{
+		Master:     "10.0.0.1",
+		MasterPort: 5000,
+		Hosts: []Host{
+			{Name: "a", Address: "10.0.0.10", User: "lab", KeyFile: "/key", Nodes: 2, BasePort: 3000},
+			{Name: "b", Address: "10.0.0.11", User: "lab", KeyFile: "/key", Nodes: 2, BasePort: 4000, WorkDir: "/var/tmp/b"},
+		},
+	}
+	c.setDefaults()
+	return c
+}
+
+func TestConfigLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "remote")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+Master = "10.0.0.1"
+MasterPort = 5000
+
+[[Hosts]]
+Address = "10.0.0.10"
+User = "lab"
+KeyFile = "/key"
+Nodes = 4
+BasePort = 3000
+`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	c, err := Load(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, inventory.Fill, c.Strategy)
+	require.Equal(t, "linux", c.TargetSystem)
+	require.Equal(t, "amd64", c.TargetArch)
+	h := c.Hosts[0]
+	require.Equal(t, "10.0.0.10", h.Name)
+	require.Equal(t, DefaultSSHPort, h.SSHPort)
+	require.Equal(t, DefaultWorkDir, h.WorkDir)
+
+	c, err = Load("../../ssh_example.toml")
+	require.NoError(t, err)
+	require.Len(t, c.Hosts, 2)
+	require.Equal(t, 2222, c.Hosts[1].SSHPort)
+	require.Equal(t, "/var/tmp/handel", c.Hosts[1].WorkDir)
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, fakeConfig().Validate())
+
+	var tests = []func(c *Config){
+		func(c *Config) { c.Master = "" },
+		func(c *Config) { c.Hosts[1].User = "" },
+		func(c *Config) { c.Hosts[1].KeyFile = "" },
+		func(c *Config) { c.Hosts[1].WorkDir = "handel" },
+		func(c *Config) { c.Hosts[1].WorkDir = "/" },
+		func(c *Config) { c.Hosts[1].Nodes = 0 },
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		c := fakeConfig()
+		test(c)
+		require.Error(t, c.Validate())
+	}
+}
+
+func TestConfigLayout(t *testing.T) {
+	c := fakeConfig()
+	ps, err := c.Layout(3, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, ps, 2)
+	require.Equal(t, "a", ps[0].Host.Name)
+	require.Len(t, ps[0].Nodes, 2)
+	require.Equal(t, "10.0.0.11:4000", ps[1].Nodes[0].Address)
+
+	h, err := c.Host("b")
+	require.NoError(t, err)
+	require.Equal(t, "/var/tmp/b", h.WorkDir)
+	_, err = c.Host("c")
+	require.Error(t, err)
+}
+
+func TestConfigRegistryObject(t *testing.T) {
+	c := fakeConfig()
+	object, url := c.RegistryObject("abcd")
+	require.Empty(t, object)
+	require.Empty(t, url)
+	c.RegistryS3 = "bucket/handel/"
+	object, url = c.RegistryObject("abcd")
+	require.Equal(t, "s3://bucket/handel/registry-abcd.csv.gz", object)
+	require.Equal(t, "https://s3.amazonaws.com/bucket/handel/registry-abcd.csv.gz", url)
+}
@@ -0,0 +1,315 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+)
+
+// Executor runs commands on a host and copies files to it
+type Executor interface {
+	// Run runs the command and returns an error if it fails
+	Run(cmd string) error
+	// Stream runs the command, writing its output and errors to w until it
+	// exits
+	Stream(cmd string, w io.Writer) error
+	// Copy copies the local file to the remote path, with the same mode
+	Copy(local, remote string) error
+	Close() error
+}
+
+// Dialer connects to a host
+type Dialer func(Host) (Executor, error)
+
+// HostErrors are the errors of the hosts, by name
+type HostErrors map[string]error
+
+func (e HostErrors) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("host %s: %s", name, e[name])
+	}
+	return "remote: " + strings.Join(msgs, "; ")
+}
+
+// Files are the paths of the files of a simulation on a host
+type Files struct {
+	Binary   string
+	Config   string
+	Registry string
+	// Compressed is the registry as received, before its decompression
+	Compressed string
+}
+
+// Files returns the paths of the files in the work dir of the host
+func (h Host) Files() Files {
+	return Files{
+		Binary:     path.Join(h.WorkDir, "node"),
+		Config:     path.Join(h.WorkDir, "config.toml"),
+		Registry:   path.Join(h.WorkDir, "registry.csv"),
+		Compressed: path.Join(h.WorkDir, "registry.csv.gz"),
+	}
+}
+
+// Registry is the registry of a run as sent to the hosts: gzip compressed
+// once for all of them, and pinned by the digest of its content, which the
+// nodes check before loading it
+type Registry struct {
+	// Compressed is the local path of the compressed registry
+	Compressed string
+	// Digest is the digest of the registry, see lib.RegistryDigest
+	Digest string
+	// URL, if not empty, is where the hosts download the compressed registry
+	// from instead of receiving it from the machine running the simulation
+	URL string
+}
+
+// FetchCommand returns the command downloading the compressed registry of
+// the URL to the host
+func FetchCommand(h Host, url string) string {
+	return "curl -fsS --retry " + strconv.Itoa(DefaultChunkRetries) + " -o " + quote(h.Files().Compressed) + " " + quote(url)
+}
+
+// DecompressCommand returns the command decompressing the registry received
+// by the host
+func DecompressCommand(h Host) string {
+	f := h.Files()
+	return "gzip -dc " + quote(f.Compressed) + " > " + quote(f.Registry)
+}
+
+// Deployment runs the steps of a simulation on the hosts: Prepare copies the
+// binary and the config once, Launch starts the nodes of each run, Kill stops
+// them, and Cleanup stops them and removes the work dirs. Each step runs on
+// all the hosts concurrently, and returns the errors of all the hosts that
+// failed.
+type Deployment struct {
+	conf *Config
+	dial Dialer
+	// the arguments shared by the command lines of the hosts, whose paths
+	// are the ones of each host
+	Base inventory.Commands
+}
+
+// NewDeployment returns a Deployment over the hosts of the config, connecting
+// to them with the dialer
+func NewDeployment(c *Config, dial Dialer, base inventory.Commands) *Deployment {
+	return &Deployment{conf: c, dial: dial, Base: base}
+}
+
+// Commands returns the commands of the host
+func (d *Deployment) Commands(h Host) *inventory.Commands {
+	f := h.Files()
+	c := d.Base
+	c.BinPath, c.ConfPath, c.RegPath = f.Binary, f.Config, f.Registry
+	return &c
+}
+
+// pushRegistry gets the compressed registry to the host, by chunks from the
+// machine running the simulation or from its URL, and decompresses it
+func pushRegistry(h Host, exec Executor, reg Registry) error {
+	var err error
+	if reg.URL != "" {
+		err = exec.Run(FetchCommand(h, reg.URL))
+	} else {
+		err = copyResumable(exec, reg.Compressed, h.Files().Compressed)
+	}
+	if err != nil {
+		return err
+	}
+	return exec.Run(DecompressCommand(h))
+}
+
+// KillCommand returns the command stopping the nodes of the host
+func KillCommand(h Host) string {
+	return "pkill -f " + quote(h.Files().Binary) + " || true"
+}
+
+// CleanupCommand returns the command stopping the nodes of the host and
+// removing its work dir
+func CleanupCommand(h Host) string {
+	return KillCommand(h) + "; rm -rf " + quote(h.WorkDir)
+}
+
+// quote quotes the argument for sh
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// onHosts runs the function on each host concurrently with a connection to
+// it, and returns the errors of the hosts
+func (d *Deployment) onHosts(hosts []Host, fn func(Host, Executor) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(HostErrors)
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h Host) {
+			defer wg.Done()
+			err := d.withHost(h, fn)
+			if err != nil {
+				mu.Lock()
+				errs[h.Name] = err
+				mu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (d *Deployment) withHost(h Host, fn func(Host, Executor) error) error {
+	exec, err := d.dial(h)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
+	return fn(h, exec)
+}
+
+// Prepare stops the nodes left by a previous simulation on all the hosts,
+// and copies the binary and the config to their work dirs
+func (d *Deployment) Prepare(binary, config string) error {
+	return d.onHosts(d.conf.Hosts, func(h Host, exec Executor) error {
+		f := h.Files()
+		steps := []func() error{
+			func() error { return exec.Run(KillCommand(h)) },
+			func() error { return exec.Run("mkdir -p " + quote(h.WorkDir)) },
+			func() error { return exec.Copy(binary, f.Binary) },
+			func() error { return exec.Run("chmod +x " + quote(f.Binary)) },
+			func() error { return exec.Copy(config, f.Config) },
+		}
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Launch sends the registry to the hosts of the placements, and starts their
+// active nodes for the given run, which refuse a registry of another digest.
+// The output of the nodes of each host is
+// written to <host>-<run>.log in the logs directory. If a host fails before
+// its nodes start, no node is started and the errors are returned. Otherwise
+// the returned Launch waits for the nodes.
+func (d *Deployment) Launch(placements []*inventory.Placement, run int, reg Registry, logs string) (*Launch, error) {
+	type started struct {
+		host  Host
+		exec  Executor
+		start string
+	}
+	var mu sync.Mutex
+	var ready []started
+	var hosts []Host
+	starts := make(map[string]string)
+	for _, pl := range placements {
+		h, err := d.conf.Host(pl.Host.Name)
+		if err != nil {
+			return nil, err
+		}
+		cmds := d.Commands(h)
+		cmds.RegDigest = reg.Digest
+		if start := cmds.Start(pl, run); start != "" {
+			hosts = append(hosts, h)
+			starts[h.Name] = start
+		}
+	}
+	if err := os.MkdirAll(logs, 0777); err != nil {
+		return nil, err
+	}
+	// the connections stay open for the nodes to run over them
+	err := d.onHosts(hosts, func(h Host, exec Executor) error {
+		if err := pushRegistry(h, exec, reg); err != nil {
+			return err
+		}
+		start := "cd " + quote(h.WorkDir) + " && exec " + starts[h.Name]
+		s, err := d.dial(h)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		ready = append(ready, started{h, s, start})
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		for _, s := range ready {
+			s.exec.Close()
+		}
+		return nil, err
+	}
+
+	l := &Launch{errs: make(HostErrors)}
+	for _, s := range ready {
+		out, err := os.Create(filepath.Join(logs, fmt.Sprintf("%s-%d.log", s.host.Name, run)))
+		if err != nil {
+			for _, s := range ready {
+				s.exec.Close()
+			}
+			return nil, err
+		}
+		l.wg.Add(1)
+		go func(s started, out *os.File) {
+			defer l.wg.Done()
+			defer s.exec.Close()
+			defer out.Close()
+			fmt.Printf("[+] %s: %s\n", s.host.Name, s.start)
+			if err := s.exec.Stream(s.start, out); err != nil {
+				l.Lock()
+				l.errs[s.host.Name] = err
+				l.Unlock()
+			}
+		}(s, out)
+	}
+	return l, nil
+}
+
+// Kill stops the nodes of all the hosts
+func (d *Deployment) Kill() error {
+	return d.onHosts(d.conf.Hosts, func(h Host, exec Executor) error {
+		return exec.Run(KillCommand(h))
+	})
+}
+
+// Cleanup stops the nodes of all the hosts and removes their work dirs
+func (d *Deployment) Cleanup() error {
+	return d.onHosts(d.conf.Hosts, func(h Host, exec Executor) error {
+		return exec.Run(CleanupCommand(h))
+	})
+}
+
+// Launch are the nodes started on the hosts
+type Launch struct {
+	sync.Mutex
+	wg   sync.WaitGroup
+	errs HostErrors
+}
+
+// Wait waits for the nodes of all the hosts to exit, and returns the errors of
+// the hosts whose nodes failed
+func (l *Launch) Wait() error {
+	l.wg.Wait()
+	l.Lock()
+	defer l.Unlock()
+	if len(l.errs) > 0 {
+		return l.errs
+	}
+	return nil
+}
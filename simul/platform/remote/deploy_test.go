@@ -0,0 +1,196 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHosts records the actions run on the hosts instead of connecting to
+// them
+type fakeHosts struct {
+	sync.Mutex
+	// actions run on each host, by name
+	actions map[string][]string
+	// hosts failing to connect
+	down map[string]bool
+	// output of the nodes of each host
+	output string
+}
+
+func newFakeHosts() *fakeHosts {
+	return &fakeHosts{actions: make(map[string][]string), down: make(map[string]bool), output: "node output\n"}
+}
+
+func (f *fakeHosts) dial(h Host) (Executor, error) {
+	if f.down[h.Name] {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeExecutor{f, h.Name}, nil
+}
+
+func (f *fakeHosts) record(host, action string) {
+	f.Lock()
+	defer f.Unlock()
+	f.actions[host] = append(f.actions[host], action)
+}
+
+func (f *fakeHosts) of(host string) []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.actions[host]
+}
+
+type fakeExecutor struct {
+	f    *fakeHosts
+	host string
+}
+
+func (e *fakeExecutor) Run(cmd string) error {
+	e.f.record(e.host, "run "+cmd)
+	return nil
+}
+
+func (e *fakeExecutor) Stream(cmd string, w io.Writer) error {
+	e.f.record(e.host, "stream "+cmd)
+	_, err := io.WriteString(w, e.f.output)
+	return err
+}
+
+func (e *fakeExecutor) Copy(local, remote string) error {
+	e.f.record(e.host, "copy "+filepath.Base(local)+" "+remote)
+	return nil
+}
+
+func (e *fakeExecutor) Close() error { return nil }
+
+func fakeDeployment(f *fakeHosts) *Deployment {
+	return NewDeployment(fakeConfig(), f.dial, inventory.Commands{
+		Curve:   "bn256",
+		Master:  "10.0.0.1:5000",
+		Monitor: "10.0.0.1:10000",
+	})
+}
+
+func TestDeploymentPrepare(t *testing.T) {
+	f := newFakeHosts()
+	d := fakeDeployment(f)
+	require.NoError(t, d.Prepare("/tmp/ssh.bin", "/tmp/ssh.conf"))
+	require.Equal(t, []string{
+		"run pkill -f '/tmp/handel/node' || true",
+		"run mkdir -p '/tmp/handel'",
+		"copy ssh.bin /tmp/handel/node",
+		"run chmod +x '/tmp/handel/node'",
+		"copy ssh.conf /tmp/handel/config.toml",
+	}, f.of("a"))
+	require.Equal(t, "copy ssh.bin /var/tmp/b/node", f.of("b")[2])
+
+	require.NoError(t, d.Cleanup())
+	require.Equal(t, "run pkill -f '/var/tmp/b/node' || true; rm -rf '/var/tmp/b'", f.of("b")[5])
+
+	// the failing hosts are named
+	f.down["a"] = true
+	err := d.Prepare("/tmp/ssh.bin", "/tmp/ssh.conf")
+	require.Error(t, err)
+	require.Equal(t, "remote: host a: connection refused", err.Error())
+	require.Len(t, err.(HostErrors), 1)
+}
+
+func TestDeploymentLaunch(t *testing.T) {
+	logs, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(logs)
+
+	f := newFakeHosts()
+	d := fakeDeployment(f)
+	ps, err := d.conf.Layout(3, 1, 0)
+	require.NoError(t, err)
+	l, err := d.Launch(ps, 2, Registry{Compressed: "/tmp/ssh.csv.gz", Digest: "abcd"}, logs)
+	require.NoError(t, err)
+	require.NoError(t, l.Wait())
+
+	for _, h := range d.conf.Hosts {
+		actions := f.of(h.Name)
+		require.Len(t, actions, 3)
+		require.Equal(t, "copy ssh.csv.gz "+h.Files().Compressed, actions[0])
+		require.Equal(t, "run gzip -dc '"+h.WorkDir+"/registry.csv.gz' > '"+h.WorkDir+"/registry.csv'", actions[1])
+		require.True(t, strings.HasPrefix(actions[2], "stream cd '"+h.WorkDir+"' && exec "+h.WorkDir+"/node"+
+			" -config "+h.WorkDir+"/config.toml -registry "+h.WorkDir+"/registry.csv"+
+			" -curve bn256 -master 10.0.0.1:5000 -monitor 10.0.0.1:10000 -registry-digest abcd -id "), actions[2])
+		out, err := ioutil.ReadFile(filepath.Join(logs, fmt.Sprintf("%s-2.log", h.Name)))
+		require.NoError(t, err)
+		require.Equal(t, f.output, string(out))
+	}
+	// the node 0 is offline
+	require.Contains(t, f.of("a")[2], " -id 1 -sync 10.0.0.10:3002 -run 2")
+	require.Contains(t, f.of("b")[2], " -id 2 -sync 10.0.0.11:4002 -run 2")
+
+	// the hosts download the registry from its URL
+	f = newFakeHosts()
+	d = fakeDeployment(f)
+	l, err = d.Launch(ps, 3, Registry{Compressed: "/tmp/ssh.csv.gz", Digest: "abcd", URL: "https://s3.amazonaws.com/bucket/registry-abcd.csv.gz"}, logs)
+	require.NoError(t, err)
+	require.NoError(t, l.Wait())
+	require.Equal(t, "run curl -fsS --retry 5 -o '/tmp/handel/registry.csv.gz' 'https://s3.amazonaws.com/bucket/registry-abcd.csv.gz'", f.of("a")[0])
+	require.Equal(t, "run gzip -dc '/tmp/handel/registry.csv.gz' > '/tmp/handel/registry.csv'", f.of("a")[1])
+}
+
+func TestDeploymentLaunchFailure(t *testing.T) {
+	logs, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(logs)
+
+	f := newFakeHosts()
+	f.down["b"] = true
+	d := fakeDeployment(f)
+	ps, err := d.conf.Layout(4, 0, 0)
+	require.NoError(t, err)
+	l, err := d.Launch(ps, 1, Registry{Compressed: "/tmp/ssh.csv.gz"}, logs)
+	require.Nil(t, l)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "host b: connection refused")
+	// no node is started on the hosts that could be reached
+	for _, a := range f.of("a") {
+		require.False(t, strings.HasPrefix(a, "stream"), a)
+	}
+	require.Empty(t, f.of("b"))
+}
+
+// TestDeploymentLocalhost runs a command on localhost over ssh with the key
+// of the HANDEL_SSH_KEY variable, and is skipped if it isn't set.
+func TestDeploymentLocalhost(t *testing.T) {
+	key := os.Getenv("HANDEL_SSH_KEY")
+	if key == "" {
+		t.Skip("HANDEL_SSH_KEY not set")
+	}
+	u, err := user.Current()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	h := Host{Name: "localhost", Address: "127.0.0.1", SSHPort: DefaultSSHPort, User: u.Username, KeyFile: key, WorkDir: filepath.Join(dir, "work")}
+	exec, err := DialSSH(h)
+	require.NoError(t, err)
+	defer exec.Close()
+	require.NoError(t, exec.Run("mkdir -p "+quote(h.WorkDir)))
+	local := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(local, []byte("handel"), 0644))
+	require.NoError(t, exec.Copy(local, h.Files().Config))
+	var out strings.Builder
+	require.NoError(t, exec.Stream("cat "+quote(h.Files().Config), &out))
+	require.Equal(t, "handel", out.String())
+	require.NoError(t, exec.Run(CleanupCommand(h)))
+	_, err = os.Stat(h.WorkDir)
+	require.True(t, os.IsNotExist(err))
+}
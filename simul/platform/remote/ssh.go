@@ -0,0 +1,146 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshExecutor is an Executor over an ssh connection
+type sshExecutor struct {
+	client *ssh.Client
+}
+
+// DialSSH is the Dialer connecting to the hosts over ssh with their user and
+// key
+func DialSSH(h Host) (Executor, error) {
+	pemBytes, err := ioutil.ReadFile(h.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s", h.KeyFile, err)
+	}
+	config := &ssh.ClientConfig{
+		User:            h.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(h.Address, strconv.Itoa(h.SSHPort)), config)
+	if err != nil {
+		return nil, err
+	}
+	return &sshExecutor{client: client}, nil
+}
+
+func (s *sshExecutor) Run(cmd string) error {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if out, err := session.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("%s: %s: %s", cmd, err, out)
+	}
+	return nil
+}
+
+func (s *sshExecutor) Stream(cmd string, w io.Writer) error {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = w
+	session.Stderr = w
+	return session.Run(cmd)
+}
+
+func (s *sshExecutor) Copy(local, remote string) error {
+	client, err := sftp.NewClient(s.client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := client.Create(remote)
+	if err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	return client.Chmod(remote, info.Mode())
+}
+
+// withSFTP runs the function with an sftp client over the connection
+func (s *sshExecutor) withSFTP(fn func(*sftp.Client) error) error {
+	client, err := sftp.NewClient(s.client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return fn(client)
+}
+
+// Truncate implements the ChunkWriter interface
+func (s *sshExecutor) Truncate(remote string, size int64) error {
+	return s.withSFTP(func(client *sftp.Client) error {
+		f, err := client.OpenFile(remote, os.O_WRONLY|os.O_CREATE)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return f.Truncate(size)
+	})
+}
+
+// Size implements the ChunkWriter interface
+func (s *sshExecutor) Size(remote string) (int64, error) {
+	var size int64
+	err := s.withSFTP(func(client *sftp.Client) error {
+		info, err := client.Stat(remote)
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// WriteAt implements the ChunkWriter interface
+func (s *sshExecutor) WriteAt(remote string, chunk []byte, off int64) error {
+	return s.withSFTP(func(client *sftp.Client) error {
+		f, err := client.OpenFile(remote, os.O_WRONLY)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = f.Write(chunk)
+		return err
+	})
+}
+
+func (s *sshExecutor) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,195 @@
+package platform
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/monitor"
+	"github.com/ConsenSys/handel/simul/platform/inventory"
+	"github.com/ConsenSys/handel/simul/platform/remote"
+)
+
+// sshPlatform runs the nodes on the hosts of a remote config, over ssh. The
+// sync master and the monitor run locally, on the master address of the
+// config.
+type sshPlatform struct {
+	c        *lib.Config
+	conf     *remote.Config
+	deploy   *remote.Deployment
+	keys     *lib.KeyCache
+	regPath  string
+	binPath  string
+	confPath string
+	csvFile  *os.File
+	// header is true once the header of the csv file is written
+	header bool
+}
+
+// NewSSH returns a Platform running the nodes on the hosts of the remote
+// config at the given path, see the remote package.
+func NewSSH(path string) Platform {
+	conf, err := remote.Load(path)
+	if err != nil {
+		panic(err)
+	}
+	return &sshPlatform{
+		conf:     conf,
+		keys:     lib.NewKeyCache(),
+		regPath:  "/tmp/ssh.csv",
+		binPath:  "/tmp/ssh.bin",
+		confPath: "/tmp/ssh.conf",
+	}
+}
+
+// RegistryPath implements the Artifacts interface
+func (p *sshPlatform) RegistryPath() string { return p.regPath }
+
+// BinaryPath implements the Artifacts interface
+func (p *sshPlatform) BinaryPath() string { return p.binPath }
+
+func (p *sshPlatform) Configure(c *lib.Config) error {
+	p.c = c
+	p.deploy = remote.NewDeployment(p.conf, remote.DialSSH, inventory.Commands{
+		Master:  net.JoinHostPort(p.conf.Master, strconv.Itoa(p.conf.MasterPort)),
+		Monitor: c.GetMonitorAddress(p.conf.Master),
+		LogSink: c.LogSink,
+	})
+	if p.conf.Binary != "" {
+		p.binPath = p.conf.Binary
+	} else {
+		// Compile the binary for the hosts
+		cmd := NewCommand("go", "build", "-o", p.binPath, c.GetBinaryPath())
+		cmd.Env = append(os.Environ(), "GOOS="+p.conf.TargetSystem, "GOARCH="+p.conf.TargetArch)
+		if err := cmd.Run(); err != nil {
+			fmt.Println("command output -> " + cmd.ReadAll())
+			return err
+		}
+	}
+	if err := c.WriteTo(p.confPath); err != nil {
+		return err
+	}
+	if err := p.deploy.Prepare(p.binPath, p.confPath); err != nil {
+		return err
+	}
+	csvFile, err := os.Create(c.GetResultsFile())
+	if err != nil {
+		return err
+	}
+	p.csvFile = csvFile
+	return nil
+}
+
+func (p *sshPlatform) Cleanup() error {
+	if p.csvFile != nil {
+		p.csvFile.Close()
+	}
+	if p.deploy == nil {
+		return nil
+	}
+	return p.deploy.Cleanup()
+}
+
+// registry compresses the registry for the hosts, and uploads it to S3 if the
+// hosts download it from there
+func (p *sshPlatform) registry() (remote.Registry, error) {
+	reg := remote.Registry{Compressed: p.regPath + lib.CompressedExt}
+	digest, err := lib.CompressRegistry(p.regPath, reg.Compressed)
+	if err != nil {
+		return reg, err
+	}
+	reg.Digest = digest
+	object, url := p.conf.RegistryObject(digest)
+	if object == "" {
+		return reg, nil
+	}
+	cmd := NewCommand("aws", "s3", "cp", reg.Compressed, object, "--acl", "public-read")
+	if err := cmd.Run(); err != nil {
+		fmt.Println("command output -> " + cmd.ReadAll())
+		return reg, err
+	}
+	reg.URL = url
+	fmt.Println("[+] Registry uploaded to", object)
+	return reg, nil
+}
+
+func (p *sshPlatform) Start(idx int, r *lib.RunConfig) error {
+	// 1. lay out the nodes on the hosts and write the registry
+	placements, err := p.conf.Layout(r.Nodes, r.Failing, p.c.LevelPorts(r.Nodes))
+	if err != nil {
+		return err
+	}
+	allocation := make(map[string][]*lib.NodeInfo)
+	for _, pl := range placements {
+		for _, n := range pl.Nodes {
+			allocation[pl.Host.Name] = append(allocation[pl.Host.Name], &lib.NodeInfo{ID: n.ID, Active: n.Active, Address: n.Address})
+		}
+	}
+	curve := p.c.GetCurve(r)
+	p.deploy.Base.Curve = curve
+	nodes := p.keys.GenerateNodesFromAllocation(curve, lib.NewCurveConstructor(curve), allocation)
+	lib.WriteAll(nodes, lib.NewCSVParser(), p.regPath)
+	fmt.Println("[+] Registry file written (", r.Nodes, " nodes,", curve, ")")
+	reg, err := p.registry()
+	if err != nil {
+		return err
+	}
+
+	// 2. run the monitor and the sync master
+	stats := defaultStats(p.c, idx, r)
+	mon := monitor.NewMonitor(p.c.MonitorPort, stats)
+	if p.c.RawDump {
+		raw, err := monitor.NewRawWriter(p.c.GetResultsDir(), idx, stats, 0)
+		if err != nil {
+			return err
+		}
+		defer raw.Close()
+		mon.SetRawDump(raw)
+	}
+	go mon.Listen()
+	defer mon.Stop()
+	master := lib.NewSyncMaster(net.JoinHostPort(p.conf.Master, strconv.Itoa(p.conf.MasterPort)), r.Nodes-r.Failing, r.Nodes)
+	master.SetPolicy(p.c.NewReleasePolicy())
+	defer master.Stop()
+
+	// 3. start the nodes, their output streamed to the logs
+	logs := filepath.Join(p.c.GetResultsDir(), "logs")
+	launch, err := p.deploy.Launch(placements, idx, reg, logs)
+	if err != nil {
+		return err
+	}
+	// the nodes still running are stopped on the way out
+	defer launch.Wait()
+	defer p.deploy.Kill()
+
+	// 4. wait for the nodes to sync up and to finish
+	select {
+	case <-master.WaitAll(lib.START):
+		fmt.Printf("[+] Master full synchronization done.\n")
+	case <-time.After(5 * time.Minute):
+		return fmt.Errorf("nodes not started after 5mn")
+	}
+	select {
+	case <-master.WaitAll(lib.END):
+		fmt.Printf("[+] Master - finished synchronization done.\n")
+	case <-time.After(p.c.GetMaxTimeout()):
+		return fmt.Errorf("timeout after %s", p.c.GetMaxTimeout())
+	}
+
+	// 5. write the stats, once the measures are in
+	time.Sleep(time.Second)
+	for k, v := range master.Stats(offlineIDs(allocation)) {
+		stats.SetStatic(k, v)
+	}
+	if !p.header {
+		stats.WriteHeader(p.csvFile)
+		p.header = true
+	}
+	stats.WriteValues(p.csvFile)
+	fmt.Printf("[+] SSH round %d finished, stats written to\n\t%s\n", idx, p.c.GetResultsFile())
+	return nil
+}
@@ -8,15 +8,58 @@ import (
 )
 
 func defaultStats(c *lib.Config, i int, r *lib.RunConfig) *monitor.Stats {
-	return DefaultStats(i, r.Nodes, r.Threshold, c.Network)
+	stats := monitor.NewStats(StaticValues(c, i, r), nil)
+	stats.SetMaxSamples(c.MaxSamples)
+	return stats
+}
+
+// StaticValues returns the static fields written by the platforms in the
+// results of the i-th run, derived from the config only.
+func StaticValues(c *lib.Config, i int, r *lib.RunConfig) map[string]string {
+	defaults := defaultValues(i, r.Nodes, r.GetThreshold(), c.Network)
+	defaults["threshold_frac"] = strconv.FormatFloat(r.ThresholdFraction(), 'f', -1, 64)
+	defaults["curve"] = c.GetCurve(r)
+	defaults["updatePayload"] = c.GetUpdatePayload(r)
+	defaults["repetitions"] = strconv.Itoa(r.GetRepetitions())
+	defaults["protocol"] = r.GetProtocol()
+	for k, v := range r.GetChurn(i).Stats() {
+		defaults[k] = v
+	}
+	for k, v := range r.UploadStats(i) {
+		defaults[k] = v
+	}
+	for k, v := range r.TopologyStats() {
+		defaults[k] = v
+	}
+	for k, v := range r.SeedStats() {
+		defaults[k] = v
+	}
+	return defaults
+}
+
+// offlineIDs returns the ids of the inactive nodes of the allocation
+func offlineIDs(allocation map[string][]*lib.NodeInfo) map[int]bool {
+	offline := make(map[int]bool)
+	for _, nodes := range allocation {
+		for _, n := range nodes {
+			if !n.Active {
+				offline[n.ID] = true
+			}
+		}
+	}
+	return offline
 }
 
 // DefaultStats returns default stats
 func DefaultStats(run int, nodes int, threshold int, network string) *monitor.Stats {
-	return monitor.NewStats(map[string]string{
+	return monitor.NewStats(defaultValues(run, nodes, threshold, network), nil)
+}
+
+func defaultValues(run int, nodes int, threshold int, network string) map[string]string {
+	return map[string]string{
 		"run":       strconv.Itoa(run),
 		"nodes":     strconv.Itoa(nodes),
 		"threshold": strconv.Itoa(threshold),
 		"network":   network,
-	}, nil)
+	}
 }
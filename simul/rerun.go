@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/ConsenSys/handel/simul/platform"
+)
+
+// rerunColumn marks the rows of the merged results coming from a rerun
+const rerunColumn = "rerun"
+
+// results holds the content of a results csv file
+type results struct {
+	header []string
+	rows   [][]string
+}
+
+func readResults(path string) (*results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	// the rows of runs with different measures have different lengths
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no header", path)
+	}
+	return &results{header: records[0], rows: records[1:]}, nil
+}
+
+func (r *results) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(r.header)
+	w.WriteAll(r.rows)
+	return w.Error()
+}
+
+// column returns the index of the column, -1 if absent
+func (r *results) column(name string) int {
+	for i, h := range r.header {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the value of the column of the row, empty if absent
+func (r *results) get(row []string, name string) string {
+	i := r.column(name)
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// run returns the index of the run of the row
+func (r *results) run(row []string) (int, error) {
+	run, err := strconv.Atoi(r.get(row, "run"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid run index: %s", err)
+	}
+	return run, nil
+}
+
+// failedRuns returns the index of the runs which did not reach their
+// threshold or were aborted, in the order of the results.
+func failedRuns(r *results) ([]int, error) {
+	for _, col := range []string{"run", "threshold_met", "aborted"} {
+		if r.column(col) < 0 {
+			return nil, fmt.Errorf("no %s column in the results", col)
+		}
+	}
+	var failed []int
+	for _, row := range r.rows {
+		run, err := r.run(row)
+		if err != nil {
+			return nil, err
+		}
+		met, err := strconv.ParseBool(r.get(row, "threshold_met"))
+		if err != nil {
+			return nil, fmt.Errorf("run %d: invalid threshold_met: %s", run, err)
+		}
+		aborted, err := strconv.ParseBool(r.get(row, "aborted"))
+		if err != nil {
+			return nil, fmt.Errorf("run %d: invalid aborted: %s", run, err)
+		}
+		if !met || aborted {
+			failed = append(failed, run)
+		}
+	}
+	return failed, nil
+}
+
+// resultSeeds gives the runs of the config without a seed the seed they are
+// recorded with in the results, if any.
+func resultSeeds(c *lib.Config, r *results) error {
+	if r.column("seed") < 0 {
+		return nil
+	}
+	for _, row := range r.rows {
+		run, err := r.run(row)
+		if err != nil {
+			return err
+		}
+		if run < 0 || run >= len(c.Runs) || c.Runs[run].Seed != 0 {
+			continue
+		}
+		seed, err := strconv.ParseInt(r.get(row, "seed"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("run %d: invalid seed: %s", run, err)
+		}
+		c.Runs[run].Seed = seed
+	}
+	return nil
+}
+
+// checkStatic returns an error listing the static fields of the results
+// which differ from the ones derived from the config. The fields absent from
+// the results are not checked.
+func checkStatic(c *lib.Config, r *results) error {
+	var diff []string
+	for _, row := range r.rows {
+		run, err := r.run(row)
+		if err != nil {
+			return err
+		}
+		if run < 0 || run >= len(c.Runs) {
+			diff = append(diff, fmt.Sprintf("run %d: not in the config of %d runs", run, len(c.Runs)))
+			continue
+		}
+		static := platform.StaticValues(c, run, &c.Runs[run])
+		var keys []string
+		for k := range static {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if r.column(k) < 0 {
+				continue
+			}
+			if v := r.get(row, k); v != static[k] {
+				diff = append(diff, fmt.Sprintf("run %d: %s: results=%q config=%q", run, k, v, static[k]))
+			}
+		}
+	}
+	if len(diff) > 0 {
+		return errors.New("results don't match the config:\n\t" + strings.Join(diff, "\n\t"))
+	}
+	return nil
+}
+
+// mergeResults replaces the rows of the results by the rows of the same run
+// of the rerun, marked with a 1 in the rerun column. The columns of the rerun
+// absent from the results are appended.
+func mergeResults(old, rerun *results) (*results, error) {
+	header := append([]string{}, old.header...)
+	for _, h := range append(rerun.header, rerunColumn) {
+		found := false
+		for _, h2 := range header {
+			found = found || h == h2
+		}
+		if !found {
+			header = append(header, h)
+		}
+	}
+	merged := &results{header: header}
+	convert := func(r *results, row []string, marker string) []string {
+		out := make([]string, len(header))
+		for i, h := range header {
+			out[i] = r.get(row, h)
+		}
+		if marker != "" {
+			out[merged.column(rerunColumn)] = marker
+		} else if out[merged.column(rerunColumn)] == "" {
+			out[merged.column(rerunColumn)] = "0"
+		}
+		return out
+	}
+	reruns := make(map[int][]string)
+	var order []int
+	for _, row := range rerun.rows {
+		run, err := rerun.run(row)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := reruns[run]; !ok {
+			order = append(order, run)
+		}
+		reruns[run] = convert(rerun, row, "1")
+	}
+	for _, row := range old.rows {
+		run, err := old.run(row)
+		if err != nil {
+			return nil, err
+		}
+		if rr, ok := reruns[run]; ok {
+			merged.rows = append(merged.rows, rr)
+			delete(reruns, run)
+			continue
+		}
+		merged.rows = append(merged.rows, convert(old, row, ""))
+	}
+	for _, run := range order {
+		if row, ok := reruns[run]; ok {
+			merged.rows = append(merged.rows, row)
+		}
+	}
+	return merged, nil
+}
+
+// rerunFailed runs again the failed runs of the results file at the given
+// path, with their Retrials multiplied by the factor, and merges the new
+// rows in the results file. The static fields of the results must match the
+// config. The rows of the rerun are first written in the results directory,
+// under the name of the results file followed by "-rerun".
+func rerunFailed(c *lib.Config, path string, factor int, runAll func(c *lib.Config, runs []int)) error {
+	old, err := readResults(path)
+	if err != nil {
+		return err
+	}
+	failed, err := failedRuns(old)
+	if err != nil {
+		return err
+	}
+	// the failed runs are run again with their seed
+	if err := resultSeeds(c, old); err != nil {
+		return err
+	}
+	c.ResolveSeeds()
+	if err := checkStatic(c, old); err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		fmt.Printf("[+] no failed run in %s\n", path)
+		return nil
+	}
+	fmt.Printf("[+] running again the failed runs %v\n", failed)
+	c.Retrials *= factor
+	c.ResultFile = strings.TrimSuffix(filepath.Base(path), ".csv") + "-rerun.csv"
+	runAll(c, failed)
+
+	rerun, err := readResults(c.GetResultsFile())
+	if err != nil {
+		return err
+	}
+	merged, err := mergeResults(old, rerun)
+	if err != nil {
+		return err
+	}
+	return merged.write(path)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/handel/simul/lib"
+	"github.com/stretchr/testify/require"
+)
+
+func rerunConfig() *lib.Config {
+	return &lib.Config{
+		Network:  "udp",
+		Curve:    "fake",
+		Retrials: 2,
+		Runs: []lib.RunConfig{
+			{Nodes: 10, Threshold: lib.Threshold{Count: 6}},
+			{Nodes: 20, Threshold: lib.Threshold{Count: 11}},
+			{Nodes: 30, Threshold: lib.Threshold{Count: 16}},
+		},
+	}
+}
+
+// inTempDir runs the test in a temporary directory holding the results
+// directory
+func inTempDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "rerun")
+	require.NoError(t, err)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	require.NoError(t, os.MkdirAll("results", 0777))
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+const sweepResults = `run,nodes,threshold,network,curve,threshold_met,aborted,sigen_wall_avg
+0,10,6,udp,fake,true,false,100
+1,20,11,udp,fake,false,false,5000
+2,30,16,udp,fake,true,false,300
+`
+
+func TestRerunFailed(t *testing.T) {
+	defer inTempDir(t)()
+	path := filepath.Join("results", "sweep.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte(sweepResults), 0644))
+
+	c := rerunConfig()
+	var runs []int
+	err := rerunFailed(c, path, 3, func(c *lib.Config, r []int) {
+		runs = r
+		require.Equal(t, 6, c.Retrials)
+		require.Equal(t, filepath.Join("results", "sweep-rerun.csv"), c.GetResultsFile())
+		rerun := "run,nodes,threshold,network,curve,threshold_met,aborted,sigen_wall_avg,sync_release\n" +
+			"1,20,11,udp,fake,true,false,200,fraction:0.995\n"
+		require.NoError(t, ioutil.WriteFile(c.GetResultsFile(), []byte(rerun), 0644))
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, runs)
+
+	merged, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "run,nodes,threshold,network,curve,threshold_met,aborted,sigen_wall_avg,sync_release,rerun\n"+
+		"0,10,6,udp,fake,true,false,100,,0\n"+
+		"1,20,11,udp,fake,true,false,200,fraction:0.995,1\n"+
+		"2,30,16,udp,fake,true,false,300,,0\n", string(merged))
+}
+
+func TestRerunFailedErrors(t *testing.T) {
+	var tests = []struct {
+		results string
+		// expected substring of the error
+		err string
+	}{
+		{"run,nodes,threshold\n0,10,6\n", "no threshold_met column"},
+		{"run,threshold_met,aborted\n0,maybe,false\n", "invalid threshold_met"},
+		// the config has 20 nodes for the run 1
+		{strings.Replace(sweepResults, "1,20,11", "1,25,11", 1), `run 1: nodes: results="25" config="20"`},
+		{sweepResults + "3,10,6,udp,fake,false,false,0\n", "run 3: not in the config"},
+		{"run,threshold_met,aborted,seed\n0,false,false,abc\n", "run 0: invalid seed"},
+	}
+	defer inTempDir(t)()
+	path := filepath.Join("results", "sweep.csv")
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.results), 0644))
+		err := rerunFailed(rerunConfig(), path, 2, func(c *lib.Config, r []int) {
+			t.Fatal("runs started")
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), test.err)
+	}
+}
+
+func TestRerunFailedSeed(t *testing.T) {
+	defer inTempDir(t)()
+	path := filepath.Join("results", "sweep.csv")
+	results := "run,nodes,threshold,threshold_met,aborted,seed\n" +
+		"0,10,6,true,false,42\n" +
+		"1,20,11,false,false,-7\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(results), 0644))
+
+	c := rerunConfig()
+	// the seed of the config wins, the runs absent from the results get one
+	c.Runs[0].Seed = 42
+	err := rerunFailed(c, path, 2, func(c *lib.Config, r []int) {
+		require.Equal(t, []int{1}, r)
+		require.Equal(t, int64(42), c.Runs[0].Seed)
+		require.Equal(t, int64(-7), c.Runs[1].Seed)
+		require.NotEqual(t, int64(0), c.Runs[2].Seed)
+		rerun := "run,nodes,threshold,threshold_met,aborted,seed\n1,20,11,true,false,-7\n"
+		require.NoError(t, ioutil.WriteFile(c.GetResultsFile(), []byte(rerun), 0644))
+	})
+	require.NoError(t, err)
+
+	// a seed differing from the results is reported
+	c = rerunConfig()
+	c.Runs[0].Seed = 43
+	err = rerunFailed(c, path, 2, func(c *lib.Config, r []int) {
+		t.Fatal("runs started")
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `run 0: seed: results="42" config="43"`)
+}
+
+func TestRerunNoFailure(t *testing.T) {
+	defer inTempDir(t)()
+	path := filepath.Join("results", "sweep.csv")
+	results := strings.Replace(sweepResults, "false,false", "true,false", 1)
+	require.NoError(t, ioutil.WriteFile(path, []byte(results), 0644))
+	err := rerunFailed(rerunConfig(), path, 2, func(c *lib.Config, r []int) {
+		t.Fatal("runs started")
+	})
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, results, string(content))
+}
@@ -0,0 +1,117 @@
+package handel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UpdateOutcome is what an update of a level did: sent the signature of the
+// level to some peers, or nothing for the reason given
+type UpdateOutcome int
+
+const (
+	// UpdateSent is an update sent to at least one peer
+	UpdateSent UpdateOutcome = iota
+	// UpdateNotStarted is a level whose timeout did not start it yet
+	UpdateNotStarted
+	// UpdateFinished is an aggregation completed, see
+	// Config.BroadcastCompletion: nothing is sent anymore
+	UpdateFinished
+	// UpdateNoPending is a level whose peers all have our current signature
+	UpdateNoPending
+	// UpdateEmptyStore is a level the store had no signature to send to
+	UpdateEmptyStore
+	// UpdateNoPeer is an update whose peers were all left out by the resend
+	// filter, see Config.ResendIdenticalAfter
+	UpdateNoPeer
+	numUpdateOutcomes
+)
+
+var updateOutcomeNames = [numUpdateOutcomes]string{
+	"sent", "not_started", "finished", "no_pending", "empty_store", "no_peer",
+}
+
+func (o UpdateOutcome) String() string {
+	if o < 0 || o >= numUpdateOutcomes {
+		return fmt.Sprintf("outcome(%d)", int(o))
+	}
+	return updateOutcomeNames[o]
+}
+
+// UpdateRecord is the outcome of an update of a level
+type UpdateRecord struct {
+	// Tick is the number of periodic updates done before this one
+	Tick    int
+	Outcome UpdateOutcome
+	// Peers is the number of peers the update was sent to
+	Peers int
+}
+
+// updateLogSize is the number of outcomes kept for each level
+const updateLogSize = 8
+
+// updateLog records the outcomes of the updates: the last ones of each
+// level, and the count of each outcome since the start
+type updateLog struct {
+	recent map[int][]UpdateRecord
+	counts [numUpdateOutcomes]int
+}
+
+func newUpdateLog() *updateLog {
+	return &updateLog{recent: make(map[int][]UpdateRecord)}
+}
+
+// recordUpdate records the outcome of an update of the level. A level the
+// store has no signature for is logged with the state of the store when it
+// starts to be. The lock must be held.
+func (h *Handel) recordUpdate(l *level, o UpdateOutcome, peers int) {
+	u := h.updates
+	recent := u.recent[l.id]
+	if o == UpdateEmptyStore && (len(recent) == 0 || recent[len(recent)-1].Outcome != o) {
+		st := statusOf(h.store, h.Partitioner, h.c.NewBitSet)
+		h.log.Warn("update_skipped", l.id, "reason", o, "store", st.line(h.threshold))
+	}
+	if len(recent) == updateLogSize {
+		recent = append(recent[:0], recent[1:]...)
+	}
+	u.recent[l.id] = append(recent, UpdateRecord{Tick: h.ticks, Outcome: o, Peers: peers})
+	u.counts[o]++
+}
+
+// RecentUpdates returns the outcomes of the last updates of the level, the
+// oldest first, to tell why Handel sends nothing at a level.
+func (h *Handel) RecentUpdates(level int) []UpdateRecord {
+	h.Lock()
+	defer h.Unlock()
+	return append([]UpdateRecord(nil), h.updates.recent[level]...)
+}
+
+// updateOutcomeValues returns the number of updates of each outcome
+func (h *Handel) updateOutcomeValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	values := make(map[string]float64, numUpdateOutcomes)
+	for o, count := range h.updates.counts {
+		values[UpdateOutcome(o).String()] = float64(count)
+	}
+	return values
+}
+
+// updatesLine returns the last outcome of each level for the status line,
+// e.g. "upd[1:sent 2:no_pending 3:not_started]", empty before any update
+func (h *Handel) updatesLine() string {
+	h.Lock()
+	defer h.Unlock()
+	var outcomes []string
+	for _, id := range h.ids {
+		recent := h.updates.recent[id]
+		if len(recent) == 0 {
+			continue
+		}
+		outcomes = append(outcomes, fmt.Sprintf("%d:%s", id, recent[len(recent)-1].Outcome))
+	}
+	if len(outcomes) == 0 {
+		return ""
+	}
+	return " upd[" + strings.Join(outcomes, " ") + "]"
+}
@@ -0,0 +1,122 @@
+package handel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// nilCombinedStore has no signature to combine for any level
+type nilCombinedStore struct {
+	SignatureStore
+}
+
+func (n *nilCombinedStore) Combined(level byte) *MultiSignature { return nil }
+
+func lastUpdate(t *testing.T, h *Handel, level int) UpdateRecord {
+	recent := h.RecentUpdates(level)
+	require.NotEmpty(t, recent, "level %d", level)
+	return recent[len(recent)-1]
+}
+
+func TestUpdateOutcomes(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	logger := new(warnLogger)
+	conf := &Config{UpdateCount: 1, ResendIdenticalAfter: time.Hour, Logger: logger}
+	h := NewHandel(newSendsNetwork(false), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+
+	// the first level starts with Handel, the other ones are not started
+	// yet. The level 1 has a single peer, satisfied by the first update.
+	h.periodicUpdate()
+	require.Equal(t, UpdateRecord{Tick: 1, Outcome: UpdateSent, Peers: 1}, lastUpdate(t, h, 1))
+	for _, lvl := range h.ids[1:] {
+		require.Equal(t, UpdateRecord{Tick: 1, Outcome: UpdateNotStarted}, lastUpdate(t, h, lvl))
+	}
+	require.Contains(t, h.StatusLine(), " upd[1:sent 2:not_started 3:not_started 4:not_started]")
+	h.periodicUpdate()
+	require.Equal(t, UpdateNoPending, lastUpdate(t, h, 1).Outcome)
+
+	// the same signature is not sent again to the peer before an hour
+	h.Lock()
+	h.levels[1].rearm()
+	h.Unlock()
+	h.periodicUpdate()
+	require.Equal(t, UpdateNoPeer, lastUpdate(t, h, 1).Outcome)
+
+	// nothing is sent once the aggregation completed
+	h.StartLevel(3)
+	require.Equal(t, UpdateSent, lastUpdate(t, h, 3).Outcome)
+	h.Lock()
+	h.completion.done = true
+	h.Unlock()
+	h.periodicUpdate()
+	require.Equal(t, UpdateFinished, lastUpdate(t, h, 3).Outcome)
+	require.Equal(t, UpdateNotStarted, lastUpdate(t, h, 4).Outcome)
+
+	values := NewReportHandel(h).Values()
+	require.Equal(t, 2.0, values["update_sent"])
+	require.Equal(t, 1.0, values["update_no_pending"])
+	require.Equal(t, 11.0, values["update_not_started"])
+	require.Equal(t, 1.0, values["update_no_peer"])
+	require.Equal(t, 2.0, values["update_finished"])
+	require.Equal(t, 0.0, values["update_empty_store"])
+
+	// a few outcomes are kept per level
+	for i := 0; i < 2*updateLogSize; i++ {
+		h.periodicUpdate()
+	}
+	recent := h.RecentUpdates(4)
+	require.Len(t, recent, updateLogSize)
+	require.Equal(t, h.ticks, recent[updateLogSize-1].Tick)
+	require.Equal(t, h.ticks-updateLogSize+1, recent[0].Tick)
+}
+
+func TestUpdateOutcomeEmptyStore(t *testing.T) {
+	reg := FakeRegistry(16)
+	id, _ := reg.Identity(1)
+	logger := new(warnLogger)
+	h := NewHandel(newSendsNetwork(false), reg, id, new(fakeCons), msg, &fakeSig{true}, &Config{Logger: logger})
+	h.store = &nilCombinedStore{h.store}
+
+	// no panic: the skip is recorded, and logged once with the store
+	h.periodicUpdate()
+	require.Equal(t, UpdateRecord{Tick: 1, Outcome: UpdateEmptyStore}, lastUpdate(t, h, 1))
+	h.Lock()
+	h.levels[1].rearm()
+	h.Unlock()
+	h.periodicUpdate()
+	require.Equal(t, UpdateEmptyStore, lastUpdate(t, h, 1).Outcome)
+	var warns []string
+	for _, w := range logger.warnings() {
+		if strings.HasPrefix(w, "update_skipped") {
+			warns = append(warns, w)
+		}
+	}
+	require.Len(t, warns, 1)
+	require.Contains(t, warns[0], "empty_store")
+	require.Contains(t, warns[0], "lvls[0/4 done]")
+}
+
+func TestUpdateOutcomesFakeSetup(t *testing.T) {
+	_, handels := FakeSetup(32)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		go h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case <-h.FinalSignatures():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for node %d", h.id.ID())
+		}
+	}
+	for _, h := range handels {
+		values := h.updateOutcomeValues()
+		skipped := values["no_peer"] + values["empty_store"] + values["finished"]
+		require.True(t, values["sent"] > 2*skipped, "node %d: %v", h.id.ID(), values)
+	}
+}
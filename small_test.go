@@ -0,0 +1,85 @@
+package handel_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	bn256 "github.com/ConsenSys/handel/bn256/cf"
+	"github.com/ConsenSys/handel/handeltest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHandelSmallRegistries runs Handel over the smallest registries, with
+// both partitioners and both stores, and checks every node outputs a valid
+// full signature.
+func TestHandelSmallRegistries(t *testing.T) {
+	msg := []byte("Sun is Shining...")
+	cons := bn256.NewConstructor()
+	partitioners := map[string]string{
+		"binomial": handel.PartitionerDeterministic,
+		"random":   handel.PartitionerSharedSeed,
+	}
+	for n := 1; n <= 8; n++ {
+		reg, secrets := handeltest.NewBN256Registry(n)
+		for name, mode := range partitioners {
+			for _, report := range []bool{false, true} {
+				t.Logf(" -- test n=%d %s report=%v -- ", n, name, report)
+				config := handel.DefaultConfig(n)
+				config.Contributions = n
+				config.PartitionerMode = mode
+				config.PartitionerSeed = []byte("small registries")
+				nets := handeltest.NewBus(n).Networks()
+				handels := make([]*handel.Handel, n)
+				for i := range handels {
+					sig, err := secrets[i].Sign(msg, rand.Reader)
+					require.NoError(t, err)
+					id, _ := reg.Identity(i)
+					handels[i] = handel.NewHandel(nets[i], reg, id, cons, msg, sig, config)
+					if report {
+						handels[i] = handel.NewReportHandel(handels[i]).Handel
+					}
+					defer handels[i].Close()
+				}
+
+				// the levels cover all the other identities
+				part := handels[0].Partitioner
+				total := 0
+				for _, lvl := range part.Levels() {
+					require.True(t, part.Size(lvl) > 0)
+					total += part.Size(lvl)
+				}
+				require.Equal(t, n-1, total)
+
+				for _, h := range handels {
+					h.Start()
+				}
+				for i, h := range handels {
+					select {
+					case ms := <-h.FinalSignatures():
+						require.Equal(t, n, ms.BitLength())
+						require.Equal(t, n, ms.Cardinality())
+						require.NoError(t, handel.VerifyMultiSignature(msg, &ms, reg, cons))
+					case <-time.After(5 * time.Second):
+						t.Fatal(fmt.Sprintf("node %d: no final signature", i))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.Error(t, handel.DefaultConfig(0).Validate(0))
+	require.NoError(t, handel.DefaultConfig(1).Validate(1))
+	config := handel.DefaultConfig(4)
+	config.Contributions = 5
+	require.Error(t, config.Validate(4))
+	require.Panics(t, func() {
+		reg := handel.NewArrayRegistry(nil)
+		id := handel.NewStaticIdentity(0, "", nil)
+		handel.NewHandel(handeltest.NewBus(1).Network(0), reg, id, bn256.NewConstructor(), nil, nil)
+	})
+}
@@ -0,0 +1,92 @@
+package handel
+
+// StoreSnapshotter is implemented by the stores whose best signatures can be
+// read all at once, see Handel.Snapshot.
+type StoreSnapshotter interface {
+	// BestSnapshot returns the best multi-signature of each level as they are
+	// at a single point in time, and the sequence number of this state. The
+	// signatures must not be modified.
+	BestSnapshot() (map[byte]*MultiSignature, uint64)
+	// Seq returns the sequence number of the best signatures, incremented
+	// each time one of them changes.
+	Seq() uint64
+}
+
+// LevelSnapshot is the best signature of a level in an AggregationSnapshot
+type LevelSnapshot struct {
+	Level int
+	// Best is the best signature of the level, nil if none was stored
+	Best *MultiSignature
+	// Size is the number of nodes of the level
+	Size int
+	// Completed is true if the best signature has all the contributions of
+	// the level
+	Completed bool
+}
+
+// AggregationSnapshot is a copy of the best signatures of the levels taken at
+// once, see Handel.Snapshot. It is owned by the caller: the signatures stored
+// afterwards don't change it.
+type AggregationSnapshot struct {
+	// Seq is the sequence number of the store when the snapshot was taken:
+	// two snapshots of the same number have the same signatures. It is
+	// always zero with a store not implementing StoreSnapshotter, and it
+	// starts over after Reset.
+	Seq uint64
+	// Levels are the best signatures of the level 0, the own signature of
+	// the node, and of the levels of the partitioner, by increasing level
+	Levels []LevelSnapshot
+	// Full is the combination of the best signatures of the levels, nil if
+	// there are none
+	Full *MultiSignature
+}
+
+// Snapshot returns a copy of the best signatures of the levels and of their
+// combination, e.g. for a consensus layer to pick the signature of a block.
+// With a store implementing StoreSnapshotter, as the default one, the
+// signatures are read at once, while reading them with Best level by level
+// could mix signatures stored in between.
+func (h *Handel) Snapshot() AggregationSnapshot {
+	var best map[byte]*MultiSignature
+	var seq uint64
+	store := unwrapStore(h.store)
+	if s, ok := store.(StoreSnapshotter); ok {
+		best, seq = s.BestSnapshot()
+	} else {
+		best = make(map[byte]*MultiSignature)
+		for _, id := range append([]int{0}, h.ids...) {
+			if ms, ok := store.Best(byte(id)); ok && ms != nil {
+				best[byte(id)] = ms
+			}
+		}
+	}
+
+	snap := AggregationSnapshot{Seq: seq}
+	var sigs []*IncomingSig
+	for _, id := range append([]int{0}, h.ids...) {
+		lvl := LevelSnapshot{Level: id, Size: 1}
+		if id > 0 {
+			lvl.Size = len(h.levels[id].nodes)
+		}
+		if ms, ok := best[byte(id)]; ok && ms != nil {
+			sigs = append(sigs, &IncomingSig{level: byte(id), ms: ms})
+			lvl.Best = &MultiSignature{BitSet: ms.BitSet.Clone(), Signature: ms.Signature}
+			lvl.Completed = ms.Cardinality() == lvl.Size
+		}
+		snap.Levels = append(snap.Levels, lvl)
+	}
+	snap.Full = h.Partitioner.CombineFull(sigs, h.c.NewBitSet)
+	return snap
+}
+
+// SnapshotIfChanged returns a Snapshot if the best signatures changed since
+// the snapshot of the given sequence number, and false otherwise, without
+// copying anything. It always returns a snapshot with a store not
+// implementing StoreSnapshotter.
+func (h *Handel) SnapshotIfChanged(lastSeq uint64) (*AggregationSnapshot, bool) {
+	if s, ok := unwrapStore(h.store).(StoreSnapshotter); ok && s.Seq() == lastSeq {
+		return nil, false
+	}
+	snap := h.Snapshot()
+	return &snap, true
+}
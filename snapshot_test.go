@@ -0,0 +1,128 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireConsistentSnapshot checks the full signature of the snapshot is the
+// combination of the best signatures of its levels
+func requireConsistentSnapshot(t *testing.T, h *Handel, snap AggregationSnapshot) {
+	var sigs []*IncomingSig
+	for _, lvl := range snap.Levels {
+		if lvl.Best == nil {
+			require.False(t, lvl.Completed)
+			continue
+		}
+		require.Equal(t, lvl.Size, lvl.Best.BitLength())
+		require.Equal(t, lvl.Size == lvl.Best.Cardinality(), lvl.Completed)
+		sigs = append(sigs, &IncomingSig{level: byte(lvl.Level), ms: lvl.Best})
+	}
+	exp := h.Partitioner.CombineFull(sigs, NewWilffBitset)
+	require.NotNil(t, snap.Full)
+	require.Equal(t, exp.BitSet.String(), snap.Full.BitSet.String())
+}
+
+func TestHandelSnapshot(t *testing.T) {
+	n := 32
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = &warnLogger{}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+
+	// the own signature is stored at the creation
+	h := handels[0]
+	snap := h.Snapshot()
+	require.True(t, snap.Seq > 0)
+	require.Len(t, snap.Levels, len(h.ids)+1)
+	require.NotNil(t, snap.Levels[0].Best)
+	require.True(t, snap.Levels[0].Completed)
+	require.Equal(t, 1, snap.Full.Cardinality())
+	requireConsistentSnapshot(t, h, snap)
+	_, changed := h.SnapshotIfChanged(snap.Seq)
+	require.False(t, changed)
+
+	// the snapshots are taken while the signatures are stored
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(h *Handel) {
+			defer wg.Done()
+			var last uint64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap, changed := h.SnapshotIfChanged(last)
+				if !changed {
+					continue
+				}
+				require.True(t, snap.Seq >= last)
+				requireConsistentSnapshot(t, h, *snap)
+				// the snapshot is owned by the caller
+				snap.Full.Set(0, true)
+				for _, lvl := range snap.Levels {
+					if lvl.Best != nil {
+						lvl.Best.Set(0, false)
+					}
+				}
+				last = snap.Seq
+			}
+		}(handels[i])
+	}
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		for complete := false; !complete; {
+			select {
+			case ms := <-h.FinalSignatures():
+				complete = ms.Cardinality() == n
+			case <-time.After(5 * time.Second):
+				t.Fatalf("node %d did not get all the contributions", i)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// the copies changed by the observers left the store as is
+	snap = h.Snapshot()
+	requireConsistentSnapshot(t, h, snap)
+	require.Equal(t, n, snap.Full.Cardinality())
+	for _, lvl := range snap.Levels {
+		require.True(t, lvl.Completed, "level %d", lvl.Level)
+	}
+	_, changed = h.SnapshotIfChanged(snap.Seq)
+	require.False(t, changed)
+	again, changed := h.SnapshotIfChanged(snap.Seq - 1)
+	require.True(t, changed)
+	require.Equal(t, snap.Seq, again.Seq)
+}
+
+func TestStoreSeq(t *testing.T) {
+	n := 8
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	s := newStore(part, NewWilffBitset, new(fakeCons))
+	require.Equal(t, uint64(0), s.Seq())
+
+	s.Store(fullIncomingSig(2))
+	require.Equal(t, uint64(1), s.Seq())
+	// the signatures not improving the best one, and the invalid levels,
+	// leave it as is
+	s.Store(&IncomingSig{level: 2, ms: newSig(bitsOf(2, 0))})
+	s.Store(fullIncomingSig(7))
+	best, seq := s.BestSnapshot()
+	require.Equal(t, uint64(1), seq)
+	require.Len(t, best, 1)
+
+	s.Store(fullIncomingSig(3))
+	require.Equal(t, uint64(2), s.Seq())
+}
@@ -0,0 +1,167 @@
+package handel
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// stalenessAlpha is the weight of the last ratio in the EWMA of an origin
+const stalenessAlpha = 0.2
+
+// staleness tracks how far behind our progress the aggregates sent by each
+// origin are: the ratio of their cardinality to the one of our best signature
+// of their level at their arrival, capped at 1, averaged per origin with an
+// EWMA. An origin forwarding old aggregates stays well below 1.
+type staleness struct {
+	sync.Mutex
+	origins map[int32]*originStaleness
+	// number of origins of the registry, the others are never tracked
+	size int32
+	// number of evaluations down-weighted by the staleEvaluator
+	deprioritized int
+	// true once disabled by the observability budget
+	disabled bool
+}
+
+type originStaleness struct {
+	ewma    float64
+	packets int
+}
+
+func newStaleness(size int) *staleness {
+	return &staleness{origins: make(map[int32]*originStaleness), size: int32(size)}
+}
+
+// observe records the ratio of an aggregate of the origin
+func (s *staleness) observe(origin int32, ratio float64) {
+	if origin < 0 || origin >= s.size {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.disabled {
+		return
+	}
+	o, ok := s.origins[origin]
+	if !ok {
+		s.origins[origin] = &originStaleness{ewma: ratio, packets: 1}
+		return
+	}
+	o.ewma = stalenessAlpha*ratio + (1-stalenessAlpha)*o.ewma
+	o.packets++
+}
+
+// stale returns true if the EWMA of the origin is below the threshold after
+// at least min aggregates
+func (s *staleness) stale(origin int32, threshold float64, min int) bool {
+	s.Lock()
+	defer s.Unlock()
+	o, ok := s.origins[origin]
+	return ok && o.packets >= min && o.ewma < threshold
+}
+
+// ewma returns the EWMA of the origin, and false if it sent no aggregate
+func (s *staleness) ewma(origin int32) (float64, bool) {
+	s.Lock()
+	defer s.Unlock()
+	o, ok := s.origins[origin]
+	if !ok {
+		return 0, false
+	}
+	return o.ewma, true
+}
+
+// values returns the distribution of the EWMAs of the origins, the number of
+// stale origins and the number of evaluations down-weighted
+func (s *staleness) values(threshold float64, min int) map[string]float64 {
+	s.Lock()
+	defer s.Unlock()
+	var ewmas []float64
+	stale := 0
+	for _, o := range s.origins {
+		ewmas = append(ewmas, o.ewma)
+		if o.packets >= min && o.ewma < threshold {
+			stale++
+		}
+	}
+	sort.Float64s(ewmas)
+	quantile := func(q float64) float64 {
+		if len(ewmas) == 0 {
+			return 0
+		}
+		return ewmas[int(math.Floor(q*float64(len(ewmas)-1)))]
+	}
+	return map[string]float64{
+		"origins":       float64(len(ewmas)),
+		"stale":         float64(stale),
+		"min":           quantile(0),
+		"p10":           quantile(0.1),
+		"p50":           quantile(0.5),
+		"deprioritized": float64(s.deprioritized),
+	}
+}
+
+// observeStaleness records the ratio of the cardinality of the aggregate to
+// our best signature of its level. The individual signatures are not
+// tracked: they are always behind.
+func (h *Handel) observeStaleness(s *IncomingSig) {
+	if s.Individual() {
+		return
+	}
+	ratio := 1.0
+	if best, _ := h.store.Best(s.level); best != nil {
+		ratio = math.Min(1, float64(s.ms.Cardinality())/float64(best.Cardinality()))
+	}
+	h.staleness.observe(s.origin, ratio)
+}
+
+// approxSize implements the collector interface
+func (s *staleness) approxSize() int {
+	s.Lock()
+	defer s.Unlock()
+	return 64 + 48*len(s.origins)
+}
+
+// disable implements the collector interface. The origins are not stale
+// anymore.
+func (s *staleness) disable() {
+	s.Lock()
+	defer s.Unlock()
+	s.disabled = true
+	s.origins = make(map[int32]*originStaleness)
+}
+
+// stalenessValues returns the values of the staleness of the origins
+func (h *Handel) stalenessValues() map[string]float64 {
+	return h.staleness.values(h.c.StaleThreshold, h.c.StaleMinPackets)
+}
+
+// staleEvaluator gives the lowest positive score to the aggregates of the
+// stale origins, so they are verified last, see Config.DeprioritizeStale
+type staleEvaluator struct {
+	SigEvaluator
+	h *Handel
+}
+
+// Evaluate implements the SigEvaluator interface
+func (e *staleEvaluator) Evaluate(sp *IncomingSig) int {
+	score, _ := e.evaluateReason(sp)
+	return score
+}
+
+// evaluateReason implements the explainingEvaluator interface
+func (e *staleEvaluator) evaluateReason(sp *IncomingSig) (int, rejection) {
+	score, reason := evaluateReason(e.SigEvaluator, sp)
+	if score <= 1 || sp.Individual() {
+		return score, reason
+	}
+	s := e.h.staleness
+	if !s.stale(sp.origin, e.h.c.StaleThreshold, e.h.c.StaleMinPackets) {
+		return score, reason
+	}
+	s.Lock()
+	s.deprioritized++
+	s.Unlock()
+	return 1, ""
+}
@@ -0,0 +1,116 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStalenessEWMA(t *testing.T) {
+	s := newStaleness(16)
+	for i := 0; i < 20; i++ {
+		s.observe(1, 1)
+		s.observe(2, 0.25)
+		if i < 4 {
+			s.observe(3, 0.1)
+		}
+	}
+	fresh, _ := s.ewma(1)
+	require.Equal(t, 1.0, fresh)
+	stale, _ := s.ewma(2)
+	require.InDelta(t, 0.25, stale, 1e-9)
+	_, ok := s.ewma(4)
+	require.False(t, ok)
+
+	require.False(t, s.stale(1, 0.5, 10))
+	require.True(t, s.stale(2, 0.5, 10))
+	// not enough aggregates yet
+	require.False(t, s.stale(3, 0.5, 10))
+	require.True(t, s.stale(3, 0.5, 4))
+
+	values := s.values(0.5, 10)
+	require.Equal(t, 3.0, values["origins"])
+	require.Equal(t, 1.0, values["stale"])
+	require.InDelta(t, 0.1, values["min"], 1e-9)
+	require.InDelta(t, 0.25, values["p50"], 1e-9)
+
+	// the EWMA recovers
+	for i := 0; i < 20; i++ {
+		s.observe(2, 1)
+	}
+	require.False(t, s.stale(2, 0.5, 10))
+}
+
+func TestStalenessDeprioritized(t *testing.T) {
+	_, handels := FakeSetupWith(16, func(c *Config) { c.DeprioritizeStale = true })
+	defer CloseHandels(handels)
+	h := handels[0]
+	proc := h.proc.(*evaluatorProcessing)
+	// the nodes 8 to 15 are at the level 4
+	h.store.Store(&IncomingSig{level: 4, ms: newSig(bitsOf(8, 0, 1, 2, 3))})
+	for i := 0; i < DefaultStaleMinPackets; i++ {
+		h.NewPacket(aggregatePacket(t, 8, 4, 8, 0))
+		h.NewPacket(aggregatePacket(t, 9, 4, 8, 0, 1, 2, 3))
+	}
+	require.True(t, h.staleness.stale(8, h.c.StaleThreshold, h.c.StaleMinPackets))
+	require.False(t, h.staleness.stale(9, h.c.StaleThreshold, h.c.StaleMinPackets))
+
+	// the same aggregate from each origin, the fresh one comes first
+	bits := bitsOf(8, 4, 5)
+	stale := &IncomingSig{origin: 8, level: 4, ms: newSig(bits)}
+	fresh := &IncomingSig{origin: 9, level: 4, ms: newSig(bits.Clone())}
+	require.Equal(t, 1, proc.evaluator.Evaluate(stale))
+	require.True(t, proc.evaluator.Evaluate(fresh) > 1)
+	// nor the individual signatures
+	ind := individualSig(4, 8, 6)
+	ind.origin = 8
+	base := proc.evaluator.(*staleEvaluator).SigEvaluator
+	require.True(t, base.Evaluate(ind) > 1)
+	require.Equal(t, base.Evaluate(ind), proc.evaluator.Evaluate(ind))
+
+	proc.Add(stale)
+	proc.Add(fresh)
+	_, first := proc.readTodos()
+	require.Equal(t, int32(9), first.origin)
+	_, second := proc.readTodos()
+	require.Equal(t, int32(8), second.origin)
+	values := NewReportHandel(h).Values()
+	require.Equal(t, 1.0, values["staleness_stale"])
+	require.True(t, values["staleness_deprioritized"] >= 1)
+}
+
+// TestStalenessCompletion runs an aggregation with a peer forwarding old
+// aggregates, known as stale by the others from the start: its aggregates are
+// verified last but the aggregation still completes.
+func TestStalenessCompletion(t *testing.T) {
+	n := 16
+	_, handels := FakeSetupWith(n, func(c *Config) { c.DeprioritizeStale = true })
+	staleID := int32(3)
+	// the stale peer sends again the first packet of each level, as a peer
+	// forwarding old aggregates
+	net := &recordNetwork{Network: handels[staleID].net}
+	net.send = func(ids []Identity, p *Packet) {
+		first := net.first(p.Level)
+		net.Network.Send(ids, &Packet{
+			Origin:        first.Origin,
+			Level:         first.Level,
+			MultiSig:      first.MultiSig,
+			IndividualSig: first.IndividualSig,
+		})
+	}
+	handels[staleID].net = net
+	for _, h := range handels {
+		for i := 0; i < h.c.StaleMinPackets; i++ {
+			h.staleness.observe(staleID, 0.1)
+		}
+	}
+	waitFullSignatures(t, handels)
+
+	deprioritized := 0.0
+	for _, h := range handels {
+		deprioritized += h.stalenessValues()["deprioritized"]
+		// the down-weighting applies to the stale peer only
+		require.True(t, h.stalenessValues()["stale"] <= 1)
+	}
+	require.True(t, deprioritized > 0)
+}
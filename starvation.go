@@ -0,0 +1,116 @@
+package handel
+
+// LevelStarved is the event emitted when a level did not receive any verified
+// contribution after Handel contacted its whole candidate set twice, typically
+// because all the nodes of the level are down. The final signature can then
+// not contain any contribution from this level.
+type LevelStarved struct {
+	// Level is the starved level
+	Level int
+	// Unresponsive are the identities of the level: none of them contributed
+	Unresponsive []Identity
+	// MaxAchievable is the upper bound on the cardinality of the final
+	// signature given all the levels starved so far
+	MaxAchievable int
+}
+
+// checkStarvation looks at the started and incomplete levels that did not
+// receive any verified contribution. Such a level is starved once its
+// candidate set has been contacted twice. A level that contacted its
+// candidate set once and stopped sending is re-armed so that its candidate set
+// is contacted again. The lock must be held.
+func (h *Handel) checkStarvation() {
+	for _, id := range h.ids {
+		lvl := h.levels[id]
+		if !lvl.started() || lvl.rcvCompleted {
+			continue
+		}
+		if _, received := h.store.Best(byte(id)); received {
+			// a late contribution lifts the starvation
+			delete(h.starved, id)
+			continue
+		}
+		if h.starved[id] {
+			continue
+		}
+		if lvl.contacted >= 2*len(lvl.nodes) {
+			h.starve(lvl)
+		} else if !lvl.active() {
+			lvl.rearm()
+		}
+	}
+	h.checkReachable()
+}
+
+// starve records the level as starved and emits the event, in a routine
+// joined by Close.
+func (h *Handel) starve(lvl *level) {
+	h.starved[lvl.id] = true
+	event := LevelStarved{
+		Level:         lvl.id,
+		Unresponsive:  append([]Identity{}, lvl.nodes...),
+		MaxAchievable: h.maxAchievable(),
+	}
+	h.log.Warn("level_starved", lvl.id, "unresponsive", len(lvl.nodes), "max_achievable", event.MaxAchievable)
+	if cb := h.c.OnLevelStarved; cb != nil {
+		h.spawn(func() { cb(event) })
+	}
+}
+
+// checkReachable emits the failure event, in a routine joined by Close, when
+// the starved levels cap the final signature below the threshold. It is
+// emitted once, until late contributions lift enough starvations to make the
+// threshold reachable again. The lock must be held.
+func (h *Handel) checkReachable() {
+	if h.done {
+		return
+	}
+	max := h.maxAchievable()
+	if max >= h.threshold {
+		h.unreachable = false
+		return
+	}
+	if h.unreachable {
+		return
+	}
+	h.unreachable = true
+	h.log.Warn("threshold_unreachable", h.threshold, "max_achievable", max)
+	if cb := h.c.OnThresholdUnreachable; cb != nil {
+		h.spawn(func() { cb(max) })
+	}
+}
+
+// maxAchievable returns the number of identities minus the ones of the
+// starved levels.
+func (h *Handel) maxAchievable() int {
+	max := h.subtreeSize()
+	for id := range h.starved {
+		max -= len(h.levels[id].nodes)
+	}
+	return max
+}
+
+// MaxAchievableCardinality returns an upper bound on the cardinality of the
+// final signature, given the levels detected as starved so far. It is the
+// number of identities as long as no level is starved.
+func (h *Handel) MaxAchievableCardinality() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.maxAchievable()
+}
+
+// starvationValues returns the number of starved levels, the upper bound on
+// the final cardinality and whether the threshold is known to be unreachable.
+func (h *Handel) starvationValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	unreachable := 0.0
+	if h.unreachable {
+		unreachable = 1
+	}
+	return map[string]float64{
+		"levels":        float64(len(h.starved)),
+		"maxAchievable": float64(h.maxAchievable()),
+		"unreachable":   unreachable,
+	}
+}
@@ -0,0 +1,134 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandelStarvation(t *testing.T) {
+	n := 16
+	// the nodes 8 to 15 are down: the last level of the others is starved
+	dead := 8
+	// each live node sees the same starved level
+	starved := make(chan LevelStarved, n)
+	unreachable := make(chan int, n)
+	config := DefaultConfig(n)
+	config.Logger = &warnLogger{}
+	config.StarvationCheck = 2
+	config.OnLevelStarved = func(e LevelStarved) {
+		starved <- e
+	}
+	config.OnThresholdUnreachable = func(max int) {
+		unreachable <- max
+	}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	for _, h := range handels[:dead] {
+		h.Start()
+	}
+
+	for i := 0; i < dead; i++ {
+		select {
+		case e := <-starved:
+			require.Equal(t, 4, e.Level)
+			require.Len(t, e.Unresponsive, n-dead)
+			for _, id := range e.Unresponsive {
+				require.True(t, id.ID() >= int32(dead))
+			}
+			require.Equal(t, dead, e.MaxAchievable)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no starvation detected")
+		}
+		select {
+		case max := <-unreachable:
+			require.Equal(t, dead, max)
+		case <-time.After(time.Second):
+			t.Fatal("unreachable threshold not signaled")
+		}
+	}
+	require.Equal(t, dead, handels[0].MaxAchievableCardinality())
+	values := handels[0].starvationValues()
+	require.Equal(t, 1.0, values["levels"])
+	require.Equal(t, 1.0, values["unreachable"])
+}
+
+func TestHandelNoStarvation(t *testing.T) {
+	n := 16
+	config := DefaultConfig(n)
+	config.Contributions = n
+	config.Logger = &warnLogger{}
+	config.StarvationCheck = 1
+	config.OnLevelStarved = func(e LevelStarved) {
+		t.Errorf("level %d starved", e.Level)
+	}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	for _, h := range handels {
+		h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case <-h.FinalSignatures():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no final signature")
+		}
+		require.Equal(t, n, h.MaxAchievableCardinality())
+	}
+}
+
+func TestHandelStarvationCallbacks(t *testing.T) {
+	n := 16
+	config := DefaultConfig(n)
+	config.Logger = &warnLogger{}
+	handels := fakeHandels(t, n, config)
+	defer CloseHandels(handels)
+	h := handels[0]
+	// the callbacks run outside of the lock: they can call Handel's methods
+	starved := make(chan int, 1)
+	unreachable := make(chan int, 1)
+	h.c.OnLevelStarved = func(e LevelStarved) {
+		starved <- h.MaxAchievableCardinality()
+	}
+	h.c.OnThresholdUnreachable = func(max int) {
+		unreachable <- max
+	}
+	await := func(ch chan int) int {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("callback not called")
+		}
+		return 0
+	}
+
+	h.Lock()
+	h.starve(h.levels[4])
+	h.checkReachable()
+	require.True(t, h.unreachable)
+	h.Unlock()
+	require.Equal(t, 8, await(starved))
+	require.Equal(t, 8, await(unreachable))
+
+	// a late contribution lifts the starvation
+	h.Lock()
+	delete(h.starved, 4)
+	h.checkReachable()
+	require.False(t, h.unreachable)
+	h.Unlock()
+
+	// the threshold is signaled again once unreachable again, and the
+	// callback can stop Handel
+	h.c.OnThresholdUnreachable = func(max int) {
+		h.Stop()
+		unreachable <- max
+	}
+	h.Lock()
+	h.starved[4] = true
+	h.checkReachable()
+	h.Unlock()
+	require.Equal(t, 8, await(unreachable))
+	require.Equal(t, Starved, waitDone(t, h).Outcome)
+}
@@ -0,0 +1,188 @@
+package handel
+
+import (
+	"fmt"
+	"time"
+)
+
+// levelStatus is the state of a level of a store
+type levelStatus struct {
+	level int
+	// number of contributions expected at the level
+	size int
+	// best multi-signature of the level, nil if none
+	best *MultiSignature
+	// number of individual signatures held for the level
+	individuals int
+}
+
+func (l *levelStatus) cardinality() int {
+	if l.best == nil {
+		return 0
+	}
+	return l.best.Cardinality()
+}
+
+func (l *levelStatus) complete() bool {
+	return l.size > 0 && l.cardinality() == l.size
+}
+
+// storeStatus is a snapshot of the content of a store, built without holding
+// the lock of the store while formatting it.
+type storeStatus struct {
+	// the level 0 followed by the levels of the partitioner
+	levels []levelStatus
+	full   *MultiSignature
+	// estimated memory held by the signatures, in bytes
+	mem int
+}
+
+// statusStore is implemented by the stores able to snapshot their status
+type statusStore interface {
+	status() storeStatus
+}
+
+// status implements the statusStore interface. Only the references to the
+// signatures are copied under the lock, the store never modifies them.
+func (r *store) status() storeStatus {
+	levels := append([]int{0}, r.part.Levels()...)
+	st := storeStatus{levels: make([]levelStatus, len(levels))}
+	var individuals []*MultiSignature
+	r.Lock()
+	for i, lvl := range levels {
+		st.levels[i] = levelStatus{
+			level:       lvl,
+			size:        r.part.Size(lvl),
+			best:        r.m[byte(lvl)],
+			individuals: len(r.individualSigs[byte(lvl)]),
+		}
+		for _, ms := range r.individualSigs[byte(lvl)] {
+			individuals = append(individuals, ms)
+		}
+	}
+	r.Unlock()
+	st.complete(r.part, r.nbs, individuals)
+	return st
+}
+
+// statusOf returns the status of the store, using only the SignatureStore
+// interface if the store can't snapshot itself: the individual signatures
+// are then unknown.
+func statusOf(s SignatureStore, part Partitioner, nbs func(int) BitSet) storeStatus {
+	if r, ok := s.(*ReportStore); ok {
+		s = r.SignatureStore
+	}
+	if ss, ok := s.(statusStore); ok {
+		return ss.status()
+	}
+	levels := append([]int{0}, part.Levels()...)
+	st := storeStatus{levels: make([]levelStatus, len(levels))}
+	for i, lvl := range levels {
+		best, _ := s.Best(byte(lvl))
+		st.levels[i] = levelStatus{level: lvl, size: part.Size(lvl), best: best}
+	}
+	st.complete(part, nbs, nil)
+	return st
+}
+
+// complete computes the full signature and the memory of the status from
+// the best signatures of its levels and the given individual signatures.
+func (st *storeStatus) complete(part Partitioner, nbs func(int) BitSet, individuals []*MultiSignature) {
+	var sigs []*IncomingSig
+	all := individuals
+	for _, l := range st.levels {
+		if l.best != nil {
+			sigs = append(sigs, &IncomingSig{level: byte(l.level), ms: l.best})
+			all = append(all, l.best)
+		}
+	}
+	if len(sigs) > 0 {
+		st.full = part.CombineFull(sigs, nbs)
+	}
+	if len(all) == 0 {
+		return
+	}
+	// the signatures of a scheme all have the same size
+	sig, err := all[0].Signature.MarshalBinary()
+	if err != nil {
+		return
+	}
+	for _, ms := range all {
+		st.mem += (ms.BitLength()+7)/8 + len(sig)
+	}
+}
+
+// line returns the status on a single line, e.g.
+// "lvls[3/5 done] full=412/512 thr=387 indiv=87 mem=1.2MB". The level 0 and
+// its individual signature, our own, are not counted.
+func (st *storeStatus) line(threshold int) string {
+	var done, indiv int
+	for _, l := range st.levels[1:] {
+		if l.complete() {
+			done++
+		}
+		indiv += l.individuals
+	}
+	var full, size int
+	if st.full != nil {
+		full, size = st.full.Cardinality(), st.full.BitLength()
+	}
+	return fmt.Sprintf("lvls[%d/%d done] full=%d/%d thr=%d indiv=%d mem=%s",
+		done, len(st.levels)-1, full, size, threshold, indiv, formatBytes(st.mem))
+}
+
+// formatBytes returns the size in bytes in a short human readable form
+func formatBytes(n int) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%dB", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	}
+}
+
+// StatusLine returns the progress of Handel on a single line suitable for
+// periodic logging: the number of completed levels, the cardinality of the
+// full signature, the threshold, the number of individual signatures held
+// and the estimated memory of the stored signatures, followed by the outcome
+// of the last update of each level once updates were made, see
+// RecentUpdates. See Config.StatusLogPeriod.
+func (h *Handel) StatusLine() string {
+	st := statusOf(h.store, h.Partitioner, h.c.NewBitSet)
+	return st.line(h.threshold) + h.updatesLine()
+}
+
+// statusLoop logs the status line every StatusLogPeriod until Handel stops,
+// and follows the changes of the period
+func (h *Handel) statusLoop() {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	reset := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if d := h.StatusLogPeriod(); d > 0 {
+			ticker = time.NewTicker(d)
+			tick = ticker.C
+		}
+	}
+	reset()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-tick:
+			h.log.Info("status", h.StatusLine())
+		case <-h.tuning.reset:
+			reset()
+		case <-h.stopCh:
+			return
+		}
+	}
+}
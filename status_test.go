@@ -0,0 +1,157 @@
+package handel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreStatus(t *testing.T) {
+	n := 16
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	s := newStore(part, NewWilffBitset, new(fakeCons))
+	st := s.status()
+	require.Equal(t, "lvls[0/4 done] full=0/0 thr=9 indiv=0 mem=0B", st.line(9))
+
+	s.Store(fullIncomingSig(1))
+	s.Store(individualSig(3, 4, 2))
+	s.Store(individualSig(3, 4, 0))
+	st = s.status()
+	// 4 signatures of 1 byte, with bitsets of 1 byte
+	require.Equal(t, "lvls[1/4 done] full=3/16 thr=9 indiv=2 mem=8B", st.line(9))
+	require.Equal(t, "replaceStore table:\n"+
+		"\tlevel 0 : 0/1 indiv=0 complete=false\n"+
+		"\tlevel 1 : 1/1 indiv=0 complete=true\n"+
+		"\tlevel 2 : 0/2 indiv=0 complete=false\n"+
+		"\tlevel 3 : 2/4 indiv=2 complete=false\n"+
+		"\tlevel 4 : 0/8 indiv=0 complete=false\n"+
+		"\t --> full sig: 3/16 mem=8B", s.String())
+
+	// the stores which can't snapshot themselves only report the best
+	// signatures
+	st = statusOf(&noStatusStore{s}, part, NewWilffBitset)
+	require.Equal(t, "lvls[1/4 done] full=3/16 thr=9 indiv=0 mem=4B", st.line(9))
+}
+
+// noStatusStore hides the status of the store
+type noStatusStore struct {
+	SignatureStore
+}
+
+func TestFormatBytes(t *testing.T) {
+	var tests = []struct {
+		n   int
+		exp string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KB"},
+		{1536, "1.5KB"},
+		{1258291, "1.2MB"},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		require.Equal(t, test.exp, formatBytes(test.n))
+	}
+}
+
+func TestHandelStatusLine(t *testing.T) {
+	_, handels := FakeSetup(16)
+	defer CloseHandels(handels)
+	h := NewReportHandel(handels[0])
+	// our own signature only, as best and individual signature of level 0
+	require.Equal(t, "lvls[0/4 done] full=1/16 thr=9 indiv=0 mem=4B", h.StatusLine())
+}
+
+// statusLogger records the times of the status lines logged, and signals
+// each of them on logged
+type statusLogger struct {
+	sync.Mutex
+	times  []time.Time
+	lines  []string
+	signal chan bool
+}
+
+func newStatusLogger() *statusLogger {
+	return &statusLogger{signal: make(chan bool, 100)}
+}
+
+func (s *statusLogger) Info(kv ...interface{}) {
+	if len(kv) < 2 || kv[0] != "status" {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.times = append(s.times, time.Now())
+	s.lines = append(s.lines, fmt.Sprint(kv[1]))
+	select {
+	case s.signal <- true:
+	default:
+	}
+}
+func (s *statusLogger) Debug(kv ...interface{})       {}
+func (s *statusLogger) Warn(kv ...interface{})        {}
+func (s *statusLogger) Error(kv ...interface{})       {}
+func (s *statusLogger) With(kv ...interface{}) Logger { return s }
+
+func (s *statusLogger) logged() ([]time.Time, []string) {
+	s.Lock()
+	defer s.Unlock()
+	return append([]time.Time{}, s.times...), append([]string{}, s.lines...)
+}
+
+// waitLines waits for n more status lines
+func (s *statusLogger) waitLines(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.signal:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d/%d status lines logged", i, n)
+		}
+	}
+}
+
+// quiet returns true if no status line is logged during d
+func (s *statusLogger) quiet(d time.Duration) bool {
+	select {
+	case <-s.signal:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func TestHandelStatusLog(t *testing.T) {
+	period := 30 * time.Millisecond
+	for i, p := range []time.Duration{0, period} {
+		t.Logf(" -- test %d -- ", i)
+		_, handels := FakeSetup(16)
+		h := handels[0]
+		logger := newStatusLogger()
+		h.log = logger
+		h.SetStatusLogPeriod(p)
+		h.c.StarvationCheck = -1
+		h.Start()
+		if p == 0 {
+			require.True(t, logger.quiet(3*period))
+			h.Close()
+			continue
+		}
+		logger.waitLines(t, 3)
+		h.Close()
+		times, lines := logger.logged()
+		for j := 1; j < len(times); j++ {
+			require.True(t, times[j].Sub(times[j-1]) > period/2)
+		}
+		// followed by the outcomes of the updates of the levels
+		require.True(t, strings.HasPrefix(lines[0], "lvls[0/4 done] full=1/16 thr=9 indiv=0 mem=4B upd[1:"), lines[0])
+		// nothing once stopped
+		require.True(t, logger.quiet(2*period))
+		after, _ := logger.logged()
+		require.Equal(t, len(times), len(after))
+	}
+}
@@ -3,14 +3,22 @@ package handel
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 )
 
 // SignatureStore is a generic interface whose role is to store received valid
 // multisignature, and to be able to serve the best multisignature received so
 // far at a given level. Different strategies can be implemented such as keeping
-// only the best one, merging two non-colluding multi-signatures etc.
-// NOTE: implementation MUST be thread-safe.
+// only the best one, merging two non-colluding multi-signatures etc. Custom
+// implementations are given to Handel with Config.NewStore.
+// NOTE: implementation MUST be thread-safe. Store is only called by Handel's
+// routine reading the verified signatures, in the order they are verified,
+// but the other methods are called concurrently by the processing, the
+// network and the periodic routines.
+// The multi-signatures returned by a store are shared: they must not be
+// modified. The default store never modifies a multi-signature once stored,
+// and copies the bitsets of the signatures given to Store.
 type SignatureStore interface {
 	// A Store is as well an evaluator since it best knows which signatures are
 	// important.
@@ -18,7 +26,7 @@ type SignatureStore interface {
 	// Store saves or merges if needed the given signature. It returns the
 	// resulting multi-signature.  This signature must have been verified before
 	// calling this function.
-	Store(sp *incomingSig) *MultiSignature
+	Store(sp *IncomingSig) *MultiSignature
 	// GetBest returns the "best" multisignature at the requested level. Best
 	// should be interpreted as "containing the most individual contributions".
 	// Tt returns false if there is no signature associated to that level, true
@@ -56,10 +64,30 @@ type store struct {
 
 	// We keep all our verified individual signatures
 	individualSigs map[byte]map[int]*MultiSignature
+
+	// the verified signatures the best of each level was combined from, nil
+	// unless the proofs are retained
+	proofs map[byte][]*MultiSignature
+	// the approximate size of the proofs
+	proofBytes int
+	sigBytes   int
+
+	// number of signatures compared by the tie-break, see precedes
+	tieBreaks int
+	// number of changes of the best signatures, see StoreSnapshotter
+	seq uint64
+
+	log    Logger
+	errors errorCounter
 }
 
-// newStore is the constructor for the store.
-func newStore(part Partitioner, nbs func(int) BitSet, c Constructor) *store {
+// newStore is the constructor for the store. The errors are logged with the
+// logger if one is given, and discarded otherwise.
+func newStore(part Partitioner, nbs func(int) BitSet, c Constructor, logger ...Logger) *store {
+	var log Logger
+	if len(logger) > 0 {
+		log = logger[0]
+	}
 	indivSigsVerified := make(map[byte]BitSet)
 	individualSigs := make(map[byte]map[int]*MultiSignature)
 	indivSigsVerified[0] = nbs(1)
@@ -76,13 +104,31 @@ func newStore(part Partitioner, nbs func(int) BitSet, c Constructor) *store {
 		c:                 c,
 		indivSigsVerified: indivSigsVerified,
 		individualSigs:    individualSigs,
+		log:               componentLogger(log, "store"),
 	}
 }
 
-func (r *store) Store(sp *incomingSig) *MultiSignature {
+// setLogger sets the logger of the errors, see newStore
+func (r *store) setLogger(logger Logger) {
+	r.Lock()
+	defer r.Unlock()
+	r.log = componentLogger(logger, "store")
+}
+
+func (r *store) Store(sp *IncomingSig) *MultiSignature {
+	// the caller keeps the signature: the stored one must not change
+	own := *sp
+	own.ms = &MultiSignature{BitSet: sp.ms.BitSet.Clone(), Signature: sp.ms.Signature}
+	sp = &own
+
 	r.Lock()
 	defer r.Unlock()
 
+	if _, ok := r.indivSigsVerified[sp.level]; !ok {
+		r.log.Warn("invalid_level", sp.level, "origin", sp.origin)
+		r.errors.inc()
+		return nil
+	}
 	if sp.Individual() {
 		if sp.ms.BitSet.Cardinality() != 1 {
 			panic("bad individual sig")
@@ -93,45 +139,55 @@ func (r *store) Store(sp *incomingSig) *MultiSignature {
 
 	n, store := r.unsafeCheckMerge(sp)
 	if store {
+		if r.proofs != nil {
+			r.storeProof(sp, n)
+		}
 		r.store(sp.level, n)
 	}
 	return n
 }
 
-func (r *store) Evaluate(sp *incomingSig) int {
+func (r *store) Evaluate(sp *IncomingSig) int {
+	score, _ := r.evaluateReason(sp)
+	return score
+}
+
+// evaluateReason implements the explainingEvaluator interface
+func (r *store) evaluateReason(sp *IncomingSig) (int, rejection) {
 	r.Lock()
 	defer r.Unlock()
-	score := r.unsafeEvaluate(sp)
+	score, reason := r.unsafeEvaluate(sp)
 	if score < 0 {
 		panic("can't have a negative score!")
 	}
-	return score
+	return score, reason
 }
 
-func (r *store) unsafeEvaluate(sp *incomingSig) int {
+func (r *store) unsafeEvaluate(sp *IncomingSig) (int, rejection) {
 	toReceive := r.part.Size(int(sp.level))
 	// The best signature we have for this level, may be nil
 	curBestMs := r.m[sp.level]
 
 	if curBestMs != nil && toReceive == curBestMs.Cardinality() {
 		// Completed level, we won't need this signature
-		return 0
+		return 0, rejectCompleteLevel
 	}
 
 	if sp.Individual() && r.indivSigsVerified[sp.level].Get(int(sp.mappedIndex)) {
 		// We have already verified this individual signature
-		return 0
+		return 0, rejectVerifiedIndividual
 	}
 
 	if curBestMs != nil && !sp.Individual() && curBestMs.IsSuperSet(sp.ms.BitSet) {
 		// We have verified an equal or better signature already. Ignore this
 		// new one.
-		return 0
+		return 0, rejectSuperset
 	}
 
 	// We take into account the individual signatures already verified we could
-	// add.
-	withIndiv := sp.ms.BitSet.Or(r.indivSigsVerified[sp.level])
+	// add. The counts are computed without materializing the combined bitsets.
+	verified := r.indivSigsVerified[sp.level]
+	msCard := sp.ms.BitSet.Cardinality()
 	// The number of signatures in our new best
 	newTotal := 0
 	// The number of sigs we add with our new best compared to the existing one.
@@ -142,23 +198,25 @@ func (r *store) unsafeEvaluate(sp *incomingSig) int {
 	combineCt := 0
 	if curBestMs == nil {
 		// the best is the new multi-sig combined with the ind. sigs
-		newTotal = withIndiv.Cardinality()
+		newTotal = sp.ms.BitSet.OrCardinality(verified)
 		addedSigs = newTotal
-		combineCt = newTotal - sp.ms.BitSet.Cardinality()
+		combineCt = newTotal - msCard
 	} else {
+		bestCard := curBestMs.BitSet.Cardinality()
 		// We need to check that the new sig and curr sig don't overlap to merge
-		if sp.ms.IntersectionCardinality(curBestMs.BitSet) != 0 {
+		if sp.ms.BitSet.AnyIntersect(curBestMs.BitSet) {
 			// We can't merge, it's a replace
-			newTotal = withIndiv.Cardinality()
-			addedSigs = newTotal - curBestMs.Cardinality()
-			combineCt = newTotal - sp.ms.BitSet.Cardinality()
+			newTotal = sp.ms.BitSet.OrCardinality(verified)
+			addedSigs = newTotal - bestCard
+			combineCt = newTotal - msCard
 		} else {
 			// We can merge our current best and the new ms. We also add
-			// individual signatures that we previously verified
-			finalSet := withIndiv.Or(curBestMs.BitSet)
-			newTotal = finalSet.Cardinality()
-			addedSigs = newTotal - curBestMs.BitSet.Cardinality()
-			combineCt = finalSet.Xor(curBestMs.BitSet.Or(sp.ms.BitSet)).Cardinality()
+			// individual signatures that we previously verified. Since both
+			// are disjoint, the verified bits they cover are disjoint as well.
+			covered := verified.AndCardinality(sp.ms.BitSet) + verified.AndCardinality(curBestMs.BitSet)
+			newTotal = msCard + bestCard + verified.Cardinality() - covered
+			addedSigs = newTotal - bestCard
+			combineCt = newTotal - bestCard - msCard
 		}
 	}
 
@@ -166,26 +224,31 @@ func (r *store) unsafeEvaluate(sp *incomingSig) int {
 		// It doesn't add any value, we keep only the individual signatures for
 		//  byzantine fault tolerance scenario but we can remove the others.
 		if sp.Individual() {
-			return 1
+			return 1, ""
 		}
-		return 0
+		// A replacement of the same cardinality is only stored if it wins the
+		// tie-break, so it must be verified.
+		if addedSigs == 0 && precedes(sp.ms.BitSet.Or(verified), curBestMs.BitSet) {
+			return 1, ""
+		}
+		return 0, rejectNoValue
 	}
 
 	if newTotal == toReceive {
 		// This completes a level! That's the best options for us. We give a
 		// greater value to the first levels/
-		return 1000000 - int(sp.level)*10 - combineCt
+		return 1000000 - int(sp.level)*10 - combineCt, ""
 	}
 
 	// It adds value, but does not complete a level. It favorizes the older level
 	// but take into account the number of sigs we receive as well.
-	return 100000 - int(sp.level)*100 + addedSigs*10 - combineCt
+	return 100000 - int(sp.level)*100 + addedSigs*10 - combineCt, ""
 }
 
 // Returns the signature to store (can be combined with the existing one or
 // previously verified signatures) and a boolean: true if the signature should
 // replace the previous one, false if the signature should be discarded
-func (r *store) unsafeCheckMerge(sp *incomingSig) (*MultiSignature, bool) {
+func (r *store) unsafeCheckMerge(sp *IncomingSig) (*MultiSignature, bool) {
 	ms2 := r.m[sp.level] // The best signature we have for this level, may be nil
 	if ms2 == nil {
 		// If we don't have a best for this level it means we haven't verified
@@ -194,24 +257,35 @@ func (r *store) unsafeCheckMerge(sp *incomingSig) (*MultiSignature, bool) {
 		return sp.ms, true
 	}
 
-	best := &MultiSignature{Signature: sp.ms.Signature, BitSet: sp.ms.BitSet.Clone()}
-	merged := sp.ms.BitSet.Or(ms2.BitSet)
-	if merged.Cardinality() == ms2.Cardinality()+sp.ms.Cardinality() {
-		best.BitSet = merged
-		best.Signature = ms2.Signature.Combine(sp.ms.Signature)
-	}
-
 	vl := r.indivSigsVerified[sp.level]
-	iS := best.And(vl).Xor(vl)
-	// in iS, all bits set mean that we can complement our current best with the
-	// corresponding individual sig.
-	// There are some individual sigs that we could use.
+	// Decide first, without allocating: the new sig is merged with our current
+	// best if they don't overlap, and complemented with the individual sigs it
+	// does not cover yet.
+	mergeable := !sp.ms.BitSet.AnyIntersect(ms2.BitSet)
+	bestCard := sp.ms.Cardinality()
+	covered := vl.AndCardinality(sp.ms.BitSet)
+	if mergeable {
+		bestCard += ms2.Cardinality()
+		covered += vl.AndCardinality(ms2.BitSet)
+	}
 	// Let's check first that the final signature will be larger than the
-	// existing one
-	if iS.Cardinality()+best.Cardinality() <= ms2.Cardinality() {
+	// existing one, or as large: the tie-break decides then.
+	newCard := vl.Cardinality() - covered + bestCard
+	if newCard < ms2.Cardinality() {
 		return nil, false
 	}
 
+	best := &MultiSignature{Signature: sp.ms.Signature}
+	if mergeable {
+		best.BitSet = sp.ms.BitSet.Or(ms2.BitSet)
+		best.Signature = ms2.Signature.Combine(sp.ms.Signature)
+	} else {
+		best.BitSet = sp.ms.BitSet.Clone()
+	}
+	// in iS, all bits set mean that we can complement our current best with the
+	// corresponding individual sig.
+	iS := best.And(vl).Xor(vl)
+
 	// Now we can build all this
 	for pos, cont := iS.NextSet(0); cont; pos, cont = iS.NextSet(pos + 1) {
 		sig, check := r.individualSigs[sp.level][pos]
@@ -225,9 +299,37 @@ func (r *store) unsafeCheckMerge(sp *incomingSig) (*MultiSignature, bool) {
 		best.Signature = sig.Combine(best.Signature)
 	}
 
+	if newCard == ms2.Cardinality() {
+		r.tieBreaks++
+		if !precedes(best.BitSet, ms2.BitSet) {
+			return nil, false
+		}
+	}
 	return best, true
 }
 
+// precedes is the tie-break between two bitsets of the same cardinality and
+// length: it returns true if a is preferred to b, i.e. if the lowest index set
+// in only one of them is set in a - a is the smallest in the lexicographic
+// order of their indexes. Of two signatures of the same cardinality, the store
+// keeps the one whose bitset is preferred, so the nodes storing the same
+// signatures in different orders keep the same ones.
+func precedes(a, b BitSet) bool {
+	i, ok := a.Xor(b).NextSet(0)
+	return ok && a.Get(i)
+}
+
+// recycle releases the bitsets owned by the store to the pool. The store must
+// not be used anymore.
+func (r *store) recycle(p *BitSetPool) {
+	r.Lock()
+	defer r.Unlock()
+	for lvl, bs := range r.indivSigsVerified {
+		p.Put(bs)
+		delete(r.indivSigsVerified, lvl)
+	}
+}
+
 func (r *store) Best(level byte) (*MultiSignature, bool) {
 	r.Lock()
 	defer r.Unlock()
@@ -238,9 +340,9 @@ func (r *store) Best(level byte) (*MultiSignature, bool) {
 func (r *store) FullSignature() *MultiSignature {
 	r.Lock()
 	defer r.Unlock()
-	sigs := make([]*incomingSig, 0, len(r.m))
+	sigs := make([]*IncomingSig, 0, len(r.m))
 	for k, ms := range r.m {
-		sigs = append(sigs, &incomingSig{level: k, ms: ms})
+		sigs = append(sigs, &IncomingSig{level: k, ms: ms})
 	}
 	return r.part.CombineFull(sigs, r.nbs)
 }
@@ -248,35 +350,158 @@ func (r *store) FullSignature() *MultiSignature {
 func (r *store) Combined(level byte) *MultiSignature {
 	r.Lock()
 	defer r.Unlock()
-	sigs := make([]*incomingSig, 0, len(r.m))
+	sigs := make([]*IncomingSig, 0, len(r.m))
 	for k, ms := range r.m {
 		if k > level {
 			continue
 		}
-		sigs = append(sigs, &incomingSig{level: k, ms: ms})
+		sigs = append(sigs, &IncomingSig{level: k, ms: ms})
 	}
 	if level < byte(r.part.MaxLevel()) {
 		level++
 	}
-	return r.part.Combine(sigs, int(level), r.nbs)
+	ms := r.part.Combine(sigs, int(level), r.nbs)
+	if ms == nil && len(sigs) > 0 {
+		r.log.Warn("combined", "level", level, "sigs", len(sigs))
+		r.errors.inc()
+	}
+	return ms
 }
 
 func (r *store) store(level byte, ms *MultiSignature) {
 	r.m[level] = ms
+	r.seq++
 	if level > r.highest {
 		r.highest = level
 	}
 }
 
-func (r *store) String() string {
-	full := r.FullSignature()
+// SigKind tells whether a signature iterated by ForEach is the best
+// multi-signature of its level or an individual signature.
+type SigKind int
+
+const (
+	// SigBest is the best multi-signature of a level
+	SigBest SigKind = iota
+	// SigIndividual is an individual signature verified at a level
+	SigIndividual
+)
+
+// SignatureIterator is implemented by the stores whose content can be read
+// without holding their lock for long, e.g. to checkpoint it.
+type SignatureIterator interface {
+	// ForEach calls fn on a consistent snapshot of the stored signatures, by
+	// increasing level: first the best multi-signature of the level, with a
+	// position of -1, then the individual signatures by increasing position
+	// in the level. It stops if fn returns false. Stores may keep storing
+	// signatures meanwhile, and the signatures must not be modified.
+	ForEach(fn func(level byte, kind SigKind, position int, ms *MultiSignature) bool)
+}
+
+// ForEach implements the SignatureIterator interface. Only the maps are
+// copied under the lock: the signatures are shared, since the store never
+// modifies them.
+func (r *store) ForEach(fn func(level byte, kind SigKind, position int, ms *MultiSignature) bool) {
+	r.Lock()
+	best := make(map[byte]*MultiSignature, len(r.m))
+	for lvl, ms := range r.m {
+		best[lvl] = ms
+	}
+	individuals := make(map[byte]map[int]*MultiSignature, len(r.individualSigs))
+	for lvl, sigs := range r.individualSigs {
+		if len(sigs) == 0 {
+			continue
+		}
+		cp := make(map[int]*MultiSignature, len(sigs))
+		for pos, ms := range sigs {
+			cp[pos] = ms
+		}
+		individuals[lvl] = cp
+	}
+	r.Unlock()
+
+	levels := make([]int, 0, len(individuals)+1)
+	for lvl := range individuals {
+		levels = append(levels, int(lvl))
+	}
+	for lvl := range best {
+		if _, ok := individuals[lvl]; !ok {
+			levels = append(levels, int(lvl))
+		}
+	}
+	sort.Ints(levels)
+	for _, l := range levels {
+		lvl := byte(l)
+		if ms, ok := best[lvl]; ok && !fn(lvl, SigBest, -1, ms) {
+			return
+		}
+		positions := make([]int, 0, len(individuals[lvl]))
+		for pos := range individuals[lvl] {
+			positions = append(positions, pos)
+		}
+		sort.Ints(positions)
+		for _, pos := range positions {
+			if !fn(lvl, SigIndividual, pos, individuals[lvl][pos]) {
+				return
+			}
+		}
+	}
+}
+
+// ForEachSignature calls fn on a consistent snapshot of the signatures stored
+// by Handel, see SignatureIterator. It returns false if the store does not
+// implement SignatureIterator.
+func (h *Handel) ForEachSignature(fn func(level byte, kind SigKind, position int, ms *MultiSignature) bool) bool {
+	it, ok := unwrapStore(h.store).(SignatureIterator)
+	if !ok {
+		return false
+	}
+	it.ForEach(fn)
+	return true
+}
+
+// unwrapStore returns the store wrapped by a ReportStore, or the store itself
+func unwrapStore(s SignatureStore) SignatureStore {
+	if r, ok := s.(*ReportStore); ok {
+		return r.SignatureStore
+	}
+	return s
+}
+
+// BestSnapshot implements the StoreSnapshotter interface. Only the map is
+// copied under the lock: the signatures are shared, since the store never
+// modifies them.
+func (r *store) BestSnapshot() (map[byte]*MultiSignature, uint64) {
+	r.Lock()
+	defer r.Unlock()
+	best := make(map[byte]*MultiSignature, len(r.m))
+	for lvl, ms := range r.m {
+		best[lvl] = ms
+	}
+	return best, r.seq
+}
+
+// Seq implements the StoreSnapshotter interface
+func (r *store) Seq() uint64 {
 	r.Lock()
 	defer r.Unlock()
+	return r.seq
+}
+
+// String returns the table of the levels: the cardinality of the best
+// signature out of the size of the level, the number of individual
+// signatures held and whether the level is complete.
+func (r *store) String() string {
+	st := r.status()
 	var b bytes.Buffer
 	b.WriteString("replaceStore table:\n")
-	for lvl, ms := range r.m {
-		b.WriteString(fmt.Sprintf("\tlevel %d : %s\n", lvl, ms))
+	for _, l := range st.levels {
+		fmt.Fprintf(&b, "\tlevel %d : %d/%d indiv=%d complete=%v\n", l.level, l.cardinality(), l.size, l.individuals, l.complete())
+	}
+	var full, size int
+	if st.full != nil {
+		full, size = st.full.Cardinality(), st.full.BitLength()
 	}
-	b.WriteString(fmt.Sprintf("\t --> full sig: %d/%d", full.Cardinality(), full.BitLength()))
+	fmt.Fprintf(&b, "\t --> full sig: %d/%d mem=%s", full, size, formatBytes(st.mem))
 	return b.String()
 }
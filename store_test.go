@@ -1,8 +1,10 @@
 package handel
 
 import (
+	"math/rand"
 	"testing"
 
+	"github.com/ConsenSys/handel/bits"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,7 +14,7 @@ func TestStoreCombined(t *testing.T) {
 
 	type combineTest struct {
 		id    int32
-		sigs  []*incomingSig
+		sigs  []*IncomingSig
 		level int
 		exp   *MultiSignature
 	}
@@ -46,6 +48,26 @@ func TestStoreCombined(t *testing.T) {
 	}
 }
 
+func TestStoreErrors(t *testing.T) {
+	logger := newCaptureLogger()
+	part := NewBinPartitioner(1, FakeRegistry(16), logger.With("id", 1))
+	store := newStore(part, NewWilffBitset, new(fakeCons), logger.With("id", 1))
+
+	// a level out of range
+	require.Nil(t, store.Store(&IncomingSig{origin: 3, level: 9, ms: fullSig(2)}))
+	require.NotNil(t, store.Store(fullIncomingSig(1)))
+	// the partitioner can't combine up to the level
+	require.Nil(t, store.Combined(200))
+
+	warns := logger.warnings()
+	require.Len(t, warns, 3)
+	require.Equal(t, []interface{}{"id", 1, "component", "store", "invalid_level", byte(9), "origin", int32(3)}, warns[0])
+	require.Equal(t, []interface{}{"id", 1, "component", "partitioner", "combine"}, warns[1][:5])
+	require.Equal(t, []interface{}{"id", 1, "component", "store", "combined", "level", byte(200), "sigs", 1}, warns[2])
+	require.Equal(t, map[string]float64{"errors": 2, "tieBreaks": 0}, store.Values())
+	require.Equal(t, map[string]float64{"errors": 1}, part.(*binomialPartitioner).Values())
+}
+
 func TestStoreFullSignature(t *testing.T) {
 	n := 8
 	reg := FakeRegistry(n)
@@ -53,7 +75,7 @@ func TestStoreFullSignature(t *testing.T) {
 	store := newStore(part, NewWilffBitset, new(fakeCons))
 	bs1 := NewWilffBitset(1)
 	bs1.Set(0, true)
-	ind := &incomingSig{
+	ind := &IncomingSig{
 		origin:      0,
 		level:       0,
 		ms:          &MultiSignature{BitSet: bs1, Signature: &fakeSig{true}},
@@ -75,7 +97,7 @@ func TestStoreUnsafeCheckMerge(t *testing.T) {
 	// We put a first sig. It should get in.
 	bs1 := NewWilffBitset(4)
 	bs1.Set(0, true)
-	p4L3 := &incomingSig{
+	p4L3 := &IncomingSig{
 		origin:      1,
 		level:       3,
 		ms:          &MultiSignature{BitSet: bs1, Signature: &fakeSig{true}},
@@ -97,7 +119,7 @@ func TestStoreUnsafeCheckMerge(t *testing.T) {
 	bs1 = NewWilffBitset(4)
 	bs1.Set(0, true)
 	bs1.Set(2, true)
-	p46L3 := &incomingSig{
+	p46L3 := &IncomingSig{
 		origin:      1,
 		level:       3,
 		ms:          &MultiSignature{BitSet: bs1, Signature: &fakeSig{true}},
@@ -118,7 +140,7 @@ func TestStoreUnsafeCheckMerge(t *testing.T) {
 	bs1 = NewWilffBitset(4)
 	bs1.Set(3, true)
 	bs1.Set(2, true)
-	p67L3 := &incomingSig{
+	p67L3 := &IncomingSig{
 		origin:      1,
 		level:       3,
 		ms:          &MultiSignature{BitSet: bs1, Signature: &fakeSig{true}},
@@ -137,38 +159,38 @@ func TestStoreReplace(t *testing.T) {
 	n := 8
 	reg := FakeRegistry(n)
 	part := NewBinPartitioner(1, reg, DefaultLogger)
-	sig0 := &incomingSig{level: 0, ms: fullSig(0)}
-	sig1 := &incomingSig{level: 1, ms: fullSig(1)}
-	sig2 := &incomingSig{level: 2, ms: fullSig(2)}
-	sig3 := &incomingSig{level: 3, ms: fullSig(3)}
+	sig0 := &IncomingSig{level: 0, ms: fullSig(0)}
+	sig1 := &IncomingSig{level: 1, ms: fullSig(1)}
+	sig2 := &IncomingSig{level: 2, ms: fullSig(2)}
+	sig3 := &IncomingSig{level: 3, ms: fullSig(3)}
 
 	fullBs3 := NewWilffBitset(n / 2)
 	for i := 0; i < fullBs3.BitLength(); i++ {
 		fullBs3.Set(i, true)
 	}
-	fullSig3 := &incomingSig{level: 3, ms: newSig(fullBs3)}
-	fullBs2 := NewWilffBitset(pow2(3 - 1))
+	fullSig3 := &IncomingSig{level: 3, ms: newSig(fullBs3)}
+	fullBs2 := NewWilffBitset(bits.Pow2(3 - 1))
 	// only signature 2 present so no 0, 1
 	for i := 2; i < fullBs2.BitLength(); i++ {
 		fullBs2.Set(i, true)
 	}
-	fullSig2 := &incomingSig{level: 3, ms: newSig(fullBs2)}
+	fullSig2 := &IncomingSig{level: 3, ms: newSig(fullBs2)}
 
 	var sc = func(ms ...int) []int {
 		return ms
 	}
 
 	type storeTest struct {
-		toStore []*incomingSig
+		toStore []*IncomingSig
 		scores  []int
 		ret     []bool
 		best    byte
 		eqMs    *MultiSignature
 		eqBool  bool
-		highest *incomingSig // can be nil
+		highest *IncomingSig // can be nil
 	}
 
-	var s = func(sps ...*incomingSig) []*incomingSig { return sps }
+	var s = func(sps ...*IncomingSig) []*IncomingSig { return sps }
 	var b = func(rets ...bool) []bool { return rets }
 	var tests = []storeTest{
 		// empty
@@ -195,3 +217,373 @@ func TestStoreReplace(t *testing.T) {
 		//require.Equal(t, test.highest, store.Highest())
 	}
 }
+
+// refEvaluate is the evaluation of the store computed on materialized
+// bitsets, as it was before the cardinality queries. It is the reference of
+// the differential tests.
+func refEvaluate(r *store, sp *IncomingSig) int {
+	toReceive := r.part.Size(int(sp.level))
+	curBestMs := r.m[sp.level]
+	if curBestMs != nil && toReceive == curBestMs.Cardinality() {
+		return 0
+	}
+	if sp.Individual() && r.indivSigsVerified[sp.level].Get(int(sp.mappedIndex)) {
+		return 0
+	}
+	if curBestMs != nil && !sp.Individual() && curBestMs.IsSuperSet(sp.ms.BitSet) {
+		return 0
+	}
+	withIndiv := sp.ms.BitSet.Or(r.indivSigsVerified[sp.level])
+	newTotal, addedSigs, combineCt := 0, 0, 0
+	if curBestMs == nil {
+		newTotal = withIndiv.Cardinality()
+		addedSigs = newTotal
+		combineCt = newTotal - sp.ms.BitSet.Cardinality()
+	} else if sp.ms.IntersectionCardinality(curBestMs.BitSet) != 0 {
+		newTotal = withIndiv.Cardinality()
+		addedSigs = newTotal - curBestMs.Cardinality()
+		combineCt = newTotal - sp.ms.BitSet.Cardinality()
+	} else {
+		finalSet := withIndiv.Or(curBestMs.BitSet)
+		newTotal = finalSet.Cardinality()
+		addedSigs = newTotal - curBestMs.BitSet.Cardinality()
+		combineCt = finalSet.Xor(curBestMs.BitSet.Or(sp.ms.BitSet)).Cardinality()
+	}
+	if addedSigs <= 0 {
+		if sp.Individual() || (addedSigs == 0 && refPrecedes(withIndiv, curBestMs.BitSet)) {
+			return 1
+		}
+		return 0
+	}
+	if newTotal == toReceive {
+		return 1000000 - int(sp.level)*10 - combineCt
+	}
+	return 100000 - int(sp.level)*100 + addedSigs*10 - combineCt
+}
+
+// refCheckMerge returns the bitset unsafeCheckMerge returned before the
+// cardinality queries, nil if the signature was discarded.
+func refCheckMerge(r *store, sp *IncomingSig) BitSet {
+	ms2 := r.m[sp.level]
+	if ms2 == nil {
+		return sp.ms.BitSet
+	}
+	best := sp.ms.BitSet.Clone()
+	merged := sp.ms.BitSet.Or(ms2.BitSet)
+	if merged.Cardinality() == ms2.Cardinality()+sp.ms.Cardinality() {
+		best = merged
+	}
+	vl := r.indivSigsVerified[sp.level]
+	iS := best.And(vl).Xor(vl)
+	res := best.Or(iS)
+	if res.Cardinality() < ms2.Cardinality() ||
+		(res.Cardinality() == ms2.Cardinality() && !refPrecedes(res, ms2.BitSet)) {
+		return nil
+	}
+	return res
+}
+
+// refPrecedes is the tie-break of the store, bit by bit
+func refPrecedes(a, b BitSet) bool {
+	for i := 0; i < a.BitLength(); i++ {
+		if a.Get(i) != b.Get(i) {
+			return a.Get(i)
+		}
+	}
+	return false
+}
+
+// randomIncomingSigs returns a stream of random signatures for the levels of
+// the partitioner, a third of them being individual signatures.
+func randomIncomingSigs(r *rand.Rand, part Partitioner, nbLevels, count int) []*IncomingSig {
+	sps := make([]*IncomingSig, count)
+	for i := range sps {
+		level := 1 + r.Intn(nbLevels)
+		size := part.Size(level)
+		bs := NewWilffBitset(size)
+		sp := &IncomingSig{level: byte(level), ms: newSig(bs)}
+		if r.Intn(3) == 0 {
+			sp.isInd = true
+			sp.mappedIndex = r.Intn(size)
+			bs.Set(sp.mappedIndex, true)
+		} else {
+			bs.Set(r.Intn(size), true)
+			for j := 0; j < size; j++ {
+				if r.Intn(2) == 0 {
+					bs.Set(j, true)
+				}
+			}
+		}
+		sps[i] = sp
+	}
+	return sps
+}
+
+func TestStoreEvaluateDifferential(t *testing.T) {
+	n := 64
+	reg := FakeRegistry(n)
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 50; i++ {
+		t.Logf(" -- test %d -- ", i)
+		part := NewBinPartitioner(int32(r.Intn(n)), reg, DefaultLogger)
+		store := newStore(part, NewWilffBitset, new(fakeCons))
+		for _, sp := range randomIncomingSigs(r, part, 6, 100) {
+			score, _ := store.unsafeEvaluate(sp)
+			require.Equal(t, refEvaluate(store, sp), score)
+			exp := refCheckMerge(store, sp)
+			ms, ok := store.unsafeCheckMerge(sp)
+			require.Equal(t, exp != nil, ok)
+			if ok {
+				require.Equal(t, exp.String(), ms.BitSet.String())
+			}
+			store.Store(sp)
+		}
+	}
+}
+
+// tieSigs returns signatures for the levels of the partitioner which all
+// intersect within their level: several of the same cardinality, which only
+// the tie-break decides between, and smaller ones.
+func tieSigs(r *rand.Rand, part Partitioner) []*IncomingSig {
+	var sps []*IncomingSig
+	for _, level := range part.Levels() {
+		size := part.Size(level)
+		sps = append(sps, individualSig(level, size, 0))
+		for i := 0; i < 8 && size >= 4; i++ {
+			card := size / 2
+			if i >= 5 {
+				card = 1 + r.Intn(size/2)
+			}
+			bs := NewWilffBitset(size)
+			bs.Set(0, true)
+			for bs.Cardinality() < card {
+				bs.Set(r.Intn(size), true)
+			}
+			sps = append(sps, &IncomingSig{level: byte(level), ms: newSig(bs)})
+		}
+	}
+	return sps
+}
+
+func TestStoreTieBreak(t *testing.T) {
+	n := 64
+	part := NewBinPartitioner(3, FakeRegistry(n), DefaultLogger)
+	r := rand.New(rand.NewSource(42))
+	sps := tieSigs(r, part)
+	marshal := func(ms *MultiSignature) string {
+		buff, err := ms.MarshalBinary()
+		require.NoError(t, err)
+		return string(buff)
+	}
+
+	var expBest map[int]string
+	var expFull string
+	var tieBreaks float64
+	for i := 0; i < 100; i++ {
+		store := newStore(part, NewWilffBitset, new(fakeCons))
+		for _, j := range r.Perm(len(sps)) {
+			// only the signatures evaluated are verified, then stored
+			if store.Evaluate(sps[j]) > 0 {
+				store.Store(sps[j])
+			}
+		}
+		best := make(map[int]string)
+		for _, level := range part.Levels() {
+			ms, ok := store.Best(byte(level))
+			require.True(t, ok)
+			best[level] = marshal(ms)
+		}
+		full := marshal(store.FullSignature())
+		if i == 0 {
+			expBest, expFull = best, full
+		}
+		require.Equal(t, expBest, best, "order %d", i)
+		require.Equal(t, expFull, full, "order %d", i)
+		tieBreaks += store.Values()["tieBreaks"]
+	}
+	require.True(t, tieBreaks > 0)
+
+	// the best is the smallest of the largest signatures
+	for _, level := range part.Levels() {
+		var exp BitSet
+		for _, sp := range sps {
+			if int(sp.level) != level || sp.ms.Cardinality() < part.Size(level)/2 || sp.Individual() {
+				continue
+			}
+			if exp == nil || refPrecedes(sp.ms.BitSet, exp) {
+				exp = sp.ms.BitSet
+			}
+		}
+		if exp != nil {
+			require.Equal(t, marshal(newSig(exp)), expBest[level], "level %d", level)
+		}
+	}
+}
+
+func TestStorePrecedes(t *testing.T) {
+	bs := func(size int, set ...int) BitSet {
+		b := NewWilffBitset(size)
+		for _, i := range set {
+			b.Set(i, true)
+		}
+		return b
+	}
+	require.True(t, precedes(bs(8, 0, 5), bs(8, 1, 2)))
+	require.False(t, precedes(bs(8, 1, 2), bs(8, 0, 5)))
+	require.True(t, precedes(bs(8, 2, 3), bs(8, 2, 7)))
+	require.False(t, precedes(bs(8, 2, 3), bs(8, 2, 3)))
+}
+
+func BenchmarkStoreEvaluate(b *testing.B) {
+	n := 1024
+	reg := FakeRegistry(n)
+	part := NewBinPartitioner(1, reg, DefaultLogger)
+	store := newStore(part, NewWilffBitset, new(fakeCons))
+	r := rand.New(rand.NewSource(42))
+	// fill the store, then evaluate a deep queue of pending signatures
+	for _, sp := range randomIncomingSigs(r, part, 10, 200) {
+		store.Store(sp)
+	}
+	pending := randomIncomingSigs(r, part, 10, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Evaluate(pending[i%len(pending)])
+	}
+}
+
+// individualSig returns the individual signature of the given position at
+// the level, of the given size
+func individualSig(level, size, pos int) *IncomingSig {
+	bs := NewWilffBitset(size)
+	bs.Set(pos, true)
+	return &IncomingSig{level: byte(level), ms: newSig(bs), isInd: true, mappedIndex: pos}
+}
+
+func TestStoreForEach(t *testing.T) {
+	n := 16
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	s := newStore(part, NewWilffBitset, new(fakeCons))
+	s.Store(individualSig(3, 4, 2))
+	s.Store(individualSig(3, 4, 0))
+	s.Store(fullIncomingSig(1))
+
+	type item struct {
+		level byte
+		kind  SigKind
+		pos   int
+		card  int
+	}
+	var items []item
+	s.ForEach(func(level byte, kind SigKind, pos int, ms *MultiSignature) bool {
+		items = append(items, item{level, kind, pos, ms.Cardinality()})
+		return true
+	})
+	require.Equal(t, []item{
+		{1, SigBest, -1, 1},
+		{3, SigBest, -1, 2},
+		{3, SigIndividual, 0, 1},
+		{3, SigIndividual, 2, 1},
+	}, items)
+
+	// returning false stops the iteration
+	var count int
+	s.ForEach(func(byte, SigKind, int, *MultiSignature) bool {
+		count++
+		return count < 2
+	})
+	require.Equal(t, 2, count)
+
+	// the stored signatures don't change with the ones given to Store
+	sig := individualSig(4, 8, 5)
+	s.Store(sig)
+	sig.ms.BitSet.Set(6, true)
+	best, _ := s.Best(4)
+	require.Equal(t, 1, best.Cardinality())
+}
+
+// TestStoreForEachConcurrent checks that each iteration, concurrent with the
+// stores, sees a state the store went through: the individual signatures form
+// a prefix of the sequence stored, and the best signature of each level
+// merges the individual signatures of the level.
+func TestStoreForEachConcurrent(t *testing.T) {
+	n := 64
+	part := NewBinPartitioner(1, FakeRegistry(n), DefaultLogger)
+	s := newStore(part, NewWilffBitset, new(fakeCons))
+	type key struct {
+		level byte
+		pos   int
+	}
+	var sequence []key
+	for _, lvl := range []int{6, 5, 4} {
+		size := part.Size(lvl)
+		for _, pos := range rand.New(rand.NewSource(int64(lvl))).Perm(size) {
+			sequence = append(sequence, key{byte(lvl), pos})
+		}
+	}
+	order := make(map[key]int)
+	for i, k := range sequence {
+		order[k] = i
+	}
+
+	done := make(chan bool)
+	go func() {
+		for _, k := range sequence {
+			s.Store(individualSig(int(k.level), part.Size(int(k.level)), k.pos))
+		}
+		close(done)
+	}()
+
+	var iterations int
+	var last int
+	for stop := false; !stop; {
+		select {
+		case <-done:
+			stop = true
+		default:
+		}
+		seen := make(map[key]bool)
+		best := make(map[byte]int)
+		s.ForEach(func(level byte, kind SigKind, pos int, ms *MultiSignature) bool {
+			if kind == SigBest {
+				best[level] = ms.Cardinality()
+				return true
+			}
+			seen[key{level, pos}] = true
+			return true
+		})
+		iterations++
+		// a prefix of the sequence, at least as long as the previous one
+		for k := range seen {
+			require.True(t, order[k] < len(seen), "%v out of the prefix of %d", k, len(seen))
+		}
+		require.True(t, len(seen) >= last)
+		last = len(seen)
+		perLevel := make(map[byte]int)
+		for k := range seen {
+			perLevel[k.level]++
+		}
+		require.Equal(t, len(perLevel), len(best))
+		for lvl, ct := range perLevel {
+			require.Equal(t, ct, best[lvl], "level %d", lvl)
+		}
+	}
+	require.Equal(t, len(sequence), last)
+	t.Logf("%d iterations", iterations)
+}
+
+func TestHandelForEachSignature(t *testing.T) {
+	_, handels := FakeSetup(4)
+	defer CloseHandels(handels)
+	h := NewReportHandel(handels[0])
+	var levels []byte
+	require.True(t, h.ForEachSignature(func(level byte, kind SigKind, pos int, ms *MultiSignature) bool {
+		levels = append(levels, level)
+		return true
+	}))
+	// our own signature, as best and individual signature of level 0
+	require.Equal(t, []byte{0, 0}, levels)
+
+	h.store = &countingStore{SignatureStore: h.store}
+	require.False(t, h.ForEachSignature(func(byte, SigKind, int, *MultiSignature) bool { return true }))
+}
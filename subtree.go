@@ -0,0 +1,225 @@
+package handel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ConsenSys/handel/bits"
+)
+
+// SubtreeRange returns the range [min,max[ of the registry of the given size
+// aggregated by the node of the given id up to the given maximum aggregation
+// level: the ids sharing its bits above the maxLevel lowest ones, as the
+// levels of the binomial partitioner up to maxLevel. A maxLevel of zero, or
+// covering all the levels, gives the whole registry. See
+// Config.MaxAggregationLevel.
+func SubtreeRange(id int32, maxLevel, size int) (min, max int) {
+	if maxLevel <= 0 || maxLevel >= bits.Log2Ceil(size) {
+		return 0, size
+	}
+	min = int(id) &^ (bits.Pow2(maxLevel) - 1)
+	max = min + bits.Pow2(maxLevel)
+	if max > size {
+		max = size
+	}
+	return min, max
+}
+
+// maxSubtreeSize returns the size of the largest subtree of the registry of the
+// given size up to the maximum aggregation level
+func maxSubtreeSize(maxLevel, size int) int {
+	min, max := SubtreeRange(0, maxLevel, size)
+	return max - min
+}
+
+// VerifySubtreeMultiSignature verifies a multi-signature aggregated by the
+// node of the given id up to the given maximum aggregation level: its bitset
+// indexes the identities of the subtree of the node, see SubtreeRange. With a
+// maxLevel of zero, it verifies the multi-signature as VerifyMultiSignature.
+func VerifySubtreeMultiSignature(msg []byte, ms *MultiSignature, reg Registry, cons Constructor, id int32, maxLevel int) error {
+	min, max := SubtreeRange(id, maxLevel, reg.Size())
+	if ms.BitSet.BitLength() != max-min {
+		return errors.New("verify multisignature: inconsistent sizes")
+	}
+	ids, ok := reg.Identities(min, max)
+	if !ok {
+		return fmt.Errorf("registry returned no identities in [%d,%d[", min, max)
+	}
+	aggregate := cons.PublicKey()
+	for i, ok := ms.BitSet.NextSet(0); ok; i, ok = ms.BitSet.NextSet(i + 1) {
+		aggregate = aggregate.Combine(ids[i].PublicKey())
+	}
+	return aggregate.VerifySignature(msg, ms.Signature)
+}
+
+// subtree returns the range [min,max[ of the registry aggregated, the whole
+// registry without Config.MaxAggregationLevel
+func (h *Handel) subtree() (int, int) {
+	if h.c.MaxAggregationLevel <= 0 {
+		return 0, h.reg.Size()
+	}
+	return SubtreeRange(h.id.ID(), h.c.MaxAggregationLevel, h.reg.Size())
+}
+
+// subtreeSize returns the number of identities aggregated
+func (h *Handel) subtreeSize() int {
+	min, max := h.subtree()
+	return max - min
+}
+
+// errOutsideSubtree is the error of the packets whose origin is outside of the
+// subtree of the node, see Config.MaxAggregationLevel
+var errOutsideSubtree = errors.New("packet's origin outside of the subtree")
+
+// outsideSubtreeLog are the preallocated arguments of the log of
+// errOutsideSubtree
+var outsideSubtreeLog = []interface{}{"invalid_packet", errOutsideSubtree}
+
+// cappedPartitioner restricts a partitioner to its levels up to the maximum
+// aggregation level: the identities of the levels above are not part of any
+// level, and the full signature spans the subtree of the levels below.
+type cappedPartitioner struct {
+	Partitioner
+	maxLevel int
+	// range of the subtree in the registry
+	min, max int
+}
+
+// cappedOrderer is a cappedPartitioner of a partitioner deciding the contact
+// order of its levels
+type cappedOrderer struct {
+	*cappedPartitioner
+	orderer ContactOrderer
+}
+
+// capPartitioner returns the partitioner restricted to the levels up to the
+// maximum aggregation level of the config, or the partitioner itself without
+// one. It panics if the levels of the partitioner are not the ones of the
+// subtree.
+func capPartitioner(c *Config, part Partitioner, id int32, size int) Partitioner {
+	if c.MaxAggregationLevel <= 0 || c.MaxAggregationLevel >= part.MaxLevel() {
+		return part
+	}
+	min, max := SubtreeRange(id, c.MaxAggregationLevel, size)
+	capped := &cappedPartitioner{Partitioner: part, maxLevel: c.MaxAggregationLevel, min: min, max: max}
+	for _, level := range capped.Levels() {
+		ids, err := part.IdentitiesAt(level)
+		if err != nil {
+			panic(err)
+		}
+		for _, i := range ids {
+			if int(i.ID()) < min || int(i.ID()) >= max {
+				panic(fmt.Sprintf("handel: identity %d of level %d outside of the subtree [%d,%d[", i.ID(), level, min, max))
+			}
+		}
+	}
+	if orderer, ok := part.(ContactOrderer); ok {
+		return &cappedOrderer{cappedPartitioner: capped, orderer: orderer}
+	}
+	return capped
+}
+
+// MaxLevel implements the Partitioner interface
+func (c *cappedPartitioner) MaxLevel() int {
+	return c.maxLevel
+}
+
+// Levels implements the Partitioner interface, without the levels above the
+// maximum
+func (c *cappedPartitioner) Levels() []int {
+	var levels []int
+	for _, level := range c.Partitioner.Levels() {
+		if level <= c.maxLevel {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// Size implements the Partitioner interface, zero above the maximum
+func (c *cappedPartitioner) Size(level int) int {
+	if level > c.maxLevel {
+		return 0
+	}
+	return c.Partitioner.Size(level)
+}
+
+func (c *cappedPartitioner) checkLevel(level int) error {
+	if level > c.maxLevel {
+		return fmt.Errorf("handel: level %d above the maximum aggregation level %d", level, c.maxLevel)
+	}
+	return nil
+}
+
+// IdentitiesAt implements the Partitioner interface
+func (c *cappedPartitioner) IdentitiesAt(level int) ([]Identity, error) {
+	if err := c.checkLevel(level); err != nil {
+		return nil, err
+	}
+	return c.Partitioner.IdentitiesAt(level)
+}
+
+// IndexAtLevel implements the Partitioner interface
+func (c *cappedPartitioner) IndexAtLevel(globalID int32, level int) (int, error) {
+	if err := c.checkLevel(level); err != nil {
+		return 0, err
+	}
+	return c.Partitioner.IndexAtLevel(globalID, level)
+}
+
+// LevelOf implements the Partitioner interface: the indexes outside of the
+// subtree have no level
+func (c *cappedPartitioner) LevelOf(globalIndex int) (int, int, error) {
+	level, pos, err := c.Partitioner.LevelOf(globalIndex)
+	if err == nil {
+		err = c.checkLevel(level)
+	}
+	return level, pos, err
+}
+
+// GlobalOf implements the Partitioner interface
+func (c *cappedPartitioner) GlobalOf(level, pos int) (int, error) {
+	if err := c.checkLevel(level); err != nil {
+		return 0, err
+	}
+	return c.Partitioner.GlobalOf(level, pos)
+}
+
+// CombineFull implements the Partitioner interface: the bitset of the
+// multi-signature returned indexes the identities of the subtree
+func (c *cappedPartitioner) CombineFull(sigs []*IncomingSig, nbs func(int) BitSet) *MultiSignature {
+	if len(sigs) == 0 {
+		return nil
+	}
+	final := nbs(c.max - c.min)
+	var sig Signature
+	for _, s := range sigs {
+		bs := s.ms.BitSet
+		for i, ok := bs.NextSet(0); ok; i, ok = bs.NextSet(i + 1) {
+			global, err := c.GlobalOf(int(s.level), i)
+			if err != nil {
+				continue
+			}
+			final.Set(global-c.min, true)
+		}
+		if sig == nil {
+			sig = s.ms.Signature
+		} else {
+			sig = sig.Combine(s.ms.Signature)
+		}
+	}
+	return &MultiSignature{BitSet: final, Signature: sig}
+}
+
+// Values implements the Reporter interface with the values of the partitioner
+func (c *cappedPartitioner) Values() map[string]float64 {
+	return asReporter(c.Partitioner).Values()
+}
+
+// ContactOrder implements the ContactOrderer interface
+func (c *cappedOrderer) ContactOrder(level int) ([]Identity, error) {
+	if err := c.checkLevel(level); err != nil {
+		return nil, err
+	}
+	return c.orderer.ContactOrder(level)
+}
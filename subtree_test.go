@@ -0,0 +1,192 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubtreeRange(t *testing.T) {
+	var tests = []struct {
+		id, maxLevel, size int
+		min, max           int
+	}{
+		{5, 0, 16, 0, 16},
+		{5, 2, 16, 4, 8},
+		{5, 3, 16, 0, 8},
+		{12, 2, 16, 12, 16},
+		{13, 2, 14, 12, 14},
+		{13, 4, 14, 0, 14},
+		{13, 10, 14, 0, 14},
+		{1000, 9, 4096, 512, 1024},
+	}
+	for i, test := range tests {
+		min, max := SubtreeRange(int32(test.id), test.maxLevel, test.size)
+		require.Equal(t, test.min, min, "test %d", i)
+		require.Equal(t, test.max, max, "test %d", i)
+	}
+	require.Equal(t, 4, maxSubtreeSize(2, 14))
+	require.Equal(t, 14, maxSubtreeSize(0, 14))
+}
+
+func TestCappedPartitioner(t *testing.T) {
+	reg := FakeRegistry(16)
+	c := &Config{MaxAggregationLevel: 2}
+	part := capPartitioner(c, NewBinPartitioner(5, reg, nil), 5, reg.Size())
+	require.Equal(t, 2, part.MaxLevel())
+	require.Equal(t, []int{1, 2}, part.Levels())
+	require.Equal(t, 0, part.Size(3))
+	_, err := part.IdentitiesAt(3)
+	require.Error(t, err)
+	_, err = part.IndexAtLevel(12, 4)
+	require.Error(t, err)
+	_, _, err = part.LevelOf(12)
+	require.Error(t, err)
+	level, pos, err := part.LevelOf(6)
+	require.NoError(t, err)
+	require.Equal(t, 2, level)
+	require.Equal(t, 0, pos)
+	_, err = part.GlobalOf(3, 0)
+	require.Error(t, err)
+
+	// the full signature spans the subtree [4,8[
+	sigs := []*IncomingSig{
+		{level: 0, ms: newSig(bitsOf(1, 0))},
+		{level: 2, ms: newSig(bitsOf(2, 1))},
+	}
+	full := part.CombineFull(sigs, NewWilffBitset)
+	require.Equal(t, 4, full.BitLength())
+	require.Equal(t, bitsOf(4, 1, 3).String(), full.BitSet.String())
+
+	// the contact order of the random partitioners is kept
+	random := NewRandomBinPartitioner(5, reg, nil, []byte("seed"))
+	part = capPartitioner(c, random, 5, reg.Size())
+	orderer, ok := part.(ContactOrderer)
+	require.True(t, ok)
+	order, err := orderer.ContactOrder(2)
+	require.NoError(t, err)
+	expected, _ := random.(ContactOrderer).ContactOrder(2)
+	require.Equal(t, expected, order)
+	_, err = orderer.ContactOrder(3)
+	require.Error(t, err)
+
+	// no cap, or a cap above the levels, leaves the partitioner as is
+	bin := NewBinPartitioner(5, reg, nil)
+	require.Equal(t, bin, capPartitioner(&Config{}, bin, 5, reg.Size()))
+	require.Equal(t, bin, capPartitioner(&Config{MaxAggregationLevel: 4}, bin, 5, reg.Size()))
+}
+
+func TestConfigMaxAggregationLevel(t *testing.T) {
+	c := mergeWithDefault(&Config{MaxAggregationLevel: 2}, 16)
+	require.Equal(t, PercentageToContributions(DefaultContributionsPerc, 4), c.Contributions)
+	require.NoError(t, c.Validate(16))
+	c.Contributions = 5
+	require.Error(t, c.Validate(16))
+	c.Contributions = 3
+	c.BroadcastCompletion = true
+	require.Error(t, c.Validate(16))
+	c.BroadcastCompletion = false
+	c.KeyRotationGrace = time.Second
+	require.Error(t, c.Validate(16))
+	c.KeyRotationGrace = 0
+	c.MaxAggregationLevel = -1
+	require.Error(t, c.Validate(16))
+}
+
+func TestHandelMaxAggregationLevel(t *testing.T) {
+	// the last subtree, [12,14[, is smaller than the others
+	n := 14
+	maxLevel := 2
+	reg := FakeRegistry(n).(*arrayRegistry)
+	ids := reg.ids
+	nets := make([]Network, n)
+	for i := 0; i < n; i++ {
+		nets[i] = &TestNetwork{ids[i].ID(), nets, nil}
+	}
+	// the origins of the packets received by each node
+	var lock sync.Mutex
+	heard := make(map[int32]map[int32]bool)
+	for i := 0; i < n; i++ {
+		id := int32(i)
+		heard[id] = make(map[int32]bool)
+		nets[i].RegisterListener(listenerFunc(func(p *Packet) {
+			lock.Lock()
+			defer lock.Unlock()
+			heard[id][p.Origin] = true
+		}))
+	}
+	conf := &Config{
+		MaxAggregationLevel: maxLevel,
+		NewPartitioner: func(id int32, reg Registry, logger Logger) Partitioner {
+			return NewBinPartitioner(id, reg, DefaultLogger)
+		},
+	}
+	handels := make([]*Handel, n)
+	for i := 0; i < n; i++ {
+		handels[i] = NewHandel(nets[i], reg, ids[i], new(fakeCons), msg, &fakeSig{true}, conf)
+	}
+	defer CloseHandels(handels)
+	for i, h := range handels {
+		levels := []int{1, 2}
+		if i >= 12 {
+			levels = []int{1}
+		}
+		require.Equal(t, levels, h.ids)
+		require.Equal(t, levels, h.Partitioner.Levels())
+	}
+	require.Equal(t, 3, handels[0].threshold)
+	require.Equal(t, 2, handels[13].threshold)
+
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			min, max := SubtreeRange(int32(i), maxLevel, n)
+			require.Equal(t, max-min, ms.BitLength())
+			require.True(t, ms.Cardinality() >= h.threshold)
+			require.NoError(t, VerifySubtreeMultiSignature(msg, &ms, reg, new(fakeCons), int32(i), maxLevel))
+			require.Error(t, VerifyMultiSignature(msg, &ms, reg, new(fakeCons)))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("node %d did not finish", i)
+		}
+	}
+
+	// the nodes only heard of the nodes of their subtree
+	lock.Lock()
+	for id, origins := range heard {
+		min, max := SubtreeRange(id, maxLevel, n)
+		for origin := range origins {
+			require.True(t, int(origin) >= min && int(origin) < max, "%d heard of %d", id, origin)
+		}
+	}
+	lock.Unlock()
+
+	// the packets of the other subtrees are rejected, and the levels above
+	// the cap don't exist
+	h := handels[5]
+	h.NewPacket(aggregatePacket(t, 12, 1, 1, 0))
+	h.NewPacket(aggregatePacket(t, 0, 3, 4, 0))
+	require.Equal(t, 2.0, h.observabilityValues()["rejectedSubtree"])
+	require.Equal(t, 0.0, h.observabilityValues()["rejected"])
+	require.Error(t, h.validatePacket(aggregatePacket(t, 4, 3, 4, 0)))
+}
+
+func TestVerifySubtreeMultiSignature(t *testing.T) {
+	// only the keys of the subtree [4,8[ verify
+	n := 16
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = &fakeIdentity{int32(i), &fakePublic{i >= 4 && i < 8}}
+	}
+	reg := NewArrayRegistry(ids)
+	ms := newSig(bitsOf(4, 0, 2, 3))
+	require.NoError(t, VerifySubtreeMultiSignature(msg, ms, reg, new(fakeCons), 5, 2))
+	require.NoError(t, VerifySubtreeMultiSignature(msg, ms, reg, new(fakeCons), 7, 2))
+	require.Error(t, VerifySubtreeMultiSignature(msg, ms, reg, new(fakeCons), 9, 2))
+	require.Error(t, VerifySubtreeMultiSignature(msg, ms, reg, new(fakeCons), 5, 3))
+	require.Error(t, VerifyMultiSignature(msg, ms, reg, new(fakeCons)))
+}
@@ -4,7 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	mathRand "math/rand"
-	"time"
+	"sync"
 
 	lvl "github.com/go-kit/kit/log/level"
 )
@@ -22,6 +22,8 @@ type Test struct {
 	finished chan int
 	// notifies when the test should be brought down
 	done chan bool
+	// routines started by the test, waited for by Stop
+	wg sync.WaitGroup
 	// complete success channel gets notified when all handel instances have
 	// output a complete multi-signature
 	completeSuccess chan bool
@@ -36,7 +38,7 @@ func NewTest(keys []SecretKey, pubs []PublicKey, c Constructor, msg []byte, conf
 	n := len(keys)
 	ids := make([]Identity, n)
 	sigs := make([]Signature, n)
-	nets := make([]Network, n)
+	nets := NewTestNetworks(n)
 	handels := make([]*Handel, n)
 	var err error
 	for i := 0; i < n; i++ {
@@ -47,7 +49,6 @@ func NewTest(keys []SecretKey, pubs []PublicKey, c Constructor, msg []byte, conf
 		if err != nil {
 			panic(err)
 		}
-		nets[i] = &TestNetwork{id: id, list: nets}
 	}
 	reg := NewArrayRegistry(ids)
 	logger := NewKitLogger(lvl.AllowDebug())
@@ -107,10 +108,19 @@ func (t *Test) Start() {
 			continue
 		}
 		idx := i
-		go handel.Start()
-		go t.waitFinalSig(idx)
+		t.spawn(handel.Start)
+		t.spawn(func() { t.waitFinalSig(idx) })
 	}
-	go t.watchComplete()
+	t.spawn(t.watchComplete)
+}
+
+// spawn runs the function in a routine waited for by Stop.
+func (t *Test) spawn(fn func()) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		fn()
+	}()
 }
 
 func (t *Test) isOffline(nodeID int32) bool {
@@ -122,15 +132,13 @@ func (t *Test) isOffline(nodeID int32) bool {
 	return false
 }
 
-// Stop manually every handel instances
+// Stop manually every handel instances and waits for their routines to
+// return.
 func (t *Test) Stop() {
 	close(t.done)
-	time.Sleep(30 * time.Millisecond)
+	t.wg.Wait()
 	for _, handel := range t.handels {
-		if t.isOffline(handel.id.ID()) {
-			continue
-		}
-		handel.Stop()
+		handel.Close()
 	}
 }
 
@@ -208,7 +216,7 @@ func (t *Test) waitFinalSig(i int) {
 			//fmt.Println("+++++++ ms", ms)
 			/*fmt.Println("+++++++ ms.BitSet ", ms.BitSet)*/
 			if ms.BitSet.Cardinality() >= t.threshold {
-				if err := VerifyMultiSignature(t.msg, &ms, t.reg, t.cons); err != nil {
+				if err := VerifySubtreeMultiSignature(t.msg, &ms, t.reg, t.cons, h.id.ID(), h.c.MaxAggregationLevel); err != nil {
 					fmt.Println(" !!! --- Test verification failed --- !!!")
 				}
 				// one full !
@@ -229,11 +237,26 @@ type TestNetwork struct {
 	lis  []Listener
 }
 
+// NewTestNetworks returns n in-memory networks connected to each other: a
+// packet sent to the identity with ID i is dispatched to the listeners of the
+// i-th network. The tests of this package use it since they can't import
+// handeltest without import cycle; the external tests and applications should
+// use handeltest.Bus instead, which also injects delays and losses.
+func NewTestNetworks(n int) []Network {
+	nets := make([]Network, n)
+	for i := 0; i < n; i++ {
+		nets[i] = &TestNetwork{id: int32(i), list: nets}
+	}
+	return nets
+}
+
 // Send implements the Network interface
 func (f *TestNetwork) Send(ids []Identity, p *Packet) {
+	// the packet is read before returning, the receivers get a copy
+	cp := *p
 	for _, id := range ids {
 		go func(i Identity) {
-			f.list[int(i.ID())].(*TestNetwork).dispatch(p)
+			f.list[int(i.ID())].(*TestNetwork).dispatch(&cp)
 		}(id)
 	}
 }
@@ -1,6 +1,7 @@
 package handel
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -9,9 +10,10 @@ import (
 // starts level according to a linear timeout function: level $i$ starts at time
 // $i * period$. The interface is started and stopped by the Handel main logic.
 type TimeoutStrategy interface {
-	// Called by handel when it starts
+	// Called by handel in its own routine when it starts. It must block until
+	// the strategy is done or stopped, so Handel can track the routine.
 	Start()
-	// // Called by handel when it stops
+	// Called by handel when it stops
 	Stop()
 }
 
@@ -24,6 +26,7 @@ type linearTimeout struct {
 	ticker   *time.Ticker
 	done     chan bool
 	started  bool
+	stopped  bool
 }
 
 // DefaultLevelTimeout is the default level timeout used by the linear timeout
@@ -39,6 +42,11 @@ func NewDefaultLinearTimeout(h *Handel, levels []int) TimeoutStrategy {
 
 // LinearTimeoutConstructor returns the linear timeout contructor as required
 // for the Config.
+//
+// It is not inlined, so the constructors it returns share their code and are
+// all named "linear" by Config.Describe.
+//
+//go:noinline
 func LinearTimeoutConstructor(period time.Duration) func(h *Handel, levels []int) TimeoutStrategy {
 	return func(h *Handel, levels []int) TimeoutStrategy {
 		return NewLinearTimeout(h, levels, period)
@@ -58,19 +66,24 @@ func NewLinearTimeout(h *Handel, levels []int, period time.Duration) TimeoutStra
 
 func (l *linearTimeout) Start() {
 	l.Lock()
-	defer l.Unlock()
+	if l.started || l.stopped {
+		l.Unlock()
+		return
+	}
 	l.started = true
 	l.ticker = time.NewTicker(l.period)
-	go l.linearLevels(l.ticker.C)
+	l.Unlock()
+	l.linearLevels(l.ticker.C)
+	l.ticker.Stop()
 }
 
 func (l *linearTimeout) Stop() {
 	l.Lock()
 	defer l.Unlock()
-	if !l.started {
+	if l.stopped {
 		return
 	}
-	l.ticker.Stop()
+	l.stopped = true
 	close(l.done)
 }
 
@@ -86,3 +99,98 @@ func (l *linearTimeout) linearLevels(c <-chan time.Time) {
 		}
 	}
 }
+
+// LevelActivationSchedule returns the time, since the start of Handel, at
+// which the level of the given index in the levels of Handel, in increasing
+// order, is activated.
+type LevelActivationSchedule func(index, level int) time.Duration
+
+// LinearSchedule returns the schedule of NewLinearTimeout: the level of index
+// i is activated at time i * period.
+func LinearSchedule(period time.Duration) LevelActivationSchedule {
+	return func(index, _ int) time.Duration {
+		return time.Duration(index) * period
+	}
+}
+
+// scheduledTimeout starts each level at the time of its schedule
+type scheduledTimeout struct {
+	sync.Mutex
+	newLevel    func(int)
+	activations []activation
+	done        chan bool
+	started     bool
+	stopped     bool
+}
+
+// activation is the time at which a level is started
+type activation struct {
+	level int
+	at    time.Duration
+}
+
+// ScheduledTimeoutConstructor returns the constructor of the timeout strategy
+// following the schedule, as required for the Config. See ScheduleAdvisor.
+//
+//go:noinline
+func ScheduledTimeoutConstructor(schedule LevelActivationSchedule) func(h *Handel, levels []int) TimeoutStrategy {
+	return func(h *Handel, levels []int) TimeoutStrategy {
+		return NewScheduledTimeout(h, levels, schedule)
+	}
+}
+
+// NewScheduledTimeout returns a TimeoutStrategy that starts each level at the
+// time given by the schedule, since the strategy started. The levels
+// scheduled at the same time start in increasing order.
+func NewScheduledTimeout(h *Handel, levels []int, schedule LevelActivationSchedule) TimeoutStrategy {
+	activations := make([]activation, len(levels))
+	for i, level := range levels {
+		activations[i] = activation{level: level, at: schedule(i, level)}
+	}
+	sort.SliceStable(activations, func(i, j int) bool {
+		return activations[i].at < activations[j].at
+	})
+	return &scheduledTimeout{
+		newLevel:    h.StartLevel,
+		activations: activations,
+		done:        make(chan bool),
+	}
+}
+
+func (s *scheduledTimeout) Start() {
+	s.Lock()
+	if s.started || s.stopped {
+		s.Unlock()
+		return
+	}
+	s.started = true
+	s.Unlock()
+	start := time.Now()
+	for _, a := range s.activations {
+		if wait := a.at - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				return
+			}
+		}
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		s.newLevel(a.level)
+	}
+}
+
+func (s *scheduledTimeout) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	close(s.done)
+}
@@ -0,0 +1,74 @@
+package handel
+
+import "errors"
+
+// errLevelSize is the error of the signatures whose bitset does not have the
+// size of the level of their packet
+var errLevelSize = errors.New("invalid bitset's size for given level")
+
+// tolerableLevel returns true if a packet of the given level, which Handel
+// does not have, may be remapped to an adjacent level - see
+// Config.TolerantLevels. The digests are never remapped.
+func (h *Handel) tolerableLevel(p *Packet) bool {
+	if !h.c.TolerantLevels || p.Flags&FlagDigest != 0 {
+		return false
+	}
+	_, below := h.levels[int(p.Level)-1]
+	_, above := h.levels[int(p.Level)+1]
+	return below || above
+}
+
+// remapLevel returns the level of the signature of the packet whose bitset
+// has the given length, which is not the size of the level of the packet. In
+// the tolerant mode, it is the level of the origin of the packet if this
+// level is adjacent to the level of the packet and has exactly the given
+// size: the bitset is then verified against the identities the origin
+// aggregates. It must be called with the lock held.
+func (h *Handel) remapLevel(p *Packet, length int) (int, error) {
+	if !h.c.TolerantLevels {
+		return 0, errLevelSize
+	}
+	level, _, err := h.Partitioner.LevelOf(int(p.Origin))
+	if err != nil {
+		return 0, errLevelSize
+	}
+	if diff := level - int(p.Level); diff != 1 && diff != -1 {
+		return 0, errLevelSize
+	}
+	if _, exists := h.levels[level]; !exists || h.Partitioner.Size(level) != length {
+		return 0, errLevelSize
+	}
+	if h.remaps == nil {
+		h.remaps = make(map[int32]int)
+	}
+	h.remaps[p.Origin]++
+	h.log.Debug("remapped_from", p.Origin, "rcvd_level", p.Level, "level", level)
+	return level, nil
+}
+
+// LevelRemaps returns the number of signatures remapped to the level of their
+// bitset, per origin - see Config.TolerantLevels.
+func (h *Handel) LevelRemaps() map[int32]int {
+	h.Lock()
+	defer h.Unlock()
+	remaps := make(map[int32]int, len(h.remaps))
+	for origin, n := range h.remaps {
+		remaps[origin] = n
+	}
+	return remaps
+}
+
+// remapValues returns the number of signatures remapped to another level and
+// the number of origins which sent them
+func (h *Handel) remapValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	total := 0
+	for _, n := range h.remaps {
+		total += n
+	}
+	return map[string]float64{
+		"sigs":    float64(total),
+		"origins": float64(len(h.remaps)),
+	}
+}
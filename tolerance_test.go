@@ -0,0 +1,81 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandelTolerantLevels(t *testing.T) {
+	// the levels of the node 1 of 16 are {0}, {2, 3}, {4..7} and {8..15}
+	type levelTest struct {
+		*Packet
+		// level of the signature, 0 if rejected
+		strict, tolerant byte
+	}
+	tests := []levelTest{
+		// the canonical levels are accepted in both modes
+		{withIndividual(aggregatePacket(t, 3, 2, 2)), 2, 2},
+		{aggregatePacket(t, 9, 4, 8), 4, 4},
+		// off by one, above and below
+		{withIndividual(aggregatePacket(t, 3, 3, 2)), 0, 2},
+		{aggregatePacket(t, 5, 2, 4), 0, 3},
+		// off by one, above the highest level of the node
+		{withIndividual(aggregatePacket(t, 9, 5, 8)), 0, 4},
+		// the size of no level
+		{aggregatePacket(t, 3, 3, 5), 0, 0},
+		// off by two
+		{aggregatePacket(t, 3, 4, 2), 0, 0},
+		// the size of an adjacent level, but not the one of the origin
+		{aggregatePacket(t, 9, 3, 2), 0, 0},
+		{aggregatePacket(t, 5, 3, 2), 0, 0},
+		// beyond the adjacent levels
+		{aggregatePacket(t, 9, 6, 8), 0, 0},
+	}
+	for _, tolerant := range []bool{false, true} {
+		_, handels := FakeSetupWith(16, func(c *Config) { c.TolerantLevels = tolerant })
+		h := handels[1]
+		remapped := make(map[int32]int)
+		for i, test := range tests {
+			exp := test.strict
+			if tolerant {
+				exp = test.tolerant
+			}
+			err := h.validatePacket(test.Packet)
+			if err == nil {
+				var ms, ind *IncomingSig
+				ms, ind, err = h.parseSignatures(test.Packet)
+				if err == nil {
+					require.Equal(t, exp, ms.level, "test %d", i)
+					if ind != nil {
+						require.Equal(t, exp, ind.level, "test %d", i)
+						require.Equal(t, int(test.Origin)%2, ind.mappedIndex)
+					}
+					if exp != test.Level {
+						remapped[test.Origin]++
+					}
+					continue
+				}
+			}
+			require.Zero(t, exp, "test %d: %s", i, err)
+		}
+		require.Equal(t, remapped, h.LevelRemaps())
+		CloseHandels(handels)
+	}
+}
+
+func TestHandelTolerantLevelsStore(t *testing.T) {
+	_, handels := FakeSetupWith(16, func(c *Config) {
+		c.TolerantLevels = true
+		c.Logger = &warnLogger{}
+	})
+	defer CloseHandels(handels)
+	h := handels[1]
+	// the contribution of the peer numbering the levels off by one reaches
+	// the store at its level
+	runPacket(h, withIndividual(aggregatePacket(t, 9, 5, 8)))
+	best, ok := h.store.Best(4)
+	require.True(t, ok)
+	require.Equal(t, 8, best.Cardinality())
+	require.Equal(t, 1.0, NewReportHandel(h).Values()["remap_sigs"])
+}
@@ -0,0 +1,190 @@
+package handel
+
+import "sync"
+
+// Tracer creates the spans of the timeline of an aggregation, see
+// Config.Tracer. Handel calls it while holding its global lock, and from the
+// processing routine for the verifications: its methods and the ones of its
+// spans must never block. A Tracer exporting the spans must buffer them and
+// drop them rather than wait.
+type Tracer interface {
+	// Start starts the span of the given name, child of the parent span, or
+	// a root span if the parent is nil.
+	Start(parent Span, name string) Span
+}
+
+// Span is an operation of an aggregation, from its start by a Tracer to End.
+type Span interface {
+	// SetInt sets the integer attribute of the given key
+	SetInt(key string, value int64)
+	// SetString sets the string attribute of the given key
+	SetString(key, value string)
+	// End ends the span. It is called once, and no attribute is set after.
+	End()
+}
+
+// Names of the spans created by Handel. The aggregation span is the root of
+// the others: a level span covers the time from the first send at the level
+// until its contributions are complete or Handel stops, and a verification
+// span, child of the span of its level, the verification of one signature.
+// A final signature span is an instant child of the aggregation span, one for
+// each signature sent over FinalSignatures.
+const (
+	SpanAggregation    = "handel.aggregation"
+	SpanLevel          = "handel.level"
+	SpanVerification   = "handel.verification"
+	SpanFinalSignature = "handel.final_signature"
+)
+
+// tracing holds the spans of an aggregation in progress. It has its own lock,
+// since the verifications are traced from the processing routine. All its
+// methods are no-ops on a nil tracing, i.e. without Config.Tracer.
+type tracing struct {
+	sync.Mutex
+	t    Tracer
+	root Span
+	// spans of the levels started, nil once ended
+	levels map[int]Span
+}
+
+// tracedProcessing is implemented by the processings tracing their
+// verifications
+type tracedProcessing interface {
+	setTracing(t *tracing)
+}
+
+func newTracing(t Tracer) *tracing {
+	if t == nil {
+		return nil
+	}
+	return &tracing{t: t, levels: make(map[int]Span)}
+}
+
+// start starts the aggregation span of the node
+func (t *tracing) start(id int32, threshold, nodes int) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.root = t.t.Start(nil, SpanAggregation)
+	t.root.SetInt("handel.id", int64(id))
+	t.root.SetInt("handel.threshold", int64(threshold))
+	t.root.SetInt("handel.nodes", int64(nodes))
+}
+
+// startLevel starts the span of the level, unless it was started already or
+// the aggregation is not started
+func (t *tracing) startLevel(lvl *level) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.levels[lvl.id]; ok || t.root == nil {
+		return
+	}
+	s := t.t.Start(t.root, SpanLevel)
+	s.SetInt("handel.level", int64(lvl.id))
+	s.SetInt("handel.peers", int64(len(lvl.nodes)))
+	t.levels[lvl.id] = s
+}
+
+// endLevel ends the span of the level, if started and not ended already,
+// with the cardinality of the best signature received at the level
+func (t *tracing) endLevel(lvl *level, rcvd int) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.unsafeEndLevel(lvl, rcvd)
+}
+
+func (t *tracing) unsafeEndLevel(lvl *level, rcvd int) {
+	s := t.levels[lvl.id]
+	if s == nil {
+		return
+	}
+	s.SetInt("handel.sent_cardinality", int64(lvl.sendSigSize))
+	s.SetInt("handel.rcvd_cardinality", int64(rcvd))
+	s.End()
+	t.levels[lvl.id] = nil
+}
+
+// verification starts the span of the verification of the signature, child
+// of the span of its level if in progress, of the aggregation span otherwise.
+// It returns nil if the aggregation is not started.
+func (t *tracing) verification(sp *IncomingSig) Span {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	parent := t.levels[int(sp.level)]
+	if parent == nil {
+		parent = t.root
+	}
+	if parent == nil {
+		return nil
+	}
+	s := t.t.Start(parent, SpanVerification)
+	s.SetInt("handel.level", int64(sp.level))
+	s.SetInt("handel.origin", int64(sp.origin))
+	s.SetInt("handel.cardinality", int64(sp.ms.Cardinality()))
+	if sp.isInd {
+		s.SetInt("handel.individual", 1)
+	} else {
+		s.SetInt("handel.individual", 0)
+	}
+	return s
+}
+
+// verified ends the span of a verification, if any
+func verified(s Span, err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.SetString("handel.error", err.Error())
+	}
+	s.End()
+}
+
+// final records the final signature of the given sequence number
+func (t *tracing) final(ms *MultiSignature, seq uint64) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.root == nil {
+		return
+	}
+	s := t.t.Start(t.root, SpanFinalSignature)
+	s.SetInt("handel.cardinality", int64(ms.Cardinality()))
+	s.SetInt("handel.seq", int64(seq))
+	s.End()
+}
+
+// stopTracing ends the spans of the levels in progress and the aggregation
+// span with the result of the aggregation. The lock of Handel must be held.
+func (h *Handel) stopTracing(r *AggregationResult) {
+	t := h.tracing
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.root == nil {
+		return
+	}
+	for _, lr := range r.Levels {
+		t.unsafeEndLevel(h.levels[lr.Level], lr.Cardinality)
+	}
+	t.root.SetString("handel.outcome", r.Outcome.String())
+	t.root.SetInt("handel.cardinality", int64(r.Cardinality))
+	t.root.SetInt("handel.verified", int64(r.Verified))
+	t.root.End()
+	t.root = nil
+}
@@ -0,0 +1,175 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// spanRecorder is a Tracer keeping all the spans in memory. If ended is set,
+// the spans ended are sent on it, as long as it has room.
+type spanRecorder struct {
+	sync.Mutex
+	roots []*recordedSpan
+	ended chan *recordedSpan
+}
+
+type recordedSpan struct {
+	r        *spanRecorder
+	name     string
+	parent   *recordedSpan
+	children []*recordedSpan
+	ints     map[string]int64
+	strings  map[string]string
+	ended    bool
+}
+
+func (r *spanRecorder) Start(parent Span, name string) Span {
+	r.Lock()
+	defer r.Unlock()
+	s := &recordedSpan{r: r, name: name, ints: make(map[string]int64), strings: make(map[string]string)}
+	if parent == nil {
+		r.roots = append(r.roots, s)
+		return s
+	}
+	s.parent = parent.(*recordedSpan)
+	s.parent.children = append(s.parent.children, s)
+	return s
+}
+
+func (s *recordedSpan) SetInt(key string, value int64) {
+	s.r.Lock()
+	defer s.r.Unlock()
+	if s.ended {
+		panic("attribute set after the end of the span")
+	}
+	s.ints[key] = value
+}
+
+func (s *recordedSpan) SetString(key, value string) {
+	s.r.Lock()
+	defer s.r.Unlock()
+	if s.ended {
+		panic("attribute set after the end of the span")
+	}
+	s.strings[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.r.Lock()
+	defer s.r.Unlock()
+	if s.ended {
+		panic("span ended twice")
+	}
+	s.ended = true
+	if s.r.ended != nil {
+		select {
+		case s.r.ended <- s:
+		default:
+		}
+	}
+}
+
+// named returns the children of the span of the given name
+func (s *recordedSpan) named(name string) []*recordedSpan {
+	var res []*recordedSpan
+	for _, c := range s.children {
+		if c.name == name {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func TestTracingAggregation(t *testing.T) {
+	n := 16
+	rec := new(spanRecorder)
+	_, handels := FakeSetupWith(n, func(c *Config) { c.Tracer = rec })
+	for _, h := range handels {
+		h.Start()
+	}
+	for _, h := range handels {
+		select {
+		case <-h.FinalSignatures():
+		case <-time.After(10 * time.Second):
+			t.Fatal("no final signature")
+		}
+	}
+	CloseHandels(handels)
+
+	rec.Lock()
+	defer rec.Unlock()
+	require.Len(t, rec.roots, n)
+	seen := make(map[int64]bool)
+	for _, root := range rec.roots {
+		id := root.ints["handel.id"]
+		seen[id] = true
+		require.Equal(t, SpanAggregation, root.name)
+		require.True(t, root.ended)
+		require.Equal(t, ThresholdMet.String(), root.strings["handel.outcome"])
+		require.Equal(t, int64(n), root.ints["handel.nodes"])
+		require.True(t, root.ints["handel.cardinality"] >= root.ints["handel.threshold"])
+
+		levels := make(map[int64]*recordedSpan)
+		for _, lvl := range root.named(SpanLevel) {
+			l := lvl.ints["handel.level"]
+			require.Nil(t, levels[l], "node %d: level %d traced twice", id, l)
+			levels[l] = lvl
+			require.True(t, lvl.ended)
+			require.Equal(t, int64(1)<<uint(l-1), lvl.ints["handel.peers"])
+			require.True(t, lvl.ints["handel.rcvd_cardinality"] <= lvl.ints["handel.peers"])
+			for _, v := range lvl.children {
+				require.Equal(t, SpanVerification, v.name)
+				require.True(t, v.ended)
+				require.Equal(t, l, v.ints["handel.level"])
+				require.True(t, v.ints["handel.cardinality"] > 0)
+				require.NotEqual(t, id, v.ints["handel.origin"])
+			}
+		}
+		require.NotEmpty(t, levels)
+
+		finals := root.named(SpanFinalSignature)
+		require.NotEmpty(t, finals)
+		for i, f := range finals {
+			require.Equal(t, int64(i+1), f.ints["handel.seq"])
+			require.True(t, f.ended)
+		}
+		require.Equal(t, root.ints["handel.cardinality"], finals[len(finals)-1].ints["handel.cardinality"])
+	}
+	require.Len(t, seen, n)
+}
+
+func TestTracingVerificationError(t *testing.T) {
+	rec := &spanRecorder{ended: make(chan *recordedSpan, 100)}
+	_, handels := FakeSetupWith(4, func(c *Config) { c.Tracer = rec })
+	defer CloseHandels(handels)
+	h := handels[0]
+	h.Start()
+
+	// an invalid signature of the node 1, at the level 1
+	bs := NewWilffBitset(1)
+	bs.Set(0, true)
+	ms := &MultiSignature{BitSet: bs, Signature: &fakeSig{false}}
+	h.proc.Add(&IncomingSig{origin: 1, level: 1, ms: ms, isInd: true})
+	// the verification spans end once their error is set
+	timeout := time.After(5 * time.Second)
+	var v *recordedSpan
+	for v == nil {
+		select {
+		case s := <-rec.ended:
+			rec.Lock()
+			if s.name == SpanVerification && s.strings["handel.error"] != "" {
+				v = s
+			}
+			rec.Unlock()
+		case <-timeout:
+			t.Fatal("no failed verification traced")
+		}
+	}
+	rec.Lock()
+	defer rec.Unlock()
+	require.Equal(t, int64(1), v.ints["handel.origin"])
+	require.Equal(t, int64(1), v.ints["handel.individual"])
+}
@@ -0,0 +1,146 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"sync"
+)
+
+var errNoTransport = errors.New("handel: not created with a transport, see NewHandelWithTransport")
+
+// Transport is a minimal interface to let Handel send messages over an
+// application's existing networking stack. Contrary to a Network, a Transport
+// only deals with bytes: Handel encodes the packets itself with the Encoding
+// of its Config, and the application hands the incoming messages to
+// Handel.HandleRaw. See NewHandelWithTransport.
+type Transport interface {
+	// Send sends the payload to the given identity. As for a Network, there
+	// are no delivery guarantees required.
+	Send(to Identity, payload []byte) error
+}
+
+// Encoding is the wire format of the Packets sent over a Transport. Nodes must
+// use the same encoding to interoperate.
+type Encoding interface {
+	Encode(*Packet, io.Writer) error
+	Decode(io.Reader) (*Packet, error)
+}
+
+// DefaultEncoding is the default encoding of the Packets over a Transport: it
+// uses gob, and is wire compatible with the gob encoding of package network.
+var DefaultEncoding Encoding = new(gobEncoding)
+
+type gobEncoding struct{}
+
+func (g *gobEncoding) Encode(p *Packet, w io.Writer) error {
+	return gob.NewEncoder(w).Encode(p)
+}
+
+func (g *gobEncoding) Decode(r io.Reader) (*Packet, error) {
+	var p Packet
+	err := gob.NewDecoder(r).Decode(&p)
+	return &p, err
+}
+
+// NewHandelWithTransport returns a Handel that sends its packets over the given
+// Transport instead of a Network. The application must pass every message it
+// receives for Handel to HandleRaw. The other arguments are the same as
+// NewHandel.
+func NewHandelWithTransport(t Transport, r Registry, id Identity, c Constructor,
+	msg []byte, s Signature, conf ...*Config) *Handel {
+	enc := DefaultEncoding
+	if len(conf) > 0 && conf[0] != nil && conf[0].Encoding != nil {
+		enc = conf[0].Encoding
+	}
+	return NewHandel(&transportNetwork{t: t, enc: enc}, r, id, c, msg, s, conf...)
+}
+
+// HandleRaw decodes a message received over the Transport from the node with
+// the given ID and processes the packet it contains. It must only be called on
+// a Handel created by NewHandelWithTransport, and returns an error otherwise
+// or if the payload is not a valid packet of that node.
+func (h *Handel) HandleRaw(from int32, payload []byte) error {
+	if h.raw == nil {
+		return errNoTransport
+	}
+	return h.raw.receive(from, payload)
+}
+
+// transportNetwork implements the Network interface over a Transport, encoding
+// and decoding the packets.
+type transportNetwork struct {
+	sync.Mutex
+	t         Transport
+	enc       Encoding
+	log       Logger
+	listeners []Listener
+	sent      int
+	rcvd      int
+	errors    int
+}
+
+func (tn *transportNetwork) RegisterListener(l Listener) {
+	tn.Lock()
+	defer tn.Unlock()
+	tn.listeners = append(tn.listeners, l)
+}
+
+func (tn *transportNetwork) Send(ids []Identity, p *Packet) {
+	var b bytes.Buffer
+	if err := tn.enc.Encode(p, &b); err != nil {
+		tn.fail("encode", err)
+		return
+	}
+	payload := b.Bytes()
+	for _, id := range ids {
+		if err := tn.t.Send(id, payload); err != nil {
+			tn.fail("send", err)
+			continue
+		}
+		tn.Lock()
+		tn.sent++
+		tn.Unlock()
+	}
+}
+
+func (tn *transportNetwork) receive(from int32, payload []byte) error {
+	p, err := tn.enc.Decode(bytes.NewReader(payload))
+	if err != nil {
+		return tn.fail("decode", err)
+	}
+	if p.Origin != from {
+		return tn.fail("origin", errors.New("packet origin differs from the sender"))
+	}
+	tn.Lock()
+	tn.rcvd++
+	listeners := tn.listeners
+	tn.Unlock()
+	if len(listeners) == 0 {
+		return tn.fail("receive", errors.New("no listener registered"))
+	}
+	for _, l := range listeners {
+		l.NewPacket(p)
+	}
+	return nil
+}
+
+func (tn *transportNetwork) fail(step string, err error) error {
+	tn.Lock()
+	tn.errors++
+	tn.Unlock()
+	tn.log.Warn("transport", step, "err", err)
+	return err
+}
+
+// Values implements the Reporter interface
+func (tn *transportNetwork) Values() map[string]float64 {
+	tn.Lock()
+	defer tn.Unlock()
+	return map[string]float64{
+		"sent":   float64(tn.sent),
+		"rcvd":   float64(tn.rcvd),
+		"errors": float64(tn.errors),
+	}
+}
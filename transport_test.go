@@ -0,0 +1,185 @@
+package handel
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// byteSwitch is an in-memory network delivering bytes between nodes, either
+// running over a Transport or over a Network.
+type byteSwitch struct {
+	sync.Mutex
+	handlers map[int32]func(from int32, payload []byte)
+}
+
+func newByteSwitch() *byteSwitch {
+	return &byteSwitch{handlers: make(map[int32]func(int32, []byte))}
+}
+
+func (s *byteSwitch) register(id int32, handler func(int32, []byte)) {
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[id] = handler
+}
+
+func (s *byteSwitch) deliver(from int32, to Identity, payload []byte) error {
+	s.Lock()
+	handler, ok := s.handlers[to.ID()]
+	s.Unlock()
+	if !ok {
+		return errors.New("unknown node")
+	}
+	cp := make([]byte, len(payload))
+	copy(cp, payload)
+	go handler(from, cp)
+	return nil
+}
+
+// switchTransport implements the Transport interface over the switch
+type switchTransport struct {
+	s  *byteSwitch
+	id int32
+}
+
+func (t *switchTransport) Send(to Identity, payload []byte) error {
+	return t.s.deliver(t.id, to, payload)
+}
+
+// switchNetwork implements the Network interface over the switch, encoding
+// the packets itself like the network implementations do.
+type switchNetwork struct {
+	s   *byteSwitch
+	id  int32
+	enc Encoding
+}
+
+func (n *switchNetwork) RegisterListener(l Listener) {
+	n.s.register(n.id, func(from int32, payload []byte) {
+		p, err := n.enc.Decode(bytes.NewReader(payload))
+		if err != nil {
+			panic(err)
+		}
+		l.NewPacket(p)
+	})
+}
+
+func (n *switchNetwork) Send(ids []Identity, p *Packet) {
+	var b bytes.Buffer
+	if err := n.enc.Encode(p, &b); err != nil {
+		panic(err)
+	}
+	for _, id := range ids {
+		n.s.deliver(n.id, id, b.Bytes())
+	}
+}
+
+// transportSetup returns n handels connected by a byte switch: the nodes for
+// which overNetwork returns true use a Network, the others a Transport.
+func transportSetup(n int, overNetwork func(i int) bool) []*Handel {
+	reg := FakeRegistry(n).(*arrayRegistry)
+	s := newByteSwitch()
+	conf := &Config{NewPartitioner: func(id int32, reg Registry, logger Logger) Partitioner {
+		return NewBinPartitioner(id, reg, DefaultLogger)
+	}}
+	handels := make([]*Handel, n)
+	for i, id := range reg.ids {
+		if overNetwork(i) {
+			net := &switchNetwork{s: s, id: id.ID(), enc: DefaultEncoding}
+			handels[i] = NewHandel(net, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+			continue
+		}
+		h := NewHandelWithTransport(&switchTransport{s, id.ID()}, reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		// the errors are counted by the transport
+		s.register(id.ID(), func(from int32, payload []byte) { h.HandleRaw(from, payload) })
+		handels[i] = h
+	}
+	return handels
+}
+
+func waitFullSignatures(t *testing.T, handels []*Handel) {
+	var wg sync.WaitGroup
+	for _, h := range handels {
+		wg.Add(1)
+		go func(h *Handel) {
+			defer wg.Done()
+			for {
+				select {
+				case ms := <-h.FinalSignatures():
+					if ms.BitSet.Cardinality() == len(handels) {
+						return
+					}
+				case <-time.After(10 * time.Second):
+					t.Error("timeout waiting for full signature")
+					return
+				}
+			}
+		}(h)
+	}
+	for _, h := range handels {
+		go h.Start()
+	}
+	wg.Wait()
+	CloseHandels(handels)
+}
+
+func TestTransportAggregation(t *testing.T) {
+	n := 16
+	handels := transportSetup(n, func(int) bool { return false })
+	waitFullSignatures(t, handels)
+	values := handels[0].raw.Values()
+	require.True(t, values["sent"] > 0)
+	require.True(t, values["rcvd"] > 0)
+	require.Equal(t, 0.0, values["errors"])
+}
+
+func TestTransportInteroperability(t *testing.T) {
+	// half the nodes over a Network, half over a Transport
+	n := 16
+	handels := transportSetup(n, func(i int) bool { return i%2 == 0 })
+	waitFullSignatures(t, handels)
+}
+
+func TestTransportHandleRaw(t *testing.T) {
+	handels := transportSetup(2, func(i int) bool { return i == 0 })
+	// not running over a transport
+	require.Equal(t, errNoTransport, handels[0].HandleRaw(1, []byte{1}))
+
+	h := handels[1]
+	require.Error(t, h.HandleRaw(0, []byte("not a packet")))
+	var b bytes.Buffer
+	require.NoError(t, DefaultEncoding.Encode(&Packet{Origin: 0, Level: 1}, &b))
+	// the origin must match the sender
+	require.Error(t, h.HandleRaw(1, b.Bytes()))
+	values := h.raw.Values()
+	require.Equal(t, 2.0, values["errors"])
+	require.Equal(t, 0.0, values["rcvd"])
+	require.NoError(t, h.HandleRaw(0, b.Bytes()))
+	require.Equal(t, 1.0, h.raw.Values()["rcvd"])
+}
+
+func TestTransportListeners(t *testing.T) {
+	tn := &transportNetwork{enc: DefaultEncoding, log: DefaultLogger}
+	var b bytes.Buffer
+	require.NoError(t, DefaultEncoding.Encode(&Packet{Origin: 1, Level: 1}, &b))
+	// no listener yet: the packet is dropped
+	tn.receive(1, b.Bytes())
+	require.Equal(t, 1.0, tn.Values()["errors"])
+
+	// every listener gets the packets
+	var got []int
+	for i := 0; i < 2; i++ {
+		i := i
+		tn.RegisterListener(ListenFunc(func(p *Packet) {
+			require.Equal(t, int32(1), p.Origin)
+			got = append(got, i)
+		}))
+	}
+	tn.receive(1, b.Bytes())
+	require.Equal(t, []int{0, 1}, got)
+	require.Equal(t, 1.0, tn.Values()["errors"])
+}
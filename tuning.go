@@ -0,0 +1,168 @@
+package handel
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// This file holds the knobs of Handel which can change while it runs, through
+// setters safe to call at any time from any goroutine.
+
+// LogLevel is the lowest level of the statements Handel passes to its Logger,
+// see Config.LogLevel
+type LogLevel int32
+
+const (
+	// LogDebug passes all the statements, the Logger filters them itself
+	LogDebug LogLevel = iota
+	// LogInfo drops the debug statements
+	LogInfo
+	// LogWarn only passes the warnings and the errors
+	LogWarn
+	// LogError only passes the errors
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the LogLevel of the given name: "debug", "info",
+// "warn" or "error"
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("handel: unknown log level %q", name)
+}
+
+// levelLogger drops the statements below its level, which can change at any
+// time. The loggers returned by With share the level.
+type levelLogger struct {
+	Logger
+	level *int32
+}
+
+func newLevelLogger(l Logger, level LogLevel) *levelLogger {
+	lvl := int32(level)
+	return &levelLogger{Logger: l, level: &lvl}
+}
+
+func (l *levelLogger) allows(level LogLevel) bool {
+	return LogLevel(atomic.LoadInt32(l.level)) <= level
+}
+
+func (l *levelLogger) Debug(kv ...interface{}) {
+	if l.allows(LogDebug) {
+		l.Logger.Debug(kv...)
+	}
+}
+
+func (l *levelLogger) Info(kv ...interface{}) {
+	if l.allows(LogInfo) {
+		l.Logger.Info(kv...)
+	}
+}
+
+func (l *levelLogger) Warn(kv ...interface{}) {
+	if l.allows(LogWarn) {
+		l.Logger.Warn(kv...)
+	}
+}
+
+func (l *levelLogger) Error(kv ...interface{}) {
+	if l.allows(LogError) {
+		l.Logger.Error(kv...)
+	}
+}
+
+func (l *levelLogger) With(kv ...interface{}) Logger {
+	return &levelLogger{Logger: l.Logger.With(kv...), level: l.level}
+}
+
+// tuning holds the current values of the knobs which can change at runtime,
+// accessed atomically
+type tuning struct {
+	logger *levelLogger
+	// period of the status line in nanoseconds, the status loop is notified
+	// of its changes through reset
+	statusPeriod int64
+	reset        chan struct{}
+	// capacity of the processing queue
+	queueCapacity int64
+}
+
+func newTuning(c *Config, log Logger) *tuning {
+	return &tuning{
+		logger:        newLevelLogger(log, c.LogLevel),
+		statusPeriod:  int64(c.StatusLogPeriod),
+		reset:         make(chan struct{}, 1),
+		queueCapacity: int64(c.QueueCapacity),
+	}
+}
+
+// capacitySetter is implemented by the processings whose queue capacity can
+// change at runtime
+type capacitySetter interface {
+	setCapacity(capacity int)
+}
+
+// SetLogLevel changes the lowest level of the statements logged by Handel
+// and its processing. The Logger of the config can still filter them further.
+// It is safe to call at any time.
+func (h *Handel) SetLogLevel(l LogLevel) {
+	atomic.StoreInt32(h.tuning.logger.level, int32(l))
+}
+
+// LogLevel returns the current lowest level of the statements logged, see
+// SetLogLevel
+func (h *Handel) LogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(h.tuning.logger.level))
+}
+
+// SetStatusLogPeriod changes the period at which Handel logs its StatusLine,
+// zero disables it. The next status line is logged one period after the
+// change. It is safe to call at any time.
+func (h *Handel) SetStatusLogPeriod(d time.Duration) {
+	atomic.StoreInt64(&h.tuning.statusPeriod, int64(d))
+	select {
+	case h.tuning.reset <- struct{}{}:
+	default:
+		// the status loop is already notified
+	}
+}
+
+// StatusLogPeriod returns the current period of the status line, see
+// SetStatusLogPeriod
+func (h *Handel) StatusLogPeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.tuning.statusPeriod))
+}
+
+// SetQueueCapacity changes the capacity of the processing queue relative to
+// which Handel sheds the incoming packets, zero disables the shedding. It
+// returns false if the processing doesn't support the change. It is safe to
+// call at any time.
+func (h *Handel) SetQueueCapacity(capacity int) bool {
+	p, ok := h.proc.(capacitySetter)
+	if !ok {
+		return false
+	}
+	p.setCapacity(capacity)
+	atomic.StoreInt64(&h.tuning.queueCapacity, int64(capacity))
+	return true
+}
+
+// QueueCapacity returns the current capacity of the processing queue, see
+// SetQueueCapacity
+func (h *Handel) QueueCapacity() int {
+	return int(atomic.LoadInt64(&h.tuning.queueCapacity))
+}
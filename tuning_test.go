@@ -0,0 +1,157 @@
+package handel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// levelsLogger counts the statements logged at each level
+type levelsLogger struct {
+	sync.Mutex
+	counts map[LogLevel]int
+}
+
+func newLevelsLogger() *levelsLogger {
+	return &levelsLogger{counts: make(map[LogLevel]int)}
+}
+
+func (l *levelsLogger) log(level LogLevel) {
+	l.Lock()
+	defer l.Unlock()
+	l.counts[level]++
+}
+
+func (l *levelsLogger) Debug(kv ...interface{})       { l.log(LogDebug) }
+func (l *levelsLogger) Info(kv ...interface{})        { l.log(LogInfo) }
+func (l *levelsLogger) Warn(kv ...interface{})        { l.log(LogWarn) }
+func (l *levelsLogger) Error(kv ...interface{})       { l.log(LogError) }
+func (l *levelsLogger) With(kv ...interface{}) Logger { return l }
+
+func (l *levelsLogger) logged() map[LogLevel]int {
+	l.Lock()
+	defer l.Unlock()
+	counts := make(map[LogLevel]int)
+	for k, v := range l.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+func TestLogLevel(t *testing.T) {
+	for i, name := range []string{"debug", "info", "warn", "error"} {
+		l, err := ParseLogLevel(name)
+		require.NoError(t, err)
+		require.Equal(t, LogLevel(i), l)
+		require.Equal(t, name, l.String())
+	}
+	_, err := ParseLogLevel("verbose")
+	require.Error(t, err)
+
+	_, handels := FakeSetup(4)
+	defer CloseHandels(handels)
+	h := handels[0]
+	recorder := newLevelsLogger()
+	logger := newLevelLogger(recorder, LogInfo)
+	h.tuning.logger = logger
+	// the loggers derived from it share its level
+	derived := logger.With("id", 1)
+	all := func() {
+		for _, l := range []Logger{logger, derived} {
+			l.Debug()
+			l.Info()
+			l.Warn()
+			l.Error()
+		}
+	}
+	all()
+	require.Equal(t, map[LogLevel]int{LogInfo: 2, LogWarn: 2, LogError: 2}, recorder.logged())
+	h.SetLogLevel(LogError)
+	require.Equal(t, LogError, h.LogLevel())
+	all()
+	require.Equal(t, map[LogLevel]int{LogInfo: 2, LogWarn: 2, LogError: 4}, recorder.logged())
+	h.SetLogLevel(LogDebug)
+	all()
+	require.Equal(t, map[LogLevel]int{LogDebug: 2, LogInfo: 4, LogWarn: 4, LogError: 6}, recorder.logged())
+}
+
+func TestSetStatusLogPeriod(t *testing.T) {
+	period := 20 * time.Millisecond
+	_, handels := FakeSetup(16)
+	h := handels[0]
+	logger := newStatusLogger()
+	h.log = logger
+	h.c.StarvationCheck = -1
+	require.Zero(t, h.StatusLogPeriod())
+	h.Start()
+	defer h.Close()
+	require.True(t, logger.quiet(3*period))
+
+	h.SetStatusLogPeriod(period)
+	require.Equal(t, period, h.StatusLogPeriod())
+	logger.waitLines(t, 3)
+
+	h.SetStatusLogPeriod(0)
+	// a tick may race with the change
+	logger.quiet(period)
+	before, _ := logger.logged()
+	require.True(t, logger.quiet(3*period))
+	after, _ := logger.logged()
+	require.Equal(t, len(before), len(after))
+}
+
+func TestSetQueueCapacity(t *testing.T) {
+	_, handels := FakeSetup(16)
+	defer CloseHandels(handels)
+	h := handels[0]
+	proc := h.proc.(*evaluatorProcessing)
+	for i := 0; i < 10; i++ {
+		h.NewPacket(aggregatePacket(t, 4, 3, 4, 2))
+	}
+	require.Zero(t, h.QueueCapacity())
+	require.Zero(t, proc.QueuePressure())
+	require.True(t, h.SetQueueCapacity(20))
+	require.Equal(t, 20, h.QueueCapacity())
+	require.Equal(t, 0.5, proc.QueuePressure())
+	require.Equal(t, shedCompleted, h.shedTier())
+	require.True(t, h.SetQueueCapacity(0))
+	require.Equal(t, shedNone, h.shedTier())
+
+	// the fifo processing has a fixed capacity
+	h.proc = newFifoProcessing(h.store, h.Partitioner, h.cons, h.msg)
+	require.False(t, h.SetQueueCapacity(20))
+	require.Zero(t, h.QueueCapacity())
+}
+
+// TestTuningConcurrent changes the knobs of all the nodes while they
+// aggregate, to run under the race detector
+func TestTuningConcurrent(t *testing.T) {
+	_, handels := FakeSetup(16)
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for _, h := range handels {
+		wg.Add(1)
+		go func(h *Handel) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				h.SetLogLevel(LogLevel(i % 4))
+				h.SetStatusLogPeriod(time.Duration(i%3) * 50 * time.Millisecond)
+				h.SetQueueCapacity(1000 + i%2)
+				h.LogLevel()
+				h.StatusLogPeriod()
+				h.QueueCapacity()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}(h)
+	}
+	waitFullSignatures(t, handels)
+	close(stop)
+	wg.Wait()
+}
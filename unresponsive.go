@@ -0,0 +1,69 @@
+package handel
+
+// unresponsive tracks the peers never heard of despite our contacts, see
+// Config.AvoidUnresponsive. It is guarded by the lock of Handel.
+type unresponsive struct {
+	// contacts after which a silent peer is suspected
+	attempts int
+	// contacts of each peer since its last packet, by global index. Only
+	// the peers of the registry are contacted, so it is bounded by its size.
+	contacts map[int32]int
+	// number of contacts deferred to the end of a rotation
+	deferred int
+}
+
+func newUnresponsive(attempts int) *unresponsive {
+	return &unresponsive{attempts: attempts, contacts: make(map[int32]int)}
+}
+
+// contacted records a contact of the peer
+func (u *unresponsive) contacted(id int32) {
+	u.contacts[id]++
+}
+
+// heard clears the suspicion of the peer, which sent us a packet
+func (u *unresponsive) heard(id int32) {
+	delete(u.contacts, id)
+}
+
+// suspected returns true if the peer was contacted at least attempts times
+// since its last packet
+func (u *unresponsive) suspected(id int32) bool {
+	return u.contacts[id] >= u.attempts
+}
+
+// suspicion returns the function telling if the peer at the given position
+// in the level is suspected, which defers it, or nil if the unresponsive
+// peers are not avoided.
+func (h *Handel) suspicion(l *level) func(pos int) bool {
+	if h.unresponsive == nil {
+		return nil
+	}
+	return func(pos int) bool {
+		if h.unresponsive.suspected(l.nodes[pos].ID()) {
+			h.unresponsive.deferred++
+			return true
+		}
+		return false
+	}
+}
+
+// unresponsiveValues returns the number of peers currently suspected and of
+// contacts deferred
+func (h *Handel) unresponsiveValues() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	if h.unresponsive == nil {
+		return map[string]float64{"suspected": 0, "deferred": 0}
+	}
+	suspected := 0
+	for _, ct := range h.unresponsive.contacts {
+		if ct >= h.unresponsive.attempts {
+			suspected++
+		}
+	}
+	return map[string]float64{
+		"suspected": float64(suspected),
+		"deferred":  float64(h.unresponsive.deferred),
+	}
+}
@@ -0,0 +1,125 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnresponsiveDeadRange contacts the peers of the last level of a node
+// whose first half, the contiguous ids 32 to 47, is offline, rotation after
+// rotation. The live peers answer each contact with a packet.
+func TestUnresponsiveDeadRange(t *testing.T) {
+	n := 64
+	dead := func(id int32) bool { return id >= 32 && id < 48 }
+	// contacts before the first live peer of each rotation, and contacts of
+	// each peer over all the rotations
+	rotations := func(t *testing.T, override func(*Config)) ([]int, map[int32]int) {
+		_, handels := FakeSetupWith(n, func(c *Config) {
+			c.ResendIdenticalAfter = -1
+			override(c)
+		})
+		defer CloseHandels(handels)
+		h := handels[0]
+		lvl := h.getLevel(6)
+		require.Len(t, lvl.nodes, 32)
+		var firsts []int
+		contacts := make(map[int32]int)
+		for r := 0; r < 5; r++ {
+			first := -1
+			for i := 0; i < len(lvl.nodes); i++ {
+				h.Lock()
+				ids := h.selectNextPeers(lvl, 1, nil)
+				h.Unlock()
+				require.Len(t, ids, 1)
+				id := ids[0].ID()
+				contacts[id]++
+				if dead(id) {
+					continue
+				}
+				if first < 0 {
+					first = i
+				}
+				h.NewPacket(aggregatePacket(t, id, 6, 32, int(id)-32))
+			}
+			firsts = append(firsts, first)
+		}
+		values := h.unresponsiveValues()
+		if h.c.AvoidUnresponsive {
+			require.Equal(t, 16.0, values["suspected"])
+			require.NotZero(t, values["deferred"])
+		} else {
+			require.Equal(t, 0.0, values["suspected"])
+		}
+		return firsts, contacts
+	}
+	once := func(t *testing.T, contacts map[int32]int) {
+		// no peer is starved: each one is contacted once per rotation
+		require.Len(t, contacts, 32)
+		for id, ct := range contacts {
+			require.Equal(t, 5, ct, "peer %d", id)
+		}
+	}
+
+	t.Run("baseline", func(t *testing.T) {
+		firsts, contacts := rotations(t, func(c *Config) { c.DisableShuffling = true })
+		require.Equal(t, []int{16, 16, 16, 16, 16}, firsts)
+		once(t, contacts)
+	})
+	t.Run("avoid", func(t *testing.T) {
+		firsts, contacts := rotations(t, func(c *Config) {
+			c.DisableShuffling = true
+			c.AvoidUnresponsive = true
+		})
+		// the dead peers are suspected after two contacts
+		require.Equal(t, []int{16, 16, 0, 0, 0}, firsts)
+		once(t, contacts)
+	})
+	t.Run("random partitioner", func(t *testing.T) {
+		var order []Identity
+		_, handels := FakeSetupWith(n, func(c *Config) {
+			c.AvoidUnresponsive = true
+			c.ResendIdenticalAfter = -1
+			c.NewPartitioner = func(id int32, reg Registry, logger Logger) Partitioner {
+				p := NewRandomBinPartitioner(id, reg, logger, []byte("seed"))
+				if id == 0 {
+					order, _ = p.(ContactOrderer).ContactOrder(6)
+				}
+				return p
+			}
+		})
+		defer CloseHandels(handels)
+		h := handels[0]
+		lvl := h.getLevel(6)
+		require.Equal(t, order, lvl.nodes)
+		for r := 0; r < 3; r++ {
+			var live int
+			for i := 0; i < len(lvl.nodes); i++ {
+				h.Lock()
+				id := h.selectNextPeers(lvl, 1, nil)[0].ID()
+				h.Unlock()
+				if !dead(id) {
+					live++
+					h.NewPacket(aggregatePacket(t, id, 6, 32, int(id)-32))
+				}
+				if r == 2 && i < 16 {
+					// the dead peers come last
+					require.False(t, dead(id))
+				}
+			}
+			require.Equal(t, 16, live)
+		}
+	})
+}
+
+func TestUnresponsiveHeard(t *testing.T) {
+	u := newUnresponsive(2)
+	u.contacted(3)
+	require.False(t, u.suspected(3))
+	u.contacted(3)
+	require.True(t, u.suspected(3))
+	// a packet clears the suspicion
+	u.heard(3)
+	require.False(t, u.suspected(3))
+	require.Empty(t, u.contacts)
+}
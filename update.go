@@ -0,0 +1,177 @@
+package handel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The payloads of the updates sent to the peers of a level, see
+// Config.UpdatePayload
+const (
+	// UpdateCombinedPrefix sends the combination of the best signatures of
+	// all the levels below the level of the peers
+	UpdateCombinedPrefix = "combined-prefix"
+	// UpdateBestLevel sends only the best signature of the level just below
+	// the level of the peers, a smaller packet. The peers have to combine the
+	// signatures of several senders: they may keep a best signature which
+	// overlaps the ones received afterwards, and never complete the level.
+	UpdateBestLevel = "best-level"
+	// UpdateFull sends the best signatures of all the levels with the bitset
+	// of the full signature, in a packet of level FullLevel. The receiver
+	// decomposes it into signatures of its own levels, so it also learns the
+	// signatures of the levels above the one it shares with the sender.
+	UpdateFull = "full"
+)
+
+// FullLevel is the level of the packets of the UpdateFull payload
+const FullLevel byte = 0xff
+
+// updateSig returns the multi-signature sent to the peers of the given
+// level, according to the UpdatePayload: its bitset has the size of the
+// level, or of the registry for UpdateFull. Its bitset can be recycled once
+// sent.
+func (h *Handel) updateSig(lvl int) *MultiSignature {
+	switch h.c.UpdatePayload {
+	case UpdateBestLevel:
+		best, _ := h.store.Best(byte(lvl - 1))
+		if best == nil {
+			break
+		}
+		sig := &IncomingSig{level: byte(lvl - 1), ms: best}
+		return h.Partitioner.Combine([]*IncomingSig{sig}, lvl, h.c.NewBitSet)
+	case UpdateFull:
+		return h.store.FullSignature()
+	}
+	return h.store.Combined(byte(lvl - 1))
+}
+
+// marshalUpdate returns the level and the content of the packet carrying the
+// given multi-signature returned by updateSig
+func (h *Handel) marshalUpdate(lvl int, ms *MultiSignature) (byte, []byte, error) {
+	if h.c.UpdatePayload != UpdateFull {
+		buff, err := h.buffers.marshal(ms)
+		return byte(lvl), buff, err
+	}
+	// the bitset of the full signature followed by the best signature of
+	// each level, without their bitsets
+	var b bytes.Buffer
+	bs, err := ms.BitSet.MarshalBinary()
+	if err != nil {
+		return 0, nil, err
+	}
+	binary.Write(&b, binary.BigEndian, uint16(len(bs)))
+	b.Write(bs)
+	for _, l := range append([]int{0}, h.Partitioner.Levels()...) {
+		best, _ := h.store.Best(byte(l))
+		if best == nil {
+			continue
+		}
+		sig, err := best.Signature.MarshalBinary()
+		if err != nil {
+			return 0, nil, err
+		}
+		b.WriteByte(byte(l))
+		binary.Write(&b, binary.BigEndian, uint16(len(sig)))
+		b.Write(sig)
+	}
+	return FullLevel, b.Bytes(), nil
+}
+
+// parseFull decomposes the content of a packet of level FullLevel into the
+// signatures of our levels. The sender shares with us the level L returned by
+// LevelOf: the signatures of its levels below L combine into a signature of
+// our level L, and its levels above L are also ours. Its level L covers our
+// own levels below L, it can't be decomposed and is ignored. The signatures
+// are not verified.
+func (h *Handel) parseFull(p *Packet) ([]*IncomingSig, error) {
+	shared, _, err := h.Partitioner.LevelOf(int(p.Origin))
+	if err != nil {
+		return nil, err
+	}
+	if shared == 0 {
+		return nil, errors.New("full update from ourself")
+	}
+	buff := bytes.NewBuffer(p.MultiSig)
+	var length uint16
+	if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	bsBuff := buff.Next(int(length))
+	if len(bsBuff) < int(length) {
+		return nil, errors.New("bitset received smaller than expected")
+	}
+	full := h.c.NewBitSet(h.reg.Size())
+	if err := full.UnmarshalBinary(bsBuff); err != nil {
+		return nil, err
+	}
+	defer h.bitsets.Put(full)
+	if full.BitLength() != h.reg.Size() {
+		return nil, errors.New("invalid bitset's size for a full update")
+	}
+
+	// the signatures of the levels of the sender, the ones below the shared
+	// level are combined
+	sigs := make(map[int]Signature)
+	for buff.Len() > 0 {
+		lvl, _ := buff.ReadByte()
+		if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		sigBuff := buff.Next(int(length))
+		if len(sigBuff) < int(length) {
+			return nil, errors.New("signature received smaller than expected")
+		}
+		sig := h.cons.Signature()
+		if err := sig.UnmarshalBinary(sigBuff); err != nil {
+			return nil, err
+		}
+		if int(lvl) < shared {
+			lvl = byte(shared)
+		}
+		if prev, ok := sigs[int(lvl)]; ok {
+			sig = prev.Combine(sig)
+		}
+		sigs[int(lvl)] = sig
+	}
+
+	var incoming []*IncomingSig
+	for _, lvl := range h.Partitioner.Levels() {
+		if lvl < shared {
+			continue
+		}
+		bs := h.c.NewBitSet(h.Partitioner.Size(lvl))
+		for pos := 0; pos < bs.BitLength(); pos++ {
+			global, err := h.Partitioner.GlobalOf(lvl, pos)
+			if err != nil {
+				return nil, err
+			}
+			bs.Set(pos, full.Get(global))
+		}
+		sig, ok := sigs[lvl]
+		switch {
+		case bs.None() && !ok:
+			continue
+		case bs.None() || !ok:
+			return nil, fmt.Errorf("level %d: bitset and signature don't match", lvl)
+		}
+		incoming = append(incoming, &IncomingSig{
+			origin: p.Origin,
+			level:  byte(lvl),
+			ms:     &MultiSignature{BitSet: bs, Signature: sig},
+		})
+	}
+	return incoming, nil
+}
+
+// updateValues returns the number of packets sent and their total size, and
+// the number of updates of each outcome
+func (h *Handel) updateValues() map[string]float64 {
+	values := h.updateOutcomeValues()
+	h.Lock()
+	defer h.Unlock()
+	values["packets"] = float64(h.stats.msgSentCt)
+	values["bytes"] = float64(h.stats.bytesSent)
+	return values
+}
@@ -0,0 +1,255 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setBits returns the indexes of the bits set in the bitset
+func setBits(bs BitSet) []int {
+	var bits []int
+	for i := 0; i < bs.BitLength(); i++ {
+		if bs.Get(i) {
+			bits = append(bits, i)
+		}
+	}
+	return bits
+}
+
+func TestUpdatePayload(t *testing.T) {
+	n := 16
+	var tests = []struct {
+		payload string
+		// set bits of the signatures received by the node 5, by level
+		exp map[int][]int
+	}{
+		// the combination of the levels 0, 1 and 2 of the node 0
+		{UpdateCombinedPrefix, map[int][]int{3: {0, 1, 2, 3}}},
+		// the level 2 of the node 0
+		{UpdateBestLevel, map[int][]int{3: {2, 3}}},
+		// the combination of the levels 0, 1 and 2, and the level 4 of the
+		// node 0, the level 3 of the node 0 is the level 0 to 2 of the node 5
+		{UpdateFull, map[int][]int{3: {0, 1, 2, 3}, 4: {2}}},
+	}
+	for i, test := range tests {
+		t.Logf(" -- test %d -- ", i)
+		_, handels := FakeSetup(n)
+		sender, receiver := handels[0], handels[5]
+		sender.c.UpdatePayload = test.payload
+		receiver.c.UpdatePayload = test.payload
+		sender.store.Store(fullIncomingSig(1))
+		sender.store.Store(fullIncomingSig(2))
+		sender.store.Store(fullIncomingSig(3))
+		// the node 10
+		sender.store.Store(individualSig(4, 8, 2))
+
+		// the node 5 is at the level 3 of the node 0 and conversely
+		ms := sender.updateSig(3)
+		level, buff, err := sender.marshalUpdate(3, ms)
+		require.NoError(t, err)
+		p := &Packet{Origin: 0, Level: level, MultiSig: buff}
+		require.NoError(t, receiver.validatePacket(p))
+
+		var sigs []*IncomingSig
+		if test.payload == UpdateFull {
+			require.Equal(t, FullLevel, level)
+			sigs, err = receiver.parseFull(p)
+		} else {
+			require.Equal(t, byte(3), level)
+			var sig *IncomingSig
+			sig, _, err = receiver.parseSignatures(p)
+			sigs = []*IncomingSig{sig}
+		}
+		require.NoError(t, err)
+		got := make(map[int][]int)
+		for _, s := range sigs {
+			require.Equal(t, int32(0), s.origin)
+			require.Equal(t, receiver.Partitioner.Size(int(s.level)), s.ms.BitLength())
+			got[int(s.level)] = setBits(s.ms.BitSet)
+		}
+		require.Equal(t, test.exp, got)
+		CloseHandels(handels)
+	}
+}
+
+// TestUpdateFullDecomposition checks the signatures decomposed from a full
+// update by each node are the ones of the store of the sender
+func TestUpdateFullDecomposition(t *testing.T) {
+	n := 13
+	_, handels := FakeSetup(n)
+	defer CloseHandels(handels)
+	sender := handels[0]
+	for _, lvl := range sender.Partitioner.Levels() {
+		bs := NewWilffBitset(sender.Partitioner.Size(lvl))
+		for pos := 0; pos < bs.BitLength(); pos += 2 {
+			bs.Set(pos, true)
+		}
+		sender.store.Store(&IncomingSig{level: byte(lvl), ms: newSig(bs)})
+	}
+	for _, h := range handels {
+		h.c.UpdatePayload = UpdateFull
+	}
+	ms := sender.updateSig(1)
+	level, buff, err := sender.marshalUpdate(1, ms)
+	require.NoError(t, err)
+	p := &Packet{Origin: 0, Level: level, MultiSig: buff}
+	for id := 1; id < n; id++ {
+		t.Logf(" -- node %d -- ", id)
+		receiver := handels[id]
+		shared, _, _ := receiver.Partitioner.LevelOf(0)
+		sigs, err := receiver.parseFull(p)
+		require.NoError(t, err)
+		for _, s := range sigs {
+			var exp *MultiSignature
+			if int(s.level) == shared {
+				// the prefix the sender sends at this level
+				exp = sender.store.Combined(s.level - 1)
+			} else {
+				exp, _ = sender.store.Best(s.level)
+			}
+			require.Equal(t, setBits(exp.BitSet), setBits(s.ms.BitSet), "level %d", s.level)
+		}
+		// the shared level and the levels above it with a signature
+		var exp int
+		for _, lvl := range receiver.Partitioner.Levels() {
+			if lvl >= shared {
+				exp++
+			}
+		}
+		require.Len(t, sigs, exp)
+	}
+}
+
+func TestUpdateFullInvalid(t *testing.T) {
+	_, handels := FakeSetup(8)
+	defer CloseHandels(handels)
+	receiver := handels[1]
+	// not accepted by the other payloads
+	p := &Packet{Origin: 0, Level: FullLevel}
+	require.Error(t, receiver.validatePacket(p))
+	receiver.c.UpdatePayload = UpdateFull
+	require.NoError(t, receiver.validatePacket(p))
+
+	bs, _ := finalBitset(8).MarshalBinary()
+	sig, _ := new(fakeSig).MarshalBinary()
+	header := append([]byte{0, byte(len(bs))}, bs...)
+	var tests = [][]byte{
+		nil,
+		// bits without signatures
+		header,
+		// truncated signature
+		append(append([]byte{}, header...), 0, 0, 5),
+	}
+	for i, payload := range tests {
+		t.Logf(" -- test %d -- ", i)
+		_, err := receiver.parseFull(&Packet{Origin: 0, Level: FullLevel, MultiSig: payload})
+		require.Error(t, err)
+	}
+	_, err := receiver.parseFull(&Packet{Origin: 1, Level: FullLevel, MultiSig: append(append(header, 0, 0, byte(len(sig))), sig...)})
+	require.Error(t, err)
+}
+
+// TestUpdatePayloadAggregation runs an aggregation with each payload. Only
+// the threshold is awaited: with the best-level payload, a node can keep a
+// best signature which overlaps the signatures it receives afterwards
+// without reaching the full signature.
+func TestUpdatePayloadAggregation(t *testing.T) {
+	for i, payload := range []string{UpdateCombinedPrefix, UpdateBestLevel, UpdateFull} {
+		t.Logf(" -- test %d -- ", i)
+		_, handels := FakeSetup(16)
+		for _, h := range handels {
+			h.c.UpdatePayload = payload
+		}
+		for _, h := range handels {
+			go h.Start()
+		}
+		for _, h := range handels {
+			select {
+			case ms := <-h.FinalSignatures():
+				require.True(t, ms.Cardinality() >= h.threshold)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timeout waiting for node %d", payload, h.id.ID())
+			}
+		}
+		CloseHandels(handels)
+		values := NewReportHandel(handels[0]).Values()
+		require.True(t, values["update_packets"] > 0)
+		require.True(t, values["update_bytes"] > values["update_packets"])
+	}
+}
+
+func TestLevelUpdateCount(t *testing.T) {
+	count := ProportionalUpdateCount(0.3, 2)
+	require.Equal(t, 2, count(1, 1))
+	require.Equal(t, 2, count(3, 4))
+	require.Equal(t, 3, count(4, 8))
+	require.Equal(t, 39, count(8, 128))
+
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	conf := &Config{
+		UpdateCount:          1,
+		ResendIdenticalAfter: -1,
+		LevelUpdateCount: func(level, size int) int {
+			if level == 2 {
+				// UpdateCount
+				return 0
+			}
+			return size / 2
+		},
+	}
+	h := NewHandel(newSendsNetwork(false), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+	require.Equal(t, "custom", h.c.Describe()["LevelUpdateCount"])
+	for _, level := range h.ids {
+		h.StartLevel(level)
+	}
+	h.periodicUpdate()
+	for level, exp := range map[int]int{1: 1, 2: 1, 3: 2, 4: 4} {
+		require.Equal(t, exp, lastUpdate(t, h, level).Peers, "level %d", level)
+	}
+
+	h.c.LevelUpdateCount = ProportionalUpdateCount(1, 1)
+	require.Equal(t, "proportional", h.c.Describe()["LevelUpdateCount"])
+	h.Lock()
+	h.levels[4].rearm()
+	h.Unlock()
+	h.periodicUpdate()
+	require.Equal(t, 8, lastUpdate(t, h, 4).Peers)
+}
+
+func TestUpdateOnImprovement(t *testing.T) {
+	n := 16
+	reg := FakeRegistry(n)
+	id, _ := reg.Identity(1)
+	for _, onImprovement := range []bool{false, true} {
+		conf := &Config{UpdateCount: 1, UpdateOnImprovement: onImprovement}
+		h := NewHandel(newSendsNetwork(false), reg, id, new(fakeCons), msg, &fakeSig{true}, conf)
+		h.StartLevel(4)
+		require.Len(t, h.RecentUpdates(4), 1)
+
+		// a contribution of the level 2 improves the signatures of the
+		// levels 3 and 4, without completing them: only the started level
+		// 4 is sent again at once
+		sp := &IncomingSig{origin: 2, level: 2, ms: newSig(bitsOf(2, 1)), isInd: true}
+		h.Lock()
+		h.store.Store(sp)
+		h.checkCompletedLevel(sp)
+		h.Unlock()
+		require.Empty(t, h.RecentUpdates(3))
+		if !onImprovement {
+			require.Len(t, h.RecentUpdates(4), 1)
+			continue
+		}
+		require.Len(t, h.RecentUpdates(4), 2)
+		require.Equal(t, UpdateSent, lastUpdate(t, h, 4).Outcome)
+
+		// nor again while it does not improve
+		h.Lock()
+		h.checkCompletedLevel(sp)
+		h.Unlock()
+		require.Len(t, h.RecentUpdates(4), 2)
+	}
+}
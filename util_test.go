@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -133,8 +135,8 @@ func fullSig(level int) *MultiSignature {
 	return newSig(fullBitset(level))
 }
 
-func fullIncomingSig(level int) *incomingSig {
-	return &incomingSig{
+func fullIncomingSig(level int) *IncomingSig {
+	return &IncomingSig{
 		level: byte(level),
 		ms:    fullSig(level),
 	}
@@ -150,33 +152,39 @@ func finalBitset(size int) BitSet {
 
 // returns a final signature pair associated with a given level but with a full
 // size bitset ( n )
-func finalIncomingSig(level, size int) *incomingSig {
-	return &incomingSig{
+func finalIncomingSig(level, size int) *IncomingSig {
+	return &IncomingSig{
 		level: byte(level),
 		ms:    newSig(finalBitset(size)),
 	}
 }
 
-func mkIncomingSig(level int) *incomingSig {
-	return &incomingSig{
+func mkIncomingSig(level int) *IncomingSig {
+	return &IncomingSig{
 		level: byte(level),
 		ms:    fullSig(level),
 	}
 }
 
-func incomingSigs(lvls ...int) []*incomingSig {
-	s := make([]*incomingSig, len(lvls))
+func incomingSigs(lvls ...int) []*IncomingSig {
+	s := make([]*IncomingSig, len(lvls))
 	for i, lvl := range lvls {
 		s[i] = mkIncomingSig(lvl)
 	}
 	return s
 }
 
-func sigs(sigs ...*incomingSig) []*incomingSig {
+func sigs(sigs ...*IncomingSig) []*IncomingSig {
 	return sigs
 }
 
 func FakeSetup(n int) (Registry, []*Handel) {
+	return FakeSetupWith(n, nil)
+}
+
+// FakeSetupWith is like FakeSetup, with the config given to the override, if
+// not nil, before creating the nodes
+func FakeSetupWith(n int, override func(c *Config)) (Registry, []*Handel) {
 	reg := FakeRegistry(n).(*arrayRegistry)
 	ids := reg.ids
 	nets := make([]Network, n)
@@ -189,6 +197,9 @@ func FakeSetup(n int) (Registry, []*Handel) {
 		return NewBinPartitioner(id, reg, DefaultLogger)
 	}
 	conf := &Config{NewPartitioner: newPartitioner}
+	if override != nil {
+		override(conf)
+	}
 	for i := 0; i < n; i++ {
 		handels[i] = NewHandel(nets[i], reg, ids[i], cons, msg, &fakeSig{true}, conf)
 	}
@@ -209,10 +220,199 @@ func ChanListener(ch chan *Packet) Listener {
 
 func CloseHandels(hs []*Handel) {
 	for _, h := range hs {
-		h.Stop()
+		h.Close()
+	}
+}
+
+// fakeClock is a clock moved by the tests, for the tests of the timings of
+// Handel which would otherwise sleep
+type fakeClock struct {
+	sync.Mutex
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1000000, 0)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// use makes the Handel and its processing use the clock. It must be called
+// before Start.
+func (c *fakeClock) use(h *Handel) {
+	h.now = c.now
+	if p, ok := h.proc.(*evaluatorProcessing); ok {
+		p.now = c.now
+	}
+}
+
+// bitsOf returns a bitset of the given size with the given bits set
+func bitsOf(size int, bits ...int) BitSet {
+	bs := NewWilffBitset(size)
+	for _, b := range bits {
+		bs.Set(b, true)
+	}
+	return bs
+}
+
+// aggregatePacket returns a packet of the given level carrying an aggregate of
+// the given size with the given bits set, or all of them if none is given
+func aggregatePacket(t *testing.T, origin int32, level, size int, bits ...int) *Packet {
+	bs := finalBitset(size)
+	if len(bits) > 0 {
+		bs = bitsOf(size, bits...)
+	}
+	buff, err := newSig(bs).MarshalBinary()
+	require.NoError(t, err)
+	return &Packet{Origin: origin, Level: byte(level), MultiSig: buff}
+}
+
+// withIndividual adds a valid individual signature to the packet
+func withIndividual(p *Packet) *Packet {
+	p.IndividualSig, _ = (&fakeSig{true}).MarshalBinary()
+	return p
+}
+
+// withAggregation marks the packet as part of the aggregation of the message
+func withAggregation(p *Packet, msg []byte) *Packet {
+	p.Aggregation = AggregationID(msg)
+	return p
+}
+
+// clonePacket returns a deep copy of the packet
+func clonePacket(p *Packet) *Packet {
+	return &Packet{
+		Origin:        p.Origin,
+		Level:         p.Level,
+		Flags:         p.Flags,
+		Progress:      p.Progress,
+		Aggregation:   p.Aggregation,
+		MultiSig:      append([]byte(nil), p.MultiSig...),
+		IndividualSig: append([]byte(nil), p.IndividualSig...),
+		PreviousKeys:  append([]byte(nil), p.PreviousKeys...),
+		Signature:     append([]byte(nil), p.Signature...),
+	}
+}
+
+// sentPacket is a packet recorded by a recordNetwork, with its recipient and
+// the time of the clock of the network
+type sentPacket struct {
+	at time.Time
+	to int32
+	p  *Packet
+}
+
+// recordNetwork is the Network of the tests recording a copy of the packets
+// sent along with their recipients. The packets are then passed to send if
+// set, as a faulty peer delaying or altering them would, or else to the
+// wrapped Network if any.
+type recordNetwork struct {
+	Network
+	sync.Mutex
+	// times the packets sent if set
+	clock *fakeClock
+	send  func(ids []Identity, p *Packet)
+	// the calls to Send
+	calls []recordedSend
+}
+
+type recordedSend struct {
+	at  time.Time
+	ids []Identity
+	p   *Packet
+}
+
+func (n *recordNetwork) RegisterListener(l Listener) {
+	if n.Network != nil {
+		n.Network.RegisterListener(l)
 	}
 }
 
+func (n *recordNetwork) Send(ids []Identity, p *Packet) {
+	n.Lock()
+	var at time.Time
+	if n.clock != nil {
+		at = n.clock.now()
+	}
+	n.calls = append(n.calls, recordedSend{
+		at:  at,
+		ids: append([]Identity(nil), ids...),
+		p:   clonePacket(p),
+	})
+	n.Unlock()
+	if n.send != nil {
+		n.send(ids, p)
+	} else if n.Network != nil {
+		n.Network.Send(ids, p)
+	}
+}
+
+// to returns the packets sent to the given peers, once per recipient, or to
+// all the peers if none is given
+func (n *recordNetwork) to(peers ...int32) []sentPacket {
+	n.Lock()
+	defer n.Unlock()
+	var sent []sentPacket
+	for _, c := range n.calls {
+		for _, id := range c.ids {
+			for _, peer := range peers {
+				if id.ID() == peer {
+					sent = append(sent, sentPacket{at: c.at, to: id.ID(), p: c.p})
+				}
+			}
+			if len(peers) == 0 {
+				sent = append(sent, sentPacket{at: c.at, to: id.ID(), p: c.p})
+			}
+		}
+	}
+	return sent
+}
+
+// packets returns the packets sent, once per call to Send
+func (n *recordNetwork) packets() []*Packet {
+	n.Lock()
+	defer n.Unlock()
+	var packets []*Packet
+	for _, c := range n.calls {
+		packets = append(packets, c.p)
+	}
+	return packets
+}
+
+// recipients returns the identities the packets were sent to, as given to
+// Send
+func (n *recordNetwork) recipients() []Identity {
+	n.Lock()
+	defer n.Unlock()
+	var ids []Identity
+	for _, c := range n.calls {
+		ids = append(ids, c.ids...)
+	}
+	return ids
+}
+
+// first returns the first packet sent at the level, nil if none
+func (n *recordNetwork) first(level byte) *Packet {
+	n.Lock()
+	defer n.Unlock()
+	for _, c := range n.calls {
+		if c.p.Level == level {
+			return c.p
+		}
+	}
+	return nil
+}
+
 func TestUtilShuffle(t *testing.T) {
 
 	n := 10